@@ -0,0 +1,130 @@
+package pail
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// bandwidthLimiter is a token bucket that limits the aggregate rate at which
+// bytes are transferred. It is safe for concurrent use, so a single limiter
+// can be shared across workers.
+type bandwidthLimiter struct {
+	mu             sync.Mutex
+	bytesPerSecond int64
+	burst          int64
+	tokens         float64
+	last           time.Time
+}
+
+// newBandwidthLimiter returns a limiter that allows bytesPerSecond bytes to be
+// transferred per second. The bucket starts empty so that the limit also
+// holds over short transfers.
+func newBandwidthLimiter(bytesPerSecond int64) *bandwidthLimiter {
+	// Allow bursts of a tenth of a second of transfer so that individual
+	// reads are not too small.
+	burst := bytesPerSecond / 10
+	if burst < 1 {
+		burst = 1
+	}
+
+	return &bandwidthLimiter{
+		bytesPerSecond: bytesPerSecond,
+		burst:          burst,
+		last:           time.Now(),
+	}
+}
+
+// wait blocks until n bytes, which must not exceed the limiter's burst, may be
+// transferred, or until the context is done.
+func (l *bandwidthLimiter) wait(ctx context.Context, n int64) error {
+	for {
+		l.mu.Lock()
+		now := time.Now()
+		l.tokens += now.Sub(l.last).Seconds() * float64(l.bytesPerSecond)
+		if l.tokens > float64(l.burst) {
+			l.tokens = float64(l.burst)
+		}
+		l.last = now
+		if l.tokens >= float64(n) {
+			l.tokens -= float64(n)
+			l.mu.Unlock()
+			return nil
+		}
+		delay := time.Duration((float64(n) - l.tokens) / float64(l.bytesPerSecond) * float64(time.Second))
+		l.mu.Unlock()
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.WithStack(ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+// throttledReader is a reader whose reads are limited by a bandwidth limiter.
+type throttledReader struct {
+	ctx     context.Context
+	r       io.Reader
+	limiter *bandwidthLimiter
+}
+
+func newThrottledReader(ctx context.Context, r io.Reader, limiter *bandwidthLimiter) *throttledReader {
+	return &throttledReader{ctx: ctx, r: r, limiter: limiter}
+}
+
+func (r *throttledReader) Read(p []byte) (int, error) {
+	if int64(len(p)) > r.limiter.burst {
+		p = p[:r.limiter.burst]
+	}
+
+	// Wait for the bytes actually read before returning them so that
+	// short reads do not consume extra bandwidth.
+	n, err := r.r.Read(p)
+	if n > 0 {
+		if waitErr := r.limiter.wait(r.ctx, int64(n)); waitErr != nil {
+			return 0, waitErr
+		}
+	}
+
+	return n, err
+}
+
+// bandwidthLimiterKey is the context key of the bandwidth limiter that
+// throttles the uploads made with the context.
+type bandwidthLimiterKey struct{}
+
+// withBandwidthLimiter returns a context whose uploads are throttled by the
+// limiter.
+func withBandwidthLimiter(ctx context.Context, limiter *bandwidthLimiter) context.Context {
+	return context.WithValue(ctx, bandwidthLimiterKey{}, limiter)
+}
+
+// throttledFile is a file whose reads are limited by a bandwidth limiter.
+type throttledFile struct {
+	*throttledReader
+	f *os.File
+}
+
+func (f *throttledFile) Close() error { return f.f.Close() }
+
+// openUploadFile opens the file at the path to upload it. If the context has a
+// bandwidth limiter, reads from the file are throttled by it.
+func openUploadFile(ctx context.Context, path string) (io.ReadCloser, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	limiter, ok := ctx.Value(bandwidthLimiterKey{}).(*bandwidthLimiter)
+	if !ok {
+		return f, nil
+	}
+
+	return &throttledFile{throttledReader: newThrottledReader(ctx, f, limiter), f: f}, nil
+}
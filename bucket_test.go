@@ -2,10 +2,15 @@ package pail
 
 import (
 	"archive/tar"
+	"archive/zip"
 	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"net/url"
 	"os"
 	"path/filepath"
 	"runtime"
@@ -19,8 +24,10 @@ import (
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
 )
 
 type bucketTestCase struct {
@@ -83,6 +90,129 @@ func TestBucket(t *testing.T) {
 				},
 			},
 		},
+		{
+			name: "GridFSWithDatabase",
+			constructor: func(t *testing.T) Bucket {
+				b, err := NewGridFSBucketWithDatabase(client.Database(dbName), GridFSOptions{
+					Name:   testutil.NewUUID(),
+					Prefix: testutil.NewUUID(),
+				})
+				require.NoError(t, err)
+				return b
+			},
+			tests: []bucketTestCase{
+				{
+					id: "UsesProvidedDatabase",
+					test: func(t *testing.T, b Bucket) {
+						bucket, ok := b.(*gridfsBucket)
+						require.True(t, ok)
+						assert.Equal(t, client, bucket.client)
+						assert.Equal(t, dbName, bucket.database().Name())
+
+						require.NoError(t, writeDataToFile(ctx, b, "key", "hello"))
+						data, err := readDataFromFile(ctx, b, "key")
+						require.NoError(t, err)
+						assert.Equal(t, "hello", data)
+
+						count, err := client.Database(dbName).Collection(bucket.opts.Name+".files").CountDocuments(ctx, bson.M{})
+						require.NoError(t, err)
+						assert.EqualValues(t, 1, count)
+					},
+				},
+				{
+					id: "RejectsMismatchedDatabaseOption",
+					test: func(t *testing.T, _ Bucket) {
+						_, err := NewGridFSBucketWithDatabase(client.Database(dbName), GridFSOptions{Name: "bucket", Database: "other"})
+						assert.Error(t, err)
+					},
+				},
+				{
+					id: "ReadsAfterWriteFromPrimaryByDefault",
+					test: func(t *testing.T, _ Bucket) {
+						db := client.Database(dbName, options.Database().SetReadPreference(readpref.SecondaryPreferred()))
+						b, err := NewGridFSBucketWithDatabase(db, GridFSOptions{Name: testutil.NewUUID()})
+						require.NoError(t, err)
+						require.NoError(t, writeDataToFile(ctx, b, "key", "hello"))
+						data, err := readDataFromFile(ctx, b, "key")
+						require.NoError(t, err)
+						assert.Equal(t, "hello", data)
+					},
+				},
+				{
+					id: "ReadsAfterWriteWithRetryNotFound",
+					test: func(t *testing.T, _ Bucket) {
+						db := client.Database(dbName, options.Database().SetReadPreference(readpref.SecondaryPreferred()))
+						b, err := NewGridFSBucketWithDatabase(db, GridFSOptions{
+							Name:            testutil.NewUUID(),
+							ReadConsistency: GridFSReadRetryNotFound,
+						})
+						require.NoError(t, err)
+						require.NoError(t, writeDataToFile(ctx, b, "key", "hello"))
+						data, err := readDataFromFile(ctx, b, "key")
+						require.NoError(t, err)
+						assert.Equal(t, "hello", data)
+
+						start := time.Now()
+						_, err = b.Get(ctx, "nonexistent")
+						require.Error(t, err)
+						assert.True(t, IsKeyNotFoundError(err))
+						assert.True(t, time.Since(start) >= 700*time.Millisecond, "should retry with backoff before giving up")
+					},
+				},
+				{
+					id: "RejectsInvalidReadConsistency",
+					test: func(t *testing.T, _ Bucket) {
+						_, err := NewGridFSBucketWithDatabase(client.Database(dbName), GridFSOptions{Name: "bucket", ReadConsistency: "eventual"})
+						assert.Error(t, err)
+						_, err = NewGridFSBucketWithDatabase(client.Database(dbName), GridFSOptions{Name: "bucket", ReadConsistency: GridFSReadRetryNotFound, ReadNotFoundRetries: -1})
+						assert.Error(t, err)
+					},
+				},
+				{
+					id: "BucketsWithDifferentNamesDoNotCollide",
+					test: func(t *testing.T, _ Bucket) {
+						db := client.Database(dbName)
+						first, err := NewGridFSBucketWithDatabase(db, GridFSOptions{Name: testutil.NewUUID()})
+						require.NoError(t, err)
+						second, err := NewGridFSBucketWithDatabase(db, GridFSOptions{Name: testutil.NewUUID()})
+						require.NoError(t, err)
+
+						require.NoError(t, writeDataToFile(ctx, first, "key", "first"))
+						exists, err := second.Exists(ctx, "key")
+						require.NoError(t, err)
+						assert.False(t, exists)
+						require.NoError(t, writeDataToFile(ctx, second, "key", "second"))
+						require.NoError(t, writeDataToFile(ctx, second, "other", "second"))
+
+						data, err := readDataFromFile(ctx, first, "key")
+						require.NoError(t, err)
+						assert.Equal(t, "first", data)
+						data, err = readDataFromFile(ctx, second, "key")
+						require.NoError(t, err)
+						assert.Equal(t, "second", data)
+						assert.Equal(t, []string{"key"}, listNames(ctx, t, first, ""))
+
+						require.NoError(t, second.Remove(ctx, "key"))
+						exists, err = first.Exists(ctx, "key")
+						require.NoError(t, err)
+						assert.True(t, exists)
+					},
+				},
+				{
+					id: "DefaultsToFSCollections",
+					test: func(t *testing.T, _ Bucket) {
+						db := client.Database(dbName)
+						b, err := NewGridFSBucketWithDatabase(db, GridFSOptions{Prefix: testutil.NewUUID()})
+						require.NoError(t, err)
+						require.NoError(t, writeDataToFile(ctx, b, "key", "data"))
+
+						count, err := db.Collection("fs.files").CountDocuments(ctx, bson.M{})
+						require.NoError(t, err)
+						assert.NotZero(t, count)
+					},
+				},
+			},
+		},
 		{
 			name: "Local",
 			constructor: func(t *testing.T) Bucket {
@@ -212,6 +342,14 @@ func TestBucket(t *testing.T) {
 				return &localFileSystem{path: path, prefix: testutil.NewUUID(), useSlash: true}
 			},
 		},
+		{
+			name: "Memory",
+			constructor: func(t *testing.T) Bucket {
+				b, err := NewMemoryBucket(MemoryBucketOptions{Name: "memory", Prefix: testutil.NewUUID()})
+				require.NoError(t, err)
+				return b
+			},
+		},
 		{
 			name: "S3Bucket",
 			constructor: func(t *testing.T) Bucket {
@@ -331,6 +469,11 @@ func TestBucket(t *testing.T) {
 			t.Run("CheckIsValid", func(t *testing.T) {
 				assert.NoError(t, impl.constructor(t).Check(ctx))
 			})
+			t.Run("PingReturnsLatency", func(t *testing.T) {
+				latency, err := Ping(ctx, impl.constructor(t))
+				require.NoError(t, err)
+				assert.True(t, latency >= 0)
+			})
 			t.Run("ListIsEmpty", func(t *testing.T) {
 				bucket := impl.constructor(t)
 				iter, err := bucket.List(ctx, "")
@@ -579,6 +722,47 @@ func TestBucket(t *testing.T) {
 				require.NoError(t, err)
 				assert.Equal(t, "hello world!", string(data))
 			})
+			t.Run("GetLimitedEnforcesSizeLimit", func(t *testing.T) {
+				bucket := impl.constructor(t)
+				limitedBucket, ok := bucket.(LimitedBucket)
+				if !ok {
+					t.Skip("bucket does not support limited gets")
+				}
+				key := testutil.NewUUID()
+				assert.NoError(t, writeDataToFile(ctx, bucket, key, "hello world!"))
+
+				reader, err := limitedBucket.GetLimited(ctx, key, int64(len("hello world!")))
+				require.NoError(t, err)
+				data, err := ioutil.ReadAll(reader)
+				require.NoError(t, err)
+				assert.Equal(t, "hello world!", string(data))
+				require.NoError(t, reader.Close())
+
+				_, err = limitedBucket.GetLimited(ctx, key, 5)
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, ErrObjectTooLarge))
+
+				_, err = limitedBucket.GetLimited(ctx, testutil.NewUUID(), 5)
+				require.Error(t, err)
+				assert.True(t, IsKeyNotFoundError(err))
+			})
+			t.Run("MissingKeyErrorsMatchErrNotExist", func(t *testing.T) {
+				bucket := impl.constructor(t)
+				key := testutil.NewUUID()
+
+				_, err := bucket.Get(ctx, key)
+				assert.True(t, errors.Is(err, ErrNotExist), "get: %v", err)
+				_, err = bucket.Reader(ctx, key)
+				assert.True(t, errors.Is(err, ErrNotExist), "reader: %v", err)
+				err = bucket.Download(ctx, key, filepath.Join(t.TempDir(), "file"))
+				assert.True(t, errors.Is(err, ErrNotExist), "download: %v", err)
+				assert.True(t, IsKeyNotFound(err))
+
+				require.NoError(t, writeDataToFile(ctx, bucket, key, "data"))
+				r, err := bucket.Get(ctx, key)
+				require.NoError(t, err)
+				require.NoError(t, r.Close())
+			})
 			t.Run("PutSavesFiles", func(t *testing.T) {
 				const contents = "check data"
 				bucket := impl.constructor(t)
@@ -601,6 +785,24 @@ func TestBucket(t *testing.T) {
 				_, err := bucket.Get(ctx, key)
 				assert.Error(t, err)
 			})
+			t.Run("WriterWithChecksumComputesChecksum", func(t *testing.T) {
+				bucket := impl.constructor(t)
+				key := testutil.NewUUID()
+				payload := strings.Repeat("checksummed payload ", 1000)
+
+				w, err := WriterWithChecksum(ctx, bucket, key)
+				require.NoError(t, err)
+				_, err = io.Copy(w, strings.NewReader(payload))
+				require.NoError(t, err)
+				assert.Empty(t, w.Checksum())
+				require.NoError(t, w.Close())
+
+				sum := sha256.Sum256([]byte(payload))
+				assert.Equal(t, hex.EncodeToString(sum[:]), w.Checksum())
+				data, err := readDataFromFile(ctx, bucket, key)
+				require.NoError(t, err)
+				assert.Equal(t, payload, data)
+			})
 			t.Run("CopyDuplicatesData", func(t *testing.T) {
 				const contents = "this one"
 				bucket := impl.constructor(t)
@@ -617,6 +819,31 @@ func TestBucket(t *testing.T) {
 				require.NoError(t, err)
 				assert.Equal(t, contents, data)
 			})
+			t.Run("CopyIfNotExistsDoesNotOverwriteDestination", func(t *testing.T) {
+				bucket := impl.constructor(t)
+				keyOne := testutil.NewUUID()
+				keyTwo := testutil.NewUUID()
+				require.NoError(t, writeDataToFile(ctx, bucket, keyOne, "new"))
+				require.NoError(t, writeDataToFile(ctx, bucket, keyTwo, "old"))
+				options := CopyOptions{
+					SourceKey:         keyOne,
+					DestinationKey:    keyTwo,
+					DestinationBucket: bucket,
+					IfNotExists:       true,
+				}
+				err := bucket.Copy(ctx, options)
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, ErrAlreadyExists))
+				data, err := readDataFromFile(ctx, bucket, keyTwo)
+				require.NoError(t, err)
+				assert.Equal(t, "old", data)
+
+				options.DestinationKey = testutil.NewUUID()
+				require.NoError(t, bucket.Copy(ctx, options))
+				data, err = readDataFromFile(ctx, bucket, options.DestinationKey)
+				require.NoError(t, err)
+				assert.Equal(t, "new", data)
+			})
 			t.Run("CopyDoesNotDuplicateDataToDryRunBucket", func(t *testing.T) {
 				const contents = "this one"
 				bucket := impl.constructor(t)
@@ -1065,6 +1292,162 @@ func TestBucket(t *testing.T) {
 					setDeleteOnSync(bucket, false)
 				})
 			})
+			t.Run("TouchUpdatesLastModified", func(t *testing.T) {
+				bucket := impl.constructor(t)
+				toucher, ok := bucket.(TouchBucket)
+				if !ok {
+					t.Skip("bucket does not support touching objects")
+				}
+				require.NoError(t, writeDataToFile(ctx, bucket, "marker", "marker data"))
+				before := getLastModified(ctx, t, bucket, "marker")
+
+				time.Sleep(10 * time.Millisecond)
+				require.NoError(t, toucher.Touch(ctx, "marker"))
+				assert.True(t, getLastModified(ctx, t, bucket, "marker").After(before))
+				data, err := readDataFromFile(ctx, bucket, "marker")
+				require.NoError(t, err)
+				assert.Equal(t, "marker data", data)
+
+				err = toucher.Touch(ctx, "missing")
+				require.Error(t, err)
+				assert.True(t, IsKeyNotFoundError(err))
+			})
+			t.Run("ReaderSeekerReadsFromEnd", func(t *testing.T) {
+				bucket := impl.constructor(t)
+				seeker, ok := bucket.(SeekableBucket)
+				if !ok {
+					t.Skip("bucket does not support seeking")
+				}
+				require.NoError(t, writeDataToFile(ctx, bucket, "archive", "header...trailer"))
+
+				r, err := seeker.ReaderSeeker(ctx, "archive")
+				require.NoError(t, err)
+				defer r.Close()
+
+				pos, err := r.Seek(-7, io.SeekEnd)
+				require.NoError(t, err)
+				assert.EqualValues(t, 9, pos)
+				trailer, err := io.ReadAll(r)
+				require.NoError(t, err)
+				assert.Equal(t, "trailer", string(trailer))
+
+				_, err = r.Seek(0, io.SeekStart)
+				require.NoError(t, err)
+				header := make([]byte, 6)
+				_, err = io.ReadFull(r, header)
+				require.NoError(t, err)
+				assert.Equal(t, "header", string(header))
+
+				_, err = seeker.ReaderSeeker(ctx, "missing")
+				require.Error(t, err)
+				assert.True(t, IsKeyNotFoundError(err))
+			})
+			t.Run("GetRangeReadsByteRange", func(t *testing.T) {
+				bucket := impl.constructor(t)
+				ranger, ok := bucket.(RangeBucket)
+				if !ok {
+					t.Skip("bucket does not support range reads")
+				}
+				require.NoError(t, writeDataToFile(ctx, bucket, "archive", "header...trailer"))
+				readRange := func(offset, length int64) (string, error) {
+					r, err := ranger.GetRange(ctx, "archive", offset, length)
+					if err != nil {
+						return "", err
+					}
+					defer r.Close()
+					data, err := io.ReadAll(r)
+					return string(data), err
+				}
+
+				data, err := readRange(0, 6)
+				require.NoError(t, err)
+				assert.Equal(t, "header", data)
+				data, err = readRange(6, 3)
+				require.NoError(t, err)
+				assert.Equal(t, "...", data)
+				data, err = readRange(9, 100)
+				require.NoError(t, err)
+				assert.Equal(t, "trailer", data)
+
+				_, err = readRange(16, 1)
+				assert.True(t, errors.Is(err, ErrRangeNotSatisfiable))
+				_, err = readRange(-1, 1)
+				assert.Error(t, err)
+				_, err = readRange(0, 0)
+				assert.Error(t, err)
+				_, err = ranger.GetRange(ctx, "missing", 0, 1)
+				require.Error(t, err)
+				assert.True(t, IsKeyNotFoundError(err))
+			})
+			t.Run("ListWithStartAfterExcludesEarlierKeys", func(t *testing.T) {
+				bucket := impl.constructor(t)
+				for _, key := range []string{"logs/a", "logs/b", "logs/c", "logs/d", "other/e"} {
+					require.NoError(t, writeDataToFile(ctx, bucket, key, "data"))
+				}
+
+				iter, err := ListWithOptions(ctx, bucket, "logs", ListOptions{StartAfter: "logs/b"})
+				require.NoError(t, err)
+				var keys []string
+				for iter.Next(ctx) {
+					keys = append(keys, iter.Item().Name())
+				}
+				require.NoError(t, iter.Err())
+				assert.ElementsMatch(t, []string{"logs/c", "logs/d"}, keys)
+
+				iter, err = ListWithOptions(ctx, bucket, "logs", ListOptions{})
+				require.NoError(t, err)
+				count, err := CountItems(ctx, iter)
+				require.NoError(t, err)
+				assert.Equal(t, 4, count)
+			})
+			t.Run("ListPrefixesMergesAndDeduplicates", func(t *testing.T) {
+				bucket := impl.constructor(t)
+				for _, key := range []string{"logs/a", "logs/task/b", "logs/task/c", "artifacts/d", "other/e"} {
+					require.NoError(t, writeDataToFile(ctx, bucket, key, "data"))
+				}
+
+				iter, err := ListPrefixes(ctx, bucket, []string{"logs/task", "logs", "artifacts"})
+				require.NoError(t, err)
+				var keys []string
+				for iter.Next(ctx) {
+					keys = append(keys, iter.Item().Name())
+				}
+				require.NoError(t, iter.Err())
+				assert.Len(t, keys, 4)
+				assert.ElementsMatch(t, []string{"logs/a", "logs/task/b", "logs/task/c", "artifacts/d"}, keys)
+				assert.ElementsMatch(t, []string{"logs/task/b", "logs/task/c"}, keys[:2], "items should be grouped by the first matching prefix")
+			})
+			t.Run("ListYieldsKeysInStrictOrder", func(t *testing.T) {
+				bucket := impl.constructor(t)
+				keys := orderTestKeys()
+				for _, key := range keys {
+					require.NoError(t, writeDataToFile(ctx, bucket, key, "data"))
+				}
+
+				assertKeysInStrictOrder(ctx, t, bucket, "list", len(keys))
+			})
+			t.Run("ZeroByteObjectsRoundTrip", func(t *testing.T) {
+				bucket := impl.constructor(t)
+				dir := t.TempDir()
+				require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "empty"), nil, 0600))
+
+				require.NoError(t, bucket.Put(ctx, "put", bytes.NewReader(nil)))
+				require.NoError(t, bucket.Upload(ctx, "upload", filepath.Join(dir, "empty")))
+				require.NoError(t, bucket.Push(ctx, SyncOptions{Local: dir, Remote: "push"}))
+
+				for _, key := range []string{"put", "upload", "push/empty"} {
+					exists, err := bucket.Exists(ctx, key)
+					require.NoError(t, err)
+					require.True(t, exists, "zero-byte object '%s' should be uploaded", key)
+
+					r, err := bucket.Get(ctx, key)
+					require.NoError(t, err)
+					data, err := ioutil.ReadAll(r)
+					require.NoError(t, err)
+					require.NoError(t, r.Close())
+					assert.Empty(t, data)
+				}
+			})
 			t.Run("UploadWithBadFileName", func(t *testing.T) {
 				bucket := impl.constructor(t)
 				err := bucket.Upload(ctx, "key", "foo\x00bar")
@@ -1113,6 +1496,209 @@ func TestBucket(t *testing.T) {
 					require.NoError(t, err)
 					assert.True(t, exists)
 				})
+				t.Run("ManyKeys", func(t *testing.T) {
+					exists, err := ExistsMany(ctx, bucket, []string{"key0", "DNE0", "key1", "DNE1"}, 2)
+					require.NoError(t, err)
+					assert.Equal(t, map[string]bool{
+						"key0": true,
+						"key1": true,
+						"DNE0": false,
+						"DNE1": false,
+					}, exists)
+				})
+			})
+			t.Run("ListReportsLastModified", func(t *testing.T) {
+				bucket := impl.constructor(t)
+				require.NoError(t, writeDataToFile(ctx, bucket, "key0", "data for key0"))
+				require.NoError(t, writeDataToFile(ctx, bucket, "key1", "data for key1"))
+
+				iter, err := bucket.List(ctx, "")
+				require.NoError(t, err)
+				count := 0
+				for iter.Next(ctx) {
+					assert.WithinDuration(t, time.Now(), itemLastModified(iter.Item()), time.Hour)
+					count++
+				}
+				require.NoError(t, iter.Err())
+				assert.Equal(t, 2, count)
+			})
+			t.Run("CountsItemsWithPrefix", func(t *testing.T) {
+				bucket := impl.constructor(t)
+				for i := 0; i < 5; i++ {
+					require.NoError(t, writeDataToFile(ctx, bucket, fmt.Sprintf("logs/log%d", i), "log data"))
+				}
+				require.NoError(t, writeDataToFile(ctx, bucket, "other", "other data"))
+
+				count, err := Count(ctx, bucket, "logs")
+				require.NoError(t, err)
+				assert.Equal(t, 5, count)
+
+				iter, err := bucket.List(ctx, "")
+				require.NoError(t, err)
+				count, err = CountItems(ctx, iter)
+				require.NoError(t, err)
+				assert.Equal(t, 6, count)
+			})
+			t.Run("GetZipReaderArchivesPrefix", func(t *testing.T) {
+				bucket := impl.constructor(t)
+				expected := map[string]string{
+					"a.txt":        "a data",
+					"nested/b.txt": strings.Repeat("b data", 1024),
+					"empty.txt":    "",
+				}
+				for name, data := range expected {
+					require.NoError(t, writeDataToFile(ctx, bucket, bucket.Join("logs", name), data))
+				}
+				require.NoError(t, writeDataToFile(ctx, bucket, "other.txt", "other data"))
+
+				r, err := GetZipReader(ctx, bucket, "logs")
+				require.NoError(t, err)
+				data, err := io.ReadAll(r)
+				require.NoError(t, err)
+				require.NoError(t, r.Close())
+
+				archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+				require.NoError(t, err)
+				actual := map[string]string{}
+				for _, file := range archive.File {
+					assert.NotZero(t, file.Flags&0x8, "entry '%s' should use a data descriptor", file.Name)
+					rc, err := file.Open()
+					require.NoError(t, err)
+					contents, err := io.ReadAll(rc)
+					require.NoError(t, err)
+					require.NoError(t, rc.Close())
+					actual[file.Name] = string(contents)
+				}
+				assert.Equal(t, expected, actual)
+			})
+			t.Run("ListKeysReturnsSortedKeys", func(t *testing.T) {
+				bucket := impl.constructor(t)
+				for _, key := range []string{"logs/c", "logs/a/b", "logs/b", "logs/a/a", "other"} {
+					require.NoError(t, writeDataToFile(ctx, bucket, key, "data"))
+				}
+
+				keys, err := ListKeys(ctx, bucket, "logs")
+				require.NoError(t, err)
+				assert.Equal(t, []string{"logs/a/a", "logs/a/b", "logs/b", "logs/c"}, keys)
+
+				iter, err := bucket.List(ctx, "logs")
+				require.NoError(t, err)
+				var listed []string
+				for iter.Next(ctx) {
+					listed = append(listed, iter.Item().Name())
+				}
+				require.NoError(t, iter.Err())
+				assert.ElementsMatch(t, listed, keys)
+			})
+			t.Run("CanceledWriterLeavesNoObject", func(t *testing.T) {
+				bucket := impl.constructor(t)
+				wctx, wcancel := context.WithCancel(ctx)
+				w, err := bucket.Writer(wctx, "canceled")
+				require.NoError(t, err)
+				_, err = w.Write([]byte("partial data"))
+				require.NoError(t, err)
+
+				wcancel()
+				_, err = w.Write([]byte("more data"))
+				assert.True(t, errors.Is(err, context.Canceled), "%v", err)
+				err = w.Close()
+				assert.True(t, errors.Is(err, context.Canceled), "%v", err)
+
+				exists, err := bucket.Exists(ctx, "canceled")
+				require.NoError(t, err)
+				assert.False(t, exists)
+			})
+			t.Run("CanceledWriterKeepsExistingObject", func(t *testing.T) {
+				bucket := impl.constructor(t)
+				require.NoError(t, writeDataToFile(ctx, bucket, "existing", "original"))
+
+				wctx, wcancel := context.WithCancel(ctx)
+				w, err := bucket.Writer(wctx, "existing")
+				require.NoError(t, err)
+				_, err = w.Write([]byte("partial data"))
+				require.NoError(t, err)
+				wcancel()
+				assert.Error(t, w.Close())
+
+				data, err := readDataFromFile(ctx, bucket, "existing")
+				require.NoError(t, err)
+				assert.Equal(t, "original", data)
+			})
+			t.Run("DiffPrefixReportsEachCategory", func(t *testing.T) {
+				bucket := impl.constructor(t)
+				src := map[string]string{"same": "data", "changed": "data", "nested/only-src": "data"}
+				dst := map[string]string{"same": "data", "changed": "longer data", "only-dst": "data"}
+				for key, data := range src {
+					require.NoError(t, writeDataToFile(ctx, bucket, bucket.Join("src", key), data))
+				}
+				for key, data := range dst {
+					require.NoError(t, writeDataToFile(ctx, bucket, bucket.Join("dst", key), data))
+				}
+
+				diff, err := DiffPrefix(ctx, bucket, bucket, "src", "dst")
+				require.NoError(t, err)
+				assert.Equal(t, []string{"nested/only-src"}, diff.OnlyInSource)
+				assert.Equal(t, []string{"only-dst"}, diff.OnlyInDestination)
+				assert.Equal(t, []string{"changed"}, diff.Different)
+
+				local, err := NewLocalBucket(LocalOptions{Path: t.TempDir()})
+				require.NoError(t, err)
+				for key, data := range dst {
+					require.NoError(t, writeDataToFile(ctx, local, local.Join("dst", key), data))
+				}
+				diff, err = DiffPrefix(ctx, bucket, local, "src", "dst")
+				require.NoError(t, err)
+				assert.Equal(t, []string{"nested/only-src"}, diff.OnlyInSource)
+				assert.Equal(t, []string{"only-dst"}, diff.OnlyInDestination)
+				assert.Equal(t, []string{"changed"}, diff.Different)
+			})
+			t.Run("PutManyUploadsEachPayload", func(t *testing.T) {
+				bucket := impl.constructor(t)
+				objects := map[string][]byte{}
+				for i := 0; i < 20; i++ {
+					objects[bucket.Join("batch", fmt.Sprintf("key%d", i))] = []byte(fmt.Sprintf("payload %d", i))
+				}
+
+				require.NoError(t, PutMany(ctx, bucket, objects, 4))
+				for key, payload := range objects {
+					data, err := readDataFromFile(ctx, bucket, key)
+					require.NoError(t, err)
+					assert.Equal(t, string(payload), data)
+				}
+			})
+			t.Run("ListMatchesPrefixesAndListDirListsDirectories", func(t *testing.T) {
+				bucket := impl.constructor(t)
+				for _, key := range listSemanticsKeys {
+					require.NoError(t, writeDataToFile(ctx, bucket, key, "data"))
+				}
+				assertListPrefixSemantics(ctx, t, bucket)
+			})
+			t.Run("ConcatToWriterJoinsPartsInKeyOrder", func(t *testing.T) {
+				bucket := impl.constructor(t)
+				whole := &bytes.Buffer{}
+				for i := 0; i < 12; i++ {
+					whole.WriteString(strings.Repeat(fmt.Sprintf("chunk %d;", i), i+1))
+				}
+				data := whole.Bytes()
+				var parts []string
+				for i := 0; len(data) > 0; i++ {
+					size := 37
+					if size > len(data) {
+						size = len(data)
+					}
+					parts = append(parts, string(data[:size]))
+					data = data[size:]
+				}
+				// Write the parts out of order so that the order of
+				// the output only depends on the keys.
+				for i := len(parts) - 1; i >= 0; i-- {
+					require.NoError(t, writeDataToFile(ctx, bucket, bucket.Join("chunked", fmt.Sprintf("part-%05d", i+1)), parts[i]))
+				}
+				require.NoError(t, writeDataToFile(ctx, bucket, bucket.Join("other", "part-00000"), "not a part"))
+
+				out := &bytes.Buffer{}
+				require.NoError(t, ConcatToWriter(ctx, bucket, "chunked", out))
+				assert.Equal(t, whole.String(), out.String())
 			})
 		})
 	}
@@ -1517,6 +2103,8 @@ func setDryRun(b Bucket, set bool) {
 		i.opts.DryRun = set
 	case *localFileSystem:
 		i.dryRun = set
+	case *memoryBucket:
+		i.opts.DryRun = set
 	case *s3BucketSmall:
 		i.dryRun = set
 	case *s3BucketLarge:
@@ -1535,6 +2123,9 @@ func setDeleteOnSync(b Bucket, set bool) {
 	case *localFileSystem:
 		i.deleteOnPush = set
 		i.deleteOnPull = set
+	case *memoryBucket:
+		i.opts.DeleteOnPush = set
+		i.opts.DeleteOnPull = set
 	case *s3BucketSmall:
 		i.deleteOnPush = set
 		i.deleteOnPull = set
@@ -1651,3 +2242,495 @@ func TestGetHeadObject(t *testing.T) {
 		assert.NotNil(t, headObject)
 	})
 }
+
+// offlineListBuckets returns constructors for buckets of each backend that
+// can be tested without external services, with S3 listings paged so that
+// listings span several pages.
+func offlineListBuckets() []struct {
+	name        string
+	constructor func(*testing.T) Bucket
+} {
+	newLocal := func(t *testing.T, useSlash bool) Bucket {
+		b, err := NewLocalBucket(LocalOptions{Path: t.TempDir(), UseSlash: useSlash})
+		require.NoError(t, err)
+		return b
+	}
+	newPagedS3 := func() *s3Bucket {
+		client := newMockS3Client()
+		client.listPageSize = 3
+		return newMockS3Bucket(client, "prefix")
+	}
+
+	return []struct {
+		name        string
+		constructor func(*testing.T) Bucket
+	}{
+		{
+			name:        "Local",
+			constructor: func(t *testing.T) Bucket { return newLocal(t, false) },
+		},
+		{
+			name:        "LocalSlashSeparator",
+			constructor: func(t *testing.T) Bucket { return newLocal(t, true) },
+		},
+		{
+			name:        "S3Small",
+			constructor: func(*testing.T) Bucket { return &s3BucketSmall{s3Bucket: *newPagedS3()} },
+		},
+		{
+			name:        "S3Large",
+			constructor: func(*testing.T) Bucket { return &s3BucketLarge{s3Bucket: *newPagedS3(), minPartSize: 1024} },
+		},
+		{
+			name: "Memory",
+			constructor: func(t *testing.T) Bucket {
+				b, err := NewMemoryBucket(MemoryBucketOptions{Prefix: "prefix"})
+				require.NoError(t, err)
+				return b
+			},
+		},
+		{
+			name:        "GCS",
+			constructor: func(*testing.T) Bucket { return newMockGCSBucket(newMockGCSClient(), GCSOptions{Prefix: "prefix"}) },
+		},
+		{
+			name: "Sharded",
+			constructor: func(t *testing.T) Bucket {
+				shards := []Bucket{
+					newLocal(t, false),
+					&s3BucketSmall{s3Bucket: *newPagedS3()},
+					newLocal(t, false),
+				}
+				return NewShardedBucket(shards, func(key string) int { return len(key) })
+			},
+		},
+	}
+}
+
+func TestListKeyOrder(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, impl := range offlineListBuckets() {
+		t.Run(impl.name, func(t *testing.T) {
+			b := impl.constructor(t)
+			keys := orderTestKeys()
+			for _, key := range keys {
+				require.NoError(t, writeDataToFile(ctx, b, key, "data"))
+			}
+
+			assertKeysInStrictOrder(ctx, t, b, "list", len(keys))
+		})
+	}
+}
+
+func TestUploadWithResult(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b, err := NewLocalBucket(LocalOptions{Path: t.TempDir()})
+	require.NoError(t, err)
+	path := filepath.Join(t.TempDir(), "file")
+	require.NoError(t, os.WriteFile(path, []byte("abc"), 0600))
+
+	for i := 0; i < 2; i++ {
+		result, err := UploadWithResult(ctx, b, "key", path)
+		require.NoError(t, err)
+		assert.Equal(t, UploadResult{Uploaded: true, BytesTransferred: 3}, result)
+	}
+	data, err := readDataFromFile(ctx, b, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "abc", data)
+
+	_, err = UploadWithResult(ctx, b, "missing", filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestPushMirror(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	impls := append(offlineListBuckets(), struct {
+		name        string
+		constructor func(*testing.T) Bucket
+	}{
+		name: "ParallelLocal",
+		constructor: func(t *testing.T) Bucket {
+			local, err := NewLocalBucket(LocalOptions{Path: t.TempDir()})
+			require.NoError(t, err)
+			b, err := NewParallelSyncBucket(ParallelBucketOptions{Workers: 2}, local)
+			require.NoError(t, err)
+			return b
+		},
+	})
+	for _, impl := range impls {
+		t.Run(impl.name, func(t *testing.T) {
+			b := impl.constructor(t)
+			local := t.TempDir()
+			for _, name := range []string{"a", "b", filepath.Join("dir", "c"), "excluded"} {
+				require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(local, name)), 0700))
+				require.NoError(t, os.WriteFile(filepath.Join(local, name), []byte("data"), 0600))
+			}
+			require.NoError(t, writeDataToFile(ctx, b, "other/key", "data"))
+			require.NoError(t, writeDataToFile(ctx, b, "remote-sibling", "data"))
+
+			opts := SyncOptions{Local: local, Remote: "remote", Mirror: true}
+			require.NoError(t, b.Push(ctx, opts))
+			assert.Equal(t, []string{"remote/a", "remote/b", "remote/dir/c", "remote/excluded"}, listNames(ctx, t, b, "remote/"))
+
+			require.NoError(t, os.Remove(filepath.Join(local, "a")))
+			require.NoError(t, b.Push(ctx, SyncOptions{Local: local, Remote: "remote"}))
+			assert.Equal(t, []string{"remote/a", "remote/b", "remote/dir/c", "remote/excluded"}, listNames(ctx, t, b, "remote/"), "only mirrors should delete")
+
+			require.NoError(t, os.Remove(filepath.Join(local, "dir", "c")))
+			opts.Exclude = "excluded"
+			require.NoError(t, b.Push(ctx, opts))
+			assert.Equal(t, []string{"remote/b", "remote/excluded"}, listNames(ctx, t, b, "remote/"))
+			assert.Equal(t, []string{"other/key"}, listNames(ctx, t, b, "other/"))
+			assert.Equal(t, []string{"remote-sibling"}, listNames(ctx, t, b, "remote-"))
+
+			localFiles, err := walkLocalTree(ctx, local)
+			require.NoError(t, err)
+			assert.Equal(t, []string{"b", "excluded"}, localFiles, "mirrors should not delete local files")
+		})
+	}
+}
+
+func TestRemovePrefix(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, impl := range offlineListBuckets() {
+		t.Run(impl.name, func(t *testing.T) {
+			b := impl.constructor(t)
+			for _, key := range listSemanticsKeys {
+				require.NoError(t, writeDataToFile(ctx, b, key, "data"))
+			}
+
+			require.NoError(t, b.RemovePrefix(ctx, "foo/b"))
+			assert.Equal(t, []string{"foo/a", "foobar", "other"}, listNames(ctx, t, b, ""))
+			require.NoError(t, b.RemovePrefix(ctx, "foo/"))
+			assert.Equal(t, []string{"foobar", "other"}, listNames(ctx, t, b, ""))
+			require.NoError(t, b.RemovePrefix(ctx, "missing"))
+			require.NoError(t, b.RemovePrefix(ctx, "foo"))
+			assert.Equal(t, []string{"other"}, listNames(ctx, t, b, ""))
+			require.NoError(t, b.RemovePrefix(ctx, ""))
+			assert.Empty(t, listNames(ctx, t, b, ""))
+
+			require.NoError(t, writeDataToFile(ctx, b, "key", "data"))
+			assert.Equal(t, []string{"key"}, listNames(ctx, t, b, ""), "bucket should remain usable")
+		})
+	}
+	t.Run("S3KeepsOtherPrefixesAndBatchesDeletes", func(t *testing.T) {
+		client := newMockS3Client()
+		client.listPageSize = 3
+		client.putObject("prefix-other/key", []byte("data"), mockS3Object{})
+		b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+		b.batchSize = 2
+		for i := 0; i < 7; i++ {
+			require.NoError(t, writeDataToFile(ctx, b, fmt.Sprintf("dir/%d", i), "data"))
+		}
+
+		require.NoError(t, b.RemovePrefix(ctx, ""))
+		assert.Empty(t, listNames(ctx, t, b, ""))
+		assert.Contains(t, client.objects, "prefix-other/key")
+		require.GreaterOrEqual(t, len(client.deleteObjectsCalls), 4)
+		for _, call := range client.deleteObjectsCalls {
+			assert.LessOrEqual(t, len(call.Delete.Objects), 2)
+		}
+	})
+	t.Run("ParallelDryRun", func(t *testing.T) {
+		local, err := NewLocalBucket(LocalOptions{Path: t.TempDir()})
+		require.NoError(t, err)
+		b, err := NewParallelSyncBucket(ParallelBucketOptions{Workers: 2, DryRun: true}, local)
+		require.NoError(t, err)
+		require.NoError(t, writeDataToFile(ctx, local, "dir/key", "data"))
+
+		require.NoError(t, b.RemovePrefix(ctx, "dir"))
+		assert.Equal(t, []string{"dir/key"}, listNames(ctx, t, local, ""))
+	})
+	t.Run("LocalDryRun", func(t *testing.T) {
+		b, err := NewLocalBucket(LocalOptions{Path: t.TempDir(), DryRun: true})
+		require.NoError(t, err)
+		require.NoError(t, os.MkdirAll(filepath.Join(b.(*localFileSystem).path, "dir"), 0700))
+		require.NoError(t, os.WriteFile(filepath.Join(b.(*localFileSystem).path, "dir", "key"), []byte("data"), 0600))
+
+		require.NoError(t, b.RemovePrefix(ctx, "dir"))
+		assert.Equal(t, []string{"dir/key"}, listNames(ctx, t, b, ""))
+	})
+}
+
+func TestLocalBucketHidesOpenWriters(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	b, err := NewLocalBucket(LocalOptions{Path: t.TempDir()})
+	require.NoError(t, err)
+	require.NoError(t, writeDataToFile(ctx, b, "dir/existing", "data"))
+
+	w, err := b.Writer(ctx, "dir/new")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("partial data"))
+	require.NoError(t, err)
+
+	assert.Equal(t, []string{"dir/existing"}, listNames(ctx, t, b, ""))
+	local := t.TempDir()
+	require.NoError(t, b.Pull(ctx, SyncOptions{Local: local, Remote: "dir"}))
+	files, err := walkLocalTree(ctx, local)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"existing"}, files)
+
+	require.NoError(t, b.RemovePrefix(ctx, "dir/"))
+	assert.Empty(t, listNames(ctx, t, b, ""))
+	require.NoError(t, w.Close())
+	assert.Equal(t, []string{"dir/new"}, listNames(ctx, t, b, ""))
+}
+
+func TestDirectoryHelpersTreatPrefixAsDirectory(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, impl := range offlineListBuckets() {
+		t.Run(impl.name, func(t *testing.T) {
+			b := impl.constructor(t)
+			for _, key := range listSemanticsKeys {
+				require.NoError(t, writeDataToFile(ctx, b, key, "data"))
+			}
+
+			r, err := GetZipReader(ctx, b, "foo")
+			require.NoError(t, err)
+			data, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.NoError(t, r.Close())
+			archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+			require.NoError(t, err)
+			var names []string
+			for _, file := range archive.File {
+				names = append(names, file.Name)
+			}
+			assert.Equal(t, []string{"a", "b/c", "b/d"}, names)
+
+			diff, err := DiffPrefix(ctx, b, b, "foo", "missing")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"a", "b/c", "b/d"}, diff.OnlyInSource)
+
+			diff, err = DiffPrefix(ctx, b, b, "foobar", "missing")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"foobar"}, diff.OnlyInSource, "a prefix that is a key should match that object")
+		})
+	}
+}
+
+func TestRemoveMatching(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, impl := range offlineListBuckets() {
+		t.Run(impl.name, func(t *testing.T) {
+			b := impl.constructor(t)
+			for _, key := range []string{"a.tmp", "dir/b.tmp", "dir/c.txt", "tmp"} {
+				require.NoError(t, writeDataToFile(ctx, b, key, "data"))
+			}
+
+			err := b.RemoveMatching(ctx, "[")
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "invalid regular expression")
+			assert.Len(t, listNames(ctx, t, b, ""), 4)
+
+			require.NoError(t, b.RemoveMatching(ctx, `\.tmp$`))
+			assert.Equal(t, []string{"dir/c.txt", "tmp"}, listNames(ctx, t, b, ""))
+			require.NoError(t, b.RemoveMatching(ctx, "^dir/"))
+			assert.Equal(t, []string{"tmp"}, listNames(ctx, t, b, ""))
+			require.NoError(t, b.RemoveMatching(ctx, "nothing"))
+			assert.Equal(t, []string{"tmp"}, listNames(ctx, t, b, ""))
+		})
+	}
+	t.Run("S3MatchesKeysWithoutPrefixInBatches", func(t *testing.T) {
+		client := newMockS3Client()
+		client.putObject("other/key.tmp", []byte("data"), mockS3Object{})
+		b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+		b.batchSize = 2
+		for i := 0; i < 5; i++ {
+			require.NoError(t, writeDataToFile(ctx, b, fmt.Sprintf("%d.tmp", i), "data"))
+		}
+		require.NoError(t, writeDataToFile(ctx, b, "keep", "data"))
+
+		require.NoError(t, b.RemoveMatching(ctx, `^\d\.tmp$`))
+		assert.Equal(t, []string{"keep"}, listNames(ctx, t, b, ""))
+		assert.Contains(t, client.objects, "other/key.tmp")
+		require.Len(t, client.deleteObjectsCalls, 3)
+		for _, call := range client.deleteObjectsCalls {
+			assert.LessOrEqual(t, len(call.Delete.Objects), 2)
+		}
+	})
+}
+
+func TestBucketURI(t *testing.T) {
+	t.Run("S3", func(t *testing.T) {
+		b := newMockS3Bucket(newMockS3Client(), "prefix")
+		assert.Equal(t, "s3://bucket/prefix/dir/key", b.URI("dir/key"))
+
+		b = newMockS3Bucket(newMockS3Client(), "")
+		assert.Equal(t, "s3://bucket/key", b.URI("key"))
+	})
+	t.Run("GCS", func(t *testing.T) {
+		b := newMockGCSBucket(newMockGCSClient(), GCSOptions{Prefix: "prefix"})
+		assert.Equal(t, "gs://bucket/prefix/dir/key", b.URI("dir/key"))
+	})
+	t.Run("GridFS", func(t *testing.T) {
+		b := &gridfsBucket{opts: GridFSOptions{Database: "db", Name: "name", Prefix: "prefix"}}
+		assert.Equal(t, "gridfs://db/name/prefix/dir/key", b.URI("dir/key"))
+
+		b = &gridfsBucket{opts: GridFSOptions{Database: "db"}}
+		assert.Equal(t, "gridfs://db/fs/key", b.URI("key"))
+	})
+	t.Run("Local", func(t *testing.T) {
+		dir := t.TempDir()
+		b, err := NewLocalBucket(LocalOptions{Path: dir, Prefix: "prefix"})
+		require.NoError(t, err)
+
+		u, err := url.Parse(b.URI("dir/key with space"))
+		require.NoError(t, err)
+		assert.Equal(t, "file", u.Scheme)
+		assert.Equal(t, filepath.Join(dir, "prefix", "dir", "key with space"), filepath.FromSlash(u.Path))
+		assert.Contains(t, b.URI("key with space"), "key%20with%20space")
+	})
+	t.Run("LocalRelativePath", func(t *testing.T) {
+		b, err := NewLocalBucket(LocalOptions{Path: "testdata", UseSlash: true})
+		require.NoError(t, err)
+		wd, err := os.Getwd()
+		require.NoError(t, err)
+
+		u, err := url.Parse(b.URI("key"))
+		require.NoError(t, err)
+		assert.Equal(t, filepath.Join(wd, "testdata", "key"), filepath.FromSlash(u.Path))
+	})
+	t.Run("Memory", func(t *testing.T) {
+		b, err := NewMemoryBucket(MemoryBucketOptions{Name: "name", Prefix: "prefix"})
+		require.NoError(t, err)
+		assert.Equal(t, "mem://name/prefix/key", b.URI("key"))
+	})
+	t.Run("Sharded", func(t *testing.T) {
+		var shards []Bucket
+		for _, name := range []string{"first", "second"} {
+			shard, err := NewMemoryBucket(MemoryBucketOptions{Name: name})
+			require.NoError(t, err)
+			shards = append(shards, shard)
+		}
+		b := NewShardedBucket(shards, func(key string) int { return len(key) })
+
+		assert.Equal(t, "mem://first/ab", b.URI("ab"))
+		assert.Equal(t, "mem://second/abc", b.URI("abc"))
+	})
+	t.Run("Parallel", func(t *testing.T) {
+		memory, err := NewMemoryBucket(MemoryBucketOptions{Name: "name"})
+		require.NoError(t, err)
+		b, err := NewParallelSyncBucket(ParallelBucketOptions{Workers: 2}, memory)
+		require.NoError(t, err)
+		assert.Equal(t, "mem://name/key", b.URI("key"))
+	})
+}
+
+func TestListPrefixSemantics(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, impl := range offlineListBuckets() {
+		t.Run(impl.name, func(t *testing.T) {
+			b := impl.constructor(t)
+			for _, key := range listSemanticsKeys {
+				require.NoError(t, writeDataToFile(ctx, b, key, "data"))
+			}
+			assertListPrefixSemantics(ctx, t, b)
+		})
+	}
+	t.Run("S3EmptyPrefixOnlyListsBucketPrefix", func(t *testing.T) {
+		client := newMockS3Client()
+		client.putObject("prefix-other/key", []byte("data"), mockS3Object{})
+		client.putObject("prefixed", []byte("data"), mockS3Object{})
+		b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+		require.NoError(t, writeDataToFile(ctx, b, "key", "data"))
+
+		assert.Equal(t, []string{"key"}, listNames(ctx, t, b, ""))
+	})
+}
+
+// listSemanticsKeys are keys that distinguish listing by prefix from listing
+// by directory.
+var listSemanticsKeys = []string{"foo/a", "foo/b/c", "foo/b/d", "foobar", "other"}
+
+// assertListPrefixSemantics asserts that List matches keys by prefix and
+// ListDir lists a single directory in a bucket with listSemanticsKeys.
+func assertListPrefixSemantics(ctx context.Context, t *testing.T, b Bucket) {
+	assert.Equal(t, []string{"foo/a", "foo/b/c", "foo/b/d", "foobar"}, listNames(ctx, t, b, "foo"))
+	assert.Equal(t, []string{"foo/a", "foo/b/c", "foo/b/d"}, listNames(ctx, t, b, "foo/"))
+	assert.Equal(t, listSemanticsKeys, listNames(ctx, t, b, ""))
+	assert.Equal(t, []string{"foo/b/c", "foo/b/d"}, listNames(ctx, t, b, "foo/b"))
+
+	listDir := func(dir string) []string {
+		iter, err := ListDir(ctx, b, dir)
+		require.NoError(t, err)
+		var names []string
+		for iter.Next(ctx) {
+			names = append(names, iter.Item().Name())
+		}
+		require.NoError(t, iter.Err())
+		return names
+	}
+	assert.Equal(t, []string{"foo/a", "foo/b/"}, listDir("foo"))
+	assert.Equal(t, []string{"foo/a", "foo/b/"}, listDir("foo/"))
+	assert.Equal(t, []string{"foo/", "foobar", "other"}, listDir(""))
+	assert.Empty(t, listDir("fo"))
+}
+
+// listNames returns the names of the items listed with the prefix.
+func listNames(ctx context.Context, t *testing.T, b Bucket, prefix string) []string {
+	iter, err := b.List(ctx, prefix)
+	require.NoError(t, err)
+	var names []string
+	for iter.Next(ctx) {
+		names = append(names, iter.Item().Name())
+	}
+	require.NoError(t, iter.Err())
+	return names
+}
+
+// orderTestKeys returns keys under the "list" prefix whose order by full key
+// differs from the order of a directory-by-directory walk, along with enough
+// other keys to span several pages of a listing.
+func orderTestKeys() []string {
+	keys := []string{"list/a/b", "list/a-c", "list/a.d", "list/a/c/d", "list/ab", "list/a0", "list/A", "list/a_e"}
+	for i := 0; i < 40; i++ {
+		keys = append(keys, fmt.Sprintf("list/a/%d/file", i), fmt.Sprintf("list/b%d", i))
+	}
+	return keys
+}
+
+// assertKeysInStrictOrder asserts that listing the prefix yields the expected
+// number of keys in strictly increasing order.
+func assertKeysInStrictOrder(ctx context.Context, t *testing.T, b Bucket, prefix string, expected int) {
+	iter, err := b.List(ctx, prefix)
+	require.NoError(t, err)
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Item().Name())
+	}
+	require.NoError(t, iter.Err())
+	require.Len(t, keys, expected)
+	for i := 1; i < len(keys); i++ {
+		assert.True(t, keys[i-1] < keys[i], "key '%s' should sort after '%s'", keys[i], keys[i-1])
+	}
+}
+
+func getLastModified(ctx context.Context, t *testing.T, bucket Bucket, key string) time.Time {
+	iter, err := bucket.List(ctx, key)
+	require.NoError(t, err)
+	for iter.Next(ctx) {
+		if iter.Item().Name() == key {
+			return itemLastModified(iter.Item())
+		}
+	}
+	require.NoError(t, iter.Err())
+	require.FailNow(t, "key not found", key)
+	return time.Time{}
+}
@@ -0,0 +1,57 @@
+package pail
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// ChecksumWriteCloser is a writer that computes the SHA-256 checksum of the
+// data written to it as it streams through to the underlying writer.
+type ChecksumWriteCloser struct {
+	io.WriteCloser
+	hash     hash.Hash
+	checksum string
+}
+
+// NewChecksumWriteCloser wraps the given writer so that the SHA-256 checksum of
+// the data written to it is computed, avoiding having to read the data back to
+// hash it.
+func NewChecksumWriteCloser(w io.WriteCloser) *ChecksumWriteCloser {
+	return &ChecksumWriteCloser{WriteCloser: w, hash: sha256.New()}
+}
+
+// WriterWithChecksum returns a writer for the given key in the bucket that
+// computes the SHA-256 checksum of the data written to it. The checksum is of
+// the data as written, before any compression applied by the bucket.
+func WriterWithChecksum(ctx context.Context, b Bucket, key string) (*ChecksumWriteCloser, error) {
+	w, err := b.Writer(ctx, key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return NewChecksumWriteCloser(w), nil
+}
+
+func (w *ChecksumWriteCloser) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	_, _ = w.hash.Write(p[:n])
+	return n, err
+}
+
+// Close closes the underlying writer and finalizes the checksum.
+func (w *ChecksumWriteCloser) Close() error {
+	err := w.WriteCloser.Close()
+	if w.checksum == "" {
+		w.checksum = hex.EncodeToString(w.hash.Sum(nil))
+	}
+	return err
+}
+
+// Checksum returns the hex-encoded SHA-256 checksum of the data written, or an
+// empty string if the writer has not been closed.
+func (w *ChecksumWriteCloser) Checksum() string { return w.checksum }
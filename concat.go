@@ -0,0 +1,52 @@
+package pail
+
+import (
+	"context"
+	"io"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// ConcatToWriter copies the contents of each object in the bucket with the
+// given prefix to the writer, one after another in order of their keys, for
+// example to reassemble a file stored as numbered parts without writing the
+// parts to disk. The writer may have been partially written to if an error is
+// returned.
+func ConcatToWriter(ctx context.Context, b Bucket, prefix string, w io.Writer) error {
+	iter, err := b.List(ctx, prefix)
+	if err != nil {
+		return errors.Wrapf(err, "listing prefix '%s'", prefix)
+	}
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Item().Name())
+	}
+	if err := iter.Err(); err != nil {
+		return errors.Wrapf(err, "iterating prefix '%s'", prefix)
+	}
+	// List already returns keys in order, but sort them in case the
+	// bucket stores keys in a form that sorts differently, such as with
+	// a KeyTransform.
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		if err := copyObjectTo(ctx, b, key, w); err != nil {
+			return errors.Wrapf(err, "copying key '%s'", key)
+		}
+	}
+	return nil
+}
+
+// copyObjectTo copies the contents of the object with the given key to the
+// writer.
+func copyObjectTo(ctx context.Context, b Bucket, key string, w io.Writer) error {
+	r, err := b.Get(ctx, key)
+	if err != nil {
+		return errors.Wrap(err, "getting object")
+	}
+	defer r.Close()
+
+	_, err = io.Copy(w, r)
+	return errors.Wrap(err, "copying object contents")
+}
@@ -0,0 +1,145 @@
+package pail
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/aws/smithy-go"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxSlowDownRetries is the number of times an operation that is
+	// throttled by the service is retried before its error is returned.
+	maxSlowDownRetries = 10
+	// slowDownBackoff is the base delay before retrying an operation that
+	// was throttled by the service; the delay increases linearly with each
+	// attempt.
+	slowDownBackoff = 50 * time.Millisecond
+)
+
+// adaptiveConcurrencyLimiter limits the number of operations that may run
+// concurrently, adjusting the limit based on whether the service is
+// throttling requests. The limit is halved when an operation is throttled
+// and increased by one after a full limit's worth of operations succeed
+// while the limit is reached. It is safe for concurrent use, so a single
+// limiter can be shared across workers.
+type adaptiveConcurrencyLimiter struct {
+	mu        sync.Mutex
+	cond      *sync.Cond
+	limit     int
+	max       int
+	active    int
+	successes int
+	// generation is incremented each time the limit is decreased so that
+	// a burst of throttled operations that were started under the same
+	// limit only decreases it once.
+	generation int
+}
+
+// newAdaptiveConcurrencyLimiter returns a limiter that initially allows, and
+// never allows more than, max concurrent operations.
+func newAdaptiveConcurrencyLimiter(max int) *adaptiveConcurrencyLimiter {
+	if max < 1 {
+		max = 1
+	}
+
+	l := &adaptiveConcurrencyLimiter{limit: max, max: max}
+	l.cond = sync.NewCond(&l.mu)
+	return l
+}
+
+// currentLimit returns the number of operations that may currently run
+// concurrently.
+func (l *adaptiveConcurrencyLimiter) currentLimit() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.limit
+}
+
+// do runs the operation once the limit allows it, retrying it with backoff if
+// it is throttled by the service.
+func (l *adaptiveConcurrencyLimiter) do(ctx context.Context, op func() error) error {
+	for attempt := 1; ; attempt++ {
+		generation := l.acquire()
+		err := op()
+		l.release(generation, err)
+
+		if !isSlowDownError(err) || attempt > maxSlowDownRetries {
+			return err
+		}
+
+		timer := time.NewTimer(time.Duration(attempt) * slowDownBackoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.WithStack(ctx.Err())
+		case <-timer.C:
+		}
+	}
+}
+
+func (l *adaptiveConcurrencyLimiter) acquire() int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for l.active >= l.limit {
+		l.cond.Wait()
+	}
+	l.active++
+
+	return l.generation
+}
+
+func (l *adaptiveConcurrencyLimiter) release(generation int, err error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	defer l.cond.Broadcast()
+
+	saturated := l.active >= l.limit
+	l.active--
+
+	if isSlowDownError(err) {
+		if generation == l.generation {
+			l.limit /= 2
+			if l.limit < 1 {
+				l.limit = 1
+			}
+			l.successes = 0
+			l.generation++
+		}
+		return
+	}
+	// Only increase the limit when it is actually constraining the number
+	// of concurrent operations, so that it does not grow unboundedly while
+	// operations are backing off.
+	if err != nil || !saturated {
+		return
+	}
+
+	l.successes++
+	if l.successes >= l.limit {
+		l.successes = 0
+		if l.limit < l.max {
+			l.limit++
+		}
+	}
+}
+
+// isSlowDownError returns whether the error indicates that the service is
+// throttling requests.
+func isSlowDownError(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	switch apiErr.ErrorCode() {
+	case "SlowDown", "ServiceUnavailable", "Throttling", "ThrottlingException", "RequestLimitExceeded":
+		return true
+	default:
+		return false
+	}
+}
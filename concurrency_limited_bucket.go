@@ -0,0 +1,206 @@
+package pail
+
+import (
+	"context"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// NewLimitedConcurrencyBucket returns a bucket that delegates every operation
+// to the given bucket, but allows at most maxConcurrent operations to run at
+// the same time, across all goroutines using the returned bucket. Operations
+// block until a slot is free or their context is done. The readers and
+// writers returned by Reader, Get, and Writer hold their slot until they are
+// closed, so they must always be closed. List only holds a slot while
+// listing starts, not while its iterator is used, and Join is not limited.
+//
+// Push and Pull each count as a single operation, regardless of the number of
+// workers the underlying bucket uses to sync. Operations that the underlying
+// bucket runs on the returned bucket while it holds a slot, such as the Writer
+// of a Copy whose destination is the returned bucket, run in that slot rather
+// than waiting for another, so they cannot deadlock. If maxConcurrent is less
+// than 1, only one operation runs at a time.
+func NewLimitedConcurrencyBucket(b Bucket, maxConcurrent int) Bucket {
+	if maxConcurrent < 1 {
+		maxConcurrent = 1
+	}
+
+	return &concurrencyLimitedBucket{Bucket: b, slots: make(chan struct{}, maxConcurrent)}
+}
+
+type concurrencyLimitedBucket struct {
+	Bucket
+	slots chan struct{}
+}
+
+// concurrencySlotKey is the context key that marks the operations that run in
+// a slot of the bucket.
+type concurrencySlotKey struct {
+	bucket *concurrencyLimitedBucket
+}
+
+// acquire blocks until a slot is free or the context is done, returning the
+// context for the operation that holds the slot and the function that frees
+// the slot. If the context is already that of an operation holding a slot, no
+// slot is acquired, since the operation was started by the holder of the slot
+// and the holder waits for it.
+func (b *concurrencyLimitedBucket) acquire(ctx context.Context) (context.Context, func(), error) {
+	key := concurrencySlotKey{bucket: b}
+	if ctx.Value(key) != nil {
+		return ctx, func() {}, nil
+	}
+
+	select {
+	case b.slots <- struct{}{}:
+	case <-ctx.Done():
+		return nil, nil, errors.WithStack(ctx.Err())
+	}
+
+	once := &sync.Once{}
+	return context.WithValue(ctx, key, true), func() { once.Do(func() { <-b.slots }) }, nil
+}
+
+// do runs the operation with the context of a slot once one is free.
+func (b *concurrencyLimitedBucket) do(ctx context.Context, op func(context.Context) error) error {
+	ctx, release, err := b.acquire(ctx)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	return op(ctx)
+}
+
+func (b *concurrencyLimitedBucket) Check(ctx context.Context) error {
+	return b.do(ctx, func(ctx context.Context) error { return b.Bucket.Check(ctx) })
+}
+
+func (b *concurrencyLimitedBucket) Ping(ctx context.Context) (time.Duration, error) {
+	var latency time.Duration
+	err := b.do(ctx, func(ctx context.Context) (err error) {
+		latency, err = Ping(ctx, b.Bucket)
+		return err
+	})
+	return latency, err
+}
+
+func (b *concurrencyLimitedBucket) Exists(ctx context.Context, key string) (bool, error) {
+	var exists bool
+	err := b.do(ctx, func(ctx context.Context) (err error) {
+		exists, err = b.Bucket.Exists(ctx, key)
+		return err
+	})
+	return exists, err
+}
+
+func (b *concurrencyLimitedBucket) Writer(ctx context.Context, key string) (io.WriteCloser, error) {
+	ctx, release, err := b.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	w, err := b.Bucket.Writer(ctx, key)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	return &releasingWriteCloser{WriteCloser: w, release: release}, nil
+}
+
+func (b *concurrencyLimitedBucket) Reader(ctx context.Context, key string) (io.ReadCloser, error) {
+	return b.openReader(ctx, func(ctx context.Context) (io.ReadCloser, error) { return b.Bucket.Reader(ctx, key) })
+}
+
+func (b *concurrencyLimitedBucket) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return b.openReader(ctx, func(ctx context.Context) (io.ReadCloser, error) { return b.Bucket.Get(ctx, key) })
+}
+
+// openReader opens a reader once a slot is free, returning a reader that frees
+// the slot when it is closed.
+func (b *concurrencyLimitedBucket) openReader(ctx context.Context, open func(context.Context) (io.ReadCloser, error)) (io.ReadCloser, error) {
+	ctx, release, err := b.acquire(ctx)
+	if err != nil {
+		return nil, err
+	}
+	r, err := open(ctx)
+	if err != nil {
+		release()
+		return nil, err
+	}
+
+	return &releasingReadCloser{ReadCloser: r, release: release}, nil
+}
+
+func (b *concurrencyLimitedBucket) Put(ctx context.Context, key string, r io.Reader) error {
+	return b.do(ctx, func(ctx context.Context) error { return b.Bucket.Put(ctx, key, r) })
+}
+
+func (b *concurrencyLimitedBucket) Upload(ctx context.Context, key, path string) error {
+	return b.do(ctx, func(ctx context.Context) error { return b.Bucket.Upload(ctx, key, path) })
+}
+
+func (b *concurrencyLimitedBucket) Download(ctx context.Context, key, path string) error {
+	return b.do(ctx, func(ctx context.Context) error { return b.Bucket.Download(ctx, key, path) })
+}
+
+func (b *concurrencyLimitedBucket) Push(ctx context.Context, opts SyncOptions) error {
+	return b.do(ctx, func(ctx context.Context) error { return b.Bucket.Push(ctx, opts) })
+}
+
+func (b *concurrencyLimitedBucket) Pull(ctx context.Context, opts SyncOptions) error {
+	return b.do(ctx, func(ctx context.Context) error { return b.Bucket.Pull(ctx, opts) })
+}
+
+func (b *concurrencyLimitedBucket) Copy(ctx context.Context, opts CopyOptions) error {
+	return b.do(ctx, func(ctx context.Context) error { return b.Bucket.Copy(ctx, opts) })
+}
+
+func (b *concurrencyLimitedBucket) Remove(ctx context.Context, key string) error {
+	return b.do(ctx, func(ctx context.Context) error { return b.Bucket.Remove(ctx, key) })
+}
+
+func (b *concurrencyLimitedBucket) RemoveMany(ctx context.Context, keys ...string) error {
+	return b.do(ctx, func(ctx context.Context) error { return b.Bucket.RemoveMany(ctx, keys...) })
+}
+
+func (b *concurrencyLimitedBucket) RemovePrefix(ctx context.Context, prefix string) error {
+	return b.do(ctx, func(ctx context.Context) error { return b.Bucket.RemovePrefix(ctx, prefix) })
+}
+
+func (b *concurrencyLimitedBucket) RemoveMatching(ctx context.Context, expression string) error {
+	return b.do(ctx, func(ctx context.Context) error { return b.Bucket.RemoveMatching(ctx, expression) })
+}
+
+func (b *concurrencyLimitedBucket) List(ctx context.Context, prefix string) (BucketIterator, error) {
+	var iter BucketIterator
+	err := b.do(ctx, func(ctx context.Context) (err error) {
+		iter, err = b.Bucket.List(ctx, prefix)
+		return err
+	})
+	return iter, err
+}
+
+// releasingReadCloser frees its concurrency slot when it is closed.
+type releasingReadCloser struct {
+	io.ReadCloser
+	release func()
+}
+
+func (r *releasingReadCloser) Close() error {
+	defer r.release()
+	return r.ReadCloser.Close()
+}
+
+// releasingWriteCloser frees its concurrency slot when it is closed.
+type releasingWriteCloser struct {
+	io.WriteCloser
+	release func()
+}
+
+func (w *releasingWriteCloser) Close() error {
+	defer w.release()
+	return w.WriteCloser.Close()
+}
@@ -0,0 +1,135 @@
+package pail
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// concurrencyTrackingBucket records the maximum number of Puts running at the
+// same time.
+type concurrencyTrackingBucket struct {
+	Bucket
+	inFlight    int32
+	maxInFlight int32
+}
+
+func (b *concurrencyTrackingBucket) Put(ctx context.Context, key string, r io.Reader) error {
+	inFlight := atomic.AddInt32(&b.inFlight, 1)
+	defer atomic.AddInt32(&b.inFlight, -1)
+	for {
+		prev := atomic.LoadInt32(&b.maxInFlight)
+		if inFlight <= prev || atomic.CompareAndSwapInt32(&b.maxInFlight, prev, inFlight) {
+			break
+		}
+	}
+	// Hold the operation long enough for others to overlap with it.
+	time.Sleep(10 * time.Millisecond)
+
+	return b.Bucket.Put(ctx, key, r)
+}
+
+func TestLimitedConcurrencyBucket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newLocal := func(t *testing.T) Bucket {
+		local, err := NewLocalBucket(LocalOptions{Path: t.TempDir()})
+		require.NoError(t, err)
+		return local
+	}
+
+	t.Run("LimitsConcurrentOperations", func(t *testing.T) {
+		tracker := &concurrencyTrackingBucket{Bucket: newLocal(t)}
+		b := NewLimitedConcurrencyBucket(tracker, 3)
+
+		wg := &sync.WaitGroup{}
+		for i := 0; i < 20; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				assert.NoError(t, b.Put(ctx, fmt.Sprintf("key%d", i), strings.NewReader("data")))
+			}(i)
+		}
+		wg.Wait()
+
+		assert.LessOrEqual(t, atomic.LoadInt32(&tracker.maxInFlight), int32(3))
+		assert.Greater(t, atomic.LoadInt32(&tracker.maxInFlight), int32(1))
+		count, err := Count(ctx, b, "")
+		require.NoError(t, err)
+		assert.Equal(t, 20, count)
+	})
+	t.Run("ReadersHoldSlotUntilClosed", func(t *testing.T) {
+		b := NewLimitedConcurrencyBucket(newLocal(t), 2)
+		require.NoError(t, b.Put(ctx, "key", strings.NewReader("data")))
+
+		first, err := b.Get(ctx, "key")
+		require.NoError(t, err)
+		second, err := b.Reader(ctx, "key")
+		require.NoError(t, err)
+
+		tctx, tcancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer tcancel()
+		_, err = b.Get(tctx, "key")
+		assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+		require.NoError(t, first.Close())
+		third, err := b.Get(ctx, "key")
+		require.NoError(t, err)
+		data, err := io.ReadAll(third)
+		require.NoError(t, err)
+		assert.Equal(t, "data", string(data))
+		require.NoError(t, third.Close())
+		require.NoError(t, second.Close())
+	})
+	t.Run("FailedOpenFreesSlot", func(t *testing.T) {
+		b := NewLimitedConcurrencyBucket(newLocal(t), 1)
+
+		_, err := b.Get(ctx, "nonexistent")
+		require.Error(t, err)
+		w, err := b.Writer(ctx, "key")
+		require.NoError(t, err)
+		_, err = w.Write([]byte("data"))
+		require.NoError(t, err)
+		require.NoError(t, w.Close())
+
+		exists, err := b.Exists(ctx, "key")
+		require.NoError(t, err)
+		assert.True(t, exists)
+	})
+	t.Run("CopyWithinBucketDoesNotDeadlock", func(t *testing.T) {
+		b := NewLimitedConcurrencyBucket(newLocal(t), 1)
+		require.NoError(t, b.Put(ctx, "src", strings.NewReader("data")))
+
+		tctx, tcancel := context.WithTimeout(ctx, 5*time.Second)
+		defer tcancel()
+		require.NoError(t, b.Copy(tctx, CopyOptions{SourceKey: "src", DestinationKey: "dst", DestinationBucket: b}))
+
+		data, err := readDataFromFile(ctx, b, "dst")
+		require.NoError(t, err)
+		assert.Equal(t, "data", data)
+
+		// The copy's slot is freed once it returns.
+		require.NoError(t, b.Put(tctx, "other", strings.NewReader("data")))
+	})
+	t.Run("SeparateOperationsStillWait", func(t *testing.T) {
+		b := NewLimitedConcurrencyBucket(newLocal(t), 1)
+		require.NoError(t, b.Put(ctx, "key", strings.NewReader("data")))
+
+		r, err := b.Get(ctx, "key")
+		require.NoError(t, err)
+		tctx, tcancel := context.WithTimeout(ctx, 20*time.Millisecond)
+		defer tcancel()
+		_, err = b.Exists(tctx, "key")
+		assert.Error(t, err)
+		require.NoError(t, r.Close())
+	})
+}
@@ -0,0 +1,31 @@
+package pail
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// CountItems drains the iterator and returns the number of items that it
+// yielded.
+func CountItems(ctx context.Context, iter BucketIterator) (int, error) {
+	count := 0
+	for iter.Next(ctx) {
+		count++
+	}
+	if err := iter.Err(); err != nil {
+		return count, errors.Wrap(err, "iterating bucket")
+	}
+
+	return count, nil
+}
+
+// Count returns the number of objects in the bucket with the given prefix.
+func Count(ctx context.Context, b Bucket, prefix string) (int, error) {
+	iter, err := b.List(ctx, prefix)
+	if err != nil {
+		return 0, errors.Wrap(err, "listing bucket")
+	}
+
+	return CountItems(ctx, iter)
+}
@@ -0,0 +1,138 @@
+package pail
+
+import (
+	"context"
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// PrefixDiff describes the differences between the objects under two
+// prefixes. Each list contains keys relative to their prefix, sorted
+// lexicographically.
+type PrefixDiff struct {
+	// OnlyInSource are the keys that exist only under the source prefix.
+	OnlyInSource []string
+	// OnlyInDestination are the keys that exist only under the destination
+	// prefix.
+	OnlyInDestination []string
+	// Different are the keys that exist under both prefixes but whose
+	// objects differ.
+	Different []string
+}
+
+// DiffPrefix compares the objects in the source bucket under srcPrefix to the
+// objects in the destination bucket under dstPrefix, which may be a different
+// bucket of a different kind. The prefixes are treated as directories, so
+// "src" compares "src/a" but not "src.bak/a". Objects are matched by their
+// keys relative to their prefix and compared using only the metadata returned
+// by listing, so no objects are downloaded. Two matched objects differ if
+// their listed sizes differ, or if both have a hash and the hashes differ;
+// hashes that are multipart S3 ETags are not compared, since they depend on
+// the part size used to upload the object rather than only its contents, nor
+// are the ETags of S3 objects encrypted with KMS or customer-provided keys,
+// which are not checksums of their contents. Checking whether an ETag is a
+// checksum takes an additional request for each object whose hash differs. As
+// a result, objects with the same size whose contents differ are only detected
+// when both buckets report comparable hashes, and objects stored compressed in
+// one bucket but not the other are reported as different.
+func DiffPrefix(ctx context.Context, src, dst Bucket, srcPrefix, dstPrefix string) (*PrefixDiff, error) {
+	srcItems, err := listRelativeItems(ctx, src, srcPrefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing source")
+	}
+	dstItems, err := listRelativeItems(ctx, dst, dstPrefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing destination")
+	}
+
+	diff := &PrefixDiff{}
+	for key, srcItem := range srcItems {
+		dstItem, ok := dstItems[key]
+		if !ok {
+			diff.OnlyInSource = append(diff.OnlyInSource, key)
+			continue
+		}
+		differ, err := bucketItemsDiffer(ctx, src, dst, srcItem, dstItem)
+		if err != nil {
+			return nil, errors.Wrapf(err, "comparing key '%s'", key)
+		}
+		if differ {
+			diff.Different = append(diff.Different, key)
+		}
+	}
+	for key := range dstItems {
+		if _, ok := srcItems[key]; !ok {
+			diff.OnlyInDestination = append(diff.OnlyInDestination, key)
+		}
+	}
+	sort.Strings(diff.OnlyInSource)
+	sort.Strings(diff.OnlyInDestination)
+	sort.Strings(diff.Different)
+
+	return diff, nil
+}
+
+// listRelativeItems returns the items in the bucket with the given prefix,
+// keyed by their names relative to the prefix.
+func listRelativeItems(ctx context.Context, b Bucket, prefix string) (map[string]BucketItem, error) {
+	iter, err := b.List(ctx, prefix)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	items := map[string]BucketItem{}
+	for iter.Next(ctx) {
+		item := iter.Item()
+		if underPrefix(item.Name(), prefix) {
+			items[relativeKey(item.Name(), prefix)] = item
+		}
+	}
+	if err = iter.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterating bucket")
+	}
+
+	return items, nil
+}
+
+// bucketItemsDiffer returns whether the listing metadata of the two items, from
+// the source and destination buckets respectively, shows that their objects
+// differ.
+func bucketItemsDiffer(ctx context.Context, src, dst Bucket, a, b BucketItem) (bool, error) {
+	sizedA, okA := a.(SizedBucketItem)
+	sizedB, okB := b.(SizedBucketItem)
+	if okA && okB && sizedA.Size() != sizedB.Size() {
+		return true, nil
+	}
+
+	hashA, hashB := a.Hash(), b.Hash()
+	if hashA == "" || hashB == "" || isMultipartETag(hashA) || isMultipartETag(hashB) || hashA == hashB {
+		return false, nil
+	}
+	// Whether an ETag is a checksum is only checked for the objects whose
+	// hashes do not match, to avoid a request for each object.
+	for _, item := range []struct {
+		bucket Bucket
+		key    string
+	}{{bucket: src, key: a.Name()}, {bucket: dst, key: b.Name()}} {
+		etagBucket, ok := item.bucket.(etagChecksumBucket)
+		if !ok {
+			continue
+		}
+		isMD5, err := etagBucket.etagIsMD5(ctx, item.key)
+		if err != nil {
+			return false, errors.Wrapf(err, "checking ETag of key '%s'", item.key)
+		}
+		if !isMD5 {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// isMultipartETag returns whether the hash is the ETag of an S3 object that
+// was uploaded in multiple parts, which has the form "<hash>-<parts>".
+func isMultipartETag(hash string) bool {
+	return strings.Contains(hash, "-")
+}
@@ -0,0 +1,399 @@
+package pail
+
+import (
+	"container/list"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
+	"github.com/pkg/errors"
+)
+
+// diskCacheTempSuffix is the suffix of the files that objects are downloaded
+// to before they are added to the cache.
+const diskCacheTempSuffix = ".tmp"
+
+// NewDiskCachingBucket returns a bucket that delegates every operation to the
+// given bucket, but serves Reader, Get, and Download from copies of objects
+// cached in cacheDir. Cached copies are keyed by the object's key and hash, as
+// reported by List, so that a copy is only used while the object is
+// unchanged; objects whose listing has no hash are not cached. Checking the
+// hash takes a request for the object's metadata, such as a HEAD request for
+// S3, but does not download it.
+//
+// The least recently used copies are removed to keep the total size of the
+// cache under maxBytes, and objects larger than maxBytes are not cached. The
+// cache directory may be shared between processes, and copies cached by
+// earlier processes are reused. The time each copy was last accessed is
+// recorded as the modification time of its file, rather than relying on the
+// filesystem's access times, so that copies cached by other processes are
+// evicted in the order they were used. Writing or removing an object through
+// the returned bucket removes its cached copy.
+func NewDiskCachingBucket(b Bucket, cacheDir string, maxBytes int64) Bucket {
+	c := &diskCachingBucket{
+		Bucket:   b,
+		dir:      cacheDir,
+		maxBytes: maxBytes,
+		entries:  map[string]*list.Element{},
+		lru:      list.New(),
+	}
+	grip.Warning(message.WrapError(c.loadExisting(), message.Fields{
+		"message":   "could not load existing disk cache entries",
+		"cache_dir": cacheDir,
+	}))
+
+	return c
+}
+
+type diskCachingBucket struct {
+	Bucket
+	dir      string
+	maxBytes int64
+
+	mu sync.Mutex
+	// entries maps the names of the cached files to their elements in
+	// lru, which is ordered from most to least recently used.
+	entries map[string]*list.Element
+	lru     *list.List
+	size    int64
+}
+
+type diskCacheEntry struct {
+	name string
+	// key is the object's key, or empty if the file was cached by another
+	// process.
+	key      string
+	size     int64
+	accessed time.Time
+}
+
+// diskCacheFileName returns the name of the file that caches the object with
+// the given key and hash.
+func diskCacheFileName(key, hash string) string {
+	sum := sha256.Sum256([]byte(key + "\x00" + hash))
+	return hex.EncodeToString(sum[:])
+}
+
+// loadExisting adds the files already in the cache directory to the cache,
+// ordered by when they were last accessed.
+func (c *diskCachingBucket) loadExisting() error {
+	dirEntries, err := os.ReadDir(c.dir)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "reading cache directory '%s'", c.dir)
+	}
+
+	var entries []*diskCacheEntry
+	for _, dirEntry := range dirEntries {
+		name := dirEntry.Name()
+		if dirEntry.IsDir() || strings.HasSuffix(name, diskCacheTempSuffix) {
+			continue
+		}
+		info, err := dirEntry.Info()
+		if err != nil {
+			continue
+		}
+		entries = append(entries, &diskCacheEntry{name: name, size: info.Size(), accessed: info.ModTime()})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].accessed.Before(entries[j].accessed) })
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for _, entry := range entries {
+		c.addLocked(entry)
+	}
+	c.evictLocked()
+
+	return nil
+}
+
+// accessedLocked records that the cached file was just accessed, returning
+// the access time to record on the file once the lock is released.
+func (c *diskCachingBucket) accessedLocked(el *list.Element) time.Time {
+	entry := el.Value.(*diskCacheEntry)
+	entry.accessed = time.Now()
+	c.lru.MoveToFront(el)
+	return entry.accessed
+}
+
+// recordAccess sets the modification time of the cached file with the given
+// name to the time it was accessed, so that other processes sharing the cache
+// directory see when it was last used.
+func (c *diskCachingBucket) recordAccess(name string, accessed time.Time) {
+	if accessed.IsZero() {
+		return
+	}
+	grip.Warning(message.WrapError(os.Chtimes(filepath.Join(c.dir, name), accessed, accessed), message.Fields{
+		"message":   "could not record disk cache access time",
+		"cache_dir": c.dir,
+	}))
+}
+
+func (c *diskCachingBucket) Reader(ctx context.Context, key string) (io.ReadCloser, error) {
+	return c.Get(ctx, key)
+}
+
+func (c *diskCachingBucket) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	hash, err := c.currentHash(ctx, key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if hash == "" {
+		return c.Bucket.Get(ctx, key)
+	}
+
+	name := diskCacheFileName(key, hash)
+	if f := c.open(key, name); f != nil {
+		return f, nil
+	}
+
+	return c.fill(ctx, key, name)
+}
+
+func (c *diskCachingBucket) Download(ctx context.Context, key, path string) error {
+	return doDownload(ctx, c, key, path, 0)
+}
+
+// currentHash returns the hash of the object with the given key, or an empty
+// string if the object does not exist or the bucket does not report its
+// hash.
+func (c *diskCachingBucket) currentHash(ctx context.Context, key string) (string, error) {
+	return objectHash(ctx, c.Bucket, key)
+}
+
+// objectHashBucket is implemented by buckets that can look up the hash of a
+// single object, as reported by List, without listing.
+type objectHashBucket interface {
+	// objectHash returns the hash of the object with the given key, or an
+	// empty string if the object does not exist or has no hash.
+	objectHash(ctx context.Context, key string) (string, error)
+}
+
+// objectHash returns the hash of the object with the given key, as reported
+// by List, or an empty string if the object does not exist or the bucket
+// does not report its hash.
+func objectHash(ctx context.Context, b Bucket, key string) (string, error) {
+	if hb, ok := b.(objectHashBucket); ok {
+		return hb.objectHash(ctx, key)
+	}
+
+	iter, err := b.List(ctx, key)
+	if err != nil {
+		return "", errors.Wrap(err, "listing object")
+	}
+	for iter.Next(ctx) {
+		if iter.Item().Name() == key {
+			return iter.Item().Hash(), nil
+		}
+	}
+
+	return "", errors.Wrap(iter.Err(), "iterating object listing")
+}
+
+// open returns the cached file with the given name, or nil if it is not
+// cached.
+func (c *diskCachingBucket) open(key, name string) *os.File {
+	path := filepath.Join(c.dir, name)
+	f, err := os.Open(path)
+
+	var accessed time.Time
+	defer func() { c.recordAccess(name, accessed) }()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err != nil {
+		c.removeLocked(name)
+		return nil
+	}
+
+	if el, ok := c.entries[name]; ok {
+		el.Value.(*diskCacheEntry).key = key
+		accessed = c.accessedLocked(el)
+	} else if info, err := f.Stat(); err == nil {
+		// The file was cached by another process.
+		c.addLocked(&diskCacheEntry{name: name, key: key, size: info.Size()})
+		c.evictLocked()
+		if el, ok := c.entries[name]; ok {
+			accessed = c.accessedLocked(el)
+		}
+	}
+
+	return f
+}
+
+// fill downloads the object with the given key and caches it in the file
+// with the given name, returning a reader for the downloaded object.
+func (c *diskCachingBucket) fill(ctx context.Context, key, name string) (io.ReadCloser, error) {
+	r, err := c.Bucket.Get(ctx, key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	defer r.Close()
+
+	if err = os.MkdirAll(c.dir, 0700); err != nil {
+		return nil, errors.Wrapf(err, "creating cache directory '%s'", c.dir)
+	}
+	tmp, err := os.CreateTemp(c.dir, "*"+diskCacheTempSuffix)
+	if err != nil {
+		return nil, errors.Wrap(err, "creating cache file")
+	}
+	size, err := io.Copy(tmp, r)
+	catcher := grip.NewBasicCatcher()
+	catcher.Wrapf(err, "downloading key '%s'", key)
+	catcher.Wrap(tmp.Close(), "closing cache file")
+	if catcher.HasErrors() {
+		catcher.Add(os.Remove(tmp.Name()))
+		return nil, catcher.Resolve()
+	}
+
+	if size > c.maxBytes {
+		f, err := os.Open(tmp.Name())
+		if err != nil {
+			_ = os.Remove(tmp.Name())
+			return nil, errors.Wrap(err, "opening downloaded object")
+		}
+		return &removingReadCloser{File: f}, nil
+	}
+
+	path := filepath.Join(c.dir, name)
+	if err = os.Rename(tmp.Name(), path); err != nil {
+		_ = os.Remove(tmp.Name())
+		return nil, errors.Wrap(err, "adding object to cache")
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "opening cached object")
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	// The file was just written, so its modification time is already
+	// the time it was accessed.
+	c.removeLocked(name)
+	c.addLocked(&diskCacheEntry{name: name, key: key, size: size})
+	c.evictLocked()
+	if el, ok := c.entries[name]; ok {
+		c.accessedLocked(el)
+	}
+
+	return f, nil
+}
+
+func (c *diskCachingBucket) addLocked(entry *diskCacheEntry) {
+	c.entries[entry.name] = c.lru.PushFront(entry)
+	c.size += entry.size
+}
+
+func (c *diskCachingBucket) removeLocked(name string) {
+	el, ok := c.entries[name]
+	if !ok {
+		return
+	}
+	c.lru.Remove(el)
+	delete(c.entries, name)
+	c.size -= el.Value.(*diskCacheEntry).size
+}
+
+// evictLocked removes the least recently used files until the cache is within
+// its size limit. Readers that already opened an evicted file can continue to
+// read it.
+func (c *diskCachingBucket) evictLocked() {
+	for c.size > c.maxBytes && c.lru.Len() > 0 {
+		entry := c.lru.Back().Value.(*diskCacheEntry)
+		c.removeLocked(entry.name)
+		if err := os.Remove(filepath.Join(c.dir, entry.name)); err != nil && !os.IsNotExist(err) {
+			grip.Warning(message.WrapError(err, message.Fields{
+				"message":   "could not remove evicted disk cache entry",
+				"cache_dir": c.dir,
+				"key":       entry.key,
+			}))
+		}
+	}
+}
+
+// invalidate removes the cached copies of the objects whose keys match.
+func (c *diskCachingBucket) invalidate(match func(key string) bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for name, el := range c.entries {
+		entry := el.Value.(*diskCacheEntry)
+		if entry.key == "" || !match(entry.key) {
+			continue
+		}
+		c.removeLocked(name)
+		_ = os.Remove(filepath.Join(c.dir, name))
+	}
+}
+
+func (c *diskCachingBucket) invalidateKey(key string) {
+	c.invalidate(func(k string) bool { return k == key })
+}
+
+func (c *diskCachingBucket) Writer(ctx context.Context, key string) (io.WriteCloser, error) {
+	c.invalidateKey(key)
+	return c.Bucket.Writer(ctx, key)
+}
+
+func (c *diskCachingBucket) Put(ctx context.Context, key string, r io.Reader) error {
+	c.invalidateKey(key)
+	return c.Bucket.Put(ctx, key, r)
+}
+
+func (c *diskCachingBucket) Upload(ctx context.Context, key, path string) error {
+	c.invalidateKey(key)
+	return c.Bucket.Upload(ctx, key, path)
+}
+
+func (c *diskCachingBucket) Remove(ctx context.Context, key string) error {
+	c.invalidateKey(key)
+	return c.Bucket.Remove(ctx, key)
+}
+
+func (c *diskCachingBucket) RemoveMany(ctx context.Context, keys ...string) error {
+	removed := map[string]bool{}
+	for _, key := range keys {
+		removed[key] = true
+	}
+	c.invalidate(func(key string) bool { return removed[key] })
+	return c.Bucket.RemoveMany(ctx, keys...)
+}
+
+func (c *diskCachingBucket) RemovePrefix(ctx context.Context, prefix string) error {
+	c.invalidate(func(key string) bool { return strings.HasPrefix(key, prefix) })
+	return c.Bucket.RemovePrefix(ctx, prefix)
+}
+
+func (c *diskCachingBucket) RemoveMatching(ctx context.Context, expression string) error {
+	if re, err := regexp.Compile(expression); err == nil {
+		c.invalidate(re.MatchString)
+	}
+	return c.Bucket.RemoveMatching(ctx, expression)
+}
+
+// removingReadCloser removes its file when it is closed.
+type removingReadCloser struct {
+	*os.File
+}
+
+func (r *removingReadCloser) Close() error {
+	catcher := grip.NewBasicCatcher()
+	catcher.Add(r.File.Close())
+	catcher.Add(os.Remove(r.File.Name()))
+	return catcher.Resolve()
+}
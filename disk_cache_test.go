@@ -0,0 +1,183 @@
+package pail
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDiskCachingBucket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	setup := func(t *testing.T, cacheDir string, maxBytes int64) (*RecordingBucket, Bucket) {
+		s3b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(newMockS3Client(), "prefix")}
+		recorder, b := NewRecordingBucket(s3b)
+		return recorder, NewDiskCachingBucket(b, cacheDir, maxBytes)
+	}
+	readAll := func(t *testing.T, b Bucket, key string) string {
+		r, err := b.Get(ctx, key)
+		require.NoError(t, err)
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		return string(data)
+	}
+	backendGets := func(recorder *RecordingBucket) []string {
+		var keys []string
+		for _, call := range recorder.Calls() {
+			if call.Method == "Get" || call.Method == "Reader" {
+				keys = append(keys, call.Key)
+			}
+		}
+		return keys
+	}
+	cacheFiles := func(t *testing.T, dir string) int {
+		entries, err := os.ReadDir(dir)
+		require.NoError(t, err)
+		return len(entries)
+	}
+
+	t.Run("SecondGetHitsDiskCache", func(t *testing.T) {
+		recorder, b := setup(t, t.TempDir(), 1024)
+		require.NoError(t, b.Put(ctx, "key", strings.NewReader("hello")))
+
+		assert.Equal(t, "hello", readAll(t, b, "key"))
+		assert.Equal(t, []string{"key"}, backendGets(recorder))
+
+		assert.Equal(t, "hello", readAll(t, b, "key"))
+		assert.Equal(t, []string{"key"}, backendGets(recorder), "second get should be served from the disk cache")
+	})
+	t.Run("DownloadUsesDiskCache", func(t *testing.T) {
+		recorder, b := setup(t, t.TempDir(), 1024)
+		require.NoError(t, b.Put(ctx, "key", strings.NewReader("hello")))
+		assert.Equal(t, "hello", readAll(t, b, "key"))
+
+		path := filepath.Join(t.TempDir(), "downloaded")
+		require.NoError(t, b.Download(ctx, "key", path))
+		data, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "hello", string(data))
+		assert.Equal(t, []string{"key"}, backendGets(recorder))
+	})
+	t.Run("PutInvalidatesCache", func(t *testing.T) {
+		dir := t.TempDir()
+		recorder, b := setup(t, dir, 1024)
+		require.NoError(t, b.Put(ctx, "key", strings.NewReader("hello")))
+		assert.Equal(t, "hello", readAll(t, b, "key"))
+
+		require.NoError(t, b.Put(ctx, "key", strings.NewReader("goodbye")))
+		assert.Equal(t, 0, cacheFiles(t, dir))
+		assert.Equal(t, "goodbye", readAll(t, b, "key"))
+		assert.Equal(t, []string{"key", "key"}, backendGets(recorder))
+	})
+	t.Run("RemoveInvalidatesCache", func(t *testing.T) {
+		dir := t.TempDir()
+		_, b := setup(t, dir, 1024)
+		require.NoError(t, b.Put(ctx, "key", strings.NewReader("hello")))
+		assert.Equal(t, "hello", readAll(t, b, "key"))
+		assert.Equal(t, 1, cacheFiles(t, dir))
+
+		require.NoError(t, b.Remove(ctx, "key"))
+		assert.Equal(t, 0, cacheFiles(t, dir))
+		_, err := b.Get(ctx, "key")
+		assert.True(t, IsKeyNotFoundError(err))
+	})
+	t.Run("EvictsLeastRecentlyUsed", func(t *testing.T) {
+		dir := t.TempDir()
+		recorder, b := setup(t, dir, 10)
+		for _, key := range []string{"a", "b", "c"} {
+			require.NoError(t, b.Put(ctx, key, strings.NewReader(strings.Repeat(key, 4))))
+		}
+
+		assert.Equal(t, "aaaa", readAll(t, b, "a"))
+		assert.Equal(t, "bbbb", readAll(t, b, "b"))
+		assert.Equal(t, "aaaa", readAll(t, b, "a"))
+		assert.Equal(t, "cccc", readAll(t, b, "c"))
+		assert.Equal(t, 2, cacheFiles(t, dir))
+
+		assert.Equal(t, "aaaa", readAll(t, b, "a"))
+		assert.Equal(t, "bbbb", readAll(t, b, "b"))
+		assert.Equal(t, []string{"a", "b", "c", "b"}, backendGets(recorder))
+	})
+	t.Run("DoesNotCacheObjectsLargerThanLimit", func(t *testing.T) {
+		dir := t.TempDir()
+		recorder, b := setup(t, dir, 4)
+		require.NoError(t, b.Put(ctx, "key", strings.NewReader("hello")))
+
+		assert.Equal(t, "hello", readAll(t, b, "key"))
+		assert.Equal(t, 0, cacheFiles(t, dir))
+		assert.Equal(t, "hello", readAll(t, b, "key"))
+		assert.Equal(t, []string{"key", "key"}, backendGets(recorder))
+	})
+	t.Run("ReusesExistingCacheDirectory", func(t *testing.T) {
+		dir := t.TempDir()
+		s3b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(newMockS3Client(), "prefix")}
+		require.NoError(t, s3b.Put(ctx, "key", strings.NewReader("hello")))
+
+		first := NewDiskCachingBucket(s3b, dir, 1024)
+		assert.Equal(t, "hello", readAll(t, first, "key"))
+
+		recorder, recording := NewRecordingBucket(s3b)
+		second := NewDiskCachingBucket(recording, dir, 1024)
+		assert.Equal(t, "hello", readAll(t, second, "key"))
+		assert.Empty(t, backendGets(recorder))
+	})
+	t.Run("EvictsByRecordedAccessTime", func(t *testing.T) {
+		dir := t.TempDir()
+		s3b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(newMockS3Client(), "prefix")}
+		for _, key := range []string{"old", "new", "nxt"} {
+			require.NoError(t, s3b.Put(ctx, key, strings.NewReader(key+"!")))
+		}
+
+		first := NewDiskCachingBucket(s3b, dir, 8)
+		assert.Equal(t, "old!", readAll(t, first, "old"))
+		assert.Equal(t, "new!", readAll(t, first, "new"))
+		assert.Equal(t, "old!", readAll(t, first, "old"))
+
+		recorder, recording := NewRecordingBucket(s3b)
+		second := NewDiskCachingBucket(recording, dir, 8)
+		assert.Equal(t, "nxt!", readAll(t, second, "nxt"))
+		assert.Equal(t, "old!", readAll(t, second, "old"))
+		assert.Equal(t, "new!", readAll(t, second, "new"))
+		assert.Equal(t, []string{"nxt", "new"}, backendGets(recorder))
+	})
+	t.Run("SharedDirectoryKeepsEveryProcessAccesses", func(t *testing.T) {
+		dir := t.TempDir()
+		s3b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(newMockS3Client(), "prefix")}
+		for _, key := range []string{"a", "b", "c"} {
+			require.NoError(t, s3b.Put(ctx, key, strings.NewReader(key+key+key+key)))
+		}
+
+		// Both caches are open at once, so neither reloads the
+		// other's accesses from the directory.
+		first := NewDiskCachingBucket(s3b, dir, 8)
+		second := NewDiskCachingBucket(s3b, dir, 8)
+		assert.Equal(t, "aaaa", readAll(t, first, "a"))
+		assert.Equal(t, "bbbb", readAll(t, second, "b"))
+		assert.Equal(t, "aaaa", readAll(t, first, "a"))
+
+		recorder, recording := NewRecordingBucket(s3b)
+		third := NewDiskCachingBucket(recording, dir, 8)
+		assert.Equal(t, "cccc", readAll(t, third, "c"))
+		assert.Equal(t, "aaaa", readAll(t, third, "a"))
+		assert.Equal(t, "bbbb", readAll(t, third, "b"))
+		assert.Equal(t, []string{"c", "b"}, backendGets(recorder))
+	})
+	t.Run("ChecksHashWithoutListing", func(t *testing.T) {
+		recorder, b := setup(t, t.TempDir(), 1024)
+		require.NoError(t, b.Put(ctx, "key", strings.NewReader("hello")))
+
+		assert.Equal(t, "hello", readAll(t, b, "key"))
+		assert.Equal(t, "hello", readAll(t, b, "key"))
+		for _, call := range recorder.Calls() {
+			assert.NotEqual(t, "List", call.Method)
+		}
+	})
+}
@@ -1,17 +1,31 @@
 package pail
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/pkg/errors"
 )
 
+// ErrNotExist is matched, as by errors.Is, by the errors that buckets return
+// when a key does not exist, regardless of the backend, so that callers can
+// distinguish a missing object from other failures.
+var ErrNotExist = errors.New("key does not exist")
+
 type keyNotFoundError struct {
 	msg string
+	// cause, if set, is the backend's error for the missing key.
+	cause error
 }
 
 func (e *keyNotFoundError) Error() string { return e.msg }
 
+// Is reports whether the target is ErrNotExist.
+func (e *keyNotFoundError) Is(target error) bool { return target == ErrNotExist }
+
+// Unwrap returns the backend's error for the missing key, if any.
+func (e *keyNotFoundError) Unwrap() error { return e.cause }
+
 // NewKeyNotFoundError creates a new error object to represent a key not found
 // error.
 func NewKeyNotFoundError(msg string) error { return &keyNotFoundError{msg: msg} }
@@ -29,7 +43,7 @@ func MakeKeyNotFoundError(err error) error {
 		return nil
 	}
 
-	return NewKeyNotFoundError(err.Error())
+	return &keyNotFoundError{msg: err.Error(), cause: err}
 }
 
 // IsKeyNotFoundError checks an error object to see if it is a key not found
@@ -42,3 +56,78 @@ func IsKeyNotFoundError(err error) bool {
 	_, ok := errors.Cause(err).(*keyNotFoundError)
 	return ok
 }
+
+// IsKeyNotFound returns whether the error is, or wraps, an error for a key
+// that does not exist. Unlike IsKeyNotFoundError, it finds errors wrapped with
+// fmt.Errorf's %w verb as well as with this package's errors.
+func IsKeyNotFound(err error) bool {
+	return errors.Is(err, ErrNotExist)
+}
+
+// ErrObjectTooLarge is returned when an object exceeds the size limit given to
+// GetLimited.
+var ErrObjectTooLarge = errors.New("object exceeds size limit")
+
+// ErrObjectLocked is returned when an object cannot be modified or removed
+// because it is under an Object Lock retention period or legal hold.
+var ErrObjectLocked = errors.New("object is locked")
+
+// ErrObjectArchived is returned when an object cannot be copied because it is
+// archived, such as in the GLACIER or DEEP_ARCHIVE storage classes, and has
+// not been restored.
+var ErrObjectArchived = errors.New("object is archived and must be restored")
+
+// ErrAlreadyExists is returned when an operation that must not overwrite an
+// existing object, such as a Copy with IfNotExists set, targets a key that
+// already exists.
+var ErrAlreadyExists = errors.New("object already exists")
+
+// ErrPreconditionFailed is returned when a conditional operation, such as
+// RemoveIfMatch, is refused because the object changed.
+var ErrPreconditionFailed = errors.New("precondition failed")
+
+// ErrInvalidKey is returned when an object cannot be written because its key
+// is not valid for the bucket, such as a key that is too long.
+var ErrInvalidKey = errors.New("invalid object key")
+
+// ErrChecksumMismatch is returned when the checksum of an object does not
+// match the checksum computed from its data, such as when S3 stores an upload
+// with an ETag other than the one computed from the uploaded data.
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
+// ErrRangeNotSatisfiable is returned when a range read, such as GetRange,
+// starts at or beyond the end of the object.
+var ErrRangeNotSatisfiable = errors.New("range not satisfiable")
+
+// ErrNotSupported is returned when a bucket cannot perform an operation on a
+// particular object, such as seeking within a compressed object.
+var ErrNotSupported = errors.New("operation not supported")
+
+// PartialSyncError is returned by Push and Pull when their context is canceled
+// before the sync completes. It records the remote keys of the objects that
+// were already synced, either because they were transferred or because they
+// were already up to date, so that a subsequent sync can skip them.
+type PartialSyncError struct {
+	// Completed are the remote keys of the objects that were synced before
+	// the sync was canceled.
+	Completed []string
+	// Err is the error that stopped the sync.
+	Err error
+}
+
+func (e *PartialSyncError) Error() string {
+	return fmt.Sprintf("sync canceled after completing %d keys: %s", len(e.Completed), e.Err)
+}
+
+// Unwrap returns the error that stopped the sync.
+func (e *PartialSyncError) Unwrap() error { return e.Err }
+
+// partialSyncError returns a PartialSyncError wrapping the given error if the
+// sync's context is done, and otherwise returns the error unchanged.
+func partialSyncError(ctx context.Context, err error, completed []string) error {
+	if err == nil || ctx.Err() == nil {
+		return err
+	}
+
+	return &PartialSyncError{Completed: completed, Err: err}
+}
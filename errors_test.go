@@ -2,6 +2,7 @@ package pail
 
 import (
 	"errors"
+	"fmt"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -15,3 +16,14 @@ func TestKeyNotFoundError(t *testing.T) {
 	assert.True(t, IsKeyNotFoundError(NewKeyNotFoundErrorf("err %s", "err")))
 	assert.True(t, IsKeyNotFoundError(MakeKeyNotFoundError(errors.New("err"))))
 }
+
+func TestErrNotExist(t *testing.T) {
+	cause := errors.New("NoSuchKey")
+	err := fmt.Errorf("getting key: %w", MakeKeyNotFoundError(cause))
+	assert.True(t, errors.Is(err, ErrNotExist))
+	assert.True(t, errors.Is(err, cause))
+	assert.True(t, IsKeyNotFound(err))
+	assert.True(t, IsKeyNotFound(NewKeyNotFoundError("err")))
+	assert.False(t, IsKeyNotFound(errors.New("err")))
+	assert.False(t, IsKeyNotFound(nil))
+}
@@ -0,0 +1,17 @@
+package pail
+
+import "context"
+
+// ExistsMany checks whether each of the given keys exists in the bucket using
+// the given number of concurrent workers. The returned map contains an entry
+// for each key whose existence could be determined; errors checking the
+// remaining keys are accumulated and returned. Buckets that implement
+// ExistsManyBucket check the keys themselves; otherwise, each key is checked
+// with Exists.
+func ExistsMany(ctx context.Context, b Bucket, keys []string, workers int) (map[string]bool, error) {
+	if eb, ok := b.(ExistsManyBucket); ok {
+		return eb.ExistsMany(ctx, keys, workers)
+	}
+
+	return existsManyHelper(ctx, b.Exists, keys, workers)
+}
@@ -0,0 +1,700 @@
+package pail
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
+	"github.com/pkg/errors"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+	"google.golang.org/api/option"
+)
+
+// GCSOptions support the use and creation of Google Cloud Storage backed
+// buckets.
+type GCSOptions struct {
+	// Name is the name of the bucket.
+	Name string
+	// Prefix is prepended to the keys of the objects in the bucket.
+	// (Optional)
+	Prefix string
+	// CredentialsFile is the path to the JSON file of a service account's
+	// credentials. If not set, the application default credentials are
+	// used. (Optional)
+	CredentialsFile string
+	// Compress gzip-compresses objects as they are uploaded, setting their
+	// content encoding so that they are decompressed as they are read.
+	// Objects that are not compressed can still be read. (Optional)
+	Compress     bool
+	DryRun       bool
+	DeleteOnSync bool
+	DeleteOnPush bool
+	DeleteOnPull bool
+	Verbose      bool
+}
+
+func (o *GCSOptions) validate() error {
+	if o.Name == "" {
+		return errors.New("must specify a bucket name")
+	}
+	if (o.DeleteOnPush != o.DeleteOnPull) && o.DeleteOnSync {
+		return errors.New("ambiguous delete on sync options set")
+	}
+
+	return nil
+}
+
+type gcsBucket struct {
+	opts   GCSOptions
+	client gcsClient
+}
+
+// NewGCSBucket returns a bucket backed by Google Cloud Storage with the given
+// options.
+func NewGCSBucket(ctx context.Context, opts GCSOptions) (Bucket, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	var clientOpts []option.ClientOption
+	if opts.CredentialsFile != "" {
+		clientOpts = append(clientOpts, option.WithCredentialsFile(opts.CredentialsFile))
+	}
+	client, err := storage.NewClient(ctx, clientOpts...)
+	if err != nil {
+		return nil, errors.Wrap(err, "constructing client")
+	}
+
+	return &gcsBucket{opts: opts, client: &gcsStorageClient{client: client}}, nil
+}
+
+func (b *gcsBucket) normalizeKey(key string) string { return b.Join(b.opts.Prefix, key) }
+
+// normalizeListPrefix returns the prefix of the object names that have the
+// given prefix. Unlike normalizeKey, it keeps a trailing slash, and an empty
+// prefix only matches the objects under the bucket's prefix.
+func (b *gcsBucket) normalizeListPrefix(prefix string) string {
+	normalized := b.normalizeKey(prefix)
+	if normalized != "" && (strings.HasSuffix(prefix, "/") || prefix == "") {
+		normalized += "/"
+	}
+	return normalized
+}
+
+func (b *gcsBucket) denormalizeKey(key string) string {
+	return consistentTrimPrefix(key, b.opts.Prefix)
+}
+
+func (b *gcsBucket) contentEncoding() string {
+	if b.opts.Compress {
+		return string(CompressionGzip)
+	}
+	return ""
+}
+
+func (b *gcsBucket) Check(ctx context.Context) error {
+	return errors.Wrap(b.client.checkBucket(ctx, b.opts.Name), "checking bucket")
+}
+
+func (b *gcsBucket) Ping(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	if err := b.client.checkBucket(ctx, b.opts.Name); err != nil {
+		return 0, errors.Wrap(err, "checking bucket")
+	}
+
+	return time.Since(start), nil
+}
+
+func (b *gcsBucket) Exists(ctx context.Context, key string) (bool, error) {
+	if _, err := b.client.attrs(ctx, b.opts.Name, b.normalizeKey(key)); err == storage.ErrObjectNotExist {
+		return false, nil
+	} else if err != nil {
+		return false, errors.Wrap(err, "getting object attributes")
+	}
+
+	return true, nil
+}
+
+func (b *gcsBucket) Join(elems ...string) string { return consistentJoin(elems) }
+
+func (b *gcsBucket) URI(key string) string { return "gs://" + b.opts.Name + "/" + b.normalizeKey(key) }
+
+func (b *gcsBucket) Writer(ctx context.Context, key string) (io.WriteCloser, error) {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "gcs",
+		"dry_run":       b.opts.DryRun,
+		"operation":     "writer",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"key":           key,
+		"compress":      b.opts.Compress,
+	})
+
+	if b.opts.DryRun {
+		return &mockWriteCloser{ctx: ctx}, nil
+	}
+
+	// The object is not replaced until the writer is closed, and the
+	// upload is abandoned if the context is canceled before then, which
+	// the writer does itself if a write fails.
+	ctx, cancel := context.WithCancel(ctx)
+	var w io.WriteCloser = b.client.newWriter(ctx, b.opts.Name, b.normalizeKey(key), b.contentEncoding())
+	if b.opts.Compress {
+		w = &compressingWriteCloser{compressor: gzip.NewWriter(w), s3Writer: w}
+	}
+
+	return &gcsWriteCloser{WriteCloser: w, cancel: cancel}, nil
+}
+
+// gcsWriteCloser abandons its upload when it is closed after a write fails,
+// so that a failed write does not replace the object with the data written
+// before the failure.
+type gcsWriteCloser struct {
+	io.WriteCloser
+	cancel   context.CancelFunc
+	writeErr error
+}
+
+func (w *gcsWriteCloser) Write(p []byte) (int, error) {
+	if w.writeErr != nil {
+		return 0, w.writeErr
+	}
+	n, err := w.WriteCloser.Write(p)
+	if err != nil {
+		w.writeErr = err
+	}
+	return n, err
+}
+
+func (w *gcsWriteCloser) Close() error {
+	defer w.cancel()
+	if w.writeErr != nil {
+		w.cancel()
+		_ = w.WriteCloser.Close()
+		return errors.Wrap(w.writeErr, "abandoning upload after failed write")
+	}
+	return w.WriteCloser.Close()
+}
+
+func (b *gcsBucket) Reader(ctx context.Context, key string) (io.ReadCloser, error) {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "gcs",
+		"operation":     "reader",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"key":           key,
+	})
+
+	body, contentEncoding, err := b.client.newReader(ctx, b.opts.Name, b.normalizeKey(key))
+	if err != nil {
+		if err == storage.ErrObjectNotExist {
+			err = MakeKeyNotFoundError(err)
+		}
+		return nil, errors.Wrapf(err, "reading key '%s'", key)
+	}
+
+	// Objects are read as they are stored, so that objects compressed by
+	// other buckets can be read regardless of whether this bucket
+	// compresses objects.
+	if contentEncoding != string(CompressionGzip) {
+		return body, nil
+	}
+	gz, err := gzip.NewReader(body)
+	if err != nil {
+		_ = body.Close()
+		return nil, errors.Wrap(err, "creating gzip reader")
+	}
+
+	return &gzipReadCloser{Reader: gz, body: body}, nil
+}
+
+func (b *gcsBucket) Put(ctx context.Context, key string, input io.Reader) error {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "gcs",
+		"dry_run":       b.opts.DryRun,
+		"operation":     "put",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"key":           key,
+	})
+
+	// Canceling the writer's context before closing it abandons the
+	// upload, so a failed read does not replace the object with the data
+	// read before the failure.
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	w, err := b.Writer(writeCtx, key)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	if _, err = io.Copy(w, input); err != nil {
+		cancel()
+		_ = w.Close()
+		return errors.Wrap(err, "copying data")
+	}
+
+	return errors.Wrap(w.Close(), "closing writer")
+}
+
+func (b *gcsBucket) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "gcs",
+		"operation":     "get",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"key":           key,
+	})
+
+	return b.Reader(ctx, key)
+}
+
+func (b *gcsBucket) Upload(ctx context.Context, key, path string) error {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "gcs",
+		"dry_run":       b.opts.DryRun,
+		"operation":     "upload",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"key":           key,
+		"path":          path,
+	})
+
+	f, err := openUploadFile(ctx, path)
+	if err != nil {
+		return errors.Wrapf(err, "opening file '%s'", path)
+	}
+	defer f.Close()
+
+	return errors.WithStack(b.Put(ctx, key, f))
+}
+
+func (b *gcsBucket) Download(ctx context.Context, key, path string) error {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "gcs",
+		"operation":     "download",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"key":           key,
+		"path":          path,
+	})
+
+	reader, err := b.Reader(ctx, key)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer reader.Close()
+
+	if err = os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrapf(err, "creating enclosing directory for file '%s'", path)
+	}
+
+	f, err := os.Create(path)
+	if err != nil {
+		return errors.Wrapf(err, "creating file '%s'", path)
+	}
+
+	catcher := grip.NewBasicCatcher()
+	_, err = io.Copy(f, reader)
+	catcher.Wrap(err, "copying data to file")
+	catcher.Wrap(f.Close(), "closing file")
+
+	return catcher.Resolve()
+}
+
+func (b *gcsBucket) Push(ctx context.Context, opts SyncOptions) error {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "gcs",
+		"dry_run":       b.opts.DryRun,
+		"operation":     "push",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"remote":        opts.Remote,
+		"local":         opts.Local,
+		"exclude":       opts.Exclude,
+	})
+
+	var re *regexp.Regexp
+	var err error
+	if opts.Exclude != "" {
+		re, err = regexp.Compile(opts.Exclude)
+		if err != nil {
+			return errors.Wrap(err, "compiling exclude regex")
+		}
+	}
+
+	localPaths, err := walkLocalTree(ctx, opts.Local)
+	if err != nil {
+		return errors.Wrap(err, "finding local paths")
+	}
+
+	var completed []string
+	for _, path := range localPaths {
+		if err = ctx.Err(); err != nil {
+			return partialSyncError(ctx, errors.WithStack(err), completed)
+		}
+		if re != nil && re.MatchString(path) {
+			continue
+		}
+
+		target := b.Join(opts.Remote, path)
+		if err = b.Upload(ctx, target, filepath.Join(opts.Local, path)); err != nil {
+			if skipVanishedFile(opts, filepath.Join(opts.Local, path), err) {
+				continue
+			}
+			return partialSyncError(ctx, errors.Wrapf(err, "uploading file '%s' to '%s'", path, target), completed)
+		}
+		completed = append(completed, target)
+	}
+
+	if (b.opts.DeleteOnPush || b.opts.DeleteOnSync || opts.Mirror) && !b.opts.DryRun {
+		return errors.Wrap(deleteOnPush(ctx, localPaths, opts.Remote, b), "deleting on sync after push")
+	}
+
+	return nil
+}
+
+func (b *gcsBucket) Pull(ctx context.Context, opts SyncOptions) error {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "gcs",
+		"operation":     "pull",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"remote":        opts.Remote,
+		"local":         opts.Local,
+		"exclude":       opts.Exclude,
+	})
+
+	var re *regexp.Regexp
+	var err error
+	if opts.Exclude != "" {
+		re, err = regexp.Compile(opts.Exclude)
+		if err != nil {
+			return errors.Wrap(err, "compiling exclude regex")
+		}
+	}
+
+	iter, err := b.List(ctx, dirPrefix(opts.Remote))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	keys := []string{}
+	var completed []string
+	for iter.Next(ctx) {
+		if err = ctx.Err(); err != nil {
+			return partialSyncError(ctx, errors.WithStack(err), completed)
+		}
+		item := iter.Item()
+		if re != nil && re.MatchString(item.Name()) {
+			continue
+		}
+
+		localName, err := filepath.Rel(opts.Remote, item.Name())
+		if err != nil {
+			return errors.Wrap(err, "getting relative filepath")
+		}
+		keys = append(keys, localName)
+
+		if err = b.Download(ctx, item.Name(), filepath.Join(opts.Local, localName)); err != nil {
+			return partialSyncError(ctx, errors.WithStack(err), completed)
+		}
+		completed = append(completed, item.Name())
+	}
+
+	if err = iter.Err(); err != nil {
+		return partialSyncError(ctx, errors.WithStack(err), completed)
+	}
+
+	if (b.opts.DeleteOnPull || b.opts.DeleteOnSync) && !b.opts.DryRun {
+		return errors.Wrap(deleteOnPull(ctx, keys, opts.Local), "deleting on sync after pull")
+	}
+
+	return nil
+}
+
+func (b *gcsBucket) Copy(ctx context.Context, opts CopyOptions) error {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "gcs",
+		"dry_run":       b.opts.DryRun,
+		"operation":     "copy",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"source_key":    opts.SourceKey,
+		"dest_key":      opts.DestinationKey,
+		"if_not_exists": opts.IfNotExists,
+	})
+
+	// Objects are copied within GCS when the destination is also a GCS
+	// bucket, which keeps their content encoding, and are otherwise
+	// streamed through the client. Like S3 buckets, the source forwards
+	// the copy to the destination with the source key qualified by the
+	// source bucket's name and prefix.
+	if !opts.IsDestination {
+		if _, ok := opts.DestinationBucket.(*gcsBucket); !ok {
+			return streamCopy(ctx, b, opts)
+		}
+		opts.IsDestination = true
+		opts.SourceKey = b.Join(b.opts.Name, b.normalizeKey(opts.SourceKey))
+		return opts.DestinationBucket.Copy(ctx, opts)
+	}
+
+	if b.opts.DryRun {
+		return nil
+	}
+
+	srcBucket, srcKey, ok := strings.Cut(opts.SourceKey, "/")
+	if !ok {
+		return errors.Errorf("copy source '%s' does not name a bucket", opts.SourceKey)
+	}
+	err := b.client.copy(ctx, srcBucket, srcKey, b.opts.Name, b.normalizeKey(opts.DestinationKey), opts.IfNotExists)
+	if err == storage.ErrObjectNotExist {
+		err = MakeKeyNotFoundError(err)
+	}
+	if opts.IfNotExists && isGCSPreconditionFailed(err) {
+		return errors.Wrapf(ErrAlreadyExists, "copying to key '%s': %s", opts.DestinationKey, err)
+	}
+	return errors.Wrapf(err, "copying key '%s' to '%s'", opts.SourceKey, opts.DestinationKey)
+}
+
+// isGCSPreconditionFailed returns whether the error is GCS refusing a
+// conditional request because its precondition does not hold.
+func isGCSPreconditionFailed(err error) bool {
+	var apiErr *googleapi.Error
+	return errors.As(err, &apiErr) && apiErr.Code == http.StatusPreconditionFailed
+}
+
+func (b *gcsBucket) Remove(ctx context.Context, key string) error {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "gcs",
+		"dry_run":       b.opts.DryRun,
+		"operation":     "remove",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"key":           key,
+	})
+
+	return b.RemoveMany(ctx, key)
+}
+
+func (b *gcsBucket) RemoveMany(ctx context.Context, keys ...string) error {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "gcs",
+		"dry_run":       b.opts.DryRun,
+		"operation":     "remove many",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"keys":          keys,
+	})
+
+	if b.opts.DryRun {
+		return nil
+	}
+
+	// GCS has no batch delete, so objects are deleted one at a time.
+	// Like the other buckets, removing keys that do not exist is not an
+	// error.
+	catcher := grip.NewBasicCatcher()
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			catcher.Add(errors.WithStack(err))
+			break
+		}
+		if err := b.client.delete(ctx, b.opts.Name, b.normalizeKey(key)); err != nil && err != storage.ErrObjectNotExist {
+			catcher.Wrapf(err, "deleting key '%s'", key)
+		}
+	}
+
+	return catcher.Resolve()
+}
+
+func (b *gcsBucket) RemovePrefix(ctx context.Context, prefix string) error {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "gcs",
+		"dry_run":       b.opts.DryRun,
+		"operation":     "remove prefix",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"prefix":        prefix,
+	})
+
+	return removePrefix(ctx, prefix, b)
+}
+
+func (b *gcsBucket) RemoveMatching(ctx context.Context, expr string) error {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "gcs",
+		"dry_run":       b.opts.DryRun,
+		"operation":     "remove matching",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"expression":    expr,
+	})
+
+	return removeMatching(ctx, expr, b)
+}
+
+func (b *gcsBucket) List(ctx context.Context, prefix string) (BucketIterator, error) {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "gcs",
+		"operation":     "list",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"prefix":        prefix,
+	})
+
+	// GCS lists objects in lexicographic order of their names, which is
+	// the order that iterators must return keys in.
+	return &gcsIterator{bucket: b, iter: b.client.list(ctx, b.opts.Name, b.normalizeListPrefix(prefix))}, nil
+}
+
+type gcsIterator struct {
+	err    error
+	bucket *gcsBucket
+	iter   gcsObjectIterator
+	item   *bucketItemImpl
+}
+
+func (iter *gcsIterator) Err() error       { return iter.err }
+func (iter *gcsIterator) Item() BucketItem { return iter.item }
+func (iter *gcsIterator) Next(ctx context.Context) bool {
+	if iter.err != nil {
+		return false
+	}
+
+	attrs, err := iter.iter.next()
+	if err == iterator.Done {
+		return false
+	} else if err != nil {
+		iter.err = errors.Wrap(err, "listing objects")
+		return false
+	}
+
+	iter.item = &bucketItemImpl{
+		bucket:       iter.bucket.opts.Name,
+		key:          iter.bucket.denormalizeKey(attrs.name),
+		hash:         attrs.hash,
+		lastModified: attrs.updated,
+		size:         attrs.size,
+		b:            iter.bucket,
+	}
+	return true
+}
+
+// gcsObjectAttrs are the attributes of a GCS object that buckets use.
+type gcsObjectAttrs struct {
+	name    string
+	size    int64
+	updated time.Time
+	// hash is the hex-encoded MD5 checksum of the object's data, which
+	// objects composed from other objects do not have.
+	hash string
+}
+
+// gcsObjectIterator iterates over listed objects, returning iterator.Done
+// once there are no more.
+type gcsObjectIterator interface {
+	next() (*gcsObjectAttrs, error)
+}
+
+// gcsClient is the subset of the GCS API that buckets use. Operations on
+// objects that do not exist return storage.ErrObjectNotExist.
+type gcsClient interface {
+	checkBucket(ctx context.Context, bucket string) error
+	attrs(ctx context.Context, bucket, key string) (*gcsObjectAttrs, error)
+	// newWriter returns a writer that replaces the object once it is
+	// closed, with the given content encoding, if any.
+	newWriter(ctx context.Context, bucket, key, contentEncoding string) io.WriteCloser
+	// newReader returns the object's data as it is stored, without
+	// decompressing it, along with its content encoding.
+	newReader(ctx context.Context, bucket, key string) (io.ReadCloser, string, error)
+	// copy copies the object server-side. If ifNotExists is set, the copy
+	// fails with a precondition error if the destination already exists.
+	copy(ctx context.Context, srcBucket, srcKey, destBucket, destKey string, ifNotExists bool) error
+	delete(ctx context.Context, bucket, key string) error
+	list(ctx context.Context, bucket, prefix string) gcsObjectIterator
+}
+
+// gcsStorageClient implements gcsClient with the GCS client library.
+type gcsStorageClient struct {
+	client *storage.Client
+}
+
+func (c *gcsStorageClient) checkBucket(ctx context.Context, bucket string) error {
+	_, err := c.client.Bucket(bucket).Attrs(ctx)
+	return err
+}
+
+func (c *gcsStorageClient) attrs(ctx context.Context, bucket, key string) (*gcsObjectAttrs, error) {
+	attrs, err := c.client.Bucket(bucket).Object(key).Attrs(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return newGCSObjectAttrs(attrs), nil
+}
+
+func (c *gcsStorageClient) newWriter(ctx context.Context, bucket, key, contentEncoding string) io.WriteCloser {
+	w := c.client.Bucket(bucket).Object(key).NewWriter(ctx)
+	w.ContentEncoding = contentEncoding
+	return w
+}
+
+func (c *gcsStorageClient) newReader(ctx context.Context, bucket, key string) (io.ReadCloser, string, error) {
+	// By default, GCS decompresses gzip-encoded objects as they are
+	// served, which would hide whether the data is compressed.
+	r, err := c.client.Bucket(bucket).Object(key).ReadCompressed(true).NewReader(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+	return r, r.Attrs.ContentEncoding, nil
+}
+
+func (c *gcsStorageClient) copy(ctx context.Context, srcBucket, srcKey, destBucket, destKey string, ifNotExists bool) error {
+	src := c.client.Bucket(srcBucket).Object(srcKey)
+	dest := c.client.Bucket(destBucket).Object(destKey)
+	if ifNotExists {
+		dest = dest.If(storage.Conditions{DoesNotExist: true})
+	}
+	_, err := dest.CopierFrom(src).Run(ctx)
+	return err
+}
+
+func (c *gcsStorageClient) delete(ctx context.Context, bucket, key string) error {
+	return c.client.Bucket(bucket).Object(key).Delete(ctx)
+}
+
+func (c *gcsStorageClient) list(ctx context.Context, bucket, prefix string) gcsObjectIterator {
+	return &gcsStorageIterator{iter: c.client.Bucket(bucket).Objects(ctx, &storage.Query{Prefix: prefix})}
+}
+
+type gcsStorageIterator struct {
+	iter *storage.ObjectIterator
+}
+
+func (iter *gcsStorageIterator) next() (*gcsObjectAttrs, error) {
+	attrs, err := iter.iter.Next()
+	if err != nil {
+		return nil, err
+	}
+	return newGCSObjectAttrs(attrs), nil
+}
+
+func newGCSObjectAttrs(attrs *storage.ObjectAttrs) *gcsObjectAttrs {
+	return &gcsObjectAttrs{
+		name:    attrs.Name,
+		size:    attrs.Size,
+		updated: attrs.Updated,
+		hash:    hex.EncodeToString(attrs.MD5),
+	}
+}
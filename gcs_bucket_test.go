@@ -0,0 +1,399 @@
+package pail
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"testing/iotest"
+	"time"
+
+	"cloud.google.com/go/storage"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/api/googleapi"
+	"google.golang.org/api/iterator"
+)
+
+type mockGCSObject struct {
+	data            []byte
+	contentEncoding string
+	updated         time.Time
+}
+
+// mockGCSClient is an in-memory GCS client for testing.
+type mockGCSClient struct {
+	mu      sync.Mutex
+	objects map[string]*mockGCSObject
+	// checkErr, if set, is returned by every bucket check.
+	checkErr  error
+	copyCalls int
+}
+
+func newMockGCSClient() *mockGCSClient {
+	return &mockGCSClient{objects: map[string]*mockGCSObject{}}
+}
+
+func newMockGCSBucket(client *mockGCSClient, opts GCSOptions) *gcsBucket {
+	if opts.Name == "" {
+		opts.Name = "bucket"
+	}
+	return &gcsBucket{opts: opts, client: client}
+}
+
+func mockGCSObjectID(bucket, key string) string { return bucket + "/" + key }
+
+func (c *mockGCSClient) checkBucket(context.Context, string) error { return c.checkErr }
+
+func (c *mockGCSClient) attrs(_ context.Context, bucket, key string) (*gcsObjectAttrs, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	obj, ok := c.objects[mockGCSObjectID(bucket, key)]
+	if !ok {
+		return nil, storage.ErrObjectNotExist
+	}
+	return obj.attrs(key), nil
+}
+
+func (c *mockGCSClient) newWriter(ctx context.Context, bucket, key, contentEncoding string) io.WriteCloser {
+	return &mockGCSWriter{ctx: ctx, client: c, id: mockGCSObjectID(bucket, key), contentEncoding: contentEncoding}
+}
+
+func (c *mockGCSClient) newReader(_ context.Context, bucket, key string) (io.ReadCloser, string, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	obj, ok := c.objects[mockGCSObjectID(bucket, key)]
+	if !ok {
+		return nil, "", storage.ErrObjectNotExist
+	}
+	return io.NopCloser(bytes.NewReader(obj.data)), obj.contentEncoding, nil
+}
+
+func (c *mockGCSClient) copy(_ context.Context, srcBucket, srcKey, destBucket, destKey string, ifNotExists bool) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.copyCalls++
+	obj, ok := c.objects[mockGCSObjectID(srcBucket, srcKey)]
+	if !ok {
+		return storage.ErrObjectNotExist
+	}
+	if _, exists := c.objects[mockGCSObjectID(destBucket, destKey)]; exists && ifNotExists {
+		return &googleapi.Error{Code: http.StatusPreconditionFailed, Message: "At least one of the pre-conditions you specified did not hold."}
+	}
+	copied := *obj
+	copied.updated = time.Now()
+	c.objects[mockGCSObjectID(destBucket, destKey)] = &copied
+	return nil
+}
+
+func (c *mockGCSClient) delete(_ context.Context, bucket, key string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id := mockGCSObjectID(bucket, key)
+	if _, ok := c.objects[id]; !ok {
+		return storage.ErrObjectNotExist
+	}
+	delete(c.objects, id)
+	return nil
+}
+
+func (c *mockGCSClient) list(_ context.Context, bucket, prefix string) gcsObjectIterator {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var listed []*gcsObjectAttrs
+	for id, obj := range c.objects {
+		key := strings.TrimPrefix(id, bucket+"/")
+		if key == id || !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		listed = append(listed, obj.attrs(key))
+	}
+	sort.Slice(listed, func(i, j int) bool { return listed[i].name < listed[j].name })
+	return &mockGCSIterator{objects: listed}
+}
+
+func (obj *mockGCSObject) attrs(key string) *gcsObjectAttrs {
+	sum := md5.Sum(obj.data)
+	return &gcsObjectAttrs{
+		name:    key,
+		size:    int64(len(obj.data)),
+		updated: obj.updated,
+		hash:    hex.EncodeToString(sum[:]),
+	}
+}
+
+type mockGCSWriter struct {
+	ctx             context.Context
+	client          *mockGCSClient
+	id              string
+	contentEncoding string
+	buf             bytes.Buffer
+}
+
+func (w *mockGCSWriter) Write(p []byte) (int, error) { return w.buf.Write(p) }
+
+func (w *mockGCSWriter) Close() error {
+	if err := w.ctx.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	w.client.mu.Lock()
+	defer w.client.mu.Unlock()
+
+	w.client.objects[w.id] = &mockGCSObject{
+		data:            append([]byte(nil), w.buf.Bytes()...),
+		contentEncoding: w.contentEncoding,
+		updated:         time.Now(),
+	}
+	return nil
+}
+
+type mockGCSIterator struct {
+	objects []*gcsObjectAttrs
+}
+
+func (iter *mockGCSIterator) next() (*gcsObjectAttrs, error) {
+	if len(iter.objects) == 0 {
+		return nil, iterator.Done
+	}
+	next := iter.objects[0]
+	iter.objects = iter.objects[1:]
+	return next, nil
+}
+
+func TestGCSBucket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	t.Run("ValidatesOptions", func(t *testing.T) {
+		_, err := NewGCSBucket(ctx, GCSOptions{})
+		assert.Error(t, err)
+		_, err = NewGCSBucket(ctx, GCSOptions{Name: "bucket", DeleteOnSync: true, DeleteOnPush: true})
+		assert.Error(t, err)
+	})
+	t.Run("RoundTrip", func(t *testing.T) {
+		client := newMockGCSClient()
+		b := newMockGCSBucket(client, GCSOptions{Prefix: "prefix"})
+		require.NoError(t, writeDataToFile(ctx, b, "key", "some data"))
+
+		assert.Contains(t, client.objects, "bucket/prefix/key")
+		data, err := readDataFromFile(ctx, b, "key")
+		require.NoError(t, err)
+		assert.Equal(t, "some data", data)
+
+		exists, err := b.Exists(ctx, "key")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		exists, err = b.Exists(ctx, "missing")
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+	t.Run("MissingKeyIsNotFound", func(t *testing.T) {
+		b := newMockGCSBucket(newMockGCSClient(), GCSOptions{})
+		_, err := b.Get(ctx, "missing")
+		assert.True(t, IsKeyNotFound(err))
+		assert.True(t, errors.Is(err, ErrNotExist))
+
+		err = b.Copy(ctx, CopyOptions{SourceKey: "missing", DestinationKey: "key", DestinationBucket: b})
+		assert.True(t, IsKeyNotFound(err))
+	})
+	t.Run("CheckReportsErrors", func(t *testing.T) {
+		client := newMockGCSClient()
+		b := newMockGCSBucket(client, GCSOptions{})
+		require.NoError(t, b.Check(ctx))
+		_, err := b.Ping(ctx)
+		require.NoError(t, err)
+
+		client.checkErr = errors.New("bucket does not exist")
+		assert.Error(t, b.Check(ctx))
+		_, err = b.Ping(ctx)
+		assert.Error(t, err)
+	})
+	t.Run("CompressesObjects", func(t *testing.T) {
+		client := newMockGCSClient()
+		b := newMockGCSBucket(client, GCSOptions{Compress: true})
+		data := strings.Repeat("compressible ", 100)
+		require.NoError(t, writeDataToFile(ctx, b, "key", data))
+
+		obj := client.objects["bucket/key"]
+		require.NotNil(t, obj)
+		assert.Equal(t, "gzip", obj.contentEncoding)
+		assert.Less(t, len(obj.data), len(data))
+		gz, err := gzip.NewReader(bytes.NewReader(obj.data))
+		require.NoError(t, err)
+		decompressed, err := io.ReadAll(gz)
+		require.NoError(t, err)
+		assert.Equal(t, data, string(decompressed))
+
+		stored, err := readDataFromFile(ctx, b, "key")
+		require.NoError(t, err)
+		assert.Equal(t, data, stored)
+
+		uncompressed := newMockGCSBucket(client, GCSOptions{})
+		stored, err = readDataFromFile(ctx, uncompressed, "key")
+		require.NoError(t, err)
+		assert.Equal(t, data, stored, "compressed objects should be readable by any bucket")
+	})
+	t.Run("CopiesWithinGCS", func(t *testing.T) {
+		client := newMockGCSClient()
+		src := newMockGCSBucket(client, GCSOptions{Name: "src", Prefix: "a", Compress: true})
+		dest := newMockGCSBucket(client, GCSOptions{Name: "dest", Prefix: "b"})
+		require.NoError(t, writeDataToFile(ctx, src, "key", "some data"))
+
+		require.NoError(t, src.Copy(ctx, CopyOptions{SourceKey: "key", DestinationKey: "copy", DestinationBucket: dest}))
+		assert.Equal(t, 1, client.copyCalls)
+		assert.Equal(t, "gzip", client.objects["dest/b/copy"].contentEncoding)
+		data, err := readDataFromFile(ctx, dest, "copy")
+		require.NoError(t, err)
+		assert.Equal(t, "some data", data)
+
+		require.NoError(t, writeDataToFile(ctx, src, "key", "other data"))
+		err = src.Copy(ctx, CopyOptions{SourceKey: "key", DestinationKey: "copy", DestinationBucket: dest, IfNotExists: true})
+		assert.True(t, errors.Is(err, ErrAlreadyExists))
+		assert.Equal(t, 2, client.copyCalls, "the destination should be checked by the copy itself")
+		data, err = readDataFromFile(ctx, dest, "copy")
+		require.NoError(t, err)
+		assert.Equal(t, "some data", data)
+
+		require.NoError(t, src.Copy(ctx, CopyOptions{SourceKey: "key", DestinationKey: "new", DestinationBucket: dest, IfNotExists: true}))
+		data, err = readDataFromFile(ctx, dest, "new")
+		require.NoError(t, err)
+		assert.Equal(t, "other data", data)
+	})
+	t.Run("CopiesForwardedByOtherBuckets", func(t *testing.T) {
+		// An S3 bucket forwards copies to the destination with the
+		// source key qualified by its bucket name and prefix, which
+		// the destination must not qualify again.
+		client := newMockGCSClient()
+		client.objects["bucket/prefix/key"] = &mockGCSObject{data: []byte("some data")}
+		dest := newMockGCSBucket(client, GCSOptions{Name: "dest", Prefix: "b"})
+		src := &s3BucketSmall{s3Bucket: *newMockS3Bucket(newMockS3Client(), "prefix")}
+
+		require.NoError(t, src.Copy(ctx, CopyOptions{SourceKey: "key", DestinationKey: "copy", DestinationBucket: dest}))
+		assert.Equal(t, 1, client.copyCalls)
+		data, err := readDataFromFile(ctx, dest, "copy")
+		require.NoError(t, err)
+		assert.Equal(t, "some data", data)
+	})
+	t.Run("CopiesToOtherBuckets", func(t *testing.T) {
+		client := newMockGCSClient()
+		b := newMockGCSBucket(client, GCSOptions{Compress: true})
+		require.NoError(t, writeDataToFile(ctx, b, "key", "some data"))
+		local, err := NewLocalBucket(LocalOptions{Path: t.TempDir()})
+		require.NoError(t, err)
+
+		require.NoError(t, b.Copy(ctx, CopyOptions{SourceKey: "key", DestinationKey: "copy", DestinationBucket: local}))
+		assert.Zero(t, client.copyCalls)
+		data, err := readDataFromFile(ctx, local, "copy")
+		require.NoError(t, err)
+		assert.Equal(t, "some data", data)
+	})
+	t.Run("RemoveManyIgnoresMissingKeys", func(t *testing.T) {
+		client := newMockGCSClient()
+		b := newMockGCSBucket(client, GCSOptions{Prefix: "prefix"})
+		require.NoError(t, writeDataToFile(ctx, b, "a", "data"))
+		require.NoError(t, writeDataToFile(ctx, b, "b", "data"))
+		require.NoError(t, writeDataToFile(ctx, b, "c", "data"))
+
+		require.NoError(t, b.RemoveMany(ctx, "a", "b", "missing"))
+		assert.Equal(t, []string{"c"}, listNames(ctx, t, b, ""))
+		require.NoError(t, b.Remove(ctx, "c"))
+		assert.Empty(t, client.objects)
+	})
+	t.Run("DryRunDoesNotModifyBucket", func(t *testing.T) {
+		client := newMockGCSClient()
+		client.objects["bucket/key"] = &mockGCSObject{data: []byte("data")}
+		b := newMockGCSBucket(client, GCSOptions{DryRun: true})
+
+		require.NoError(t, writeDataToFile(ctx, b, "new", "data"))
+		require.NoError(t, b.Copy(ctx, CopyOptions{SourceKey: "key", DestinationKey: "copy", DestinationBucket: b}))
+		require.NoError(t, b.Remove(ctx, "key"))
+		assert.Len(t, client.objects, 1)
+		assert.Contains(t, client.objects, "bucket/key")
+	})
+	t.Run("CanceledWriterDoesNotUpload", func(t *testing.T) {
+		client := newMockGCSClient()
+		b := newMockGCSBucket(client, GCSOptions{Compress: true})
+		tctx, tcancel := context.WithCancel(ctx)
+		w, err := b.Writer(tctx, "key")
+		require.NoError(t, err)
+		_, err = w.Write([]byte("data"))
+		require.NoError(t, err)
+		tcancel()
+
+		assert.True(t, errors.Is(w.Close(), context.Canceled))
+		assert.Empty(t, client.objects)
+	})
+	t.Run("FailedPutDoesNotUpload", func(t *testing.T) {
+		client := newMockGCSClient()
+		b := newMockGCSBucket(client, GCSOptions{})
+		require.NoError(t, b.Put(ctx, "key", strings.NewReader("original")))
+
+		err := b.Put(ctx, "key", io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errors.New("connection reset"))))
+		require.Error(t, err)
+		assert.Equal(t, []byte("original"), client.objects["bucket/key"].data)
+	})
+	t.Run("ListsItemsWithAttributes", func(t *testing.T) {
+		b := newMockGCSBucket(newMockGCSClient(), GCSOptions{Prefix: "prefix"})
+		require.NoError(t, writeDataToFile(ctx, b, "dir/key", "some data"))
+
+		iter, err := b.List(ctx, "dir/")
+		require.NoError(t, err)
+		require.True(t, iter.Next(ctx))
+		item := iter.Item()
+		sum := md5.Sum([]byte("some data"))
+		assert.Equal(t, "dir/key", item.Name())
+		assert.Equal(t, "bucket", item.Bucket())
+		assert.Equal(t, hex.EncodeToString(sum[:]), item.Hash())
+		assert.False(t, itemLastModified(item).IsZero())
+		sized, ok := item.(SizedBucketItem)
+		require.True(t, ok)
+		assert.EqualValues(t, len("some data"), sized.Size())
+		assert.False(t, iter.Next(ctx))
+		assert.NoError(t, iter.Err())
+	})
+	t.Run("EmptyPrefixOnlyListsBucketPrefix", func(t *testing.T) {
+		client := newMockGCSClient()
+		client.objects["bucket/prefix-other/key"] = &mockGCSObject{data: []byte("data")}
+		client.objects["bucket/prefixed"] = &mockGCSObject{data: []byte("data")}
+		b := newMockGCSBucket(client, GCSOptions{Prefix: "prefix"})
+		require.NoError(t, writeDataToFile(ctx, b, "key", "data"))
+
+		assert.Equal(t, []string{"key"}, listNames(ctx, t, b, ""))
+	})
+	t.Run("PushAndPull", func(t *testing.T) {
+		b := newMockGCSBucket(newMockGCSClient(), GCSOptions{Prefix: "prefix", Compress: true})
+		local := t.TempDir()
+		files := map[string]string{"a": "data a", filepath.Join("dir", "b"): "data b"}
+		for name, data := range files {
+			require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(local, name)), 0700))
+			require.NoError(t, os.WriteFile(filepath.Join(local, name), []byte(data), 0600))
+		}
+
+		require.NoError(t, b.Push(ctx, SyncOptions{Local: local, Remote: "remote"}))
+		assert.Equal(t, []string{"remote/a", "remote/dir/b"}, listNames(ctx, t, b, "remote/"))
+
+		pulled := t.TempDir()
+		require.NoError(t, b.Pull(ctx, SyncOptions{Local: pulled, Remote: "remote"}))
+		for name, data := range files {
+			stored, err := os.ReadFile(filepath.Join(pulled, name))
+			require.NoError(t, err)
+			assert.Equal(t, data, string(stored))
+		}
+	})
+}
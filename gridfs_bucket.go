@@ -7,6 +7,8 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"strings"
+	"time"
 
 	"github.com/mongodb/grip"
 	"github.com/mongodb/grip/message"
@@ -16,10 +18,37 @@ import (
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/gridfs"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.mongodb.org/mongo-driver/mongo/readpref"
+)
+
+// GridFSReadConsistency determines where a GridFS bucket reads files from,
+// which matters when a file is read soon after it is written.
+type GridFSReadConsistency string
+
+const (
+	// GridFSReadPrimary reads from the primary, so a file is visible as soon
+	// as it is written. This is the default.
+	GridFSReadPrimary GridFSReadConsistency = "primary"
+	// GridFSReadRetryNotFound reads with the read preference of the client
+	// or database, which may read from a secondary that has not yet
+	// replicated a file that was just written, so reads of files that are
+	// not found are retried with backoff.
+	GridFSReadRetryNotFound GridFSReadConsistency = "retry-not-found"
+)
+
+const (
+	defaultGridFSReadNotFoundRetries = 3
+	defaultGridFSReadNotFoundBackoff = 100 * time.Millisecond
+	// gridfsRemoveBatchSize is the number of files that are deleted
+	// together when removing files by prefix.
+	gridfsRemoveBatchSize = 1000
 )
 
 // GridFSOptions support the use and creation of GridFS backed buckets.
 type GridFSOptions struct {
+	// Name is the name of the GridFS bucket, which stores its files in the
+	// "<Name>.files" and "<Name>.chunks" collections, so that buckets with
+	// different names can share a database. Defaults to "fs".
 	Name         string
 	Prefix       string
 	Database     string
@@ -29,6 +58,17 @@ type GridFSOptions struct {
 	DeleteOnPush bool
 	DeleteOnPull bool
 	Verbose      bool
+	// ReadConsistency determines where files are read from. Defaults to
+	// GridFSReadPrimary.
+	ReadConsistency GridFSReadConsistency
+	// ReadNotFoundRetries is the number of times a read of a file that is
+	// not found is retried when ReadConsistency is
+	// GridFSReadRetryNotFound. Defaults to 3.
+	ReadNotFoundRetries int
+	// ReadNotFoundBackoff is the time to wait before the first retry of a
+	// read of a file that is not found, which doubles with each retry.
+	// Defaults to 100ms.
+	ReadNotFoundBackoff time.Duration
 }
 
 func (o *GridFSOptions) validate() error {
@@ -36,12 +76,43 @@ func (o *GridFSOptions) validate() error {
 		return errors.New("ambiguous delete on sync options set")
 	}
 
+	switch o.ReadConsistency {
+	case "", GridFSReadPrimary, GridFSReadRetryNotFound:
+	default:
+		return errors.Errorf("unsupported read consistency '%s'", o.ReadConsistency)
+	}
+	if o.ReadNotFoundRetries < 0 {
+		return errors.New("read not found retries cannot be negative")
+	}
+	if o.ReadNotFoundBackoff < 0 {
+		return errors.New("read not found backoff cannot be negative")
+	}
+
 	return nil
 }
 
 type gridfsBucket struct {
 	opts   GridFSOptions
 	client *mongo.Client
+	// db, if set, is the database that stores the bucket, used instead of
+	// the client's database named by the options.
+	db *mongo.Database
+}
+
+// database returns the database that stores the bucket.
+func (b *gridfsBucket) database() *mongo.Database {
+	if b.db != nil {
+		return b.db
+	}
+	return b.client.Database(b.opts.Database)
+}
+
+// name returns the name of the GridFS bucket.
+func (b *gridfsBucket) name() string {
+	if b.opts.Name == "" {
+		return options.DefaultName
+	}
+	return b.opts.Name
 }
 
 func (b *gridfsBucket) normalizeKey(key string) string { return b.Join(b.opts.Prefix, key) }
@@ -64,6 +135,32 @@ func NewGridFSBucketWithClient(ctx context.Context, client *mongo.Client, opts G
 	return &gridfsBucket{opts: opts, client: client}, nil
 }
 
+// NewGridFSBucketWithDatabase returns a new bucket backed by GridFS in the
+// existing Mongo database with the given options, so that the bucket uses the
+// database's client, including its connection pool, and the database's read
+// and write settings, except that files are read from the primary unless the
+// options' ReadConsistency is GridFSReadRetryNotFound. The options' MongoDBURI
+// is ignored and their Database, if set, must be the database's name.
+//
+// The caller retains ownership of the database's client: the bucket never
+// disconnects it, so the caller must disconnect it once it and any buckets
+// using it are no longer needed.
+func NewGridFSBucketWithDatabase(db *mongo.Database, opts GridFSOptions) (Bucket, error) {
+	if db == nil {
+		return nil, errors.New("must provide a Mongo database")
+	}
+	if opts.Database != "" && opts.Database != db.Name() {
+		return nil, errors.Errorf("database option '%s' does not match the provided database '%s'", opts.Database, db.Name())
+	}
+	opts.Database = db.Name()
+
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	return &gridfsBucket{opts: opts, client: db.Client(), db: db}, nil
+}
+
 // NewGridFSBucket returns a bucket backed by GridFS with the given options.
 func NewGridFSBucket(ctx context.Context, opts GridFSOptions) (Bucket, error) {
 	if err := opts.validate(); err != nil {
@@ -82,6 +179,15 @@ func (b *gridfsBucket) Check(ctx context.Context) error {
 	return errors.Wrap(b.client.Ping(ctx, nil), "pinging DB")
 }
 
+func (b *gridfsBucket) Ping(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	if err := b.database().RunCommand(ctx, bson.D{{Key: "ping", Value: 1}}).Err(); err != nil {
+		return 0, errors.Wrap(err, "running ping command")
+	}
+
+	return time.Since(start), nil
+}
+
 func (b *gridfsBucket) Exists(ctx context.Context, key string) (bool, error) {
 	grid, err := b.bucket(ctx)
 	if err != nil {
@@ -99,6 +205,14 @@ func (b *gridfsBucket) Exists(ctx context.Context, key string) (bool, error) {
 
 func (b *gridfsBucket) Join(elems ...string) string { return consistentJoin(elems) }
 
+func (b *gridfsBucket) URI(key string) string {
+	database := b.opts.Database
+	if b.db != nil {
+		database = b.db.Name()
+	}
+	return "gridfs://" + database + "/" + b.name() + "/" + b.normalizeKey(key)
+}
+
 // bucket returns a new GridFS bucket configured with the context timeout, if
 // it exists. This function is called by each operation that needs to read or
 // write from the bucket to avoid read and write deadline conflicts—GridFS only
@@ -110,7 +224,11 @@ func (b *gridfsBucket) bucket(ctx context.Context) (*gridfs.Bucket, error) {
 		return nil, errors.Wrap(err, "fetching bucket with canceled context")
 	}
 
-	gfs, err := gridfs.NewBucket(b.client.Database(b.opts.Database), options.GridFSBucket().SetName(b.opts.Name))
+	opts := options.GridFSBucket().SetName(b.name())
+	if b.opts.ReadConsistency != GridFSReadRetryNotFound {
+		opts.SetReadPreference(readpref.Primary())
+	}
+	gfs, err := gridfs.NewBucket(b.database(), opts)
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
@@ -127,6 +245,62 @@ func (b *gridfsBucket) bucket(ctx context.Context) (*gridfs.Bucket, error) {
 	return gfs, nil
 }
 
+// openDownloadStream opens the file with the given name for reading, retrying
+// as by retryNotFound.
+func (b *gridfsBucket) openDownloadStream(ctx context.Context, grid *gridfs.Bucket, name string) (*gridfs.DownloadStream, error) {
+	var stream *gridfs.DownloadStream
+	err := b.retryNotFound(ctx, name, func() error {
+		var err error
+		stream, err = grid.OpenDownloadStreamByName(name)
+		return err
+	})
+	return stream, err
+}
+
+// retryNotFound runs the read of the file with the given name. If the bucket
+// retries reads of files that are not found, it retries the read with backoff
+// while it returns gridfs.ErrFileNotFound, until the retries are exhausted or
+// the context is done.
+func (b *gridfsBucket) retryNotFound(ctx context.Context, name string, read func() error) error {
+	var retries int
+	backoff := b.opts.ReadNotFoundBackoff
+	if b.opts.ReadConsistency == GridFSReadRetryNotFound {
+		retries = b.opts.ReadNotFoundRetries
+		if retries == 0 {
+			retries = defaultGridFSReadNotFoundRetries
+		}
+		if backoff == 0 {
+			backoff = defaultGridFSReadNotFoundBackoff
+		}
+	}
+
+	for attempt := 0; ; attempt++ {
+		err := read()
+		if err != gridfs.ErrFileNotFound || attempt >= retries {
+			return err
+		}
+
+		grip.DebugWhen(b.opts.Verbose, message.Fields{
+			"type":     "gridfs",
+			"message":  "retrying read of file that was not found",
+			"bucket":   b.opts.Name,
+			"file":     name,
+			"attempt":  attempt + 1,
+			"retries":  retries,
+			"wait_for": backoff.String(),
+		})
+
+		timer := time.NewTimer(backoff)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return errors.Wrap(ctx.Err(), "waiting to retry read")
+		case <-timer.C:
+		}
+		backoff *= 2
+	}
+}
+
 func (b *gridfsBucket) Writer(ctx context.Context, name string) (io.WriteCloser, error) {
 	grip.DebugWhen(b.opts.Verbose, message.Fields{
 		"type":          "gridfs",
@@ -143,7 +317,7 @@ func (b *gridfsBucket) Writer(ctx context.Context, name string) (io.WriteCloser,
 	}
 
 	if b.opts.DryRun {
-		return &mockWriteCloser{}, nil
+		return &mockWriteCloser{ctx: ctx}, nil
 	}
 
 	writer, err := grid.OpenUploadStream(b.normalizeKey(name))
@@ -151,7 +325,67 @@ func (b *gridfsBucket) Writer(ctx context.Context, name string) (io.WriteCloser,
 		return nil, errors.Wrap(err, "opening stream")
 	}
 
-	return writer, nil
+	return &gridfsWriteCloser{UploadStream: writer, ctx: ctx}, nil
+}
+
+// gridfsWriteCloser writes to a GridFS upload stream, aborting the upload,
+// which removes the chunks already written, if the context is canceled before
+// it is closed.
+type gridfsWriteCloser struct {
+	*gridfs.UploadStream
+	ctx context.Context
+}
+
+func (w *gridfsWriteCloser) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return w.UploadStream.Write(p)
+}
+
+func (w *gridfsWriteCloser) Close() error {
+	if err := w.ctx.Err(); err != nil {
+		if abortErr := w.UploadStream.Abort(); abortErr != nil {
+			return errors.Wrapf(err, "aborting upload: %s", abortErr)
+		}
+		return errors.WithStack(err)
+	}
+	return w.UploadStream.Close()
+}
+
+// gridfsFileMetadata is the metadata document that stores the content type
+// and custom metadata of a GridFS file.
+type gridfsFileMetadata struct {
+	ContentType string            `bson:"contentType,omitempty"`
+	Metadata    map[string]string `bson:"metadata,omitempty"`
+}
+
+func (b *gridfsBucket) WriterWithInfo(ctx context.Context, name string, info *BucketItemInfo) (io.WriteCloser, error) {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "gridfs",
+		"dry_run":       b.opts.DryRun,
+		"operation":     "writer with info",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"key":           name,
+	})
+
+	grid, err := b.bucket(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving bucket")
+	}
+
+	if b.opts.DryRun {
+		return &mockWriteCloser{ctx: ctx}, nil
+	}
+
+	metadata := gridfsFileMetadata{ContentType: info.ContentType, Metadata: info.Metadata}
+	writer, err := grid.OpenUploadStream(b.normalizeKey(name), options.GridFSUpload().SetMetadata(metadata))
+	if err != nil {
+		return nil, errors.Wrap(err, "opening stream")
+	}
+
+	return &gridfsWriteCloser{UploadStream: writer, ctx: ctx}, nil
 }
 
 func (b *gridfsBucket) Reader(ctx context.Context, name string) (io.ReadCloser, error) {
@@ -168,7 +402,7 @@ func (b *gridfsBucket) Reader(ctx context.Context, name string) (io.ReadCloser,
 		return nil, errors.Wrap(err, "resolving bucket")
 	}
 
-	reader, err := grid.OpenDownloadStreamByName(b.normalizeKey(name))
+	reader, err := b.openDownloadStream(ctx, grid, b.normalizeKey(name))
 	if err != nil {
 		if err == gridfs.ErrFileNotFound {
 			err = MakeKeyNotFoundError(err)
@@ -179,6 +413,221 @@ func (b *gridfsBucket) Reader(ctx context.Context, name string) (io.ReadCloser,
 	return reader, nil
 }
 
+// GetWithInfo returns the object's contents along with its size, upload time,
+// and the content type and custom metadata it was written with, if any.
+func (b *gridfsBucket) GetWithInfo(ctx context.Context, name string) (io.ReadCloser, *BucketItemInfo, error) {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "gridfs",
+		"operation":     "get with info",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"key":           name,
+	})
+
+	grid, err := b.bucket(ctx)
+	if err != nil {
+		return nil, nil, errors.Wrap(err, "resolving bucket")
+	}
+
+	stream, err := b.openDownloadStream(ctx, grid, b.normalizeKey(name))
+	if err != nil {
+		if err == gridfs.ErrFileNotFound {
+			err = MakeKeyNotFoundError(err)
+		}
+		return nil, nil, errors.Wrap(err, "opening stream")
+	}
+
+	file := stream.GetFile()
+	info := &BucketItemInfo{
+		Key:          name,
+		Size:         file.Length,
+		LastModified: file.UploadDate,
+	}
+	// Files written by other tools may have metadata in another shape,
+	// which is not an error, but cannot be copied.
+	var metadata gridfsFileMetadata
+	if len(file.Metadata) > 0 && bson.Unmarshal(file.Metadata, &metadata) == nil {
+		info.ContentType = metadata.ContentType
+		info.Metadata = metadata.Metadata
+	}
+
+	return stream, info, nil
+}
+
+func (b *gridfsBucket) ReaderSeeker(ctx context.Context, name string) (io.ReadSeekCloser, error) {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "gridfs",
+		"operation":     "reader seeker",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"key":           name,
+	})
+
+	grid, err := b.bucket(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving bucket")
+	}
+
+	stream, err := b.openDownloadStream(ctx, grid, b.normalizeKey(name))
+	if err != nil {
+		if err == gridfs.ErrFileNotFound {
+			err = MakeKeyNotFoundError(err)
+		}
+		return nil, errors.Wrap(err, "opening stream")
+	}
+
+	// Download streams can only skip forward, so seeking reopens the
+	// stream and skips to the new offset.
+	return newRangeReadSeeker(stream.GetFile().Length, stream, func(offset int64) (io.ReadCloser, error) {
+		s, err := grid.OpenDownloadStreamByName(b.normalizeKey(name))
+		if err != nil {
+			return nil, errors.Wrap(err, "reopening stream")
+		}
+		if _, err := s.Skip(offset); err != nil {
+			catcher := grip.NewBasicCatcher()
+			catcher.Wrap(err, "skipping to offset")
+			catcher.Add(s.Close())
+			return nil, catcher.Resolve()
+		}
+		return s, nil
+	}), nil
+}
+
+func (b *gridfsBucket) GetRange(ctx context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "gridfs",
+		"operation":     "get range",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"key":           name,
+		"offset":        offset,
+		"length":        length,
+	})
+
+	if err := validateRange(offset, length); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	grid, err := b.bucket(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving bucket")
+	}
+
+	// Like a download stream, read the most recent revision of the file.
+	var file gridfsFileRange
+	err = b.retryNotFound(ctx, b.normalizeKey(name), func() error {
+		err := grid.GetFilesCollection().FindOne(ctx, bson.M{"filename": b.normalizeKey(name)},
+			options.FindOne().SetSort(bson.D{{Key: "uploadDate", Value: -1}})).Decode(&file)
+		if err == mongo.ErrNoDocuments {
+			return gridfs.ErrFileNotFound
+		}
+		return err
+	})
+	if err != nil {
+		if err == gridfs.ErrFileNotFound {
+			return nil, MakeKeyNotFoundError(err)
+		}
+		return nil, errors.Wrap(err, "finding file")
+	}
+	if offset >= file.Length {
+		return nil, errors.Wrapf(ErrRangeNotSatisfiable, "offset %d is not within file '%s' of %d bytes", offset, name, file.Length)
+	}
+	if file.ChunkSize <= 0 {
+		return nil, errors.Errorf("file '%s' has invalid chunk size %d", name, file.ChunkSize)
+	}
+
+	// Only fetch the chunks that overlap the range, rather than skipping
+	// through the chunks before it as a download stream does.
+	end := offset + length
+	if end > file.Length {
+		end = file.Length
+	}
+	chunkSize := int64(file.ChunkSize)
+	first := offset / chunkSize
+	cursor, err := grid.GetChunksCollection().Find(ctx,
+		bson.M{"files_id": file.ID, "n": bson.M{"$gte": first, "$lte": (end - 1) / chunkSize}},
+		options.Find().SetSort(bson.D{{Key: "n", Value: 1}}))
+	if err != nil {
+		return nil, errors.Wrap(err, "finding file chunks")
+	}
+
+	return &gridfsRangeReader{
+		ctx:       ctx,
+		cursor:    cursor,
+		next:      first,
+		skip:      offset - first*chunkSize,
+		remaining: end - offset,
+	}, nil
+}
+
+// gridfsFileRange is the part of a GridFS file document needed to find the
+// chunks that hold a range of the file.
+type gridfsFileRange struct {
+	ID        interface{} `bson:"_id"`
+	Length    int64       `bson:"length"`
+	ChunkSize int32       `bson:"chunkSize"`
+}
+
+// gridfsRangeReader reads a range of a GridFS file from the chunks that
+// overlap it.
+type gridfsRangeReader struct {
+	ctx    context.Context
+	cursor *mongo.Cursor
+	// next is the number of the next chunk expected from the cursor.
+	next int64
+	// skip is the number of bytes of the first chunk before the range.
+	skip      int64
+	remaining int64
+	buf       []byte
+}
+
+func (r *gridfsRangeReader) Read(p []byte) (int, error) {
+	for len(r.buf) == 0 {
+		if r.remaining <= 0 {
+			return 0, io.EOF
+		}
+		if !r.cursor.Next(r.ctx) {
+			if err := r.cursor.Err(); err != nil {
+				return 0, errors.Wrap(err, "reading file chunks")
+			}
+			return 0, errors.Errorf("file chunk %d is missing", r.next)
+		}
+		var chunk struct {
+			N    int64  `bson:"n"`
+			Data []byte `bson:"data"`
+		}
+		if err := r.cursor.Decode(&chunk); err != nil {
+			return 0, errors.Wrap(err, "decoding file chunk")
+		}
+		if chunk.N != r.next {
+			return 0, errors.Errorf("file chunk %d is missing", r.next)
+		}
+		r.next++
+
+		data := chunk.Data
+		if r.skip > 0 {
+			if r.skip > int64(len(data)) {
+				return 0, errors.Errorf("file chunk %d is shorter than expected", chunk.N)
+			}
+			data = data[r.skip:]
+			r.skip = 0
+		}
+		if int64(len(data)) > r.remaining {
+			data = data[:r.remaining]
+		}
+		r.remaining -= int64(len(data))
+		r.buf = data
+	}
+
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+func (r *gridfsRangeReader) Close() error {
+	return errors.WithStack(r.cursor.Close(context.Background()))
+}
+
 func (b *gridfsBucket) Put(ctx context.Context, name string, input io.Reader) error {
 	grip.DebugWhen(b.opts.Verbose, message.Fields{
 		"type":          "gridfs",
@@ -228,7 +677,7 @@ func (b *gridfsBucket) Upload(ctx context.Context, name, path string) error {
 		"path":          path,
 	})
 
-	f, err := os.Open(path)
+	f, err := openUploadFile(ctx, path)
 	if err != nil {
 		return errors.Wrapf(err, "opening file '%s'", name)
 	}
@@ -292,18 +741,26 @@ func (b *gridfsBucket) Push(ctx context.Context, opts SyncOptions) error {
 		return errors.Wrap(err, "finding local paths")
 	}
 
+	var completed []string
 	for _, path := range localPaths {
+		if err = ctx.Err(); err != nil {
+			return partialSyncError(ctx, errors.WithStack(err), completed)
+		}
 		if re != nil && re.MatchString(path) {
 			continue
 		}
 
 		target := b.Join(opts.Remote, path)
 		if err = b.Upload(ctx, target, filepath.Join(opts.Local, path)); err != nil {
-			return errors.Wrapf(err, "uploading file '%s' to '%s'", path, target)
+			if skipVanishedFile(opts, filepath.Join(opts.Local, path), err) {
+				continue
+			}
+			return partialSyncError(ctx, errors.Wrapf(err, "uploading file '%s' to '%s'", path, target), completed)
 		}
+		completed = append(completed, target)
 	}
 
-	if (b.opts.DeleteOnPush || b.opts.DeleteOnSync) && !b.opts.DryRun {
+	if (b.opts.DeleteOnPush || b.opts.DeleteOnSync || opts.Mirror) && !b.opts.DryRun {
 		return errors.Wrap(deleteOnPush(ctx, localPaths, opts.Remote, b), "deleting on sync after push")
 	}
 
@@ -330,13 +787,17 @@ func (b *gridfsBucket) Pull(ctx context.Context, opts SyncOptions) error {
 		}
 	}
 
-	iter, err := b.List(ctx, opts.Remote)
+	iter, err := b.List(ctx, dirPrefix(opts.Remote))
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
 	keys := []string{}
+	var completed []string
 	for iter.Next(ctx) {
+		if err = ctx.Err(); err != nil {
+			return partialSyncError(ctx, errors.WithStack(err), completed)
+		}
 		item := iter.Item()
 		if re != nil && re.MatchString(item.Name()) {
 			continue
@@ -349,12 +810,13 @@ func (b *gridfsBucket) Pull(ctx context.Context, opts SyncOptions) error {
 		keys = append(keys, localName)
 
 		if err = b.Download(ctx, item.Name(), filepath.Join(opts.Local, localName)); err != nil {
-			return errors.WithStack(err)
+			return partialSyncError(ctx, errors.WithStack(err), completed)
 		}
+		completed = append(completed, item.Name())
 	}
 
 	if err = iter.Err(); err != nil {
-		return errors.WithStack(err)
+		return partialSyncError(ctx, errors.WithStack(err), completed)
 	}
 
 	if (b.opts.DeleteOnPull || b.opts.DeleteOnSync) && !b.opts.DryRun {
@@ -372,23 +834,10 @@ func (b *gridfsBucket) Copy(ctx context.Context, opts CopyOptions) error {
 		"bucket_prefix": b.opts.Prefix,
 		"source_key":    opts.SourceKey,
 		"dest_key":      opts.DestinationKey,
+		"if_not_exists": opts.IfNotExists,
 	})
 
-	from, err := b.Reader(ctx, opts.SourceKey)
-	if err != nil {
-		return errors.Wrap(err, "getting reader for source")
-	}
-
-	to, err := opts.DestinationBucket.Writer(ctx, opts.DestinationKey)
-	if err != nil {
-		return errors.Wrap(err, "getting writer for destination")
-	}
-
-	if _, err = io.Copy(to, from); err != nil {
-		return errors.Wrap(err, "copying data")
-	}
-
-	return errors.WithStack(to.Close())
+	return streamCopy(ctx, b, opts)
 }
 
 func (b *gridfsBucket) Remove(ctx context.Context, key string) error {
@@ -463,7 +912,59 @@ func (b *gridfsBucket) RemovePrefix(ctx context.Context, prefix string) error {
 		"prefix":        prefix,
 	})
 
-	return removePrefix(ctx, prefix, b)
+	if b.opts.DryRun {
+		return nil
+	}
+
+	grid, err := b.bucket(ctx)
+	if err != nil {
+		return errors.Wrap(err, "resolving bucket")
+	}
+
+	cursor, err := grid.GetFilesCollection().Find(ctx, b.listFilter(prefix), options.Find().SetProjection(bson.M{"_id": 1}))
+	if err != nil {
+		return errors.Wrap(err, "finding files")
+	}
+	defer cursor.Close(ctx)
+
+	// Delete the files and their chunks in batches, rather than one file
+	// at a time.
+	var ids []interface{}
+	for cursor.Next(ctx) {
+		document := struct {
+			ID interface{} `bson:"_id"`
+		}{}
+		if err = cursor.Decode(&document); err != nil {
+			return errors.Wrap(err, "decoding GridFS metadata")
+		}
+		ids = append(ids, document.ID)
+		if len(ids) == gridfsRemoveBatchSize {
+			if err = deleteGridFSFiles(ctx, grid, ids); err != nil {
+				return errors.Wrapf(err, "deleting files with prefix '%s'", prefix)
+			}
+			ids = ids[:0]
+		}
+	}
+	if err = cursor.Err(); err != nil {
+		return errors.Wrap(err, "iterating GridFS metadata")
+	}
+
+	return errors.Wrapf(deleteGridFSFiles(ctx, grid, ids), "deleting files with prefix '%s'", prefix)
+}
+
+// deleteGridFSFiles deletes the files with the given IDs along with their
+// chunks. Like the driver, it deletes the files first, so that no file is
+// left without its chunks.
+func deleteGridFSFiles(ctx context.Context, grid *gridfs.Bucket, ids []interface{}) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	if _, err := grid.GetFilesCollection().DeleteMany(ctx, bson.M{"_id": bson.M{"$in": ids}}); err != nil {
+		return errors.Wrap(err, "deleting files")
+	}
+	_, err := grid.GetChunksCollection().DeleteMany(ctx, bson.M{"files_id": bson.M{"$in": ids}})
+	return errors.Wrap(err, "deleting file chunks")
 }
 
 func (b *gridfsBucket) RemoveMatching(ctx context.Context, expr string) error {
@@ -493,11 +994,7 @@ func (b *gridfsBucket) List(ctx context.Context, prefix string) (BucketIterator,
 		return nil, errors.Wrap(err, "resolving bucket")
 	}
 
-	filter := bson.M{}
-	if prefix != "" {
-		filter = bson.M{"filename": primitive.Regex{Pattern: fmt.Sprintf("^%s.*", b.normalizeKey(prefix))}}
-	}
-	cursor, err := grid.FindContext(ctx, filter, options.GridFSFind().SetSort(bson.M{"filename": 1}))
+	cursor, err := grid.FindContext(ctx, b.listFilter(prefix), options.GridFSFind().SetSort(bson.M{"filename": 1}))
 	if err != nil {
 		return nil, errors.Wrap(err, "finding file")
 	}
@@ -505,6 +1002,20 @@ func (b *gridfsBucket) List(ctx context.Context, prefix string) (BucketIterator,
 	return &gridfsIterator{bucket: b, iter: cursor}, nil
 }
 
+// listFilter returns the filter for the files whose keys have the given
+// prefix. The prefix is matched literally, keeping a trailing slash, and an
+// empty prefix only matches the files under the bucket's prefix.
+func (b *gridfsBucket) listFilter(prefix string) bson.M {
+	listPrefix := b.normalizeKey(prefix)
+	if listPrefix != "" && (strings.HasSuffix(prefix, "/") || prefix == "") {
+		listPrefix += "/"
+	}
+	if listPrefix == "" {
+		return bson.M{}
+	}
+	return bson.M{"filename": primitive.Regex{Pattern: fmt.Sprintf("^%s", regexp.QuoteMeta(listPrefix))}}
+}
+
 type gridfsIterator struct {
 	err    error
 	bucket *gridfsBucket
@@ -521,8 +1032,10 @@ func (iter *gridfsIterator) Next(ctx context.Context) bool {
 	}
 
 	document := struct {
-		ID       interface{} `bson:"_id"`
-		Filename string      `bson:"filename"`
+		ID         interface{} `bson:"_id"`
+		Filename   string      `bson:"filename"`
+		UploadDate time.Time   `bson:"uploadDate"`
+		Length     int64       `bson:"length"`
 	}{}
 	if err := iter.iter.Decode(&document); err != nil {
 		iter.err = err
@@ -530,9 +1043,11 @@ func (iter *gridfsIterator) Next(ctx context.Context) bool {
 	}
 
 	iter.item = &bucketItemImpl{
-		bucket: iter.bucket.opts.Name,
-		key:    iter.bucket.denormalizeKey(document.Filename),
-		b:      iter.bucket,
+		bucket:       iter.bucket.name(),
+		key:          iter.bucket.denormalizeKey(document.Filename),
+		lastModified: document.UploadDate,
+		size:         document.Length,
+		b:            iter.bucket,
 	}
 	return true
 }
@@ -3,6 +3,7 @@ package pail
 import (
 	"context"
 	"io"
+	"time"
 )
 
 // Bucket defines an interface for accessing a remote blob store, like
@@ -45,8 +46,22 @@ type Bucket interface {
 	// `filepath.Join`.
 	Join(...string) string
 
+	// URI returns the canonical location of the object with the given key,
+	// including the bucket's prefix, in the form used by the backing
+	// store: s3://<bucket>/<key> for S3, gs://<bucket>/<key> for Google
+	// Cloud Storage, gridfs://<database>/<name>/<key> for GridFS,
+	// file://<absolute path> for local buckets and mem://<name>/<key>
+	// for in-memory buckets. The object need not exist.
+	URI(string) string
+
 	// Produces a Writer and Reader interface to the file named by
 	// the string.
+	//
+	// Once the context passed to Writer is canceled, Write and Close
+	// return the context's error, and Close discards the data written:
+	// no object is created and any partial upload is aborted, but an
+	// existing object with the same key may already have been replaced
+	// or truncated, depending on the bucket.
 	Writer(context.Context, string) (io.WriteCloser, error)
 	Reader(context.Context, string) (io.ReadCloser, error)
 
@@ -77,27 +92,183 @@ type Bucket interface {
 	RemoveMany(context.Context, ...string) error
 
 	// Remove all objects with the given prefix, continuing on error and
-	// returning any accumulated errors.
+	// returning any accumulated errors. Like List, the prefix is matched
+	// as a string rather than as a directory, so "foo" removes both
+	// "foo/bar" and "foobar"; pass "foo/" to only remove the directory.
 	// Note that this operation is not atomic.
 	RemovePrefix(context.Context, string) error
 
-	// Remove all objects matching the given regular expression, continuing
-	// on error and returning any accumulated errors.
+	// Remove all objects whose keys, relative to the bucket's prefix,
+	// match the given regular expression, continuing on error and
+	// returning any accumulated errors. An invalid expression is an
+	// error, and no objects are removed.
 	// Note that this operation is not atomic.
 	RemoveMatching(context.Context, string) error
 
-	// List returns an iterator over the contents of a bucket with the
-	// the given prefix. Contents are iterated lexicographically by key
-	// name.
+	// List returns an iterator over the contents of a bucket whose keys
+	// start with the given prefix. The prefix is matched as a string
+	// rather than as a directory, so "foo" matches "foo/bar" and
+	// "foobar", while "foo/" only matches "foo/bar"; an empty prefix
+	// matches every key. Use ListDir to list a single directory.
+	// Contents are iterated in strictly increasing
+	// lexicographic order of their keys, compared byte by byte, across
+	// the whole listing regardless of how the bucket pages through it, so
+	// callers may rely on the order, for example to merge listings.
 	List(context.Context, string) (BucketIterator, error)
 }
 
+// ListOptions configures a listing.
+type ListOptions struct {
+	// StartAfter, if set, excludes the keys that sort at or before it,
+	// so that a listing can be resumed from the last key seen.
+	StartAfter string
+}
+
+// ListOptionsBucket is implemented by buckets that can apply ListOptions when
+// listing, rather than filtering a full listing.
+type ListOptionsBucket interface {
+	// ListWithOptions behaves like List, but applies the given options.
+	ListWithOptions(ctx context.Context, prefix string, opts ListOptions) (BucketIterator, error)
+}
+
+// PingBucket is implemented by buckets that can check that their backing store
+// is reachable with a request that is lighter weight than Check.
+type PingBucket interface {
+	// Ping behaves like the Ping function.
+	Ping(ctx context.Context) (time.Duration, error)
+}
+
+// ExistsManyBucket is implemented by buckets that can check whether many keys
+// exist more efficiently than by checking each key in turn.
+type ExistsManyBucket interface {
+	// ExistsMany behaves like the ExistsMany function.
+	ExistsMany(ctx context.Context, keys []string, workers int) (map[string]bool, error)
+}
+
+// DirListBucket is implemented by buckets that can list the contents of a
+// directory without listing the contents of its subdirectories.
+type DirListBucket interface {
+	// ListDir behaves like the ListDir function.
+	ListDir(ctx context.Context, dir string) (BucketIterator, error)
+}
+
+// ParallelListBucket is implemented by buckets that can list the contents
+// under a prefix concurrently.
+type ParallelListBucket interface {
+	// ListParallel returns an iterator over the same items as List, but
+	// lists each of the "/"-delimited sub-prefixes of the prefix
+	// concurrently, using the given number of workers. Unlike List, the
+	// items are not returned in order of their keys. The workers stop
+	// when the context is canceled, which callers must do if they stop
+	// iterating before the iterator is exhausted.
+	ListParallel(ctx context.Context, prefix string, workers int) (BucketIterator, error)
+}
+
+// UploadResultBucket is implemented by buckets that can report whether an
+// upload transferred the file or skipped it because the object was already up
+// to date.
+type UploadResultBucket interface {
+	// UploadWithResult behaves like the UploadWithResult function.
+	UploadWithResult(ctx context.Context, key, path string) (UploadResult, error)
+}
+
+// LimitedBucket is implemented by buckets that can cap the size of objects
+// read from them, protecting memory-bounded consumers from unexpectedly large
+// objects.
+type LimitedBucket interface {
+	// GetLimited behaves like Get, but returns an error wrapping
+	// ErrObjectTooLarge if the object is larger than the given number of
+	// bytes. Where possible, the size is checked before the download
+	// starts; otherwise, the returned reader errors once the limit is
+	// exceeded.
+	GetLimited(ctx context.Context, key string, maxBytes int64) (io.ReadCloser, error)
+}
+
+// BucketItemInfo describes the metadata of an object in a bucket.
+type BucketItemInfo struct {
+	Key string
+	// Size is the size of the object as stored in the bucket, which, for
+	// compressed objects, is the compressed size.
+	Size            int64
+	ContentType     string
+	ContentEncoding string
+	LastModified    time.Time
+	ETag            string
+	Metadata        map[string]string
+	// ExpiresAt, if set, is when the object is scheduled to expire, as
+	// evaluated by the bucket's service. For S3, it is set when a lifecycle
+	// rule expires the object.
+	ExpiresAt *time.Time
+}
+
+// InfoBucket is implemented by buckets that can return the metadata of an
+// object along with its contents in a single request.
+type InfoBucket interface {
+	// GetWithInfo behaves like Get, but also returns the metadata of the
+	// object.
+	GetWithInfo(ctx context.Context, key string) (io.ReadCloser, *BucketItemInfo, error)
+}
+
+// InfoWriterBucket is implemented by buckets that can store the content type
+// and custom metadata of an object along with its contents. Copies between
+// buckets of different types use it to preserve the metadata returned by an
+// InfoBucket source.
+type InfoWriterBucket interface {
+	// WriterWithInfo behaves like Writer, but sets the content type and
+	// custom metadata of the written object to those in the given info.
+	// The other fields of the info are ignored.
+	WriterWithInfo(ctx context.Context, key string, info *BucketItemInfo) (io.WriteCloser, error)
+}
+
+// TouchBucket is implemented by buckets that can update the modification time
+// of an object without rewriting its contents.
+type TouchBucket interface {
+	// Touch sets the modification time of the object with the given key
+	// to the current time. The object's contents and metadata are
+	// unchanged.
+	Touch(ctx context.Context, key string) error
+}
+
+// ConditionalRemoveBucket is implemented by buckets that can remove an object
+// only if it has not changed, so that a newer version of the object written
+// after it was read is not removed.
+type ConditionalRemoveBucket interface {
+	// RemoveIfMatch removes the object with the given key if its current
+	// ETag is the expected ETag, and otherwise returns an error wrapping
+	// ErrPreconditionFailed.
+	RemoveIfMatch(ctx context.Context, key, expectedETag string) error
+}
+
+// SeekableBucket is implemented by buckets that can read objects from
+// arbitrary offsets, for formats such as zip that must be read out of order.
+type SeekableBucket interface {
+	// ReaderSeeker returns a reader for the object with the given key
+	// that supports seeking relative to the start, the current offset,
+	// or the end of the object.
+	ReaderSeeker(ctx context.Context, key string) (io.ReadSeekCloser, error)
+}
+
+// RangeBucket is implemented by buckets that can read a byte range of an
+// object without reading the rest of it, such as the footer of a file.
+type RangeBucket interface {
+	// GetRange returns a reader for the given number of bytes of the
+	// object with the given key, starting at the given offset, which
+	// returns fewer bytes if the range extends past the end of the object.
+	// It returns an error wrapping ErrRangeNotSatisfiable if the offset is
+	// not within the object, and one wrapping ErrNotSupported if the
+	// object is compressed, since offsets into its contents do not
+	// correspond to byte ranges of the stored object.
+	GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error)
+}
+
 // SyncBucket defines an interface to access a remote blob store and synchronize
 // the local file system tree with the remote store.
 type SyncBucket interface {
 	// Sync methods: these methods are the recursive, efficient
 	// copy methods of files from S3 to the local file
-	// system.
+	// system. If the context is canceled before a sync completes, they
+	// return a *PartialSyncError recording the keys that were already
+	// synced.
 	Push(context.Context, SyncOptions) error
 	Pull(context.Context, SyncOptions) error
 }
@@ -108,6 +279,37 @@ type SyncOptions struct {
 	Local   string
 	Remote  string
 	Exclude string
+	// SkipVanishedFiles, when set, skips local files that are removed
+	// after Push enumerates the local tree but before they are uploaded,
+	// rather than failing the Push. This is useful when pushing a
+	// directory that is actively being written to.
+	SkipVanishedFiles bool
+	// MaxBandwidthBytesPerSec, when positive, caps the aggregate rate at
+	// which Push uploads data, shared across all of the bucket's workers.
+	// It is only supported by parallel sync buckets, which throttle the
+	// files read by the underlying bucket's Upload.
+	MaxBandwidthBytesPerSec int64
+	// FailFast, when set, makes Push and Pull return the first error as
+	// soon as it occurs. By default, parallel sync buckets stop starting
+	// new transfers after an error, but wait for the transfers in
+	// progress to finish and return all of their errors. With FailFast,
+	// the transfers in progress are canceled, but not waited for. Buckets
+	// that sync serially always stop at the first error.
+	FailFast bool
+	// VerifyArchive, when set, makes archive buckets read the archive
+	// back after Push uploads it, checking that it is a well-formed tar
+	// archive with an entry for each pushed file. This detects truncated
+	// or otherwise corrupted uploads. Other buckets ignore it.
+	VerifyArchive bool
+	// Mirror, when set, makes Push delete the objects under Remote that
+	// have no counterpart in Local once every file is uploaded, so that
+	// the remote is an exact mirror of the local directory, as if the
+	// bucket were configured with DeleteOnPush. Unlike DeleteOnSync, it
+	// never deletes local files. Remote objects are kept if a local file
+	// with the same path exists, even if it is excluded. Objects are not
+	// deleted if the push fails or the bucket is a dry run. Archive
+	// buckets, which always replace the whole archive, ignore it.
+	Mirror bool
 }
 
 // CopyOptions describes the arguments to the Copy method for moving
@@ -117,6 +319,10 @@ type CopyOptions struct {
 	DestinationKey    string
 	DestinationBucket Bucket
 	IsDestination     bool
+	// IfNotExists prevents the copy from overwriting an existing
+	// destination key. If the destination already exists, the copy
+	// returns an error wrapping ErrAlreadyExists.
+	IfNotExists bool
 }
 
 ////////////////////////////////////////////////////////////////////////
@@ -129,7 +335,8 @@ type CopyOptions struct {
 // libraries.
 
 // BucketIterator provides a way to interact with the contents of a
-// bucket, as in the output of the List operation.
+// bucket, as in the output of the List operation. Iterators returned by List
+// yield items in strictly increasing order of their keys.
 type BucketIterator interface {
 	Next(context.Context) bool
 	Err() error
@@ -145,10 +352,39 @@ type BucketItem interface {
 	Get(context.Context) (io.ReadCloser, error)
 }
 
+// SizedBucketItem is implemented by bucket items whose listing reports the
+// size of the object.
+type SizedBucketItem interface {
+	BucketItem
+	// Size returns the size of the object as stored in the bucket, which,
+	// for compressed objects, is the compressed size.
+	Size() int64
+}
+
+// LastModifiedBucketItem is implemented by bucket items whose listing reports
+// when the object was last modified.
+type LastModifiedBucketItem interface {
+	BucketItem
+	// LastModified returns the time at which the item was last modified,
+	// or the zero time if it is not known.
+	LastModified() time.Time
+}
+
+// itemLastModified returns the time at which the item was last modified, or
+// the zero time if it is not known.
+func itemLastModified(item BucketItem) time.Time {
+	if modified, ok := item.(LastModifiedBucketItem); ok {
+		return modified.LastModified()
+	}
+	return time.Time{}
+}
+
 type bucketItemImpl struct {
-	bucket string
-	key    string
-	hash   string
+	bucket       string
+	key          string
+	hash         string
+	lastModified time.Time
+	size         int64
 
 	// TODO add other info?
 
@@ -158,9 +394,11 @@ type bucketItemImpl struct {
 	b Bucket
 }
 
-func (bi *bucketItemImpl) Name() string   { return bi.key }
-func (bi *bucketItemImpl) Hash() string   { return bi.hash }
-func (bi *bucketItemImpl) Bucket() string { return bi.bucket }
+func (bi *bucketItemImpl) Name() string            { return bi.key }
+func (bi *bucketItemImpl) Hash() string            { return bi.hash }
+func (bi *bucketItemImpl) Bucket() string          { return bi.bucket }
+func (bi *bucketItemImpl) LastModified() time.Time { return bi.lastModified }
+func (bi *bucketItemImpl) Size() int64             { return bi.size }
 func (bi *bucketItemImpl) Get(ctx context.Context) (io.ReadCloser, error) {
 	return bi.b.Get(ctx, bi.key)
 }
@@ -0,0 +1,67 @@
+package pail
+
+import (
+	"context"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// ListDir returns an iterator over the contents of the directory dir, treating
+// "/" as the directory separator: the objects directly in the directory, and
+// an item for each of its subdirectories. The names of the items for
+// subdirectories end with "/", and they have no size or modification time. A
+// trailing slash on dir is optional, and an empty dir lists the top level of
+// the bucket. Items are iterated in order of their names.
+//
+// Buckets that implement DirListBucket list the directory themselves; other
+// buckets are listed with List, skipping the contents of the subdirectories.
+func ListDir(ctx context.Context, b Bucket, dir string) (BucketIterator, error) {
+	if db, ok := b.(DirListBucket); ok {
+		return db.ListDir(ctx, dir)
+	}
+
+	prefix := dirPrefix(dir)
+	iter, err := b.List(ctx, prefix)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	return &dirIterator{iter: iter, bucket: b, prefix: prefix}, nil
+}
+
+// dirIterator iterates over the items of a listing of a directory, replacing
+// the items in each subdirectory with a single item for the subdirectory. The
+// listing is in order, so the items in a subdirectory are consecutive.
+type dirIterator struct {
+	iter    BucketIterator
+	bucket  Bucket
+	prefix  string
+	lastDir string
+	item    BucketItem
+}
+
+func (iter *dirIterator) Next(ctx context.Context) bool {
+	for iter.iter.Next(ctx) {
+		item := iter.iter.Item()
+		rest := strings.TrimPrefix(item.Name(), iter.prefix)
+		idx := strings.Index(rest, "/")
+		if idx < 0 {
+			iter.item = item
+			return true
+		}
+
+		dir := iter.prefix + rest[:idx+1]
+		if dir == iter.lastDir {
+			continue
+		}
+		iter.lastDir = dir
+		iter.item = &bucketItemImpl{bucket: item.Bucket(), key: dir, b: iter.bucket}
+		return true
+	}
+	iter.item = nil
+	return false
+}
+
+func (iter *dirIterator) Err() error { return iter.iter.Err() }
+
+func (iter *dirIterator) Item() BucketItem { return iter.item }
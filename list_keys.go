@@ -0,0 +1,30 @@
+package pail
+
+import (
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// ListKeys returns the keys of the objects in the bucket with the given
+// prefix, sorted lexicographically. The keys are the full keys of the
+// objects, including the prefix, as returned by the names of the listed
+// items, so they can be passed directly to the bucket's other methods.
+func ListKeys(ctx context.Context, b Bucket, prefix string) ([]string, error) {
+	iter, err := b.List(ctx, prefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing bucket")
+	}
+
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Item().Name())
+	}
+	if err = iter.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterating bucket")
+	}
+	sort.Strings(keys)
+
+	return keys, nil
+}
@@ -0,0 +1,42 @@
+package pail
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
+// ListWithOptions returns an iterator over the items in the bucket with the
+// given prefix, applying the given options. Buckets that implement
+// ListOptionsBucket apply the options themselves; for other buckets, the full
+// listing is filtered.
+func ListWithOptions(ctx context.Context, b Bucket, prefix string, opts ListOptions) (BucketIterator, error) {
+	if lb, ok := b.(ListOptionsBucket); ok {
+		return lb.ListWithOptions(ctx, prefix, opts)
+	}
+
+	iter, err := b.List(ctx, prefix)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	if opts.StartAfter == "" {
+		return iter, nil
+	}
+
+	return &startAfterIterator{BucketIterator: iter, startAfter: opts.StartAfter}, nil
+}
+
+// startAfterIterator skips the items whose keys sort at or before startAfter.
+type startAfterIterator struct {
+	BucketIterator
+	startAfter string
+}
+
+func (iter *startAfterIterator) Next(ctx context.Context) bool {
+	for iter.BucketIterator.Next(ctx) {
+		if iter.Item().Name() > iter.startAfter {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,111 @@
+package pail
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// ListParallel returns an iterator over the items in the bucket with the given
+// prefix, listing the prefix's sub-prefixes concurrently with the given number
+// of workers if the bucket implements ParallelListBucket. Other buckets are
+// listed with List. Callers must not assume that the items are returned in
+// order of their keys.
+func ListParallel(ctx context.Context, b Bucket, prefix string, workers int) (BucketIterator, error) {
+	if pb, ok := b.(ParallelListBucket); ok {
+		return pb.ListParallel(ctx, prefix, workers)
+	}
+
+	iter, err := b.List(ctx, prefix)
+	return iter, errors.WithStack(err)
+}
+
+// parallelListIterator returns the items sent by concurrent listings, in the
+// order that they arrive.
+type parallelListIterator struct {
+	items  chan BucketItem
+	cancel context.CancelFunc
+	item   BucketItem
+	err    error
+
+	// mu guards firstErr, the first error of any of the listings, which
+	// stops the others.
+	mu       sync.Mutex
+	firstErr error
+}
+
+// newParallelListIterator returns an iterator over the items that the given
+// listing functions send, running each of them in its own goroutine under a
+// context that is canceled when any of them fails.
+func newParallelListIterator(ctx context.Context, listings ...func(context.Context, func(BucketItem) bool) error) *parallelListIterator {
+	ctx, cancel := context.WithCancel(ctx)
+	iter := &parallelListIterator{items: make(chan BucketItem), cancel: cancel}
+
+	send := func(item BucketItem) bool {
+		select {
+		case iter.items <- item:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	}
+
+	wg := &sync.WaitGroup{}
+	for _, listing := range listings {
+		wg.Add(1)
+		go func(listing func(context.Context, func(BucketItem) bool) error) {
+			defer wg.Done()
+			if err := listing(ctx, send); err != nil {
+				iter.setErr(err)
+			} else if err := ctx.Err(); err != nil {
+				iter.setErr(err)
+			}
+		}(listing)
+	}
+	go func() {
+		wg.Wait()
+		cancel()
+		close(iter.items)
+	}()
+
+	return iter
+}
+
+func (iter *parallelListIterator) setErr(err error) {
+	iter.mu.Lock()
+	defer iter.mu.Unlock()
+
+	if iter.firstErr == nil {
+		iter.firstErr = err
+	}
+	iter.cancel()
+}
+
+func (iter *parallelListIterator) Next(ctx context.Context) bool {
+	if iter.err != nil {
+		return false
+	}
+
+	select {
+	case item, ok := <-iter.items:
+		if !ok {
+			iter.mu.Lock()
+			iter.err = iter.firstErr
+			iter.mu.Unlock()
+			iter.item = nil
+			return false
+		}
+		iter.item = item
+		return true
+	case <-ctx.Done():
+		iter.cancel()
+		iter.err = errors.WithStack(ctx.Err())
+		iter.item = nil
+		return false
+	}
+}
+
+func (iter *parallelListIterator) Err() error { return iter.err }
+
+func (iter *parallelListIterator) Item() BucketItem { return iter.item }
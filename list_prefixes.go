@@ -0,0 +1,78 @@
+package pail
+
+import (
+	"context"
+	"sync"
+
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+)
+
+// ListPrefixes returns an iterator over the items in the bucket with any of
+// the given prefixes. The prefixes are listed concurrently, and an item with
+// more than one of the prefixes is only returned once.
+//
+// Items are returned grouped by the first of the given prefixes that they
+// have, in the order that the bucket lists them, so they are not sorted
+// across prefixes. All of the listings are held in memory, so this is not
+// suitable for prefixes with very many items.
+func ListPrefixes(ctx context.Context, b Bucket, prefixes []string) (BucketIterator, error) {
+	listings := make([][]BucketItem, len(prefixes))
+	catcher := grip.NewBasicCatcher()
+	wg := &sync.WaitGroup{}
+	for i, prefix := range prefixes {
+		wg.Add(1)
+		go func(i int, prefix string) {
+			defer wg.Done()
+
+			iter, err := b.List(ctx, prefix)
+			if err != nil {
+				catcher.Wrapf(err, "listing prefix '%s'", prefix)
+				return
+			}
+			for iter.Next(ctx) {
+				listings[i] = append(listings[i], iter.Item())
+			}
+			catcher.Wrapf(iter.Err(), "iterating prefix '%s'", prefix)
+		}(i, prefix)
+	}
+	wg.Wait()
+	if catcher.HasErrors() {
+		return nil, errors.Wrap(catcher.Resolve(), "listing prefixes")
+	}
+
+	seen := map[string]bool{}
+	var items []BucketItem
+	for _, listing := range listings {
+		for _, item := range listing {
+			if seen[item.Name()] {
+				continue
+			}
+			seen[item.Name()] = true
+			items = append(items, item)
+		}
+	}
+
+	return &bucketItemsIterator{items: items, idx: -1}, nil
+}
+
+// bucketItemsIterator iterates over a fixed list of items.
+type bucketItemsIterator struct {
+	items []BucketItem
+	idx   int
+}
+
+func (iter *bucketItemsIterator) Err() error { return nil }
+func (iter *bucketItemsIterator) Item() BucketItem {
+	if iter.idx < 0 || iter.idx >= len(iter.items) {
+		return nil
+	}
+	return iter.items[iter.idx]
+}
+func (iter *bucketItemsIterator) Next(_ context.Context) bool {
+	if iter.idx >= len(iter.items) {
+		return false
+	}
+	iter.idx++
+	return iter.idx < len(iter.items)
+}
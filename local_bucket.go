@@ -4,9 +4,17 @@ import (
 	"context"
 	"io"
 	"io/ioutil"
+	"math/rand"
+	"mime"
+	"net/url"
 	"os"
+	"path"
 	"path/filepath"
 	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/evergreen-ci/utility"
 	"github.com/mongodb/grip"
@@ -22,6 +30,9 @@ type localFileSystem struct {
 	deleteOnPush bool
 	deleteOnPull bool
 	verbose      bool
+	// copyBufferSize is the size of the buffer used to copy data in Put,
+	// Upload, and Download, or zero to use the io.Copy default.
+	copyBufferSize int
 }
 
 // LocalOptions describes the configuration of a local Bucket.
@@ -36,12 +47,20 @@ type LocalOptions struct {
 	DeleteOnPush bool
 	DeleteOnPull bool
 	Verbose      bool
+	// CopyBufferSize sets the size, in bytes, of the buffer used to copy
+	// data in Put, Upload, and Download. Larger buffers make fewer, larger
+	// reads and writes. Defaults to 32KB, which allows the OS to copy
+	// between files directly where it supports doing so. (Optional)
+	CopyBufferSize int
 }
 
 func (o *LocalOptions) validate() error {
 	if (o.DeleteOnPush != o.DeleteOnPull) && o.DeleteOnSync {
 		return errors.New("ambiguous delete on sync options set")
 	}
+	if o.CopyBufferSize < 0 {
+		return errors.New("copy buffer size must be positive")
+	}
 
 	return nil
 }
@@ -62,12 +81,13 @@ func NewLocalBucket(opts LocalOptions) (Bucket, error) {
 	}
 
 	b := &localFileSystem{
-		path:         opts.Path,
-		prefix:       opts.Prefix,
-		useSlash:     opts.UseSlash,
-		dryRun:       opts.DryRun,
-		deleteOnPush: opts.DeleteOnPush || opts.DeleteOnSync,
-		deleteOnPull: opts.DeleteOnPull || opts.DeleteOnSync,
+		path:           opts.Path,
+		prefix:         opts.Prefix,
+		useSlash:       opts.UseSlash,
+		dryRun:         opts.DryRun,
+		deleteOnPush:   opts.DeleteOnPush || opts.DeleteOnSync,
+		deleteOnPull:   opts.DeleteOnPull || opts.DeleteOnSync,
+		copyBufferSize: opts.CopyBufferSize,
 	}
 	if err := b.Check(context.TODO()); err != nil {
 		return nil, errors.WithStack(err)
@@ -90,11 +110,12 @@ func NewLocalTemporaryBucket(opts LocalOptions) (Bucket, error) {
 	}
 
 	return &localFileSystem{
-		path:         dir,
-		prefix:       opts.Prefix,
-		dryRun:       opts.DryRun,
-		deleteOnPush: opts.DeleteOnPush || opts.DeleteOnSync,
-		deleteOnPull: opts.DeleteOnPull || opts.DeleteOnSync,
+		path:           dir,
+		prefix:         opts.Prefix,
+		dryRun:         opts.DryRun,
+		deleteOnPush:   opts.DeleteOnPush || opts.DeleteOnSync,
+		deleteOnPull:   opts.DeleteOnPull || opts.DeleteOnSync,
+		copyBufferSize: opts.CopyBufferSize,
 	}, nil
 }
 
@@ -106,6 +127,15 @@ func (b *localFileSystem) Check(_ context.Context) error {
 	return nil
 }
 
+func (b *localFileSystem) Ping(_ context.Context) (time.Duration, error) {
+	start := time.Now()
+	if _, err := os.Stat(b.path); err != nil {
+		return 0, errors.Wrap(err, "getting bucket root stats")
+	}
+
+	return time.Since(start), nil
+}
+
 func (b *localFileSystem) Exists(_ context.Context, key string) (bool, error) {
 	if _, err := os.Stat(b.Join(b.path, b.normalizeKey(key))); err != nil {
 		if os.IsNotExist(err) {
@@ -125,7 +155,24 @@ func (b *localFileSystem) Join(elems ...string) string {
 	return filepath.Join(elems...)
 }
 
-func (b *localFileSystem) Writer(_ context.Context, name string) (io.WriteCloser, error) {
+// URI returns a file URI with the absolute path of the file for the key. The
+// path is relative to the working directory if it cannot be made absolute.
+func (b *localFileSystem) URI(key string) string {
+	fn := b.Join(b.path, b.normalizeKey(key))
+	if abs, err := filepath.Abs(fn); err == nil {
+		fn = abs
+	}
+	fn = filepath.ToSlash(fn)
+	// Windows paths, such as C:/dir, need a leading slash to be the path
+	// of a URI.
+	if !strings.HasPrefix(fn, "/") {
+		fn = "/" + fn
+	}
+
+	return (&url.URL{Scheme: "file", Path: fn}).String()
+}
+
+func (b *localFileSystem) Writer(ctx context.Context, name string) (io.WriteCloser, error) {
 	grip.DebugWhen(b.verbose, message.Fields{
 		"type":          "local",
 		"dry_run":       b.dryRun,
@@ -136,7 +183,7 @@ func (b *localFileSystem) Writer(_ context.Context, name string) (io.WriteCloser
 	})
 
 	if b.dryRun {
-		return &mockWriteCloser{}, nil
+		return &mockWriteCloser{ctx: ctx}, nil
 	}
 
 	path := b.Join(b.path, b.normalizeKey(name))
@@ -144,12 +191,79 @@ func (b *localFileSystem) Writer(_ context.Context, name string) (io.WriteCloser
 		return nil, errors.Wrap(err, "creating base directories")
 	}
 
-	f, err := os.Create(path)
+	f, err := createLocalTempFile(path)
 	if err != nil {
 		return nil, errors.Wrapf(err, "opening file '%s'", path)
 	}
 
-	return f, nil
+	return &localWriteCloser{file: f, path: path, ctx: ctx}, nil
+}
+
+// localTempFileSuffix is the suffix of the names of the temporary files that
+// writers stage data in, which are not listed or synced as files of the tree.
+const localTempFileSuffix = ".pail-tmp"
+
+// createLocalTempFile creates a new, uniquely named file in the same
+// directory as the given path, so that it can be renamed over the path. Unlike
+// os.CreateTemp, the file gets the same permissions that os.Create would give
+// it.
+func createLocalTempFile(path string) (*os.File, error) {
+	dir, base := filepath.Split(path)
+	for i := 0; i < 10000; i++ {
+		name := filepath.Join(dir, "."+base+"."+strconv.FormatUint(uint64(rand.Int63()), 36)+localTempFileSuffix)
+		f, err := os.OpenFile(name, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0666)
+		if os.IsExist(err) {
+			continue
+		}
+		return f, err
+	}
+	return nil, errors.Errorf("could not find an unused temporary file name for '%s'", path)
+}
+
+// isLocalTempFile returns whether the file name is that of a temporary file
+// created by createLocalTempFile.
+func isLocalTempFile(name string) bool {
+	return strings.HasPrefix(name, ".") && strings.HasSuffix(name, localTempFileSuffix)
+}
+
+// localWriteCloser writes to a temporary file, which replaces the file at the
+// path once the writer is closed. If the context is canceled before the
+// writer is closed, the temporary file is removed and any existing file at
+// the path is left as it was.
+type localWriteCloser struct {
+	file *os.File
+	path string
+	ctx  context.Context
+}
+
+func (w *localWriteCloser) Write(p []byte) (int, error) {
+	if err := w.ctx.Err(); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return w.file.Write(p)
+}
+
+func (w *localWriteCloser) Close() error {
+	if err := w.ctx.Err(); err != nil {
+		return w.discard(errors.WithStack(err))
+	}
+	if err := w.file.Close(); err != nil {
+		return w.discard(errors.Wrapf(err, "closing temporary file '%s'", w.file.Name()))
+	}
+	if err := os.Rename(w.file.Name(), w.path); err != nil {
+		return w.discard(errors.Wrapf(err, "renaming temporary file to '%s'", w.path))
+	}
+	return nil
+}
+
+// discard removes the temporary file and returns the error that caused it to
+// be discarded.
+func (w *localWriteCloser) discard(err error) error {
+	_ = w.file.Close()
+	if rmErr := os.Remove(w.file.Name()); rmErr != nil && !os.IsNotExist(rmErr) {
+		return errors.Wrapf(err, "removing temporary file '%s': %s", w.file.Name(), rmErr)
+	}
+	return err
 }
 
 func (b *localFileSystem) Reader(_ context.Context, name string) (io.ReadCloser, error) {
@@ -173,6 +287,67 @@ func (b *localFileSystem) Reader(_ context.Context, name string) (io.ReadCloser,
 	return f, nil
 }
 
+func (b *localFileSystem) ReaderSeeker(_ context.Context, name string) (io.ReadSeekCloser, error) {
+	grip.DebugWhen(b.verbose, message.Fields{
+		"type":          "local",
+		"operation":     "reader seeker",
+		"bucket":        b.path,
+		"bucket_prefix": b.prefix,
+		"key":           name,
+	})
+
+	path := b.Join(b.path, b.normalizeKey(name))
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = MakeKeyNotFoundError(err)
+		}
+		return nil, errors.Wrapf(err, "opening file '%s'", path)
+	}
+
+	return f, nil
+}
+
+func (b *localFileSystem) GetRange(_ context.Context, name string, offset, length int64) (io.ReadCloser, error) {
+	grip.DebugWhen(b.verbose, message.Fields{
+		"type":          "local",
+		"operation":     "get range",
+		"bucket":        b.path,
+		"bucket_prefix": b.prefix,
+		"key":           name,
+		"offset":        offset,
+		"length":        length,
+	})
+
+	if err := validateRange(offset, length); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	path := b.Join(b.path, b.normalizeKey(name))
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = MakeKeyNotFoundError(err)
+		}
+		return nil, errors.Wrapf(err, "opening file '%s'", path)
+	}
+	info, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, errors.Wrapf(err, "getting info for file '%s'", path)
+	}
+	if offset >= info.Size() {
+		_ = f.Close()
+		return nil, errors.Wrapf(ErrRangeNotSatisfiable, "offset %d is not within file '%s' of %d bytes", offset, path, info.Size())
+	}
+	if _, err = f.Seek(offset, io.SeekStart); err != nil {
+		_ = f.Close()
+		return nil, errors.Wrapf(err, "seeking to offset %d in file '%s'", offset, path)
+	}
+
+	return newLimitedRangeReadCloser(f, length), nil
+}
+
 func (b *localFileSystem) Put(ctx context.Context, name string, input io.Reader) error {
 	grip.DebugWhen(b.verbose, message.Fields{
 		"type":          "local",
@@ -187,7 +362,7 @@ func (b *localFileSystem) Put(ctx context.Context, name string, input io.Reader)
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	_, err = io.Copy(f, input)
+	_, err = copyWithBuffer(f, input, b.copyBufferSize)
 	if err != nil {
 		_ = f.Close()
 		return errors.Wrap(err, "copying data to file")
@@ -208,6 +383,74 @@ func (b *localFileSystem) Get(ctx context.Context, name string) (io.ReadCloser,
 	return b.Reader(ctx, name)
 }
 
+func (b *localFileSystem) GetLimited(ctx context.Context, name string, maxBytes int64) (io.ReadCloser, error) {
+	grip.DebugWhen(b.verbose, message.Fields{
+		"type":          "local",
+		"operation":     "get limited",
+		"bucket":        b.path,
+		"bucket_prefix": b.prefix,
+		"key":           name,
+		"max_bytes":     maxBytes,
+	})
+
+	if maxBytes < 0 {
+		return nil, errors.New("size limit cannot be negative")
+	}
+
+	path := b.Join(b.path, b.normalizeKey(name))
+	info, err := os.Stat(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = MakeKeyNotFoundError(err)
+		}
+		return nil, errors.Wrapf(err, "getting file stats for '%s'", path)
+	}
+	if info.Size() > maxBytes {
+		return nil, errors.Wrapf(ErrObjectTooLarge, "key '%s' has %d bytes, which is larger than %d bytes", name, info.Size(), maxBytes)
+	}
+
+	r, err := b.Reader(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLimitedReadCloser(r, name, maxBytes), nil
+}
+
+// GetWithInfo returns the object's contents along with its size and
+// modification time. Local files have no stored content type or metadata, so
+// the content type is inferred from the key's extension.
+func (b *localFileSystem) GetWithInfo(ctx context.Context, name string) (io.ReadCloser, *BucketItemInfo, error) {
+	grip.DebugWhen(b.verbose, message.Fields{
+		"type":          "local",
+		"operation":     "get with info",
+		"bucket":        b.path,
+		"bucket_prefix": b.prefix,
+		"key":           name,
+	})
+
+	path := b.Join(b.path, b.normalizeKey(name))
+	f, err := os.Open(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			err = MakeKeyNotFoundError(err)
+		}
+		return nil, nil, errors.Wrapf(err, "opening file '%s'", path)
+	}
+	stat, err := f.Stat()
+	if err != nil {
+		_ = f.Close()
+		return nil, nil, errors.Wrapf(err, "getting file stats for '%s'", path)
+	}
+
+	return f, &BucketItemInfo{
+		Key:          name,
+		Size:         stat.Size(),
+		ContentType:  mime.TypeByExtension(filepath.Ext(name)),
+		LastModified: stat.ModTime(),
+	}, nil
+}
+
 func (b *localFileSystem) Upload(ctx context.Context, name, path string) error {
 	grip.DebugWhen(b.verbose, message.Fields{
 		"type":          "local",
@@ -219,7 +462,7 @@ func (b *localFileSystem) Upload(ctx context.Context, name, path string) error {
 		"path":          path,
 	})
 
-	f, err := os.Open(path)
+	f, err := openUploadFile(ctx, path)
 	if err != nil {
 		return errors.Wrapf(err, "opening file '%s'", name)
 	}
@@ -255,7 +498,7 @@ func (b *localFileSystem) Download(ctx context.Context, name, path string) error
 		return errors.WithStack(err)
 	}
 
-	_, err = io.Copy(f, reader)
+	_, err = copyWithBuffer(f, reader, b.copyBufferSize)
 	if err != nil {
 		_ = f.Close()
 		_ = reader.Close()
@@ -276,24 +519,35 @@ func (b *localFileSystem) Copy(ctx context.Context, options CopyOptions) error {
 		"bucket_prefix": b.prefix,
 		"source_key":    options.SourceKey,
 		"dest_key":      options.DestinationKey,
+		"if_not_exists": options.IfNotExists,
 	})
 
-	from, err := b.Reader(ctx, options.SourceKey)
-	if err != nil {
-		return errors.Wrap(err, "getting reader for source")
-	}
+	return streamCopy(ctx, b, options)
+}
 
-	to, err := options.DestinationBucket.Writer(ctx, options.DestinationKey)
-	if err != nil {
-		return errors.Wrap(err, "getting writer for destination")
-	}
+func (b *localFileSystem) Touch(_ context.Context, key string) error {
+	grip.DebugWhen(b.verbose, message.Fields{
+		"type":          "local",
+		"dry_run":       b.dryRun,
+		"operation":     "touch",
+		"bucket":        b.path,
+		"bucket_prefix": b.prefix,
+		"key":           key,
+	})
 
-	_, err = io.Copy(to, from)
-	if err != nil {
-		return errors.Wrap(err, "copying data")
+	path := b.Join(b.path, b.normalizeKey(key))
+	if _, err := os.Stat(path); err != nil {
+		if os.IsNotExist(err) {
+			return MakeKeyNotFoundError(err)
+		}
+		return errors.Wrapf(err, "getting file stats for '%s'", path)
+	}
+	if b.dryRun {
+		return nil
 	}
 
-	return errors.WithStack(to.Close())
+	now := time.Now()
+	return errors.Wrapf(os.Chtimes(path, now, now), "touching path '%s'", path)
 }
 
 func (b *localFileSystem) Remove(ctx context.Context, key string) error {
@@ -345,7 +599,38 @@ func (b *localFileSystem) RemovePrefix(ctx context.Context, prefix string) error
 		"prefix":        prefix,
 	})
 
-	return removePrefix(ctx, prefix, b)
+	if b.dryRun {
+		return nil
+	}
+
+	// Keys are matched by prefix rather than by directory, so remove each
+	// entry of the directory that contains the prefix whose name has the
+	// rest of the prefix, along with everything under it.
+	dir, base := prefix, ""
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		dir, base = path.Split(prefix)
+	}
+	root := b.Join(b.path, b.normalizeKey(dir))
+	entries, err := os.ReadDir(root)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return errors.Wrapf(err, "reading directory '%s'", root)
+	}
+
+	catcher := grip.NewBasicCatcher()
+	for _, entry := range entries {
+		if err = ctx.Err(); err != nil {
+			catcher.Add(errors.WithStack(err))
+			break
+		}
+		if strings.HasPrefix(entry.Name(), base) && !isLocalTempFile(entry.Name()) {
+			catcher.Wrapf(os.RemoveAll(filepath.Join(root, entry.Name())), "removing path '%s'", filepath.Join(root, entry.Name()))
+		}
+	}
+
+	return catcher.Resolve()
 }
 
 func (b *localFileSystem) RemoveMatching(ctx context.Context, expression string) error {
@@ -387,38 +672,54 @@ func (b *localFileSystem) Push(ctx context.Context, opts SyncOptions) error {
 		return errors.WithStack(err)
 	}
 
+	var completed []string
 	for _, fn := range files {
+		if err = ctx.Err(); err != nil {
+			return partialSyncError(ctx, errors.WithStack(err), completed)
+		}
 		if re != nil && re.MatchString(fn) {
 			continue
 		}
 
-		target := b.Join(b.path, b.normalizeKey(b.Join(opts.Remote, fn)))
+		key := b.Join(opts.Remote, fn)
+		target := b.Join(b.path, b.normalizeKey(key))
 		file := b.Join(opts.Local, fn)
 		if _, err := os.Stat(target); os.IsNotExist(err) {
-			if err := b.Upload(ctx, b.Join(opts.Remote, fn), file); err != nil {
-				return errors.WithStack(err)
+			if err := b.Upload(ctx, key, file); err != nil {
+				if skipVanishedFile(opts, file, err) {
+					continue
+				}
+				return partialSyncError(ctx, errors.WithStack(err), completed)
 			}
+			completed = append(completed, key)
 
 			continue
 		}
 
 		lsum, err := utility.SHA1SumFile(file)
 		if err != nil {
-			return errors.WithStack(err)
+			if skipVanishedFile(opts, file, err) {
+				continue
+			}
+			return partialSyncError(ctx, errors.WithStack(err), completed)
 		}
 		rsum, err := utility.SHA1SumFile(target)
 		if err != nil {
-			return errors.WithStack(err)
+			return partialSyncError(ctx, errors.WithStack(err), completed)
 		}
 
 		if lsum != rsum {
-			if err := b.Upload(ctx, b.Join(opts.Remote, fn), file); err != nil {
-				return errors.WithStack(err)
+			if err := b.Upload(ctx, key, file); err != nil {
+				if skipVanishedFile(opts, file, err) {
+					continue
+				}
+				return partialSyncError(ctx, errors.WithStack(err), completed)
 			}
 		}
+		completed = append(completed, key)
 	}
 
-	if b.deleteOnPush && !b.dryRun {
+	if (b.deleteOnPush || opts.Mirror) && !b.dryRun {
 		return errors.Wrap(deleteOnPush(ctx, files, opts.Remote, b), "deleting on sync after push")
 	}
 	return nil
@@ -451,7 +752,11 @@ func (b *localFileSystem) Pull(ctx context.Context, opts SyncOptions) error {
 	}
 
 	keys := []string{}
+	var completed []string
 	for _, fn := range files {
+		if err = ctx.Err(); err != nil {
+			return partialSyncError(ctx, errors.WithStack(err), completed)
+		}
 		if re != nil && re.MatchString(fn) {
 			continue
 		}
@@ -461,26 +766,28 @@ func (b *localFileSystem) Pull(ctx context.Context, opts SyncOptions) error {
 		fn = b.Join(opts.Remote, fn)
 		if _, err := os.Stat(path); os.IsNotExist(err) {
 			if err := b.Download(ctx, fn, path); err != nil {
-				return errors.WithStack(err)
+				return partialSyncError(ctx, errors.WithStack(err), completed)
 			}
+			completed = append(completed, fn)
 
 			continue
 		}
 
 		lsum, err := utility.SHA1SumFile(b.Join(prefix, fn))
 		if err != nil {
-			return errors.WithStack(err)
+			return partialSyncError(ctx, errors.WithStack(err), completed)
 		}
 		rsum, err := utility.SHA1SumFile(path)
 		if err != nil {
-			return errors.WithStack(err)
+			return partialSyncError(ctx, errors.WithStack(err), completed)
 		}
 
 		if lsum != rsum {
 			if err := b.Download(ctx, fn, path); err != nil {
-				return errors.WithStack(err)
+				return partialSyncError(ctx, errors.WithStack(err), completed)
 			}
 		}
+		completed = append(completed, fn)
 	}
 
 	if b.deleteOnPull && !b.dryRun {
@@ -490,29 +797,54 @@ func (b *localFileSystem) Pull(ctx context.Context, opts SyncOptions) error {
 }
 
 func (b *localFileSystem) List(ctx context.Context, prefix string) (BucketIterator, error) {
+	return b.ListWithOptions(ctx, prefix, ListOptions{})
+}
+
+func (b *localFileSystem) ListWithOptions(ctx context.Context, prefix string, opts ListOptions) (BucketIterator, error) {
 	grip.DebugWhen(b.verbose, message.Fields{
 		"operation":     "list",
 		"bucket":        b.path,
 		"bucket_prefix": b.prefix,
 		"prefix":        prefix,
+		"start_after":   opts.StartAfter,
 	})
 
-	files, err := walkLocalTree(ctx, b.Join(b.path, b.normalizeKey(prefix)))
+	// Keys are matched by prefix rather than by directory, so walk the
+	// directory that contains the prefix and keep the keys that have it.
+	dir := prefix
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		if dir = path.Dir(prefix); dir == "." {
+			dir = ""
+		}
+	}
+	walked, err := walkLocalTreeInfo(ctx, b.Join(b.path, b.normalizeKey(dir)))
 	if err != nil {
 		return nil, errors.WithStack(err)
 	}
+	var files []localFile
+	for _, file := range walked {
+		key := b.Join(dir, file.path)
+		if strings.HasPrefix(key, prefix) && (opts.StartAfter == "" || key > opts.StartAfter) {
+			files = append(files, file)
+		}
+	}
+	// The tree is walked one directory at a time, which does not order
+	// keys such as "a/b" and "a-c" by their full key, so sort them.
+	sort.Slice(files, func(i, j int) bool {
+		return b.Join(dir, files[i].path) < b.Join(dir, files[j].path)
+	})
 
 	return &localFileSystemIterator{
 		files:  files,
 		idx:    -1,
 		bucket: b,
-		prefix: prefix,
+		prefix: dir,
 	}, nil
 }
 
 type localFileSystemIterator struct {
 	err    error
-	files  []string
+	files  []localFile
 	idx    int
 	item   *bucketItemImpl
 	bucket *localFileSystem
@@ -527,10 +859,13 @@ func (iter *localFileSystemIterator) Next(_ context.Context) bool {
 		return false
 	}
 
+	file := iter.files[iter.idx]
 	iter.item = &bucketItemImpl{
-		bucket: iter.bucket.path,
-		key:    iter.bucket.Join(iter.prefix, iter.files[iter.idx]),
-		b:      iter.bucket,
+		bucket:       iter.bucket.path,
+		key:          iter.bucket.Join(iter.prefix, file.path),
+		lastModified: file.info.ModTime(),
+		size:         file.info.Size(),
+		b:            iter.bucket,
 	}
 	return true
 }
@@ -0,0 +1,566 @@
+package pail
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
+	"github.com/pkg/errors"
+)
+
+// MemoryBucketOptions support the creation of in-memory buckets.
+type MemoryBucketOptions struct {
+	// Name is the name of the bucket, which is reported by its items.
+	// (Optional)
+	Name         string
+	Prefix       string
+	DryRun       bool
+	DeleteOnSync bool
+	DeleteOnPush bool
+	DeleteOnPull bool
+	Verbose      bool
+}
+
+func (o *MemoryBucketOptions) validate() error {
+	if (o.DeleteOnPush != o.DeleteOnPull) && o.DeleteOnSync {
+		return errors.New("ambiguous delete on sync options set")
+	}
+
+	return nil
+}
+
+type memoryBucket struct {
+	opts MemoryBucketOptions
+
+	mu sync.RWMutex
+	// objects is the data of each object by its key, including the
+	// bucket's prefix. The data is never modified once it is stored, so
+	// it can be read without holding the lock.
+	objects map[string][]byte
+	// lastModified is when each object was last written.
+	lastModified map[string]time.Time
+}
+
+// NewMemoryBucket returns a bucket that stores objects in memory, which is
+// useful for testing code that uses buckets without access to a network or
+// disk. The objects are lost once the bucket is no longer referenced, and are
+// not shared with other buckets, except by copying.
+func NewMemoryBucket(opts MemoryBucketOptions) (Bucket, error) {
+	if err := opts.validate(); err != nil {
+		return nil, err
+	}
+
+	return &memoryBucket{
+		opts:         opts,
+		objects:      map[string][]byte{},
+		lastModified: map[string]time.Time{},
+	}, nil
+}
+
+func (b *memoryBucket) normalizeKey(key string) string { return b.Join(b.opts.Prefix, key) }
+
+// normalizeListPrefix returns the prefix of the stored keys that have the
+// given prefix. Unlike normalizeKey, it keeps a trailing slash, and an empty
+// prefix only matches the keys under the bucket's prefix.
+func (b *memoryBucket) normalizeListPrefix(prefix string) string {
+	normalized := b.normalizeKey(prefix)
+	if normalized != "" && (strings.HasSuffix(prefix, "/") || prefix == "") {
+		normalized += "/"
+	}
+	return normalized
+}
+
+func (b *memoryBucket) denormalizeKey(key string) string {
+	return consistentTrimPrefix(key, b.opts.Prefix)
+}
+
+// get returns the data of the object with the given key.
+func (b *memoryBucket) get(key string) ([]byte, bool) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	data, ok := b.objects[b.normalizeKey(key)]
+	return data, ok
+}
+
+// set stores the data as the object with the given key, which the bucket
+// takes ownership of.
+func (b *memoryBucket) set(key string, data []byte) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.objects[b.normalizeKey(key)] = data
+	b.lastModified[b.normalizeKey(key)] = time.Now()
+}
+
+func (b *memoryBucket) Check(ctx context.Context) error { return errors.WithStack(ctx.Err()) }
+
+func (b *memoryBucket) Ping(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	if err := ctx.Err(); err != nil {
+		return 0, errors.WithStack(err)
+	}
+
+	return time.Since(start), nil
+}
+
+func (b *memoryBucket) Exists(_ context.Context, key string) (bool, error) {
+	_, ok := b.get(key)
+	return ok, nil
+}
+
+// objectHash returns the MD5 checksum of the object with the given key, as
+// reported by List, or an empty string if it does not exist.
+func (b *memoryBucket) objectHash(_ context.Context, key string) (string, error) {
+	data, ok := b.get(key)
+	if !ok {
+		return "", nil
+	}
+	sum := md5.Sum(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func (b *memoryBucket) Join(elems ...string) string { return consistentJoin(elems) }
+
+func (b *memoryBucket) URI(key string) string {
+	return "mem://" + b.opts.Name + "/" + b.normalizeKey(key)
+}
+
+func (b *memoryBucket) Writer(ctx context.Context, key string) (io.WriteCloser, error) {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "memory",
+		"dry_run":       b.opts.DryRun,
+		"operation":     "writer",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"key":           key,
+	})
+
+	if b.opts.DryRun {
+		return &mockWriteCloser{ctx: ctx}, nil
+	}
+
+	return &memoryWriteCloser{ctx: ctx, bucket: b, key: key}, nil
+}
+
+// memoryWriteCloser buffers the data written to it and stores it in the
+// bucket once it is closed, unless the context is canceled before then, so
+// that readers never see a partially written object.
+type memoryWriteCloser struct {
+	ctx    context.Context
+	bucket *memoryBucket
+	key    string
+	buf    bytes.Buffer
+	closed bool
+}
+
+func (w *memoryWriteCloser) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("writer already closed")
+	}
+	if err := w.ctx.Err(); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return w.buf.Write(p)
+}
+
+func (w *memoryWriteCloser) Close() error {
+	if w.closed {
+		return errors.New("writer already closed")
+	}
+	w.closed = true
+	if err := w.ctx.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+
+	w.bucket.set(w.key, w.buf.Bytes())
+	return nil
+}
+
+func (b *memoryBucket) Reader(_ context.Context, key string) (io.ReadCloser, error) {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "memory",
+		"operation":     "reader",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"key":           key,
+	})
+
+	data, ok := b.get(key)
+	if !ok {
+		return nil, MakeKeyNotFoundError(errors.Errorf("key '%s' does not exist", key))
+	}
+
+	return io.NopCloser(bytes.NewReader(data)), nil
+}
+
+func (b *memoryBucket) Put(ctx context.Context, key string, input io.Reader) error {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "memory",
+		"dry_run":       b.opts.DryRun,
+		"operation":     "put",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"key":           key,
+	})
+
+	data, err := io.ReadAll(input)
+	if err != nil {
+		return errors.Wrap(err, "reading data")
+	}
+	if err = ctx.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+	if b.opts.DryRun {
+		return nil
+	}
+
+	b.set(key, data)
+	return nil
+}
+
+func (b *memoryBucket) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "memory",
+		"operation":     "get",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"key":           key,
+	})
+
+	return b.Reader(ctx, key)
+}
+
+func (b *memoryBucket) Upload(ctx context.Context, key, path string) error {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "memory",
+		"dry_run":       b.opts.DryRun,
+		"operation":     "upload",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"key":           key,
+		"path":          path,
+	})
+
+	f, err := openUploadFile(ctx, path)
+	if err != nil {
+		return errors.Wrapf(err, "opening file '%s'", path)
+	}
+	defer f.Close()
+
+	return errors.WithStack(b.Put(ctx, key, f))
+}
+
+func (b *memoryBucket) Download(ctx context.Context, key, path string) error {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "memory",
+		"operation":     "download",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"key":           key,
+		"path":          path,
+	})
+
+	data, ok := b.get(key)
+	if !ok {
+		return MakeKeyNotFoundError(errors.Errorf("key '%s' does not exist", key))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return errors.Wrapf(err, "creating enclosing directory for file '%s'", path)
+	}
+
+	return errors.Wrapf(os.WriteFile(path, data, 0600), "writing file '%s'", path)
+}
+
+func (b *memoryBucket) Push(ctx context.Context, opts SyncOptions) error {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "memory",
+		"dry_run":       b.opts.DryRun,
+		"operation":     "push",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"remote":        opts.Remote,
+		"local":         opts.Local,
+		"exclude":       opts.Exclude,
+	})
+
+	var re *regexp.Regexp
+	var err error
+	if opts.Exclude != "" {
+		re, err = regexp.Compile(opts.Exclude)
+		if err != nil {
+			return errors.Wrap(err, "compiling exclude regex")
+		}
+	}
+
+	localPaths, err := walkLocalTree(ctx, opts.Local)
+	if err != nil {
+		return errors.Wrap(err, "finding local paths")
+	}
+
+	var completed []string
+	for _, path := range localPaths {
+		if err = ctx.Err(); err != nil {
+			return partialSyncError(ctx, errors.WithStack(err), completed)
+		}
+		if re != nil && re.MatchString(path) {
+			continue
+		}
+
+		target := b.Join(opts.Remote, path)
+		if err = b.Upload(ctx, target, filepath.Join(opts.Local, path)); err != nil {
+			if skipVanishedFile(opts, filepath.Join(opts.Local, path), err) {
+				continue
+			}
+			return partialSyncError(ctx, errors.Wrapf(err, "uploading file '%s' to '%s'", path, target), completed)
+		}
+		completed = append(completed, target)
+	}
+
+	if (b.opts.DeleteOnPush || b.opts.DeleteOnSync || opts.Mirror) && !b.opts.DryRun {
+		return errors.Wrap(deleteOnPush(ctx, localPaths, opts.Remote, b), "deleting on sync after push")
+	}
+
+	return nil
+}
+
+func (b *memoryBucket) Pull(ctx context.Context, opts SyncOptions) error {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "memory",
+		"operation":     "pull",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"remote":        opts.Remote,
+		"local":         opts.Local,
+		"exclude":       opts.Exclude,
+	})
+
+	var re *regexp.Regexp
+	var err error
+	if opts.Exclude != "" {
+		re, err = regexp.Compile(opts.Exclude)
+		if err != nil {
+			return errors.Wrap(err, "compiling exclude regex")
+		}
+	}
+
+	iter, err := b.List(ctx, dirPrefix(opts.Remote))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	keys := []string{}
+	var completed []string
+	for iter.Next(ctx) {
+		if err = ctx.Err(); err != nil {
+			return partialSyncError(ctx, errors.WithStack(err), completed)
+		}
+		item := iter.Item()
+		if re != nil && re.MatchString(item.Name()) {
+			continue
+		}
+
+		localName, err := filepath.Rel(opts.Remote, item.Name())
+		if err != nil {
+			return errors.Wrap(err, "getting relative filepath")
+		}
+		keys = append(keys, localName)
+
+		if err = b.Download(ctx, item.Name(), filepath.Join(opts.Local, localName)); err != nil {
+			return partialSyncError(ctx, errors.WithStack(err), completed)
+		}
+		completed = append(completed, item.Name())
+	}
+
+	if err = iter.Err(); err != nil {
+		return partialSyncError(ctx, errors.WithStack(err), completed)
+	}
+
+	if (b.opts.DeleteOnPull || b.opts.DeleteOnSync) && !b.opts.DryRun {
+		return errors.Wrap(deleteOnPull(ctx, keys, opts.Local), "deleting on sync after pull")
+	}
+
+	return nil
+}
+
+func (b *memoryBucket) Copy(ctx context.Context, opts CopyOptions) error {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "memory",
+		"operation":     "copy",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"source_key":    opts.SourceKey,
+		"dest_key":      opts.DestinationKey,
+		"if_not_exists": opts.IfNotExists,
+	})
+
+	// Objects are shared directly between memory buckets, since their
+	// data is never modified, and are otherwise streamed.
+	dest, ok := opts.DestinationBucket.(*memoryBucket)
+	if !ok {
+		return streamCopy(ctx, b, opts)
+	}
+
+	if err := checkCopyDestination(ctx, opts); err != nil {
+		return err
+	}
+	data, ok := b.get(opts.SourceKey)
+	if !ok {
+		return MakeKeyNotFoundError(errors.Errorf("key '%s' does not exist", opts.SourceKey))
+	}
+	if dest.opts.DryRun {
+		return nil
+	}
+
+	dest.set(opts.DestinationKey, data)
+	return nil
+}
+
+func (b *memoryBucket) Remove(ctx context.Context, key string) error {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "memory",
+		"dry_run":       b.opts.DryRun,
+		"operation":     "remove",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"key":           key,
+	})
+
+	return b.RemoveMany(ctx, key)
+}
+
+func (b *memoryBucket) RemoveMany(ctx context.Context, keys ...string) error {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "memory",
+		"dry_run":       b.opts.DryRun,
+		"operation":     "remove many",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"keys":          keys,
+	})
+
+	if err := ctx.Err(); err != nil {
+		return errors.WithStack(err)
+	}
+	if b.opts.DryRun {
+		return nil
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, key := range keys {
+		delete(b.objects, b.normalizeKey(key))
+		delete(b.lastModified, b.normalizeKey(key))
+	}
+
+	return nil
+}
+
+func (b *memoryBucket) RemovePrefix(ctx context.Context, prefix string) error {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "memory",
+		"dry_run":       b.opts.DryRun,
+		"operation":     "remove prefix",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"prefix":        prefix,
+	})
+
+	return removePrefix(ctx, prefix, b)
+}
+
+func (b *memoryBucket) RemoveMatching(ctx context.Context, expr string) error {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "memory",
+		"dry_run":       b.opts.DryRun,
+		"operation":     "remove matching",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"expression":    expr,
+	})
+
+	return removeMatching(ctx, expr, b)
+}
+
+func (b *memoryBucket) List(ctx context.Context, prefix string) (BucketIterator, error) {
+	grip.DebugWhen(b.opts.Verbose, message.Fields{
+		"type":          "memory",
+		"operation":     "list",
+		"bucket":        b.opts.Name,
+		"bucket_prefix": b.opts.Prefix,
+		"prefix":        prefix,
+	})
+
+	if err := ctx.Err(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	// The iterator lists the objects as they are when it is created, so
+	// that the bucket can be modified while it is iterated over.
+	listPrefix := b.normalizeListPrefix(prefix)
+	var items []*bucketItemImpl
+	b.mu.RLock()
+	for key, data := range b.objects {
+		if !strings.HasPrefix(key, listPrefix) {
+			continue
+		}
+		sum := md5.Sum(data)
+		items = append(items, &bucketItemImpl{
+			bucket:       b.opts.Name,
+			key:          b.denormalizeKey(key),
+			hash:         hex.EncodeToString(sum[:]),
+			lastModified: b.lastModified[key],
+			size:         int64(len(data)),
+			b:            b,
+		})
+	}
+	b.mu.RUnlock()
+	sort.Slice(items, func(i, j int) bool { return items[i].key < items[j].key })
+
+	return &memoryIterator{items: items, idx: -1}, nil
+}
+
+type memoryIterator struct {
+	items []*bucketItemImpl
+	idx   int
+	err   error
+}
+
+func (iter *memoryIterator) Err() error { return iter.err }
+
+func (iter *memoryIterator) Item() BucketItem {
+	if iter.idx < 0 || iter.idx >= len(iter.items) {
+		return nil
+	}
+	return iter.items[iter.idx]
+}
+
+func (iter *memoryIterator) Next(ctx context.Context) bool {
+	if iter.err != nil {
+		return false
+	}
+	if err := ctx.Err(); err != nil {
+		iter.err = errors.WithStack(err)
+		return false
+	}
+	if iter.idx+1 >= len(iter.items) {
+		iter.idx = len(iter.items)
+		return false
+	}
+
+	iter.idx++
+	return true
+}
@@ -0,0 +1,131 @@
+package pail
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestMemoryBucket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newBucket := func(t *testing.T, opts MemoryBucketOptions) *memoryBucket {
+		b, err := NewMemoryBucket(opts)
+		require.NoError(t, err)
+		return b.(*memoryBucket)
+	}
+
+	t.Run("ValidatesOptions", func(t *testing.T) {
+		_, err := NewMemoryBucket(MemoryBucketOptions{DeleteOnSync: true, DeleteOnPush: true})
+		assert.Error(t, err)
+	})
+	t.Run("MissingKeysDoNotExist", func(t *testing.T) {
+		b := newBucket(t, MemoryBucketOptions{})
+
+		_, err := b.Get(ctx, "missing")
+		assert.True(t, errors.Is(err, ErrNotExist))
+		err = b.Download(ctx, "missing", filepath.Join(t.TempDir(), "file"))
+		assert.True(t, errors.Is(err, ErrNotExist))
+		err = b.Copy(ctx, CopyOptions{SourceKey: "missing", DestinationKey: "key", DestinationBucket: b})
+		assert.True(t, errors.Is(err, ErrNotExist))
+		assert.NoError(t, b.RemoveMany(ctx, "missing"))
+	})
+	t.Run("CopiesBetweenMemoryBuckets", func(t *testing.T) {
+		src := newBucket(t, MemoryBucketOptions{Prefix: "src"})
+		dest := newBucket(t, MemoryBucketOptions{Prefix: "dest"})
+		require.NoError(t, writeDataToFile(ctx, src, "key", "some data"))
+
+		require.NoError(t, src.Copy(ctx, CopyOptions{SourceKey: "key", DestinationKey: "copy", DestinationBucket: dest}))
+		assert.Contains(t, dest.objects, "dest/copy")
+		data, err := readDataFromFile(ctx, dest, "copy")
+		require.NoError(t, err)
+		assert.Equal(t, "some data", data)
+
+		require.NoError(t, writeDataToFile(ctx, src, "key", "new data"))
+		data, err = readDataFromFile(ctx, dest, "copy")
+		require.NoError(t, err)
+		assert.Equal(t, "some data", data, "copies should not change with their source")
+		assert.Equal(t, []string{"key"}, listNames(ctx, t, src, ""))
+	})
+	t.Run("ObjectsAreNotSharedBetweenBuckets", func(t *testing.T) {
+		first := newBucket(t, MemoryBucketOptions{})
+		second := newBucket(t, MemoryBucketOptions{})
+		require.NoError(t, writeDataToFile(ctx, first, "key", "data"))
+
+		exists, err := second.Exists(ctx, "key")
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+	t.Run("ReadersAreUnaffectedByLaterWrites", func(t *testing.T) {
+		b := newBucket(t, MemoryBucketOptions{})
+		require.NoError(t, writeDataToFile(ctx, b, "key", "old data"))
+		r, err := b.Get(ctx, "key")
+		require.NoError(t, err)
+
+		require.NoError(t, writeDataToFile(ctx, b, "key", "new data"))
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, "old data", string(data))
+		require.NoError(t, r.Close())
+	})
+	t.Run("CanceledWriterDoesNotStoreObject", func(t *testing.T) {
+		b := newBucket(t, MemoryBucketOptions{})
+		tctx, tcancel := context.WithCancel(ctx)
+		w, err := b.Writer(tctx, "key")
+		require.NoError(t, err)
+		_, err = w.Write([]byte("data"))
+		require.NoError(t, err)
+		tcancel()
+
+		assert.Error(t, w.Close())
+		exists, err := b.Exists(ctx, "key")
+		require.NoError(t, err)
+		assert.False(t, exists)
+	})
+	t.Run("ListIsASnapshot", func(t *testing.T) {
+		b := newBucket(t, MemoryBucketOptions{Name: "memory"})
+		require.NoError(t, writeDataToFile(ctx, b, "a", "data"))
+		require.NoError(t, writeDataToFile(ctx, b, "b", "data"))
+
+		iter, err := b.List(ctx, "")
+		require.NoError(t, err)
+		require.NoError(t, b.Remove(ctx, "b"))
+		require.NoError(t, writeDataToFile(ctx, b, "c", "data"))
+		var names []string
+		for iter.Next(ctx) {
+			assert.Equal(t, "memory", iter.Item().Bucket())
+			assert.False(t, itemLastModified(iter.Item()).IsZero())
+			names = append(names, iter.Item().Name())
+		}
+		require.NoError(t, iter.Err())
+		assert.Equal(t, []string{"a", "b"}, names)
+	})
+	t.Run("SupportsConcurrentUse", func(t *testing.T) {
+		b := newBucket(t, MemoryBucketOptions{})
+		wg := &sync.WaitGroup{}
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				key := fmt.Sprintf("key%d", i)
+				assert.NoError(t, writeDataToFile(ctx, b, key, key))
+				data, err := readDataFromFile(ctx, b, key)
+				assert.NoError(t, err)
+				assert.Equal(t, key, data)
+				_, err = b.List(ctx, "")
+				assert.NoError(t, err)
+			}(i)
+		}
+		wg.Wait()
+
+		assert.Len(t, listNames(ctx, t, b, ""), 10)
+	})
+}
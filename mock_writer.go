@@ -1,9 +1,24 @@
 package pail
 
+import (
+	"context"
+
+	"github.com/pkg/errors"
+)
+
 // This is just a writer that does nothing, it is implemented for when the
-// local bucket is set with dryRun to true.
-type mockWriteCloser struct{}
+// local bucket is set with dryRun to true. Like other writers, it returns the
+// context's error once its context is done.
+type mockWriteCloser struct {
+	ctx context.Context
+}
 
 // These functions do not do anything.
-func (m *mockWriteCloser) Write(p []byte) (n int, err error) { return len(p), nil }
-func (m *mockWriteCloser) Close() error                      { return nil }
+func (m *mockWriteCloser) Write(p []byte) (n int, err error) {
+	if err := m.ctx.Err(); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return len(p), nil
+}
+
+func (m *mockWriteCloser) Close() error { return errors.WithStack(m.ctx.Err()) }
@@ -0,0 +1,213 @@
+package pail
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+)
+
+// OpenBucket constructs a bucket from a URL. The URL's scheme selects the
+// bucket implementation and its query parameters set the bucket options:
+//
+//   - s3://<bucket>/<prefix> constructs an S3 bucket. Supported parameters
+//     are region, multipart, compress, permissions, content_type,
+//     max_retries, assume_role_arn, shared_credentials_file and
+//     shared_credentials_profile. If creds is not nil, it is used as the
+//     bucket's credentials.
+//   - gridfs://[user:password@]<host>/<database>/<name> constructs a GridFS
+//     bucket. Supported parameters are prefix; all other parameters are
+//     passed through to the MongoDB connection string.
+//   - file://<path> constructs a local bucket. Supported parameters are
+//     prefix and use_slash.
+//
+// All schemes additionally support the dry_run, verbose, delete_on_sync,
+// delete_on_push and delete_on_pull parameters.
+func OpenBucket(ctx context.Context, rawURL string, creds aws.CredentialsProvider) (Bucket, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, errors.Wrap(err, "parsing bucket URL")
+	}
+
+	switch u.Scheme {
+	case "s3":
+		opts, multipart, err := parseS3URL(u)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing S3 bucket URL")
+		}
+		if creds != nil {
+			opts.Credentials = creds
+		}
+		if multipart {
+			return NewS3MultiPartBucket(ctx, opts)
+		}
+		return NewS3Bucket(ctx, opts)
+	case "gridfs":
+		opts, err := parseGridFSURL(u)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing GridFS bucket URL")
+		}
+		return NewGridFSBucket(ctx, opts)
+	case "file":
+		opts, err := parseLocalURL(u)
+		if err != nil {
+			return nil, errors.Wrap(err, "parsing local bucket URL")
+		}
+		return NewLocalBucket(opts)
+	default:
+		return nil, errors.Errorf("unsupported bucket URL scheme '%s'", u.Scheme)
+	}
+}
+
+// bucketURLParams reads typed values from the query parameters of a bucket
+// URL, accumulating any parse errors and tracking which parameters have been
+// consumed.
+type bucketURLParams struct {
+	values   url.Values
+	catcher  grip.Catcher
+	consumed map[string]bool
+}
+
+func newBucketURLParams(u *url.URL) *bucketURLParams {
+	return &bucketURLParams{
+		values:   u.Query(),
+		catcher:  grip.NewBasicCatcher(),
+		consumed: map[string]bool{},
+	}
+}
+
+func (p *bucketURLParams) string(name string) string {
+	p.consumed[name] = true
+	return p.values.Get(name)
+}
+
+func (p *bucketURLParams) bool(name string) bool {
+	val := p.string(name)
+	if val == "" {
+		return false
+	}
+
+	b, err := strconv.ParseBool(val)
+	p.catcher.Wrapf(err, "parsing parameter '%s'", name)
+	return b
+}
+
+func (p *bucketURLParams) int(name string) *int {
+	val := p.string(name)
+	if val == "" {
+		return nil
+	}
+
+	i, err := strconv.Atoi(val)
+	if err != nil {
+		p.catcher.Wrapf(err, "parsing parameter '%s'", name)
+		return nil
+	}
+	return &i
+}
+
+// unconsumed returns the query parameters that have not been read.
+func (p *bucketURLParams) unconsumed() url.Values {
+	remaining := url.Values{}
+	for name, vals := range p.values {
+		if !p.consumed[name] {
+			remaining[name] = vals
+		}
+	}
+	return remaining
+}
+
+// resolve returns any accumulated parse errors and, if strict is set, an
+// error for each unsupported parameter.
+func (p *bucketURLParams) resolve(strict bool) error {
+	if strict {
+		for name := range p.unconsumed() {
+			p.catcher.Errorf("unsupported parameter '%s'", name)
+		}
+	}
+	return p.catcher.Resolve()
+}
+
+func parseS3URL(u *url.URL) (S3Options, bool, error) {
+	if u.Host == "" {
+		return S3Options{}, false, errors.New("must specify a bucket name")
+	}
+
+	params := newBucketURLParams(u)
+	opts := S3Options{
+		Name:                      u.Host,
+		Prefix:                    strings.Trim(u.Path, "/"),
+		Region:                    params.string("region"),
+		Compress:                  params.bool("compress"),
+		Permissions:               S3Permissions(params.string("permissions")),
+		ContentType:               params.string("content_type"),
+		MaxRetries:                params.int("max_retries"),
+		AssumeRoleARN:             params.string("assume_role_arn"),
+		SharedCredentialsFilepath: params.string("shared_credentials_file"),
+		SharedCredentialsProfile:  params.string("shared_credentials_profile"),
+		DryRun:                    params.bool("dry_run"),
+		Verbose:                   params.bool("verbose"),
+		DeleteOnSync:              params.bool("delete_on_sync"),
+		DeleteOnPush:              params.bool("delete_on_push"),
+		DeleteOnPull:              params.bool("delete_on_pull"),
+	}
+	multipart := params.bool("multipart")
+
+	return opts, multipart, params.resolve(true)
+}
+
+func parseGridFSURL(u *url.URL) (GridFSOptions, error) {
+	parts := strings.Split(strings.Trim(u.Path, "/"), "/")
+	if u.Host == "" || len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return GridFSOptions{}, errors.New("URL must have the form 'gridfs://<host>/<database>/<name>'")
+	}
+
+	params := newBucketURLParams(u)
+	opts := GridFSOptions{
+		Database:     parts[0],
+		Name:         parts[1],
+		Prefix:       params.string("prefix"),
+		DryRun:       params.bool("dry_run"),
+		Verbose:      params.bool("verbose"),
+		DeleteOnSync: params.bool("delete_on_sync"),
+		DeleteOnPush: params.bool("delete_on_push"),
+		DeleteOnPull: params.bool("delete_on_pull"),
+	}
+	mongoURI := url.URL{
+		Scheme:   "mongodb",
+		User:     u.User,
+		Host:     u.Host,
+		Path:     "/",
+		RawQuery: params.unconsumed().Encode(),
+	}
+	opts.MongoDBURI = mongoURI.String()
+
+	return opts, params.resolve(false)
+}
+
+func parseLocalURL(u *url.URL) (LocalOptions, error) {
+	if u.Host != "" && u.Host != "localhost" {
+		return LocalOptions{}, errors.Errorf("unsupported host '%s'", u.Host)
+	}
+	if u.Path == "" {
+		return LocalOptions{}, errors.New("must specify a path")
+	}
+
+	params := newBucketURLParams(u)
+	opts := LocalOptions{
+		Path:         u.Path,
+		Prefix:       params.string("prefix"),
+		UseSlash:     params.bool("use_slash"),
+		DryRun:       params.bool("dry_run"),
+		Verbose:      params.bool("verbose"),
+		DeleteOnSync: params.bool("delete_on_sync"),
+		DeleteOnPush: params.bool("delete_on_push"),
+		DeleteOnPull: params.bool("delete_on_pull"),
+	}
+
+	return opts, params.resolve(true)
+}
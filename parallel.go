@@ -2,11 +2,13 @@ package pail
 
 import (
 	"context"
+	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
 	"sync"
 
+	"github.com/evergreen-ci/utility"
 	"github.com/mongodb/grip"
 	"github.com/mongodb/grip/message"
 	"github.com/pkg/errors"
@@ -14,10 +16,12 @@ import (
 
 type parallelBucketImpl struct {
 	Bucket
-	size         int
-	deleteOnPush bool
-	deleteOnPull bool
-	dryRun       bool
+	size            int
+	deleteOnPush    bool
+	deleteOnPull    bool
+	dryRun          bool
+	verifyChecksums bool
+	adaptive        bool
 }
 
 // ParallelBucketOptions support the use and creation of parallel sync buckets.
@@ -37,6 +41,19 @@ type ParallelBucketOptions struct {
 	// DeleteOnPull will delete all objects from the target that do not
 	// exist in the source after the completion of Pull.
 	DeleteOnPull bool
+	// VerifyChecksums verifies the MD5 checksum of each file downloaded
+	// during Pull against the hash stored in the bucket, if the bucket
+	// provides one. S3 objects whose ETags are not MD5 checksums, those
+	// uploaded in parts or encrypted with KMS keys or customer-provided
+	// keys, are not verified. A file whose checksum does not match is
+	// removed and the Pull returns an error.
+	VerifyChecksums bool
+	// AdaptiveConcurrency reduces the number of concurrent operations
+	// during Push and Pull when the underlying bucket reports that
+	// requests are being throttled (e.g. S3 SlowDown errors), and
+	// gradually increases it, up to Workers, once throttling subsides.
+	// Throttled operations are retried with backoff.
+	AdaptiveConcurrency bool
 }
 
 // NewParallelSyncBucket returns a layered bucket implemenation that supports
@@ -47,15 +64,29 @@ func NewParallelSyncBucket(opts ParallelBucketOptions, b Bucket) (Bucket, error)
 	}
 
 	return &parallelBucketImpl{
-		size:         opts.Workers,
-		deleteOnPush: opts.DeleteOnPush || opts.DeleteOnSync,
-		deleteOnPull: opts.DeleteOnPull || opts.DeleteOnSync,
-		dryRun:       opts.DryRun,
-		Bucket:       b,
+		size:            opts.Workers,
+		deleteOnPush:    opts.DeleteOnPush || opts.DeleteOnSync,
+		deleteOnPull:    opts.DeleteOnPull || opts.DeleteOnSync,
+		dryRun:          opts.DryRun,
+		verifyChecksums: opts.VerifyChecksums,
+		adaptive:        opts.AdaptiveConcurrency,
+		Bucket:          b,
 	}, nil
 }
 
+// RemovePrefix removes the objects with the given prefix from the underlying
+// bucket, unless the parallel bucket is a dry run.
+func (b *parallelBucketImpl) RemovePrefix(ctx context.Context, prefix string) error {
+	if b.dryRun {
+		return nil
+	}
+	return b.Bucket.RemovePrefix(ctx, prefix)
+}
+
 func (b *parallelBucketImpl) Push(ctx context.Context, opts SyncOptions) error {
+	// The sync's context is also canceled when a transfer fails, so keep
+	// the caller's context to tell whether the caller canceled the sync.
+	callerCtx := ctx
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -73,16 +104,48 @@ func (b *parallelBucketImpl) Push(ctx context.Context, opts SyncOptions) error {
 		return errors.WithStack(err)
 	}
 
-	in := make(chan string, len(files))
-	for i := range files {
-		if re != nil && re.MatchString(files[i]) {
+	// Uploads read their files through the context's bandwidth limiter, so
+	// that the limit is shared by the workers without changing what Upload
+	// does otherwise.
+	uploadCtx := ctx
+	if opts.MaxBandwidthBytesPerSec > 0 {
+		uploadCtx = withBandwidthLimiter(ctx, newBandwidthLimiter(opts.MaxBandwidthBytesPerSec))
+	}
+	upload := func(key, path string) error {
+		return b.Bucket.Upload(uploadCtx, key, path)
+	}
+	if b.adaptive {
+		limiter := newAdaptiveConcurrencyLimiter(b.size)
+		baseUpload := upload
+		upload = func(key, path string) error {
+			return limiter.do(ctx, func() error { return baseUpload(key, path) })
+		}
+	}
+
+	var included []string
+	var keys []string
+	for _, fn := range files {
+		if re != nil && re.MatchString(fn) {
 			continue
 		}
-		in <- files[i]
+		included = append(included, fn)
+		keys = append(keys, filepath.Join(opts.Remote, fn))
+	}
+	// Check every key before uploading anything, so that an invalid key
+	// does not leave the push partially complete.
+	if err = validateKeys(b.Bucket, keys); err != nil {
+		return errors.Wrap(err, "validating keys to push")
+	}
+
+	in := make(chan string, len(included))
+	for _, fn := range included {
+		in <- fn
 	}
 	close(in)
 	wg := &sync.WaitGroup{}
 	catcher := grip.NewBasicCatcher()
+	failure := newFirstFailure(cancel)
+	progress := &syncProgress{}
 	for i := 0; i < b.size; i++ {
 		wg.Add(1)
 		go func() {
@@ -98,23 +161,39 @@ func (b *parallelBucketImpl) Push(ctx context.Context, opts SyncOptions) error {
 					continue
 				}
 
-				if err := b.Bucket.Upload(ctx, filepath.Join(opts.Remote, fn), filepath.Join(opts.Local, fn)); err != nil {
-					catcher.Add(err)
-					cancel()
+				key := filepath.Join(opts.Remote, fn)
+				path := filepath.Join(opts.Local, fn)
+				if err := upload(key, path); err != nil {
+					if !skipVanishedFile(opts, path, err) {
+						catcher.Add(err)
+						failure.set(err)
+					}
+					continue
 				}
+				progress.add(key)
 			}
 		}()
 	}
+	if opts.FailFast {
+		if err := failure.waitOrFail(wg); err != nil {
+			return partialSyncError(callerCtx, errors.WithStack(err), progress.completed())
+		}
+	}
 	wg.Wait()
 
-	if ctx.Err() == nil && b.deleteOnPush && !b.dryRun {
+	if ctx.Err() == nil && (b.deleteOnPush || opts.Mirror) && !b.dryRun {
 		catcher.Wrap(deleteOnPush(ctx, files, opts.Remote, b), "deleting on sync after push")
 	}
 
-	return catcher.Resolve()
-
+	// Workers stop without an error once the sync is canceled, so record
+	// the cancellation itself.
+	if err := callerCtx.Err(); err != nil && !catcher.HasErrors() {
+		catcher.Add(err)
+	}
+	return partialSyncError(callerCtx, catcher.Resolve(), progress.completed())
 }
 func (b *parallelBucketImpl) Pull(ctx context.Context, opts SyncOptions) error {
+	callerCtx := ctx
 	ctx, cancel := context.WithCancel(ctx)
 	defer cancel()
 
@@ -127,12 +206,24 @@ func (b *parallelBucketImpl) Pull(ctx context.Context, opts SyncOptions) error {
 		}
 	}
 
-	iter, err := b.List(ctx, opts.Remote)
+	iter, err := b.List(ctx, dirPrefix(opts.Remote))
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
+	download := func(key, path string) error {
+		return b.Download(ctx, key, path)
+	}
+	if b.adaptive {
+		limiter := newAdaptiveConcurrencyLimiter(b.size)
+		download = func(key, path string) error {
+			return limiter.do(ctx, func() error { return b.Download(ctx, key, path) })
+		}
+	}
+
 	catcher := grip.NewBasicCatcher()
+	failure := newFirstFailure(cancel)
+	progress := &syncProgress{}
 	items := make(chan BucketItem)
 	toDelete := make(chan string)
 
@@ -140,11 +231,6 @@ func (b *parallelBucketImpl) Pull(ctx context.Context, opts SyncOptions) error {
 		defer close(items)
 
 		for iter.Next(ctx) {
-			if iter.Err() != nil {
-				cancel()
-				catcher.Wrap(iter.Err(), "iterating bucket")
-			}
-
 			if re != nil && re.MatchString(iter.Item().Name()) {
 				continue
 			}
@@ -156,24 +242,49 @@ func (b *parallelBucketImpl) Pull(ctx context.Context, opts SyncOptions) error {
 			case items <- iter.Item():
 			}
 		}
+		if err := iter.Err(); err != nil {
+			err = errors.Wrap(err, "iterating bucket")
+			catcher.Add(err)
+			failure.set(err)
+		}
 	}()
 
+	// Each worker accumulates errors in its own catcher, which are merged
+	// into the main catcher once all of the workers have finished.
+	workerCatchers := make([]grip.Catcher, b.size)
 	wg := &sync.WaitGroup{}
 	for i := 0; i < b.size; i++ {
+		workerCatchers[i] = grip.NewBasicCatcher()
 		wg.Add(1)
-		go func() {
+		go func(workerCatcher grip.Catcher) {
 			defer wg.Done()
 			for item := range items {
+				if err := ctx.Err(); err != nil {
+					workerCatcher.Add(err)
+					return
+				}
+
 				name, err := filepath.Rel(opts.Remote, item.Name())
 				if err != nil {
-					catcher.Wrap(err, "getting relative filepath")
-					cancel()
+					err = errors.Wrap(err, "getting relative filepath")
+					workerCatcher.Add(err)
+					failure.set(err)
+					continue
 				}
 				localName := filepath.Join(opts.Local, name)
-				if err := b.Download(ctx, item.Name(), localName); err != nil {
-					catcher.Add(err)
-					cancel()
+				if err = download(item.Name(), localName); err != nil {
+					workerCatcher.Add(err)
+					failure.set(err)
+					continue
+				}
+				if b.verifyChecksums {
+					if err = verifyDownloadChecksum(ctx, b.Bucket, item, localName); err != nil {
+						workerCatcher.Add(err)
+						failure.set(err)
+						continue
+					}
 				}
+				progress.add(item.Name())
 
 				fn := strings.TrimPrefix(item.Name(), opts.Remote)
 				fn = strings.TrimPrefix(fn, "/")
@@ -181,12 +292,12 @@ func (b *parallelBucketImpl) Pull(ctx context.Context, opts SyncOptions) error {
 
 				select {
 				case <-ctx.Done():
-					catcher.Add(ctx.Err())
+					workerCatcher.Add(ctx.Err())
 					return
 				case toDelete <- fn:
 				}
 			}
-		}()
+		}(workerCatchers[i])
 	}
 	go func() {
 		wg.Wait()
@@ -212,10 +323,136 @@ func (b *parallelBucketImpl) Pull(ctx context.Context, opts SyncOptions) error {
 		}
 	}()
 
+	if opts.FailFast {
+		select {
+		case <-deleteSignal:
+		case <-failure.failed:
+		}
+		if err := failure.first(); err != nil {
+			return partialSyncError(callerCtx, errors.WithStack(err), progress.completed())
+		}
+	}
+	<-deleteSignal
+
+	for _, workerCatcher := range workerCatchers {
+		catcher.Extend(workerCatcher.Errors())
+	}
+
+	return partialSyncError(callerCtx, catcher.Resolve(), progress.completed())
+}
+
+// syncProgress records the remote keys that the workers of a sync have
+// completed.
+type syncProgress struct {
+	mu   sync.Mutex
+	keys []string
+}
+
+func (p *syncProgress) add(key string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.keys = append(p.keys, key)
+}
+
+// completed returns a copy of the completed keys, since workers may still be
+// adding to them.
+func (p *syncProgress) completed() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return append([]string(nil), p.keys...)
+}
+
+// firstFailure records the first error of a sync operation, canceling the
+// operation when it occurs.
+type firstFailure struct {
+	once   sync.Once
+	cancel context.CancelFunc
+	err    error
+	// failed is closed once err is set.
+	failed chan struct{}
+}
+
+func newFirstFailure(cancel context.CancelFunc) *firstFailure {
+	return &firstFailure{cancel: cancel, failed: make(chan struct{})}
+}
+
+// set records the error if it is the first, and cancels the operation.
+func (f *firstFailure) set(err error) {
+	f.once.Do(func() {
+		f.err = err
+		close(f.failed)
+	})
+	f.cancel()
+}
+
+// waitOrFail waits for the workers to finish, returning early with the first
+// error if one occurs before they do.
+func (f *firstFailure) waitOrFail(wg *sync.WaitGroup) error {
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-f.failed:
+	}
+	return f.first()
+}
+
+// first returns the first error, or nil if none has occurred.
+func (f *firstFailure) first() error {
 	select {
-	case <-ctx.Done():
-	case <-deleteSignal:
+	case <-f.failed:
+		return f.err
+	default:
+		return nil
+	}
+}
+
+// etagChecksumBucket is implemented by buckets whose item hashes are ETags,
+// which are only MD5 checksums of the data of some objects.
+type etagChecksumBucket interface {
+	// etagIsMD5 returns whether the ETag of the object with the given key
+	// is the MD5 checksum of its data.
+	etagIsMD5(ctx context.Context, key string) (bool, error)
+}
+
+// verifyDownloadChecksum checks that the MD5 checksum of the downloaded file
+// matches the hash of the bucket item. Items without a hash, such as those
+// from buckets that do not store checksums, are not verified, nor are items
+// whose hash is not an MD5 checksum, such as the ETags of S3 objects that were
+// uploaded in parts, encrypted with KMS keys or compressed. If the checksums
+// do not match, the downloaded file is removed.
+func verifyDownloadChecksum(ctx context.Context, b Bucket, item BucketItem, path string) error {
+	if item.Hash() == "" || isMultipartETag(item.Hash()) {
+		return nil
 	}
 
+	localmd5, err := utility.MD5SumFile(path)
+	if err != nil {
+		return errors.Wrapf(err, "checksumming downloaded file '%s'", path)
+	}
+	if localmd5 == item.Hash() {
+		return nil
+	}
+	// Whether an ETag is a checksum is only checked for the files that do
+	// not match it, to avoid a request for each file.
+	if etagBucket, ok := b.(etagChecksumBucket); ok {
+		isMD5, err := etagBucket.etagIsMD5(ctx, item.Name())
+		if err != nil {
+			return errors.Wrapf(err, "checking ETag of key '%s'", item.Name())
+		}
+		if !isMD5 {
+			return nil
+		}
+	}
+
+	catcher := grip.NewBasicCatcher()
+	catcher.Errorf("checksum mismatch for key '%s': expected '%s' but downloaded file '%s' has '%s'", item.Name(), item.Hash(), path, localmd5)
+	catcher.Wrapf(os.Remove(path), "removing corrupt file '%s'", path)
 	return catcher.Resolve()
 }
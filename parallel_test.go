@@ -0,0 +1,577 @@
+package pail
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// checksummedBucket wraps a bucket so that listed items report the MD5
+// checksum recorded when the object was written, simulating a bucket that
+// stores content hashes as metadata.
+type checksummedBucket struct {
+	Bucket
+	hashes map[string]string
+	// notMD5 are the keys whose hashes are not MD5 checksums, like the
+	// ETags of S3 objects encrypted with KMS keys.
+	notMD5 map[string]bool
+}
+
+func (b *checksummedBucket) etagIsMD5(_ context.Context, key string) (bool, error) {
+	return !b.notMD5[key], nil
+}
+
+func (b *checksummedBucket) List(ctx context.Context, prefix string) (BucketIterator, error) {
+	iter, err := b.Bucket.List(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	return &checksummedIterator{BucketIterator: iter, hashes: b.hashes}, nil
+}
+
+type checksummedIterator struct {
+	BucketIterator
+	hashes map[string]string
+}
+
+func (iter *checksummedIterator) Item() BucketItem {
+	item := iter.BucketIterator.Item()
+	if item == nil {
+		return nil
+	}
+	return &bucketItemImpl{
+		bucket:       item.Bucket(),
+		key:          item.Name(),
+		hash:         iter.hashes[item.Name()],
+		lastModified: itemLastModified(item),
+	}
+}
+
+// vanishingBucket wraps a bucket so that the local files for the given keys are
+// removed immediately before they are uploaded, simulating files that are
+// deleted while a Push is in progress.
+type vanishingBucket struct {
+	Bucket
+	vanish map[string]bool
+}
+
+func (b *vanishingBucket) Upload(ctx context.Context, key, path string) error {
+	if b.vanish[key] {
+		if err := os.Remove(path); err != nil {
+			return err
+		}
+	}
+	return b.Bucket.Upload(ctx, key, path)
+}
+
+// throttlingBucket wraps a bucket so that uploads fail with a SlowDown error
+// whenever more than maxConcurrent uploads are in flight, simulating S3
+// throttling under heavy load. It records the number of uploads in flight
+// when each upload started.
+type throttlingBucket struct {
+	Bucket
+	maxConcurrent int
+	mu            sync.Mutex
+	inFlight      int
+	history       []int
+	slowDowns     int
+}
+
+func (b *throttlingBucket) Upload(ctx context.Context, key, path string) error {
+	b.mu.Lock()
+	b.inFlight++
+	b.history = append(b.history, b.inFlight)
+	throttled := b.inFlight > b.maxConcurrent
+	if throttled {
+		b.slowDowns++
+	}
+	b.mu.Unlock()
+	defer func() {
+		b.mu.Lock()
+		b.inFlight--
+		b.mu.Unlock()
+	}()
+
+	time.Sleep(5 * time.Millisecond)
+	if throttled {
+		return mockS3APIError("SlowDown")
+	}
+	return b.Bucket.Upload(ctx, key, path)
+}
+
+// stallingBucket wraps a bucket so that all uploads other than the failing
+// key block, ignoring cancellation, until release is closed, and the upload of
+// the failing key fails once another upload is blocked, simulating slow
+// transfers in progress when a fatal error occurs. It records the number of
+// uploads started.
+type stallingBucket struct {
+	Bucket
+	failing   string
+	release   chan struct{}
+	stalled   chan struct{}
+	stallOnce sync.Once
+	mu        sync.Mutex
+	started   int
+}
+
+func newStallingBucket(b Bucket, failing string) *stallingBucket {
+	return &stallingBucket{Bucket: b, failing: failing, release: make(chan struct{}), stalled: make(chan struct{})}
+}
+
+func (b *stallingBucket) Upload(ctx context.Context, key, path string) error {
+	b.mu.Lock()
+	b.started++
+	b.mu.Unlock()
+
+	if key == b.failing {
+		<-b.stalled
+		return errors.New("access denied")
+	}
+	b.stallOnce.Do(func() { close(b.stalled) })
+	<-b.release
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return b.Bucket.Upload(ctx, key, path)
+}
+
+func md5Hex(data string) string {
+	sum := md5.Sum([]byte(data))
+	return hex.EncodeToString(sum[:])
+}
+
+func TestParallelPull(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	setup := func(t *testing.T, numFiles int) (*checksummedBucket, map[string]string) {
+		local, err := NewLocalBucket(LocalOptions{Path: t.TempDir()})
+		require.NoError(t, err)
+
+		b := &checksummedBucket{Bucket: local, hashes: map[string]string{}}
+		data := map[string]string{}
+		for i := 0; i < numFiles; i++ {
+			key := fmt.Sprintf("file%d", i)
+			content := fmt.Sprintf("content for file %d", i)
+			require.NoError(t, writeDataToFile(ctx, local, filepath.Join("remote", key), content))
+			b.hashes[filepath.Join("remote", key)] = md5Hex(content)
+			data[key] = content
+		}
+
+		return b, data
+	}
+
+	t.Run("ConcurrentWorkersDownloadAllFiles", func(t *testing.T) {
+		b, data := setup(t, 100)
+		pb, err := NewParallelSyncBucket(ParallelBucketOptions{Workers: 16, VerifyChecksums: true}, b)
+		require.NoError(t, err)
+
+		local := t.TempDir()
+		require.NoError(t, pb.Pull(ctx, SyncOptions{Local: local, Remote: "remote"}))
+		assert.NoError(t, checkLocalTreeMatchesData(ctx, local, data))
+	})
+	t.Run("CorruptedFileFailsVerification", func(t *testing.T) {
+		b, _ := setup(t, 10)
+		b.hashes[filepath.Join("remote", "file3")] = md5Hex("original content")
+
+		pb, err := NewParallelSyncBucket(ParallelBucketOptions{Workers: 4, VerifyChecksums: true}, b)
+		require.NoError(t, err)
+
+		local := t.TempDir()
+		err = pb.Pull(ctx, SyncOptions{Local: local, Remote: "remote"})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "checksum mismatch")
+		assert.Contains(t, err.Error(), "file3")
+
+		_, err = os.Stat(filepath.Join(local, "file3"))
+		assert.True(t, os.IsNotExist(err))
+	})
+	t.Run("MultipartETagsAreNotVerified", func(t *testing.T) {
+		b, data := setup(t, 10)
+		b.hashes[filepath.Join("remote", "file3")] = md5Hex("content for file 3") + "-2"
+
+		pb, err := NewParallelSyncBucket(ParallelBucketOptions{Workers: 4, VerifyChecksums: true}, b)
+		require.NoError(t, err)
+
+		local := t.TempDir()
+		require.NoError(t, pb.Pull(ctx, SyncOptions{Local: local, Remote: "remote"}))
+		assert.NoError(t, checkLocalTreeMatchesData(ctx, local, data))
+	})
+	t.Run("ETagsThatAreNotChecksumsAreNotVerified", func(t *testing.T) {
+		b, data := setup(t, 10)
+		b.hashes[filepath.Join("remote", "file3")] = md5Hex("encrypted")
+		b.notMD5 = map[string]bool{filepath.Join("remote", "file3"): true}
+
+		pb, err := NewParallelSyncBucket(ParallelBucketOptions{Workers: 4, VerifyChecksums: true}, b)
+		require.NoError(t, err)
+
+		local := t.TempDir()
+		require.NoError(t, pb.Pull(ctx, SyncOptions{Local: local, Remote: "remote"}))
+		assert.NoError(t, checkLocalTreeMatchesData(ctx, local, data))
+	})
+	t.Run("CorruptedFileIsIgnoredWithoutVerification", func(t *testing.T) {
+		b, data := setup(t, 10)
+		b.hashes[filepath.Join("remote", "file3")] = md5Hex("original content")
+
+		pb, err := NewParallelSyncBucket(ParallelBucketOptions{Workers: 4}, b)
+		require.NoError(t, err)
+
+		local := t.TempDir()
+		require.NoError(t, pb.Pull(ctx, SyncOptions{Local: local, Remote: "remote"}))
+		assert.NoError(t, checkLocalTreeMatchesData(ctx, local, data))
+	})
+}
+
+func TestParallelPush(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	setup := func(t *testing.T) (*vanishingBucket, string, map[string]string) {
+		remote, err := NewLocalBucket(LocalOptions{Path: t.TempDir()})
+		require.NoError(t, err)
+
+		local := t.TempDir()
+		data := map[string]string{}
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("file%d", i)
+			content := fmt.Sprintf("content for file %d", i)
+			require.NoError(t, os.WriteFile(filepath.Join(local, key), []byte(content), 0600))
+			data[key] = content
+		}
+
+		b := &vanishingBucket{Bucket: remote, vanish: map[string]bool{filepath.Join("remote", "file3"): true}}
+		return b, local, data
+	}
+
+	t.Run("VanishedFileFailsPush", func(t *testing.T) {
+		b, local, _ := setup(t)
+		pb, err := NewParallelSyncBucket(ParallelBucketOptions{Workers: 4}, b)
+		require.NoError(t, err)
+
+		assert.Error(t, pb.Push(ctx, SyncOptions{Local: local, Remote: "remote"}))
+	})
+	t.Run("VanishedFileIsSkippedWithSkipVanishedFiles", func(t *testing.T) {
+		b, local, data := setup(t)
+		pb, err := NewParallelSyncBucket(ParallelBucketOptions{Workers: 4}, b)
+		require.NoError(t, err)
+
+		require.NoError(t, pb.Push(ctx, SyncOptions{Local: local, Remote: "remote", SkipVanishedFiles: true}))
+
+		delete(data, "file3")
+		pulled := t.TempDir()
+		require.NoError(t, b.Pull(ctx, SyncOptions{Local: pulled, Remote: "remote"}))
+		assert.NoError(t, checkLocalTreeMatchesData(ctx, pulled, data))
+	})
+	t.Run("MaxBandwidthLimitsAggregateThroughput", func(t *testing.T) {
+		remote, err := NewLocalBucket(LocalOptions{Path: t.TempDir()})
+		require.NoError(t, err)
+		recorder, recorded := NewRecordingBucket(remote)
+		pb, err := NewParallelSyncBucket(ParallelBucketOptions{Workers: 4}, recorded)
+		require.NoError(t, err)
+
+		const (
+			numFiles       = 8
+			fileSize       = 25 * 1024
+			bytesPerSecond = 200 * 1024
+		)
+		local := t.TempDir()
+		data := map[string]string{}
+		for i := 0; i < numFiles; i++ {
+			key := fmt.Sprintf("file%d", i)
+			content := strings.Repeat(fmt.Sprint(i), fileSize)
+			require.NoError(t, os.WriteFile(filepath.Join(local, key), []byte(content), 0600))
+			data[key] = content
+		}
+
+		start := time.Now()
+		require.NoError(t, pb.Push(ctx, SyncOptions{Local: local, Remote: "remote", MaxBandwidthBytesPerSec: bytesPerSecond}))
+		elapsed := time.Since(start)
+
+		throughput := float64(numFiles*fileSize) / elapsed.Seconds()
+		assert.LessOrEqual(t, throughput, float64(bytesPerSecond))
+		// Throttled files are still uploaded with Upload, so that the
+		// bucket's own upload behavior applies.
+		for _, call := range recorder.Calls() {
+			assert.Equal(t, "Upload", call.Method)
+		}
+		assert.Len(t, recorder.Calls(), numFiles)
+
+		pulled := t.TempDir()
+		require.NoError(t, remote.Pull(ctx, SyncOptions{Local: pulled, Remote: "remote"}))
+		assert.NoError(t, checkLocalTreeMatchesData(ctx, pulled, data))
+	})
+	t.Run("FailFastReturnsFirstErrorWithoutWaiting", func(t *testing.T) {
+		_, local, _ := setup(t)
+		remote, err := NewLocalBucket(LocalOptions{Path: t.TempDir()})
+		require.NoError(t, err)
+		b := newStallingBucket(remote, filepath.Join("remote", "file0"))
+		defer close(b.release)
+		pb, err := NewParallelSyncBucket(ParallelBucketOptions{Workers: 2}, b)
+		require.NoError(t, err)
+
+		errs := make(chan error, 1)
+		go func() { errs <- pb.Push(ctx, SyncOptions{Local: local, Remote: "remote", FailFast: true}) }()
+		select {
+		case err := <-errs:
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "access denied")
+		case <-time.After(5 * time.Second):
+			t.Fatal("push did not return after the first error")
+		}
+
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		assert.LessOrEqual(t, b.started, 3, "push should stop starting uploads after the first error")
+	})
+	t.Run("WaitsForUploadsInProgressByDefault", func(t *testing.T) {
+		_, local, _ := setup(t)
+		remote, err := NewLocalBucket(LocalOptions{Path: t.TempDir()})
+		require.NoError(t, err)
+		b := newStallingBucket(remote, filepath.Join("remote", "file0"))
+		pb, err := NewParallelSyncBucket(ParallelBucketOptions{Workers: 2}, b)
+		require.NoError(t, err)
+
+		errs := make(chan error, 1)
+		go func() { errs <- pb.Push(ctx, SyncOptions{Local: local, Remote: "remote"}) }()
+		select {
+		case <-errs:
+			t.Fatal("push returned before the upload in progress finished")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		close(b.release)
+		err = <-errs
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "access denied")
+	})
+	t.Run("AdaptiveConcurrencyBacksOffWhenThrottled", func(t *testing.T) {
+		remote, err := NewLocalBucket(LocalOptions{Path: t.TempDir()})
+		require.NoError(t, err)
+		b := &throttlingBucket{Bucket: remote, maxConcurrent: 4}
+
+		local := t.TempDir()
+		data := map[string]string{}
+		for i := 0; i < 100; i++ {
+			key := fmt.Sprintf("file%d", i)
+			content := fmt.Sprintf("content for file %d", i)
+			require.NoError(t, os.WriteFile(filepath.Join(local, key), []byte(content), 0600))
+			data[key] = content
+		}
+
+		pb, err := NewParallelSyncBucket(ParallelBucketOptions{Workers: 16}, b)
+		require.NoError(t, err)
+		assert.Error(t, pb.Push(ctx, SyncOptions{Local: local, Remote: "remote"}))
+
+		b.history = nil
+		b.slowDowns = 0
+		pb, err = NewParallelSyncBucket(ParallelBucketOptions{Workers: 16, AdaptiveConcurrency: true}, b)
+		require.NoError(t, err)
+		require.NoError(t, pb.Push(ctx, SyncOptions{Local: local, Remote: "remote"}))
+		assert.NotZero(t, b.slowDowns)
+
+		// Once the workers have adapted, the number of uploads in flight
+		// should stay close to the throttling threshold, only exceeding it
+		// by one while probing for more capacity.
+		require.Greater(t, len(b.history), 100)
+		for _, inFlight := range b.history[len(b.history)/2:] {
+			assert.LessOrEqual(t, inFlight, b.maxConcurrent+1)
+		}
+
+		pulled := t.TempDir()
+		require.NoError(t, remote.Pull(ctx, SyncOptions{Local: pulled, Remote: "remote"}))
+		assert.NoError(t, checkLocalTreeMatchesData(ctx, pulled, data))
+	})
+}
+
+func TestAdaptiveConcurrencyLimiter(t *testing.T) {
+	slowDown := mockS3APIError("SlowDown")
+
+	t.Run("ThrottlingHalvesLimitOncePerGeneration", func(t *testing.T) {
+		l := newAdaptiveConcurrencyLimiter(8)
+		generations := make([]int, 4)
+		for i := range generations {
+			generations[i] = l.acquire()
+		}
+		for _, generation := range generations {
+			l.release(generation, slowDown)
+		}
+		assert.Equal(t, 4, l.currentLimit())
+
+		l.release(l.acquire(), slowDown)
+		l.release(l.acquire(), slowDown)
+		l.release(l.acquire(), slowDown)
+		assert.Equal(t, 1, l.currentLimit())
+	})
+	t.Run("SaturatedSuccessesIncreaseLimitUpToMax", func(t *testing.T) {
+		l := newAdaptiveConcurrencyLimiter(4)
+		l.release(l.acquire(), slowDown)
+		l.release(l.acquire(), slowDown)
+		require.Equal(t, 1, l.currentLimit())
+
+		// succeed runs n operations while holding all but one of the
+		// limiter's slots, so that each operation reaches the limit.
+		succeed := func(n int) {
+			held := make([]int, l.currentLimit()-1)
+			for i := range held {
+				held[i] = l.acquire()
+			}
+			for i := 0; i < n; i++ {
+				l.release(l.acquire(), nil)
+			}
+			for _, generation := range held {
+				l.release(generation, nil)
+			}
+		}
+
+		succeed(1)
+		assert.Equal(t, 2, l.currentLimit())
+		succeed(2)
+		assert.Equal(t, 3, l.currentLimit())
+		succeed(20)
+		assert.Equal(t, 4, l.currentLimit())
+	})
+	t.Run("UnsaturatedSuccessesDoNotIncreaseLimit", func(t *testing.T) {
+		l := newAdaptiveConcurrencyLimiter(4)
+		l.release(l.acquire(), slowDown)
+		require.Equal(t, 2, l.currentLimit())
+
+		for i := 0; i < 10; i++ {
+			l.release(l.acquire(), nil)
+		}
+		assert.Equal(t, 2, l.currentLimit())
+	})
+	t.Run("OtherErrorsDoNotChangeLimit", func(t *testing.T) {
+		l := newAdaptiveConcurrencyLimiter(4)
+		l.release(l.acquire(), errors.New("error"))
+		l.release(l.acquire(), mockS3APIError("AccessDenied"))
+		assert.Equal(t, 4, l.currentLimit())
+	})
+}
+
+// cancelingBucket wraps a bucket so that the sync is canceled once a given
+// number of uploads or downloads complete. It records the keys of the
+// completed transfers.
+type cancelingBucket struct {
+	Bucket
+	after     int
+	cancel    context.CancelFunc
+	mu        sync.Mutex
+	completed []string
+}
+
+func (b *cancelingBucket) transferred(key string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.completed = append(b.completed, key)
+	if len(b.completed) == b.after {
+		b.cancel()
+	}
+}
+
+func (b *cancelingBucket) Upload(ctx context.Context, key, path string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := b.Bucket.Upload(ctx, key, path); err != nil {
+		return err
+	}
+	b.transferred(key)
+	return nil
+}
+
+func (b *cancelingBucket) Download(ctx context.Context, key, path string) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := b.Bucket.Download(ctx, key, path); err != nil {
+		return err
+	}
+	b.transferred(key)
+	return nil
+}
+
+func TestSyncCancellation(t *testing.T) {
+	const numFiles = 10
+	setup := func(t *testing.T) (Bucket, string) {
+		remote, err := NewLocalBucket(LocalOptions{Path: t.TempDir()})
+		require.NoError(t, err)
+
+		local := t.TempDir()
+		for i := 0; i < numFiles; i++ {
+			require.NoError(t, os.WriteFile(filepath.Join(local, fmt.Sprintf("file%d", i)), []byte(fmt.Sprint(i)), 0600))
+		}
+		return remote, local
+	}
+	assertPartial := func(t *testing.T, err error, b *cancelingBucket) {
+		require.Error(t, err)
+
+		var partialErr *PartialSyncError
+		require.True(t, errors.As(err, &partialErr))
+		assert.Len(t, partialErr.Completed, b.after)
+		assert.ElementsMatch(t, b.completed, partialErr.Completed)
+	}
+
+	for name, makeSyncBucket := range map[string]func(t *testing.T, b Bucket) SyncBucket{
+		"Parallel": func(t *testing.T, b Bucket) SyncBucket {
+			pb, err := NewParallelSyncBucket(ParallelBucketOptions{Workers: 1}, b)
+			require.NoError(t, err)
+			return pb
+		},
+		"Sharded": func(t *testing.T, b Bucket) SyncBucket {
+			return NewShardedBucket([]Bucket{b}, nil)
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Run("PushReturnsCompletedKeys", func(t *testing.T) {
+				remote, local := setup(t)
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				b := &cancelingBucket{Bucket: remote, after: 4, cancel: cancel}
+
+				assertPartial(t, makeSyncBucket(t, b).Push(ctx, SyncOptions{Local: local, Remote: "remote"}), b)
+				for _, key := range b.completed {
+					exists, err := remote.Exists(context.Background(), key)
+					require.NoError(t, err)
+					assert.True(t, exists, key)
+				}
+				count, err := Count(context.Background(), remote, "remote")
+				require.NoError(t, err)
+				assert.Equal(t, b.after, count)
+			})
+			t.Run("PullReturnsCompletedKeys", func(t *testing.T) {
+				remote, local := setup(t)
+				require.NoError(t, remote.Push(context.Background(), SyncOptions{Local: local, Remote: "remote"}))
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				b := &cancelingBucket{Bucket: remote, after: 3, cancel: cancel}
+
+				pulled := t.TempDir()
+				assertPartial(t, makeSyncBucket(t, b).Pull(ctx, SyncOptions{Local: pulled, Remote: "remote"}), b)
+				for _, key := range b.completed {
+					_, err := os.Stat(filepath.Join(pulled, strings.TrimPrefix(key, "remote/")))
+					assert.NoError(t, err, key)
+				}
+			})
+			t.Run("CompletedSyncReturnsNoError", func(t *testing.T) {
+				remote, local := setup(t)
+				ctx, cancel := context.WithCancel(context.Background())
+				defer cancel()
+				b := &cancelingBucket{Bucket: remote, after: numFiles + 1, cancel: cancel}
+
+				assert.NoError(t, makeSyncBucket(t, b).Push(ctx, SyncOptions{Local: local, Remote: "remote"}))
+			})
+		})
+	}
+}
@@ -0,0 +1,24 @@
+package pail
+
+import (
+	"context"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Ping performs a minimal, inexpensive request against the bucket's backing
+// store and returns its round-trip latency, for use in health checks. Buckets
+// that implement PingBucket make a request lighter weight than Check; for
+// other buckets, Ping returns the latency of Check.
+func Ping(ctx context.Context, b Bucket) (time.Duration, error) {
+	if pb, ok := b.(PingBucket); ok {
+		return pb.Ping(ctx)
+	}
+
+	start := time.Now()
+	if err := b.Check(ctx); err != nil {
+		return 0, errors.WithStack(err)
+	}
+	return time.Since(start), nil
+}
@@ -0,0 +1,70 @@
+package pail
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+)
+
+// PutMany uploads each of the in-memory payloads to the bucket under its key,
+// using the given number of concurrent workers. Every key is validated before
+// anything is uploaded, but a failed upload does not stop the others, and the
+// errors of all failed uploads are returned together.
+//
+// The payloads are already in memory, so S3 buckets that upload objects in
+// multiple parts upload each payload in a single request instead.
+func PutMany(ctx context.Context, b Bucket, objects map[string][]byte, workers int) error {
+	if workers < 1 {
+		workers = 1
+	}
+	if sb, ok := b.(singleRequestBucket); ok {
+		b = sb.singleRequestBucket()
+	}
+
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	if err := validateKeys(b, keys); err != nil {
+		return errors.Wrap(err, "validating keys to put")
+	}
+
+	in := make(chan string, len(keys))
+	for _, key := range keys {
+		in <- key
+	}
+	close(in)
+
+	catcher := grip.NewBasicCatcher()
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range in {
+				if err := ctx.Err(); err != nil {
+					catcher.Wrapf(err, "putting key '%s'", key)
+					continue
+				}
+
+				catcher.Wrapf(b.Put(ctx, key, bytes.NewReader(objects[key])), "putting key '%s'", key)
+			}
+		}()
+	}
+	wg.Wait()
+
+	return catcher.Resolve()
+}
+
+// singleRequestBucket is implemented by buckets that upload objects in
+// multiple parts, which are wasted on payloads that are already in memory.
+type singleRequestBucket interface {
+	// singleRequestBucket returns a bucket with the same contents that
+	// uploads each object in a single request.
+	singleRequestBucket() Bucket
+}
@@ -0,0 +1,66 @@
+package pail
+
+import (
+	"container/heap"
+	"context"
+	"sort"
+
+	"github.com/pkg/errors"
+)
+
+// GetRecent returns the n most recently modified items with the given prefix,
+// ordered from newest to oldest. Only the items' metadata is fetched, and at
+// most n items are held in memory while listing, so it is suitable for very
+// large prefixes.
+func GetRecent(ctx context.Context, b Bucket, prefix string, n int) ([]BucketItem, error) {
+	if n < 0 {
+		return nil, errors.New("number of items cannot be negative")
+	}
+	if n == 0 {
+		return []BucketItem{}, nil
+	}
+
+	iter, err := b.List(ctx, prefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing bucket")
+	}
+
+	// Keep the n newest items seen so far in a min-heap ordered by
+	// modification time, so that the oldest of them can be replaced.
+	recent := &bucketItemHeap{}
+	for iter.Next(ctx) {
+		item := iter.Item()
+		if recent.Len() < n {
+			heap.Push(recent, item)
+		} else if itemLastModified(item).After(itemLastModified((*recent)[0])) {
+			(*recent)[0] = item
+			heap.Fix(recent, 0)
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return nil, errors.Wrap(err, "iterating bucket")
+	}
+
+	items := []BucketItem(*recent)
+	sort.SliceStable(items, func(i, j int) bool {
+		return itemLastModified(items[i]).After(itemLastModified(items[j]))
+	})
+
+	return items, nil
+}
+
+// bucketItemHeap is a min-heap of bucket items ordered by modification time.
+type bucketItemHeap []BucketItem
+
+func (h bucketItemHeap) Len() int { return len(h) }
+func (h bucketItemHeap) Less(i, j int) bool {
+	return itemLastModified(h[i]).Before(itemLastModified(h[j]))
+}
+func (h bucketItemHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *bucketItemHeap) Push(x interface{}) { *h = append(*h, x.(BucketItem)) }
+func (h *bucketItemHeap) Pop() interface{} {
+	old := *h
+	item := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return item
+}
@@ -0,0 +1,282 @@
+package pail
+
+import (
+	"context"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// RecordedCall describes a single call to a recording bucket.
+type RecordedCall struct {
+	// Method is the name of the Bucket method that was called.
+	Method string
+	// Key is the key, prefix or expression that the method was called
+	// with. For Push and Pull, it is the remote prefix; for Copy, it is
+	// the source key.
+	Key string
+	// Bytes is the number of bytes transferred by the call, if any.
+	Bytes int64
+}
+
+// RecordingBucket holds the ordered sequence of calls made to a recording
+// bucket. It is safe for concurrent use.
+type RecordingBucket struct {
+	mu    sync.Mutex
+	calls []RecordedCall
+}
+
+// NewRecordingBucket returns a bucket that delegates every operation to the
+// given bucket and records each call, along with the recorder that holds the
+// recorded calls. This is useful for asserting the exact sequence of
+// operations that a workflow performs against a real bucket.
+//
+// Calls are recorded when they complete. Calls to Writer, Reader and Get are
+// recorded, along with the number of bytes transferred, when the returned
+// writer or reader is closed. Join is not recorded.
+//
+// The returned bucket implements the optional interfaces whose package
+// functions fall back to Bucket methods, such as ListOptionsBucket and
+// UploadResultBucket, and records their calls by the name of the method. It
+// does not implement the other optional interfaces, such as SeekableBucket,
+// RangeBucket, and the S3-specific interfaces, even if the given bucket
+// does, since it cannot provide them for buckets that do not; use the given
+// bucket directly for those operations, which are not recorded.
+func NewRecordingBucket(b Bucket) (*RecordingBucket, Bucket) {
+	recorder := &RecordingBucket{}
+	return recorder, &recordingBucketImpl{Bucket: b, recorder: recorder}
+}
+
+// Calls returns a copy of the calls recorded so far, in the order in which
+// they completed.
+func (r *RecordingBucket) Calls() []RecordedCall {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return append([]RecordedCall{}, r.calls...)
+}
+
+// Reset clears the recorded calls.
+func (r *RecordingBucket) Reset() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = nil
+}
+
+func (r *RecordingBucket) record(method, key string, bytes int64) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.calls = append(r.calls, RecordedCall{Method: method, Key: key, Bytes: bytes})
+}
+
+type recordingBucketImpl struct {
+	Bucket
+	recorder *RecordingBucket
+}
+
+func (b *recordingBucketImpl) Check(ctx context.Context) error {
+	defer b.recorder.record("Check", "", 0)
+	return b.Bucket.Check(ctx)
+}
+
+func (b *recordingBucketImpl) Ping(ctx context.Context) (time.Duration, error) {
+	defer b.recorder.record("Ping", "", 0)
+	return Ping(ctx, b.Bucket)
+}
+
+func (b *recordingBucketImpl) Exists(ctx context.Context, key string) (bool, error) {
+	defer b.recorder.record("Exists", key, 0)
+	return b.Bucket.Exists(ctx, key)
+}
+
+func (b *recordingBucketImpl) ExistsMany(ctx context.Context, keys []string, workers int) (map[string]bool, error) {
+	defer func() {
+		for _, key := range keys {
+			b.recorder.record("ExistsMany", key, 0)
+		}
+	}()
+	return ExistsMany(ctx, b.Bucket, keys, workers)
+}
+
+// objectHash is not recorded, since it is not a Bucket method.
+func (b *recordingBucketImpl) objectHash(ctx context.Context, key string) (string, error) {
+	return objectHash(ctx, b.Bucket, key)
+}
+
+func (b *recordingBucketImpl) Writer(ctx context.Context, key string) (io.WriteCloser, error) {
+	w, err := b.Bucket.Writer(ctx, key)
+	if err != nil {
+		b.recorder.record("Writer", key, 0)
+		return nil, err
+	}
+	return &recordingWriteCloser{WriteCloser: w, method: "Writer", key: key, recorder: b.recorder}, nil
+}
+
+func (b *recordingBucketImpl) Reader(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, err := b.Bucket.Reader(ctx, key)
+	return b.recordReader("Reader", key, r, err)
+}
+
+func (b *recordingBucketImpl) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, err := b.Bucket.Get(ctx, key)
+	return b.recordReader("Get", key, r, err)
+}
+
+func (b *recordingBucketImpl) recordReader(method, key string, r io.ReadCloser, err error) (io.ReadCloser, error) {
+	if err != nil {
+		b.recorder.record(method, key, 0)
+		return nil, err
+	}
+	return &recordingReadCloser{ReadCloser: r, method: method, key: key, recorder: b.recorder}, nil
+}
+
+func (b *recordingBucketImpl) Put(ctx context.Context, key string, r io.Reader) error {
+	counter := &countingReader{Reader: r}
+	defer func() { b.recorder.record("Put", key, counter.n) }()
+	return b.Bucket.Put(ctx, key, counter)
+}
+
+func (b *recordingBucketImpl) Upload(ctx context.Context, key, path string) error {
+	err := b.Bucket.Upload(ctx, key, path)
+	b.recorder.record("Upload", key, fileSize(path))
+	return err
+}
+
+func (b *recordingBucketImpl) Download(ctx context.Context, key, path string) error {
+	err := b.Bucket.Download(ctx, key, path)
+	b.recorder.record("Download", key, fileSize(path))
+	return err
+}
+
+func (b *recordingBucketImpl) Push(ctx context.Context, opts SyncOptions) error {
+	defer b.recorder.record("Push", opts.Remote, 0)
+	return b.Bucket.Push(ctx, opts)
+}
+
+func (b *recordingBucketImpl) Pull(ctx context.Context, opts SyncOptions) error {
+	defer b.recorder.record("Pull", opts.Remote, 0)
+	return b.Bucket.Pull(ctx, opts)
+}
+
+func (b *recordingBucketImpl) Copy(ctx context.Context, opts CopyOptions) error {
+	defer b.recorder.record("Copy", opts.SourceKey, 0)
+
+	// The destination must have the same type as the source for the
+	// underlying bucket, so unwrap recording destinations.
+	if dst, ok := opts.DestinationBucket.(*recordingBucketImpl); ok {
+		opts.DestinationBucket = dst.Bucket
+	}
+	return b.Bucket.Copy(ctx, opts)
+}
+
+func (b *recordingBucketImpl) Remove(ctx context.Context, key string) error {
+	defer b.recorder.record("Remove", key, 0)
+	return b.Bucket.Remove(ctx, key)
+}
+
+func (b *recordingBucketImpl) RemoveMany(ctx context.Context, keys ...string) error {
+	defer func() {
+		for _, key := range keys {
+			b.recorder.record("RemoveMany", key, 0)
+		}
+	}()
+	return b.Bucket.RemoveMany(ctx, keys...)
+}
+
+func (b *recordingBucketImpl) RemovePrefix(ctx context.Context, prefix string) error {
+	defer b.recorder.record("RemovePrefix", prefix, 0)
+	return b.Bucket.RemovePrefix(ctx, prefix)
+}
+
+func (b *recordingBucketImpl) RemoveMatching(ctx context.Context, expression string) error {
+	defer b.recorder.record("RemoveMatching", expression, 0)
+	return b.Bucket.RemoveMatching(ctx, expression)
+}
+
+func (b *recordingBucketImpl) List(ctx context.Context, prefix string) (BucketIterator, error) {
+	defer b.recorder.record("List", prefix, 0)
+	return b.Bucket.List(ctx, prefix)
+}
+
+func (b *recordingBucketImpl) ListWithOptions(ctx context.Context, prefix string, opts ListOptions) (BucketIterator, error) {
+	defer b.recorder.record("ListWithOptions", prefix, 0)
+	return ListWithOptions(ctx, b.Bucket, prefix, opts)
+}
+
+func (b *recordingBucketImpl) ListDir(ctx context.Context, dir string) (BucketIterator, error) {
+	defer b.recorder.record("ListDir", dir, 0)
+	return ListDir(ctx, b.Bucket, dir)
+}
+
+func (b *recordingBucketImpl) ListParallel(ctx context.Context, prefix string, workers int) (BucketIterator, error) {
+	defer b.recorder.record("ListParallel", prefix, 0)
+	return ListParallel(ctx, b.Bucket, prefix, workers)
+}
+
+func (b *recordingBucketImpl) UploadWithResult(ctx context.Context, key, path string) (UploadResult, error) {
+	result, err := UploadWithResult(ctx, b.Bucket, key, path)
+	b.recorder.record("UploadWithResult", key, result.BytesTransferred)
+	return result, err
+}
+
+// fileSize returns the size of the file at the given path, or 0 if it cannot
+// be determined.
+func fileSize(path string) int64 {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return info.Size()
+}
+
+type countingReader struct {
+	io.Reader
+	n int64
+}
+
+func (r *countingReader) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+type recordingReadCloser struct {
+	io.ReadCloser
+	method   string
+	key      string
+	n        int64
+	recorder *RecordingBucket
+}
+
+func (r *recordingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.ReadCloser.Read(p)
+	r.n += int64(n)
+	return n, err
+}
+
+func (r *recordingReadCloser) Close() error {
+	defer r.recorder.record(r.method, r.key, r.n)
+	return r.ReadCloser.Close()
+}
+
+type recordingWriteCloser struct {
+	io.WriteCloser
+	method   string
+	key      string
+	n        int64
+	recorder *RecordingBucket
+}
+
+func (w *recordingWriteCloser) Write(p []byte) (int, error) {
+	n, err := w.WriteCloser.Write(p)
+	w.n += int64(n)
+	return n, err
+}
+
+func (w *recordingWriteCloser) Close() error {
+	defer w.recorder.record(w.method, w.key, w.n)
+	return w.WriteCloser.Close()
+}
@@ -0,0 +1,135 @@
+package pail
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRecordingBucket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	setup := func(t *testing.T) (*RecordingBucket, Bucket) {
+		local, err := NewLocalBucket(LocalOptions{Path: t.TempDir()})
+		require.NoError(t, err)
+		return NewRecordingBucket(local)
+	}
+
+	t.Run("RecordsPushSequence", func(t *testing.T) {
+		recorder, b := setup(t)
+		pb, err := NewParallelSyncBucket(ParallelBucketOptions{Workers: 1}, b)
+		require.NoError(t, err)
+
+		local := t.TempDir()
+		for name, content := range map[string]string{"a.txt": "a", "b.txt": "bb", "c.txt": "ccc"} {
+			require.NoError(t, os.WriteFile(filepath.Join(local, name), []byte(content), 0600))
+		}
+		require.NoError(t, pb.Push(ctx, SyncOptions{Local: local, Remote: "remote"}))
+
+		assert.Equal(t, []RecordedCall{
+			{Method: "Upload", Key: filepath.Join("remote", "a.txt"), Bytes: 1},
+			{Method: "Upload", Key: filepath.Join("remote", "b.txt"), Bytes: 2},
+			{Method: "Upload", Key: filepath.Join("remote", "c.txt"), Bytes: 3},
+		}, recorder.Calls())
+	})
+	t.Run("RecordsReadsAndWrites", func(t *testing.T) {
+		recorder, b := setup(t)
+
+		require.NoError(t, b.Put(ctx, "key", strings.NewReader("hello")))
+		exists, err := b.Exists(ctx, "key")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		r, err := b.Get(ctx, "key")
+		require.NoError(t, err)
+		_, err = io.ReadAll(r)
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		require.NoError(t, b.Remove(ctx, "key"))
+
+		assert.Equal(t, []RecordedCall{
+			{Method: "Put", Key: "key", Bytes: 5},
+			{Method: "Exists", Key: "key"},
+			{Method: "Get", Key: "key", Bytes: 5},
+			{Method: "Remove", Key: "key"},
+		}, recorder.Calls())
+
+		recorder.Reset()
+		assert.Empty(t, recorder.Calls())
+	})
+	t.Run("RecordsFailedCalls", func(t *testing.T) {
+		recorder, b := setup(t)
+
+		_, err := b.Get(ctx, "DNE")
+		assert.Error(t, err)
+		assert.Equal(t, []RecordedCall{{Method: "Get", Key: "DNE"}}, recorder.Calls())
+	})
+	t.Run("PingFallsBackToCheck", func(t *testing.T) {
+		recorder, b := setup(t)
+
+		_, err := Ping(ctx, b)
+		require.NoError(t, err)
+		// Hide the Ping method of the recording bucket.
+		_, err = Ping(ctx, struct{ Bucket }{b})
+		require.NoError(t, err)
+
+		assert.Equal(t, []RecordedCall{{Method: "Ping"}, {Method: "Check"}}, recorder.Calls())
+	})
+	t.Run("CopyBetweenRecordingBuckets", func(t *testing.T) {
+		recorder, b := setup(t)
+		require.NoError(t, b.Put(ctx, "src", strings.NewReader("data")))
+		recorder.Reset()
+
+		require.NoError(t, b.Copy(ctx, CopyOptions{SourceKey: "src", DestinationKey: "dst", DestinationBucket: b}))
+		assert.Equal(t, []RecordedCall{{Method: "Copy", Key: "src"}}, recorder.Calls())
+		data, err := readDataFromFile(ctx, b, "dst")
+		require.NoError(t, err)
+		assert.Equal(t, "data", data)
+	})
+	t.Run("RecordsOptionalInterfaces", func(t *testing.T) {
+		recorder, b := setup(t)
+		for _, key := range []string{"dir/a", "dir/b", "dir/sub/c"} {
+			require.NoError(t, b.Put(ctx, key, strings.NewReader("data")))
+		}
+		path := filepath.Join(t.TempDir(), "file")
+		require.NoError(t, os.WriteFile(path, []byte("hello"), 0600))
+		recorder.Reset()
+
+		iter, err := ListWithOptions(ctx, b, "dir/", ListOptions{StartAfter: "dir/a"})
+		require.NoError(t, err)
+		var names []string
+		for iter.Next(ctx) {
+			names = append(names, iter.Item().Name())
+		}
+		require.NoError(t, iter.Err())
+		assert.Equal(t, []string{"dir/b", "dir/sub/c"}, names)
+
+		iter, err = ListDir(ctx, b, "dir")
+		require.NoError(t, err)
+		names = nil
+		for iter.Next(ctx) {
+			names = append(names, iter.Item().Name())
+		}
+		require.NoError(t, iter.Err())
+		assert.Equal(t, []string{"dir/a", "dir/b", "dir/sub/"}, names)
+
+		_, err = ListParallel(ctx, b, "dir/", 2)
+		require.NoError(t, err)
+		result, err := UploadWithResult(ctx, b, "uploaded", path)
+		require.NoError(t, err)
+		assert.True(t, result.Uploaded)
+
+		assert.Equal(t, []RecordedCall{
+			{Method: "ListWithOptions", Key: "dir/"},
+			{Method: "ListDir", Key: "dir"},
+			{Method: "ListParallel", Key: "dir/"},
+			{Method: "UploadWithResult", Key: "uploaded", Bytes: 5},
+		}, recorder.Calls())
+	})
+}
@@ -0,0 +1,128 @@
+package pail
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// NewRegionalReadBucket returns a bucket that writes to the primary bucket and
+// reads from the replica of the primary in the local region, so that reads
+// are served from the closest copy of the data. If there is no replica for the
+// local region, the primary is used for reads too.
+//
+// Replicas are assumed to be kept up to date with the primary asynchronously,
+// so an object that was written recently may not have been replicated yet.
+// Exists, Reader, Get, and Download therefore fall back to the primary if the
+// object is not found in the replica. List and Pull only read from the
+// replica, so their results may lag behind the primary.
+//
+// Check and Ping apply to both the primary and the replica. Every other
+// operation, including Copy, Push, and every kind of removal, applies only to
+// the primary.
+func NewRegionalReadBucket(primary Bucket, replicas map[string]Bucket, localRegion string) Bucket {
+	b := &regionalReadBucket{Bucket: primary}
+	if replica, ok := replicas[localRegion]; ok && replica != nil {
+		b.replica = replica
+	}
+
+	return b
+}
+
+type regionalReadBucket struct {
+	// Bucket is the primary bucket.
+	Bucket
+	// replica, if set, is the bucket that reads are served from.
+	replica Bucket
+}
+
+// reader returns the bucket that reads are served from.
+func (b *regionalReadBucket) reader() Bucket {
+	if b.replica == nil {
+		return b.Bucket
+	}
+	return b.replica
+}
+
+func (b *regionalReadBucket) Check(ctx context.Context) error {
+	if err := b.Bucket.Check(ctx); err != nil {
+		return errors.Wrap(err, "checking primary")
+	}
+	if b.replica != nil {
+		return errors.Wrap(b.replica.Check(ctx), "checking replica")
+	}
+	return nil
+}
+
+// Ping pings the primary and the replica, returning the latency of the
+// slower.
+func (b *regionalReadBucket) Ping(ctx context.Context) (time.Duration, error) {
+	latency, err := Ping(ctx, b.Bucket)
+	if err != nil {
+		return 0, errors.Wrap(err, "pinging primary")
+	}
+	if b.replica == nil {
+		return latency, nil
+	}
+
+	replicaLatency, err := Ping(ctx, b.replica)
+	if err != nil {
+		return 0, errors.Wrap(err, "pinging replica")
+	}
+	if replicaLatency > latency {
+		return replicaLatency, nil
+	}
+	return latency, nil
+}
+
+func (b *regionalReadBucket) Exists(ctx context.Context, key string) (bool, error) {
+	exists, err := b.reader().Exists(ctx, key)
+	if err != nil || exists || b.replica == nil {
+		return exists, err
+	}
+
+	return b.Bucket.Exists(ctx, key)
+}
+func (b *regionalReadBucket) Reader(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, err := b.reader().Reader(ctx, key)
+	if b.replica != nil && IsKeyNotFoundError(err) {
+		return b.Bucket.Reader(ctx, key)
+	}
+	return r, err
+}
+
+func (b *regionalReadBucket) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	r, err := b.reader().Get(ctx, key)
+	if b.replica != nil && IsKeyNotFoundError(err) {
+		return b.Bucket.Get(ctx, key)
+	}
+	return r, err
+}
+
+func (b *regionalReadBucket) Download(ctx context.Context, key, path string) error {
+	err := b.reader().Download(ctx, key, path)
+	if b.replica != nil && IsKeyNotFoundError(err) {
+		return b.Bucket.Download(ctx, key, path)
+	}
+	return err
+}
+
+func (b *regionalReadBucket) Pull(ctx context.Context, opts SyncOptions) error {
+	return b.reader().Pull(ctx, opts)
+}
+
+func (b *regionalReadBucket) List(ctx context.Context, prefix string) (BucketIterator, error) {
+	return b.reader().List(ctx, prefix)
+}
+
+// Copy copies the object within the primary. If the destination bucket is
+// also a regional read bucket, the object is copied to its primary.
+func (b *regionalReadBucket) Copy(ctx context.Context, opts CopyOptions) error {
+	if dest, ok := opts.DestinationBucket.(*regionalReadBucket); ok {
+		opts.DestinationBucket = dest.Bucket
+	}
+
+	return b.Bucket.Copy(ctx, opts)
+}
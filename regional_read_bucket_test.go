@@ -0,0 +1,94 @@
+package pail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRegionalReadBucket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	newRecording := func(t *testing.T) (*RecordingBucket, Bucket) {
+		local, err := NewLocalBucket(LocalOptions{Path: t.TempDir()})
+		require.NoError(t, err)
+		return NewRecordingBucket(local)
+	}
+	methods := func(recorder *RecordingBucket) []string {
+		var out []string
+		for _, call := range recorder.Calls() {
+			out = append(out, call.Method+" "+call.Key)
+		}
+		return out
+	}
+	type setup struct {
+		primaryRecorder, localRecorder, remoteRecorder *RecordingBucket
+		primary, local, remote                         Bucket
+	}
+	newSetup := func(t *testing.T) setup {
+		var s setup
+		s.primaryRecorder, s.primary = newRecording(t)
+		s.localRecorder, s.local = newRecording(t)
+		s.remoteRecorder, s.remote = newRecording(t)
+		return s
+	}
+
+	t.Run("ReadsFromLocalReplicaAndWritesToPrimary", func(t *testing.T) {
+		s := newSetup(t)
+		b := NewRegionalReadBucket(s.primary, map[string]Bucket{"us-east-1": s.local, "eu-west-1": s.remote}, "us-east-1")
+		require.NoError(t, s.local.Put(ctx, "key", strings.NewReader("replica")))
+		s.localRecorder.Reset()
+
+		require.NoError(t, b.Put(ctx, "key", strings.NewReader("primary")))
+		data, err := readDataFromFile(ctx, b, "key")
+		require.NoError(t, err)
+		assert.Equal(t, "replica", data)
+		exists, err := b.Exists(ctx, "key")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		keys, err := ListKeys(ctx, b, "")
+		require.NoError(t, err)
+		assert.Equal(t, []string{"key"}, keys)
+		require.NoError(t, b.Remove(ctx, "key"))
+
+		assert.Equal(t, []string{"Put key", "Remove key"}, methods(s.primaryRecorder))
+		assert.Equal(t, []string{"Reader key", "Exists key", "List "}, methods(s.localRecorder))
+		assert.Empty(t, s.remoteRecorder.Calls())
+	})
+	t.Run("FallsBackToPrimaryForUnreplicatedObjects", func(t *testing.T) {
+		s := newSetup(t)
+		b := NewRegionalReadBucket(s.primary, map[string]Bucket{"us-east-1": s.local}, "us-east-1")
+		require.NoError(t, b.Put(ctx, "key", strings.NewReader("primary")))
+
+		data, err := readDataFromFile(ctx, b, "key")
+		require.NoError(t, err)
+		assert.Equal(t, "primary", data)
+		exists, err := b.Exists(ctx, "key")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		path := filepath.Join(t.TempDir(), "downloaded")
+		require.NoError(t, b.Download(ctx, "key", path))
+		downloaded, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "primary", string(downloaded))
+
+		assert.Equal(t, []string{"Reader key", "Exists key", "Download key"}, methods(s.localRecorder))
+	})
+	t.Run("UsesPrimaryWithoutLocalReplica", func(t *testing.T) {
+		s := newSetup(t)
+		b := NewRegionalReadBucket(s.primary, map[string]Bucket{"eu-west-1": s.remote}, "us-east-1")
+		require.NoError(t, b.Put(ctx, "key", strings.NewReader("primary")))
+
+		data, err := readDataFromFile(ctx, b, "key")
+		require.NoError(t, err)
+		assert.Equal(t, "primary", data)
+		assert.Equal(t, []string{"Put key", "Reader key"}, methods(s.primaryRecorder))
+		assert.Empty(t, s.remoteRecorder.Calls())
+	})
+}
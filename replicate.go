@@ -0,0 +1,81 @@
+package pail
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+)
+
+// ReplicateChanged copies the objects in the source bucket with the given
+// source prefix that were modified at or after since to the destination
+// bucket, at the same paths relative to the destination prefix, so that a
+// replica that was up to date at since can be brought up to date without
+// copying every object. Objects whose modification time is unknown are
+// always copied. The objects are copied by the given number of workers
+// concurrently. If both buckets use the same backend, the source bucket's
+// Copy is used, so the objects' contents are not transferred where the
+// service supports copying them itself; otherwise the objects are streamed
+// from the source to the destination. Objects removed from the source are not removed from the destination.
+// Errors copying individual objects do not stop the other copies, and are
+// returned together once every object has been attempted.
+func ReplicateChanged(ctx context.Context, src, dest Bucket, srcPrefix, dstPrefix string, since time.Time, workers int) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	iter, err := src.List(ctx, srcPrefix)
+	if err != nil {
+		return errors.Wrap(err, "listing source")
+	}
+
+	keys := make(chan string)
+	wg := &sync.WaitGroup{}
+	catcher := grip.NewBasicCatcher()
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range keys {
+				opts := CopyOptions{
+					SourceKey:         key,
+					DestinationKey:    dest.Join(dstPrefix, relativeKey(key, srcPrefix)),
+					DestinationBucket: dest,
+				}
+				var err error
+				if sameBackend(src, dest) {
+					err = src.Copy(ctx, opts)
+				} else {
+					err = streamCopy(ctx, src, opts)
+				}
+				catcher.Wrapf(err, "replicating key '%s'", key)
+			}
+		}()
+	}
+
+	for iter.Next(ctx) {
+		item := iter.Item()
+		if modified := itemLastModified(item); !modified.IsZero() && modified.Before(since) {
+			continue
+		}
+		keys <- item.Name()
+	}
+	close(keys)
+	wg.Wait()
+	catcher.Wrap(iter.Err(), "iterating source")
+
+	return catcher.Resolve()
+}
+
+// sameBackend returns whether the buckets are of the same type, so that one
+// may copy objects to the other within the service that stores them.
+func sameBackend(a, b Bucket) bool {
+	if _, ok := asS3Bucket(a); ok {
+		_, ok = asS3Bucket(b)
+		return ok
+	}
+	return reflect.TypeOf(a) == reflect.TypeOf(b)
+}
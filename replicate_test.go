@@ -0,0 +1,80 @@
+package pail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplicateChanged(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srcDir := t.TempDir()
+	src, err := NewLocalBucket(LocalOptions{Path: srcDir})
+	require.NoError(t, err)
+	cutoff := time.Now().Add(-time.Hour)
+	for key, modified := range map[string]time.Time{
+		"logs/old":        cutoff.Add(-time.Minute),
+		"logs/nested/old": cutoff.Add(-24 * time.Hour),
+		"logs/new":        cutoff.Add(time.Minute),
+		"logs/nested/new": cutoff.Add(30 * time.Minute),
+		"logs/at-cutoff":  cutoff,
+		"other/new":       cutoff.Add(time.Minute),
+	} {
+		require.NoError(t, src.Put(ctx, key, strings.NewReader(key)))
+		require.NoError(t, os.Chtimes(filepath.Join(srcDir, key), modified, modified))
+	}
+
+	localDest, err := NewLocalBucket(LocalOptions{Path: t.TempDir()})
+	require.NoError(t, err)
+	memoryDest, err := NewMemoryBucket(MemoryBucketOptions{})
+	require.NoError(t, err)
+	for name, dest := range map[string]Bucket{
+		"Local":  localDest,
+		"Memory": memoryDest,
+		"MockS3": &s3BucketSmall{s3Bucket: *newMockS3Bucket(newMockS3Client(), "replica")},
+	} {
+		t.Run(name, func(t *testing.T) {
+			require.NoError(t, ReplicateChanged(ctx, src, dest, "logs", "backup", cutoff, 2))
+
+			keys, err := ListKeys(ctx, dest, "")
+			require.NoError(t, err)
+			assert.Equal(t, []string{"backup/at-cutoff", "backup/nested/new", "backup/new"}, keys)
+			for _, key := range []string{"at-cutoff", "nested/new", "new"} {
+				data, err := readDataFromFile(ctx, dest, dest.Join("backup", key))
+				require.NoError(t, err)
+				assert.Equal(t, "logs/"+key, data)
+			}
+		})
+	}
+
+	t.Run("FromS3ToOtherBackends", func(t *testing.T) {
+		s3Src := &s3BucketSmall{s3Bucket: *newMockS3Bucket(newMockS3Client(), "source")}
+		for _, key := range []string{"logs/a", "logs/nested/b"} {
+			require.NoError(t, s3Src.Put(ctx, key, strings.NewReader(key)))
+		}
+
+		memoryDest, err := NewMemoryBucket(MemoryBucketOptions{})
+		require.NoError(t, err)
+		localDest, err := NewLocalBucket(LocalOptions{Path: t.TempDir()})
+		require.NoError(t, err)
+		for name, dest := range map[string]Bucket{"Local": localDest, "Memory": memoryDest} {
+			t.Run(name, func(t *testing.T) {
+				require.NoError(t, ReplicateChanged(ctx, s3Src, dest, "logs", "backup", time.Time{}, 2))
+
+				for _, key := range []string{"a", "nested/b"} {
+					data, err := readDataFromFile(ctx, dest, dest.Join("backup", key))
+					require.NoError(t, err)
+					assert.Equal(t, "logs/"+key, data)
+				}
+			})
+		}
+	})
+}
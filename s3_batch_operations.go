@@ -0,0 +1,256 @@
+package pail
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/aws-sdk-go-v2/service/s3control"
+	s3ControlTypes "github.com/aws/aws-sdk-go-v2/service/s3control/types"
+	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
+	"github.com/pkg/errors"
+)
+
+// s3ControlClient is the subset of the S3 Control API used by the S3 buckets.
+// It is implemented by *s3control.Client.
+type s3ControlClient interface {
+	CreateJob(context.Context, *s3control.CreateJobInput, ...func(*s3control.Options)) (*s3control.CreateJobOutput, error)
+}
+
+// BatchOperationsBucket is implemented by buckets that can submit S3 Batch
+// Operations jobs, which run operations that are too large for client-side
+// iteration (e.g. copying millions of objects) on the server.
+type BatchOperationsBucket interface {
+	// SubmitBatchCopyJob lists the objects under the given prefix,
+	// uploads a manifest of those objects to the bucket, and creates an
+	// S3 Batch Operations job that copies each object in the manifest to
+	// the destination bucket. It returns the ID of the created job.
+	SubmitBatchCopyJob(context.Context, BatchJobOptions) (string, error)
+}
+
+// BatchJobOptions describes the arguments to SubmitBatchCopyJob.
+type BatchJobOptions struct {
+	// AccountID is the ID of the AWS account that owns the job.
+	AccountID string
+	// RoleARN is the ARN of the IAM role that S3 Batch Operations assumes
+	// to run the job.
+	RoleARN string
+	// Prefix selects the objects to copy. It is relative to the bucket's
+	// prefix. (Optional)
+	Prefix string
+	// ManifestKey is the key, relative to the bucket's prefix, to which
+	// the generated CSV manifest is uploaded.
+	ManifestKey string
+	// DestinationBucketARN is the ARN of the bucket into which the objects
+	// are copied.
+	DestinationBucketARN string
+	// DestinationPrefix is prepended to the key of each copied object.
+	// (Optional)
+	DestinationPrefix string
+	// ReportBucketARN is the ARN of the bucket to which the job completion
+	// report is written. When empty, no report is generated. (Optional)
+	ReportBucketARN string
+	// ReportPrefix is the prefix under which the job completion report is
+	// written. (Optional)
+	ReportPrefix string
+	// Priority is the relative priority of the job. (Optional)
+	Priority int32
+	// ConfirmationRequired, when set, requires the job to be confirmed
+	// before it runs. (Optional)
+	ConfirmationRequired bool
+	// Description is a human-readable description of the job. (Optional)
+	Description string
+}
+
+func (o *BatchJobOptions) validate() error {
+	catcher := grip.NewBasicCatcher()
+	catcher.NewWhen(o.AccountID == "", "must specify an account ID")
+	catcher.NewWhen(o.RoleARN == "", "must specify a role ARN")
+	catcher.NewWhen(o.ManifestKey == "", "must specify a manifest key")
+	catcher.NewWhen(o.DestinationBucketARN == "", "must specify a destination bucket ARN")
+	catcher.NewWhen(o.Priority < 0, "priority cannot be negative")
+	return catcher.Resolve()
+}
+
+func (s *s3Bucket) SubmitBatchCopyJob(ctx context.Context, opts BatchJobOptions) (string, error) {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"dry_run":       s.dryRun,
+		"operation":     "submit batch copy job",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+		"prefix":        opts.Prefix,
+		"manifest_key":  opts.ManifestKey,
+		"destination":   opts.DestinationBucketARN,
+	})
+
+	if err := opts.validate(); err != nil {
+		return "", errors.Wrap(err, "invalid batch job options")
+	}
+
+	if s.dryRun {
+		return "", errors.Wrap(s.writeBatchManifest(ctx, io.Discard, opts.Prefix), "generating manifest")
+	}
+
+	// The manifest is written to a temporary file as the objects are
+	// listed, so that the manifest of a large prefix is not held in
+	// memory, and is then uploaded from the file.
+	manifest, err := os.CreateTemp("", "pail-batch-manifest")
+	if err != nil {
+		return "", errors.Wrap(err, "creating temporary file for manifest")
+	}
+	defer func() {
+		_ = manifest.Close()
+		_ = os.Remove(manifest.Name())
+	}()
+	if err = s.writeBatchManifest(ctx, manifest, opts.Prefix); err != nil {
+		return "", errors.Wrap(err, "generating manifest")
+	}
+	if _, err = manifest.Seek(0, io.SeekStart); err != nil {
+		return "", errors.Wrap(err, "rewinding manifest")
+	}
+
+	manifestKey := s.normalizeKey(opts.ManifestKey)
+	manifestInput := &s3.PutObjectInput{
+		Bucket:      aws.String(s.name),
+		Key:         aws.String(manifestKey),
+		Body:        manifest,
+		ContentType: aws.String("text/csv"),
+	}
+	if s.tagging != "" {
+		manifestInput.Tagging = aws.String(s.tagging)
+	}
+	s.encryption.applyToPut(manifestInput)
+	putResult, err := s.svc.PutObject(ctx, manifestInput)
+	if err != nil {
+		return "", errors.Wrap(err, "uploading manifest")
+	}
+
+	token, err := newClientRequestToken()
+	if err != nil {
+		return "", errors.Wrap(err, "generating client request token")
+	}
+
+	input := &s3control.CreateJobInput{
+		AccountId:            aws.String(opts.AccountID),
+		ClientRequestToken:   aws.String(token),
+		ConfirmationRequired: aws.Bool(opts.ConfirmationRequired),
+		Priority:             aws.Int32(opts.Priority),
+		RoleArn:              aws.String(opts.RoleARN),
+		Operation: &s3ControlTypes.JobOperation{
+			S3PutObjectCopy: &s3ControlTypes.S3CopyObjectOperation{
+				TargetResource: aws.String(opts.DestinationBucketARN),
+			},
+		},
+		Manifest: &s3ControlTypes.JobManifest{
+			Spec: &s3ControlTypes.JobManifestSpec{
+				Format: s3ControlTypes.JobManifestFormatS3BatchOperationsCsv20180820,
+				Fields: []s3ControlTypes.JobManifestFieldName{
+					s3ControlTypes.JobManifestFieldNameBucket,
+					s3ControlTypes.JobManifestFieldNameKey,
+				},
+			},
+			Location: &s3ControlTypes.JobManifestLocation{
+				ObjectArn: aws.String(fmt.Sprintf("arn:%s:s3:::%s/%s", arnPartition(s.region), s.name, manifestKey)),
+				ETag:      putResult.ETag,
+			},
+		},
+		Report: &s3ControlTypes.JobReport{Enabled: false},
+	}
+	if opts.DestinationPrefix != "" {
+		input.Operation.S3PutObjectCopy.TargetKeyPrefix = aws.String(opts.DestinationPrefix)
+	}
+	if opts.Description != "" {
+		input.Description = aws.String(opts.Description)
+	}
+	if opts.ReportBucketARN != "" {
+		input.Report = &s3ControlTypes.JobReport{
+			Enabled:     true,
+			Bucket:      aws.String(opts.ReportBucketARN),
+			Format:      s3ControlTypes.JobReportFormatReportCsv20180820,
+			ReportScope: s3ControlTypes.JobReportScopeAllTasks,
+		}
+		if opts.ReportPrefix != "" {
+			input.Report.Prefix = aws.String(opts.ReportPrefix)
+		}
+	}
+
+	result, err := s.controlSvc.CreateJob(ctx, input)
+	if err != nil {
+		return "", errors.Wrap(err, "creating batch operations job")
+	}
+
+	return aws.ToString(result.JobId), nil
+}
+
+// writeBatchManifest writes an S3 Batch Operations CSV manifest containing
+// every object under the given prefix to the writer as the objects are
+// listed. Each line of the manifest has the form "bucket,key", where the key
+// is URL-encoded.
+func (s *s3Bucket) writeBatchManifest(ctx context.Context, w io.Writer, prefix string) error {
+	iter, err := s.listHelper(ctx, nil, s.normalizeListPrefix(prefix))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	bw := bufio.NewWriter(w)
+	count := 0
+	for iter.Next(ctx) {
+		if _, err = fmt.Fprintf(bw, "%s,%s\n", s.name, encodeManifestKey(s.normalizeKey(iter.Item().Name()))); err != nil {
+			return errors.Wrap(err, "writing manifest")
+		}
+		count++
+	}
+	if err = iter.Err(); err != nil {
+		return errors.Wrap(err, "iterating bucket")
+	}
+	if count == 0 {
+		return errors.Errorf("no objects found with prefix '%s'", prefix)
+	}
+
+	return errors.Wrap(bw.Flush(), "writing manifest")
+}
+
+// arnPartition returns the partition of the ARNs of resources in the given
+// region, such as "aws-us-gov" for the AWS GovCloud (US) regions.
+func arnPartition(region string) string {
+	switch {
+	case strings.HasPrefix(region, "us-gov-"):
+		return "aws-us-gov"
+	case strings.HasPrefix(region, "cn-"):
+		return "aws-cn"
+	case strings.HasPrefix(region, "us-isob-"):
+		return "aws-iso-b"
+	case strings.HasPrefix(region, "us-iso-"):
+		return "aws-iso"
+	default:
+		return "aws"
+	}
+}
+
+// encodeManifestKey URL-encodes each path segment of the key, as required by
+// the S3 Batch Operations CSV manifest format.
+func encodeManifestKey(key string) string {
+	segments := strings.Split(key, "/")
+	for i := range segments {
+		segments[i] = url.PathEscape(segments[i])
+	}
+	return strings.Join(segments, "/")
+}
+
+func newClientRequestToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -2,33 +2,38 @@ package pail
 
 import (
 	"archive/tar"
-	"compress/gzip"
+	"bytes"
 	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"fmt"
 	"io"
 	"net/http"
 	"os"
 	"path/filepath"
 	"regexp"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/credentials"
 	"github.com/aws/aws-sdk-go-v2/credentials/stscreds"
 	s3Manager "github.com/aws/aws-sdk-go-v2/feature/s3/manager"
 	"github.com/aws/aws-sdk-go-v2/service/s3"
 	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3control"
 	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/aws/smithy-go"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
 	"github.com/evergreen-ci/utility"
 	"github.com/mongodb/grip"
 	"github.com/mongodb/grip/message"
 	"github.com/pkg/errors"
 )
 
-const compressionEncoding = "gzip"
-
 // S3Permissions is a type that describes the object canned ACL from S3.
 type S3Permissions string
 
@@ -67,18 +72,90 @@ type s3BucketLarge struct {
 }
 
 type s3Bucket struct {
-	dryRun              bool
-	deleteOnPush        bool
-	deleteOnPull        bool
-	singleFileChecksums bool
-	compress            bool
-	verbose             bool
-	batchSize           int
-	svc                 *s3.Client
-	name                string
-	prefix              string
-	permissions         S3Permissions
-	contentType         string
+	dryRun                  bool
+	deleteOnPush            bool
+	deleteOnPull            bool
+	singleFileChecksums     bool
+	compress                bool
+	compressionAlgorithm    CompressionAlgorithm
+	compressionDictionary   []byte
+	compressionDictionaryID string
+	lenientGzip             bool
+	sniffCompression        bool
+	objectRetention         *ObjectRetention
+	// objectLockConfig caches the bucket's Object Lock configuration, which
+	// is checked against objectRetention.
+	objectLockConfig  *objectLockConfigCache
+	verbose           bool
+	batchSize         int
+	svc               s3Client
+	controlSvc        s3ControlClient
+	name              string
+	prefix            string
+	permissions       S3Permissions
+	contentType       string
+	detectContentType bool
+	maxKeyLength      int
+	validateKeyUTF8   bool
+	sendContentMD5    bool
+	verifyETag        bool
+	verifyCRC32C      bool
+	computeTreeHash   bool
+	copyBufferSize    int
+	archiveWorkers    int
+	// objectMetadata is custom metadata set on each object written to
+	// the bucket.
+	objectMetadata map[string]string
+	// keyTransform, if set, transforms keys as they are stored in the
+	// bucket.
+	keyTransform KeyTransform
+	// maxCopyBytes, if set, overrides the size of the largest object that
+	// is copied in a single request, for testing.
+	maxCopyBytes int64
+	// metadataSlots, if set, limits the number of concurrent requests for
+	// object metadata; each request holds a slot while it runs.
+	metadataSlots chan struct{}
+	// tagging is the URL-encoded tags set on each object written to the
+	// bucket.
+	tagging string
+	// encryption is the server-side encryption of each object written or
+	// copied to the bucket.
+	encryption serverSideEncryption
+	// presignSvc is the client that svc wraps, which presigns URLs. It is
+	// nil if the bucket's client is not an S3 client.
+	presignSvc *s3.Client
+	// region is the region of the bucket's client, which determines the
+	// partition of the ARNs of the bucket's objects.
+	region string
+}
+
+// s3Client is the subset of the S3 API used by the S3 buckets. It is
+// implemented by *s3.Client.
+type s3Client interface {
+	HeadBucket(context.Context, *s3.HeadBucketInput, ...func(*s3.Options)) (*s3.HeadBucketOutput, error)
+	GetBucketLocation(context.Context, *s3.GetBucketLocationInput, ...func(*s3.Options)) (*s3.GetBucketLocationOutput, error)
+	HeadObject(context.Context, *s3.HeadObjectInput, ...func(*s3.Options)) (*s3.HeadObjectOutput, error)
+	GetObject(context.Context, *s3.GetObjectInput, ...func(*s3.Options)) (*s3.GetObjectOutput, error)
+	GetObjectAcl(context.Context, *s3.GetObjectAclInput, ...func(*s3.Options)) (*s3.GetObjectAclOutput, error)
+	GetObjectAttributes(context.Context, *s3.GetObjectAttributesInput, ...func(*s3.Options)) (*s3.GetObjectAttributesOutput, error)
+	PutObject(context.Context, *s3.PutObjectInput, ...func(*s3.Options)) (*s3.PutObjectOutput, error)
+	CopyObject(context.Context, *s3.CopyObjectInput, ...func(*s3.Options)) (*s3.CopyObjectOutput, error)
+	GetObjectTagging(context.Context, *s3.GetObjectTaggingInput, ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error)
+	DeleteObject(context.Context, *s3.DeleteObjectInput, ...func(*s3.Options)) (*s3.DeleteObjectOutput, error)
+	DeleteObjects(context.Context, *s3.DeleteObjectsInput, ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error)
+	ListObjects(context.Context, *s3.ListObjectsInput, ...func(*s3.Options)) (*s3.ListObjectsOutput, error)
+	GetObjectLegalHold(context.Context, *s3.GetObjectLegalHoldInput, ...func(*s3.Options)) (*s3.GetObjectLegalHoldOutput, error)
+	GetObjectRetention(context.Context, *s3.GetObjectRetentionInput, ...func(*s3.Options)) (*s3.GetObjectRetentionOutput, error)
+	GetObjectLockConfiguration(context.Context, *s3.GetObjectLockConfigurationInput, ...func(*s3.Options)) (*s3.GetObjectLockConfigurationOutput, error)
+	GetBucketLifecycleConfiguration(context.Context, *s3.GetBucketLifecycleConfigurationInput, ...func(*s3.Options)) (*s3.GetBucketLifecycleConfigurationOutput, error)
+	PutBucketLifecycleConfiguration(context.Context, *s3.PutBucketLifecycleConfigurationInput, ...func(*s3.Options)) (*s3.PutBucketLifecycleConfigurationOutput, error)
+	ListObjectVersions(context.Context, *s3.ListObjectVersionsInput, ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error)
+	ListMultipartUploads(context.Context, *s3.ListMultipartUploadsInput, ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error)
+	CreateMultipartUpload(context.Context, *s3.CreateMultipartUploadInput, ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error)
+	UploadPart(context.Context, *s3.UploadPartInput, ...func(*s3.Options)) (*s3.UploadPartOutput, error)
+	UploadPartCopy(context.Context, *s3.UploadPartCopyInput, ...func(*s3.Options)) (*s3.UploadPartCopyOutput, error)
+	CompleteMultipartUpload(context.Context, *s3.CompleteMultipartUploadInput, ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error)
+	AbortMultipartUpload(context.Context, *s3.AbortMultipartUploadInput, ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error)
 }
 
 // S3Options support the use and creation of S3 backed buckets.
@@ -96,9 +173,36 @@ type S3Options struct {
 	// DeleteOnPull will delete all objects from the target that do not
 	// exist in the source after the completion of Pull.
 	DeleteOnPull bool
-	// Compress enables gzipping of uploaded objects. For downloading, objects
-	// that are compressed with gzip are automatically decoded.
+	// Compress enables compression of uploaded objects. For downloading,
+	// objects that are compressed with gzip or zstd are automatically
+	// decoded.
 	Compress bool
+	// CompressionAlgorithm sets the algorithm used to compress uploaded
+	// objects when Compress is set. Defaults to gzip. (Optional)
+	CompressionAlgorithm CompressionAlgorithm
+	// CompressionDictionary is a Zstandard dictionary, as produced by
+	// `zstd --train`, used to compress and decompress objects with zstd.
+	// Dictionaries greatly improve the compression of many small, similar
+	// objects. The dictionary's ID is recorded in the metadata of each
+	// object, and objects compressed with a dictionary can only be read by
+	// a bucket with the same dictionary. Requires the zstd compression
+	// algorithm. (Optional)
+	CompressionDictionary []byte
+	// LenientGzip allows readers of gzip-compressed objects to be closed
+	// before the object has been read in full, which otherwise fails
+	// because the gzip checksum has not been validated. It also treats
+	// objects whose data ends early, such as truncated objects, as
+	// complete: readers return io.EOF rather than io.ErrUnexpectedEOF
+	// once the available data has been read. Corrupt data, such as a
+	// checksum that does not match, is still reported. (Optional)
+	LenientGzip bool
+	// SniffCompression decompresses objects that have no content
+	// encoding but whose data starts with the gzip magic number, such as
+	// legacy objects that were uploaded compressed without the encoding
+	// being recorded. Objects that are gzip files in their own right,
+	// those with a .gz, .tgz or .gzip extension or a gzip content type,
+	// are never decompressed. (Optional)
+	SniffCompression bool
 	// UseSingleFileChecksums forces the bucket to checksum files before
 	// running uploads and download operation (rather than doing these
 	// operations independently.) Useful for large files, particularly in
@@ -109,11 +213,31 @@ type S3Options struct {
 	// MaxRetries sets the number of retry attempts for S3 operations.
 	// By default it defers to the AWS SDK's default.
 	MaxRetries *int
+	// ReadRetries and WriteRetries override MaxRetries for operations that
+	// read from and write to the bucket, respectively. Reads are
+	// idempotent and safe to retry aggressively, whereas retrying
+	// non-idempotent writes, such as completing a multipart upload, can
+	// fail or waste work. (Optional)
+	ReadRetries  *int
+	WriteRetries *int
+	// MaxIdleConns, MaxIdleConnsPerHost, and IdleConnTimeout tune the
+	// connection pool of the HTTP transport used for S3 requests, as
+	// described in http.Transport. They are ignored if the bucket is
+	// constructed with an explicit HTTP client. Buckets with the same
+	// settings share a transport, and so its idle connections. Zero
+	// values use the AWS SDK's defaults. (Optional)
+	MaxIdleConns        int
+	MaxIdleConnsPerHost int
+	IdleConnTimeout     time.Duration
+	// CredentialSource pins the source of the AWS credentials. Defaults to
+	// CredentialSourceChain, which documents the order in which the other
+	// credential options and the environment are used. (Optional)
+	CredentialSource CredentialSource
 	// Credentials allows the passing in of explicit AWS credentials. These
 	// will override the default credentials chain. (Optional)
 	Credentials aws.CredentialsProvider
-	// SharedCredentialsFilepath, when not empty, will override the default
-	// credentials chain and the Credentials value (see above). (Optional)
+	// SharedCredentialsFilepath, when not empty, overrides the location of
+	// the shared credentials file. (Optional)
 	SharedCredentialsFilepath string
 	// SharedCredentialsProfile, when not empty, will fetch the given
 	// credentials profile from the shared credentials file. (Optional)
@@ -128,22 +252,153 @@ type S3Options struct {
 	// session. This field is ignored if AssumeRoleARN is not set.
 	// (Optional)
 	AssumeRoleOptions []func(*stscreds.AssumeRoleOptions)
-	// Region specifies the AWS region.
+	// Region specifies the AWS region. The legacy "EU" location
+	// constraint is accepted as eu-west-1.
 	Region string
-	// Name specifies the name of the bucket.
+	// Endpoint overrides the URL of the S3 service, for example to use an
+	// S3-compatible service. (Optional)
+	Endpoint string
+	// UseFIPS sends requests to the region's FIPS 140-2 validated
+	// endpoints, as required in environments such as GovCloud. It cannot
+	// be combined with Endpoint. (Optional)
+	UseFIPS bool
+	// KeyTransform, if set, transforms the key of each object as it is
+	// stored in the bucket, not including the bucket's prefix. Keys are
+	// encoded on every request and keys returned by S3, such as those of
+	// listed objects, are decoded, so callers only see the untransformed
+	// keys. URLKeyTransform percent-encodes characters that are unsafe in
+	// S3 keys. S3 lists objects in the order of their transformed keys,
+	// which may differ from the order of the untransformed keys.
+	// (Optional)
+	KeyTransform KeyTransform
+	// ExpectedBucketOwner is the ID of the AWS account that must own the
+	// bucket. If set, S3 rejects every request to the bucket with an
+	// AccessDenied error if the bucket is owned by a different account,
+	// for example because it was deleted and re-created by another
+	// account. (Optional)
+	ExpectedBucketOwner string
+	// MetadataConcurrency, if positive, is the maximum number of requests
+	// for object metadata, such as the HeadObject requests made by
+	// Exists and ExistsMany and the GetObjectAttributes requests made by
+	// ListWithAttributes, that the bucket makes concurrently, across
+	// all of the goroutines using it. Requests beyond the limit wait for
+	// a slot, so that bulk metadata scans do not exhaust the HTTP
+	// client's connections. (Optional)
+	MetadataConcurrency int
+	// Name specifies the name of the bucket. It may instead be the ARN of
+	// an access point, including a multi-region access point, whose
+	// requests are signed with SigV4A and routed by S3 to the nearest
+	// healthy region. Multi-region access points cannot be used with a
+	// custom Endpoint or UseFIPS.
 	Name string
 	// Prefix specifies the prefix to use. (Optional)
 	Prefix string
+	// PrefixSegments are appended to Prefix, separated by slashes, so
+	// that a prefix can be composed from layers of configuration, such as
+	// an environment and a team. Empty segments and redundant slashes are
+	// removed, and the composed prefix has no leading or trailing slash.
+	// Prefix is used as is if there are no segments. (Optional)
+	PrefixSegments []string
 	// Permissions sets the S3 permissions to use for each object. Defaults
 	// to FULL_CONTROL. See
 	// `https://docs.aws.amazon.com/AmazonS3/latest/dev/acl-overview.html`
 	// for more information.
 	Permissions S3Permissions
+	// DisableACL omits the ACL from every request that writes an object,
+	// for buckets whose Object Ownership setting is bucket owner
+	// enforced, which reject requests that set ACLs with an
+	// AccessControlListNotSupported error. It cannot be combined with
+	// Permissions. Copies into a bucket with Permissions set also omit the
+	// ACL if the bucket rejects it. (Optional)
+	DisableACL bool
 	// ContentType sets the standard MIME type of the object data. Defaults
 	// to nil. See
 	//`https://www.w3.org/Protocols/rfc2616/rfc2616-sec14.html#sec14.17`
 	// for more information.
 	ContentType string
+	// DetectContentType sets the MIME type of each uploaded object to the
+	// type detected from the first 512 bytes of its data, as by
+	// http.DetectContentType. ContentType, if set, is used for data whose
+	// type is not recognized. (Optional)
+	DetectContentType bool
+	// MaxKeyLength sets the maximum length, in bytes, of the keys of
+	// objects written to the bucket, including the bucket's prefix.
+	// Writes and pushes with longer keys fail before any data is
+	// transferred. Defaults to, and cannot exceed, S3's limit of 1024
+	// bytes. (Optional)
+	MaxKeyLength int
+	// ValidateKeyUTF8 additionally rejects writes to keys that are not
+	// valid UTF-8. (Optional)
+	ValidateKeyUTF8 bool
+	// SendContentMD5 sends the MD5 checksum of each object uploaded in a
+	// single request, so that S3 rejects the upload if the data is
+	// corrupted in transit. It does not apply to multipart uploads.
+	// (Optional)
+	SendContentMD5 bool
+	// VerifyETag compares the ETag that S3 returns for each uploaded
+	// object with the ETag computed from the uploaded data, which is the
+	// MD5 checksum of objects uploaded in a single request or the
+	// multipart ETag of objects uploaded in parts, and fails the upload
+	// with ErrChecksumMismatch if they differ. The object is still stored.
+	// Objects encrypted with SSE-KMS or SSE-C do not have MD5-based ETags,
+	// so this cannot be used with buckets that encrypt with them.
+	// (Optional)
+	VerifyETag bool
+	// VerifyCRC32C validates the data of each object that is read in full,
+	// as by Get, Reader, and Download, against the CRC32C checksum that
+	// S3 stores for it, such as for objects uploaded with the CRC32C
+	// checksum algorithm. The checksum is returned with the object by
+	// enabling the checksum mode of each read, and reads fail with
+	// ErrChecksumMismatch once the data is read if it does not match.
+	// Objects without a CRC32C checksum, and objects uploaded in parts,
+	// whose checksum is of their parts, are read without validation.
+	// (Optional)
+	VerifyCRC32C bool
+	// ComputeTreeHash computes the SHA-256 tree hash that Amazon S3
+	// Glacier uses to verify archives of each object as it is uploaded,
+	// and stores it in the object's "sha256-tree-hash" metadata, so that
+	// the object can later be archived without reading it again. The hash
+	// is of the object as stored, after compression. The metadata of
+	// objects uploaded in multiple parts can only be set after the upload
+	// completes, by copying the object onto itself within S3, so in
+	// buckets with versioning or Object Lock enabled each such upload
+	// also keeps a noncurrent version of the object without the hash.
+	// (Optional)
+	ComputeTreeHash bool
+	// CopyBufferSize sets the size, in bytes, of the buffer used to copy
+	// data in Put, Upload, and Download. Larger buffers make fewer, larger
+	// reads and writes, which can improve throughput over high-bandwidth
+	// links. Defaults to 32KB. (Optional)
+	CopyBufferSize int
+	// ObjectRetention sets the Object Lock retention of each object
+	// written to the bucket. Set RetainFor rather than RetainUntil to
+	// retain each object for a length of time after it is written. Writes
+	// fail if the retention is less strict than the bucket's default
+	// retention. (Optional)
+	ObjectRetention *ObjectRetention
+	// RequestTags are set as object tags on each object written to the
+	// bucket, for example so that storage costs can be allocated by team.
+	// S3 allows at most 10 tags per object. Objects copied within S3 keep
+	// the tags of their source. (Optional)
+	RequestTags map[string]string
+	// ServerSideEncryption sets the server-side encryption of each object
+	// written or copied to the bucket: "AES256", "aws:kms", or
+	// "aws:kms:dsse". Defaults to the bucket's default encryption.
+	// (Optional)
+	ServerSideEncryption string
+	// SSEKMSKeyID sets the ID or ARN of the KMS key that objects are
+	// encrypted with, which requires ServerSideEncryption to be "aws:kms"
+	// or "aws:kms:dsse". Defaults to the AWS managed key for S3.
+	// (Optional)
+	SSEKMSKeyID string
+	// ArchiveWorkers, if greater than one, is the number of files that
+	// archive buckets read concurrently while creating the archive in
+	// Push, so that slow reads overlap. The files are still written to
+	// the archive one at a time and in order, and each file read ahead is
+	// held in memory until it is written; at most 64 MiB of files are read
+	// ahead, and larger files are read as they are written. Defaults to
+	// reading one file at a time. (Optional)
+	ArchiveWorkers int
 }
 
 // CreateAWSCredentials is a wrapper for creating static AWS credentials.
@@ -151,12 +406,37 @@ func CreateAWSCredentials(awsKey, awsPassword, awsToken string) aws.CredentialsP
 	return credentials.NewStaticCredentialsProvider(awsKey, awsPassword, awsToken)
 }
 
-func (s *s3Bucket) normalizeKey(key string) string { return s.Join(s.prefix, key) }
+func (s *s3Bucket) normalizeKey(key string) string {
+	if s.keyTransform != nil {
+		key = s.keyTransform.Encode(key)
+	}
+	return s.Join(s.prefix, key)
+}
+
+// normalizeListPrefix returns the prefix of the keys in the bucket that have
+// the given prefix. Unlike normalizeKey, it keeps a trailing slash, and an
+// empty prefix only matches the keys under the bucket's prefix.
+func (s *s3Bucket) normalizeListPrefix(prefix string) string {
+	normalized := s.normalizeKey(prefix)
+	if normalized != "" && (strings.HasSuffix(prefix, "/") || prefix == "") {
+		normalized += "/"
+	}
+	return normalized
+}
 
-func (s *s3Bucket) denormalizeKey(key string) string { return consistentTrimPrefix(key, s.prefix) }
+func (s *s3Bucket) denormalizeKey(key string) string {
+	key = consistentTrimPrefix(key, s.prefix)
+	if s.keyTransform != nil {
+		key = s.keyTransform.Decode(key)
+	}
+	return key
+}
 
 func newS3BucketBase(ctx context.Context, client *http.Client, options S3Options) (*s3Bucket, error) {
 	if options.Permissions != "" {
+		if options.DisableACL {
+			return nil, errors.New("cannot set permissions when ACLs are disabled")
+		}
 		if err := options.Permissions.Validate(); err != nil {
 			return nil, errors.WithStack(err)
 		}
@@ -166,45 +446,216 @@ func newS3BucketBase(ctx context.Context, client *http.Client, options S3Options
 		return nil, errors.New("ambiguous delete on sync options set")
 	}
 
+	if options.CompressionAlgorithm != "" {
+		if err := options.CompressionAlgorithm.Validate(); err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+	var dictionaryID string
+	if len(options.CompressionDictionary) > 0 {
+		if options.CompressionAlgorithm != CompressionZstd {
+			return nil, errors.New("compression dictionary requires zstd compression")
+		}
+		var err error
+		dictionaryID, err = zstdDictionaryID(options.CompressionDictionary)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+	}
+
+	if err := options.CredentialSource.Validate(); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	if options.MaxKeyLength < 0 || options.MaxKeyLength > s3MaxKeyLength {
+		return nil, errors.Errorf("maximum key length must be between 0 and %d bytes", s3MaxKeyLength)
+	}
+
+	if options.CopyBufferSize < 0 {
+		return nil, errors.New("copy buffer size must be positive")
+	}
+
+	if options.ArchiveWorkers < 0 {
+		return nil, errors.New("archive workers must not be negative")
+	}
+
+	if options.ObjectRetention != nil {
+		if err := options.ObjectRetention.validate(); err != nil {
+			return nil, errors.Wrap(err, "invalid object retention")
+		}
+	}
+
+	encryption, err := newServerSideEncryption(options.ServerSideEncryption, options.SSEKMSKeyID)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid server-side encryption")
+	}
+	if options.VerifyETag && encryption.usesKMS() {
+		return nil, errors.New("cannot verify ETags of objects encrypted with KMS keys")
+	}
+
+	if options.UseFIPS && options.Endpoint != "" {
+		return nil, errors.New("cannot use FIPS endpoints with a custom endpoint")
+	}
+
+	multiRegionAccessPoint := isMultiRegionAccessPointARN(options.Name)
+	if multiRegionAccessPoint {
+		if options.Endpoint != "" {
+			return nil, errors.New("cannot use a custom endpoint with a multi-region access point")
+		}
+		if options.UseFIPS {
+			return nil, errors.New("cannot use FIPS endpoints with a multi-region access point")
+		}
+	}
+
+	tagging, err := encodeObjectTags(options.RequestTags)
+	if err != nil {
+		return nil, errors.Wrap(err, "invalid request tags")
+	}
+
+	region := options.Region
+	if region != "" {
+		region = normalizeRegion(region)
+	}
 	config := configOpts{
-		region:                    options.Region,
+		region:                    region,
 		maxRetries:                aws.ToInt(options.MaxRetries),
-		client:                    client,
 		sharedCredentialsFilepath: options.SharedCredentialsFilepath,
 		sharedCredentialsProfile:  options.SharedCredentialsProfile,
 	}
+	if client != nil {
+		config.client = client
+	} else {
+		config.pool = connectionPoolOpts{
+			maxIdleConns:        options.MaxIdleConns,
+			maxIdleConnsPerHost: options.MaxIdleConnsPerHost,
+			idleConnTimeout:     options.IdleConnTimeout,
+		}
+	}
 	cfg, err := getCachedConfig(ctx, config)
 	if err != nil {
 		return nil, errors.Wrap(err, "getting AWS config")
 	}
 
+	creds, err := pinnedCredentials(ctx, options)
+	if err != nil {
+		return nil, errors.Wrap(err, "resolving AWS credentials")
+	}
+	if creds == nil && options.Credentials != nil {
+		creds = options.Credentials
+	} else if options.AssumeRoleARN != "" && options.CredentialSource != CredentialSourceExplicit {
+		var stsOpts []func(*sts.Options)
+		if creds != nil {
+			baseCreds := creds
+			stsOpts = append(stsOpts, func(opts *sts.Options) {
+				opts.Credentials = baseCreds
+			})
+		}
+		assumeRoleClient := sts.NewFromConfig(*cfg, stsOpts...)
+		creds = stscreds.NewAssumeRoleProvider(assumeRoleClient, options.AssumeRoleARN, options.AssumeRoleOptions...)
+	}
+
+	// Cache the credentials so that they can be refreshed if they expire
+	// before the cache expects them to.
+	credsCache, _ := cfg.Credentials.(*aws.CredentialsCache)
+	if creds != nil {
+		if credsCache, _ = creds.(*aws.CredentialsCache); credsCache == nil {
+			credsCache = aws.NewCredentialsCache(creds)
+		}
+		creds = credsCache
+	}
+
 	var s3Opts []func(*s3.Options)
-	if options.Credentials != nil {
+	var controlOpts []func(*s3control.Options)
+	if creds != nil {
+		s3Opts = append(s3Opts, func(opts *s3.Options) {
+			opts.Credentials = creds
+		})
+		controlOpts = append(controlOpts, func(opts *s3control.Options) {
+			opts.Credentials = creds
+		})
+	}
+	if options.Endpoint != "" {
+		s3Opts = append(s3Opts, func(opts *s3.Options) {
+			opts.BaseEndpoint = aws.String(options.Endpoint)
+		})
+	}
+	if options.UseFIPS {
 		s3Opts = append(s3Opts, func(opts *s3.Options) {
-			opts.Credentials = options.Credentials
+			opts.EndpointOptions.UseFIPSEndpoint = aws.FIPSEndpointStateEnabled
 		})
-	} else if options.AssumeRoleARN != "" {
+		controlOpts = append(controlOpts, func(opts *s3control.Options) {
+			opts.EndpointOptions.UseFIPSEndpoint = aws.FIPSEndpointStateEnabled
+		})
+	}
+
+	if multiRegionAccessPoint {
 		s3Opts = append(s3Opts, func(opts *s3.Options) {
-			assumeRoleClient := sts.NewFromConfig(*cfg)
-			opts.Credentials = stscreds.NewAssumeRoleProvider(assumeRoleClient, options.AssumeRoleARN, options.AssumeRoleOptions...)
+			opts.DisableMultiRegionAccessPoints = false
 		})
 	}
 
-	svc := s3.NewFromConfig(*cfg, s3Opts...)
+	s3Svc := s3.NewFromConfig(*cfg, s3Opts...)
+	if multiRegionAccessPoint {
+		if err := validateSigV4ASupport(s3Svc.Options()); err != nil {
+			return nil, errors.Wrap(err, "configuring multi-region access point")
+		}
+	}
+	var svc s3Client = s3Svc
+	if options.ExpectedBucketOwner != "" {
+		svc = &expectedOwnerClient{s3Client: svc, owner: options.ExpectedBucketOwner}
+	}
+	if options.ReadRetries != nil || options.WriteRetries != nil {
+		svc = &retryPolicyClient{
+			s3Client:      svc,
+			readAttempts:  aws.ToInt(options.ReadRetries),
+			writeAttempts: aws.ToInt(options.WriteRetries),
+		}
+	}
+	if credsCache != nil {
+		svc = &credentialRefreshClient{s3Client: svc, creds: credsCache}
+	}
+	controlSvc := s3control.NewFromConfig(*cfg, controlOpts...)
+	var metadataSlots chan struct{}
+	if options.MetadataConcurrency > 0 {
+		metadataSlots = make(chan struct{}, options.MetadataConcurrency)
+	}
 
 	return &s3Bucket{
-		name:                options.Name,
-		prefix:              options.Prefix,
-		compress:            options.Compress,
-		singleFileChecksums: options.UseSingleFileChecksums,
-		verbose:             options.Verbose,
-		svc:                 svc,
-		permissions:         options.Permissions,
-		contentType:         options.ContentType,
-		dryRun:              options.DryRun,
-		batchSize:           1000,
-		deleteOnPush:        options.DeleteOnPush || options.DeleteOnSync,
-		deleteOnPull:        options.DeleteOnPull || options.DeleteOnSync,
+		name:                    options.Name,
+		region:                  cfg.Region,
+		prefix:                  options.prefix(),
+		compress:                options.Compress,
+		compressionAlgorithm:    options.CompressionAlgorithm,
+		compressionDictionary:   options.CompressionDictionary,
+		compressionDictionaryID: dictionaryID,
+		lenientGzip:             options.LenientGzip,
+		sniffCompression:        options.SniffCompression,
+		objectRetention:         options.ObjectRetention,
+		objectLockConfig:        &objectLockConfigCache{},
+		singleFileChecksums:     options.UseSingleFileChecksums,
+		verbose:                 options.Verbose,
+		svc:                     svc,
+		controlSvc:              controlSvc,
+		presignSvc:              s3Svc,
+		permissions:             options.Permissions,
+		contentType:             options.ContentType,
+		detectContentType:       options.DetectContentType,
+		maxKeyLength:            options.MaxKeyLength,
+		validateKeyUTF8:         options.ValidateKeyUTF8,
+		sendContentMD5:          options.SendContentMD5,
+		verifyETag:              options.VerifyETag,
+		verifyCRC32C:            options.VerifyCRC32C,
+		computeTreeHash:         options.ComputeTreeHash,
+		copyBufferSize:          options.CopyBufferSize,
+		archiveWorkers:          options.ArchiveWorkers,
+		tagging:                 tagging,
+		encryption:              encryption,
+		keyTransform:            options.KeyTransform,
+		metadataSlots:           metadataSlots,
+		dryRun:                  options.DryRun,
+		batchSize:               1000,
+		deleteOnPush:            options.DeleteOnPush || options.DeleteOnSync,
+		deleteOnPull:            options.DeleteOnPull || options.DeleteOnSync,
 	}, nil
 }
 
@@ -216,7 +667,68 @@ type configOpts struct {
 	sharedCredentialsFilepath string
 	sharedCredentialsProfile  string
 	expiry                    time.Duration
-	client                    *http.Client
+	client                    config.HTTPClient
+	// pool, if set, configures the connection pool of the HTTP client
+	// when no client is given.
+	pool connectionPoolOpts
+}
+
+// connectionPoolOpts are the connection pool settings of an HTTP transport
+// for S3 requests.
+type connectionPoolOpts struct {
+	maxIdleConns        int
+	maxIdleConnsPerHost int
+	idleConnTimeout     time.Duration
+}
+
+// pooledHTTPClientKey identifies the HTTP clients that can share a transport.
+// The profile is included since it can configure a custom CA bundle.
+type pooledHTTPClientKey struct {
+	pool    connectionPoolOpts
+	profile string
+}
+
+// pooledHTTPClients are the HTTP clients for S3 requests by their connection
+// pool settings, so that buckets with the same settings share a transport
+// and its pool of connections rather than each opening their own.
+var pooledHTTPClients = struct {
+	sync.Mutex
+	clients map[pooledHTTPClientKey]*http.Client
+}{clients: map[pooledHTTPClientKey]*http.Client{}}
+
+// pooledHTTPClient returns the HTTP client shared by every bucket with the
+// same connection pool settings and profile. Its transport is the one that
+// the loaded config's HTTP client, such as one configured with a custom CA
+// bundle, or else the AWS SDK's default client would build, configured with
+// the connection pool settings. The client is not an AWS SDK buildable client,
+// since the SDK copies those for each S3 client, each with its own transport.
+func pooledHTTPClient(base aws.HTTPClient, pool connectionPoolOpts, profile string) *http.Client {
+	key := pooledHTTPClientKey{pool: pool, profile: profile}
+
+	pooledHTTPClients.Lock()
+	defer pooledHTTPClients.Unlock()
+
+	if shared, ok := pooledHTTPClients.clients[key]; ok {
+		return shared
+	}
+	buildable, ok := base.(*awshttp.BuildableClient)
+	if !ok {
+		buildable = awshttp.NewBuildableClient()
+	}
+	tr := buildable.WithTransportOptions(func(tr *http.Transport) {
+		if pool.maxIdleConns != 0 {
+			tr.MaxIdleConns = pool.maxIdleConns
+		}
+		if pool.maxIdleConnsPerHost != 0 {
+			tr.MaxIdleConnsPerHost = pool.maxIdleConnsPerHost
+		}
+		if pool.idleConnTimeout != 0 {
+			tr.IdleConnTimeout = pool.idleConnTimeout
+		}
+	}).GetTransport()
+	shared := &http.Client{Transport: tr}
+	pooledHTTPClients.clients[key] = shared
+	return shared
 }
 
 func getCachedConfig(ctx context.Context, cfgOpts configOpts) (*aws.Config, error) {
@@ -253,6 +765,9 @@ func getCachedConfig(ctx context.Context, cfgOpts configOpts) (*aws.Config, erro
 	if err != nil {
 		return nil, errors.Wrap(err, "creating new session")
 	}
+	if cfgOpts.client == nil && cfgOpts.pool != (connectionPoolOpts{}) {
+		newCfg.HTTPClient = pooledHTTPClient(newCfg.HTTPClient, cfgOpts.pool, cfgOpts.sharedCredentialsProfile)
+	}
 	if isDefault {
 		configCache[cfgOpts] = &newCfg
 	}
@@ -333,8 +848,48 @@ func (s *s3Bucket) Check(ctx context.Context) error {
 	return nil
 }
 
+func (s *s3Bucket) Ping(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	_, err := s.svc.HeadBucket(ctx, &s3.HeadBucketInput{
+		Bucket: aws.String(s.name),
+	})
+	if err != nil {
+		// As in Check, a 403 Forbidden error means that the bucket is
+		// reachable but the credentials may only have access to a
+		// sub-bucket.
+		var apiErr smithy.APIError
+		if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "Forbidden" {
+			return 0, errors.Wrap(err, "getting S3 head bucket")
+		}
+	}
+
+	return time.Since(start), nil
+}
+
+// acquireMetadataSlot blocks until the bucket may make another request for
+// object metadata or the context is done, returning the function that frees
+// the slot.
+func (s *s3Bucket) acquireMetadataSlot(ctx context.Context) (func(), error) {
+	if s.metadataSlots == nil {
+		return func() {}, nil
+	}
+
+	select {
+	case s.metadataSlots <- struct{}{}:
+		return func() { <-s.metadataSlots }, nil
+	case <-ctx.Done():
+		return nil, errors.WithStack(ctx.Err())
+	}
+}
+
 func (s *s3Bucket) Exists(ctx context.Context, key string) (bool, error) {
-	_, err := s.svc.HeadObject(ctx, &s3.HeadObjectInput{
+	release, err := s.acquireMetadataSlot(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+
+	_, err = s.svc.HeadObject(ctx, &s3.HeadObjectInput{
 		Bucket: aws.String(s.name),
 		Key:    aws.String(s.normalizeKey(key)),
 	})
@@ -353,37 +908,86 @@ func (s *s3Bucket) Exists(ctx context.Context, key string) (bool, error) {
 
 func (s *s3Bucket) Join(elems ...string) string { return consistentJoin(elems) }
 
+func (s *s3Bucket) URI(key string) string { return "s3://" + s.name + "/" + s.normalizeKey(key) }
+
 type smallWriteCloser struct {
-	isClosed    bool
-	dryRun      bool
-	compress    bool
-	verbose     bool
-	svc         *s3.Client
-	buffer      []byte
+	isClosed bool
+	dryRun   bool
+	verbose  bool
+	svc      s3Client
+	// buffer holds the object until it is uploaded on close.
+	buffer      *budgetedBuffer
 	name        string
 	ctx         context.Context
 	key         string
 	permissions S3Permissions
 	contentType string
+	// contentEncoding, metadata, and tagging are set on the uploaded
+	// object.
+	contentEncoding string
+	metadata        map[string]string
+	tagging         string
+	// retention, if set, is the Object Lock retention of the uploaded
+	// object.
+	retention *ObjectRetention
+	// contentTypeDetector, if set, detects the content type of the
+	// uploaded object, falling back to contentType.
+	contentTypeDetector *contentTypeDetector
+	// sendContentMD5 sends the MD5 checksum of the uploaded object.
+	sendContentMD5 bool
+	// verifyETag checks that the ETag of the uploaded object is the MD5
+	// checksum of the uploaded data.
+	verifyETag bool
+	// encryption is the server-side encryption of the uploaded object.
+	encryption serverSideEncryption
+	// computeTreeHash stores the tree hash of the uploaded object in its
+	// metadata.
+	computeTreeHash bool
 }
 
 type largeWriteCloser struct {
-	isCreated      bool
-	isClosed       bool
-	compress       bool
-	dryRun         bool
-	verbose        bool
-	partNumber     int32
-	minSize        int
-	svc            *s3.Client
-	ctx            context.Context
-	buffer         []byte
+	isCreated  bool
+	isClosed   bool
+	dryRun     bool
+	verbose    bool
+	partNumber int32
+	minSize    int
+	svc        s3Client
+	ctx        context.Context
+	// buffer holds the part being written until it is uploaded.
+	buffer         *budgetedBuffer
 	completedParts []s3Types.CompletedPart
-	name           string
-	key            string
-	permissions    S3Permissions
-	contentType    string
-	uploadID       string
+	partDigests    [][md5.Size]byte
+	expectedETag   string
+	// verifyETag checks that the ETag of the uploaded object is the
+	// multipart ETag computed from the uploaded parts.
+	verifyETag  bool
+	name        string
+	key         string
+	permissions S3Permissions
+	contentType string
+	uploadID    string
+	// contentEncoding, metadata, and tagging are set on the uploaded
+	// object.
+	contentEncoding string
+	metadata        map[string]string
+	tagging         string
+	// retention, if set, is the Object Lock retention of the uploaded
+	// object.
+	retention *ObjectRetention
+	// encryption is the server-side encryption of the uploaded object,
+	// which is set when the upload is created and applies to every part.
+	encryption serverSideEncryption
+	// contentTypeDetector, if set, detects the content type of the
+	// uploaded object, falling back to contentType.
+	contentTypeDetector *contentTypeDetector
+	// treeHasher, if set, computes the tree hash of the uploaded parts,
+	// which is stored in the object's metadata once the upload completes.
+	treeHasher *treeHasher
+	// bucket is the bucket that the object is uploaded to, which copies
+	// the object onto itself to set its tree hash.
+	bucket *s3Bucket
+	size   int64
 }
 
 func (w *largeWriteCloser) create() error {
@@ -400,11 +1004,20 @@ func (w *largeWriteCloser) create() error {
 			Bucket:      aws.String(w.name),
 			Key:         aws.String(w.key),
 			ACL:         s3Types.ObjectCannedACL(string(w.permissions)),
-			ContentType: aws.String(w.contentType),
+			ContentType: aws.String(w.contentTypeDetector.contentType(w.contentType)),
+			Metadata:    w.metadata,
 		}
-		if w.compress {
-			input.ContentEncoding = aws.String(compressionEncoding)
+		if w.contentEncoding != "" {
+			input.ContentEncoding = aws.String(w.contentEncoding)
 		}
+		if w.tagging != "" {
+			input.Tagging = aws.String(w.tagging)
+		}
+		if w.retention != nil {
+			input.ObjectLockMode = s3Types.ObjectLockMode(w.retention.Mode)
+			input.ObjectLockRetainUntilDate = aws.Time(w.retention.RetainUntil)
+		}
+		w.encryption.applyToCreateMultipartUpload(input)
 
 		result, err := w.svc.CreateMultipartUpload(w.ctx, input)
 		if err != nil {
@@ -436,7 +1049,7 @@ func (w *largeWriteCloser) complete() error {
 			UploadId: aws.String(w.uploadID),
 		}
 
-		_, err := w.svc.CompleteMultipartUpload(w.ctx, input)
+		result, err := w.svc.CompleteMultipartUpload(w.ctx, input)
 		if err != nil {
 			abortErr := w.abort()
 			if abortErr != nil {
@@ -444,10 +1057,61 @@ func (w *largeWriteCloser) complete() error {
 			}
 			return errors.Wrap(err, "completing multipart upload")
 		}
+
+		// Objects encrypted with SSE-KMS or SSE-C do not have MD5-based
+		// ETags, so a mismatch is only reported rather than treated as
+		// an error unless verification was requested.
+		w.expectedETag = multipartETag(w.partDigests)
+		if w.verifyETag {
+			if err := checkUploadETag(aws.ToString(result.ETag), w.expectedETag); err != nil {
+				return errors.WithStack(err)
+			}
+		}
+		etag := strings.Trim(aws.ToString(result.ETag), `"`)
+		grip.WarningWhen(etag != "" && etag != w.expectedETag, message.Fields{
+			"message":       "multipart upload ETag does not match the ETag computed from the uploaded parts",
+			"bucket":        w.name,
+			"key":           w.key,
+			"etag":          etag,
+			"expected_etag": w.expectedETag,
+		})
+
+		if w.treeHasher != nil {
+			return errors.Wrap(w.setTreeHash(result.ETag), "setting tree hash metadata")
+		}
 	}
 	return nil
 }
 
+// setTreeHash adds the tree hash of the uploaded parts to the metadata of the
+// completed object with the given ETag by copying the object onto itself,
+// which happens within S3, so the object's contents are not transferred
+// again. In buckets with versioning or Object Lock enabled, the copy is a new
+// version of the object, and the version uploaded without the hash is kept
+// as a noncurrent version.
+func (w *largeWriteCloser) setTreeHash(etag *string) error {
+	input := &s3.CopyObjectInput{
+		Bucket:            aws.String(w.name),
+		CopySource:        aws.String(escapeCopySource(copySource(w.name, w.key))),
+		Key:               aws.String(w.key),
+		ACL:               s3Types.ObjectCannedACL(string(w.permissions)),
+		MetadataDirective: s3Types.MetadataDirectiveReplace,
+		Metadata:          withTreeHash(w.metadata, w.treeHasher.Sum()),
+		ContentType:       aws.String(w.contentTypeDetector.contentType(w.contentType)),
+		CopySourceIfMatch: etag,
+	}
+	if w.contentEncoding != "" {
+		input.ContentEncoding = aws.String(w.contentEncoding)
+	}
+	if w.retention != nil {
+		input.ObjectLockMode = s3Types.ObjectLockMode(w.retention.Mode)
+		input.ObjectLockRetainUntilDate = aws.Time(w.retention.RetainUntil)
+	}
+	w.encryption.applyToCopy(input)
+
+	return w.bucket.copyInPlace(w.ctx, input, w.size)
+}
+
 func (w *largeWriteCloser) abort() error {
 	grip.DebugWhen(w.verbose, message.Fields{
 		"type":      "s3",
@@ -457,16 +1121,41 @@ func (w *largeWriteCloser) abort() error {
 		"key":       w.key,
 	})
 
+	ctx := w.ctx
+	if ctx.Err() != nil {
+		// Abort the upload even though the writer's context is done,
+		// so that the parts already uploaded are not left behind.
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(context.Background(), abortMultipartUploadTimeout)
+		defer cancel()
+	}
+
 	input := &s3.AbortMultipartUploadInput{
 		Bucket:   aws.String(w.name),
 		Key:      aws.String(w.key),
 		UploadId: aws.String(w.uploadID),
 	}
 
-	_, err := w.svc.AbortMultipartUpload(w.ctx, input)
+	_, err := w.svc.AbortMultipartUpload(ctx, input)
 	return err
 }
 
+// cancel aborts the upload, if it was started and the writer is not already
+// closed, because the writer's context is done, and returns the context's
+// error.
+func (w *largeWriteCloser) cancel() error {
+	w.buffer.reset()
+	err := w.ctx.Err()
+	if w.isCreated && !w.isClosed && !w.dryRun {
+		w.isClosed = true
+		if abortErr := w.abort(); abortErr != nil {
+			return errors.Wrapf(err, "aborting multipart upload: %s", abortErr)
+		}
+	}
+	w.isClosed = true
+	return errors.WithStack(err)
+}
+
 func (w *largeWriteCloser) flush() error {
 	grip.DebugWhen(w.verbose, message.Fields{
 		"type":      "s3",
@@ -475,6 +1164,9 @@ func (w *largeWriteCloser) flush() error {
 		"bucket":    w.name,
 		"key":       w.key,
 	})
+	// Once the part is uploaded, or the upload fails, its buffer is no
+	// longer needed.
+	defer w.buffer.reset()
 
 	if !w.isCreated {
 		err := w.create()
@@ -482,14 +1174,27 @@ func (w *largeWriteCloser) flush() error {
 			return err
 		}
 	}
+	if w.partNumber > maxMultipartParts {
+		catcher := grip.NewBasicCatcher()
+		catcher.Errorf("upload exceeds the maximum of %d parts; increase the part size", maxMultipartParts)
+		if !w.dryRun {
+			catcher.Wrap(w.abort(), "aborting multipart upload")
+		}
+		return catcher.Resolve()
+	}
 	if !w.dryRun {
 		input := &s3.UploadPartInput{
-			Body:       s3Manager.ReadSeekCloser(strings.NewReader(string(w.buffer))),
+			Body:       s3Manager.ReadSeekCloser(bytes.NewReader(w.buffer.bytes())),
 			Bucket:     aws.String(w.name),
 			Key:        aws.String(w.key),
 			PartNumber: aws.Int32(w.partNumber),
 			UploadId:   aws.String(w.uploadID),
 		}
+		if w.retention != nil {
+			// S3 requires an MD5 checksum to upload objects with
+			// Object Lock retention.
+			input.ContentMD5 = aws.String(contentMD5(w.buffer.bytes()))
+		}
 		result, err := w.svc.UploadPart(w.ctx, input)
 		if err != nil {
 			abortErr := w.abort()
@@ -502,9 +1207,13 @@ func (w *largeWriteCloser) flush() error {
 			ETag:       result.ETag,
 			PartNumber: aws.Int32(w.partNumber),
 		})
+		w.partDigests = append(w.partDigests, md5.Sum(w.buffer.bytes()))
+		if w.treeHasher != nil {
+			_, _ = w.treeHasher.Write(w.buffer.bytes())
+		}
+		w.size += int64(w.buffer.len())
 	}
 
-	w.buffer = []byte{}
 	w.partNumber++
 	return nil
 }
@@ -518,10 +1227,18 @@ func (w *smallWriteCloser) Write(p []byte) (int, error) {
 		"key":       w.key,
 	})
 
+	if err := w.ctx.Err(); err != nil {
+		w.isClosed = true
+		return 0, errors.WithStack(err)
+	}
 	if w.isClosed {
 		return 0, errors.New("writer already closed")
 	}
-	w.buffer = append(w.buffer, p...)
+	if err := w.buffer.write(w.ctx, p); err != nil {
+		w.isClosed = true
+		w.buffer.reset()
+		return 0, errors.WithStack(err)
+	}
 	return len(p), nil
 }
 
@@ -534,11 +1251,26 @@ func (w *largeWriteCloser) Write(p []byte) (int, error) {
 		"key":       w.key,
 	})
 
+	if w.ctx.Err() != nil {
+		return 0, w.cancel()
+	}
 	if w.isClosed {
 		return 0, errors.New("writer already closed")
 	}
-	w.buffer = append(w.buffer, p...)
-	if len(w.buffer) > w.minSize {
+
+	// Reserve exactly the space needed, at least a part, rather than
+	// letting the buffer double in size when the write overflows it.
+	size := w.buffer.len() + len(p)
+	if size < w.minSize {
+		size = w.minSize
+	}
+	if err := w.buffer.reserve(w.ctx, size); err != nil {
+		return 0, w.cancel()
+	}
+	if err := w.buffer.write(w.ctx, p); err != nil {
+		return 0, w.cancel()
+	}
+	if w.buffer.len() > w.minSize {
 		err := w.flush()
 		if err != nil {
 			return 0, err
@@ -555,27 +1287,61 @@ func (w *smallWriteCloser) Close() error {
 		"bucket":    w.name,
 		"key":       w.key,
 	})
+	// The object is no longer needed once it is uploaded, or the upload
+	// fails.
+	defer w.buffer.reset()
 
+	if err := w.ctx.Err(); err != nil {
+		w.isClosed = true
+		return errors.WithStack(err)
+	}
 	if w.isClosed {
 		return errors.New("writer already closed")
 	}
+	w.isClosed = true
 	if w.dryRun {
 		return nil
 	}
 
 	input := &s3.PutObjectInput{
-		Body:        s3Manager.ReadSeekCloser(strings.NewReader(string(w.buffer))),
+		Body:        s3Manager.ReadSeekCloser(bytes.NewReader(w.buffer.bytes())),
 		Bucket:      aws.String(w.name),
 		Key:         aws.String(w.key),
 		ACL:         s3Types.ObjectCannedACL(string(w.permissions)),
-		ContentType: aws.String(w.contentType),
+		ContentType: aws.String(w.contentTypeDetector.contentType(w.contentType)),
+		Metadata:    w.metadata,
+	}
+	if w.computeTreeHash {
+		hasher := newTreeHasher()
+		_, _ = hasher.Write(w.buffer.bytes())
+		input.Metadata = withTreeHash(w.metadata, hasher.Sum())
+	}
+	if w.contentEncoding != "" {
+		input.ContentEncoding = aws.String(w.contentEncoding)
 	}
-	if w.compress {
-		input.ContentEncoding = aws.String(compressionEncoding)
+	if w.tagging != "" {
+		input.Tagging = aws.String(w.tagging)
 	}
+	if w.retention != nil {
+		input.ObjectLockMode = s3Types.ObjectLockMode(w.retention.Mode)
+		input.ObjectLockRetainUntilDate = aws.Time(w.retention.RetainUntil)
+	}
+	// S3 requires an MD5 checksum to upload objects with Object Lock
+	// retention.
+	if w.retention != nil || w.sendContentMD5 {
+		input.ContentMD5 = aws.String(contentMD5(w.buffer.bytes()))
+	}
+	w.encryption.applyToPut(input)
 
-	_, err := w.svc.PutObject(w.ctx, input)
-	return errors.Wrap(err, "copying data to file")
+	output, err := w.svc.PutObject(w.ctx, input)
+	if err != nil {
+		return errors.Wrap(err, "copying data to file")
+	}
+	if w.verifyETag {
+		sum := md5.Sum(w.buffer.bytes())
+		return errors.WithStack(checkUploadETag(aws.ToString(output.ETag), hex.EncodeToString(sum[:])))
+	}
+	return nil
 
 }
 
@@ -588,35 +1354,40 @@ func (w *largeWriteCloser) Close() error {
 		"key":       w.key,
 	})
 
+	if w.ctx.Err() != nil {
+		return w.cancel()
+	}
 	if w.isClosed {
 		return errors.New("writer already closed")
 	}
-	if len(w.buffer) > 0 || w.partNumber == 0 {
+	if w.buffer.len() > 0 || w.partNumber == 0 {
 		err := w.flush()
 		if err != nil {
 			return err
 		}
 	}
-	err := w.complete()
-	return err
+	w.isClosed = true
+	return w.complete()
 }
 
 type compressingWriteCloser struct {
-	gzipWriter io.WriteCloser
+	compressor io.WriteCloser
 	s3Writer   io.WriteCloser
 }
 
 func (w *compressingWriteCloser) Write(p []byte) (int, error) {
-	return w.gzipWriter.Write(p)
+	return w.compressor.Write(p)
 }
 
 func (w *compressingWriteCloser) Close() error {
-	catcher := grip.NewBasicCatcher()
-
-	catcher.Add(w.gzipWriter.Close())
-	catcher.Add(w.s3Writer.Close())
+	compressErr := w.compressor.Close()
+	// Return the S3 writer's error as is, so that callers can detect
+	// whether the writer's context was canceled.
+	if err := w.s3Writer.Close(); err != nil {
+		return err
+	}
 
-	return catcher.Resolve()
+	return errors.Wrap(compressErr, "closing compressor")
 }
 
 func (s *s3BucketSmall) Writer(ctx context.Context, key string) (io.WriteCloser, error) {
@@ -629,23 +1400,42 @@ func (s *s3BucketSmall) Writer(ctx context.Context, key string) (io.WriteCloser,
 		"key":           key,
 	})
 
+	if err := s.validateKey(key); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	retention := s.objectRetention.at(time.Now())
+	if err := s.checkObjectRetention(ctx, retention); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	detector := s.newContentTypeDetector()
 	writer := &smallWriteCloser{
-		name:        s.name,
-		svc:         s.svc,
-		ctx:         ctx,
-		key:         s.normalizeKey(key),
-		permissions: s.permissions,
-		contentType: s.contentType,
-		dryRun:      s.dryRun,
-		compress:    s.compress,
+		buffer:              newBudgetedBuffer(),
+		name:                s.name,
+		svc:                 s.svc,
+		ctx:                 ctx,
+		key:                 s.normalizeKey(key),
+		permissions:         s.permissions,
+		contentType:         s.contentType,
+		dryRun:              s.dryRun,
+		contentEncoding:     s.contentEncoding(),
+		metadata:            s.uploadMetadata(),
+		tagging:             s.tagging,
+		retention:           retention,
+		contentTypeDetector: detector,
+		sendContentMD5:      s.sendContentMD5,
+		verifyETag:          s.verifyETag,
+		encryption:          s.encryption,
+		computeTreeHash:     s.computeTreeHash,
 	}
 	if s.compress {
-		return &compressingWriteCloser{
-			gzipWriter: gzip.NewWriter(writer),
-			s3Writer:   writer,
-		}, nil
+		compressor, err := s.newCompressingWriter(writer)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return withContentTypeDetection(compressor, detector), nil
 	}
-	return writer, nil
+	return withContentTypeDetection(writer, detector), nil
 }
 
 func (s *s3BucketLarge) Writer(ctx context.Context, key string) (io.WriteCloser, error) {
@@ -658,25 +1448,95 @@ func (s *s3BucketLarge) Writer(ctx context.Context, key string) (io.WriteCloser,
 		"key":           key,
 	})
 
+	if err := s.validateKey(key); err != nil {
+		return nil, errors.WithStack(err)
+	}
+	retention := s.objectRetention.at(time.Now())
+	if err := s.checkObjectRetention(ctx, retention); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	detector := s.newContentTypeDetector()
 	writer := &largeWriteCloser{
-		minSize:     s.minPartSize,
-		name:        s.name,
-		svc:         s.svc,
-		ctx:         ctx,
-		key:         s.normalizeKey(key),
-		permissions: s.permissions,
-		contentType: s.contentType,
-		dryRun:      s.dryRun,
-		compress:    s.compress,
-		verbose:     s.verbose,
+		buffer:              newBudgetedBuffer(),
+		minSize:             s.minPartSize,
+		name:                s.name,
+		svc:                 s.svc,
+		ctx:                 ctx,
+		key:                 s.normalizeKey(key),
+		permissions:         s.permissions,
+		contentType:         s.contentType,
+		dryRun:              s.dryRun,
+		verbose:             s.verbose,
+		contentEncoding:     s.contentEncoding(),
+		metadata:            s.uploadMetadata(),
+		tagging:             s.tagging,
+		retention:           retention,
+		contentTypeDetector: detector,
+		verifyETag:          s.verifyETag,
+		encryption:          s.encryption,
+		bucket:              &s.s3Bucket,
+	}
+	if s.computeTreeHash {
+		writer.treeHasher = newTreeHasher()
 	}
 	if s.compress {
-		return &compressingWriteCloser{
-			gzipWriter: gzip.NewWriter(writer),
-			s3Writer:   writer,
-		}, nil
+		compressor, err := s.newCompressingWriter(writer)
+		if err != nil {
+			return nil, errors.WithStack(err)
+		}
+		return withContentTypeDetection(compressor, detector), nil
+	}
+	return withContentTypeDetection(writer, detector), nil
+}
+
+func (s *s3BucketSmall) WriterWithInfo(ctx context.Context, key string, info *BucketItemInfo) (io.WriteCloser, error) {
+	withInfo := *s
+	withInfo.s3Bucket = s.withObjectInfo(info)
+	return withInfo.Writer(ctx, key)
+}
+
+func (s *s3BucketLarge) WriterWithInfo(ctx context.Context, key string, info *BucketItemInfo) (io.WriteCloser, error) {
+	withInfo := *s
+	withInfo.s3Bucket = s.withObjectInfo(info)
+	return withInfo.Writer(ctx, key)
+}
+
+// withObjectInfo returns a copy of the bucket that writes objects with the
+// content type and custom metadata in the given info. Metadata that describes
+// how the source object was compressed is not copied.
+func (s *s3Bucket) withObjectInfo(info *BucketItemInfo) s3Bucket {
+	withInfo := *s
+	if info.ContentType != "" {
+		withInfo.contentType = info.ContentType
+		withInfo.detectContentType = false
+	}
+	withInfo.objectMetadata = map[string]string{}
+	for k, v := range info.Metadata {
+		if k != zstdDictionaryMetadataKey {
+			withInfo.objectMetadata[k] = v
+		}
+	}
+
+	return withInfo
+}
+
+// uploadMetadata returns the metadata to set on each object written to the
+// bucket.
+func (s *s3Bucket) uploadMetadata() map[string]string {
+	compression := s.compressionMetadata()
+	if len(s.objectMetadata) == 0 {
+		return compression
+	}
+
+	metadata := make(map[string]string, len(s.objectMetadata)+len(compression))
+	for k, v := range s.objectMetadata {
+		metadata[k] = v
 	}
-	return writer, nil
+	for k, v := range compression {
+		metadata[k] = v
+	}
+	return metadata
 }
 
 func (s *s3Bucket) Reader(ctx context.Context, key string) (io.ReadCloser, error) {
@@ -692,8 +1552,13 @@ func (s *s3Bucket) Reader(ctx context.Context, key string) (io.ReadCloser, error
 		Bucket: aws.String(s.name),
 		Key:    aws.String(s.normalizeKey(key)),
 	}
+	var optFns []func(*s3.Options)
+	if s.verifyCRC32C {
+		input.ChecksumMode = s3Types.ChecksumModeEnabled
+		optFns = append(optFns, withoutResponseChecksumValidation)
+	}
 
-	result, err := s.svc.GetObject(ctx, input)
+	result, err := s.svc.GetObject(ctx, input, optFns...)
 	if err != nil {
 		var apiErr smithy.APIError
 		if errors.As(err, &apiErr) {
@@ -703,19 +1568,21 @@ func (s *s3Bucket) Reader(ctx context.Context, key string) (io.ReadCloser, error
 		}
 		return nil, err
 	}
-	if aws.ToString(result.ContentEncoding) == "gzip" {
-		return gzip.NewReader(result.Body)
+	body := result.Body
+	if checksum := getObjectCRC32C(result); s.verifyCRC32C && checksum != "" {
+		// The checksum is of the data as stored, before it is
+		// decompressed.
+		body = newCRC32CVerifyingReader(body, key, checksum)
 	}
-
-	return result.Body, nil
+	return s.newDecompressingReader(aws.ToString(result.ContentEncoding), result.Metadata, s.shouldSniff(key, aws.ToString(result.ContentType)), body)
 }
 
-func putHelper(ctx context.Context, b Bucket, key string, r io.Reader) error {
+func (s *s3Bucket) putHelper(ctx context.Context, b Bucket, key string, r io.Reader) error {
 	f, err := b.Writer(ctx, key)
 	if err != nil {
 		return errors.WithStack(err)
 	}
-	_, err = io.Copy(f, r)
+	_, err = copyWithBuffer(f, r, s.copyBufferSize)
 	if err != nil {
 		_ = f.Close()
 		return errors.Wrap(err, "copying data to file")
@@ -733,7 +1600,11 @@ func (s *s3BucketSmall) Put(ctx context.Context, key string, r io.Reader) error
 		"key":           key,
 	})
 
-	return putHelper(ctx, s, key, r)
+	return s.putHelper(ctx, s, key, r)
+}
+
+func (s *s3BucketLarge) singleRequestBucket() Bucket {
+	return &s3BucketSmall{s3Bucket: s.s3Bucket}
 }
 
 func (s *s3BucketLarge) Put(ctx context.Context, key string, r io.Reader) error {
@@ -746,85 +1617,409 @@ func (s *s3BucketLarge) Put(ctx context.Context, key string, r io.Reader) error
 		"key":           key,
 	})
 
-	return putHelper(ctx, s, key, r)
+	return s.putHelper(ctx, s, key, r)
 }
 
-func (s *s3Bucket) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+// ReaderAtUploader is implemented by buckets that can upload an object from
+// an io.ReaderAt, reading the parts of a multipart upload concurrently rather
+// than from a single sequential reader.
+type ReaderAtUploader interface {
+	UploadReaderAt(ctx context.Context, key string, r io.ReaderAt, size int64) error
+}
+
+// UploadReaderAt uploads size bytes read from r to the given key. The object
+// is uploaded in parts of the bucket's minimum part size, which are read and
+// sent concurrently. Compressed buckets cannot compress parts independently,
+// so they fall back to a sequential Put.
+func (s *s3BucketLarge) UploadReaderAt(ctx context.Context, key string, r io.ReaderAt, size int64) error {
 	grip.DebugWhen(s.verbose, message.Fields{
 		"type":          "s3",
-		"operation":     "get",
+		"dry_run":       s.dryRun,
+		"operation":     "upload reader at",
 		"bucket":        s.name,
 		"bucket_prefix": s.prefix,
 		"key":           key,
+		"size":          size,
 	})
 
-	return s.Reader(ctx, key)
-}
-
-func (s *s3Bucket) s3WithUploadChecksumHelper(ctx context.Context, target, file string) (bool, error) {
-	localmd5, err := utility.MD5SumFile(file)
+	if size < 0 {
+		return errors.New("size cannot be negative")
+	}
+	if err := s.validateKey(key); err != nil {
+		return errors.WithStack(err)
+	}
+	sized, err := s.withPartSizeFor(size)
 	if err != nil {
-		return false, errors.Wrapf(err, "checksumming '%s'", file)
+		return errors.Wrapf(err, "uploading key '%s'", key)
 	}
-	input := &s3.HeadObjectInput{
-		Bucket:  aws.String(s.name),
-		Key:     aws.String(target),
-		IfMatch: aws.String(localmd5),
+	if s.compress {
+		return sized.Put(ctx, key, io.NewSectionReader(r, 0, size))
 	}
-	_, err = s.svc.HeadObject(ctx, input)
-	var apiErr smithy.APIError
-	if errors.As(err, &apiErr) {
-		if apiErr.ErrorCode() == "PreconditionFailed" || apiErr.ErrorCode() == "NotFound" {
-			return true, nil
-		}
+	if s.dryRun {
+		return nil
 	}
 
-	return false, errors.Wrapf(err, "checking if object '%s' exists", target)
-}
+	retention := s.objectRetention.at(time.Now())
+	if err := s.checkObjectRetention(ctx, retention); err != nil {
+		return errors.WithStack(err)
+	}
 
-func doUpload(ctx context.Context, b Bucket, key, path string) error {
-	f, err := os.Open(path)
-	if err != nil {
-		return errors.Wrapf(err, "opening file '%s'", path)
+	uploader := s3Manager.NewUploader(s.svc, func(u *s3Manager.Uploader) {
+		u.PartSize = int64(sized.minPartSize)
+	})
+	input := &s3.PutObjectInput{
+		Bucket: aws.String(s.name),
+		Key:    aws.String(s.normalizeKey(key)),
+		ACL:    s3Types.ObjectCannedACL(string(s.permissions)),
+		Body:   io.NewSectionReader(r, 0, size),
+	}
+	if s.contentType != "" {
+		input.ContentType = aws.String(s.contentType)
+	}
+	if s.tagging != "" {
+		input.Tagging = aws.String(s.tagging)
+	}
+	if s.computeTreeHash {
+		// Unlike a streamed upload, the data can be read again locally,
+		// so the hash is computed before the upload starts, when the
+		// metadata is set.
+		hasher := newTreeHasher()
+		if _, err := copyWithBuffer(hasher, io.NewSectionReader(r, 0, size), s.copyBufferSize); err != nil {
+			return errors.Wrapf(err, "computing tree hash of key '%s'", key)
+		}
+		input.Metadata = withTreeHash(s.uploadMetadata(), hasher.Sum())
+	}
+	if detector := s.newContentTypeDetector(); detector != nil {
+		head := make([]byte, contentTypeSniffLen)
+		n, err := r.ReadAt(head, 0)
+		if err != nil && err != io.EOF {
+			return errors.Wrapf(err, "reading key '%s' to detect content type", key)
+		}
+		detector.observe(head[:n])
+		input.ContentType = aws.String(detector.contentType(s.contentType))
+	}
+	if retention != nil {
+		input.ObjectLockMode = s3Types.ObjectLockMode(retention.Mode)
+		input.ObjectLockRetainUntilDate = aws.Time(retention.RetainUntil)
+		// S3 requires a checksum of each part uploaded with Object Lock
+		// retention.
+		input.ChecksumAlgorithm = s3Types.ChecksumAlgorithmCrc32
+	}
+	s.encryption.applyToPut(input)
+	if _, err := uploader.Upload(ctx, input); err != nil {
+		return errors.Wrapf(err, "uploading key '%s'", key)
 	}
-	defer f.Close()
 
-	return errors.WithStack(b.Put(ctx, key, f))
+	return nil
 }
 
-func (s *s3Bucket) uploadHelper(ctx context.Context, b Bucket, key, path string) error {
+func (s *s3Bucket) Get(ctx context.Context, key string) (io.ReadCloser, error) {
 	grip.DebugWhen(s.verbose, message.Fields{
 		"type":          "s3",
-		"dry_run":       s.dryRun,
-		"operation":     "upload",
+		"operation":     "get",
 		"bucket":        s.name,
 		"bucket_prefix": s.prefix,
 		"key":           key,
-		"path":          path,
 	})
 
-	if s.singleFileChecksums {
-		shouldUpload, err := s.s3WithUploadChecksumHelper(ctx, key, path)
-		if err != nil {
-			return errors.WithStack(err)
-		}
-		if !shouldUpload {
-			return nil
-		}
-	}
-
-	return errors.WithStack(doUpload(ctx, b, key, path))
+	return s.Reader(ctx, key)
 }
 
-func (s *s3BucketLarge) Upload(ctx context.Context, key, path string) error {
-	return s.uploadHelper(ctx, s, key, path)
-}
+func (s *s3Bucket) GetLimited(ctx context.Context, key string, maxBytes int64) (io.ReadCloser, error) {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"operation":     "get limited",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+		"key":           key,
+		"max_bytes":     maxBytes,
+	})
 
-func (s *s3BucketSmall) Upload(ctx context.Context, key, path string) error {
-	return s.uploadHelper(ctx, s, key, path)
-}
+	if maxBytes < 0 {
+		return nil, errors.New("size limit cannot be negative")
+	}
+
+	head, err := s.svc.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.name),
+		Key:    aws.String(s.normalizeKey(key)),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			if apiErr.ErrorCode() == "NotFound" {
+				return nil, MakeKeyNotFoundError(err)
+			}
+		}
+		return nil, errors.Wrap(err, "getting S3 head object")
+	}
+	// The content length of compressed objects is the compressed size, so
+	// the decompressed stream is only checked while it is read.
+	if !isCompressedEncoding(aws.ToString(head.ContentEncoding)) && aws.ToInt64(head.ContentLength) > maxBytes {
+		return nil, errors.Wrapf(ErrObjectTooLarge, "key '%s' has %d bytes, which is larger than %d bytes", key, aws.ToInt64(head.ContentLength), maxBytes)
+	}
+
+	r, err := s.Reader(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return newLimitedReadCloser(r, key, maxBytes), nil
+}
+
+func (s *s3Bucket) ReaderSeeker(ctx context.Context, key string) (io.ReadSeekCloser, error) {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"operation":     "reader seeker",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+		"key":           key,
+	})
+
+	head, err := s.svc.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.name),
+		Key:    aws.String(s.normalizeKey(key)),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			if apiErr.ErrorCode() == "NotFound" {
+				return nil, MakeKeyNotFoundError(err)
+			}
+		}
+		return nil, errors.Wrap(err, "getting S3 head object")
+	}
+	// Offsets into the decompressed contents do not correspond to byte
+	// ranges of the stored object.
+	if isCompressedEncoding(aws.ToString(head.ContentEncoding)) {
+		return nil, errors.Wrapf(ErrNotSupported, "seeking within compressed key '%s'", key)
+	}
+
+	// Each range is only read from the version of the object that was
+	// found here, so that a concurrent overwrite fails the read rather
+	// than mixing the contents of different versions.
+	return newRangeReadSeeker(aws.ToInt64(head.ContentLength), nil, func(offset int64) (io.ReadCloser, error) {
+		result, err := s.svc.GetObject(ctx, &s3.GetObjectInput{
+			Bucket:  aws.String(s.name),
+			Key:     aws.String(s.normalizeKey(key)),
+			Range:   aws.String(fmt.Sprintf("bytes=%d-", offset)),
+			IfMatch: head.ETag,
+		})
+		if err != nil {
+			return nil, errors.Wrap(err, "getting S3 object range")
+		}
+		return result.Body, nil
+	}), nil
+}
+
+func (s *s3Bucket) GetRange(ctx context.Context, key string, offset, length int64) (io.ReadCloser, error) {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"operation":     "get range",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+		"key":           key,
+		"offset":        offset,
+		"length":        length,
+	})
+
+	if err := validateRange(offset, length); err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	result, err := s.svc.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.name),
+		Key:    aws.String(s.normalizeKey(key)),
+		Range:  aws.String(fmt.Sprintf("bytes=%d-%d", offset, offset+length-1)),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			switch apiErr.ErrorCode() {
+			case "NoSuchKey":
+				return nil, MakeKeyNotFoundError(err)
+			case "InvalidRange":
+				return nil, errors.Wrapf(ErrRangeNotSatisfiable, "offset %d of key '%s': %s", offset, key, err)
+			}
+		}
+		return nil, errors.Wrap(err, "getting S3 object range")
+	}
+	// Offsets into the decompressed contents do not correspond to byte
+	// ranges of the stored object.
+	if isCompressedEncoding(aws.ToString(result.ContentEncoding)) {
+		_ = result.Body.Close()
+		return nil, errors.Wrapf(ErrNotSupported, "reading range of compressed key '%s'", key)
+	}
+
+	return result.Body, nil
+}
+
+func (s *s3Bucket) GetWithInfo(ctx context.Context, key string) (io.ReadCloser, *BucketItemInfo, error) {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"operation":     "get with info",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+		"key":           key,
+	})
+
+	result, err := s.svc.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.name),
+		Key:    aws.String(s.normalizeKey(key)),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			if apiErr.ErrorCode() == "NoSuchKey" {
+				return nil, nil, MakeKeyNotFoundError(err)
+			}
+		}
+		return nil, nil, err
+	}
+
+	info := &BucketItemInfo{
+		Key:             key,
+		Size:            aws.ToInt64(result.ContentLength),
+		ContentType:     aws.ToString(result.ContentType),
+		ContentEncoding: aws.ToString(result.ContentEncoding),
+		LastModified:    aws.ToTime(result.LastModified),
+		ETag:            strings.Trim(aws.ToString(result.ETag), `"`),
+		Metadata:        result.Metadata,
+		ExpiresAt:       parseExpirationHeader(aws.ToString(result.Expiration)),
+	}
+	r, err := s.newDecompressingReader(info.ContentEncoding, result.Metadata, s.shouldSniff(key, info.ContentType), result.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return r, info, nil
+}
+
+func (s *s3Bucket) s3WithUploadChecksumHelper(ctx context.Context, target, file string) (bool, error) {
+	localmd5, err := utility.MD5SumFile(file)
+	if err != nil {
+		return false, errors.Wrapf(err, "checksumming '%s'", file)
+	}
+	input := &s3.HeadObjectInput{
+		Bucket:  aws.String(s.name),
+		Key:     aws.String(target),
+		IfMatch: aws.String(localmd5),
+	}
+	release, err := s.acquireMetadataSlot(ctx)
+	if err != nil {
+		return false, err
+	}
+	defer release()
+	_, err = s.svc.HeadObject(ctx, input)
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		if apiErr.ErrorCode() == "PreconditionFailed" || apiErr.ErrorCode() == "NotFound" {
+			return true, nil
+		}
+	}
+
+	return false, errors.Wrapf(err, "checking if object '%s' exists", target)
+}
+
+func doUpload(ctx context.Context, b Bucket, key, path string) error {
+	f, err := openUploadFile(ctx, path)
+	if err != nil {
+		return errors.Wrapf(err, "opening file '%s'", path)
+	}
+	defer f.Close()
+
+	return errors.WithStack(b.Put(ctx, key, f))
+}
+
+func (s *s3Bucket) uploadHelper(ctx context.Context, b Bucket, key, path string, size int64) (UploadResult, error) {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"dry_run":       s.dryRun,
+		"operation":     "upload",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+		"key":           key,
+		"path":          path,
+	})
+
+	if s.singleFileChecksums {
+		shouldUpload, err := s.s3WithUploadChecksumHelper(ctx, s.normalizeKey(key), path)
+		if err != nil {
+			return UploadResult{}, errors.WithStack(err)
+		}
+		if !shouldUpload {
+			return UploadResult{Skipped: true}, nil
+		}
+	}
+
+	if err := doUpload(ctx, b, key, path); err != nil {
+		return UploadResult{}, errors.WithStack(err)
+	}
+	if s.dryRun {
+		return UploadResult{}, nil
+	}
+	return UploadResult{Uploaded: true, BytesTransferred: size}, nil
+}
+
+func (s *s3BucketLarge) Upload(ctx context.Context, key, path string) error {
+	_, err := s.UploadWithResult(ctx, key, path)
+	return err
+}
+
+func (s *s3BucketLarge) UploadWithResult(ctx context.Context, key, path string) (UploadResult, error) {
+	// Increase the part size, if necessary, so that the file can be
+	// uploaded without exceeding the maximum number of parts, or fail
+	// before starting an upload that cannot complete.
+	info, err := os.Stat(path)
+	if err != nil {
+		return UploadResult{}, errors.Wrapf(err, "getting file stats for '%s'", path)
+	}
+	sized, err := s.withPartSizeFor(info.Size())
+	if err != nil {
+		return UploadResult{}, errors.Wrapf(err, "uploading file '%s'", path)
+	}
+
+	return s.uploadHelper(ctx, sized, key, path, info.Size())
+}
+
+// withPartSizeFor returns a copy of the bucket with a part size large enough
+// to upload an object of the given size without exceeding the maximum number
+// of parts.
+func (s *s3BucketLarge) withPartSizeFor(size int64) (*s3BucketLarge, error) {
+	partSize, err := multipartPartSize(size, s.minPartSize)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	sized := *s
+	sized.minPartSize = partSize
+	return &sized, nil
+}
+
+func (s *s3BucketSmall) Upload(ctx context.Context, key, path string) error {
+	_, err := s.UploadWithResult(ctx, key, path)
+	return err
+}
+
+func (s *s3BucketSmall) UploadWithResult(ctx context.Context, key, path string) (UploadResult, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return UploadResult{}, errors.Wrapf(err, "getting file stats for '%s'", path)
+	}
+
+	return s.uploadHelper(ctx, s, key, path, info.Size())
+}
+
+func doDownload(ctx context.Context, b Bucket, key, path string, bufferSize int) error {
+	reserved := bufferSize
+	if reserved == 0 {
+		reserved = defaultTransferBufferSize
+	}
+	release, err := globalTransferBudget.acquire(ctx, int64(reserved))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	defer release()
 
-func doDownload(ctx context.Context, b Bucket, key, path string) error {
 	reader, err := b.Reader(ctx, key)
 	if err != nil {
 		return errors.WithStack(err)
@@ -838,7 +2033,7 @@ func doDownload(ctx context.Context, b Bucket, key, path string) error {
 	if err != nil {
 		return errors.Wrapf(err, "creating file '%s'", path)
 	}
-	_, err = io.Copy(f, reader)
+	_, err = copyWithBuffer(f, reader, bufferSize)
 	if err != nil {
 		_ = f.Close()
 		return errors.Wrap(err, "copying data")
@@ -847,17 +2042,17 @@ func doDownload(ctx context.Context, b Bucket, key, path string) error {
 	return errors.WithStack(f.Close())
 }
 
-func s3DownloadWithChecksum(ctx context.Context, b Bucket, item BucketItem, local string) error {
+func s3DownloadWithChecksum(ctx context.Context, b Bucket, item BucketItem, local string, bufferSize int) error {
 	localmd5, err := utility.MD5SumFile(local)
 	if os.IsNotExist(errors.Cause(err)) {
-		if err = doDownload(ctx, b, item.Name(), local); err != nil {
+		if err = doDownload(ctx, b, item.Name(), local, bufferSize); err != nil {
 			return errors.WithStack(err)
 		}
 	} else if err != nil {
 		return errors.WithStack(err)
 	}
 	if localmd5 != item.Hash() {
-		if err = doDownload(ctx, b, item.Name(), local); err != nil {
+		if err = doDownload(ctx, b, item.Name(), local, bufferSize); err != nil {
 			return errors.WithStack(err)
 		}
 	}
@@ -883,10 +2078,10 @@ func (s *s3Bucket) downloadHelper(ctx context.Context, b Bucket, key, path strin
 		if !iter.Next(ctx) {
 			return errors.New("no results found")
 		}
-		return s3DownloadWithChecksum(ctx, b, iter.Item(), path)
+		return s3DownloadWithChecksum(ctx, b, iter.Item(), path, s.copyBufferSize)
 	}
 
-	return doDownload(ctx, b, key, path)
+	return doDownload(ctx, b, key, path, s.copyBufferSize)
 }
 
 func (s *s3BucketSmall) Download(ctx context.Context, key, path string) error {
@@ -923,26 +2118,48 @@ func (s *s3Bucket) pushHelper(ctx context.Context, b Bucket, opts SyncOptions) e
 		return errors.WithStack(err)
 	}
 
+	var included []string
+	var targets []string
 	for _, fn := range files {
 		if re != nil && re.MatchString(fn) {
 			continue
 		}
+		included = append(included, fn)
+		targets = append(targets, s.Join(opts.Remote, fn))
+	}
+	// Check every key before uploading anything, so that an invalid key
+	// does not leave the push partially complete.
+	if err = validateKeys(b, targets); err != nil {
+		return errors.Wrap(err, "validating keys to push")
+	}
 
-		target := s.Join(opts.Remote, fn)
+	var completed []string
+	for i, fn := range included {
+		if err = ctx.Err(); err != nil {
+			return partialSyncError(ctx, errors.WithStack(err), completed)
+		}
+
+		target := targets[i]
 		file := filepath.Join(opts.Local, fn)
 		shouldUpload, err := s.s3WithUploadChecksumHelper(ctx, target, file)
 		if err != nil {
-			return errors.WithStack(err)
-		}
-		if !shouldUpload {
-			continue
+			if skipVanishedFile(opts, file, err) {
+				continue
+			}
+			return partialSyncError(ctx, errors.WithStack(err), completed)
 		}
-		if err = doUpload(ctx, b, target, file); err != nil {
-			return errors.WithStack(err)
+		if shouldUpload {
+			if err = doUpload(ctx, b, target, file); err != nil {
+				if skipVanishedFile(opts, file, err) {
+					continue
+				}
+				return partialSyncError(ctx, errors.WithStack(err), completed)
+			}
 		}
+		completed = append(completed, target)
 	}
 
-	if s.deleteOnPush && !s.dryRun {
+	if (s.deleteOnPush || opts.Mirror) && !s.dryRun {
 		return errors.Wrap(deleteOnPush(ctx, files, opts.Remote, b), "deleting on sync after push")
 	}
 	return nil
@@ -975,16 +2192,20 @@ func (s *s3Bucket) pullHelper(ctx context.Context, b Bucket, opts SyncOptions) e
 		}
 	}
 
-	iter, err := b.List(ctx, opts.Remote)
+	iter, err := b.List(ctx, dirPrefix(opts.Remote))
 	if err != nil {
 		return errors.WithStack(err)
 	}
 
 	keys := []string{}
+	var completed []string
 	for iter.Next(ctx) {
 		if iter.Err() != nil {
 			return errors.Wrap(err, "iterating bucket")
 		}
+		if err = ctx.Err(); err != nil {
+			return partialSyncError(ctx, errors.WithStack(err), completed)
+		}
 
 		if re != nil && re.MatchString(iter.Item().Name()) {
 			continue
@@ -996,9 +2217,13 @@ func (s *s3Bucket) pullHelper(ctx context.Context, b Bucket, opts SyncOptions) e
 		}
 		keys = append(keys, localName)
 
-		if err := s3DownloadWithChecksum(ctx, b, iter.Item(), filepath.Join(opts.Local, localName)); err != nil {
-			return errors.WithStack(err)
+		if err := s3DownloadWithChecksum(ctx, b, iter.Item(), filepath.Join(opts.Local, localName), s.copyBufferSize); err != nil {
+			return partialSyncError(ctx, errors.WithStack(err), completed)
 		}
+		completed = append(completed, iter.Item().Name())
+	}
+	if err = iter.Err(); err != nil {
+		return partialSyncError(ctx, errors.Wrap(err, "iterating bucket"), completed)
 	}
 
 	if s.deleteOnPull && !s.dryRun {
@@ -1018,7 +2243,7 @@ func (s *s3BucketLarge) Pull(ctx context.Context, opts SyncOptions) error {
 func (s *s3Bucket) Copy(ctx context.Context, options CopyOptions) error {
 	if !options.IsDestination {
 		options.IsDestination = true
-		options.SourceKey = s.Join(s.name, s.normalizeKey(options.SourceKey))
+		options.SourceKey = copySource(s.name, s.normalizeKey(options.SourceKey))
 		return options.DestinationBucket.Copy(ctx, options)
 	}
 
@@ -1030,24 +2255,97 @@ func (s *s3Bucket) Copy(ctx context.Context, options CopyOptions) error {
 		"bucket_prefix": s.prefix,
 		"source_key":    options.SourceKey,
 		"dest_key":      options.DestinationKey,
+		"if_not_exists": options.IfNotExists,
 	})
 
+	if err := s.validateKey(options.DestinationKey); err != nil {
+		return errors.WithStack(err)
+	}
+
 	input := &s3.CopyObjectInput{
 		Bucket:     aws.String(s.name),
-		CopySource: aws.String(options.SourceKey),
+		CopySource: aws.String(escapeCopySource(options.SourceKey)),
 		Key:        aws.String(s.normalizeKey(options.DestinationKey)),
 		ACL:        s3Types.ObjectCannedACL(string(s.permissions)),
 	}
+	s.encryption.applyToCopy(input)
+
+	var optFns []func(*s3.Options)
+	if options.IfNotExists {
+		// The SDK does not model the destination precondition on
+		// CopyObject, so set the header directly.
+		optFns = append(optFns, func(o *s3.Options) {
+			o.APIOptions = append(o.APIOptions, smithyhttp.SetHeaderValue("If-None-Match", "*"))
+		})
+	}
 
 	if !s.dryRun {
-		_, err := s.svc.CopyObject(ctx, input)
+		_, err := s.svc.CopyObject(ctx, input, optFns...)
+		if input.ACL != "" && isACLNotSupportedError(err) {
+			// The destination bucket enforces bucket owner object
+			// ownership, so the copy is owned by the bucket owner
+			// without an ACL.
+			grip.Debug(message.Fields{
+				"message":  "destination bucket does not support ACLs, copying without ACL",
+				"bucket":   s.name,
+				"dest_key": options.DestinationKey,
+				"acl":      input.ACL,
+			})
+			withoutACL := *input
+			withoutACL.ACL = ""
+			_, err = s.svc.CopyObject(ctx, &withoutACL, optFns...)
+		}
 		if err != nil {
+			var apiErr smithy.APIError
+			if options.IfNotExists && errors.As(err, &apiErr) && apiErr.ErrorCode() == "PreconditionFailed" {
+				return errors.Wrapf(ErrAlreadyExists, "copying to key '%s': %s", options.DestinationKey, err)
+			}
 			return errors.Wrap(err, "copying data")
 		}
 	}
 	return nil
 }
 
+// isACLNotSupportedError returns whether the error indicates that the bucket
+// does not allow ACLs to be set on its objects.
+func isACLNotSupportedError(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "AccessControlListNotSupported"
+}
+
+func (s *s3Bucket) Touch(ctx context.Context, key string) error {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"dry_run":       s.dryRun,
+		"operation":     "touch",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+		"key":           key,
+	})
+
+	head, err := s.svc.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.name),
+		Key:    aws.String(s.normalizeKey(key)),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotFound" {
+			return MakeKeyNotFoundError(err)
+		}
+		return errors.Wrap(err, "getting S3 head object")
+	}
+	if s.dryRun {
+		return nil
+	}
+
+	// The object is copied onto itself with its current metadata and
+	// headers, so only its modification time changes.
+	input := s.copyInPlaceInput(s.normalizeKey(key), head)
+	s.encryption.orObject(head).applyToCopy(input)
+	err = s.copyInPlace(ctx, input, aws.ToInt64(head.ContentLength))
+	return errors.Wrapf(err, "touching key '%s'", key)
+}
+
 func (s *s3Bucket) Remove(ctx context.Context, key string) error {
 	grip.DebugWhen(s.verbose, message.Fields{
 		"type":          "s3",
@@ -1066,26 +2364,85 @@ func (s *s3Bucket) Remove(ctx context.Context, key string) error {
 
 		_, err := s.svc.DeleteObject(ctx, input)
 		if err != nil {
+			if isObjectLockError(err) {
+				return errors.Wrapf(ErrObjectLocked, "removing key '%s': %s", key, err)
+			}
 			return errors.Wrap(err, "removing data")
 		}
 	}
 	return nil
 }
 
-func (s *s3Bucket) deleteObjectsWrapper(ctx context.Context, toDelete *s3Types.Delete) error {
-	if len(toDelete.Objects) > 0 {
-		input := &s3.DeleteObjectsInput{
-			Bucket: aws.String(s.name),
-			Delete: toDelete,
+func (s *s3Bucket) RemoveIfMatch(ctx context.Context, key, expectedETag string) error {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"dry_run":       s.dryRun,
+		"operation":     "remove if match",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+		"key":           key,
+		"etag":          expectedETag,
+	})
+
+	if expectedETag == "" {
+		return errors.New("must specify the expected ETag")
+	}
+	if s.dryRun {
+		return nil
+	}
+
+	input := &s3.DeleteObjectInput{
+		Bucket: aws.String(s.name),
+		Key:    aws.String(s.normalizeKey(key)),
+	}
+	// The SDK does not model the precondition on DeleteObject, so set the
+	// header directly. S3 expects the ETag to be quoted.
+	etag := `"` + strings.Trim(expectedETag, `"`) + `"`
+	_, err := s.svc.DeleteObject(ctx, input, func(o *s3.Options) {
+		o.APIOptions = append(o.APIOptions, smithyhttp.SetHeaderValue("If-Match", etag))
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			switch apiErr.ErrorCode() {
+			case "PreconditionFailed":
+				return errors.Wrapf(ErrPreconditionFailed, "removing key '%s': %s", key, err)
+			case "NoSuchKey", "NotFound":
+				return MakeKeyNotFoundError(err)
+			}
 		}
-		_, err := s.svc.DeleteObjects(ctx, input)
-		if err != nil {
-			return errors.Wrap(err, "removing data")
+		if isObjectLockError(err) {
+			return errors.Wrapf(ErrObjectLocked, "removing key '%s': %s", key, err)
 		}
+		return errors.Wrap(err, "removing data")
 	}
 	return nil
 }
 
+// deleteObjectsWrapper deletes the given objects, returning an error for
+// each object that S3 failed to delete as well as for the request as a
+// whole.
+func (s *s3Bucket) deleteObjectsWrapper(ctx context.Context, toDelete *s3Types.Delete) error {
+	if len(toDelete.Objects) == 0 {
+		return nil
+	}
+
+	toDelete.Quiet = aws.Bool(true)
+	result, err := s.svc.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+		Bucket: aws.String(s.name),
+		Delete: toDelete,
+	})
+	if err != nil {
+		return errors.Wrap(err, "removing data")
+	}
+
+	catcher := grip.NewBasicCatcher()
+	for _, failed := range result.Errors {
+		catcher.Wrapf(deleteObjectsError(failed), "removing key '%s'", s.denormalizeKey(aws.ToString(failed.Key)))
+	}
+	return catcher.Resolve()
+}
+
 func (s *s3Bucket) RemoveMany(ctx context.Context, keys ...string) error {
 	grip.DebugWhen(s.verbose, message.Fields{
 		"type":          "s3",
@@ -1118,6 +2475,43 @@ func (s *s3Bucket) RemoveMany(ctx context.Context, keys ...string) error {
 	return catcher.Resolve()
 }
 
+// removePrefixHelper deletes the objects with the given prefix one page of
+// the listing at a time, in batches of at most the bucket's batch size, so
+// that the keys to delete are never all held in memory at once. Objects that
+// fail to be deleted do not stop the rest from being deleted.
+func (s *s3Bucket) removePrefixHelper(ctx context.Context, prefix string) error {
+	if s.dryRun {
+		return nil
+	}
+
+	catcher := grip.NewBasicCatcher()
+	marker := ""
+	for {
+		contents, isTruncated, err := getObjectsWrapper(ctx, s, s.normalizeListPrefix(prefix), marker)
+		if err != nil {
+			catcher.Wrapf(err, "listing objects with prefix '%s'", prefix)
+			return catcher.Resolve()
+		}
+
+		// The listed keys are deleted as they are stored, since they
+		// are already normalized.
+		toDelete := &s3Types.Delete{}
+		for _, obj := range contents {
+			if len(toDelete.Objects) == s.batchSize {
+				catcher.Wrapf(s.deleteObjectsWrapper(ctx, toDelete), "deleting objects with prefix '%s'", prefix)
+				toDelete = &s3Types.Delete{}
+			}
+			toDelete.Objects = append(toDelete.Objects, s3Types.ObjectIdentifier{Key: obj.Key})
+		}
+		catcher.Wrapf(s.deleteObjectsWrapper(ctx, toDelete), "deleting objects with prefix '%s'", prefix)
+
+		if !isTruncated || len(contents) == 0 {
+			return catcher.Resolve()
+		}
+		marker = aws.ToString(contents[len(contents)-1].Key)
+	}
+}
+
 func (s *s3BucketSmall) RemovePrefix(ctx context.Context, prefix string) error {
 	grip.DebugWhen(s.verbose, message.Fields{
 		"type":          "s3",
@@ -1128,7 +2522,7 @@ func (s *s3BucketSmall) RemovePrefix(ctx context.Context, prefix string) error {
 		"prefix":        prefix,
 	})
 
-	return removePrefix(ctx, prefix, s)
+	return s.removePrefixHelper(ctx, prefix)
 }
 
 func (s *s3BucketLarge) RemovePrefix(ctx context.Context, prefix string) error {
@@ -1141,7 +2535,7 @@ func (s *s3BucketLarge) RemovePrefix(ctx context.Context, prefix string) error {
 		"prefix":        prefix,
 	})
 
-	return removePrefix(ctx, prefix, s)
+	return s.removePrefixHelper(ctx, prefix)
 }
 
 func (s *s3BucketSmall) RemoveMatching(ctx context.Context, expression string) error {
@@ -1171,15 +2565,22 @@ func (s *s3BucketLarge) RemoveMatching(ctx context.Context, expression string) e
 }
 
 func (s *s3Bucket) listHelper(ctx context.Context, b Bucket, prefix string) (BucketIterator, error) {
+	return s.listAfterHelper(ctx, b, prefix, "")
+}
+
+// listAfterHelper lists the objects with the given prefix whose keys sort
+// after the given marker. Both are normalized keys.
+func (s *s3Bucket) listAfterHelper(ctx context.Context, b Bucket, prefix, marker string) (BucketIterator, error) {
 	grip.DebugWhen(s.verbose, message.Fields{
 		"type":          "s3",
 		"operation":     "list",
 		"bucket":        s.name,
 		"bucket_prefix": s.prefix,
 		"prefix":        prefix,
+		"marker":        marker,
 	})
 
-	contents, isTruncated, err := getObjectsWrapper(ctx, s, prefix, "")
+	contents, isTruncated, err := getObjectsWrapper(ctx, s, prefix, marker)
 	if err != nil {
 		return nil, err
 	}
@@ -1194,11 +2595,29 @@ func (s *s3Bucket) listHelper(ctx context.Context, b Bucket, prefix string) (Buc
 }
 
 func (s *s3BucketSmall) List(ctx context.Context, prefix string) (BucketIterator, error) {
-	return s.listHelper(ctx, s, s.normalizeKey(prefix))
+	return s.listHelper(ctx, s, s.normalizeListPrefix(prefix))
 }
 
 func (s *s3BucketLarge) List(ctx context.Context, prefix string) (BucketIterator, error) {
-	return s.listHelper(ctx, s, s.normalizeKey(prefix))
+	return s.listHelper(ctx, s, s.normalizeListPrefix(prefix))
+}
+
+func (s *s3BucketSmall) ListWithOptions(ctx context.Context, prefix string, opts ListOptions) (BucketIterator, error) {
+	return s.listAfterHelper(ctx, s, s.normalizeListPrefix(prefix), s.listMarker(opts))
+}
+
+func (s *s3BucketLarge) ListWithOptions(ctx context.Context, prefix string, opts ListOptions) (BucketIterator, error) {
+	return s.listAfterHelper(ctx, s, s.normalizeListPrefix(prefix), s.listMarker(opts))
+}
+
+// listMarker returns the marker that starts a listing after the key given in
+// the options. S3 lists the keys after the marker, so it serves the same
+// purpose as StartAfter in version 2 of the listing API.
+func (s *s3Bucket) listMarker(opts ListOptions) string {
+	if opts.StartAfter == "" {
+		return ""
+	}
+	return s.normalizeKey(opts.StartAfter)
 }
 
 func getObjectsWrapper(ctx context.Context, s *s3Bucket, prefix, marker string) ([]s3Types.Object, bool, error) {
@@ -1252,15 +2671,22 @@ func (iter *s3BucketIterator) Next(ctx context.Context) bool {
 		}
 	}
 
-	iter.item = &bucketItemImpl{
-		bucket: iter.s.name,
-		key:    iter.s.denormalizeKey(*iter.contents[iter.idx].Key),
-		hash:   strings.Trim(*iter.contents[iter.idx].ETag, `"`),
-		b:      iter.b,
-	}
+	iter.item = iter.s.newBucketItem(iter.b, iter.contents[iter.idx])
 	return true
 }
 
+// newBucketItem returns the item of the given bucket for the listed object.
+func (s *s3Bucket) newBucketItem(b Bucket, obj s3Types.Object) *bucketItemImpl {
+	return &bucketItemImpl{
+		bucket:       s.name,
+		key:          s.denormalizeKey(aws.ToString(obj.Key)),
+		hash:         strings.Trim(aws.ToString(obj.ETag), `"`),
+		lastModified: aws.ToTime(obj.LastModified),
+		size:         aws.ToInt64(obj.Size),
+		b:            b,
+	}
+}
+
 type s3ArchiveBucket struct {
 	*s3BucketLarge
 }
@@ -1329,29 +2755,82 @@ func (s *s3ArchiveBucket) Push(ctx context.Context, opts SyncOptions) error {
 
 	target := s.Join(opts.Remote, syncArchiveName)
 
-	s3Writer, err := s.Writer(ctx, target)
+	// The writer is closed exactly once: on success, to upload the
+	// archive, and otherwise after canceling its context, so that a
+	// partial archive is discarded rather than uploaded.
+	writerCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	s3Writer, err := s.Writer(writerCtx, target)
 	if err != nil {
 		return errors.Wrap(err, "creating writer")
 	}
-	defer s3Writer.Close()
+	abort := func() {
+		cancel()
+		_ = s3Writer.Close()
+	}
 
 	tarWriter := tar.NewWriter(s3Writer)
-	defer tarWriter.Close()
 
+	var matched []string
 	for _, fn := range files {
 		if re != nil && re.MatchString(fn) {
 			continue
 		}
+		matched = append(matched, fn)
+	}
 
-		file := filepath.Join(opts.Local, fn)
-		// We can't compare the checksum without processing all the
-		// local matched files as a tar stream, so just upload it
-		// unconditionally.
-		if err := tarFile(tarWriter, opts.Local, fn); err != nil {
-			return errors.Wrap(err, file)
+	// We can't compare the checksum without processing all the local
+	// matched files as a tar stream, so just upload it unconditionally.
+	if err = tarFiles(ctx, tarWriter, opts.Local, matched, s.archiveWorkers); err != nil {
+		abort()
+		return errors.WithStack(err)
+	}
+	entries := len(matched)
+
+	if err = tarWriter.Close(); err != nil {
+		abort()
+		return errors.Wrap(err, "closing archive")
+	}
+	if err = s3Writer.Close(); err != nil {
+		return errors.Wrap(err, "uploading archive")
+	}
+
+	if !opts.VerifyArchive || s.dryRun {
+		return nil
+	}
+	return errors.Wrapf(s.verifyArchive(ctx, target, entries), "verifying archive uploaded to remote path '%s'", opts.Remote)
+}
+
+// verifyArchive reads back the archive at key and checks that it is a
+// well-formed tar archive with the expected number of entries.
+func (s *s3ArchiveBucket) verifyArchive(ctx context.Context, key string, expectedEntries int) error {
+	reader, err := s.Get(ctx, key)
+	if err != nil {
+		return errors.Wrapf(err, "getting archive '%s'", key)
+	}
+	defer reader.Close()
+
+	var entries int
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return errors.Wrapf(err, "reading archive '%s'", key)
+		}
+		// Read each entry's contents so that a truncated entry is
+		// detected.
+		if _, err = io.Copy(io.Discard, tarReader); err != nil {
+			return errors.Wrapf(err, "reading archive entry '%s'", header.Name)
 		}
+		entries++
 	}
 
+	if entries != expectedEntries {
+		return errors.Errorf("archive '%s' has %d entries, but expected %d", key, entries, expectedEntries)
+	}
 	return nil
 }
 
@@ -1392,6 +2871,119 @@ func (s *s3ArchiveBucket) Pull(ctx context.Context, opts SyncOptions) error {
 	return nil
 }
 
+// ArchiveAppender is implemented by archive buckets that can add entries to
+// an existing archive.
+type ArchiveAppender interface {
+	ArchiveAppend(ctx context.Context, archiveKey, innerPath string, r io.Reader) error
+}
+
+// ArchiveAppend adds a regular file entry named innerPath, with the contents
+// of r, to the tar archive at archiveKey, creating the archive if it does not
+// exist. Existing entries are preserved, except for any entry that is already
+// named innerPath, which is replaced.
+//
+// S3 objects cannot be modified in place, so every append downloads and
+// rewrites the entire archive: each call transfers the full archive twice and
+// spools it to a local temporary file. Appends to the same archive are not
+// safe to run concurrently.
+func (s *s3ArchiveBucket) ArchiveAppend(ctx context.Context, archiveKey, innerPath string, r io.Reader) error {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"dry_run":       s.dryRun,
+		"operation":     "archive append",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+		"key":           archiveKey,
+		"inner_path":    innerPath,
+	})
+
+	entry, err := os.CreateTemp("", "pail-archive-entry")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary file for archive entry")
+	}
+	defer func() {
+		_ = entry.Close()
+		_ = os.Remove(entry.Name())
+	}()
+	size, err := io.Copy(entry, r)
+	if err != nil {
+		return errors.Wrap(err, "reading archive entry")
+	}
+	if _, err = entry.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "rewinding archive entry")
+	}
+
+	archive, err := os.CreateTemp("", "pail-archive")
+	if err != nil {
+		return errors.Wrap(err, "creating temporary file for archive")
+	}
+	defer func() {
+		_ = archive.Close()
+		_ = os.Remove(archive.Name())
+	}()
+
+	tarWriter := tar.NewWriter(archive)
+	if err = s.copyArchiveEntries(ctx, tarWriter, archiveKey, innerPath); err != nil {
+		return errors.WithStack(err)
+	}
+	header := &tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     innerPath,
+		Mode:     0644,
+		Size:     size,
+		ModTime:  time.Now(),
+	}
+	if err = tarWriter.WriteHeader(header); err != nil {
+		return errors.Wrapf(err, "writing header for archive entry '%s'", innerPath)
+	}
+	if _, err = io.Copy(tarWriter, entry); err != nil {
+		return errors.Wrapf(err, "writing archive entry '%s'", innerPath)
+	}
+	if err = tarWriter.Close(); err != nil {
+		return errors.Wrap(err, "closing archive")
+	}
+	if _, err = archive.Seek(0, io.SeekStart); err != nil {
+		return errors.Wrap(err, "rewinding archive")
+	}
+
+	return errors.Wrapf(s.Put(ctx, archiveKey, archive), "uploading archive '%s'", archiveKey)
+}
+
+// copyArchiveEntries copies every entry of the existing archive at archiveKey,
+// except for entries named skipPath, into tarWriter. A missing archive has no
+// entries.
+func (s *s3ArchiveBucket) copyArchiveEntries(ctx context.Context, tarWriter *tar.Writer, archiveKey, skipPath string) error {
+	reader, err := s.Get(ctx, archiveKey)
+	if err != nil {
+		if IsKeyNotFoundError(err) {
+			return nil
+		}
+		return errors.Wrapf(err, "getting archive '%s'", archiveKey)
+	}
+	defer reader.Close()
+
+	tarReader := tar.NewReader(reader)
+	for {
+		header, err := tarReader.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return errors.Wrapf(err, "reading archive '%s'", archiveKey)
+		}
+		if header.Name == skipPath {
+			continue
+		}
+
+		if err = tarWriter.WriteHeader(header); err != nil {
+			return errors.Wrapf(err, "writing header for archive entry '%s'", header.Name)
+		}
+		if _, err = io.Copy(tarWriter, tarReader); err != nil {
+			return errors.Wrapf(err, "copying archive entry '%s'", header.Name)
+		}
+	}
+}
+
 // PresignExpireTime sets the amount of time the link is live before expiring.
 const PresignExpireTime = 24 * time.Hour
 
@@ -1407,10 +2999,7 @@ type PreSignRequestParams struct {
 }
 
 func (p *PreSignRequestParams) getS3Client(ctx context.Context) (*s3.Client, error) {
-	region := p.Region
-	if region == "" {
-		region = "us-east-1"
-	}
+	region := normalizeRegion(p.Region)
 
 	cfgOpts := configOpts{
 		region: region,
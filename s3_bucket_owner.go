@@ -0,0 +1,138 @@
+package pail
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// expectedOwnerClient wraps an S3 client so that every request is sent with
+// the expected bucket owner, causing S3 to reject it with an AccessDenied
+// error if the bucket is owned by a different account. This prevents
+// operations on a bucket that was deleted and re-created with the same name
+// by another account.
+type expectedOwnerClient struct {
+	s3Client
+	owner string
+}
+
+func (c *expectedOwnerClient) HeadBucket(ctx context.Context, input *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	input.ExpectedBucketOwner = aws.String(c.owner)
+	return c.s3Client.HeadBucket(ctx, input, optFns...)
+}
+
+func (c *expectedOwnerClient) GetBucketLocation(ctx context.Context, input *s3.GetBucketLocationInput, optFns ...func(*s3.Options)) (*s3.GetBucketLocationOutput, error) {
+	input.ExpectedBucketOwner = aws.String(c.owner)
+	return c.s3Client.GetBucketLocation(ctx, input, optFns...)
+}
+
+func (c *expectedOwnerClient) HeadObject(ctx context.Context, input *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	input.ExpectedBucketOwner = aws.String(c.owner)
+	return c.s3Client.HeadObject(ctx, input, optFns...)
+}
+
+func (c *expectedOwnerClient) GetObject(ctx context.Context, input *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	input.ExpectedBucketOwner = aws.String(c.owner)
+	return c.s3Client.GetObject(ctx, input, optFns...)
+}
+
+func (c *expectedOwnerClient) GetObjectAcl(ctx context.Context, input *s3.GetObjectAclInput, optFns ...func(*s3.Options)) (*s3.GetObjectAclOutput, error) {
+	input.ExpectedBucketOwner = aws.String(c.owner)
+	return c.s3Client.GetObjectAcl(ctx, input, optFns...)
+}
+
+func (c *expectedOwnerClient) GetObjectAttributes(ctx context.Context, input *s3.GetObjectAttributesInput, optFns ...func(*s3.Options)) (*s3.GetObjectAttributesOutput, error) {
+	input.ExpectedBucketOwner = aws.String(c.owner)
+	return c.s3Client.GetObjectAttributes(ctx, input, optFns...)
+}
+
+func (c *expectedOwnerClient) PutObject(ctx context.Context, input *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	input.ExpectedBucketOwner = aws.String(c.owner)
+	return c.s3Client.PutObject(ctx, input, optFns...)
+}
+
+func (c *expectedOwnerClient) CopyObject(ctx context.Context, input *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	input.ExpectedBucketOwner = aws.String(c.owner)
+	return c.s3Client.CopyObject(ctx, input, optFns...)
+}
+
+func (c *expectedOwnerClient) GetObjectTagging(ctx context.Context, input *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+	input.ExpectedBucketOwner = aws.String(c.owner)
+	return c.s3Client.GetObjectTagging(ctx, input, optFns...)
+}
+
+func (c *expectedOwnerClient) DeleteObject(ctx context.Context, input *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	input.ExpectedBucketOwner = aws.String(c.owner)
+	return c.s3Client.DeleteObject(ctx, input, optFns...)
+}
+
+func (c *expectedOwnerClient) DeleteObjects(ctx context.Context, input *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	input.ExpectedBucketOwner = aws.String(c.owner)
+	return c.s3Client.DeleteObjects(ctx, input, optFns...)
+}
+
+func (c *expectedOwnerClient) ListObjects(ctx context.Context, input *s3.ListObjectsInput, optFns ...func(*s3.Options)) (*s3.ListObjectsOutput, error) {
+	input.ExpectedBucketOwner = aws.String(c.owner)
+	return c.s3Client.ListObjects(ctx, input, optFns...)
+}
+
+func (c *expectedOwnerClient) GetObjectLegalHold(ctx context.Context, input *s3.GetObjectLegalHoldInput, optFns ...func(*s3.Options)) (*s3.GetObjectLegalHoldOutput, error) {
+	input.ExpectedBucketOwner = aws.String(c.owner)
+	return c.s3Client.GetObjectLegalHold(ctx, input, optFns...)
+}
+
+func (c *expectedOwnerClient) GetObjectRetention(ctx context.Context, input *s3.GetObjectRetentionInput, optFns ...func(*s3.Options)) (*s3.GetObjectRetentionOutput, error) {
+	input.ExpectedBucketOwner = aws.String(c.owner)
+	return c.s3Client.GetObjectRetention(ctx, input, optFns...)
+}
+
+func (c *expectedOwnerClient) GetObjectLockConfiguration(ctx context.Context, input *s3.GetObjectLockConfigurationInput, optFns ...func(*s3.Options)) (*s3.GetObjectLockConfigurationOutput, error) {
+	input.ExpectedBucketOwner = aws.String(c.owner)
+	return c.s3Client.GetObjectLockConfiguration(ctx, input, optFns...)
+}
+
+func (c *expectedOwnerClient) GetBucketLifecycleConfiguration(ctx context.Context, input *s3.GetBucketLifecycleConfigurationInput, optFns ...func(*s3.Options)) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+	input.ExpectedBucketOwner = aws.String(c.owner)
+	return c.s3Client.GetBucketLifecycleConfiguration(ctx, input, optFns...)
+}
+
+func (c *expectedOwnerClient) PutBucketLifecycleConfiguration(ctx context.Context, input *s3.PutBucketLifecycleConfigurationInput, optFns ...func(*s3.Options)) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+	input.ExpectedBucketOwner = aws.String(c.owner)
+	return c.s3Client.PutBucketLifecycleConfiguration(ctx, input, optFns...)
+}
+
+func (c *expectedOwnerClient) ListObjectVersions(ctx context.Context, input *s3.ListObjectVersionsInput, optFns ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error) {
+	input.ExpectedBucketOwner = aws.String(c.owner)
+	return c.s3Client.ListObjectVersions(ctx, input, optFns...)
+}
+
+func (c *expectedOwnerClient) ListMultipartUploads(ctx context.Context, input *s3.ListMultipartUploadsInput, optFns ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error) {
+	input.ExpectedBucketOwner = aws.String(c.owner)
+	return c.s3Client.ListMultipartUploads(ctx, input, optFns...)
+}
+
+func (c *expectedOwnerClient) CreateMultipartUpload(ctx context.Context, input *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	input.ExpectedBucketOwner = aws.String(c.owner)
+	return c.s3Client.CreateMultipartUpload(ctx, input, optFns...)
+}
+
+func (c *expectedOwnerClient) UploadPart(ctx context.Context, input *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	input.ExpectedBucketOwner = aws.String(c.owner)
+	return c.s3Client.UploadPart(ctx, input, optFns...)
+}
+
+func (c *expectedOwnerClient) UploadPartCopy(ctx context.Context, input *s3.UploadPartCopyInput, optFns ...func(*s3.Options)) (*s3.UploadPartCopyOutput, error) {
+	input.ExpectedBucketOwner = aws.String(c.owner)
+	return c.s3Client.UploadPartCopy(ctx, input, optFns...)
+}
+
+func (c *expectedOwnerClient) CompleteMultipartUpload(ctx context.Context, input *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	input.ExpectedBucketOwner = aws.String(c.owner)
+	return c.s3Client.CompleteMultipartUpload(ctx, input, optFns...)
+}
+
+func (c *expectedOwnerClient) AbortMultipartUpload(ctx context.Context, input *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	input.ExpectedBucketOwner = aws.String(c.owner)
+	return c.s3Client.AbortMultipartUpload(ctx, input, optFns...)
+}
@@ -0,0 +1,5119 @@
+package pail
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/aws-sdk-go-v2/service/s3control"
+	s3ControlTypes "github.com/aws/aws-sdk-go-v2/service/s3control/types"
+	"github.com/aws/smithy-go"
+	"github.com/aws/smithy-go/middleware"
+	smithyhttp "github.com/aws/smithy-go/transport/http"
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockS3Object is an object stored in the mock S3 client.
+type mockS3Object struct {
+	data            []byte
+	etag            string
+	contentType     string
+	contentEncoding string
+	// cacheControl, contentDisposition, contentLanguage and expires are
+	// the object's other standard HTTP headers.
+	cacheControl       string
+	contentDisposition string
+	contentLanguage    string
+	expires            *time.Time
+	storageClass       s3Types.StorageClass
+	restore            string
+	lastModified       time.Time
+	metadata           map[string]string
+	tagging            string
+	expiration         string
+	legalHold          bool
+	retention          *s3Types.ObjectLockRetention
+	checksumSHA256     string
+	// checksumCRC32C is the CRC32C checksum of the data the object was
+	// uploaded with, if it was uploaded with the CRC32C algorithm.
+	checksumCRC32C string
+	// replicationStatus is the object's status in a bucket with
+	// replication configured.
+	replicationStatus s3Types.ReplicationStatus
+	// serverSideEncryption and sseKMSKeyID are the object's encryption,
+	// which are empty if it has the bucket's default encryption.
+	serverSideEncryption s3Types.ServerSideEncryption
+	sseKMSKeyID          string
+}
+
+// mockS3Client is an in-memory implementation of the S3 API used by the S3
+// buckets, for testing without access to S3.
+type mockS3Client struct {
+	mu            sync.Mutex
+	objects       map[string]*mockS3Object
+	deleteMarkers map[string][]string
+	// noncurrentVersions are the IDs of the noncurrent versions of each
+	// key, and lockedVersions are the IDs of the versions that are
+	// protected by Object Lock.
+	noncurrentVersions map[string][]string
+	lockedVersions     map[string]bool
+	headBucketErr      error
+	uploads            map[string]map[int32][]byte
+	uploadKeys         map[string]string
+	uploadObjects      map[string]mockS3Object
+	uploadCount        int
+	listPageSize       int
+	putObjectCalls     []*s3.PutObjectInput
+	copyCalls          []*s3.CopyObjectInput
+	deleteObjectsCalls []*s3.DeleteObjectsInput
+	// uploadPartCopyCalls are the parts copied into multipart uploads.
+	uploadPartCopyCalls []*s3.UploadPartCopyInput
+	objectLock          *s3Types.ObjectLockConfiguration
+	headObjectErrs      map[string]error
+	location            s3Types.BucketLocationConstraint
+	lifecycle           []s3Types.LifecycleRule
+	// owner, if set, is the account that owns the bucket, which requests
+	// with a different expected bucket owner are rejected for.
+	owner string
+	// corrupt, if set, modifies the data of each object as it is stored,
+	// to simulate a corrupted upload.
+	corrupt func(key string, data []byte) []byte
+	// getAttributesCalls is the number of GetObjectAttributes requests.
+	getAttributesCalls int
+	// getObjectLockCalls is the number of GetObjectLockConfiguration
+	// requests.
+	getObjectLockCalls int
+	// aclsDisabled rejects requests that set ACLs, like a bucket whose
+	// Object Ownership setting is bucket owner enforced.
+	aclsDisabled bool
+	// uploadETag, if set, replaces the ETag returned for each completed
+	// upload, to simulate an object stored differently than uploaded.
+	uploadETag func(key, etag string) string
+	// kmsAliases are the ARNs of the KMS keys that each alias names, which
+	// objects copied with an alias are reported as encrypted with.
+	kmsAliases map[string]string
+	// listObjectsErrs are the errors returned by listings of each prefix.
+	listObjectsErrs map[string]error
+	// uploadPartHook, if set, is called as each part is uploaded.
+	uploadPartHook func()
+	// putObjectHook, if set, is called as each object is put.
+	putObjectHook func()
+}
+
+func newMockS3Client() *mockS3Client {
+	return &mockS3Client{
+		objects:            map[string]*mockS3Object{},
+		deleteMarkers:      map[string][]string{},
+		noncurrentVersions: map[string][]string{},
+		lockedVersions:     map[string]bool{},
+		uploads:            map[string]map[int32][]byte{},
+		uploadKeys:         map[string]string{},
+		uploadObjects:      map[string]mockS3Object{},
+		listPageSize:       1000,
+	}
+}
+
+func newMockS3Bucket(client *mockS3Client, prefix string) *s3Bucket {
+	return &s3Bucket{
+		name:      "bucket",
+		prefix:    prefix,
+		svc:       client,
+		batchSize: 1000,
+	}
+}
+
+func mockS3APIError(code string) error {
+	return &smithy.GenericAPIError{Code: code, Message: code}
+}
+
+// checkOwner returns the error that S3 returns when the expected bucket
+// owner of a request does not own the bucket.
+func (c *mockS3Client) checkOwner(expected *string) error {
+	if expected != nil && c.owner != "" && aws.ToString(expected) != c.owner {
+		return mockS3APIError("AccessDenied")
+	}
+	return nil
+}
+
+// mockRequestHeaders returns the HTTP headers that the API options set by the
+// given per-operation options would add to a request.
+func mockRequestHeaders(ctx context.Context, optFns ...func(*s3.Options)) (http.Header, error) {
+	var opts s3.Options
+	for _, fn := range optFns {
+		fn(&opts)
+	}
+
+	stack := middleware.NewStack("mock", smithyhttp.NewStackRequest)
+	for _, fn := range opts.APIOptions {
+		if err := fn(stack); err != nil {
+			return nil, err
+		}
+	}
+
+	var headers http.Header
+	handler := middleware.DecorateHandler(middleware.HandlerFunc(func(_ context.Context, in interface{}) (interface{}, middleware.Metadata, error) {
+		headers = in.(*smithyhttp.Request).Header
+		return nil, middleware.Metadata{}, nil
+	}), stack)
+	if _, _, err := handler.Handle(ctx, nil); err != nil {
+		return nil, err
+	}
+
+	return headers, nil
+}
+
+func mockObjectLockRetention(mode s3Types.ObjectLockMode, retainUntil *time.Time) *s3Types.ObjectLockRetention {
+	if mode == "" {
+		return nil
+	}
+	return &s3Types.ObjectLockRetention{Mode: s3Types.ObjectLockRetentionMode(mode), RetainUntilDate: retainUntil}
+}
+
+func mockETag(data []byte) string {
+	sum := md5.Sum(data)
+	return fmt.Sprintf(`"%s"`, hex.EncodeToString(sum[:]))
+}
+
+func (c *mockS3Client) putObject(key string, data []byte, obj mockS3Object) *mockS3Object {
+	if c.corrupt != nil {
+		data = c.corrupt(key, data)
+	}
+	obj.data = data
+	obj.etag = mockETag(data)
+	obj.lastModified = time.Now()
+	if obj.storageClass == "" {
+		obj.storageClass = s3Types.StorageClassStandard
+	}
+	c.objects[key] = &obj
+	return &obj
+}
+
+func (c *mockS3Client) HeadBucket(context.Context, *s3.HeadBucketInput, ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	if c.headBucketErr != nil {
+		return nil, c.headBucketErr
+	}
+	return &s3.HeadBucketOutput{}, nil
+}
+
+func (c *mockS3Client) GetBucketLocation(context.Context, *s3.GetBucketLocationInput, ...func(*s3.Options)) (*s3.GetBucketLocationOutput, error) {
+	return &s3.GetBucketLocationOutput{LocationConstraint: c.location}, nil
+}
+
+func (c *mockS3Client) HeadObject(_ context.Context, input *s3.HeadObjectInput, _ ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.checkOwner(input.ExpectedBucketOwner); err != nil {
+		return nil, err
+	}
+
+	if err := c.headObjectErrs[aws.ToString(input.Key)]; err != nil {
+		return nil, err
+	}
+	obj, ok := c.objects[aws.ToString(input.Key)]
+	if !ok {
+		return nil, mockS3APIError("NotFound")
+	}
+	if input.IfMatch != nil && aws.ToString(input.IfMatch) != strings.Trim(obj.etag, `"`) {
+		return nil, mockS3APIError("PreconditionFailed")
+	}
+
+	return &s3.HeadObjectOutput{
+		ContentLength:        aws.Int64(int64(len(obj.data))),
+		ContentType:          aws.String(obj.contentType),
+		ContentEncoding:      aws.String(obj.contentEncoding),
+		CacheControl:         aws.String(obj.cacheControl),
+		ContentDisposition:   aws.String(obj.contentDisposition),
+		ContentLanguage:      aws.String(obj.contentLanguage),
+		Expires:              obj.expires,
+		ETag:                 aws.String(obj.etag),
+		LastModified:         aws.Time(obj.lastModified),
+		StorageClass:         obj.storageClass,
+		Restore:              aws.String(obj.restore),
+		Metadata:             obj.metadata,
+		ReplicationStatus:    obj.replicationStatus,
+		ServerSideEncryption: obj.serverSideEncryption,
+		SSEKMSKeyId:          aws.String(obj.sseKMSKeyID),
+	}, nil
+}
+
+func (c *mockS3Client) GetObject(_ context.Context, input *s3.GetObjectInput, _ ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.checkOwner(input.ExpectedBucketOwner); err != nil {
+		return nil, err
+	}
+
+	obj, ok := c.objects[aws.ToString(input.Key)]
+	if !ok {
+		return nil, mockS3APIError("NoSuchKey")
+	}
+	if input.IfMatch != nil && strings.Trim(aws.ToString(input.IfMatch), `"`) != strings.Trim(obj.etag, `"`) {
+		return nil, mockS3APIError("PreconditionFailed")
+	}
+	data := obj.data
+	if input.Range != nil {
+		first, last, _ := strings.Cut(strings.TrimPrefix(aws.ToString(input.Range), "bytes="), "-")
+		start, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, mockS3APIError("InvalidRange")
+		}
+		end := len(data) - 1
+		if last != "" {
+			if end, err = strconv.Atoi(last); err != nil {
+				return nil, mockS3APIError("InvalidRange")
+			}
+		}
+		if start >= len(data) {
+			return nil, mockS3APIError("InvalidRange")
+		}
+		if end >= len(data) {
+			end = len(data) - 1
+		}
+		data = data[start : end+1]
+	}
+
+	out := &s3.GetObjectOutput{
+		Body:            io.NopCloser(bytes.NewReader(data)),
+		Expiration:      aws.String(obj.expiration),
+		ContentLength:   aws.Int64(int64(len(data))),
+		ContentType:     aws.String(obj.contentType),
+		ContentEncoding: aws.String(obj.contentEncoding),
+		ETag:            aws.String(obj.etag),
+		LastModified:    aws.Time(obj.lastModified),
+		StorageClass:    obj.storageClass,
+		Metadata:        obj.metadata,
+	}
+	if input.ChecksumMode == s3Types.ChecksumModeEnabled && input.Range == nil && obj.checksumCRC32C != "" {
+		out.ChecksumCRC32C = aws.String(obj.checksumCRC32C)
+	}
+	return out, nil
+}
+
+func (c *mockS3Client) GetObjectTagging(_ context.Context, input *s3.GetObjectTaggingInput, _ ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	obj, ok := c.objects[aws.ToString(input.Key)]
+	if !ok {
+		return nil, mockS3APIError("NoSuchKey")
+	}
+	values, err := url.ParseQuery(obj.tagging)
+	if err != nil {
+		return nil, mockS3APIError("InvalidTag")
+	}
+	output := &s3.GetObjectTaggingOutput{}
+	for k := range values {
+		output.TagSet = append(output.TagSet, s3Types.Tag{Key: aws.String(k), Value: aws.String(values.Get(k))})
+	}
+
+	return output, nil
+}
+
+func (c *mockS3Client) GetObjectAcl(context.Context, *s3.GetObjectAclInput, ...func(*s3.Options)) (*s3.GetObjectAclOutput, error) {
+	return &s3.GetObjectAclOutput{}, nil
+}
+
+func (c *mockS3Client) GetObjectAttributes(_ context.Context, input *s3.GetObjectAttributesInput, _ ...func(*s3.Options)) (*s3.GetObjectAttributesOutput, error) {
+	if err := c.checkOwner(input.ExpectedBucketOwner); err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.getAttributesCalls++
+	obj, ok := c.objects[aws.ToString(input.Key)]
+	if !ok {
+		return nil, mockS3APIError("NoSuchKey")
+	}
+
+	out := &s3.GetObjectAttributesOutput{LastModified: aws.Time(obj.lastModified)}
+	for _, attr := range input.ObjectAttributes {
+		switch attr {
+		case s3Types.ObjectAttributesEtag:
+			out.ETag = aws.String(strings.Trim(obj.etag, `"`))
+		case s3Types.ObjectAttributesObjectSize:
+			out.ObjectSize = aws.Int64(int64(len(obj.data)))
+		case s3Types.ObjectAttributesStorageClass:
+			out.StorageClass = obj.storageClass
+		case s3Types.ObjectAttributesChecksum:
+			if obj.checksumSHA256 != "" || obj.checksumCRC32C != "" {
+				out.Checksum = &s3Types.Checksum{}
+			}
+			if obj.checksumSHA256 != "" {
+				out.Checksum.ChecksumSHA256 = aws.String(obj.checksumSHA256)
+			}
+			if obj.checksumCRC32C != "" {
+				out.Checksum.ChecksumCRC32C = aws.String(obj.checksumCRC32C)
+			}
+		}
+	}
+
+	return out, nil
+}
+
+func (c *mockS3Client) PutObject(_ context.Context, input *s3.PutObjectInput, _ ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if err := c.checkOwner(input.ExpectedBucketOwner); err != nil {
+		return nil, err
+	}
+	if c.putObjectHook != nil {
+		c.putObjectHook()
+	}
+	data, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	if input.ContentMD5 != nil && aws.ToString(input.ContentMD5) != contentMD5(data) {
+		return nil, mockS3APIError("BadDigest")
+	}
+	if c.aclsDisabled && input.ACL != "" {
+		return nil, mockS3APIError("AccessControlListNotSupported")
+	}
+	var checksumCRC32C string
+	if input.ChecksumAlgorithm == s3Types.ChecksumAlgorithmCrc32c || input.ChecksumCRC32C != nil {
+		checksumCRC32C = encodeCRC32C(crc32.Checksum(data, crc32cTable))
+		if input.ChecksumCRC32C != nil && aws.ToString(input.ChecksumCRC32C) != checksumCRC32C {
+			return nil, mockS3APIError("BadDigest")
+		}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.putObjectCalls = append(c.putObjectCalls, input)
+	obj := c.putObject(aws.ToString(input.Key), data, mockS3Object{
+		contentType:          aws.ToString(input.ContentType),
+		contentEncoding:      aws.ToString(input.ContentEncoding),
+		cacheControl:         aws.ToString(input.CacheControl),
+		contentDisposition:   aws.ToString(input.ContentDisposition),
+		contentLanguage:      aws.ToString(input.ContentLanguage),
+		expires:              input.Expires,
+		storageClass:         input.StorageClass,
+		metadata:             input.Metadata,
+		tagging:              aws.ToString(input.Tagging),
+		retention:            mockObjectLockRetention(input.ObjectLockMode, input.ObjectLockRetainUntilDate),
+		serverSideEncryption: input.ServerSideEncryption,
+		sseKMSKeyID:          aws.ToString(input.SSEKMSKeyId),
+		checksumCRC32C:       checksumCRC32C,
+	})
+
+	return &s3.PutObjectOutput{ETag: aws.String(c.returnedUploadETag(aws.ToString(input.Key), obj.etag))}, nil
+}
+
+func (c *mockS3Client) CopyObject(ctx context.Context, input *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	headers, err := mockRequestHeaders(ctx, optFns...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.copyCalls = append(c.copyCalls, input)
+	if c.aclsDisabled && input.ACL != "" {
+		return nil, mockS3APIError("AccessControlListNotSupported")
+	}
+	source, err := url.PathUnescape(aws.ToString(input.CopySource))
+	if err != nil {
+		return nil, err
+	}
+	source = source[strings.Index(source, "/")+1:]
+	src, ok := c.objects[source]
+	if !ok {
+		return nil, mockS3APIError("NoSuchKey")
+	}
+	if _, exists := c.objects[aws.ToString(input.Key)]; exists && headers.Get("If-None-Match") == "*" {
+		return nil, mockS3APIError("PreconditionFailed")
+	}
+
+	dst := *src
+	if input.MetadataDirective == s3Types.MetadataDirectiveReplace {
+		dst.metadata = input.Metadata
+		dst.contentType = aws.ToString(input.ContentType)
+		dst.contentEncoding = aws.ToString(input.ContentEncoding)
+		dst.cacheControl = aws.ToString(input.CacheControl)
+		dst.contentDisposition = aws.ToString(input.ContentDisposition)
+		dst.contentLanguage = aws.ToString(input.ContentLanguage)
+		dst.expires = input.Expires
+	}
+	if input.StorageClass != "" {
+		dst.storageClass = input.StorageClass
+	}
+	// Like S3, copies are encrypted as requested or with the bucket's
+	// default encryption, regardless of the encryption of the source.
+	dst.serverSideEncryption = input.ServerSideEncryption
+	dst.sseKMSKeyID = aws.ToString(input.SSEKMSKeyId)
+	if arn, ok := c.kmsAliases[dst.sseKMSKeyID]; ok {
+		dst.sseKMSKeyID = arn
+	}
+	obj := c.putObject(aws.ToString(input.Key), src.data, dst)
+
+	return &s3.CopyObjectOutput{CopyObjectResult: &s3Types.CopyObjectResult{ETag: aws.String(obj.etag)}}, nil
+}
+
+func (c *mockS3Client) DeleteObject(ctx context.Context, input *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	headers, err := mockRequestHeaders(ctx, optFns...)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.checkOwner(input.ExpectedBucketOwner); err != nil {
+		return nil, err
+	}
+
+	key := aws.ToString(input.Key)
+	obj, ok := c.objects[key]
+	if ifMatch := headers.Get("If-Match"); ifMatch != "" {
+		if !ok {
+			return nil, mockS3APIError("NoSuchKey")
+		}
+		if ifMatch != obj.etag {
+			return nil, mockS3APIError("PreconditionFailed")
+		}
+	}
+	return c.deleteVersion(key, input.VersionId)
+}
+
+// deleteVersion removes the current or given version of the key, or its
+// delete marker with the given version ID. The client must be locked.
+func (c *mockS3Client) deleteVersion(key string, version *string) (*s3.DeleteObjectOutput, error) {
+	obj, ok := c.objects[key]
+	locked := ok && (obj.legalHold || (obj.retention != nil && time.Now().Before(aws.ToTime(obj.retention.RetainUntilDate))))
+	if version != nil {
+		versionID := aws.ToString(version)
+		if c.lockedVersions[versionID] {
+			return nil, mockObjectLockError()
+		}
+		if ok && strings.Trim(obj.etag, `"`) == versionID {
+			if locked {
+				return nil, mockObjectLockError()
+			}
+			delete(c.objects, key)
+			return &s3.DeleteObjectOutput{VersionId: version}, nil
+		}
+		if removeMockVersion(c.noncurrentVersions, key, versionID) {
+			return &s3.DeleteObjectOutput{VersionId: version}, nil
+		}
+		removeMockVersion(c.deleteMarkers, key, versionID)
+		return &s3.DeleteObjectOutput{DeleteMarker: aws.Bool(true), VersionId: version}, nil
+	}
+
+	if locked {
+		return nil, mockObjectLockError()
+	}
+	delete(c.objects, key)
+	return &s3.DeleteObjectOutput{}, nil
+}
+
+func mockObjectLockError() error {
+	return &smithy.GenericAPIError{Code: "AccessDenied", Message: "Access Denied because object protected by object lock."}
+}
+
+// removeMockVersion removes the version with the given ID from the key's
+// versions, returning whether it was found.
+func removeMockVersion(versions map[string][]string, key, versionID string) bool {
+	for i, id := range versions[key] {
+		if id != versionID {
+			continue
+		}
+		versions[key] = append(versions[key][:i], versions[key][i+1:]...)
+		if len(versions[key]) == 0 {
+			delete(versions, key)
+		}
+		return true
+	}
+	return false
+}
+
+func (c *mockS3Client) GetObjectLegalHold(_ context.Context, input *s3.GetObjectLegalHoldInput, _ ...func(*s3.Options)) (*s3.GetObjectLegalHoldOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	obj, ok := c.objects[aws.ToString(input.Key)]
+	if !ok {
+		return nil, mockS3APIError("NoSuchKey")
+	}
+	if !obj.legalHold {
+		return nil, mockS3APIError("NoSuchObjectLockConfiguration")
+	}
+	return &s3.GetObjectLegalHoldOutput{LegalHold: &s3Types.ObjectLockLegalHold{Status: s3Types.ObjectLockLegalHoldStatusOn}}, nil
+}
+
+func (c *mockS3Client) GetObjectRetention(_ context.Context, input *s3.GetObjectRetentionInput, _ ...func(*s3.Options)) (*s3.GetObjectRetentionOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	obj, ok := c.objects[aws.ToString(input.Key)]
+	if !ok {
+		return nil, mockS3APIError("NoSuchKey")
+	}
+	if obj.retention == nil {
+		return nil, mockS3APIError("NoSuchObjectLockConfiguration")
+	}
+	return &s3.GetObjectRetentionOutput{Retention: obj.retention}, nil
+}
+
+func (c *mockS3Client) GetObjectLockConfiguration(context.Context, *s3.GetObjectLockConfigurationInput, ...func(*s3.Options)) (*s3.GetObjectLockConfigurationOutput, error) {
+	c.mu.Lock()
+	c.getObjectLockCalls++
+	c.mu.Unlock()
+	if c.objectLock == nil {
+		return nil, mockS3APIError("ObjectLockConfigurationNotFoundError")
+	}
+	return &s3.GetObjectLockConfigurationOutput{ObjectLockConfiguration: c.objectLock}, nil
+}
+
+func (c *mockS3Client) GetBucketLifecycleConfiguration(context.Context, *s3.GetBucketLifecycleConfigurationInput, ...func(*s3.Options)) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+	if c.lifecycle == nil {
+		return nil, mockS3APIError("NoSuchLifecycleConfiguration")
+	}
+	return &s3.GetBucketLifecycleConfigurationOutput{Rules: c.lifecycle}, nil
+}
+
+func (c *mockS3Client) PutBucketLifecycleConfiguration(_ context.Context, input *s3.PutBucketLifecycleConfigurationInput, _ ...func(*s3.Options)) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+	c.lifecycle = input.LifecycleConfiguration.Rules
+	return &s3.PutBucketLifecycleConfigurationOutput{}, nil
+}
+
+func (c *mockS3Client) DeleteObjects(_ context.Context, input *s3.DeleteObjectsInput, _ ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.checkOwner(input.ExpectedBucketOwner); err != nil {
+		return nil, err
+	}
+
+	c.deleteObjectsCalls = append(c.deleteObjectsCalls, input)
+	out := &s3.DeleteObjectsOutput{}
+	for _, obj := range input.Delete.Objects {
+		if _, err := c.deleteVersion(aws.ToString(obj.Key), obj.VersionId); err != nil {
+			var apiErr smithy.APIError
+			if !errors.As(err, &apiErr) {
+				return nil, err
+			}
+			out.Errors = append(out.Errors, s3Types.Error{
+				Key:       obj.Key,
+				VersionId: obj.VersionId,
+				Code:      aws.String(apiErr.ErrorCode()),
+				Message:   aws.String(apiErr.ErrorMessage()),
+			})
+			continue
+		}
+		out.Deleted = append(out.Deleted, s3Types.DeletedObject{Key: obj.Key, VersionId: obj.VersionId})
+	}
+	return out, nil
+}
+
+func (c *mockS3Client) ListObjects(_ context.Context, input *s3.ListObjectsInput, _ ...func(*s3.Options)) (*s3.ListObjectsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if err := c.checkOwner(input.ExpectedBucketOwner); err != nil {
+		return nil, err
+	}
+
+	if err := c.listObjectsErrs[aws.ToString(input.Prefix)]; err != nil {
+		return nil, err
+	}
+
+	// With a delimiter, the keys that contain it after the prefix are
+	// rolled up into their common prefix, which is listed in their place.
+	prefix, delimiter := aws.ToString(input.Prefix), aws.ToString(input.Delimiter)
+	// entries maps each listed key to whether it is a common prefix.
+	entries := map[string]bool{}
+	for key := range c.objects {
+		if !strings.HasPrefix(key, prefix) {
+			continue
+		}
+		entry, isCommonPrefix := key, false
+		if idx := strings.Index(key[len(prefix):], delimiter); delimiter != "" && idx >= 0 {
+			entry, isCommonPrefix = key[:len(prefix)+idx+len(delimiter)], true
+		}
+		if entry > aws.ToString(input.Marker) {
+			entries[entry] = entries[entry] || isCommonPrefix
+		}
+	}
+	keys := []string{}
+	for entry := range entries {
+		keys = append(keys, entry)
+	}
+	sort.Strings(keys)
+
+	out := &s3.ListObjectsOutput{IsTruncated: aws.Bool(false)}
+	if len(keys) > c.listPageSize {
+		keys = keys[:c.listPageSize]
+		out.IsTruncated = aws.Bool(true)
+		if delimiter != "" {
+			out.NextMarker = aws.String(keys[len(keys)-1])
+		}
+	}
+	for _, key := range keys {
+		if entries[key] {
+			out.CommonPrefixes = append(out.CommonPrefixes, s3Types.CommonPrefix{Prefix: aws.String(key)})
+			continue
+		}
+		obj := c.objects[key]
+		out.Contents = append(out.Contents, s3Types.Object{
+			Key:          aws.String(key),
+			ETag:         aws.String(obj.etag),
+			Size:         aws.Int64(int64(len(obj.data))),
+			LastModified: aws.Time(obj.lastModified),
+			StorageClass: s3Types.ObjectStorageClass(obj.storageClass),
+		})
+	}
+	return out, nil
+}
+
+// ListObjectVersions lists the versions and delete markers stored in the mock
+// client. The version ID of each current object is its ETag.
+func (c *mockS3Client) ListObjectVersions(_ context.Context, input *s3.ListObjectVersionsInput, _ ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	type version struct {
+		key          string
+		versionID    string
+		deleteMarker bool
+		noncurrent   bool
+	}
+	var versions []version
+	for key, obj := range c.objects {
+		if strings.HasPrefix(key, aws.ToString(input.Prefix)) {
+			versions = append(versions, version{key: key, versionID: strings.Trim(obj.etag, `"`)})
+		}
+	}
+	for key, versionIDs := range c.noncurrentVersions {
+		if strings.HasPrefix(key, aws.ToString(input.Prefix)) {
+			for _, versionID := range versionIDs {
+				versions = append(versions, version{key: key, versionID: versionID, noncurrent: true})
+			}
+		}
+	}
+	for key, markers := range c.deleteMarkers {
+		if strings.HasPrefix(key, aws.ToString(input.Prefix)) {
+			for _, versionID := range markers {
+				versions = append(versions, version{key: key, versionID: versionID, deleteMarker: true})
+			}
+		}
+	}
+	sort.Slice(versions, func(i, j int) bool {
+		if versions[i].key != versions[j].key {
+			return versions[i].key < versions[j].key
+		}
+		return versions[i].versionID < versions[j].versionID
+	})
+
+	keyMarker := aws.ToString(input.KeyMarker)
+	versionIDMarker := aws.ToString(input.VersionIdMarker)
+	out := &s3.ListObjectVersionsOutput{IsTruncated: aws.Bool(false)}
+	count := 0
+	for _, v := range versions {
+		if v.key < keyMarker || (v.key == keyMarker && v.versionID <= versionIDMarker) {
+			continue
+		}
+		if count == c.listPageSize {
+			out.IsTruncated = aws.Bool(true)
+			break
+		}
+		if v.deleteMarker {
+			out.DeleteMarkers = append(out.DeleteMarkers, s3Types.DeleteMarkerEntry{
+				Key:       aws.String(v.key),
+				VersionId: aws.String(v.versionID),
+				IsLatest:  aws.Bool(true),
+			})
+		} else {
+			out.Versions = append(out.Versions, s3Types.ObjectVersion{
+				Key:       aws.String(v.key),
+				VersionId: aws.String(v.versionID),
+				IsLatest:  aws.Bool(!v.noncurrent),
+			})
+		}
+		out.NextKeyMarker = aws.String(v.key)
+		out.NextVersionIdMarker = aws.String(v.versionID)
+		count++
+	}
+	return out, nil
+}
+
+func (c *mockS3Client) ListMultipartUploads(_ context.Context, input *s3.ListMultipartUploadsInput, _ ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ids := []string{}
+	for id, key := range c.uploadKeys {
+		if strings.HasPrefix(key, aws.ToString(input.Prefix)) {
+			ids = append(ids, id)
+		}
+	}
+	sort.Slice(ids, func(i, j int) bool {
+		if c.uploadKeys[ids[i]] != c.uploadKeys[ids[j]] {
+			return c.uploadKeys[ids[i]] < c.uploadKeys[ids[j]]
+		}
+		return ids[i] < ids[j]
+	})
+
+	out := &s3.ListMultipartUploadsOutput{}
+	marker := aws.ToString(input.KeyMarker)
+	for _, id := range ids {
+		key := c.uploadKeys[id]
+		if key < marker || (key == marker && id <= aws.ToString(input.UploadIdMarker)) {
+			continue
+		}
+		if len(out.Uploads) == c.listPageSize {
+			out.IsTruncated = aws.Bool(true)
+			last := out.Uploads[len(out.Uploads)-1]
+			out.NextKeyMarker = last.Key
+			out.NextUploadIdMarker = last.UploadId
+			break
+		}
+		out.Uploads = append(out.Uploads, s3Types.MultipartUpload{
+			Key:       aws.String(key),
+			UploadId:  aws.String(id),
+			Initiated: aws.Time(c.uploadObjects[id].lastModified),
+		})
+	}
+	return out, nil
+}
+
+func (c *mockS3Client) CreateMultipartUpload(_ context.Context, input *s3.CreateMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.uploadCount++
+	id := fmt.Sprintf("upload%d", c.uploadCount)
+	c.uploads[id] = map[int32][]byte{}
+	c.uploadKeys[id] = aws.ToString(input.Key)
+	c.uploadObjects[id] = mockS3Object{
+		contentType:          aws.ToString(input.ContentType),
+		contentEncoding:      aws.ToString(input.ContentEncoding),
+		cacheControl:         aws.ToString(input.CacheControl),
+		contentDisposition:   aws.ToString(input.ContentDisposition),
+		contentLanguage:      aws.ToString(input.ContentLanguage),
+		expires:              input.Expires,
+		storageClass:         input.StorageClass,
+		metadata:             input.Metadata,
+		tagging:              aws.ToString(input.Tagging),
+		retention:            mockObjectLockRetention(input.ObjectLockMode, input.ObjectLockRetainUntilDate),
+		lastModified:         time.Now(),
+		serverSideEncryption: input.ServerSideEncryption,
+		sseKMSKeyID:          aws.ToString(input.SSEKMSKeyId),
+	}
+	return &s3.CreateMultipartUploadOutput{UploadId: aws.String(id)}, nil
+}
+
+func (c *mockS3Client) UploadPart(_ context.Context, input *s3.UploadPartInput, _ ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	if c.uploadPartHook != nil {
+		c.uploadPartHook()
+	}
+	data, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	parts, ok := c.uploads[aws.ToString(input.UploadId)]
+	if !ok {
+		return nil, mockS3APIError("NoSuchUpload")
+	}
+	parts[aws.ToInt32(input.PartNumber)] = data
+	return &s3.UploadPartOutput{ETag: aws.String(mockETag(data))}, nil
+}
+
+func (c *mockS3Client) UploadPartCopy(_ context.Context, input *s3.UploadPartCopyInput, _ ...func(*s3.Options)) (*s3.UploadPartCopyOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.uploadPartCopyCalls = append(c.uploadPartCopyCalls, input)
+	parts, ok := c.uploads[aws.ToString(input.UploadId)]
+	if !ok {
+		return nil, mockS3APIError("NoSuchUpload")
+	}
+	source, err := url.PathUnescape(aws.ToString(input.CopySource))
+	if err != nil {
+		return nil, err
+	}
+	src, ok := c.objects[source[strings.Index(source, "/")+1:]]
+	if !ok {
+		return nil, mockS3APIError("NoSuchKey")
+	}
+	if input.CopySourceIfMatch != nil && strings.Trim(aws.ToString(input.CopySourceIfMatch), `"`) != strings.Trim(src.etag, `"`) {
+		return nil, mockS3APIError("PreconditionFailed")
+	}
+	var start, end int
+	if _, err = fmt.Sscanf(aws.ToString(input.CopySourceRange), "bytes=%d-%d", &start, &end); err != nil {
+		return nil, err
+	}
+	if start > end || end >= len(src.data) {
+		return nil, mockS3APIError("InvalidRange")
+	}
+	data := append([]byte{}, src.data[start:end+1]...)
+	parts[aws.ToInt32(input.PartNumber)] = data
+	return &s3.UploadPartCopyOutput{CopyPartResult: &s3Types.CopyPartResult{ETag: aws.String(mockETag(data))}}, nil
+}
+
+func (c *mockS3Client) CompleteMultipartUpload(_ context.Context, input *s3.CompleteMultipartUploadInput, _ ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	id := aws.ToString(input.UploadId)
+	parts, ok := c.uploads[id]
+	if !ok {
+		return nil, mockS3APIError("NoSuchUpload")
+	}
+	data := []byte{}
+	completed := [][]byte{}
+	for _, part := range input.MultipartUpload.Parts {
+		data = append(data, parts[aws.ToInt32(part.PartNumber)]...)
+		completed = append(completed, parts[aws.ToInt32(part.PartNumber)])
+	}
+	obj := c.putObject(c.uploadKeys[id], data, c.uploadObjects[id])
+	obj.etag = fmt.Sprintf(`"%s"`, ComputeMultipartETag(completed))
+	c.objects[c.uploadKeys[id]] = obj
+	delete(c.uploads, id)
+	delete(c.uploadKeys, id)
+	delete(c.uploadObjects, id)
+
+	return &s3.CompleteMultipartUploadOutput{ETag: aws.String(c.returnedUploadETag(aws.ToString(input.Key), obj.etag))}, nil
+}
+
+// returnedUploadETag returns the ETag to return for the completed upload of
+// the object with the given key and stored ETag.
+func (c *mockS3Client) returnedUploadETag(key, etag string) string {
+	if c.uploadETag == nil {
+		return etag
+	}
+	return c.uploadETag(key, etag)
+}
+
+func (c *mockS3Client) AbortMultipartUpload(_ context.Context, input *s3.AbortMultipartUploadInput, _ ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.uploads, aws.ToString(input.UploadId))
+	delete(c.uploadKeys, aws.ToString(input.UploadId))
+	delete(c.uploadObjects, aws.ToString(input.UploadId))
+	return &s3.AbortMultipartUploadOutput{}, nil
+}
+
+type mockS3ControlClient struct {
+	createJobInputs []*s3control.CreateJobInput
+}
+
+func (c *mockS3ControlClient) CreateJob(_ context.Context, input *s3control.CreateJobInput, _ ...func(*s3control.Options)) (*s3control.CreateJobOutput, error) {
+	c.createJobInputs = append(c.createJobInputs, input)
+	return &s3control.CreateJobOutput{JobId: aws.String("job-id")}, nil
+}
+
+func TestSubmitBatchCopyJob(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	opts := BatchJobOptions{
+		AccountID:            "123456789012",
+		RoleARN:              "arn:aws:iam::123456789012:role/batch",
+		Prefix:               "artifacts",
+		ManifestKey:          "manifests/copy.csv",
+		DestinationBucketARN: "arn:aws:s3:::destination",
+		DestinationPrefix:    "copied",
+		Priority:             10,
+	}
+	setup := func(t *testing.T) (*s3Bucket, *mockS3Client, *mockS3ControlClient) {
+		client := newMockS3Client()
+		controlClient := &mockS3ControlClient{}
+		b := newMockS3Bucket(client, "prefix")
+		b.controlSvc = controlClient
+		for _, key := range []string{"artifacts/a.txt", "artifacts/dir/b c.txt", "other/c.txt"} {
+			require.NoError(t, (&s3BucketSmall{s3Bucket: *b}).Put(ctx, key, strings.NewReader(key)))
+		}
+		return b, client, controlClient
+	}
+
+	t.Run("GeneratesManifestAndSubmitsJob", func(t *testing.T) {
+		b, client, controlClient := setup(t)
+
+		jobID, err := b.SubmitBatchCopyJob(ctx, opts)
+		require.NoError(t, err)
+		assert.Equal(t, "job-id", jobID)
+
+		manifest, ok := client.objects["prefix/manifests/copy.csv"]
+		require.True(t, ok)
+		assert.Equal(t, "bucket,prefix/artifacts/a.txt\nbucket,prefix/artifacts/dir/b%20c.txt\n", string(manifest.data))
+
+		require.Len(t, controlClient.createJobInputs, 1)
+		input := controlClient.createJobInputs[0]
+		assert.Equal(t, opts.AccountID, aws.ToString(input.AccountId))
+		assert.Equal(t, opts.RoleARN, aws.ToString(input.RoleArn))
+		assert.Equal(t, opts.Priority, aws.ToInt32(input.Priority))
+		assert.NotEmpty(t, aws.ToString(input.ClientRequestToken))
+		require.NotNil(t, input.Operation.S3PutObjectCopy)
+		assert.Equal(t, opts.DestinationBucketARN, aws.ToString(input.Operation.S3PutObjectCopy.TargetResource))
+		assert.Equal(t, opts.DestinationPrefix, aws.ToString(input.Operation.S3PutObjectCopy.TargetKeyPrefix))
+		assert.Equal(t, s3ControlTypes.JobManifestFormatS3BatchOperationsCsv20180820, input.Manifest.Spec.Format)
+		assert.Equal(t, []s3ControlTypes.JobManifestFieldName{s3ControlTypes.JobManifestFieldNameBucket, s3ControlTypes.JobManifestFieldNameKey}, input.Manifest.Spec.Fields)
+		assert.Equal(t, "arn:aws:s3:::bucket/prefix/manifests/copy.csv", aws.ToString(input.Manifest.Location.ObjectArn))
+		assert.Equal(t, manifest.etag, aws.ToString(input.Manifest.Location.ETag))
+		assert.False(t, input.Report.Enabled)
+	})
+	t.Run("EnablesReport", func(t *testing.T) {
+		b, _, controlClient := setup(t)
+
+		reportOpts := opts
+		reportOpts.ReportBucketARN = "arn:aws:s3:::reports"
+		reportOpts.ReportPrefix = "batch"
+		_, err := b.SubmitBatchCopyJob(ctx, reportOpts)
+		require.NoError(t, err)
+
+		require.Len(t, controlClient.createJobInputs, 1)
+		report := controlClient.createJobInputs[0].Report
+		assert.True(t, report.Enabled)
+		assert.Equal(t, reportOpts.ReportBucketARN, aws.ToString(report.Bucket))
+		assert.Equal(t, reportOpts.ReportPrefix, aws.ToString(report.Prefix))
+	})
+	t.Run("FailsWithInvalidOptions", func(t *testing.T) {
+		b, _, controlClient := setup(t)
+
+		_, err := b.SubmitBatchCopyJob(ctx, BatchJobOptions{Prefix: "artifacts"})
+		assert.Error(t, err)
+		assert.Empty(t, controlClient.createJobInputs)
+	})
+	t.Run("FailsWithEmptyPrefix", func(t *testing.T) {
+		b, client, controlClient := setup(t)
+
+		emptyOpts := opts
+		emptyOpts.Prefix = "DNE"
+		_, err := b.SubmitBatchCopyJob(ctx, emptyOpts)
+		assert.Error(t, err)
+		assert.Empty(t, controlClient.createJobInputs)
+		assert.NotContains(t, client.objects, "prefix/manifests/copy.csv")
+	})
+	t.Run("UsesPartitionOfRegion", func(t *testing.T) {
+		for region, partition := range map[string]string{
+			"":              "aws",
+			"us-east-1":     "aws",
+			"us-gov-west-1": "aws-us-gov",
+			"cn-north-1":    "aws-cn",
+		} {
+			b, _, controlClient := setup(t)
+			b.region = region
+
+			_, err := b.SubmitBatchCopyJob(ctx, opts)
+			require.NoError(t, err)
+			require.Len(t, controlClient.createJobInputs, 1)
+			assert.Equal(t, "arn:"+partition+":s3:::bucket/prefix/manifests/copy.csv", aws.ToString(controlClient.createJobInputs[0].Manifest.Location.ObjectArn), region)
+		}
+	})
+	t.Run("UploadsManifestOfManyPages", func(t *testing.T) {
+		b, client, _ := setup(t)
+		client.listPageSize = 2
+		for i := 0; i < 5; i++ {
+			client.putObject(fmt.Sprintf("prefix/many/%d", i), []byte("data"), mockS3Object{})
+		}
+
+		manyOpts := opts
+		manyOpts.Prefix = "many"
+		_, err := b.SubmitBatchCopyJob(ctx, manyOpts)
+		require.NoError(t, err)
+		assert.Equal(t, "bucket,prefix/many/0\nbucket,prefix/many/1\nbucket,prefix/many/2\nbucket,prefix/many/3\nbucket,prefix/many/4\n", string(client.objects["prefix/manifests/copy.csv"].data))
+	})
+	t.Run("DryRunDoesNotSubmit", func(t *testing.T) {
+		b, client, controlClient := setup(t)
+		b.dryRun = true
+
+		jobID, err := b.SubmitBatchCopyJob(ctx, opts)
+		require.NoError(t, err)
+		assert.Empty(t, jobID)
+		assert.Empty(t, controlClient.createJobInputs)
+		assert.NotContains(t, client.objects, "prefix/manifests/copy.csv")
+	})
+}
+
+func TestS3GetLimited(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	payload := []byte(strings.Repeat("abcdefghij", 100))
+
+	t.Run("SucceedsAtLimit", func(t *testing.T) {
+		client := newMockS3Client()
+		b := newMockS3Bucket(client, "prefix")
+		client.putObject("prefix/key", payload, mockS3Object{})
+
+		r, err := b.GetLimited(ctx, "key", int64(len(payload)))
+		require.NoError(t, err)
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, payload, data)
+	})
+	t.Run("OversizedObjectFailsBeforeDownload", func(t *testing.T) {
+		client := newMockS3Client()
+		b := newMockS3Bucket(client, "prefix")
+		client.putObject("prefix/key", payload, mockS3Object{})
+
+		r, err := b.GetLimited(ctx, "key", int64(len(payload)-1))
+		assert.Nil(t, r)
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrObjectTooLarge))
+	})
+	t.Run("OversizedStreamFailsWhileReading", func(t *testing.T) {
+		client := newMockS3Client()
+		b := newMockS3Bucket(client, "prefix")
+		compressed := &bytes.Buffer{}
+		gz := gzip.NewWriter(compressed)
+		_, err := gz.Write(payload)
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+		require.Less(t, compressed.Len(), len(payload)-1)
+		client.putObject("prefix/key", compressed.Bytes(), mockS3Object{contentEncoding: "gzip"})
+
+		r, err := b.GetLimited(ctx, "key", int64(len(payload)-1))
+		require.NoError(t, err)
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrObjectTooLarge))
+		assert.Equal(t, payload[:len(payload)-1], data)
+	})
+	t.Run("MissingKeyIsKeyNotFound", func(t *testing.T) {
+		b := newMockS3Bucket(newMockS3Client(), "prefix")
+
+		_, err := b.GetLimited(ctx, "DNE", 10)
+		require.Error(t, err)
+		assert.True(t, IsKeyNotFoundError(err))
+	})
+	t.Run("NegativeLimitFails", func(t *testing.T) {
+		client := newMockS3Client()
+		b := newMockS3Bucket(client, "prefix")
+		client.putObject("prefix/key", payload, mockS3Object{})
+
+		_, err := b.GetLimited(ctx, "key", -1)
+		assert.Error(t, err)
+	})
+}
+
+func TestS3GetWithInfo(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	t.Run("InfoMatchesHeadObject", func(t *testing.T) {
+		client := newMockS3Client()
+		b := newMockS3Bucket(client, "prefix")
+		payload := []byte("hello world")
+		client.putObject("prefix/key", payload, mockS3Object{
+			contentType: "text/plain",
+			metadata:    map[string]string{"owner": "pail"},
+		})
+
+		r, info, err := b.GetWithInfo(ctx, "key")
+		require.NoError(t, err)
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, payload, data)
+
+		head, err := client.HeadObject(ctx, &s3.HeadObjectInput{Key: aws.String("prefix/key")})
+		require.NoError(t, err)
+		assert.Equal(t, &BucketItemInfo{
+			Key:          "key",
+			Size:         aws.ToInt64(head.ContentLength),
+			ContentType:  aws.ToString(head.ContentType),
+			LastModified: aws.ToTime(head.LastModified),
+			ETag:         strings.Trim(aws.ToString(head.ETag), `"`),
+			Metadata:     head.Metadata,
+		}, info)
+		assert.Equal(t, "text/plain", info.ContentType)
+		assert.EqualValues(t, len(payload), info.Size)
+	})
+	t.Run("DecompressesCompressedObject", func(t *testing.T) {
+		client := newMockS3Client()
+		b := newMockS3Bucket(client, "prefix")
+		payload := []byte(strings.Repeat("abcdefghij", 100))
+		compressed := &bytes.Buffer{}
+		gz := gzip.NewWriter(compressed)
+		_, err := gz.Write(payload)
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+		client.putObject("prefix/key", compressed.Bytes(), mockS3Object{contentEncoding: "gzip"})
+
+		r, info, err := b.GetWithInfo(ctx, "key")
+		require.NoError(t, err)
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, payload, data)
+		assert.Equal(t, "gzip", info.ContentEncoding)
+		assert.EqualValues(t, compressed.Len(), info.Size)
+	})
+	t.Run("MissingKeyIsKeyNotFound", func(t *testing.T) {
+		b := newMockS3Bucket(newMockS3Client(), "prefix")
+
+		_, _, err := b.GetWithInfo(ctx, "DNE")
+		require.Error(t, err)
+		assert.True(t, IsKeyNotFoundError(err))
+	})
+}
+
+func TestS3CopyIfNotExists(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	setup := func(t *testing.T) (*mockS3Client, *s3BucketSmall) {
+		client := newMockS3Client()
+		b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+		client.putObject("prefix/src", []byte("new release"), mockS3Object{})
+		client.putObject("prefix/release", []byte("old release"), mockS3Object{})
+		return client, b
+	}
+
+	t.Run("ExistingDestinationIsProtected", func(t *testing.T) {
+		client, b := setup(t)
+
+		err := b.Copy(ctx, CopyOptions{SourceKey: "src", DestinationKey: "release", DestinationBucket: b, IfNotExists: true})
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrAlreadyExists))
+		assert.Equal(t, []byte("old release"), client.objects["prefix/release"].data)
+	})
+	t.Run("MissingDestinationIsCopied", func(t *testing.T) {
+		client, b := setup(t)
+
+		require.NoError(t, b.Copy(ctx, CopyOptions{SourceKey: "src", DestinationKey: "new", DestinationBucket: b, IfNotExists: true}))
+		assert.Equal(t, []byte("new release"), client.objects["prefix/new"].data)
+	})
+	t.Run("ExistingDestinationIsOverwrittenByDefault", func(t *testing.T) {
+		client, b := setup(t)
+
+		require.NoError(t, b.Copy(ctx, CopyOptions{SourceKey: "src", DestinationKey: "release", DestinationBucket: b}))
+		assert.Equal(t, []byte("new release"), client.objects["prefix/release"].data)
+	})
+}
+
+func TestComputeMultipartETag(t *testing.T) {
+	t.Run("MatchesS3Algorithm", func(t *testing.T) {
+		// The expected ETag for a two-part upload of a 5 MiB part of
+		// zeros followed by the bytes "tail", computed independently
+		// with md5sum.
+		parts := [][]byte{make([]byte, 5*1024*1024), []byte("tail")}
+		assert.Equal(t, "47693b6aafc99e607a33f321da4dc903-2", ComputeMultipartETag(parts))
+	})
+	t.Run("SinglePartIsNotPlainMD5", func(t *testing.T) {
+		part := []byte("hello world")
+		etag := ComputeMultipartETag([][]byte{part})
+		assert.True(t, strings.HasSuffix(etag, "-1"))
+		assert.NotEqual(t, strings.Trim(mockETag(part), `"`), strings.TrimSuffix(etag, "-1"))
+	})
+	t.Run("NoParts", func(t *testing.T) {
+		assert.Empty(t, ComputeMultipartETag(nil))
+	})
+	t.Run("LargeWriterComputesExpectedETag", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		client := newMockS3Client()
+		const partSize = 1024
+		b := &s3BucketLarge{s3Bucket: *newMockS3Bucket(client, "prefix"), minPartSize: partSize}
+		w, err := b.Writer(ctx, "key")
+		require.NoError(t, err)
+		lw, ok := w.(*largeWriteCloser)
+		require.True(t, ok)
+
+		var parts [][]byte
+		for i := 0; i < 3; i++ {
+			part := bytes.Repeat([]byte{byte('a' + i)}, partSize+1)
+			_, err = w.Write(part)
+			require.NoError(t, err)
+			parts = append(parts, part)
+		}
+		require.NoError(t, w.Close())
+
+		expected := ComputeMultipartETag(parts)
+		assert.Equal(t, expected, lw.expectedETag)
+		assert.Equal(t, fmt.Sprintf(`"%s"`, expected), client.objects["prefix/key"].etag)
+	})
+}
+
+func TestS3ExistsMany(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newMockS3Client()
+	b := newMockS3Bucket(client, "prefix")
+	var keys []string
+	expected := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		key := fmt.Sprintf("key%d", i)
+		keys = append(keys, key)
+		expected[key] = i%3 != 0
+		if expected[key] {
+			client.putObject("prefix/"+key, []byte(key), mockS3Object{})
+		}
+	}
+
+	t.Run("ReportsPresentAndAbsentKeys", func(t *testing.T) {
+		exists, err := ExistsMany(ctx, &s3BucketSmall{s3Bucket: *b}, keys, 8)
+		require.NoError(t, err)
+		assert.Equal(t, expected, exists)
+	})
+	t.Run("SeparatesErrorsFromAbsentKeys", func(t *testing.T) {
+		client.headObjectErrs = map[string]error{"prefix/key1": mockS3APIError("AccessDenied")}
+		defer func() { client.headObjectErrs = nil }()
+
+		exists, err := ExistsMany(ctx, &s3BucketSmall{s3Bucket: *b}, keys, 8)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "key1")
+		assert.NotContains(t, exists, "key1")
+		assert.Len(t, exists, len(keys)-1)
+		assert.False(t, exists["key0"])
+		assert.True(t, exists["key2"])
+	})
+}
+
+func TestS3DetectBucketRegion(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, test := range []struct {
+		name     string
+		location s3Types.BucketLocationConstraint
+		expected string
+	}{
+		{name: "Empty", location: "", expected: "us-east-1"},
+		{name: "LegacyEU", location: s3Types.BucketLocationConstraintEu, expected: "eu-west-1"},
+		{name: "Explicit", location: s3Types.BucketLocationConstraintUsWest2, expected: "us-west-2"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			client := newMockS3Client()
+			client.location = test.location
+			b := newMockS3Bucket(client, "prefix")
+
+			region, err := b.DetectBucketRegion(ctx)
+			require.NoError(t, err)
+			assert.Equal(t, test.expected, region)
+			assert.Equal(t, test.expected, normalizeRegion(string(test.location)))
+		})
+	}
+}
+
+func TestS3GetRecent(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newMockS3Client()
+	client.listPageSize = 7
+	b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+	start := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	// Insert the objects in an order unrelated to their modification
+	// times.
+	for j := 0; j < 100; j++ {
+		i := j * 37 % 100
+		obj := client.putObject(fmt.Sprintf("prefix/logs/log%03d", i), []byte("log"), mockS3Object{})
+		obj.lastModified = start.Add(time.Duration(i) * time.Minute)
+		client.objects[fmt.Sprintf("prefix/logs/log%03d", i)] = obj
+	}
+	client.putObject("prefix/other/newest", []byte("other"), mockS3Object{})
+
+	t.Run("ReturnsNewestInOrder", func(t *testing.T) {
+		items, err := GetRecent(ctx, b, "logs", 5)
+		require.NoError(t, err)
+		require.Len(t, items, 5)
+		for i, item := range items {
+			assert.Equal(t, fmt.Sprintf("logs/log%03d", 99-i), item.Name())
+			assert.Equal(t, start.Add(time.Duration(99-i)*time.Minute), itemLastModified(item))
+		}
+	})
+	t.Run("ReturnsAllWhenFewerThanN", func(t *testing.T) {
+		items, err := GetRecent(ctx, b, "logs", 500)
+		require.NoError(t, err)
+		require.Len(t, items, 100)
+		assert.Equal(t, "logs/log099", items[0].Name())
+		assert.Equal(t, "logs/log000", items[99].Name())
+	})
+	t.Run("ZeroReturnsNothing", func(t *testing.T) {
+		items, err := GetRecent(ctx, b, "logs", 0)
+		require.NoError(t, err)
+		assert.Empty(t, items)
+	})
+	t.Run("NegativeFails", func(t *testing.T) {
+		_, err := GetRecent(ctx, b, "logs", -1)
+		assert.Error(t, err)
+	})
+}
+
+func TestS3DeleteMarkers(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	setup := func(t *testing.T) (*s3Bucket, *mockS3Client) {
+		client := newMockS3Client()
+		client.listPageSize = 2
+		b := newMockS3Bucket(client, "prefix")
+		client.deleteMarkers["prefix/logs/a.txt"] = []string{"v1", "v2"}
+		client.deleteMarkers["prefix/logs/b.txt"] = []string{"v3"}
+		client.deleteMarkers["prefix/other/c.txt"] = []string{"v4"}
+		client.putObject("prefix/logs/d.txt", []byte("data"), mockS3Object{})
+		return b, client
+	}
+
+	t.Run("ListReturnsMarkersWithPrefix", func(t *testing.T) {
+		b, _ := setup(t)
+
+		markers, err := b.ListDeleteMarkers(ctx, "logs")
+		require.NoError(t, err)
+		require.Len(t, markers, 3)
+		assert.Equal(t, DeleteMarker{Key: "logs/a.txt", VersionID: "v1", IsLatest: true}, markers[0])
+		assert.Equal(t, DeleteMarker{Key: "logs/a.txt", VersionID: "v2", IsLatest: true}, markers[1])
+		assert.Equal(t, DeleteMarker{Key: "logs/b.txt", VersionID: "v3", IsLatest: true}, markers[2])
+	})
+	t.Run("PurgeRemovesAllMarkersWithPrefix", func(t *testing.T) {
+		b, client := setup(t)
+
+		require.NoError(t, b.PurgeDeleteMarkers(ctx, "logs"))
+		markers, err := b.ListDeleteMarkers(ctx, "logs")
+		require.NoError(t, err)
+		assert.Empty(t, markers)
+
+		assert.Equal(t, map[string][]string{"prefix/other/c.txt": {"v4"}}, client.deleteMarkers)
+		assert.Contains(t, client.objects, "prefix/logs/d.txt")
+	})
+	t.Run("PurgeWithDryRunDoesNotRemoveMarkers", func(t *testing.T) {
+		b, _ := setup(t)
+		b.dryRun = true
+
+		require.NoError(t, b.PurgeDeleteMarkers(ctx, "logs"))
+		markers, err := b.ListDeleteMarkers(ctx, "logs")
+		require.NoError(t, err)
+		assert.Len(t, markers, 3)
+	})
+}
+
+func TestS3EmptyPrefix(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	setup := func(t *testing.T) (*s3Bucket, *mockS3Client) {
+		client := newMockS3Client()
+		client.listPageSize = 2
+		b := newMockS3Bucket(client, "prefix")
+		client.putObject("prefix/logs/a.txt", []byte("a"), mockS3Object{})
+		client.putObject("prefix/logs/locked.txt", []byte("locked"), mockS3Object{legalHold: true})
+		client.putObject("prefix/other/c.txt", []byte("c"), mockS3Object{})
+		client.noncurrentVersions["prefix/logs/a.txt"] = []string{"a-v1", "a-v2"}
+		client.noncurrentVersions["prefix/logs/b.txt"] = []string{"b-v1", "b-locked"}
+		client.noncurrentVersions["prefix/other/c.txt"] = []string{"c-v1"}
+		client.lockedVersions["b-locked"] = true
+		client.deleteMarkers["prefix/logs/b.txt"] = []string{"b-marker"}
+		return b, client
+	}
+
+	t.Run("RemovesAllVersionsAndReportsLockedObjects", func(t *testing.T) {
+		b, client := setup(t)
+
+		err := b.EmptyPrefix(ctx, "logs", EmptyOptions{NoncurrentVersions: true, DeleteMarkers: true})
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrObjectLocked))
+		var lockedErr *LockedObjectsError
+		require.True(t, errors.As(err, &lockedErr))
+		assert.ElementsMatch(t, []ObjectVersion{
+			{Key: "logs/locked.txt", VersionID: strings.Trim(client.objects["prefix/logs/locked.txt"].etag, `"`)},
+			{Key: "logs/b.txt", VersionID: "b-locked"},
+		}, lockedErr.Objects)
+
+		assert.NotContains(t, client.objects, "prefix/logs/a.txt")
+		assert.Contains(t, client.objects, "prefix/logs/locked.txt")
+		assert.Equal(t, map[string][]string{
+			"prefix/logs/b.txt":  {"b-locked"},
+			"prefix/other/c.txt": {"c-v1"},
+		}, client.noncurrentVersions)
+		assert.Empty(t, client.deleteMarkers)
+		assert.Contains(t, client.objects, "prefix/other/c.txt")
+	})
+	t.Run("KeepsDeleteMarkersByDefault", func(t *testing.T) {
+		b, client := setup(t)
+
+		err := b.EmptyPrefix(ctx, "logs", EmptyOptions{NoncurrentVersions: true})
+		assert.True(t, errors.Is(err, ErrObjectLocked))
+		assert.Equal(t, map[string][]string{"prefix/logs/b.txt": {"b-marker"}}, client.deleteMarkers)
+		assert.NotContains(t, client.noncurrentVersions, "prefix/logs/a.txt")
+	})
+	t.Run("RemovesOnlyCurrentVersionsByDefault", func(t *testing.T) {
+		b, client := setup(t)
+
+		err := b.EmptyPrefix(ctx, "logs", EmptyOptions{})
+		var lockedErr *LockedObjectsError
+		require.True(t, errors.As(err, &lockedErr))
+		assert.Equal(t, []ObjectVersion{{Key: "logs/locked.txt"}}, lockedErr.Objects)
+
+		assert.NotContains(t, client.objects, "prefix/logs/a.txt")
+		assert.Equal(t, []string{"a-v1", "a-v2"}, client.noncurrentVersions["prefix/logs/a.txt"])
+	})
+	t.Run("SucceedsWithoutLockedObjects", func(t *testing.T) {
+		b, client := setup(t)
+
+		require.NoError(t, b.EmptyPrefix(ctx, "other", EmptyOptions{NoncurrentVersions: true, DeleteMarkers: true}))
+		assert.NotContains(t, client.objects, "prefix/other/c.txt")
+		assert.NotContains(t, client.noncurrentVersions, "prefix/other/c.txt")
+	})
+	t.Run("DryRunDoesNotRemove", func(t *testing.T) {
+		b, client := setup(t)
+		b.dryRun = true
+
+		require.NoError(t, b.EmptyPrefix(ctx, "logs", EmptyOptions{NoncurrentVersions: true, DeleteMarkers: true}))
+		assert.Contains(t, client.objects, "prefix/logs/a.txt")
+		assert.Len(t, client.noncurrentVersions, 3)
+		assert.Len(t, client.deleteMarkers, 1)
+	})
+	t.Run("DeleteMarkersRequireNoncurrentVersions", func(t *testing.T) {
+		b, _ := setup(t)
+		assert.Error(t, b.EmptyPrefix(ctx, "logs", EmptyOptions{DeleteMarkers: true}))
+	})
+	t.Run("RemovesEachPageInBatches", func(t *testing.T) {
+		b, client := setup(t)
+		b.batchSize = 2
+		for i := 0; i < 5; i++ {
+			client.putObject(fmt.Sprintf("prefix/many/%d", i), []byte("data"), mockS3Object{})
+		}
+
+		require.NoError(t, b.EmptyPrefix(ctx, "many", EmptyOptions{}))
+		require.Len(t, client.deleteObjectsCalls, 3)
+		for _, call := range client.deleteObjectsCalls {
+			assert.LessOrEqual(t, len(call.Delete.Objects), 2)
+		}
+		for i := 0; i < 5; i++ {
+			assert.NotContains(t, client.objects, fmt.Sprintf("prefix/many/%d", i))
+		}
+	})
+}
+
+func TestS3RemoveReportsFailedDeletes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	setup := func(t *testing.T) (*s3BucketSmall, *mockS3Client) {
+		client := newMockS3Client()
+		client.listPageSize = 2
+		b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+		b.batchSize = 2
+		client.putObject("prefix/logs/a.txt", []byte("a"), mockS3Object{})
+		client.putObject("prefix/logs/locked.txt", []byte("locked"), mockS3Object{legalHold: true})
+		client.putObject("prefix/logs/z.txt", []byte("z"), mockS3Object{})
+		return b, client
+	}
+
+	t.Run("RemovePrefix", func(t *testing.T) {
+		b, client := setup(t)
+
+		err := b.RemovePrefix(ctx, "logs")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "logs/locked.txt")
+		assert.Contains(t, client.objects, "prefix/logs/locked.txt")
+		assert.NotContains(t, client.objects, "prefix/logs/a.txt")
+		assert.NotContains(t, client.objects, "prefix/logs/z.txt", "failed deletes should not stop the rest of the prefix from being removed")
+	})
+	t.Run("RemoveMany", func(t *testing.T) {
+		b, client := setup(t)
+
+		err := b.RemoveMany(ctx, "logs/a.txt", "logs/locked.txt", "logs/z.txt")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "logs/locked.txt")
+		assert.Contains(t, client.objects, "prefix/logs/locked.txt")
+		assert.NotContains(t, client.objects, "prefix/logs/a.txt")
+		assert.NotContains(t, client.objects, "prefix/logs/z.txt")
+	})
+}
+
+// concurrencyTrackingReaderAt wraps an io.ReaderAt and records the maximum
+// number of parts being read at the same time.
+type concurrencyTrackingReaderAt struct {
+	io.ReaderAt
+	partSize    int64
+	inFlight    int32
+	maxInFlight int32
+}
+
+func (r *concurrencyTrackingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	if off%r.partSize == 0 {
+		// Hold the first read of each part long enough for the reads of
+		// other parts to overlap with it.
+		inFlight := atomic.AddInt32(&r.inFlight, 1)
+		defer atomic.AddInt32(&r.inFlight, -1)
+		for {
+			prev := atomic.LoadInt32(&r.maxInFlight)
+			if inFlight <= prev || atomic.CompareAndSwapInt32(&r.maxInFlight, prev, inFlight) {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	return r.ReaderAt.ReadAt(p, off)
+}
+
+func TestS3UploadReaderAt(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const partSize = 1024 * 1024 * 5
+	setup := func(t *testing.T, size int) (*s3BucketLarge, *mockS3Client, *os.File, []byte) {
+		client := newMockS3Client()
+		b := &s3BucketLarge{s3Bucket: *newMockS3Bucket(client, "prefix"), minPartSize: partSize}
+
+		data := make([]byte, size)
+		_, err := rand.Read(data)
+		require.NoError(t, err)
+		path := filepath.Join(t.TempDir(), "file")
+		require.NoError(t, os.WriteFile(path, data, 0600))
+		f, err := os.Open(path)
+		require.NoError(t, err)
+		t.Cleanup(func() { assert.NoError(t, f.Close()) })
+
+		return b, client, f, data
+	}
+
+	t.Run("ReadsPartsConcurrently", func(t *testing.T) {
+		b, client, f, data := setup(t, 3*partSize+1024)
+		r := &concurrencyTrackingReaderAt{ReaderAt: f, partSize: partSize}
+
+		require.NoError(t, b.UploadReaderAt(ctx, "key", r, int64(len(data))))
+		assert.Greater(t, atomic.LoadInt32(&r.maxInFlight), int32(1))
+		require.Contains(t, client.objects, "prefix/key")
+		assert.Equal(t, data, client.objects["prefix/key"].data)
+	})
+	t.Run("UploadsSmallFileInSinglePart", func(t *testing.T) {
+		b, client, f, data := setup(t, 1024)
+
+		require.NoError(t, b.UploadReaderAt(ctx, "key", f, int64(len(data))))
+		assert.Len(t, client.putObjectCalls, 1)
+		require.Contains(t, client.objects, "prefix/key")
+		assert.Equal(t, data, client.objects["prefix/key"].data)
+	})
+	t.Run("DryRunDoesNotUpload", func(t *testing.T) {
+		b, client, f, data := setup(t, 1024)
+		b.dryRun = true
+
+		require.NoError(t, b.UploadReaderAt(ctx, "key", f, int64(len(data))))
+		assert.Empty(t, client.objects)
+	})
+}
+
+func TestS3MultipartPartLimit(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	t.Run("PartSize", func(t *testing.T) {
+		const minPartSize = 1024 * 1024 * 5
+		for _, test := range []struct {
+			name     string
+			size     int64
+			expected int
+		}{
+			{name: "Small", size: 1024, expected: minPartSize},
+			{name: "AtLimit", size: minPartSize * maxMultipartParts, expected: minPartSize},
+			{name: "OverLimit", size: minPartSize*maxMultipartParts + 1, expected: minPartSize + 1},
+			{name: "VeryLarge", size: 100 * 1024 * 1024 * 1024, expected: 10737419},
+		} {
+			t.Run(test.name, func(t *testing.T) {
+				partSize, err := multipartPartSize(test.size, minPartSize)
+				require.NoError(t, err)
+				assert.Equal(t, test.expected, partSize)
+				assert.LessOrEqual(t, (test.size+int64(partSize)-1)/int64(partSize), int64(maxMultipartParts))
+			})
+		}
+	})
+	t.Run("TooLargeObjectFailsBeforeUpload", func(t *testing.T) {
+		client := newMockS3Client()
+		b := &s3BucketLarge{s3Bucket: *newMockS3Bucket(client, "prefix"), minPartSize: 1024 * 1024 * 5}
+
+		err := b.UploadReaderAt(ctx, "key", bytes.NewReader(nil), maxMultipartPartSize*maxMultipartParts+1)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "too large")
+		assert.Zero(t, client.uploadCount)
+		assert.Empty(t, client.putObjectCalls)
+	})
+	t.Run("UploadIncreasesPartSize", func(t *testing.T) {
+		client := newMockS3Client()
+		b := &s3BucketLarge{s3Bucket: *newMockS3Bucket(client, "prefix"), minPartSize: 1}
+
+		data := bytes.Repeat([]byte("0123456789"), 3000)
+		path := filepath.Join(t.TempDir(), "file")
+		require.NoError(t, os.WriteFile(path, data, 0600))
+
+		sized, err := b.withPartSizeFor(int64(len(data)))
+		require.NoError(t, err)
+		assert.Equal(t, 3, sized.minPartSize)
+		assert.Equal(t, 1, b.minPartSize)
+
+		require.NoError(t, b.Upload(ctx, "key", path))
+		require.Contains(t, client.objects, "prefix/key")
+		assert.Equal(t, data, client.objects["prefix/key"].data)
+	})
+	t.Run("StreamingWriterFailsAfterMaxParts", func(t *testing.T) {
+		client := newMockS3Client()
+		b := &s3BucketLarge{s3Bucket: *newMockS3Bucket(client, "prefix"), minPartSize: 1}
+
+		w, err := b.Writer(ctx, "key")
+		require.NoError(t, err)
+		for i := 0; i < maxMultipartParts; i++ {
+			_, err = w.Write([]byte("ab"))
+			require.NoError(t, err)
+		}
+		_, err = w.Write([]byte("ab"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "maximum")
+		assert.Empty(t, client.uploads)
+		assert.NotContains(t, client.objects, "prefix/key")
+	})
+}
+
+func TestS3Ping(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	t.Run("SucceedsWithReachableBucket", func(t *testing.T) {
+		b := newMockS3Bucket(newMockS3Client(), "prefix")
+
+		latency, err := b.Ping(ctx)
+		require.NoError(t, err)
+		assert.True(t, latency >= 0)
+	})
+	t.Run("SucceedsWithForbiddenBucket", func(t *testing.T) {
+		client := newMockS3Client()
+		client.headBucketErr = mockS3APIError("Forbidden")
+		b := newMockS3Bucket(client, "prefix")
+
+		latency, err := b.Ping(ctx)
+		require.NoError(t, err)
+		assert.True(t, latency >= 0)
+	})
+	t.Run("FailsWithMissingBucket", func(t *testing.T) {
+		client := newMockS3Client()
+		client.headBucketErr = mockS3APIError("NotFound")
+		b := newMockS3Bucket(client, "prefix")
+
+		_, err := b.Ping(ctx)
+		assert.Error(t, err)
+	})
+}
+
+func TestS3ArchiveAppend(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	setup := func(t *testing.T) *s3ArchiveBucket {
+		client := newMockS3Client()
+		b := &s3ArchiveBucket{s3BucketLarge: &s3BucketLarge{s3Bucket: *newMockS3Bucket(client, "prefix"), minPartSize: 1024 * 1024 * 5}}
+
+		archive := &bytes.Buffer{}
+		tarWriter := tar.NewWriter(archive)
+		for _, name := range []string{"a.txt", "dir/b.txt"} {
+			require.NoError(t, tarWriter.WriteHeader(&tar.Header{Typeflag: tar.TypeReg, Name: name, Mode: 0644, Size: int64(len(name))}))
+			_, err := tarWriter.Write([]byte(name))
+			require.NoError(t, err)
+		}
+		require.NoError(t, tarWriter.Close())
+		require.NoError(t, b.Put(ctx, "remote/"+syncArchiveName, archive))
+
+		return b
+	}
+
+	t.Run("AppendsToExistingArchive", func(t *testing.T) {
+		b := setup(t)
+
+		require.NoError(t, b.ArchiveAppend(ctx, "remote/"+syncArchiveName, "dir/c.txt", strings.NewReader("dir/c.txt")))
+
+		local := t.TempDir()
+		require.NoError(t, b.Pull(ctx, SyncOptions{Local: local, Remote: "remote"}))
+		assert.NoError(t, checkLocalTreeMatchesData(ctx, local, map[string]string{
+			"a.txt":     "a.txt",
+			"dir/b.txt": "dir/b.txt",
+			"dir/c.txt": "dir/c.txt",
+		}))
+	})
+	t.Run("ReplacesEntryWithSamePath", func(t *testing.T) {
+		b := setup(t)
+
+		require.NoError(t, b.ArchiveAppend(ctx, "remote/"+syncArchiveName, "a.txt", strings.NewReader("new contents")))
+
+		local := t.TempDir()
+		require.NoError(t, b.Pull(ctx, SyncOptions{Local: local, Remote: "remote"}))
+		assert.NoError(t, checkLocalTreeMatchesData(ctx, local, map[string]string{
+			"a.txt":     "new contents",
+			"dir/b.txt": "dir/b.txt",
+		}))
+	})
+	t.Run("CreatesMissingArchive", func(t *testing.T) {
+		b := setup(t)
+
+		require.NoError(t, b.ArchiveAppend(ctx, "other/"+syncArchiveName, "a.txt", strings.NewReader("a.txt")))
+
+		local := t.TempDir()
+		require.NoError(t, b.Pull(ctx, SyncOptions{Local: local, Remote: "other"}))
+		assert.NoError(t, checkLocalTreeMatchesData(ctx, local, map[string]string{"a.txt": "a.txt"}))
+	})
+}
+
+func TestS3ArchivePushVerify(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	setup := func(t *testing.T) (*mockS3Client, *s3ArchiveBucket, string) {
+		client := newMockS3Client()
+		b := &s3ArchiveBucket{s3BucketLarge: &s3BucketLarge{s3Bucket: *newMockS3Bucket(client, "prefix"), minPartSize: 1024 * 1024 * 5}}
+
+		local := t.TempDir()
+		for _, name := range []string{"a.txt", "dir/b.txt", "dir/c.txt"} {
+			require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(local, name)), 0700))
+			require.NoError(t, os.WriteFile(filepath.Join(local, name), bytes.Repeat([]byte(name), 100), 0600))
+		}
+		return client, b, local
+	}
+
+	t.Run("SucceedsForIntactArchive", func(t *testing.T) {
+		_, b, local := setup(t)
+		require.NoError(t, b.Push(ctx, SyncOptions{Local: local, Remote: "remote", VerifyArchive: true}))
+	})
+	t.Run("FailsForTruncatedArchive", func(t *testing.T) {
+		client, b, local := setup(t)
+		client.corrupt = func(_ string, data []byte) []byte { return data[:len(data)/2] }
+
+		err := b.Push(ctx, SyncOptions{Local: local, Remote: "remote", VerifyArchive: true})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "verifying archive")
+	})
+	t.Run("FailsForArchiveMissingEntries", func(t *testing.T) {
+		client, b, local := setup(t)
+		client.corrupt = func(_ string, data []byte) []byte {
+			archive := &bytes.Buffer{}
+			tarWriter := tar.NewWriter(archive)
+			tarReader := tar.NewReader(bytes.NewReader(data))
+			header, err := tarReader.Next()
+			require.NoError(t, err)
+			require.NoError(t, tarWriter.WriteHeader(header))
+			_, err = io.Copy(tarWriter, tarReader)
+			require.NoError(t, err)
+			require.NoError(t, tarWriter.Close())
+			return archive.Bytes()
+		}
+
+		err := b.Push(ctx, SyncOptions{Local: local, Remote: "remote", VerifyArchive: true})
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "has 1 entries, but expected 3")
+	})
+	t.Run("SkipsVerificationByDefault", func(t *testing.T) {
+		client, b, local := setup(t)
+		client.corrupt = func(_ string, data []byte) []byte { return data[:len(data)/2] }
+
+		assert.NoError(t, b.Push(ctx, SyncOptions{Local: local, Remote: "remote"}))
+	})
+	t.Run("FailedPushDoesNotUploadPartialArchive", func(t *testing.T) {
+		client, b, local := setup(t)
+		// tar can't archive sockets, so the push fails after the
+		// writer has been opened and other files have been written.
+		l, err := net.Listen("unix", filepath.Join(local, "dir", "socket"))
+		require.NoError(t, err)
+		defer l.Close()
+
+		assert.Error(t, b.Push(ctx, SyncOptions{Local: local, Remote: "remote"}))
+		assert.NotContains(t, client.objects, "prefix/remote/archive.tar")
+		assert.Empty(t, client.uploadKeys)
+	})
+	t.Run("ReadsFilesConcurrently", func(t *testing.T) {
+		client, b, local := setup(t)
+		require.NoError(t, b.Push(ctx, SyncOptions{Local: local, Remote: "remote"}))
+		serial := client.objects["prefix/remote/archive.tar"].data
+
+		b.archiveWorkers = 4
+		require.NoError(t, b.Push(ctx, SyncOptions{Local: local, Remote: "remote", VerifyArchive: true}))
+		assert.Equal(t, serial, client.objects["prefix/remote/archive.tar"].data)
+	})
+}
+
+func TestS3ObjectLock(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	retainUntil := time.Now().Add(time.Hour).Round(time.Second)
+	setup := func(t *testing.T) *s3Bucket {
+		client := newMockS3Client()
+		client.putObject("prefix/unlocked", []byte("data"), mockS3Object{})
+		client.putObject("prefix/held", []byte("data"), mockS3Object{legalHold: true})
+		client.putObject("prefix/retained", []byte("data"), mockS3Object{retention: &s3Types.ObjectLockRetention{
+			Mode:            s3Types.ObjectLockRetentionModeCompliance,
+			RetainUntilDate: aws.Time(retainUntil),
+		}})
+		return newMockS3Bucket(client, "prefix")
+	}
+
+	t.Run("LegalHold", func(t *testing.T) {
+		b := setup(t)
+
+		held, err := b.GetObjectLegalHold(ctx, "held")
+		require.NoError(t, err)
+		assert.True(t, held)
+
+		held, err = b.GetObjectLegalHold(ctx, "unlocked")
+		require.NoError(t, err)
+		assert.False(t, held)
+
+		_, err = b.GetObjectLegalHold(ctx, "DNE")
+		require.Error(t, err)
+		assert.True(t, IsKeyNotFoundError(err))
+	})
+	t.Run("Retention", func(t *testing.T) {
+		b := setup(t)
+
+		retention, err := b.GetObjectRetention(ctx, "retained")
+		require.NoError(t, err)
+		require.NotNil(t, retention)
+		assert.Equal(t, string(s3Types.ObjectLockRetentionModeCompliance), retention.Mode)
+		assert.True(t, retainUntil.Equal(retention.RetainUntil))
+		assert.True(t, retention.IsActive())
+
+		retention, err = b.GetObjectRetention(ctx, "unlocked")
+		require.NoError(t, err)
+		assert.Nil(t, retention)
+		assert.False(t, retention.IsActive())
+	})
+	t.Run("RemoveLockedObjectReturnsErrObjectLocked", func(t *testing.T) {
+		b := setup(t)
+
+		for _, key := range []string{"held", "retained"} {
+			err := b.Remove(ctx, key)
+			require.Error(t, err)
+			assert.True(t, errors.Is(err, ErrObjectLocked), key)
+		}
+		assert.NoError(t, b.Remove(ctx, "unlocked"))
+	})
+	t.Run("BucketConfiguration", func(t *testing.T) {
+		b := setup(t)
+		b.svc.(*mockS3Client).objectLock = &s3Types.ObjectLockConfiguration{
+			ObjectLockEnabled: s3Types.ObjectLockEnabledEnabled,
+			Rule: &s3Types.ObjectLockRule{DefaultRetention: &s3Types.DefaultRetention{
+				Mode: s3Types.ObjectLockRetentionModeGovernance,
+				Days: aws.Int32(30),
+			}},
+		}
+
+		config, err := b.GetBucketObjectLockConfiguration(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, &ObjectLockConfig{Enabled: true, Mode: "GOVERNANCE", Days: 30}, config)
+		assert.True(t, config.HasDefaultRetention())
+		written := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+		assert.Equal(t, time.Date(2024, 1, 31, 0, 0, 0, 0, time.UTC), config.DefaultRetainUntil(written))
+	})
+	t.Run("BucketConfigurationWithoutObjectLock", func(t *testing.T) {
+		b := setup(t)
+
+		config, err := b.GetBucketObjectLockConfiguration(ctx)
+		require.NoError(t, err)
+		assert.Nil(t, config)
+		assert.False(t, config.HasDefaultRetention())
+	})
+	t.Run("WritesValidateObjectRetention", func(t *testing.T) {
+		client := newMockS3Client()
+		client.objectLock = &s3Types.ObjectLockConfiguration{
+			ObjectLockEnabled: s3Types.ObjectLockEnabledEnabled,
+			Rule: &s3Types.ObjectLockRule{DefaultRetention: &s3Types.DefaultRetention{
+				Mode:  s3Types.ObjectLockRetentionModeCompliance,
+				Years: aws.Int32(1),
+			}},
+		}
+		small := &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+		large := &s3BucketLarge{s3Bucket: *newMockS3Bucket(client, "prefix"), minPartSize: 1024 * 1024 * 5}
+
+		for name, b := range map[string]Bucket{"Small": small, "Large": large} {
+			t.Run(name, func(t *testing.T) {
+				for _, retention := range []*ObjectRetention{
+					{Mode: "GOVERNANCE", RetainUntil: time.Now().AddDate(2, 0, 0)},
+					{Mode: "COMPLIANCE", RetainUntil: time.Now().AddDate(0, 6, 0)},
+				} {
+					small.objectRetention = retention
+					large.objectRetention = retention
+					assert.Error(t, b.Put(ctx, "weak", strings.NewReader("data")))
+				}
+				assert.NotContains(t, client.objects, "prefix/weak")
+
+				retention := &ObjectRetention{Mode: "COMPLIANCE", RetainUntil: time.Now().AddDate(2, 0, 0).Round(time.Second)}
+				small.objectRetention = retention
+				large.objectRetention = retention
+				require.NoError(t, b.Put(ctx, "strict", strings.NewReader("data")))
+
+				stored, err := small.GetObjectRetention(ctx, "strict")
+				require.NoError(t, err)
+				require.NotNil(t, stored)
+				assert.Equal(t, "COMPLIANCE", stored.Mode)
+				assert.True(t, retention.RetainUntil.Equal(stored.RetainUntil))
+			})
+		}
+	})
+	t.Run("RetainForIsRelativeToEachWrite", func(t *testing.T) {
+		client := newMockS3Client()
+		b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+		b.objectLockConfig = &objectLockConfigCache{}
+		b.objectRetention = &ObjectRetention{Mode: "GOVERNANCE", RetainFor: 24 * time.Hour}
+		require.NoError(t, b.objectRetention.validate())
+
+		for _, key := range []string{"first", "second"} {
+			before := time.Now()
+			require.NoError(t, b.Put(ctx, key, strings.NewReader("data")))
+			stored, err := b.GetObjectRetention(ctx, key)
+			require.NoError(t, err)
+			require.NotNil(t, stored)
+			assert.False(t, stored.RetainUntil.Before(before.Add(24*time.Hour)), key)
+			assert.False(t, stored.RetainUntil.After(time.Now().Add(24*time.Hour)), key)
+		}
+		assert.Equal(t, 1, client.getObjectLockCalls)
+	})
+	t.Run("InvalidRetention", func(t *testing.T) {
+		for _, retention := range []ObjectRetention{
+			{Mode: "GOVERNANCE"},
+			{Mode: "GOVERNANCE", RetainUntil: time.Now(), RetainFor: time.Hour},
+			{Mode: "GOVERNANCE", RetainFor: -time.Hour},
+			{Mode: "NONE", RetainFor: time.Hour},
+		} {
+			assert.Error(t, retention.validate())
+		}
+	})
+	t.Run("UploadReaderAtSetsRetention", func(t *testing.T) {
+		client := newMockS3Client()
+		b := &s3BucketLarge{s3Bucket: *newMockS3Bucket(client, "prefix"), minPartSize: 1024 * 1024 * 5}
+		b.objectRetention = &ObjectRetention{Mode: "COMPLIANCE", RetainFor: time.Hour}
+
+		data := []byte("data")
+		require.NoError(t, b.UploadReaderAt(ctx, "key", bytes.NewReader(data), int64(len(data))))
+		stored, err := b.GetObjectRetention(ctx, "key")
+		require.NoError(t, err)
+		require.NotNil(t, stored)
+		assert.Equal(t, "COMPLIANCE", stored.Mode)
+		assert.True(t, stored.IsActive())
+	})
+}
+
+// trackingReadCloser records how many bytes have been read from the
+// underlying reader and whether it has been closed.
+type trackingReadCloser struct {
+	io.Reader
+	read   int
+	closed bool
+}
+
+func (r *trackingReadCloser) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	r.read += n
+	return n, err
+}
+
+func (r *trackingReadCloser) Close() error {
+	r.closed = true
+	return nil
+}
+
+func TestS3StreamingDecompression(t *testing.T) {
+	payload := &bytes.Buffer{}
+	for i := 0; payload.Len() < 8*1024*1024; i++ {
+		fmt.Fprintf(payload, "log line %d: the quick brown fox jumps over the lazy dog\n", i)
+	}
+
+	compress := map[string]func(w io.Writer) io.WriteCloser{
+		"gzip": func(w io.Writer) io.WriteCloser { return gzip.NewWriter(w) },
+		"zstd": func(w io.Writer) io.WriteCloser {
+			enc, err := zstd.NewWriter(w)
+			require.NoError(t, err)
+			return enc
+		},
+	}
+	for encoding, newWriter := range compress {
+		t.Run(encoding, func(t *testing.T) {
+			compressed := &bytes.Buffer{}
+			w := newWriter(compressed)
+			_, err := w.Write(payload.Bytes())
+			require.NoError(t, err)
+			require.NoError(t, w.Close())
+
+			body := &trackingReadCloser{Reader: bytes.NewReader(compressed.Bytes())}
+			r, err := newMockS3Bucket(newMockS3Client(), "").newDecompressingReader(encoding, nil, false, body)
+			require.NoError(t, err)
+
+			chunk := make([]byte, 1024)
+			_, err = io.ReadFull(r, chunk)
+			require.NoError(t, err)
+			assert.Less(t, body.read, compressed.Len()/2, "reading the first chunk should not read the whole object")
+
+			rest, err := io.ReadAll(r)
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(payload.Bytes(), append(chunk, rest...)))
+
+			assert.False(t, body.closed)
+			require.NoError(t, r.Close())
+			assert.True(t, body.closed)
+		})
+	}
+}
+
+func TestS3ZstdCompression(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dict, err := os.ReadFile(filepath.Join("testdata", "zstd_json.dict"))
+	require.NoError(t, err)
+
+	newBucket := func(t *testing.T, client *mockS3Client, dict []byte) *s3BucketSmall {
+		b := newMockS3Bucket(client, "prefix")
+		b.compress = true
+		b.compressionAlgorithm = CompressionZstd
+		if len(dict) > 0 {
+			dictionaryID, err := zstdDictionaryID(dict)
+			require.NoError(t, err)
+			b.compressionDictionary = dict
+			b.compressionDictionaryID = dictionaryID
+		}
+		return &s3BucketSmall{s3Bucket: *b}
+	}
+	// Small JSON documents with the same shape as those the dictionary was
+	// trained on.
+	docs := map[string]string{}
+	for i := 0; i < 20; i++ {
+		docs[fmt.Sprintf("doc%d.json", i)] = fmt.Sprintf(`{"task_id": "evergreen_ubuntu2204_compile_patch_%08x", "status": "success", "host": {"distro": "rhel80-large", "provider": "ec2-fleet"}, "start_time": "2024-05-1%dT03:00:00Z", "duration_ms": %d, "tags": ["nightly", "compile", "required"]}`, i*7919, i%10, 1000+i*37)
+	}
+	totalSize := func(client *mockS3Client) int {
+		size := 0
+		for _, obj := range client.objects {
+			size += len(obj.data)
+		}
+		return size
+	}
+
+	t.Run("DictionaryImprovesCompression", func(t *testing.T) {
+		plainClient := newMockS3Client()
+		plain := newBucket(t, plainClient, nil)
+		dictClient := newMockS3Client()
+		withDict := newBucket(t, dictClient, dict)
+		for key, doc := range docs {
+			require.NoError(t, plain.Put(ctx, key, strings.NewReader(doc)))
+			require.NoError(t, withDict.Put(ctx, key, strings.NewReader(doc)))
+		}
+
+		assert.Less(t, totalSize(dictClient), totalSize(plainClient))
+		for key, doc := range docs {
+			data, err := readDataFromFile(ctx, plain, key)
+			require.NoError(t, err)
+			assert.Equal(t, doc, data)
+			data, err = readDataFromFile(ctx, withDict, key)
+			require.NoError(t, err)
+			assert.Equal(t, doc, data)
+		}
+	})
+	t.Run("RecordsDictionaryInMetadata", func(t *testing.T) {
+		client := newMockS3Client()
+		b := newBucket(t, client, dict)
+		require.NoError(t, b.Put(ctx, "doc.json", strings.NewReader(docs["doc0.json"])))
+
+		obj := client.objects["prefix/doc.json"]
+		require.NotNil(t, obj)
+		assert.Equal(t, string(CompressionZstd), obj.contentEncoding)
+		assert.Equal(t, b.compressionDictionaryID, obj.metadata[zstdDictionaryMetadataKey])
+	})
+	t.Run("ReadWithoutDictionaryFails", func(t *testing.T) {
+		client := newMockS3Client()
+		require.NoError(t, newBucket(t, client, dict).Put(ctx, "doc.json", strings.NewReader(docs["doc0.json"])))
+
+		_, err := newBucket(t, client, nil).Get(ctx, "doc.json")
+		assert.Error(t, err)
+	})
+	t.Run("ReadWithDifferentDictionaryFails", func(t *testing.T) {
+		client := newMockS3Client()
+		require.NoError(t, newBucket(t, client, dict).Put(ctx, "doc.json", strings.NewReader(docs["doc0.json"])))
+
+		other := append([]byte{}, dict...)
+		other[4]++
+		_, err := newBucket(t, client, other).Get(ctx, "doc.json")
+		assert.Error(t, err)
+	})
+	t.Run("LargeBucketRecordsDictionary", func(t *testing.T) {
+		client := newMockS3Client()
+		small := newBucket(t, client, dict)
+		large := &s3BucketLarge{s3Bucket: small.s3Bucket, minPartSize: 1024 * 1024 * 5}
+		require.NoError(t, large.Put(ctx, "doc.json", strings.NewReader(docs["doc0.json"])))
+
+		obj := client.objects["prefix/doc.json"]
+		require.NotNil(t, obj)
+		assert.Equal(t, small.compressionDictionaryID, obj.metadata[zstdDictionaryMetadataKey])
+		data, err := readDataFromFile(ctx, large, "doc.json")
+		require.NoError(t, err)
+		assert.Equal(t, docs["doc0.json"], data)
+	})
+	t.Run("InvalidDictionaryFails", func(t *testing.T) {
+		_, err := zstdDictionaryID([]byte("not a dictionary"))
+		assert.Error(t, err)
+	})
+}
+
+// attemptsRecordingClient records the maximum number of attempts that the
+// per-operation options passed to GetObject and PutObject would configure.
+type attemptsRecordingClient struct {
+	*mockS3Client
+	getAttempts int
+	putAttempts int
+}
+
+func mockMaxAttempts(optFns []func(*s3.Options)) int {
+	var opts s3.Options
+	for _, fn := range optFns {
+		fn(&opts)
+	}
+	if opts.Retryer == nil {
+		return 0
+	}
+	return opts.Retryer.MaxAttempts()
+}
+
+func (c *attemptsRecordingClient) GetObject(ctx context.Context, input *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	c.getAttempts = mockMaxAttempts(optFns)
+	return c.mockS3Client.GetObject(ctx, input, optFns...)
+}
+
+func (c *attemptsRecordingClient) PutObject(ctx context.Context, input *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	c.putAttempts = mockMaxAttempts(optFns)
+	return c.mockS3Client.PutObject(ctx, input, optFns...)
+}
+
+func TestS3ReadWriteRetryPolicies(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	recorder := &attemptsRecordingClient{mockS3Client: newMockS3Client()}
+	b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(recorder.mockS3Client, "prefix")}
+	b.svc = &retryPolicyClient{s3Client: recorder, readAttempts: 7, writeAttempts: 2}
+
+	require.NoError(t, b.Put(ctx, "key", strings.NewReader("data")))
+	assert.Equal(t, 2, recorder.putAttempts)
+
+	data, err := b.Get(ctx, "key")
+	require.NoError(t, err)
+	_, err = io.ReadAll(data)
+	require.NoError(t, err)
+	require.NoError(t, data.Close())
+	assert.Equal(t, 7, recorder.getAttempts)
+
+	t.Run("UnsetPolicyUsesDefault", func(t *testing.T) {
+		b.svc = &retryPolicyClient{s3Client: recorder, readAttempts: 7}
+
+		require.NoError(t, b.Put(ctx, "key", strings.NewReader("data")))
+		assert.Zero(t, recorder.putAttempts)
+	})
+}
+
+// mockLifecycleRules are lifecycle rules that expire logs after 400 days and
+// archived logs, which are transitioned to cheaper storage classes in the
+// meantime, after a year. Releases are expired on a fixed date.
+var mockLifecycleRules = []s3Types.LifecycleRule{
+	{
+		ID:         aws.String("expire-logs"),
+		Status:     s3Types.ExpirationStatusEnabled,
+		Filter:     &s3Types.LifecycleRuleFilterMemberPrefix{Value: "prefix/logs/"},
+		Expiration: &s3Types.LifecycleExpiration{Days: aws.Int32(400)},
+	},
+	{
+		ID:         aws.String("expire-archived-logs"),
+		Status:     s3Types.ExpirationStatusEnabled,
+		Filter:     &s3Types.LifecycleRuleFilterMemberPrefix{Value: "prefix/logs/archive/"},
+		Expiration: &s3Types.LifecycleExpiration{Days: aws.Int32(365)},
+		Transitions: []s3Types.Transition{
+			{Days: aws.Int32(30), StorageClass: s3Types.TransitionStorageClassStandardIa},
+			{Days: aws.Int32(90), StorageClass: s3Types.TransitionStorageClassGlacier},
+		},
+	},
+	{
+		ID:     aws.String("expire-temporary-logs"),
+		Status: s3Types.ExpirationStatusEnabled,
+		Filter: &s3Types.LifecycleRuleFilterMemberAnd{Value: s3Types.LifecycleRuleAndOperator{
+			Prefix: aws.String("prefix/logs/"),
+			Tags:   []s3Types.Tag{{Key: aws.String("temporary"), Value: aws.String("true")}},
+		}},
+		Expiration: &s3Types.LifecycleExpiration{Days: aws.Int32(1)},
+	},
+	{
+		ID:         aws.String("expire-large-objects"),
+		Status:     s3Types.ExpirationStatusEnabled,
+		Filter:     &s3Types.LifecycleRuleFilterMemberObjectSizeGreaterThan{Value: 1024},
+		Expiration: &s3Types.LifecycleExpiration{Days: aws.Int32(1)},
+	},
+	{
+		ID:         aws.String("expire-releases"),
+		Status:     s3Types.ExpirationStatusEnabled,
+		Filter:     &s3Types.LifecycleRuleFilterMemberPrefix{Value: "prefix/releases/"},
+		Expiration: &s3Types.LifecycleExpiration{Date: aws.Time(time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC))},
+	},
+	{
+		ID:         aws.String("disabled"),
+		Status:     s3Types.ExpirationStatusDisabled,
+		Filter:     &s3Types.LifecycleRuleFilterMemberPrefix{Value: "prefix/"},
+		Expiration: &s3Types.LifecycleExpiration{Days: aws.Int32(1)},
+	},
+}
+
+func TestFindMatchingRules(t *testing.T) {
+	rules := convertLifecycleRules(mockLifecycleRules)
+
+	for _, test := range []struct {
+		key      string
+		expected []string
+	}{
+		{key: "prefix/logs/task.log", expected: []string{"expire-logs"}},
+		{key: "prefix/logs/archive/task.log", expected: []string{"expire-logs", "expire-archived-logs"}},
+		{key: "prefix/releases/v1.tgz", expected: []string{"expire-releases"}},
+		{key: "prefix/artifacts/task.tgz"},
+	} {
+		t.Run(test.key, func(t *testing.T) {
+			var ids []string
+			for _, rule := range FindMatchingRules(rules, test.key) {
+				ids = append(ids, rule.ID)
+			}
+			assert.Equal(t, test.expected, ids)
+		})
+	}
+	t.Run("TagAndSizeFiltersAreMarked", func(t *testing.T) {
+		for _, rule := range rules {
+			filtered := rule.ID == "expire-temporary-logs" || rule.ID == "expire-large-objects"
+			assert.Equal(t, filtered, rule.FilteredByTagsOrSize, rule.ID)
+		}
+	})
+}
+
+func TestPredictLifecycleTransitions(t *testing.T) {
+	created := time.Date(2024, 1, 1, 15, 30, 0, 0, time.UTC)
+
+	t.Run("ColdestClassWinsOnTheSameDate", func(t *testing.T) {
+		rules := []LifecycleRule{
+			{Transitions: []LifecycleTransition{{Days: 30, StorageClass: "STANDARD_IA"}}},
+			{Transitions: []LifecycleTransition{{Days: 30, StorageClass: "GLACIER"}}},
+		}
+		assert.Equal(t, []PredictedTransition{
+			{StorageClass: "GLACIER", Date: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		}, predictTransitions(rules, created))
+	})
+	t.Run("NeverTransitionsToWarmerClass", func(t *testing.T) {
+		rules := []LifecycleRule{
+			{Transitions: []LifecycleTransition{{Days: 30, StorageClass: "GLACIER"}}},
+			{Transitions: []LifecycleTransition{{Days: 60, StorageClass: "STANDARD_IA"}}},
+		}
+		assert.Equal(t, []PredictedTransition{
+			{StorageClass: "GLACIER", Date: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		}, predictTransitions(rules, created))
+	})
+	t.Run("ExpiryOfAnyRuleEndsTransitions", func(t *testing.T) {
+		rules := []LifecycleRule{
+			{Transitions: []LifecycleTransition{
+				{Days: 30, StorageClass: "STANDARD_IA"},
+				{Days: 120, StorageClass: "DEEP_ARCHIVE"},
+			}},
+			{ExpirationDays: 100},
+		}
+		assert.Equal(t, []PredictedTransition{
+			{StorageClass: "STANDARD_IA", Date: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+		}, predictTransitions(rules, created))
+	})
+	t.Run("TransitionDate", func(t *testing.T) {
+		date := time.Date(2024, 3, 15, 0, 0, 0, 0, time.UTC)
+		rules := []LifecycleRule{
+			{Transitions: []LifecycleTransition{{Date: &date, StorageClass: "GLACIER"}}},
+		}
+		assert.Equal(t, []PredictedTransition{{StorageClass: "GLACIER", Date: date}}, predictTransitions(rules, created))
+	})
+}
+
+func TestS3GetWithInfoExpiresAt(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newMockS3Client()
+	b := newMockS3Bucket(client, "prefix")
+	client.putObject("prefix/expiring", []byte("data"), mockS3Object{
+		expiration: `expiry-date="Fri, 23 Dec 2012 00:00:00 GMT", rule-id="logs-expiration"`,
+	})
+	client.putObject("prefix/permanent", []byte("data"), mockS3Object{})
+
+	t.Run("ParsesExpirationHeader", func(t *testing.T) {
+		r, info, err := b.GetWithInfo(ctx, "expiring")
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		require.NotNil(t, info.ExpiresAt)
+		assert.Equal(t, time.Date(2012, 12, 23, 0, 0, 0, 0, time.UTC), info.ExpiresAt.UTC())
+	})
+	t.Run("NoExpirationHeader", func(t *testing.T) {
+		r, info, err := b.GetWithInfo(ctx, "permanent")
+		require.NoError(t, err)
+		require.NoError(t, r.Close())
+		assert.Nil(t, info.ExpiresAt)
+	})
+	t.Run("MalformedExpirationHeader", func(t *testing.T) {
+		for _, header := range []string{`rule-id="rule"`, `expiry-date="tomorrow", rule-id="rule"`, `expiry-date="Fri, 23 Dec 2012`} {
+			assert.Nil(t, parseExpirationHeader(header), header)
+		}
+	})
+}
+
+func TestS3PredictExpiry(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newMockS3Client()
+	b := newMockS3Bucket(client, "prefix")
+	created := time.Date(2024, 1, 1, 15, 30, 0, 0, time.UTC)
+	for _, key := range []string{"logs/task.log", "logs/archive/task.log", "releases/v1.tgz", "artifacts/task.tgz"} {
+		obj := client.putObject("prefix/"+key, []byte("data"), mockS3Object{})
+		obj.lastModified = created
+		client.objects["prefix/"+key] = obj
+	}
+
+	t.Run("NoLifecycleConfiguration", func(t *testing.T) {
+		expiry, err := b.PredictExpiry(ctx, "logs/task.log")
+		require.NoError(t, err)
+		assert.Nil(t, expiry)
+	})
+
+	client.lifecycle = mockLifecycleRules
+
+	t.Run("RoundsUpToMidnight", func(t *testing.T) {
+		expiry, err := b.PredictExpiry(ctx, "logs/task.log")
+		require.NoError(t, err)
+		require.NotNil(t, expiry)
+		assert.Equal(t, time.Date(2025, 2, 5, 0, 0, 0, 0, time.UTC), *expiry)
+	})
+	t.Run("EarliestOfOverlappingRules", func(t *testing.T) {
+		expiry, err := b.PredictExpiry(ctx, "logs/archive/task.log")
+		require.NoError(t, err)
+		require.NotNil(t, expiry)
+		assert.Equal(t, time.Date(2025, 1, 1, 0, 0, 0, 0, time.UTC), *expiry)
+	})
+	t.Run("ExpirationDate", func(t *testing.T) {
+		expiry, err := b.PredictExpiry(ctx, "releases/v1.tgz")
+		require.NoError(t, err)
+		require.NotNil(t, expiry)
+		assert.Equal(t, time.Date(2024, 6, 1, 0, 0, 0, 0, time.UTC), *expiry)
+	})
+	t.Run("NoMatchingRule", func(t *testing.T) {
+		expiry, err := b.PredictExpiry(ctx, "artifacts/task.tgz")
+		require.NoError(t, err)
+		assert.Nil(t, expiry)
+	})
+}
+
+func TestS3PredictTransitions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newMockS3Client()
+	client.lifecycle = mockLifecycleRules
+	b := newMockS3Bucket(client, "prefix")
+	created := time.Date(2024, 1, 1, 15, 30, 0, 0, time.UTC)
+	for _, key := range []string{"logs/task.log", "logs/archive/task.log"} {
+		obj := client.putObject("prefix/"+key, []byte("data"), mockS3Object{})
+		obj.lastModified = created
+		client.objects["prefix/"+key] = obj
+	}
+
+	t.Run("InfrequentAccessThenGlacier", func(t *testing.T) {
+		transitions, err := b.PredictTransitions(ctx, "logs/archive/task.log")
+		require.NoError(t, err)
+		assert.Equal(t, []PredictedTransition{
+			{StorageClass: "STANDARD_IA", Date: time.Date(2024, 2, 1, 0, 0, 0, 0, time.UTC)},
+			{StorageClass: "GLACIER", Date: time.Date(2024, 4, 1, 0, 0, 0, 0, time.UTC)},
+		}, transitions)
+	})
+	t.Run("RuleWithoutTransitions", func(t *testing.T) {
+		transitions, err := b.PredictTransitions(ctx, "logs/task.log")
+		require.NoError(t, err)
+		assert.Empty(t, transitions)
+	})
+}
+
+func TestS3CopyLifecycleConfiguration(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	srcClient := newMockS3Client()
+	src := &s3BucketSmall{s3Bucket: *newMockS3Bucket(srcClient, "prefix")}
+	destClient := newMockS3Client()
+	dest := &s3BucketLarge{s3Bucket: *newMockS3Bucket(destClient, "prefix"), minPartSize: 1024 * 1024 * 5}
+
+	t.Run("NoSourceLifecycleConfiguration", func(t *testing.T) {
+		require.NoError(t, CopyLifecycleConfiguration(ctx, src, dest))
+		assert.Nil(t, destClient.lifecycle)
+	})
+	t.Run("CopiesRules", func(t *testing.T) {
+		srcClient.lifecycle = mockLifecycleRules
+		require.NoError(t, CopyLifecycleConfiguration(ctx, src, dest))
+
+		rules, err := dest.GetLifecycleRules(ctx)
+		require.NoError(t, err)
+		assert.Equal(t, convertLifecycleRules(mockLifecycleRules), rules)
+	})
+	t.Run("NonS3Bucket", func(t *testing.T) {
+		local, err := NewLocalBucket(LocalOptions{Path: t.TempDir()})
+		require.NoError(t, err)
+		assert.Error(t, CopyLifecycleConfiguration(ctx, src, local))
+	})
+}
+
+func TestS3LenientGzip(t *testing.T) {
+	payload := make([]byte, 256*1024)
+	_, err := rand.Read(payload)
+	require.NoError(t, err)
+	compressed := &bytes.Buffer{}
+	w := gzip.NewWriter(compressed)
+	_, err = w.Write(payload)
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	// Only the beginning of the compressed object is stored, as when an
+	// upload was cut short.
+	truncated := compressed.Bytes()[:compressed.Len()/2]
+
+	for _, test := range []struct {
+		name    string
+		lenient bool
+	}{
+		{name: "Strict"},
+		{name: "Lenient", lenient: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b := newMockS3Bucket(newMockS3Client(), "")
+			b.lenientGzip = test.lenient
+
+			t.Run("EarlyClose", func(t *testing.T) {
+				body := &trackingReadCloser{Reader: bytes.NewReader(compressed.Bytes())}
+				r, err := b.newDecompressingReader("gzip", nil, false, body)
+				require.NoError(t, err)
+				head := make([]byte, 1024)
+				_, err = io.ReadFull(r, head)
+				require.NoError(t, err)
+				assert.Equal(t, payload[:len(head)], head)
+
+				if test.lenient {
+					assert.NoError(t, r.Close())
+				} else {
+					err = r.Close()
+					require.Error(t, err)
+					assert.Contains(t, err.Error(), io.ErrUnexpectedEOF.Error())
+				}
+				assert.True(t, body.closed)
+			})
+			t.Run("FullRead", func(t *testing.T) {
+				body := &trackingReadCloser{Reader: bytes.NewReader(compressed.Bytes())}
+				r, err := b.newDecompressingReader("gzip", nil, false, body)
+				require.NoError(t, err)
+				data, err := io.ReadAll(r)
+				require.NoError(t, err)
+				assert.Equal(t, payload, data)
+
+				assert.NoError(t, r.Close())
+				assert.True(t, body.closed)
+			})
+			t.Run("Truncated", func(t *testing.T) {
+				body := &trackingReadCloser{Reader: bytes.NewReader(truncated)}
+				r, err := b.newDecompressingReader("gzip", nil, false, body)
+				require.NoError(t, err)
+				data, err := io.ReadAll(r)
+				assert.True(t, bytes.HasPrefix(payload, data))
+				assert.NotEmpty(t, data)
+
+				if test.lenient {
+					assert.NoError(t, err)
+					assert.NoError(t, r.Close())
+				} else {
+					assert.ErrorIs(t, err, io.ErrUnexpectedEOF)
+					err = r.Close()
+					require.Error(t, err)
+					assert.Contains(t, err.Error(), io.ErrUnexpectedEOF.Error())
+				}
+				assert.True(t, body.closed)
+			})
+		})
+	}
+	t.Run("LenientValidatesFullReads", func(t *testing.T) {
+		b := newMockS3Bucket(newMockS3Client(), "")
+		b.lenientGzip = true
+		corrupt := append([]byte{}, compressed.Bytes()...)
+		// Corrupt the CRC-32 in the gzip footer.
+		corrupt[len(corrupt)-8] ^= 0xff
+
+		r, err := b.newDecompressingReader("gzip", nil, false, io.NopCloser(bytes.NewReader(corrupt)))
+		require.NoError(t, err)
+		_, err = io.ReadAll(r)
+		assert.ErrorIs(t, err, gzip.ErrChecksum)
+		assert.NoError(t, r.Close())
+	})
+}
+
+func TestS3CredentialSource(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	credsFile := filepath.Join(dir, "credentials")
+	require.NoError(t, os.WriteFile(credsFile, []byte("[default]\naws_access_key_id = file-key\naws_secret_access_key = file-secret\n"), 0600))
+	// Isolate the tests from the user's own AWS configuration.
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "missing"))
+	t.Setenv("AWS_PROFILE", "")
+	t.Setenv("AWS_CA_BUNDLE", "")
+	t.Setenv("AWS_ACCESS_KEY_ID", "env-key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "env-secret")
+
+	explicit := CreateAWSCredentials("explicit-key", "explicit-secret", "")
+	accessKeyID := func(t *testing.T, opts S3Options) string {
+		opts.Name = "bucket"
+		opts.Region = "us-east-1"
+		// A non-default HTTP client prevents the AWS config from being
+		// cached across tests with different environments.
+		b, err := newS3BucketBase(ctx, &http.Client{}, opts)
+		require.NoError(t, err)
+		creds, err := sdkClient(t, b.svc).Options().Credentials.Retrieve(ctx)
+		require.NoError(t, err)
+		return creds.AccessKeyID
+	}
+
+	t.Run("ChainPrefersExplicitCredentials", func(t *testing.T) {
+		assert.Equal(t, "explicit-key", accessKeyID(t, S3Options{Credentials: explicit}))
+	})
+	t.Run("ChainUsesEnvironment", func(t *testing.T) {
+		assert.Equal(t, "env-key", accessKeyID(t, S3Options{}))
+		assert.Equal(t, "env-key", accessKeyID(t, S3Options{SharedCredentialsFilepath: credsFile}))
+	})
+	t.Run("Explicit", func(t *testing.T) {
+		assert.Equal(t, "explicit-key", accessKeyID(t, S3Options{CredentialSource: CredentialSourceExplicit, Credentials: explicit}))
+
+		_, err := newS3BucketBase(ctx, &http.Client{}, S3Options{Name: "bucket", CredentialSource: CredentialSourceExplicit})
+		assert.Error(t, err)
+	})
+	t.Run("Env", func(t *testing.T) {
+		assert.Equal(t, "env-key", accessKeyID(t, S3Options{CredentialSource: CredentialSourceEnv, Credentials: explicit}))
+	})
+	t.Run("SharedFile", func(t *testing.T) {
+		assert.Equal(t, "file-key", accessKeyID(t, S3Options{CredentialSource: CredentialSourceSharedFile, SharedCredentialsFilepath: credsFile, Credentials: explicit}))
+
+		_, err := newS3BucketBase(ctx, &http.Client{}, S3Options{Name: "bucket", CredentialSource: CredentialSourceSharedFile, SharedCredentialsFilepath: filepath.Join(dir, "missing")})
+		assert.Error(t, err)
+	})
+	t.Run("InvalidSource", func(t *testing.T) {
+		_, err := newS3BucketBase(ctx, &http.Client{}, S3Options{Name: "bucket", CredentialSource: "magic"})
+		assert.Error(t, err)
+	})
+}
+
+func TestS3ZeroByteObjects(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for name, newBucket := range map[string]func(*mockS3Client) Bucket{
+		"Small": func(client *mockS3Client) Bucket {
+			return &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+		},
+		"Large": func(client *mockS3Client) Bucket {
+			return &s3BucketLarge{s3Bucket: *newMockS3Bucket(client, "prefix"), minPartSize: 1024 * 1024 * 5}
+		},
+		"Parallel": func(client *mockS3Client) Bucket {
+			b, err := NewParallelSyncBucket(ParallelBucketOptions{Workers: 4}, &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")})
+			require.NoError(t, err)
+			return b
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := newMockS3Client()
+			b := newBucket(client)
+			dir := t.TempDir()
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "empty"), nil, 0600))
+			require.NoError(t, os.WriteFile(filepath.Join(dir, "full"), []byte("data"), 0600))
+
+			require.NoError(t, b.Put(ctx, "put", bytes.NewReader(nil)))
+			require.NoError(t, b.Upload(ctx, "upload", filepath.Join(dir, "empty")))
+			require.NoError(t, b.Push(ctx, SyncOptions{Local: dir, Remote: "push"}))
+
+			for _, key := range []string{"put", "upload", "push/empty"} {
+				obj, ok := client.objects["prefix/"+key]
+				require.True(t, ok, "zero-byte object '%s' should be uploaded", key)
+				assert.Empty(t, obj.data)
+
+				r, err := b.Get(ctx, key)
+				require.NoError(t, err)
+				data, err := io.ReadAll(r)
+				require.NoError(t, err)
+				require.NoError(t, r.Close())
+				assert.Empty(t, data)
+			}
+			assert.Contains(t, client.objects, "prefix/push/full")
+		})
+	}
+}
+
+func TestS3DetectContentType(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	png := append([]byte("\x89PNG\r\n\x1a\n"), bytes.Repeat([]byte{0}, 1024)...)
+	for name, newBucket := range map[string]func(*mockS3Client) Bucket{
+		"Small": func(client *mockS3Client) Bucket {
+			return &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+		},
+		"Large": func(client *mockS3Client) Bucket {
+			// A small part size ensures the multipart upload is
+			// created before all of the data is written.
+			return &s3BucketLarge{s3Bucket: *newMockS3Bucket(client, "prefix"), minPartSize: 16}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := newMockS3Client()
+			b := newBucket(client)
+			setDetect := func(detect bool, contentType string) {
+				switch bucket := b.(type) {
+				case *s3BucketSmall:
+					bucket.detectContentType, bucket.contentType = detect, contentType
+				case *s3BucketLarge:
+					bucket.detectContentType, bucket.contentType = detect, contentType
+				}
+			}
+
+			t.Run("DetectsImage", func(t *testing.T) {
+				setDetect(true, "")
+				w, err := b.Writer(ctx, "image")
+				require.NoError(t, err)
+				// Hide the reader's WriteTo method so that the data
+				// is written in small chunks.
+				_, err = io.CopyBuffer(w, struct{ io.Reader }{bytes.NewReader(png)}, make([]byte, 100))
+				require.NoError(t, err)
+				require.NoError(t, w.Close())
+				assert.Equal(t, "image/png", client.objects["prefix/image"].contentType)
+			})
+			t.Run("UnrecognizedUsesContentType", func(t *testing.T) {
+				setDetect(true, "application/x-custom")
+				require.NoError(t, b.Put(ctx, "custom", bytes.NewReader([]byte{0, 1, 2, 3})))
+				assert.Equal(t, "application/x-custom", client.objects["prefix/custom"].contentType)
+			})
+			t.Run("DisabledUsesContentType", func(t *testing.T) {
+				setDetect(false, "application/x-custom")
+				require.NoError(t, b.Put(ctx, "image", bytes.NewReader(png)))
+				assert.Equal(t, "application/x-custom", client.objects["prefix/image"].contentType)
+			})
+		})
+	}
+}
+
+// pooledTransport returns the HTTP transport that the bucket's S3 client
+// sends requests with.
+func pooledTransport(t *testing.T, b *s3Bucket) *http.Transport {
+	client, ok := sdkClient(t, b.svc).Options().HTTPClient.(*http.Client)
+	require.True(t, ok)
+	transport, ok := client.Transport.(*http.Transport)
+	require.True(t, ok)
+	return transport
+}
+
+func TestS3ConnectionPoolOptions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	t.Run("ConfiguresTransport", func(t *testing.T) {
+		b, err := newS3BucketBase(ctx, nil, S3Options{
+			Name:                "bucket",
+			Region:              "us-east-1",
+			MaxIdleConns:        200,
+			MaxIdleConnsPerHost: 50,
+			IdleConnTimeout:     time.Minute,
+		})
+		require.NoError(t, err)
+
+		transport := pooledTransport(t, b)
+		assert.Equal(t, 200, transport.MaxIdleConns)
+		assert.Equal(t, 50, transport.MaxIdleConnsPerHost)
+		assert.Equal(t, time.Minute, transport.IdleConnTimeout)
+	})
+	t.Run("SharesTransportBetweenBuckets", func(t *testing.T) {
+		opts := S3Options{Name: "bucket", Region: "us-east-1", MaxIdleConns: 300}
+		first, err := newS3BucketBase(ctx, nil, opts)
+		require.NoError(t, err)
+		opts.Region = "us-west-2"
+		second, err := newS3BucketBase(ctx, nil, opts)
+		require.NoError(t, err)
+
+		assert.Same(t, pooledTransport(t, first), pooledTransport(t, second))
+
+		opts.MaxIdleConns = 400
+		other, err := newS3BucketBase(ctx, nil, opts)
+		require.NoError(t, err)
+		assert.NotSame(t, pooledTransport(t, first), pooledTransport(t, other))
+		assert.Equal(t, 400, pooledTransport(t, other).MaxIdleConns)
+	})
+	t.Run("KeepsCustomCABundle", func(t *testing.T) {
+		srv := httptest.NewTLSServer(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+		defer srv.Close()
+		bundle := filepath.Join(t.TempDir(), "ca.pem")
+		require.NoError(t, os.WriteFile(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: srv.Certificate().Raw}), 0600))
+		t.Setenv("AWS_CA_BUNDLE", bundle)
+
+		b, err := newS3BucketBase(ctx, nil, S3Options{Name: "bucket", Region: "us-east-1", MaxIdleConns: 500})
+		require.NoError(t, err)
+		resp, err := (&http.Client{Transport: pooledTransport(t, b)}).Get(srv.URL)
+		require.NoError(t, err)
+		require.NoError(t, resp.Body.Close())
+	})
+	t.Run("ExplicitClientTakesPrecedence", func(t *testing.T) {
+		t.Setenv("AWS_CA_BUNDLE", "")
+		httpClient := &http.Client{}
+		b, err := newS3BucketBase(ctx, httpClient, S3Options{Name: "bucket", Region: "us-east-1", MaxIdleConns: 200})
+		require.NoError(t, err)
+		assert.Same(t, httpClient, sdkClient(t, b.svc).Options().HTTPClient)
+	})
+	t.Run("DefaultsWithoutOptions", func(t *testing.T) {
+		b, err := newS3BucketBase(ctx, nil, S3Options{Name: "bucket", Region: "us-east-1"})
+		require.NoError(t, err)
+		assert.IsType(t, &awshttp.BuildableClient{}, sdkClient(t, b.svc).Options().HTTPClient)
+	})
+}
+
+func TestS3Touch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newMockS3Client()
+	b := newMockS3Bucket(client, "prefix")
+	past := time.Now().Add(-time.Hour)
+	obj := client.putObject("prefix/marker", []byte("marker"), mockS3Object{
+		contentType:  "text/plain",
+		storageClass: s3Types.StorageClassStandardIa,
+		metadata:     map[string]string{"owner": "evergreen"},
+	})
+	obj.lastModified = past
+	client.objects["prefix/marker"] = obj
+
+	require.NoError(t, b.Touch(ctx, "marker"))
+	touched := client.objects["prefix/marker"]
+	assert.True(t, touched.lastModified.After(past))
+	assert.Equal(t, []byte("marker"), touched.data)
+	assert.Equal(t, "text/plain", touched.contentType)
+	assert.Equal(t, s3Types.StorageClassStandardIa, touched.storageClass)
+	assert.Equal(t, map[string]string{"owner": "evergreen"}, touched.metadata)
+
+	t.Run("MissingKey", func(t *testing.T) {
+		err := b.Touch(ctx, "missing")
+		require.Error(t, err)
+		assert.True(t, IsKeyNotFoundError(err))
+	})
+	t.Run("KeepsHeadersAndTags", func(t *testing.T) {
+		expires := time.Now().Add(24 * time.Hour).UTC().Truncate(time.Second)
+		client.putObject("prefix/headers", []byte("data"), mockS3Object{
+			cacheControl:       "max-age=60",
+			contentDisposition: "attachment",
+			contentLanguage:    "en",
+			expires:            &expires,
+			tagging:            "team=build",
+		})
+
+		require.NoError(t, b.Touch(ctx, "headers"))
+		touched := client.objects["prefix/headers"]
+		assert.Equal(t, "max-age=60", touched.cacheControl)
+		assert.Equal(t, "attachment", touched.contentDisposition)
+		assert.Equal(t, "en", touched.contentLanguage)
+		require.NotNil(t, touched.expires)
+		assert.True(t, expires.Equal(*touched.expires))
+		assert.Equal(t, "team=build", touched.tagging)
+	})
+	t.Run("CopiesLargeObjectsInParts", func(t *testing.T) {
+		client := newMockS3Client()
+		b := newMockS3Bucket(client, "prefix")
+		b.maxCopyBytes = 4
+		client.putObject("prefix/large", []byte("0123456789"), mockS3Object{
+			contentType:  "text/plain",
+			cacheControl: "no-cache",
+			metadata:     map[string]string{"owner": "evergreen"},
+			tagging:      "team=build",
+		})
+
+		require.NoError(t, b.Touch(ctx, "large"))
+		require.Len(t, client.uploadPartCopyCalls, 3)
+		assert.Equal(t, "bytes=8-9", aws.ToString(client.uploadPartCopyCalls[2].CopySourceRange))
+		assert.Empty(t, client.copyCalls)
+		touched := client.objects["prefix/large"]
+		assert.Equal(t, []byte("0123456789"), touched.data)
+		assert.Equal(t, "text/plain", touched.contentType)
+		assert.Equal(t, "no-cache", touched.cacheControl)
+		assert.Equal(t, map[string]string{"owner": "evergreen"}, touched.metadata)
+		assert.Equal(t, "team=build", touched.tagging)
+		assert.Empty(t, client.uploads, "upload should be completed")
+	})
+}
+
+func TestS3ListPrefixes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newMockS3Client()
+	client.listPageSize = 3
+	b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+	for i := 0; i < 10; i++ {
+		client.putObject(fmt.Sprintf("prefix/logs/log%d", i), []byte("log"), mockS3Object{})
+		client.putObject(fmt.Sprintf("prefix/artifacts/artifact%d", i), []byte("artifact"), mockS3Object{})
+	}
+
+	iter, err := ListPrefixes(ctx, b, []string{"logs/log1", "logs", "artifacts/artifact"})
+	require.NoError(t, err)
+	count, err := CountItems(ctx, iter)
+	require.NoError(t, err)
+	assert.Equal(t, 20, count)
+}
+
+func TestS3KeyValidation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "a-short"), []byte("data"), 0600))
+	longDir := dir
+	for i := 0; i < 6; i++ {
+		longDir = filepath.Join(longDir, strings.Repeat(fmt.Sprint(i), 200))
+	}
+	require.NoError(t, os.MkdirAll(longDir, 0700))
+	require.NoError(t, os.WriteFile(filepath.Join(longDir, "file"), []byte("data"), 0600))
+
+	for name, newBucket := range map[string]func(*mockS3Client) Bucket{
+		"Small": func(client *mockS3Client) Bucket {
+			return &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+		},
+		"Parallel": func(client *mockS3Client) Bucket {
+			b, err := NewParallelSyncBucket(ParallelBucketOptions{Workers: 4}, &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")})
+			require.NoError(t, err)
+			return b
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Run("PushFailsBeforeUploading", func(t *testing.T) {
+				client := newMockS3Client()
+				err := newBucket(client).Push(ctx, SyncOptions{Local: dir, Remote: "push"})
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, ErrInvalidKey))
+				assert.Contains(t, err.Error(), "exceeds the maximum of 1024 bytes")
+				assert.Contains(t, err.Error(), strings.Repeat("5", 200)+"/file")
+				assert.Empty(t, client.objects, "no objects should be uploaded")
+			})
+		})
+	}
+	t.Run("Put", func(t *testing.T) {
+		client := newMockS3Client()
+		b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+		b.maxKeyLength = 16
+
+		require.NoError(t, b.Put(ctx, "short", strings.NewReader("data")))
+		err := b.Put(ctx, "much-too-long-key", strings.NewReader("data"))
+		assert.True(t, errors.Is(err, ErrInvalidKey))
+
+		require.NoError(t, b.Put(ctx, "bad\xff", strings.NewReader("data")))
+		b.validateKeyUTF8 = true
+		err = b.Put(ctx, "bad\xff", strings.NewReader("data"))
+		assert.True(t, errors.Is(err, ErrInvalidKey))
+	})
+	t.Run("InvalidMaximum", func(t *testing.T) {
+		_, err := newS3BucketBase(ctx, nil, S3Options{Name: "bucket", Region: "us-east-1", MaxKeyLength: 2048})
+		assert.Error(t, err)
+	})
+}
+
+func TestS3ForEachObject(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newMockS3Client()
+	client.listPageSize = 2
+	b := newMockS3Bucket(client, "prefix")
+	for i := 0; i < 5; i++ {
+		client.putObject(fmt.Sprintf("prefix/%d", i), []byte("data"), mockS3Object{})
+	}
+	client.putObject("other/key", []byte("data"), mockS3Object{})
+
+	var mu sync.Mutex
+	var processed []string
+	err := b.forEachObject(ctx, "", 3, func(_ context.Context, obj s3Types.Object) error {
+		key := aws.ToString(obj.Key)
+		mu.Lock()
+		processed = append(processed, key)
+		mu.Unlock()
+		if key == "prefix/1" || key == "prefix/3" {
+			return errors.Errorf("failed '%s'", key)
+		}
+		return nil
+	})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "failed 'prefix/1'")
+	assert.Contains(t, err.Error(), "failed 'prefix/3'")
+	assert.ElementsMatch(t, []string{"prefix/0", "prefix/1", "prefix/2", "prefix/3", "prefix/4"}, processed, "errors should not stop the remaining objects")
+}
+
+func TestS3ChangeStorageClass(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newMockS3Client()
+	client.listPageSize = 4
+	b := newMockS3Bucket(client, "prefix")
+	for i := 0; i < 10; i++ {
+		storageClass := s3Types.StorageClassStandard
+		if i%2 == 0 {
+			storageClass = s3Types.StorageClassGlacier
+		}
+		client.putObject(fmt.Sprintf("prefix/cold/obj%d", i), []byte("data"), mockS3Object{
+			storageClass: storageClass,
+			metadata:     map[string]string{"index": fmt.Sprint(i)},
+		})
+	}
+	client.putObject("prefix/hot/obj", []byte("data"), mockS3Object{})
+
+	require.NoError(t, b.ChangeStorageClass(ctx, "cold", string(s3Types.StorageClassGlacier), 3))
+
+	var copied []string
+	for _, call := range client.copyCalls {
+		copied = append(copied, aws.ToString(call.Key))
+	}
+	assert.ElementsMatch(t, []string{"prefix/cold/obj1", "prefix/cold/obj3", "prefix/cold/obj5", "prefix/cold/obj7", "prefix/cold/obj9"}, copied)
+	for i := 0; i < 10; i++ {
+		obj := client.objects[fmt.Sprintf("prefix/cold/obj%d", i)]
+		assert.Equal(t, s3Types.StorageClassGlacier, obj.storageClass)
+		assert.Equal(t, map[string]string{"index": fmt.Sprint(i)}, obj.metadata)
+	}
+	assert.Equal(t, s3Types.StorageClassStandard, client.objects["prefix/hot/obj"].storageClass)
+
+	t.Run("InvalidStorageClass", func(t *testing.T) {
+		assert.Error(t, b.ChangeStorageClass(ctx, "cold", "FROZEN", 1))
+	})
+	t.Run("KeepsEncryptionOfObject", func(t *testing.T) {
+		client := newMockS3Client()
+		b := newMockS3Bucket(client, "prefix")
+		b.encryption = serverSideEncryption{algorithm: s3Types.ServerSideEncryptionAes256}
+		client.putObject("prefix/key", []byte("data"), mockS3Object{
+			serverSideEncryption: s3Types.ServerSideEncryptionAwsKms,
+			sseKMSKeyID:          "object-key",
+			cacheControl:         "no-cache",
+		})
+
+		require.NoError(t, b.ChangeStorageClass(ctx, "", string(s3Types.StorageClassStandardIa), 1))
+		obj := client.objects["prefix/key"]
+		assert.Equal(t, s3Types.StorageClassStandardIa, obj.storageClass)
+		assert.Equal(t, s3Types.ServerSideEncryptionAwsKms, obj.serverSideEncryption)
+		assert.Equal(t, "object-key", obj.sseKMSKeyID)
+		assert.Equal(t, "no-cache", obj.cacheControl)
+	})
+	t.Run("CopiesLargeObjectsInParts", func(t *testing.T) {
+		client := newMockS3Client()
+		b := newMockS3Bucket(client, "prefix")
+		b.maxCopyBytes = 4
+		client.putObject("prefix/key", []byte("0123456789"), mockS3Object{})
+
+		require.NoError(t, b.ChangeStorageClass(ctx, "", string(s3Types.StorageClassStandardIa), 1))
+		assert.Empty(t, client.copyCalls)
+		assert.Len(t, client.uploadPartCopyCalls, 3)
+		obj := client.objects["prefix/key"]
+		assert.Equal(t, s3Types.StorageClassStandardIa, obj.storageClass)
+		assert.Equal(t, []byte("0123456789"), obj.data)
+	})
+	t.Run("ReportsArchivedObjects", func(t *testing.T) {
+		client := newMockS3Client()
+		b := newMockS3Bucket(client, "prefix")
+		client.putObject("prefix/archived", []byte("data"), mockS3Object{storageClass: s3Types.StorageClassDeepArchive})
+		client.putObject("prefix/restoring", []byte("data"), mockS3Object{
+			storageClass: s3Types.StorageClassGlacier,
+			restore:      `ongoing-request="true"`,
+		})
+		client.putObject("prefix/restored", []byte("data"), mockS3Object{
+			storageClass: s3Types.StorageClassGlacier,
+			restore:      `ongoing-request="false", expiry-date="Fri, 21 Dec 2012 00:00:00 GMT"`,
+		})
+		client.putObject("prefix/standard", []byte("data"), mockS3Object{})
+
+		err := b.ChangeStorageClass(ctx, "", string(s3Types.StorageClassStandardIa), 2)
+		require.Error(t, err)
+		assert.True(t, errors.Is(err, ErrObjectArchived))
+		archivedErr, ok := err.(*ArchivedObjectsError)
+		require.True(t, ok)
+		assert.ElementsMatch(t, []string{"archived", "restoring"}, archivedErr.Keys)
+		assert.Equal(t, s3Types.StorageClassDeepArchive, client.objects["prefix/archived"].storageClass)
+		assert.Equal(t, s3Types.StorageClassGlacier, client.objects["prefix/restoring"].storageClass)
+		assert.Equal(t, s3Types.StorageClassStandardIa, client.objects["prefix/restored"].storageClass)
+		assert.Equal(t, s3Types.StorageClassStandardIa, client.objects["prefix/standard"].storageClass)
+	})
+}
+
+// corruptingS3Client simulates data corruption in transit by changing the
+// body of each PutObject request.
+type corruptingS3Client struct {
+	*mockS3Client
+}
+
+func (c *corruptingS3Client) PutObject(ctx context.Context, input *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	data, err := io.ReadAll(input.Body)
+	if err != nil {
+		return nil, err
+	}
+	corrupted := *input
+	corrupted.Body = bytes.NewReader(append(data, '!'))
+	return c.mockS3Client.PutObject(ctx, &corrupted, optFns...)
+}
+
+func TestS3SendContentMD5(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newMockS3Client()
+	b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+
+	require.NoError(t, b.Put(ctx, "without", strings.NewReader("data")))
+	require.Len(t, client.putObjectCalls, 1)
+	assert.Nil(t, client.putObjectCalls[0].ContentMD5)
+
+	b.sendContentMD5 = true
+	require.NoError(t, b.Put(ctx, "with", strings.NewReader("data")))
+	require.Len(t, client.putObjectCalls, 2)
+	assert.Equal(t, contentMD5([]byte("data")), aws.ToString(client.putObjectCalls[1].ContentMD5))
+
+	t.Run("CorruptedUploadIsRejected", func(t *testing.T) {
+		b.svc = &corruptingS3Client{mockS3Client: client}
+		err := b.Put(ctx, "corrupted", strings.NewReader("data"))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "BadDigest")
+		assert.NotContains(t, client.objects, "prefix/corrupted")
+	})
+}
+
+func TestS3ReaderSeeker(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newMockS3Client()
+	b := newMockS3Bucket(client, "prefix")
+	client.putObject("prefix/key", []byte("0123456789"), mockS3Object{})
+
+	t.Run("SeeksWithRangedReads", func(t *testing.T) {
+		r, err := b.ReaderSeeker(ctx, "key")
+		require.NoError(t, err)
+		defer r.Close()
+
+		pos, err := r.Seek(-4, io.SeekEnd)
+		require.NoError(t, err)
+		assert.EqualValues(t, 6, pos)
+		buf := make([]byte, 2)
+		_, err = io.ReadFull(r, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "67", string(buf))
+
+		_, err = r.Seek(1, io.SeekStart)
+		require.NoError(t, err)
+		_, err = io.ReadFull(r, buf)
+		require.NoError(t, err)
+		assert.Equal(t, "12", string(buf))
+
+		_, err = r.Seek(0, io.SeekEnd)
+		require.NoError(t, err)
+		n, err := r.Read(buf)
+		assert.Zero(t, n)
+		assert.Equal(t, io.EOF, err)
+	})
+	t.Run("FailsIfOverwritten", func(t *testing.T) {
+		r, err := b.ReaderSeeker(ctx, "key")
+		require.NoError(t, err)
+		defer r.Close()
+
+		client.putObject("prefix/key", []byte("abcdefghij"), mockS3Object{})
+		_, err = io.ReadAll(r)
+		assert.Error(t, err)
+	})
+	t.Run("CompressedObjectIsNotSupported", func(t *testing.T) {
+		client.putObject("prefix/compressed", []byte("compressed"), mockS3Object{contentEncoding: "gzip"})
+		_, err := b.ReaderSeeker(ctx, "compressed")
+		assert.True(t, errors.Is(err, ErrNotSupported))
+	})
+	t.Run("MissingKey", func(t *testing.T) {
+		_, err := b.ReaderSeeker(ctx, "missing")
+		require.Error(t, err)
+		assert.True(t, IsKeyNotFoundError(err))
+	})
+}
+
+func TestS3GetRange(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newMockS3Client()
+	b := newMockS3Bucket(client, "prefix")
+	client.putObject("prefix/key", []byte("0123456789"), mockS3Object{})
+
+	t.Run("RequestsOnlyRange", func(t *testing.T) {
+		r, err := b.GetRange(ctx, "key", 2, 3)
+		require.NoError(t, err)
+		defer r.Close()
+		data, err := io.ReadAll(r)
+		require.NoError(t, err)
+		assert.Equal(t, "234", string(data))
+	})
+	t.Run("OffsetBeyondEndIsNotSatisfiable", func(t *testing.T) {
+		_, err := b.GetRange(ctx, "key", 10, 1)
+		assert.True(t, errors.Is(err, ErrRangeNotSatisfiable))
+	})
+	t.Run("CompressedObjectIsNotSupported", func(t *testing.T) {
+		client.putObject("prefix/compressed", []byte("compressed"), mockS3Object{contentEncoding: "gzip"})
+		_, err := b.GetRange(ctx, "compressed", 0, 4)
+		assert.True(t, errors.Is(err, ErrNotSupported))
+	})
+}
+
+func TestS3CopyBufferSize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 256*1024)
+	src := filepath.Join(t.TempDir(), "src")
+	require.NoError(t, os.WriteFile(src, payload, 0600))
+
+	for name, newBucket := range map[string]func(*s3Bucket) Bucket{
+		"Small": func(b *s3Bucket) Bucket { return &s3BucketSmall{s3Bucket: *b} },
+		"Large": func(b *s3Bucket) Bucket { return &s3BucketLarge{s3Bucket: *b, minPartSize: 1024 * 1024 * 5} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := newMockS3Client()
+			base := newMockS3Bucket(client, "prefix")
+			base.copyBufferSize = 1024 * 1024
+			b := newBucket(base)
+
+			require.NoError(t, b.Upload(ctx, "key", src))
+			assert.Equal(t, payload, client.objects["prefix/key"].data)
+
+			dest := filepath.Join(t.TempDir(), "dest")
+			require.NoError(t, b.Download(ctx, "key", dest))
+			downloaded, err := os.ReadFile(dest)
+			require.NoError(t, err)
+			assert.Equal(t, payload, downloaded)
+		})
+	}
+	t.Run("NegativeSizeIsInvalid", func(t *testing.T) {
+		_, err := newS3BucketBase(ctx, nil, S3Options{Name: "bucket", Region: "us-east-1", CopyBufferSize: -1})
+		assert.Error(t, err)
+	})
+}
+
+func TestS3StreamingCopyPreservesInfo(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for name, newBucket := range map[string]func(*mockS3Client) Bucket{
+		"Small": func(client *mockS3Client) Bucket {
+			return &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+		},
+		"Large": func(client *mockS3Client) Bucket {
+			return &s3BucketLarge{s3Bucket: *newMockS3Bucket(client, "prefix"), minPartSize: 1024 * 1024 * 5}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Run("FromLocal", func(t *testing.T) {
+				local, err := NewLocalBucket(LocalOptions{Path: t.TempDir()})
+				require.NoError(t, err)
+				require.NoError(t, local.Put(ctx, "report.html", strings.NewReader("<html></html>")))
+				client := newMockS3Client()
+
+				require.NoError(t, local.Copy(ctx, CopyOptions{
+					SourceKey:         "report.html",
+					DestinationKey:    "copied.html",
+					DestinationBucket: newBucket(client),
+				}))
+				copied := client.objects["prefix/copied.html"]
+				require.NotNil(t, copied)
+				assert.Equal(t, "<html></html>", string(copied.data))
+				assert.Equal(t, "text/html; charset=utf-8", copied.contentType)
+			})
+			t.Run("WithMetadata", func(t *testing.T) {
+				srcClient := newMockS3Client()
+				src := &s3BucketSmall{s3Bucket: *newMockS3Bucket(srcClient, "src")}
+				srcClient.putObject("src/key", []byte("data"), mockS3Object{
+					contentType: "application/x-custom",
+					metadata:    map[string]string{"owner": "evergreen", "task": "t1", zstdDictionaryMetadataKey: "1234"},
+				})
+				client := newMockS3Client()
+
+				require.NoError(t, streamCopy(ctx, src, CopyOptions{
+					SourceKey:         "key",
+					DestinationKey:    "key",
+					DestinationBucket: newBucket(client),
+				}))
+				copied := client.objects["prefix/key"]
+				require.NotNil(t, copied)
+				assert.Equal(t, "data", string(copied.data))
+				assert.Equal(t, "application/x-custom", copied.contentType)
+				assert.Equal(t, map[string]string{"owner": "evergreen", "task": "t1"}, copied.metadata)
+			})
+		})
+	}
+}
+
+func TestS3ListWithStartAfter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newMockS3Client()
+	client.listPageSize = 2
+	b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+	for i := 0; i < 6; i++ {
+		client.putObject(fmt.Sprintf("prefix/logs/log%d", i), []byte("log"), mockS3Object{})
+	}
+
+	iter, err := b.ListWithOptions(ctx, "logs", ListOptions{StartAfter: "logs/log2"})
+	require.NoError(t, err)
+	var keys []string
+	for iter.Next(ctx) {
+		keys = append(keys, iter.Item().Name())
+	}
+	require.NoError(t, iter.Err())
+	assert.Equal(t, []string{"logs/log3", "logs/log4", "logs/log5"}, keys)
+}
+
+func TestS3PrefixSegments(t *testing.T) {
+	for name, test := range map[string]struct {
+		prefix   string
+		segments []string
+		expected string
+	}{
+		"PrefixOnly":                  {prefix: "base", expected: "base"},
+		"PrefixWithoutSegmentsIsKept": {prefix: "/base/", expected: "/base/"},
+		"SegmentsOnly":                {segments: []string{"prod", "team"}, expected: "prod/team"},
+		"PrefixAndSegments":           {prefix: "base", segments: []string{"prod", "team", "feature"}, expected: "base/prod/team/feature"},
+		"EmptySegments":               {prefix: "base", segments: []string{"", "prod", "", "team", ""}, expected: "base/prod/team"},
+		"TrailingSlashes":             {prefix: "base/", segments: []string{"prod/", "team//"}, expected: "base/prod/team"},
+		"LeadingSlashes":              {prefix: "/base", segments: []string{"/prod", "//team"}, expected: "base/prod/team"},
+		"NestedSegments":              {segments: []string{"prod/us-east", "team"}, expected: "prod/us-east/team"},
+		"AllEmpty":                    {segments: []string{"", "/", "//"}, expected: ""},
+	} {
+		t.Run(name, func(t *testing.T) {
+			opts := S3Options{Prefix: test.prefix, PrefixSegments: test.segments}
+			assert.Equal(t, test.expected, opts.prefix())
+		})
+	}
+	t.Run("BucketUsesComposedPrefix", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+
+		b, err := newS3BucketBase(ctx, nil, S3Options{Name: "bucket", Region: "us-east-1", Prefix: "base/", PrefixSegments: []string{"prod", "team/"}})
+		require.NoError(t, err)
+		assert.Equal(t, "base/prod/team", b.prefix)
+		assert.Equal(t, "base/prod/team/key", b.normalizeKey("key"))
+	})
+}
+
+func gzipData(t *testing.T, data string) []byte {
+	compressed := &bytes.Buffer{}
+	w := gzip.NewWriter(compressed)
+	_, err := w.Write([]byte(data))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	return compressed.Bytes()
+}
+
+func TestS3SniffCompression(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newMockS3Client()
+	client.putObject("prefix/compressed", gzipData(t, "hello world"), mockS3Object{})
+	client.putObject("prefix/plain", []byte("hello world"), mockS3Object{})
+	client.putObject("prefix/short", []byte("h"), mockS3Object{})
+	client.putObject("prefix/archive.tar.gz", gzipData(t, "hello world"), mockS3Object{})
+	client.putObject("prefix/download", gzipData(t, "hello world"), mockS3Object{contentType: "application/x-gzip"})
+
+	for _, test := range []struct {
+		name     string
+		sniff    bool
+		key      string
+		expected []byte
+	}{
+		{name: "KeepsGzipFilesCompressed", sniff: true, key: "archive.tar.gz", expected: gzipData(t, "hello world")},
+		{name: "KeepsGzipContentTypeCompressed", sniff: true, key: "download", expected: gzipData(t, "hello world")},
+		{name: "DecompressesGzipWithoutEncoding", sniff: true, key: "compressed", expected: []byte("hello world")},
+		{name: "PassesThroughPlainData", sniff: true, key: "plain", expected: []byte("hello world")},
+		{name: "PassesThroughShortData", sniff: true, key: "short", expected: []byte("h")},
+		{name: "DisabledReturnsRawData", key: "compressed", expected: gzipData(t, "hello world")},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b := newMockS3Bucket(client, "prefix")
+			b.sniffCompression = test.sniff
+
+			r, err := b.Get(ctx, test.key)
+			require.NoError(t, err)
+			data, err := io.ReadAll(r)
+			require.NoError(t, err)
+			require.NoError(t, r.Close())
+			assert.Equal(t, test.expected, data)
+		})
+	}
+	t.Run("ClosesBody", func(t *testing.T) {
+		b := newMockS3Bucket(newMockS3Client(), "")
+		b.sniffCompression = true
+		for _, data := range [][]byte{gzipData(t, "hello"), []byte("hello")} {
+			body := &trackingReadCloser{Reader: bytes.NewReader(data)}
+			r, err := b.newDecompressingReader("", nil, true, body)
+			require.NoError(t, err)
+			_, err = io.ReadAll(r)
+			require.NoError(t, err)
+			require.NoError(t, r.Close())
+			assert.True(t, body.closed)
+		}
+	})
+}
+
+func TestS3GetToWriterCompressed(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	compressed := gzipData(t, "hello world")
+	client := newMockS3Client()
+	client.putObject("prefix/encoded", compressed, mockS3Object{contentEncoding: "gzip"})
+	client.putObject("prefix/unencoded", compressed, mockS3Object{})
+	client.putObject("prefix/plain", []byte("hello world"), mockS3Object{})
+
+	for _, test := range []struct {
+		name     string
+		sniff    bool
+		key      string
+		encoding string
+		expected []byte
+	}{
+		{name: "PassesThroughGzipObject", key: "encoded", encoding: "gzip", expected: compressed},
+		{name: "PassesThroughPlainObject", key: "plain", expected: []byte("hello world")},
+		{name: "SniffsGzipWithoutEncoding", sniff: true, key: "unencoded", encoding: "gzip", expected: compressed},
+		{name: "DoesNotSniffWhenDisabled", key: "unencoded", expected: compressed},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			b := newMockS3Bucket(client, "prefix")
+			b.sniffCompression = test.sniff
+
+			buf := &bytes.Buffer{}
+			encoding, err := b.GetToWriterCompressed(ctx, test.key, buf)
+			require.NoError(t, err)
+			assert.Equal(t, test.encoding, encoding)
+			assert.Equal(t, test.expected, buf.Bytes())
+		})
+	}
+	t.Run("SetsResponseHeaderBeforeWriting", func(t *testing.T) {
+		b := newMockS3Bucket(client, "prefix")
+		rec := httptest.NewRecorder()
+		encoding, err := b.GetToWriterCompressed(ctx, "encoded", rec)
+		require.NoError(t, err)
+		assert.Equal(t, "gzip", encoding)
+
+		// The recorder keeps the headers as they were when the body was
+		// first written.
+		resp := rec.Result()
+		assert.Equal(t, "gzip", resp.Header.Get("Content-Encoding"))
+		assert.Equal(t, compressed, rec.Body.Bytes())
+	})
+	t.Run("DoesNotSetResponseHeaderForPlainObject", func(t *testing.T) {
+		b := newMockS3Bucket(client, "prefix")
+		rec := httptest.NewRecorder()
+		_, err := b.GetToWriterCompressed(ctx, "plain", rec)
+		require.NoError(t, err)
+		assert.Empty(t, rec.Result().Header.Get("Content-Encoding"))
+	})
+	t.Run("MissingKey", func(t *testing.T) {
+		b := newMockS3Bucket(client, "prefix")
+		_, err := b.GetToWriterCompressed(ctx, "DNE", &bytes.Buffer{})
+		assert.True(t, IsKeyNotFoundError(err))
+	})
+}
+
+func TestS3RepairCompressionMetadata(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	setup := func() *mockS3Client {
+		client := newMockS3Client()
+		client.listPageSize = 2
+		for i := 0; i < 3; i++ {
+			client.putObject(fmt.Sprintf("prefix/logs/compressed%d", i), gzipData(t, "hello"), mockS3Object{
+				contentType:        "text/plain",
+				contentDisposition: "inline",
+				cacheControl:       "max-age=60",
+				metadata:           map[string]string{"index": fmt.Sprint(i)},
+				storageClass:       s3Types.StorageClassStandardIa,
+			})
+		}
+		client.putObject("prefix/logs/plain", []byte("hello"), mockS3Object{contentType: "text/plain"})
+		client.putObject("prefix/logs/archive.tgz", gzipData(t, "hello"), mockS3Object{})
+		client.putObject("prefix/logs/download", gzipData(t, "hello"), mockS3Object{contentType: "application/gzip"})
+		client.putObject("prefix/logs/encoded", gzipData(t, "hello"), mockS3Object{contentEncoding: "gzip"})
+		client.putObject("prefix/other/compressed", gzipData(t, "hello"), mockS3Object{})
+		return client
+	}
+
+	t.Run("SetsMissingEncoding", func(t *testing.T) {
+		client := setup()
+		b := newMockS3Bucket(client, "prefix")
+		require.NoError(t, b.RepairCompressionMetadata(ctx, "logs", RepairCompressionOptions{Workers: 3}))
+
+		var copied []string
+		for _, call := range client.copyCalls {
+			copied = append(copied, aws.ToString(call.Key))
+		}
+		assert.ElementsMatch(t, []string{"prefix/logs/compressed0", "prefix/logs/compressed1", "prefix/logs/compressed2"}, copied)
+		for i := 0; i < 3; i++ {
+			obj := client.objects[fmt.Sprintf("prefix/logs/compressed%d", i)]
+			assert.Equal(t, "gzip", obj.contentEncoding)
+			assert.Equal(t, "text/plain", obj.contentType)
+			assert.Equal(t, "inline", obj.contentDisposition)
+			assert.Equal(t, "max-age=60", obj.cacheControl)
+			assert.Equal(t, map[string]string{"index": fmt.Sprint(i)}, obj.metadata)
+			assert.Equal(t, s3Types.StorageClassStandardIa, obj.storageClass)
+		}
+		assert.Empty(t, client.objects["prefix/logs/plain"].contentEncoding)
+		assert.Empty(t, client.objects["prefix/logs/archive.tgz"].contentEncoding)
+		assert.Empty(t, client.objects["prefix/logs/download"].contentEncoding)
+		assert.Empty(t, client.objects["prefix/other/compressed"].contentEncoding)
+
+		data, err := readDataFromFile(ctx, &s3BucketSmall{s3Bucket: *b}, "logs/compressed0")
+		require.NoError(t, err)
+		assert.Equal(t, "hello", data)
+	})
+	t.Run("DryRunDoesNotCopy", func(t *testing.T) {
+		client := setup()
+		b := newMockS3Bucket(client, "prefix")
+		require.NoError(t, b.RepairCompressionMetadata(ctx, "logs", RepairCompressionOptions{DryRun: true}))
+		assert.Empty(t, client.copyCalls)
+		assert.Empty(t, client.objects["prefix/logs/compressed0"].contentEncoding)
+	})
+	t.Run("DryRunBucketDoesNotCopy", func(t *testing.T) {
+		client := setup()
+		b := newMockS3Bucket(client, "prefix")
+		b.dryRun = true
+		require.NoError(t, b.RepairCompressionMetadata(ctx, "logs", RepairCompressionOptions{}))
+		assert.Empty(t, client.copyCalls)
+	})
+	t.Run("RejectsNegativeWorkers", func(t *testing.T) {
+		b := newMockS3Bucket(setup(), "prefix")
+		assert.Error(t, b.RepairCompressionMetadata(ctx, "logs", RepairCompressionOptions{Workers: -1}))
+	})
+}
+
+// hostRecordingHTTPClient records the host and Authorization header of each
+// request and fails it without sending it.
+type hostRecordingHTTPClient struct {
+	mu             sync.Mutex
+	hosts          []string
+	authorizations []string
+}
+
+func (c *hostRecordingHTTPClient) Do(req *http.Request) (*http.Response, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.hosts = append(c.hosts, req.URL.Host)
+	c.authorizations = append(c.authorizations, req.Header.Get("Authorization"))
+	return nil, errors.New("request not sent")
+}
+
+func TestS3UseFIPS(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	resolvedHost := func(t *testing.T, options S3Options) string {
+		options.Name = "bucket"
+		options.Region = "us-gov-west-1"
+		options.Credentials = CreateAWSCredentials("key", "secret", "")
+		b, err := newS3BucketBase(ctx, nil, options)
+		require.NoError(t, err)
+
+		client := &hostRecordingHTTPClient{}
+		_, _ = b.svc.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(b.name), Key: aws.String("key")}, func(opts *s3.Options) {
+			opts.HTTPClient = client
+			opts.RetryMaxAttempts = 1
+		})
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		require.NotEmpty(t, client.hosts)
+		return client.hosts[0]
+	}
+
+	t.Run("ResolvesFIPSEndpoint", func(t *testing.T) {
+		assert.Contains(t, resolvedHost(t, S3Options{UseFIPS: true}), "fips")
+	})
+	t.Run("DefaultEndpointIsNotFIPS", func(t *testing.T) {
+		assert.NotContains(t, resolvedHost(t, S3Options{}), "fips")
+	})
+	t.Run("CustomEndpoint", func(t *testing.T) {
+		assert.Equal(t, "bucket.s3.example.com", resolvedHost(t, S3Options{Endpoint: "https://s3.example.com"}))
+	})
+	t.Run("RejectsFIPSWithCustomEndpoint", func(t *testing.T) {
+		_, err := newS3BucketBase(ctx, nil, S3Options{Name: "bucket", Region: "us-gov-west-1", UseFIPS: true, Endpoint: "https://s3.example.com"})
+		assert.Error(t, err)
+	})
+}
+
+func TestS3MultiRegionAccessPoint(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const mrapARN = "arn:aws:s3::123456789012:accesspoint/mfzwi23gnjvgw.mrap"
+	sendHeadObject := func(t *testing.T, name string) *hostRecordingHTTPClient {
+		b, err := newS3BucketBase(ctx, nil, S3Options{
+			Name:        name,
+			Region:      "us-east-1",
+			Credentials: CreateAWSCredentials("key", "secret", ""),
+		})
+		require.NoError(t, err)
+
+		client := &hostRecordingHTTPClient{}
+		_, _ = b.svc.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(b.name), Key: aws.String("key")}, func(opts *s3.Options) {
+			opts.HTTPClient = client
+			opts.RetryMaxAttempts = 1
+		})
+		client.mu.Lock()
+		defer client.mu.Unlock()
+		require.NotEmpty(t, client.hosts)
+		return client
+	}
+
+	t.Run("SignsWithSigV4A", func(t *testing.T) {
+		client := sendHeadObject(t, mrapARN)
+		assert.Equal(t, "mfzwi23gnjvgw.mrap.accesspoint.s3-global.amazonaws.com", client.hosts[0])
+		assert.True(t, strings.HasPrefix(client.authorizations[0], "AWS4-ECDSA-P256-SHA256 "), client.authorizations[0])
+	})
+	t.Run("BucketSignsWithSigV4", func(t *testing.T) {
+		client := sendHeadObject(t, "bucket")
+		assert.True(t, strings.HasPrefix(client.authorizations[0], "AWS4-HMAC-SHA256 "), client.authorizations[0])
+	})
+	t.Run("RejectsCustomEndpoint", func(t *testing.T) {
+		_, err := newS3BucketBase(ctx, nil, S3Options{Name: mrapARN, Region: "us-east-1", Endpoint: "https://s3.example.com"})
+		assert.Error(t, err)
+	})
+	t.Run("RejectsFIPS", func(t *testing.T) {
+		_, err := newS3BucketBase(ctx, nil, S3Options{Name: mrapARN, Region: "us-east-1", UseFIPS: true})
+		assert.Error(t, err)
+	})
+	t.Run("RequiresSigV4A", func(t *testing.T) {
+		assert.NoError(t, validateSigV4ASupport(s3.New(s3.Options{}).Options()))
+		assert.Error(t, validateSigV4ASupport(s3.Options{}))
+		assert.Error(t, validateSigV4ASupport(s3.New(s3.Options{DisableMultiRegionAccessPoints: true}).Options()))
+	})
+	t.Run("CopiesFromObjectPath", func(t *testing.T) {
+		assert.Equal(t, mrapARN+"/object/dir/key", copySource(mrapARN, "dir/key"))
+		assert.Equal(t, "bucket/dir/key", copySource("bucket", "dir/key"))
+		assert.True(t, isMultiRegionAccessPointARN(mrapARN))
+		assert.False(t, isMultiRegionAccessPointARN("arn:aws:s3:us-east-1:123456789012:accesspoint/name"))
+		assert.True(t, isAccessPointARN("arn:aws:s3:us-east-1:123456789012:accesspoint/name"))
+		assert.False(t, isAccessPointARN("bucket"))
+	})
+}
+
+// sdkClient returns the AWS SDK client that the S3 client wraps.
+func sdkClient(t *testing.T, svc s3Client) *s3.Client {
+	for {
+		switch c := svc.(type) {
+		case *s3.Client:
+			return c
+		case *credentialRefreshClient:
+			svc = c.s3Client
+		case *retryPolicyClient:
+			svc = c.s3Client
+		case *expectedOwnerClient:
+			svc = c.s3Client
+		default:
+			require.FailNow(t, "unexpected S3 client type", "%T", svc)
+		}
+	}
+}
+
+// expiringCredentialsProvider returns temporary credentials that S3 rejects
+// as AccessDenied the first time they are retrieved, and valid credentials
+// after. The credentials expire after the given duration.
+type expiringCredentialsProvider struct {
+	expiresIn  time.Duration
+	retrievals int
+}
+
+func (p *expiringCredentialsProvider) Retrieve(context.Context) (aws.Credentials, error) {
+	p.retrievals++
+	accessKeyID := "valid"
+	if p.retrievals == 1 {
+		accessKeyID = "expired"
+	}
+	return aws.Credentials{
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: "secret",
+		CanExpire:       true,
+		Expires:         time.Now().Add(p.expiresIn),
+	}, nil
+}
+
+// credentialCheckingS3Client rejects requests made with expired credentials,
+// after reading their bodies, as S3 does.
+type credentialCheckingS3Client struct {
+	*mockS3Client
+	creds aws.CredentialsProvider
+}
+
+func (c *credentialCheckingS3Client) checkCredentials(ctx context.Context) error {
+	creds, err := c.creds.Retrieve(ctx)
+	if err != nil {
+		return err
+	}
+	if creds.AccessKeyID == "expired" {
+		return mockS3APIError("AccessDenied")
+	}
+	return nil
+}
+
+func (c *credentialCheckingS3Client) GetObject(ctx context.Context, input *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	if err := c.checkCredentials(ctx); err != nil {
+		return nil, err
+	}
+	return c.mockS3Client.GetObject(ctx, input, optFns...)
+}
+
+func (c *credentialCheckingS3Client) PutObject(ctx context.Context, input *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	if err := c.checkCredentials(ctx); err != nil {
+		_, _ = io.Copy(io.Discard, input.Body)
+		return nil, err
+	}
+	return c.mockS3Client.PutObject(ctx, input, optFns...)
+}
+
+func TestS3CredentialRefresh(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	setup := func(provider aws.CredentialsProvider) (*mockS3Client, *s3BucketSmall) {
+		client := newMockS3Client()
+		cache := aws.NewCredentialsCache(provider)
+		b := newMockS3Bucket(client, "prefix")
+		b.svc = &credentialRefreshClient{
+			s3Client: &credentialCheckingS3Client{mockS3Client: client, creds: cache},
+			creds:    cache,
+		}
+		return client, &s3BucketSmall{s3Bucket: *b}
+	}
+
+	t.Run("RetriesGetWithRefreshedCredentials", func(t *testing.T) {
+		provider := &expiringCredentialsProvider{expiresIn: time.Second}
+		client, b := setup(provider)
+		client.putObject("prefix/key", []byte("hello"), mockS3Object{})
+
+		data, err := readDataFromFile(ctx, b, "key")
+		require.NoError(t, err)
+		assert.Equal(t, "hello", data)
+		assert.Equal(t, 2, provider.retrievals)
+	})
+	t.Run("RetriesPutWithRewoundBody", func(t *testing.T) {
+		provider := &expiringCredentialsProvider{expiresIn: time.Second}
+		client, b := setup(provider)
+
+		require.NoError(t, b.Put(ctx, "key", strings.NewReader("hello")))
+		assert.Equal(t, []byte("hello"), client.objects["prefix/key"].data)
+		assert.Equal(t, 2, provider.retrievals)
+	})
+	t.Run("DoesNotRetryCredentialsFarFromExpiring", func(t *testing.T) {
+		provider := &expiringCredentialsProvider{expiresIn: time.Hour}
+		client, b := setup(provider)
+		client.putObject("prefix/key", []byte("hello"), mockS3Object{})
+
+		_, err := b.Get(ctx, "key")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "AccessDenied")
+		assert.Equal(t, 1, provider.retrievals)
+	})
+	t.Run("DoesNotRetryPermanentCredentials", func(t *testing.T) {
+		client, b := setup(CreateAWSCredentials("expired", "secret", ""))
+		client.putObject("prefix/key", []byte("hello"), mockS3Object{})
+
+		_, err := b.Get(ctx, "key")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "AccessDenied")
+	})
+}
+
+func TestS3RequestTags(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	tags := map[string]string{"team": "build & release", "cost-center": "1234"}
+	tagging, err := encodeObjectTags(tags)
+	require.NoError(t, err)
+	objectTags := func(t *testing.T, client *mockS3Client, key string) map[string]string {
+		output, err := client.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{Bucket: aws.String("bucket"), Key: aws.String(key)})
+		require.NoError(t, err)
+		actual := map[string]string{}
+		for _, tag := range output.TagSet {
+			actual[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+		}
+		return actual
+	}
+
+	for name, newBucket := range map[string]func(*s3Bucket) Bucket{
+		"Small": func(b *s3Bucket) Bucket { return &s3BucketSmall{s3Bucket: *b} },
+		"Large": func(b *s3Bucket) Bucket { return &s3BucketLarge{s3Bucket: *b, minPartSize: 1024 * 1024 * 5} },
+	} {
+		t.Run(name, func(t *testing.T) {
+			client := newMockS3Client()
+			b := newMockS3Bucket(client, "prefix")
+			b.tagging = tagging
+			bucket := newBucket(b)
+
+			require.NoError(t, bucket.Put(ctx, "put", strings.NewReader("data")))
+			assert.Equal(t, tags, objectTags(t, client, "prefix/put"))
+
+			path := filepath.Join(t.TempDir(), "file")
+			require.NoError(t, os.WriteFile(path, []byte("data"), 0600))
+			require.NoError(t, bucket.Upload(ctx, "upload", path))
+			assert.Equal(t, tags, objectTags(t, client, "prefix/upload"))
+		})
+	}
+	t.Run("NoTags", func(t *testing.T) {
+		client := newMockS3Client()
+		b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+		require.NoError(t, b.Put(ctx, "key", strings.NewReader("data")))
+		assert.Empty(t, objectTags(t, client, "prefix/key"))
+	})
+	t.Run("RejectsTooManyTags", func(t *testing.T) {
+		tooMany := map[string]string{}
+		for i := 0; i <= s3MaxObjectTags; i++ {
+			tooMany[fmt.Sprint(i)] = "value"
+		}
+		_, err := newS3BucketBase(ctx, nil, S3Options{Name: "bucket", Region: "us-east-1", RequestTags: tooMany})
+		assert.Error(t, err)
+	})
+	t.Run("RejectsEmptyTagKey", func(t *testing.T) {
+		_, err := newS3BucketBase(ctx, nil, S3Options{Name: "bucket", Region: "us-east-1", RequestTags: map[string]string{"": "value"}})
+		assert.Error(t, err)
+	})
+}
+
+func TestS3ExpectedBucketOwner(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	setup := func(owner string) (*mockS3Client, Bucket) {
+		client := newMockS3Client()
+		client.owner = "111111111111"
+		b := newMockS3Bucket(client, "prefix")
+		b.svc = &expectedOwnerClient{s3Client: client, owner: owner}
+		return client, &s3BucketSmall{s3Bucket: *b}
+	}
+
+	t.Run("SendsExpectedOwner", func(t *testing.T) {
+		client, b := setup("111111111111")
+		require.NoError(t, writeDataToFile(ctx, b, "key", "data"))
+		require.Len(t, client.putObjectCalls, 1)
+		assert.Equal(t, "111111111111", aws.ToString(client.putObjectCalls[0].ExpectedBucketOwner))
+
+		data, err := readDataFromFile(ctx, b, "key")
+		require.NoError(t, err)
+		assert.Equal(t, "data", data)
+		exists, err := b.Exists(ctx, "key")
+		require.NoError(t, err)
+		assert.True(t, exists)
+		count, err := Count(ctx, b, "")
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+		require.NoError(t, b.Remove(ctx, "key"))
+	})
+	t.Run("RejectsMismatchedOwner", func(t *testing.T) {
+		client, b := setup("222222222222")
+		client.putObject("prefix/key", []byte("data"), mockS3Object{})
+
+		assertAccessDenied := func(err error) {
+			var apiErr smithy.APIError
+			require.True(t, errors.As(err, &apiErr), "%v", err)
+			assert.Equal(t, "AccessDenied", apiErr.ErrorCode())
+		}
+		assertAccessDenied(b.Put(ctx, "other", strings.NewReader("data")))
+		_, err := b.Get(ctx, "key")
+		assertAccessDenied(err)
+		_, err = b.Exists(ctx, "key")
+		assertAccessDenied(err)
+		_, err = Count(ctx, b, "")
+		assertAccessDenied(err)
+		assertAccessDenied(b.Remove(ctx, "key"))
+		assert.Contains(t, client.objects, "prefix/key")
+	})
+}
+
+func TestS3ListIncompleteUploads(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newMockS3Client()
+	client.listPageSize = 2
+	b := newMockS3Bucket(client, "prefix")
+
+	start := time.Now()
+	var started []*s3.CreateMultipartUploadOutput
+	for _, key := range []string{"logs/a", "logs/b", "logs/b", "other"} {
+		out, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+			Bucket: aws.String("bucket"),
+			Key:    aws.String(b.normalizeKey(key)),
+		})
+		require.NoError(t, err)
+		started = append(started, out)
+	}
+	completed, err := client.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket: aws.String("bucket"),
+		Key:    aws.String(b.normalizeKey("logs/c")),
+	})
+	require.NoError(t, err)
+	_, err = client.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+		Bucket:          aws.String("bucket"),
+		Key:             aws.String(b.normalizeKey("logs/c")),
+		UploadId:        completed.UploadId,
+		MultipartUpload: &s3Types.CompletedMultipartUpload{},
+	})
+	require.NoError(t, err)
+
+	iter, err := b.ListIncompleteUploads(ctx, "logs")
+	require.NoError(t, err)
+	var uploads []MultipartUpload
+	for iter.Next(ctx) {
+		uploads = append(uploads, *iter.Item())
+	}
+	require.NoError(t, iter.Err())
+
+	require.Len(t, uploads, 3)
+	for i, key := range []string{"logs/a", "logs/b", "logs/b"} {
+		assert.Equal(t, key, uploads[i].Key)
+		assert.Equal(t, aws.ToString(started[i].UploadId), uploads[i].UploadID)
+		assert.False(t, uploads[i].Initiated.Before(start))
+	}
+}
+
+func TestS3RemoveIfMatch(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newMockS3Client()
+	b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+
+	t.Run("RemovesMatchingObject", func(t *testing.T) {
+		obj := client.putObject("prefix/key", []byte("data"), mockS3Object{})
+		require.NoError(t, b.RemoveIfMatch(ctx, "key", strings.Trim(obj.etag, `"`)))
+		assert.NotContains(t, client.objects, "prefix/key")
+	})
+	t.Run("AcceptsQuotedETag", func(t *testing.T) {
+		obj := client.putObject("prefix/key", []byte("data"), mockS3Object{})
+		require.NoError(t, b.RemoveIfMatch(ctx, "key", obj.etag))
+		assert.NotContains(t, client.objects, "prefix/key")
+	})
+	t.Run("RefusesStaleETag", func(t *testing.T) {
+		stale := client.putObject("prefix/key", []byte("old"), mockS3Object{})
+		client.putObject("prefix/key", []byte("new"), mockS3Object{})
+
+		err := b.RemoveIfMatch(ctx, "key", stale.etag)
+		assert.True(t, errors.Is(err, ErrPreconditionFailed), "%v", err)
+		data, err := readDataFromFile(ctx, b, "key")
+		require.NoError(t, err)
+		assert.Equal(t, "new", data)
+	})
+	t.Run("MissingKey", func(t *testing.T) {
+		err := b.RemoveIfMatch(ctx, "DNE", `"etag"`)
+		assert.True(t, IsKeyNotFoundError(err))
+	})
+}
+
+// referenceTreeHash computes the SHA-256 tree hash of the data as described by
+// the Amazon S3 Glacier documentation, keeping the digests of every chunk.
+func referenceTreeHash(data []byte) string {
+	var digests [][]byte
+	for start := 0; start < len(data) || start == 0; start += treeHashChunkSize {
+		end := start + treeHashChunkSize
+		if end > len(data) {
+			end = len(data)
+		}
+		digest := sha256.Sum256(data[start:end])
+		digests = append(digests, digest[:])
+	}
+	for len(digests) > 1 {
+		var next [][]byte
+		for i := 0; i < len(digests); i += 2 {
+			if i+1 == len(digests) {
+				next = append(next, digests[i])
+				continue
+			}
+			digest := sha256.Sum256(append(append([]byte{}, digests[i]...), digests[i+1]...))
+			next = append(next, digest[:])
+		}
+		digests = next
+	}
+	return hex.EncodeToString(digests[0])
+}
+
+func TestS3TreeHash(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	payload := func(size int) []byte {
+		data := make([]byte, size)
+		for i := range data {
+			data[i] = byte(i * 7)
+		}
+		return data
+	}
+
+	t.Run("MatchesReference", func(t *testing.T) {
+		for _, size := range []int{0, 1, treeHashChunkSize - 1, treeHashChunkSize, treeHashChunkSize + 1, 3*treeHashChunkSize + 512, 4 * treeHashChunkSize, 7*treeHashChunkSize + 1} {
+			data := payload(size)
+			hasher := newTreeHasher()
+			// Write in uneven pieces to exercise chunk boundaries.
+			for start := 0; start < len(data); start += 300 * 1024 {
+				end := start + 300*1024
+				if end > len(data) {
+					end = len(data)
+				}
+				_, err := hasher.Write(data[start:end])
+				require.NoError(t, err)
+			}
+			assert.Equal(t, referenceTreeHash(data), hasher.Sum(), "size %d", size)
+		}
+	})
+	t.Run("KnownPayload", func(t *testing.T) {
+		// The tree hash of a payload of a single chunk is its SHA-256
+		// digest.
+		hasher := newTreeHasher()
+		_, err := hasher.Write([]byte("hello world"))
+		require.NoError(t, err)
+		assert.Equal(t, "b94d27b9934d3e08a52e52d7da7dabfac484efe37a5380ee9088f7ace2efcde9", hasher.Sum())
+	})
+	t.Run("SmallWriterStoresTreeHash", func(t *testing.T) {
+		client := newMockS3Client()
+		b := newMockS3Bucket(client, "prefix")
+		b.computeTreeHash = true
+		data := payload(2*treeHashChunkSize + 10)
+
+		require.NoError(t, (&s3BucketSmall{s3Bucket: *b}).Put(ctx, "key", bytes.NewReader(data)))
+		assert.Equal(t, referenceTreeHash(data), client.objects["prefix/key"].metadata[treeHashMetadataKey])
+	})
+	t.Run("LargeWriterStoresTreeHash", func(t *testing.T) {
+		client := newMockS3Client()
+		b := newMockS3Bucket(client, "prefix")
+		b.computeTreeHash = true
+		b.contentType = "application/octet-stream"
+		data := payload(3*treeHashChunkSize + 10)
+
+		large := &s3BucketLarge{s3Bucket: *b, minPartSize: treeHashChunkSize}
+		require.NoError(t, large.Put(ctx, "key", bytes.NewReader(data)))
+		obj := client.objects["prefix/key"]
+		assert.Equal(t, referenceTreeHash(data), obj.metadata[treeHashMetadataKey])
+		assert.Equal(t, "application/octet-stream", obj.contentType)
+		assert.Equal(t, data, obj.data)
+	})
+	t.Run("LargeWriterStoresTreeHashOfObjectsLargerThanCopyLimit", func(t *testing.T) {
+		client := newMockS3Client()
+		b := newMockS3Bucket(client, "prefix")
+		b.computeTreeHash = true
+		b.maxCopyBytes = treeHashChunkSize
+		data := payload(3*treeHashChunkSize + 10)
+
+		large := &s3BucketLarge{s3Bucket: *b, minPartSize: treeHashChunkSize}
+		require.NoError(t, large.Put(ctx, "key", bytes.NewReader(data)))
+		obj := client.objects["prefix/key"]
+		assert.Equal(t, referenceTreeHash(data), obj.metadata[treeHashMetadataKey])
+		assert.Equal(t, data, obj.data)
+	})
+	t.Run("LargeWriterStoresTreeHashWithoutACLSupport", func(t *testing.T) {
+		client := newMockS3Client()
+		b := newMockS3Bucket(client, "prefix")
+		b.computeTreeHash = true
+		b.permissions = S3PermissionsPrivate
+		data := payload(3*treeHashChunkSize + 10)
+
+		client.aclsDisabled = true
+		large := &s3BucketLarge{s3Bucket: *b, minPartSize: treeHashChunkSize}
+		require.NoError(t, large.Put(ctx, "key", bytes.NewReader(data)))
+		assert.Equal(t, referenceTreeHash(data), client.objects["prefix/key"].metadata[treeHashMetadataKey])
+	})
+	t.Run("UploadReaderAtStoresTreeHash", func(t *testing.T) {
+		client := newMockS3Client()
+		b := newMockS3Bucket(client, "prefix")
+		b.computeTreeHash = true
+		data := payload(treeHashChunkSize + 10)
+
+		large := &s3BucketLarge{s3Bucket: *b, minPartSize: 5 * 1024 * 1024}
+		require.NoError(t, large.UploadReaderAt(ctx, "key", bytes.NewReader(data), int64(len(data))))
+		assert.Equal(t, referenceTreeHash(data), client.objects["prefix/key"].metadata[treeHashMetadataKey])
+	})
+}
+
+func TestS3WriterCancellation(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, test := range []struct {
+		name     string
+		compress bool
+		large    bool
+	}{
+		{name: "Small"},
+		{name: "SmallCompressed", compress: true},
+		{name: "Large", large: true},
+		{name: "LargeCompressed", large: true, compress: true},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			client := newMockS3Client()
+			b := newMockS3Bucket(client, "prefix")
+			b.compress = test.compress
+			var bucket Bucket = &s3BucketSmall{s3Bucket: *b}
+			if test.large {
+				bucket = &s3BucketLarge{s3Bucket: *b, minPartSize: 1024}
+			}
+
+			wctx, wcancel := context.WithCancel(ctx)
+			w, err := bucket.Writer(wctx, "key")
+			require.NoError(t, err)
+			// Write enough incompressible data that the large writer
+			// uploads parts before the context is canceled.
+			data := make([]byte, 4096)
+			_, err = rand.Read(data)
+			require.NoError(t, err)
+			_, err = w.Write(data)
+			require.NoError(t, err)
+			if test.large && !test.compress {
+				require.Len(t, client.uploads, 1, "large writer should have started a multipart upload")
+			}
+
+			wcancel()
+			err = w.Close()
+			assert.True(t, errors.Is(err, context.Canceled), "%v", err)
+			assert.Empty(t, client.objects)
+			assert.Empty(t, client.uploads, "multipart upload should be aborted")
+		})
+	}
+}
+
+func TestS3SmallWriterCloseTwice(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newMockS3Client()
+	b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+
+	w, err := b.Writer(ctx, "key")
+	require.NoError(t, err)
+	_, err = w.Write([]byte("data"))
+	require.NoError(t, err)
+	require.NoError(t, w.Close())
+	require.Len(t, client.putObjectCalls, 1)
+
+	assert.Error(t, w.Close())
+	assert.Len(t, client.putObjectCalls, 1)
+	data, err := readDataFromFile(ctx, b, "key")
+	require.NoError(t, err)
+	assert.Equal(t, "data", data)
+}
+
+func TestS3KeyTransform(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newMockS3Client()
+	b := newMockS3Bucket(client, "prefix")
+	b.keyTransform = URLKeyTransform{}
+	bucket := &s3BucketSmall{s3Bucket: *b}
+
+	keys := []string{"dir/with space.txt", "dir/per%cent#hash?q=1&x", "dir/ünïcode+plus"}
+	for _, key := range keys {
+		require.NoError(t, bucket.Put(ctx, key, strings.NewReader(key)))
+	}
+
+	t.Run("StoresEncodedKeys", func(t *testing.T) {
+		var stored []string
+		for key := range client.objects {
+			stored = append(stored, key)
+		}
+		assert.ElementsMatch(t, []string{
+			"prefix/dir/with%20space.txt",
+			"prefix/dir/per%25cent%23hash%3Fq%3D1%26x",
+			"prefix/dir/%C3%BCn%C3%AFcode%2Bplus",
+		}, stored)
+	})
+	t.Run("GetDecodesKeys", func(t *testing.T) {
+		for _, key := range keys {
+			data, err := readDataFromFile(ctx, bucket, key)
+			require.NoError(t, err)
+			assert.Equal(t, key, data)
+		}
+	})
+	t.Run("ListReturnsDecodedKeys", func(t *testing.T) {
+		iter, err := bucket.List(ctx, "dir")
+		require.NoError(t, err)
+		var listed []string
+		for iter.Next(ctx) {
+			listed = append(listed, iter.Item().Name())
+		}
+		require.NoError(t, iter.Err())
+		assert.ElementsMatch(t, keys, listed)
+	})
+	t.Run("CopyEncodedKeys", func(t *testing.T) {
+		require.NoError(t, bucket.Copy(ctx, CopyOptions{
+			SourceKey:         keys[1],
+			DestinationKey:    "copy/" + keys[1],
+			DestinationBucket: bucket,
+		}))
+		data, err := readDataFromFile(ctx, bucket, "copy/"+keys[1])
+		require.NoError(t, err)
+		assert.Equal(t, keys[1], data)
+	})
+}
+
+func TestURLKeyTransform(t *testing.T) {
+	transform := URLKeyTransform{}
+	for _, key := range []string{"", "plain/key.txt", "with space/and%percent", "a+b=c&d?e#f", "ünïcode/ключ"} {
+		encoded := transform.Encode(key)
+		assert.Equal(t, key, transform.Decode(encoded), key)
+		assert.True(t, strings.HasPrefix(transform.Encode(key+"suffix"), encoded), key)
+	}
+	assert.Equal(t, "not%zzencoded", transform.Decode("not%zzencoded"))
+}
+
+func TestS3ListWithAttributes(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newMockS3Client()
+	client.listPageSize = 30
+	storageClasses := []s3Types.StorageClass{s3Types.StorageClassStandard, s3Types.StorageClassGlacier}
+	expected := map[string]ObjectAttributes{}
+	for i := 0; i < 2*listAttributesBatchSize+10; i++ {
+		key := fmt.Sprintf("dir/key%03d", i)
+		data := []byte(key)
+		sum := sha256.Sum256(data)
+		checksum := base64.StdEncoding.EncodeToString(sum[:])
+		storageClass := storageClasses[i%len(storageClasses)]
+		client.putObject("prefix/"+key, data, mockS3Object{checksumSHA256: checksum, storageClass: storageClass})
+		expected[key] = ObjectAttributes{Size: int64(len(data)), StorageClass: string(storageClass), ChecksumSHA256: checksum}
+	}
+	client.putObject("prefix/other/key", []byte("other"), mockS3Object{})
+	b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+
+	t.Run("PopulatesAttributesOfEachItem", func(t *testing.T) {
+		iter, err := b.ListWithAttributes(ctx, "dir", []string{"Checksum", "StorageClass", "ObjectSize"})
+		require.NoError(t, err)
+
+		actual := map[string]ObjectAttributes{}
+		for iter.Next(ctx) {
+			item, ok := iter.Item().(AttributesBucketItem)
+			require.True(t, ok)
+			actual[item.Name()] = item.Attributes()
+
+			data, err := readDataFromFile(ctx, b, item.Name())
+			require.NoError(t, err)
+			assert.Equal(t, item.Name(), data)
+		}
+		require.NoError(t, iter.Err())
+		assert.Equal(t, expected, actual)
+		assert.Equal(t, len(expected), client.getAttributesCalls)
+	})
+	t.Run("RejectsUnknownAttributes", func(t *testing.T) {
+		_, err := b.ListWithAttributes(ctx, "dir", []string{"Checksum", "Owner"})
+		assert.Error(t, err)
+	})
+	t.Run("FailsWhenAttributesCannotBeFetched", func(t *testing.T) {
+		client := newMockS3Client()
+		client.putObject("prefix/dir/key", []byte("data"), mockS3Object{})
+		b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+
+		iter, err := b.ListWithAttributes(ctx, "dir", []string{"ObjectSize"})
+		require.NoError(t, err)
+		// Remove the object after it is listed, but before its
+		// attributes are fetched.
+		delete(client.objects, "prefix/dir/key")
+		assert.False(t, iter.Next(ctx))
+		assert.Error(t, iter.Err())
+	})
+}
+
+// concurrencyTrackingClient wraps the mock S3 client to record the maximum
+// number of concurrent HeadObject requests, each of which takes at least the
+// given delay.
+type concurrencyTrackingClient struct {
+	*mockS3Client
+	delay       time.Duration
+	mu          sync.Mutex
+	inFlight    int
+	maxInFlight int
+}
+
+func (c *concurrencyTrackingClient) HeadObject(ctx context.Context, input *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	c.mu.Lock()
+	c.inFlight++
+	if c.inFlight > c.maxInFlight {
+		c.maxInFlight = c.inFlight
+	}
+	c.mu.Unlock()
+	defer func() {
+		c.mu.Lock()
+		c.inFlight--
+		c.mu.Unlock()
+	}()
+
+	time.Sleep(c.delay)
+	return c.mockS3Client.HeadObject(ctx, input, optFns...)
+}
+
+func TestS3MetadataConcurrency(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const limit = 3
+	setup := func(t *testing.T, metadataConcurrency int) (*concurrencyTrackingClient, *s3Bucket, []string) {
+		client := &concurrencyTrackingClient{mockS3Client: newMockS3Client(), delay: 5 * time.Millisecond}
+		var keys []string
+		for i := 0; i < 40; i++ {
+			key := fmt.Sprintf("key%d", i)
+			if i%2 == 0 {
+				client.putObject("prefix/"+key, []byte(key), mockS3Object{})
+			}
+			keys = append(keys, key)
+		}
+		b := newMockS3Bucket(client.mockS3Client, "prefix")
+		b.svc = client
+		if metadataConcurrency > 0 {
+			b.metadataSlots = make(chan struct{}, metadataConcurrency)
+		}
+		return client, b, keys
+	}
+
+	t.Run("LimitsConcurrentHeadObjectRequests", func(t *testing.T) {
+		client, b, keys := setup(t, limit)
+
+		exists, err := ExistsMany(ctx, &s3BucketSmall{s3Bucket: *b}, keys, 16)
+		require.NoError(t, err)
+		require.Len(t, exists, len(keys))
+		for i, key := range keys {
+			assert.Equal(t, i%2 == 0, exists[key], key)
+		}
+		assert.Equal(t, limit, client.maxInFlight)
+	})
+	t.Run("LimitIsSharedAcrossCallers", func(t *testing.T) {
+		client, b, keys := setup(t, limit)
+
+		wg := &sync.WaitGroup{}
+		for i := 0; i < 4; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := ExistsMany(ctx, &s3BucketSmall{s3Bucket: *b}, keys, 4)
+				assert.NoError(t, err)
+			}()
+		}
+		wg.Wait()
+		assert.LessOrEqual(t, client.maxInFlight, limit)
+	})
+	t.Run("UnlimitedByDefault", func(t *testing.T) {
+		client, b, keys := setup(t, 0)
+
+		_, err := ExistsMany(ctx, &s3BucketSmall{s3Bucket: *b}, keys, 16)
+		require.NoError(t, err)
+		assert.Greater(t, client.maxInFlight, limit)
+	})
+	t.Run("WaitingRequestStopsWhenContextIsDone", func(t *testing.T) {
+		_, b, _ := setup(t, 1)
+		b.metadataSlots <- struct{}{}
+
+		tctx, tcancel := context.WithTimeout(ctx, 10*time.Millisecond)
+		defer tcancel()
+		_, err := b.Exists(tctx, "key0")
+		assert.True(t, errors.Is(err, context.DeadlineExceeded))
+	})
+}
+
+func TestS3GetReplicationStatus(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newMockS3Client()
+	b := newMockS3Bucket(client, "prefix")
+
+	for status, expected := range map[s3Types.ReplicationStatus]string{
+		"":                                 "",
+		s3Types.ReplicationStatusPending:   ReplicationStatusPending,
+		s3Types.ReplicationStatusCompleted: ReplicationStatusCompleted,
+		s3Types.ReplicationStatusComplete:  ReplicationStatusCompleted,
+		s3Types.ReplicationStatusFailed:    ReplicationStatusFailed,
+		s3Types.ReplicationStatusReplica:   ReplicationStatusReplica,
+	} {
+		key := fmt.Sprintf("key-%s", status)
+		client.putObject("prefix/"+key, []byte("data"), mockS3Object{replicationStatus: status})
+
+		actual, err := b.GetReplicationStatus(ctx, key)
+		require.NoError(t, err, status)
+		assert.Equal(t, expected, actual, status)
+	}
+
+	_, err := b.GetReplicationStatus(ctx, "DNE")
+	assert.True(t, IsKeyNotFoundError(err))
+}
+
+func TestS3PutMany(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	objects := map[string][]byte{}
+	for i := 0; i < 25; i++ {
+		objects[fmt.Sprintf("generated/key%d", i)] = []byte(fmt.Sprintf("payload %d", i))
+	}
+
+	t.Run("LargeBucketUploadsEachPayloadInSingleRequest", func(t *testing.T) {
+		client := newMockS3Client()
+		b := &s3BucketLarge{s3Bucket: *newMockS3Bucket(client, "prefix"), minPartSize: 1024 * 1024 * 5}
+
+		require.NoError(t, PutMany(ctx, b, objects, 8))
+		assert.Len(t, client.putObjectCalls, len(objects))
+		assert.Zero(t, client.uploadCount)
+		for key, payload := range objects {
+			data, err := readDataFromFile(ctx, b, key)
+			require.NoError(t, err)
+			assert.Equal(t, string(payload), data)
+		}
+	})
+	t.Run("AggregatesErrors", func(t *testing.T) {
+		client := newMockS3Client()
+		client.owner = "owner"
+		b := newMockS3Bucket(client, "prefix")
+		b.svc = &expectedOwnerClient{s3Client: client, owner: "other"}
+
+		err := PutMany(ctx, &s3BucketSmall{s3Bucket: *b}, objects, 8)
+		require.Error(t, err)
+		for key := range objects {
+			assert.Contains(t, err.Error(), fmt.Sprintf("putting key '%s'", key))
+		}
+	})
+	t.Run("InvalidKeyPreventsAllUploads", func(t *testing.T) {
+		client := newMockS3Client()
+		b := newMockS3Bucket(client, "prefix")
+		b.maxKeyLength = 20
+
+		err := PutMany(ctx, &s3BucketSmall{s3Bucket: *b}, map[string][]byte{
+			"short":                    []byte("data"),
+			strings.Repeat("long", 10): []byte("data"),
+		}, 2)
+		assert.True(t, errors.Is(err, ErrInvalidKey))
+		assert.Empty(t, client.objects)
+	})
+}
+
+func TestS3CopyToACLDisabledBucket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	setup := func(t *testing.T, permissions S3Permissions) (*mockS3Client, *s3BucketSmall, *s3BucketSmall) {
+		srcClient := newMockS3Client()
+		srcClient.putObject("src/key", []byte("data"), mockS3Object{})
+		src := &s3BucketSmall{s3Bucket: *newMockS3Bucket(srcClient, "src")}
+
+		destClient := newMockS3Client()
+		destClient.aclsDisabled = true
+		destClient.putObject("src/key", []byte("data"), mockS3Object{})
+		dest := &s3BucketSmall{s3Bucket: *newMockS3Bucket(destClient, "dest")}
+		dest.permissions = permissions
+
+		return destClient, src, dest
+	}
+	copyOpts := func(dest Bucket) CopyOptions {
+		return CopyOptions{SourceKey: "key", DestinationKey: "copied", DestinationBucket: dest}
+	}
+
+	t.Run("CopiesWithoutACL", func(t *testing.T) {
+		destClient, src, dest := setup(t, "")
+
+		require.NoError(t, src.Copy(ctx, copyOpts(dest)))
+		require.Len(t, destClient.copyCalls, 1)
+		assert.Empty(t, destClient.copyCalls[0].ACL)
+		data, err := readDataFromFile(ctx, dest, "copied")
+		require.NoError(t, err)
+		assert.Equal(t, "data", data)
+	})
+	t.Run("RejectedACLIsOmittedOnRetry", func(t *testing.T) {
+		destClient, src, dest := setup(t, S3PermissionsBucketOwnerFullControl)
+
+		require.NoError(t, src.Copy(ctx, copyOpts(dest)))
+		require.Len(t, destClient.copyCalls, 2)
+		assert.Equal(t, s3Types.ObjectCannedACLBucketOwnerFullControl, destClient.copyCalls[0].ACL)
+		assert.Empty(t, destClient.copyCalls[1].ACL)
+		data, err := readDataFromFile(ctx, dest, "copied")
+		require.NoError(t, err)
+		assert.Equal(t, "data", data)
+	})
+	t.Run("ReturnsErrorOfRetry", func(t *testing.T) {
+		destClient, src, dest := setup(t, S3PermissionsBucketOwnerFullControl)
+		delete(destClient.objects, "src/key")
+
+		require.Error(t, src.Copy(ctx, copyOpts(dest)))
+		assert.Len(t, destClient.copyCalls, 2)
+		_, err := readDataFromFile(ctx, dest, "copied")
+		assert.True(t, IsKeyNotFoundError(err))
+	})
+	t.Run("CannotCombineWithPermissions", func(t *testing.T) {
+		_, err := NewS3Bucket(ctx, S3Options{Name: "bucket", Region: "us-east-1", DisableACL: true, Permissions: S3PermissionsPrivate})
+		assert.Error(t, err)
+	})
+}
+
+func TestS3VerifyETag(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	mismatched := func(string, string) string { return `"0123456789abcdef0123456789abcdef"` }
+	for _, impl := range []struct {
+		name        string
+		constructor func(*mockS3Client) Bucket
+	}{
+		{
+			name: "Small",
+			constructor: func(client *mockS3Client) Bucket {
+				return &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+			},
+		},
+		{
+			name: "Large",
+			constructor: func(client *mockS3Client) Bucket {
+				return &s3BucketLarge{s3Bucket: *newMockS3Bucket(client, "prefix"), minPartSize: 4}
+			},
+		},
+	} {
+		t.Run(impl.name, func(t *testing.T) {
+			setup := func(verify bool) (*mockS3Client, Bucket) {
+				client := newMockS3Client()
+				b := impl.constructor(client)
+				switch bucket := b.(type) {
+				case *s3BucketSmall:
+					bucket.verifyETag = verify
+				case *s3BucketLarge:
+					bucket.verifyETag = verify
+				}
+				return client, b
+			}
+
+			t.Run("SucceedsWithMatchingETag", func(t *testing.T) {
+				_, b := setup(true)
+				require.NoError(t, writeDataToFile(ctx, b, "key", "some data"))
+				data, err := readDataFromFile(ctx, b, "key")
+				require.NoError(t, err)
+				assert.Equal(t, "some data", data)
+			})
+			t.Run("FailsWithMismatchedETag", func(t *testing.T) {
+				client, b := setup(true)
+				client.uploadETag = mismatched
+
+				err := writeDataToFile(ctx, b, "key", "some data")
+				require.Error(t, err)
+				assert.True(t, errors.Is(err, ErrChecksumMismatch))
+			})
+			t.Run("IgnoresMismatchedETagWithoutVerification", func(t *testing.T) {
+				client, b := setup(false)
+				client.uploadETag = mismatched
+
+				assert.NoError(t, writeDataToFile(ctx, b, "key", "some data"))
+			})
+		})
+	}
+}
+
+func TestS3ETagIsMD5(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newMockS3Client()
+	b := newMockS3Bucket(client, "prefix")
+	client.putObject("prefix/plain", []byte("data"), mockS3Object{serverSideEncryption: s3Types.ServerSideEncryptionAes256})
+	client.putObject("prefix/kms", []byte("data"), mockS3Object{serverSideEncryption: s3Types.ServerSideEncryptionAwsKms})
+
+	for key, expected := range map[string]bool{"plain": true, "kms": false} {
+		isMD5, err := b.etagIsMD5(ctx, key)
+		require.NoError(t, err)
+		assert.Equal(t, expected, isMD5, key)
+	}
+	_, err := b.etagIsMD5(ctx, "missing")
+	assert.Error(t, err)
+
+	t.Run("CompressedObjects", func(t *testing.T) {
+		client := newMockS3Client()
+		b := newMockS3Bucket(client, "prefix")
+		b.compress = true
+		data := map[string]string{}
+		for i := 0; i < 10; i++ {
+			key := fmt.Sprintf("file%d", i)
+			data[key] = strings.Repeat(fmt.Sprintf("content for file %d\n", i), 100)
+			require.NoError(t, writeDataToFile(ctx, &s3BucketSmall{s3Bucket: *b}, "remote/"+key, data[key]))
+		}
+
+		isMD5, err := b.etagIsMD5(ctx, "remote/file0")
+		require.NoError(t, err)
+		assert.False(t, isMD5)
+
+		pb, err := NewParallelSyncBucket(ParallelBucketOptions{Workers: 4, VerifyChecksums: true}, &s3BucketSmall{s3Bucket: *b})
+		require.NoError(t, err)
+		local := t.TempDir()
+		require.NoError(t, pb.Pull(ctx, SyncOptions{Local: local, Remote: "remote"}))
+		assert.NoError(t, checkLocalTreeMatchesData(ctx, local, data))
+	})
+}
+
+func TestS3DiffPrefixETags(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := newMockS3Client()
+	b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+	client.putObject("prefix/src/plain", []byte("data"), mockS3Object{serverSideEncryption: s3Types.ServerSideEncryptionAes256})
+	client.putObject("prefix/src/kms", []byte("data"), mockS3Object{serverSideEncryption: s3Types.ServerSideEncryptionAwsKms})
+
+	dst, err := NewMemoryBucket(MemoryBucketOptions{})
+	require.NoError(t, err)
+	for _, key := range []string{"dst/plain", "dst/kms"} {
+		require.NoError(t, dst.Put(ctx, key, strings.NewReader("abcd")))
+	}
+
+	diff, err := DiffPrefix(ctx, b, dst, "src", "dst")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"plain"}, diff.Different)
+}
+
+func TestS3ServerSideEncryption(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	kms := serverSideEncryption{algorithm: s3Types.ServerSideEncryptionAwsKms, kmsKeyID: "key-id"}
+	assertEncryption := func(t *testing.T, client *mockS3Client, key string, expected serverSideEncryption) {
+		obj, ok := client.objects[key]
+		require.True(t, ok)
+		assert.Equal(t, expected.algorithm, obj.serverSideEncryption)
+		assert.Equal(t, expected.kmsKeyID, obj.sseKMSKeyID)
+	}
+
+	for _, impl := range []struct {
+		name        string
+		constructor func(*mockS3Client) Bucket
+	}{
+		{
+			name: "Small",
+			constructor: func(client *mockS3Client) Bucket {
+				b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+				b.encryption = kms
+				return b
+			},
+		},
+		{
+			name: "Large",
+			constructor: func(client *mockS3Client) Bucket {
+				b := &s3BucketLarge{s3Bucket: *newMockS3Bucket(client, "prefix"), minPartSize: 4}
+				b.encryption = kms
+				return b
+			},
+		},
+	} {
+		t.Run(impl.name, func(t *testing.T) {
+			t.Run("WriterEncryptsObject", func(t *testing.T) {
+				client := newMockS3Client()
+				b := impl.constructor(client)
+				require.NoError(t, writeDataToFile(ctx, b, "key", "some data"))
+				assertEncryption(t, client, "prefix/key", kms)
+			})
+			t.Run("CopyEncryptsDestination", func(t *testing.T) {
+				client := newMockS3Client()
+				b := impl.constructor(client)
+				client.putObject("prefix/src", []byte("some data"), mockS3Object{})
+
+				require.NoError(t, b.Copy(ctx, CopyOptions{SourceKey: "src", DestinationKey: "dst", DestinationBucket: b}))
+				assertEncryption(t, client, "prefix/dst", kms)
+			})
+		})
+	}
+
+	t.Run("TouchKeepsEncryptionOfObjectByDefault", func(t *testing.T) {
+		client := newMockS3Client()
+		b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+		client.putObject("prefix/key", []byte("some data"), mockS3Object{serverSideEncryption: kms.algorithm, sseKMSKeyID: kms.kmsKeyID})
+
+		require.NoError(t, b.Touch(ctx, "key"))
+		assertEncryption(t, client, "prefix/key", kms)
+	})
+	t.Run("TouchReappliesConfiguredEncryption", func(t *testing.T) {
+		client := newMockS3Client()
+		b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+		b.encryption = kms
+		client.putObject("prefix/key", []byte("some data"), mockS3Object{serverSideEncryption: s3Types.ServerSideEncryptionAes256})
+
+		require.NoError(t, b.Touch(ctx, "key"))
+		assertEncryption(t, client, "prefix/key", kms)
+	})
+	t.Run("ValidatesOptions", func(t *testing.T) {
+		for name, opts := range map[string]S3Options{
+			"UnknownAlgorithm":      {ServerSideEncryption: "rot13"},
+			"KeyWithoutKMS":         {ServerSideEncryption: string(s3Types.ServerSideEncryptionAes256), SSEKMSKeyID: "key-id"},
+			"KeyWithoutAlgorithm":   {SSEKMSKeyID: "key-id"},
+			"VerifyETagWithKMSKeys": {ServerSideEncryption: string(s3Types.ServerSideEncryptionAwsKms), VerifyETag: true},
+		} {
+			t.Run(name, func(t *testing.T) {
+				opts.Name = "bucket"
+				opts.Region = "us-east-1"
+				_, err := NewS3Bucket(ctx, opts)
+				assert.Error(t, err)
+			})
+		}
+	})
+}
+
+func TestS3ReEncrypt(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	const newKey = "arn:aws:kms:us-east-1:123456789012:key/new"
+	setup := func() (*mockS3Client, *s3BucketSmall) {
+		client := newMockS3Client()
+		client.putObject("prefix/dir/old", []byte("old"), mockS3Object{serverSideEncryption: s3Types.ServerSideEncryptionAwsKms, sseKMSKeyID: "old-key", storageClass: s3Types.StorageClassStandardIa})
+		client.putObject("prefix/dir/new", []byte("new"), mockS3Object{serverSideEncryption: s3Types.ServerSideEncryptionAwsKms, sseKMSKeyID: newKey})
+		client.putObject("prefix/dir/default", []byte("default"), mockS3Object{})
+		client.putObject("prefix/other", []byte("other"), mockS3Object{})
+		return client, &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+	}
+	copiedKeys := func(client *mockS3Client) []string {
+		var keys []string
+		for _, call := range client.copyCalls {
+			keys = append(keys, aws.ToString(call.Key))
+		}
+		sort.Strings(keys)
+		return keys
+	}
+
+	t.Run("CopiesOnlyObjectsUnderDifferentKey", func(t *testing.T) {
+		client, b := setup()
+		require.NoError(t, b.ReEncrypt(ctx, "dir/", newKey, 2))
+
+		assert.Equal(t, []string{"prefix/dir/default", "prefix/dir/old"}, copiedKeys(client))
+		for _, key := range []string{"prefix/dir/default", "prefix/dir/old", "prefix/dir/new"} {
+			assert.Equal(t, s3Types.ServerSideEncryptionAwsKms, client.objects[key].serverSideEncryption, key)
+			assert.Equal(t, newKey, client.objects[key].sseKMSKeyID, key)
+		}
+		assert.Equal(t, s3Types.StorageClassStandardIa, client.objects["prefix/dir/old"].storageClass)
+		assert.Empty(t, client.objects["prefix/other"].sseKMSKeyID)
+
+		data, err := readDataFromFile(ctx, b, "dir/old")
+		require.NoError(t, err)
+		assert.Equal(t, "old", data)
+	})
+	t.Run("DryRunDoesNotCopy", func(t *testing.T) {
+		client, b := setup()
+		b.dryRun = true
+		require.NoError(t, b.ReEncrypt(ctx, "dir/", newKey, 2))
+		assert.Empty(t, client.copyCalls)
+	})
+	t.Run("ReturnsErrors", func(t *testing.T) {
+		client, b := setup()
+		client.headObjectErrs = map[string]error{"prefix/dir/old": mockS3APIError("AccessDenied")}
+		assert.Error(t, b.ReEncrypt(ctx, "dir/", newKey, 2))
+	})
+	t.Run("ComparesKeyIDWithARN", func(t *testing.T) {
+		client, b := setup()
+		require.NoError(t, b.ReEncrypt(ctx, "dir/", "new", 2))
+		assert.Equal(t, []string{"prefix/dir/default", "prefix/dir/old"}, copiedKeys(client))
+	})
+	t.Run("ResolvesAlias", func(t *testing.T) {
+		client, b := setup()
+		client.kmsAliases = map[string]string{"alias/new": newKey}
+		require.NoError(t, b.ReEncrypt(ctx, "dir/", "alias/new", 1))
+		for _, key := range []string{"prefix/dir/default", "prefix/dir/old", "prefix/dir/new"} {
+			assert.Equal(t, newKey, client.objects[key].sseKMSKeyID, key)
+		}
+
+		client.copyCalls = nil
+		require.NoError(t, b.ReEncrypt(ctx, "dir/", "alias/new", 1))
+		// Until the alias is resolved by the first copy, no object is
+		// known to be encrypted with it.
+		assert.Equal(t, []string{"prefix/dir/default"}, copiedKeys(client))
+	})
+	t.Run("CopiesLargeObjectsInParts", func(t *testing.T) {
+		client, b := setup()
+		b.maxCopyBytes = 2
+		require.NoError(t, b.ReEncrypt(ctx, "dir/old", newKey, 1))
+		assert.Empty(t, client.copyCalls)
+		assert.Len(t, client.uploadPartCopyCalls, 2)
+		assert.Equal(t, newKey, client.objects["prefix/dir/old"].sseKMSKeyID)
+		assert.Equal(t, []byte("old"), client.objects["prefix/dir/old"].data)
+	})
+	t.Run("ContinuesPastErrors", func(t *testing.T) {
+		client, b := setup()
+		client.headObjectErrs = map[string]error{
+			"prefix/dir/old": mockS3APIError("AccessDenied"),
+			"prefix/dir/new": mockS3APIError("AccessDenied"),
+		}
+		err := b.ReEncrypt(ctx, "dir/", newKey, 1)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "'dir/old'")
+		assert.Contains(t, err.Error(), "'dir/new'")
+		assert.Equal(t, newKey, client.objects["prefix/dir/default"].sseKMSKeyID)
+	})
+	t.Run("RequiresKey", func(t *testing.T) {
+		_, b := setup()
+		assert.Error(t, b.ReEncrypt(ctx, "dir/", "", 2))
+	})
+}
+
+func TestS3Presign(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	setup := func(creds aws.CredentialsProvider) *s3BucketSmall {
+		b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(newMockS3Client(), "prefix")}
+		b.presignSvc = s3.New(s3.Options{Region: "us-west-2", Credentials: creds})
+		return b
+	}
+	parse := func(t *testing.T, rawURL string) *url.URL {
+		parsed, err := url.Parse(rawURL)
+		require.NoError(t, err)
+		return parsed
+	}
+	creds := CreateAWSCredentials("access-key", "secret-key", "")
+
+	t.Run("GetSignsNormalizedKey", func(t *testing.T) {
+		presigned, err := setup(creds).PresignGet(ctx, "dir/key", time.Hour)
+		require.NoError(t, err)
+		parsed := parse(t, presigned)
+		assert.Equal(t, "/prefix/dir/key", parsed.Path)
+		assert.Contains(t, parsed.Host, "us-west-2")
+		assert.Equal(t, "3600", parsed.Query().Get("X-Amz-Expires"))
+		assert.Contains(t, parsed.Query().Get("X-Amz-Credential"), "access-key/")
+		assert.NotEmpty(t, parsed.Query().Get("X-Amz-Signature"))
+	})
+	t.Run("GetSignsContentDisposition", func(t *testing.T) {
+		presigned, err := setup(creds).PresignGetWithOptions(ctx, "key", PresignGetOptions{
+			Expires:                    time.Minute,
+			ResponseContentDisposition: `attachment; filename="file.txt"`,
+		})
+		require.NoError(t, err)
+		assert.Equal(t, `attachment; filename="file.txt"`, parse(t, presigned).Query().Get("response-content-disposition"))
+	})
+	t.Run("PutSignsNormalizedKey", func(t *testing.T) {
+		b := setup(creds)
+		b.maxKeyLength = 16
+		presigned, err := b.PresignPut(ctx, "key", time.Hour)
+		require.NoError(t, err)
+		assert.Equal(t, "/prefix/key", parse(t, presigned).Path)
+
+		_, err = b.PresignPut(ctx, strings.Repeat("k", 16), time.Hour)
+		assert.Error(t, err)
+	})
+	t.Run("RejectsInvalidExpiry", func(t *testing.T) {
+		b := setup(creds)
+		for _, expires := range []time.Duration{0, -time.Minute, 8 * 24 * time.Hour} {
+			_, err := b.PresignGet(ctx, "key", expires)
+			assert.Error(t, err)
+			_, err = b.PresignPut(ctx, "key", expires)
+			assert.Error(t, err)
+		}
+	})
+	t.Run("FailsWithoutCredentials", func(t *testing.T) {
+		for name, creds := range map[string]aws.CredentialsProvider{
+			"Missing":   nil,
+			"Anonymous": aws.AnonymousCredentials{},
+		} {
+			t.Run(name, func(t *testing.T) {
+				_, err := setup(creds).PresignGet(ctx, "key", time.Hour)
+				assert.Error(t, err)
+			})
+		}
+	})
+	t.Run("FailsWithoutS3Client", func(t *testing.T) {
+		b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(newMockS3Client(), "prefix")}
+		_, err := b.PresignGet(ctx, "key", time.Hour)
+		assert.Error(t, err)
+	})
+}
+
+func TestS3UploadWithResult(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	for _, impl := range []struct {
+		name        string
+		constructor func(*s3Bucket) Bucket
+	}{
+		{
+			name:        "Small",
+			constructor: func(b *s3Bucket) Bucket { return &s3BucketSmall{s3Bucket: *b} },
+		},
+		{
+			name:        "Large",
+			constructor: func(b *s3Bucket) Bucket { return &s3BucketLarge{s3Bucket: *b, minPartSize: 4} },
+		},
+	} {
+		t.Run(impl.name, func(t *testing.T) {
+			setup := func(t *testing.T, checksums bool) (*mockS3Client, UploadResultBucket, string) {
+				client := newMockS3Client()
+				base := newMockS3Bucket(client, "prefix")
+				base.singleFileChecksums = checksums
+				path := filepath.Join(t.TempDir(), "file")
+				require.NoError(t, os.WriteFile(path, []byte("abc"), 0600))
+				return client, impl.constructor(base).(UploadResultBucket), path
+			}
+
+			t.Run("SkipsUnchangedFile", func(t *testing.T) {
+				client, b, path := setup(t, true)
+				if impl.name == "Large" {
+					// The ETags of multipart uploads are not
+					// checksums of the file, so store the object as
+					// a single part.
+					client.putObject("prefix/key", []byte("abc"), mockS3Object{})
+				} else {
+					result, err := b.UploadWithResult(ctx, "key", path)
+					require.NoError(t, err)
+					assert.Equal(t, UploadResult{Uploaded: true, BytesTransferred: 3}, result)
+				}
+
+				result, err := b.UploadWithResult(ctx, "key", path)
+				require.NoError(t, err)
+				assert.Equal(t, UploadResult{Skipped: true}, result)
+
+				require.NoError(t, os.WriteFile(path, []byte("abcd"), 0600))
+				result, err = b.UploadWithResult(ctx, "key", path)
+				require.NoError(t, err)
+				assert.Equal(t, UploadResult{Uploaded: true, BytesTransferred: 4}, result)
+			})
+			t.Run("UploadsUnchangedFileWithoutChecksums", func(t *testing.T) {
+				_, b, path := setup(t, false)
+				for i := 0; i < 2; i++ {
+					result, err := b.UploadWithResult(ctx, "key", path)
+					require.NoError(t, err)
+					assert.Equal(t, UploadResult{Uploaded: true, BytesTransferred: 3}, result)
+				}
+			})
+			t.Run("DryRunReportsNeither", func(t *testing.T) {
+				client, b, path := setup(t, false)
+				switch bucket := b.(type) {
+				case *s3BucketSmall:
+					bucket.dryRun = true
+				case *s3BucketLarge:
+					bucket.dryRun = true
+				}
+				result, err := b.UploadWithResult(ctx, "key", path)
+				require.NoError(t, err)
+				assert.Equal(t, UploadResult{}, result)
+				assert.NotContains(t, client.objects, "prefix/key")
+			})
+		})
+	}
+}
+
+func TestS3ListParallel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	setup := func(t *testing.T) (*mockS3Client, *s3BucketSmall, []string) {
+		client := newMockS3Client()
+		client.listPageSize = 3
+		b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+		var keys []string
+		for _, dir := range []string{"a", "b", "c", "d"} {
+			for i := 0; i < 10; i++ {
+				keys = append(keys, fmt.Sprintf("logs/%s/%d", dir, i), fmt.Sprintf("logs/%s/nested/%d", dir, i))
+			}
+		}
+		keys = append(keys, "logs/top0", "logs/top1", "logs-old/file", "other/file")
+		for _, key := range keys {
+			require.NoError(t, writeDataToFile(ctx, b, key, key))
+		}
+		return client, b, keys
+	}
+	listAll := func(t *testing.T, iter BucketIterator) []string {
+		var names []string
+		for iter.Next(ctx) {
+			names = append(names, iter.Item().Name())
+		}
+		require.NoError(t, iter.Err())
+		return names
+	}
+
+	t.Run("ListsSameItemsAsList", func(t *testing.T) {
+		_, b, keys := setup(t)
+		for _, prefix := range []string{"", "logs", "logs/", "logs/a/"} {
+			for _, workers := range []int{1, 3, 16} {
+				iter, err := b.List(ctx, prefix)
+				require.NoError(t, err)
+				expected := listAll(t, iter)
+				require.NotEmpty(t, expected)
+				if prefix == "" {
+					require.Len(t, expected, len(keys))
+				}
+
+				iter, err = ListParallel(ctx, b, prefix, workers)
+				require.NoError(t, err)
+				assert.ElementsMatch(t, expected, listAll(t, iter), "prefix '%s' with %d workers", prefix, workers)
+			}
+		}
+	})
+	t.Run("ReturnsWorkerError", func(t *testing.T) {
+		client, b, _ := setup(t)
+		client.listObjectsErrs = map[string]error{"prefix/logs/c/": errors.New("listing failed")}
+
+		iter, err := ListParallel(ctx, b, "logs/", 3)
+		require.NoError(t, err)
+		for iter.Next(ctx) {
+		}
+		require.Error(t, iter.Err())
+		assert.Contains(t, iter.Err().Error(), "listing failed")
+		assert.False(t, iter.Next(ctx))
+	})
+	t.Run("FailsIfSubPrefixesCannotBeListed", func(t *testing.T) {
+		client, b, _ := setup(t)
+		client.listObjectsErrs = map[string]error{"prefix/logs/": errors.New("listing failed")}
+
+		_, err := ListParallel(ctx, b, "logs/", 2)
+		assert.Error(t, err)
+	})
+	t.Run("StopsWhenContextIsCanceled", func(t *testing.T) {
+		_, b, _ := setup(t)
+		listCtx, listCancel := context.WithCancel(ctx)
+		iter, err := ListParallel(listCtx, b, "logs/", 4)
+		require.NoError(t, err)
+		require.True(t, iter.Next(ctx))
+
+		listCancel()
+		for iter.Next(ctx) {
+		}
+		assert.True(t, errors.Is(iter.Err(), context.Canceled))
+	})
+	t.Run("FallsBackToListForOtherBuckets", func(t *testing.T) {
+		local, err := NewLocalBucket(LocalOptions{Path: t.TempDir()})
+		require.NoError(t, err)
+		for _, key := range []string{"logs/a/0", "logs/b/0", "logs/c"} {
+			require.NoError(t, writeDataToFile(ctx, local, key, key))
+		}
+
+		iter, err := ListParallel(ctx, local, "logs", 4)
+		require.NoError(t, err)
+		assert.Equal(t, []string{"logs/a/0", "logs/b/0", "logs/c"}, listAll(t, iter))
+	})
+}
+
+func TestS3VerifyCRC32C(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// putWithCRC32C uploads the data to the key with the CRC32C checksum
+	// algorithm, as S3 clients outside of the bucket might.
+	putWithCRC32C := func(t *testing.T, client *mockS3Client, key string, data []byte, encoding string) {
+		input := &s3.PutObjectInput{
+			Bucket:            aws.String("bucket"),
+			Key:               aws.String(key),
+			Body:              bytes.NewReader(data),
+			ChecksumAlgorithm: s3Types.ChecksumAlgorithmCrc32c,
+		}
+		if encoding != "" {
+			input.ContentEncoding = aws.String(encoding)
+		}
+		_, err := client.PutObject(ctx, input)
+		require.NoError(t, err)
+	}
+	newBucket := func(client *mockS3Client) *s3BucketSmall {
+		b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+		b.verifyCRC32C = true
+		return b
+	}
+
+	t.Run("ReadsMatchingData", func(t *testing.T) {
+		client := newMockS3Client()
+		putWithCRC32C(t, client, "prefix/key", []byte("some data"), "")
+		b := newBucket(client)
+
+		data, err := readDataFromFile(ctx, b, "key")
+		require.NoError(t, err)
+		assert.Equal(t, "some data", data)
+
+		path := filepath.Join(t.TempDir(), "file")
+		require.NoError(t, b.Download(ctx, "key", path))
+		downloaded, err := os.ReadFile(path)
+		require.NoError(t, err)
+		assert.Equal(t, "some data", string(downloaded))
+		assert.Zero(t, client.getAttributesCalls, "checksum should be returned with the object")
+	})
+	t.Run("ChecksumIsOfCompressedData", func(t *testing.T) {
+		client := newMockS3Client()
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		_, err := gz.Write([]byte("some data"))
+		require.NoError(t, err)
+		require.NoError(t, gz.Close())
+		putWithCRC32C(t, client, "prefix/key", compressed.Bytes(), "gzip")
+
+		data, err := readDataFromFile(ctx, newBucket(client), "key")
+		require.NoError(t, err)
+		assert.Equal(t, "some data", data)
+	})
+	t.Run("DetectsCorruption", func(t *testing.T) {
+		client := newMockS3Client()
+		client.corrupt = func(_ string, data []byte) []byte {
+			corrupted := append([]byte(nil), data...)
+			corrupted[0] ^= 0xff
+			return corrupted
+		}
+		putWithCRC32C(t, client, "prefix/key", []byte("some data"), "")
+		b := newBucket(client)
+
+		r, err := b.Get(ctx, "key")
+		require.NoError(t, err)
+		_, err = io.ReadAll(r)
+		assert.True(t, errors.Is(err, ErrChecksumMismatch))
+		_, err = r.Read(make([]byte, 1))
+		assert.True(t, errors.Is(err, ErrChecksumMismatch), "checksum error should persist")
+		require.NoError(t, r.Close())
+
+		err = b.Download(ctx, "key", filepath.Join(t.TempDir(), "file"))
+		assert.True(t, errors.Is(err, ErrChecksumMismatch))
+
+		b.verifyCRC32C = false
+		_, err = readDataFromFile(ctx, b, "key")
+		assert.NoError(t, err, "data should not be validated unless requested")
+	})
+	t.Run("SkipsObjectsWithoutCRC32C", func(t *testing.T) {
+		client := newMockS3Client()
+		b := newBucket(client)
+		require.NoError(t, writeDataToFile(ctx, b, "key", "some data"))
+
+		data, err := readDataFromFile(ctx, b, "key")
+		require.NoError(t, err)
+		assert.Equal(t, "some data", data)
+	})
+	t.Run("MissingKeyIsNotFound", func(t *testing.T) {
+		_, err := newBucket(newMockS3Client()).Get(ctx, "missing")
+		assert.True(t, IsKeyNotFound(err))
+	})
+}
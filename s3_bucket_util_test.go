@@ -276,7 +276,16 @@ func getS3SmallBucketTests(ctx context.Context, tempdir string, s3Credentials aw
 				require.NoError(t, err)
 				require.NoError(t, cw.Close())
 				assert.Equal(t, len(data), n)
-				compressedData := cw.(*compressingWriteCloser).s3Writer.(*smallWriteCloser).buffer
+				// The writer's buffer is released once the object is
+				// uploaded, so read the compressed object as stored.
+				rawObject, err := rawBucket.svc.GetObject(ctx, &s3.GetObjectInput{
+					Bucket: aws.String(s3BucketName),
+					Key:    aws.String(rawBucket.normalizeKey(compressedKey)),
+				})
+				require.NoError(t, err)
+				compressedData, err := ioutil.ReadAll(rawObject.Body)
+				require.NoError(t, err)
+				require.NoError(t, rawObject.Body.Close())
 
 				reader, err := gzip.NewReader(bytes.NewReader(compressedData))
 				require.NoError(t, err)
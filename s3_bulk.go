@@ -0,0 +1,71 @@
+package pail
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+)
+
+// forEachObjectPage calls the function with each page of the objects with the
+// given prefix, stopping at the first error.
+func (s *s3Bucket) forEachObjectPage(ctx context.Context, prefix string, fn func([]s3Types.Object) error) error {
+	marker := ""
+	for {
+		contents, isTruncated, err := getObjectsWrapper(ctx, s, s.normalizeListPrefix(prefix), marker)
+		if err != nil {
+			return errors.WithStack(err)
+		}
+		if err = fn(contents); err != nil {
+			return err
+		}
+		if !isTruncated || len(contents) == 0 {
+			return nil
+		}
+		marker = aws.ToString(contents[len(contents)-1].Key)
+	}
+}
+
+// forEachObject calls the function with each object with the given prefix,
+// using the given number of concurrent workers. Objects are processed as they
+// are listed, so the listing is never held in memory. It continues past the
+// objects that the function fails for and returns all of the errors once
+// every object is processed; it only stops early if the listing fails or the
+// context is canceled.
+func (s *s3Bucket) forEachObject(ctx context.Context, prefix string, workers int, fn func(context.Context, s3Types.Object) error) error {
+	if workers < 1 {
+		workers = 1
+	}
+
+	objects := make(chan s3Types.Object)
+	catcher := grip.NewBasicCatcher()
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for obj := range objects {
+				catcher.Add(fn(ctx, obj))
+			}
+		}()
+	}
+
+	err := s.forEachObjectPage(ctx, prefix, func(contents []s3Types.Object) error {
+		for _, obj := range contents {
+			select {
+			case objects <- obj:
+			case <-ctx.Done():
+				return errors.WithStack(ctx.Err())
+			}
+		}
+		return nil
+	})
+	close(objects)
+	wg.Wait()
+	catcher.Add(err)
+
+	return catcher.Resolve()
+}
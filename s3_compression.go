@@ -0,0 +1,435 @@
+package pail
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/klauspost/compress/zstd"
+	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
+	"github.com/pkg/errors"
+)
+
+// gzipMagic is the header that every gzip stream starts with.
+var gzipMagic = []byte{0x1f, 0x8b}
+
+// CompressionRepairBucket is implemented by buckets that can fix the content
+// encoding of objects that were uploaded compressed without it.
+type CompressionRepairBucket interface {
+	// RepairCompressionMetadata sets the content encoding of every object
+	// with the given prefix that has none, but whose data is
+	// gzip-compressed, so that it is decompressed when read. Objects that
+	// are gzip files in their own right, such as tarballs, are left
+	// unchanged.
+	RepairCompressionMetadata(ctx context.Context, prefix string, opts RepairCompressionOptions) error
+}
+
+// RepairCompressionOptions configures RepairCompressionMetadata.
+type RepairCompressionOptions struct {
+	// Workers is the number of objects that are checked and repaired
+	// concurrently. Defaults to 1.
+	Workers int
+	// DryRun logs the objects that would be repaired without changing
+	// them. Buckets configured for dry runs never change objects.
+	DryRun bool
+}
+
+// CompressedGetBucket is implemented by buckets that can return objects as
+// they are stored, without decompressing them, for example so that an HTTP
+// server can pass compressed objects through to clients that accept their
+// encoding.
+type CompressedGetBucket interface {
+	// GetToWriterCompressed writes the contents of the object with the
+	// given key to the writer as they are stored and returns their content
+	// encoding, which is empty if they are not compressed. Objects that
+	// cannot be decoded without bucket-specific configuration, such as
+	// Zstandard objects compressed with a dictionary, are decompressed
+	// before they are written, and an empty encoding is returned.
+	// If the writer is an http.ResponseWriter, or otherwise has a
+	// Header method, its Content-Encoding header is set to the encoding
+	// before anything is written, since headers cannot be changed once
+	// the body is started.
+	GetToWriterCompressed(ctx context.Context, key string, w io.Writer) (string, error)
+}
+
+// CompressionAlgorithm is the algorithm used to compress objects uploaded to
+// a bucket.
+type CompressionAlgorithm string
+
+const (
+	// CompressionGzip compresses objects with gzip.
+	CompressionGzip CompressionAlgorithm = "gzip"
+	// CompressionZstd compresses objects with Zstandard, which is faster
+	// and compresses better than gzip, and supports shared dictionaries.
+	CompressionZstd CompressionAlgorithm = "zstd"
+)
+
+// Validate checks that the compression algorithm is supported.
+func (a CompressionAlgorithm) Validate() error {
+	switch a {
+	case CompressionGzip, CompressionZstd:
+		return nil
+	default:
+		return errors.Errorf("unsupported compression algorithm '%s'", a)
+	}
+}
+
+// zstdDictionaryMetadataKey is the object metadata key that records the ID of
+// the dictionary that an object was compressed with. S3 returns metadata keys
+// in lowercase.
+const zstdDictionaryMetadataKey = "pail-zstd-dictionary-id"
+
+// zstdDictionaryID returns the ID of a Zstandard dictionary, checking that the
+// dictionary is well-formed.
+func zstdDictionaryID(dict []byte) (string, error) {
+	if len(dict) < 8 || !bytes.Equal(dict[:4], []byte{0x37, 0xa4, 0x30, 0xec}) {
+		return "", errors.New("compression dictionary is not a Zstandard dictionary")
+	}
+	enc, err := zstd.NewWriter(nil, zstd.WithEncoderDict(dict))
+	if err != nil {
+		return "", errors.Wrap(err, "loading compression dictionary")
+	}
+	grip.Warning(enc.Close())
+
+	return strconv.FormatUint(uint64(binary.LittleEndian.Uint32(dict[4:8])), 10), nil
+}
+
+// contentEncoding returns the content encoding of objects uploaded to the
+// bucket, or an empty string if the bucket does not compress objects.
+func (s *s3Bucket) contentEncoding() string {
+	if !s.compress {
+		return ""
+	}
+	if s.compressionAlgorithm == "" {
+		return string(CompressionGzip)
+	}
+	return string(s.compressionAlgorithm)
+}
+
+// compressionMetadata returns the object metadata that records how objects
+// uploaded to the bucket are compressed.
+func (s *s3Bucket) compressionMetadata() map[string]string {
+	if s.contentEncoding() != string(CompressionZstd) || s.compressionDictionaryID == "" {
+		return nil
+	}
+	return map[string]string{zstdDictionaryMetadataKey: s.compressionDictionaryID}
+}
+
+// newCompressingWriter wraps the given writer so that the data written to it
+// is compressed with the bucket's compression algorithm.
+func (s *s3Bucket) newCompressingWriter(w io.WriteCloser) (io.WriteCloser, error) {
+	var compressor io.WriteCloser
+	switch s.contentEncoding() {
+	case string(CompressionZstd):
+		var opts []zstd.EOption
+		if len(s.compressionDictionary) > 0 {
+			// The encoder's default level makes little use of
+			// dictionaries, so use a level that does.
+			opts = append(opts,
+				zstd.WithEncoderDict(s.compressionDictionary),
+				zstd.WithEncoderLevel(zstd.SpeedBetterCompression),
+			)
+		}
+		enc, err := zstd.NewWriter(w, opts...)
+		if err != nil {
+			return nil, errors.Wrap(err, "creating zstd encoder")
+		}
+		compressor = enc
+	default:
+		compressor = gzip.NewWriter(w)
+	}
+
+	return &compressingWriteCloser{compressor: compressor, s3Writer: w}, nil
+}
+
+// shouldSniff returns whether an object with the given key and content type
+// that has no content encoding is checked for gzip-compressed data.
+func (s *s3Bucket) shouldSniff(key, contentType string) bool {
+	return s.sniffCompression && !isGzipFile(key, contentType)
+}
+
+// isGzipFile returns whether an object with the given key and content type is
+// a gzip file in its own right, such as a tarball, which is stored compressed
+// on purpose and must not be decompressed.
+func isGzipFile(key, contentType string) bool {
+	lowerKey := strings.ToLower(key)
+	for _, ext := range []string{".gz", ".tgz", ".gzip"} {
+		if strings.HasSuffix(lowerKey, ext) {
+			return true
+		}
+	}
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		mediaType = strings.ToLower(strings.TrimSpace(contentType))
+	}
+	switch mediaType {
+	case "application/gzip", "application/x-gzip", "application/x-tgz", "application/x-gtar":
+		return true
+	default:
+		return false
+	}
+}
+
+// newDecompressingReader wraps the body of an object with the given content
+// encoding and metadata so that it is decompressed incrementally as it is
+// read. If the object has no content encoding, it is decompressed only if
+// sniff is set and its data is gzip-compressed. Closing the returned reader
+// closes the body. Objects compressed with a dictionary can only be read if
+// the bucket is configured with the same dictionary.
+func (s *s3Bucket) newDecompressingReader(contentEncoding string, metadata map[string]string, sniff bool, body io.ReadCloser) (io.ReadCloser, error) {
+	switch contentEncoding {
+	case string(CompressionGzip):
+		gz, err := gzip.NewReader(body)
+		if err != nil {
+			_ = body.Close()
+			return nil, errors.Wrap(err, "creating gzip reader")
+		}
+		return &gzipReadCloser{Reader: gz, body: body, lenient: s.lenientGzip}, nil
+	case string(CompressionZstd):
+		var opts []zstd.DOption
+		if dictID := metadata[zstdDictionaryMetadataKey]; dictID != "" {
+			if len(s.compressionDictionary) == 0 {
+				_ = body.Close()
+				return nil, errors.Errorf("object was compressed with dictionary '%s' but bucket has no compression dictionary", dictID)
+			}
+			if dictID != s.compressionDictionaryID {
+				_ = body.Close()
+				return nil, errors.Errorf("object was compressed with dictionary '%s' but bucket has dictionary '%s'", dictID, s.compressionDictionaryID)
+			}
+			opts = append(opts, zstd.WithDecoderDicts(s.compressionDictionary))
+		}
+		dec, err := zstd.NewReader(body, opts...)
+		if err != nil {
+			_ = body.Close()
+			return nil, errors.Wrap(err, "creating zstd decoder")
+		}
+		return &zstdReadCloser{Decoder: dec, body: body}, nil
+	case "":
+		if sniff {
+			return s.newSniffingReader(body)
+		}
+		return body, nil
+	default:
+		return body, nil
+	}
+}
+
+// newSniffingReader decompresses the body if it starts with the gzip magic
+// number, and otherwise returns it unchanged.
+func (s *s3Bucket) newSniffingReader(body io.ReadCloser) (io.ReadCloser, error) {
+	br := bufio.NewReader(body)
+	header, err := br.Peek(len(gzipMagic))
+	if err != nil && err != io.EOF {
+		_ = body.Close()
+		return nil, errors.Wrap(err, "reading object header")
+	}
+	buffered := &bufferedReadCloser{Reader: br, body: body}
+	if !bytes.Equal(header, gzipMagic) {
+		return buffered, nil
+	}
+
+	gz, err := gzip.NewReader(br)
+	if err != nil {
+		_ = body.Close()
+		return nil, errors.Wrap(err, "creating gzip reader")
+	}
+	return &gzipReadCloser{Reader: gz, body: body, lenient: s.lenientGzip}, nil
+}
+
+// bufferedReadCloser reads from a buffered reader of the body, and closes the
+// body.
+type bufferedReadCloser struct {
+	*bufio.Reader
+	body io.ReadCloser
+}
+
+func (r *bufferedReadCloser) Close() error { return r.body.Close() }
+
+func (s *s3Bucket) GetToWriterCompressed(ctx context.Context, key string, w io.Writer) (string, error) {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"operation":     "get to writer compressed",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+		"key":           key,
+	})
+
+	result, err := s.svc.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.name),
+		Key:    aws.String(s.normalizeKey(key)),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) {
+			if apiErr.ErrorCode() == "NoSuchKey" {
+				return "", MakeKeyNotFoundError(err)
+			}
+		}
+		return "", err
+	}
+
+	encoding := aws.ToString(result.ContentEncoding)
+	var body io.ReadCloser = result.Body
+	switch {
+	case encoding == string(CompressionZstd) && result.Metadata[zstdDictionaryMetadataKey] != "":
+		if body, err = s.newDecompressingReader(encoding, result.Metadata, false, body); err != nil {
+			return "", err
+		}
+		encoding = ""
+	case encoding == "" && s.shouldSniff(key, aws.ToString(result.ContentType)):
+		br := bufio.NewReader(body)
+		header, err := br.Peek(len(gzipMagic))
+		if err != nil && err != io.EOF {
+			_ = body.Close()
+			return "", errors.Wrap(err, "reading object header")
+		}
+		if bytes.Equal(header, gzipMagic) {
+			encoding = string(CompressionGzip)
+		}
+		body = &bufferedReadCloser{Reader: br, body: body}
+	}
+	defer body.Close()
+
+	if hw, ok := w.(interface{ Header() http.Header }); ok && encoding != "" {
+		hw.Header().Set("Content-Encoding", encoding)
+	}
+	if _, err = copyWithBuffer(w, body, s.copyBufferSize); err != nil {
+		return "", errors.Wrapf(err, "copying key '%s'", key)
+	}
+
+	return encoding, nil
+}
+
+func (s *s3Bucket) RepairCompressionMetadata(ctx context.Context, prefix string, opts RepairCompressionOptions) error {
+	dryRun := s.dryRun || opts.DryRun
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"dry_run":       dryRun,
+		"operation":     "repair compression metadata",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+		"prefix":        prefix,
+		"workers":       opts.Workers,
+	})
+
+	if opts.Workers < 0 {
+		return errors.New("number of workers cannot be negative")
+	}
+	return s.forEachObject(ctx, prefix, opts.Workers, func(ctx context.Context, obj s3Types.Object) error {
+		key := aws.ToString(obj.Key)
+		return errors.Wrapf(s.repairCompressionMetadata(ctx, key, dryRun), "repairing key '%s'", s.denormalizeKey(key))
+	})
+}
+
+// repairCompressionMetadata sets the content encoding of the object with the
+// given normalized key to gzip if it has no content encoding but its data is
+// gzip-compressed.
+func (s *s3Bucket) repairCompressionMetadata(ctx context.Context, key string, dryRun bool) error {
+	head, err := s.headObjectForCopy(ctx, key)
+	if err != nil {
+		return err
+	}
+	if aws.ToString(head.ContentEncoding) != "" || aws.ToInt64(head.ContentLength) < int64(len(gzipMagic)) {
+		return nil
+	}
+	if isGzipFile(key, aws.ToString(head.ContentType)) {
+		return nil
+	}
+	result, err := s.svc.GetObject(ctx, &s3.GetObjectInput{
+		Bucket:  aws.String(s.name),
+		Key:     aws.String(key),
+		Range:   aws.String("bytes=0-1"),
+		IfMatch: head.ETag,
+	})
+	if err != nil {
+		return errors.Wrap(err, "getting object header")
+	}
+	header := make([]byte, len(gzipMagic))
+	_, err = io.ReadFull(result.Body, header)
+	_ = result.Body.Close()
+	if err != nil {
+		return errors.Wrap(err, "reading object header")
+	}
+	if !bytes.Equal(header, gzipMagic) {
+		return nil
+	}
+
+	grip.Info(message.Fields{
+		"message": "setting missing gzip content encoding",
+		"dry_run": dryRun,
+		"bucket":  s.name,
+		"key":     key,
+	})
+	if dryRun {
+		return nil
+	}
+
+	input := s.copyInPlaceInput(key, head)
+	input.ContentEncoding = aws.String(string(CompressionGzip))
+	s.encryption.orObject(head).applyToCopy(input)
+	return errors.Wrap(s.copyInPlace(ctx, input, aws.ToInt64(head.ContentLength)), "setting content encoding")
+}
+
+// isCompressedEncoding returns whether the content encoding is one that the
+// bucket decompresses on read.
+func isCompressedEncoding(contentEncoding string) bool {
+	return contentEncoding == string(CompressionGzip) || contentEncoding == string(CompressionZstd)
+}
+
+// gzipReadCloser closes both the gzip reader and the underlying reader.
+// Closing it before the end of the stream, where the gzip footer is validated,
+// is an error unless it is lenient. If lenient, a gzip stream that ends early
+// is also treated as complete rather than as an error.
+type gzipReadCloser struct {
+	*gzip.Reader
+	body    io.ReadCloser
+	lenient bool
+	// done is set once a read returns io.EOF or an error.
+	done bool
+}
+
+func (r *gzipReadCloser) Read(p []byte) (int, error) {
+	n, err := r.Reader.Read(p)
+	if r.lenient && err == io.ErrUnexpectedEOF {
+		err = io.EOF
+	}
+	if err != nil {
+		r.done = true
+	}
+	return n, err
+}
+
+func (r *gzipReadCloser) Close() error {
+	catcher := grip.NewBasicCatcher()
+	if err := r.Reader.Close(); !r.lenient || err != io.ErrUnexpectedEOF {
+		catcher.Add(err)
+	}
+	if !r.done && !r.lenient {
+		catcher.Wrap(io.ErrUnexpectedEOF, "closing gzip stream before its checksum was validated")
+	}
+	catcher.Add(r.body.Close())
+	return catcher.Resolve()
+}
+
+// zstdReadCloser closes both the zstd decoder and the underlying reader.
+type zstdReadCloser struct {
+	*zstd.Decoder
+	body io.ReadCloser
+}
+
+func (r *zstdReadCloser) Close() error {
+	r.Decoder.Close()
+	return r.body.Close()
+}
@@ -0,0 +1,73 @@
+package pail
+
+import (
+	"io"
+	"net/http"
+)
+
+// contentTypeSniffLen is the number of bytes at the beginning of an object
+// that are used to detect its content type.
+const contentTypeSniffLen = 512
+
+// contentTypeDetector detects the content type of an object from the
+// beginning of its uncompressed data. A nil detector detects nothing.
+type contentTypeDetector struct {
+	head []byte
+}
+
+// newContentTypeDetector returns a detector if the bucket detects the content
+// type of uploaded objects, or nil otherwise.
+func (s *s3Bucket) newContentTypeDetector() *contentTypeDetector {
+	if !s.detectContentType {
+		return nil
+	}
+	return &contentTypeDetector{}
+}
+
+// observe records the beginning of the object's data, of which p is the
+// next chunk.
+func (d *contentTypeDetector) observe(p []byte) {
+	if d == nil || len(d.head) >= contentTypeSniffLen {
+		return
+	}
+	if n := contentTypeSniffLen - len(d.head); len(p) > n {
+		p = p[:n]
+	}
+	d.head = append(d.head, p...)
+}
+
+// contentType returns the detected content type of the object. The fallback
+// content type is returned if the detector is nil or the data is not of a
+// recognized type.
+func (d *contentTypeDetector) contentType(fallback string) string {
+	if d == nil {
+		return fallback
+	}
+	detected := http.DetectContentType(d.head)
+	if detected == "application/octet-stream" && fallback != "" {
+		return fallback
+	}
+	return detected
+}
+
+// detectingWriteCloser passes the data written to it to the detector before
+// writing it to the underlying writer, so that the underlying writer can set
+// the detected content type when it uploads the object.
+type detectingWriteCloser struct {
+	io.WriteCloser
+	detector *contentTypeDetector
+}
+
+func (w *detectingWriteCloser) Write(p []byte) (int, error) {
+	w.detector.observe(p)
+	return w.WriteCloser.Write(p)
+}
+
+// withContentTypeDetection wraps the writer so that its data is passed to
+// the detector, if any.
+func withContentTypeDetection(w io.WriteCloser, detector *contentTypeDetector) io.WriteCloser {
+	if detector == nil {
+		return w
+	}
+	return &detectingWriteCloser{WriteCloser: w, detector: detector}
+}
@@ -0,0 +1,188 @@
+package pail
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
+	"github.com/pkg/errors"
+)
+
+// copyInPlaceInput returns the input to copy the object with the given
+// normalized key and metadata onto itself. S3 only allows copying an object
+// onto itself if its metadata is replaced, so the input replaces it with the
+// object's current metadata and headers, including its encryption, storage
+// class and Object Lock settings; callers then change the fields that the copy
+// is meant to change. The copy only succeeds if the object is unchanged since
+// its metadata was read. Tags are kept, since copies keep the tags of their
+// source.
+func (s *s3Bucket) copyInPlaceInput(key string, head *s3.HeadObjectOutput) *s3.CopyObjectInput {
+	return &s3.CopyObjectInput{
+		Bucket:                    aws.String(s.name),
+		CopySource:                aws.String(escapeCopySource(copySource(s.name, key))),
+		Key:                       aws.String(key),
+		ACL:                       s3Types.ObjectCannedACL(string(s.permissions)),
+		MetadataDirective:         s3Types.MetadataDirectiveReplace,
+		Metadata:                  head.Metadata,
+		CacheControl:              head.CacheControl,
+		ContentDisposition:        head.ContentDisposition,
+		ContentEncoding:           head.ContentEncoding,
+		ContentLanguage:           head.ContentLanguage,
+		ContentType:               head.ContentType,
+		Expires:                   head.Expires,
+		WebsiteRedirectLocation:   head.WebsiteRedirectLocation,
+		StorageClass:              head.StorageClass,
+		ServerSideEncryption:      head.ServerSideEncryption,
+		SSEKMSKeyId:               head.SSEKMSKeyId,
+		BucketKeyEnabled:          head.BucketKeyEnabled,
+		ObjectLockMode:            head.ObjectLockMode,
+		ObjectLockRetainUntilDate: head.ObjectLockRetainUntilDate,
+		ObjectLockLegalHoldStatus: head.ObjectLockLegalHoldStatus,
+		CopySourceIfMatch:         head.ETag,
+	}
+}
+
+// maxCopySize returns the size of the largest object that the bucket copies
+// in a single request.
+func (s *s3Bucket) maxCopySize() int64 {
+	if s.maxCopyBytes > 0 {
+		return s.maxCopyBytes
+	}
+	return maxCopyObjectSize
+}
+
+// copyInPlace copies an object of the given size onto itself with the given
+// input, as returned by copyInPlaceInput. The copy happens within S3, so the
+// object's contents are not transferred. S3 cannot copy objects larger than
+// 5 GiB in a single request, so larger objects are copied in parts. If the
+// bucket does not support ACLs, the object is copied without one.
+func (s *s3Bucket) copyInPlace(ctx context.Context, input *s3.CopyObjectInput, size int64) error {
+	err := s.copyInPlaceWithACL(ctx, input, size)
+	if input.ACL != "" && isACLNotSupportedError(err) {
+		grip.Debug(message.Fields{
+			"message": "bucket does not support ACLs, copying without ACL",
+			"bucket":  s.name,
+			"key":     aws.ToString(input.Key),
+			"acl":     input.ACL,
+		})
+		withoutACL := *input
+		withoutACL.ACL = ""
+		err = s.copyInPlaceWithACL(ctx, &withoutACL, size)
+	}
+	return err
+}
+
+func (s *s3Bucket) copyInPlaceWithACL(ctx context.Context, input *s3.CopyObjectInput, size int64) error {
+	if size <= s.maxCopySize() {
+		_, err := s.svc.CopyObject(ctx, input)
+		return err
+	}
+
+	return errors.Wrap(s.copyInPlaceMultipart(ctx, input, size), "copying object in parts")
+}
+
+// copyInPlaceMultipart copies an object onto itself in parts of the largest
+// size that S3 can copy in a single request. Unlike CopyObject, multipart
+// uploads do not keep the tags of their source, so they are set explicitly.
+func (s *s3Bucket) copyInPlaceMultipart(ctx context.Context, input *s3.CopyObjectInput, size int64) error {
+	tagging := input.Tagging
+	if input.TaggingDirective != s3Types.TaggingDirectiveReplace {
+		tags, err := s.svc.GetObjectTagging(ctx, &s3.GetObjectTaggingInput{
+			Bucket: input.Bucket,
+			Key:    input.Key,
+		})
+		if err != nil {
+			return errors.Wrap(err, "getting object tags")
+		}
+		values := url.Values{}
+		for _, tag := range tags.TagSet {
+			values.Set(aws.ToString(tag.Key), aws.ToString(tag.Value))
+		}
+		if len(values) > 0 {
+			tagging = aws.String(values.Encode())
+		}
+	}
+
+	upload, err := s.svc.CreateMultipartUpload(ctx, &s3.CreateMultipartUploadInput{
+		Bucket:                    input.Bucket,
+		Key:                       input.Key,
+		ACL:                       input.ACL,
+		Metadata:                  input.Metadata,
+		CacheControl:              input.CacheControl,
+		ContentDisposition:        input.ContentDisposition,
+		ContentEncoding:           input.ContentEncoding,
+		ContentLanguage:           input.ContentLanguage,
+		ContentType:               input.ContentType,
+		Expires:                   input.Expires,
+		WebsiteRedirectLocation:   input.WebsiteRedirectLocation,
+		StorageClass:              input.StorageClass,
+		ServerSideEncryption:      input.ServerSideEncryption,
+		SSEKMSKeyId:               input.SSEKMSKeyId,
+		BucketKeyEnabled:          input.BucketKeyEnabled,
+		ObjectLockMode:            input.ObjectLockMode,
+		ObjectLockRetainUntilDate: input.ObjectLockRetainUntilDate,
+		ObjectLockLegalHoldStatus: input.ObjectLockLegalHoldStatus,
+		Tagging:                   tagging,
+	})
+	if err != nil {
+		return errors.Wrap(err, "creating multipart upload")
+	}
+
+	parts, err := s.copyParts(ctx, input, upload.UploadId, size)
+	if err == nil {
+		_, err = s.svc.CompleteMultipartUpload(ctx, &s3.CompleteMultipartUploadInput{
+			Bucket:          input.Bucket,
+			Key:             input.Key,
+			UploadId:        upload.UploadId,
+			MultipartUpload: &s3Types.CompletedMultipartUpload{Parts: parts},
+		})
+		err = errors.Wrap(err, "completing multipart upload")
+	}
+	if err != nil {
+		_, abortErr := s.svc.AbortMultipartUpload(ctx, &s3.AbortMultipartUploadInput{
+			Bucket:   input.Bucket,
+			Key:      input.Key,
+			UploadId: upload.UploadId,
+		})
+		if abortErr != nil {
+			return errors.Wrapf(err, "aborting multipart upload: %s", abortErr)
+		}
+		return err
+	}
+
+	return nil
+}
+
+// copyParts copies the source of the input into the multipart upload with the
+// given ID in parts.
+func (s *s3Bucket) copyParts(ctx context.Context, input *s3.CopyObjectInput, uploadID *string, size int64) ([]s3Types.CompletedPart, error) {
+	partSize := s.maxCopySize()
+	var parts []s3Types.CompletedPart
+	for start := int64(0); start < size; start += partSize {
+		end := start + partSize - 1
+		if end >= size {
+			end = size - 1
+		}
+		partNumber := aws.Int32(int32(len(parts) + 1))
+		result, err := s.svc.UploadPartCopy(ctx, &s3.UploadPartCopyInput{
+			Bucket:            input.Bucket,
+			Key:               input.Key,
+			UploadId:          uploadID,
+			PartNumber:        partNumber,
+			CopySource:        input.CopySource,
+			CopySourceRange:   aws.String(fmt.Sprintf("bytes=%d-%d", start, end)),
+			CopySourceIfMatch: input.CopySourceIfMatch,
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "copying part %d", aws.ToInt32(partNumber))
+		}
+		parts = append(parts, s3Types.CompletedPart{ETag: result.CopyPartResult.ETag, PartNumber: partNumber})
+	}
+
+	return parts, nil
+}
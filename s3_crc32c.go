@@ -0,0 +1,94 @@
+package pail
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"hash"
+	"hash/crc32"
+	"io"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go/middleware"
+	"github.com/pkg/errors"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// getObjectCRC32C returns the CRC32C checksum of the data as a whole that S3
+// returned for an object read with the checksum mode enabled. The checksum is
+// empty if the object has none, such as objects uploaded without one, and for
+// objects uploaded in parts, whose checksum is of their parts' checksums.
+func getObjectCRC32C(result *s3.GetObjectOutput) string {
+	checksum := aws.ToString(result.ChecksumCRC32C)
+	if strings.Contains(checksum, "-") {
+		return ""
+	}
+	return checksum
+}
+
+// withoutResponseChecksumValidation removes the SDK's validation of the
+// checksums returned with the object, so that the data is only verified by a
+// crc32cVerifyingReader and mismatches are reported as ErrChecksumMismatch.
+func withoutResponseChecksumValidation(o *s3.Options) {
+	o.APIOptions = append(o.APIOptions, func(stack *middleware.Stack) error {
+		const id = "AWSChecksum:ValidateOutputPayloadChecksum"
+		if _, ok := stack.Deserialize.Get(id); !ok {
+			return nil
+		}
+		_, err := stack.Deserialize.Remove(id)
+		return err
+	})
+}
+
+// crc32cVerifyingReader computes the CRC32C checksum of the data read from an
+// object and, once the data is read in full, returns ErrChecksumMismatch
+// instead of io.EOF if it is not the object's stored checksum. Data is not
+// verified if the reader is closed before it is read in full.
+type crc32cVerifyingReader struct {
+	io.ReadCloser
+	key      string
+	expected string
+	hash     hash.Hash32
+	verified bool
+	// err is the result of the verification, which is returned by every
+	// read once the data is read in full.
+	err error
+}
+
+func newCRC32CVerifyingReader(body io.ReadCloser, key, expected string) *crc32cVerifyingReader {
+	return &crc32cVerifyingReader{
+		ReadCloser: body,
+		key:        key,
+		expected:   expected,
+		hash:       crc32.New(crc32cTable),
+	}
+}
+
+func (r *crc32cVerifyingReader) Read(p []byte) (int, error) {
+	if r.verified {
+		return 0, r.err
+	}
+
+	n, err := r.ReadCloser.Read(p)
+	_, _ = r.hash.Write(p[:n])
+	if err != io.EOF {
+		return n, err
+	}
+
+	r.verified = true
+	r.err = io.EOF
+	if actual := encodeCRC32C(r.hash.Sum32()); actual != r.expected {
+		r.err = errors.Wrapf(ErrChecksumMismatch, "key '%s' has CRC32C '%s' but read data has '%s'", r.key, r.expected, actual)
+	}
+	return n, r.err
+}
+
+// encodeCRC32C returns the CRC32C checksum base64-encoded in big-endian byte
+// order, as S3 encodes checksums.
+func encodeCRC32C(sum uint32) string {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], sum)
+	return base64.StdEncoding.EncodeToString(b[:])
+}
@@ -0,0 +1,306 @@
+package pail
+
+import (
+	"context"
+	"io"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+	"github.com/pkg/errors"
+)
+
+// credentialExpiryWindow is how close to expiring temporary credentials must
+// have been when a request was made for S3 rejecting it as AccessDenied to be
+// treated as the credentials expiring.
+const credentialExpiryWindow = time.Minute
+
+// credentialRefreshClient wraps an S3 client so that an operation that fails
+// because its temporary credentials expired, such as an assumed role's
+// session expiring between when the credentials were retrieved and when the
+// request was received, is retried once with refreshed credentials.
+type credentialRefreshClient struct {
+	s3Client
+	creds *aws.CredentialsCache
+}
+
+// do runs the operation, retrying it once after refreshing the credentials if
+// it fails due to expired credentials. The operation is not retried if reset
+// is non-nil and fails, for example because its request body cannot be read
+// again.
+func (c *credentialRefreshClient) do(ctx context.Context, reset func() error, op func() error) error {
+	// The credentials are retrieved before the operation, since the cache
+	// replaces them once they expire.
+	creds, credsErr := c.creds.Retrieve(ctx)
+	err := op()
+	if !isExpiredCredentialsError(err, creds, credsErr == nil) {
+		return err
+	}
+	if reset != nil && reset() != nil {
+		return err
+	}
+
+	c.creds.Invalidate()
+	return op()
+}
+
+// isExpiredCredentialsError returns whether the error indicates that the
+// request's credentials expired. S3 reports requests signed with recently
+// expired session credentials as either expired or, in some cases, as
+// AccessDenied, so AccessDenied is only treated as an expiry if the
+// credentials used were temporary and within credentialExpiryWindow of
+// expiring.
+func isExpiredCredentialsError(err error, creds aws.Credentials, haveCreds bool) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+
+	switch apiErr.ErrorCode() {
+	case "ExpiredToken", "ExpiredTokenException", "TokenRefreshRequired":
+		return true
+	case "AccessDenied":
+		return haveCreds && creds.CanExpire && time.Until(creds.Expires) < credentialExpiryWindow
+	default:
+		return false
+	}
+}
+
+// rewinder returns a function that seeks the body back to its current
+// offset, or nil if the body is empty. The returned function fails if the
+// body cannot seek.
+func rewinder(body io.Reader) func() error {
+	if body == nil {
+		return nil
+	}
+	seeker, ok := body.(io.Seeker)
+	if !ok {
+		return func() error { return errors.New("request body cannot be rewound") }
+	}
+	offset, err := seeker.Seek(0, io.SeekCurrent)
+	if err != nil {
+		return func() error { return errors.Wrap(err, "getting request body offset") }
+	}
+
+	return func() error {
+		_, err := seeker.Seek(offset, io.SeekStart)
+		return errors.Wrap(err, "rewinding request body")
+	}
+}
+
+func (c *credentialRefreshClient) HeadBucket(ctx context.Context, input *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	var out *s3.HeadBucketOutput
+	err := c.do(ctx, nil, func() (err error) {
+		out, err = c.s3Client.HeadBucket(ctx, input, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *credentialRefreshClient) GetBucketLocation(ctx context.Context, input *s3.GetBucketLocationInput, optFns ...func(*s3.Options)) (*s3.GetBucketLocationOutput, error) {
+	var out *s3.GetBucketLocationOutput
+	err := c.do(ctx, nil, func() (err error) {
+		out, err = c.s3Client.GetBucketLocation(ctx, input, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *credentialRefreshClient) HeadObject(ctx context.Context, input *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	var out *s3.HeadObjectOutput
+	err := c.do(ctx, nil, func() (err error) {
+		out, err = c.s3Client.HeadObject(ctx, input, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *credentialRefreshClient) GetObject(ctx context.Context, input *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	var out *s3.GetObjectOutput
+	err := c.do(ctx, nil, func() (err error) {
+		out, err = c.s3Client.GetObject(ctx, input, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *credentialRefreshClient) GetObjectAcl(ctx context.Context, input *s3.GetObjectAclInput, optFns ...func(*s3.Options)) (*s3.GetObjectAclOutput, error) {
+	var out *s3.GetObjectAclOutput
+	err := c.do(ctx, nil, func() (err error) {
+		out, err = c.s3Client.GetObjectAcl(ctx, input, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *credentialRefreshClient) GetObjectAttributes(ctx context.Context, input *s3.GetObjectAttributesInput, optFns ...func(*s3.Options)) (*s3.GetObjectAttributesOutput, error) {
+	var out *s3.GetObjectAttributesOutput
+	err := c.do(ctx, nil, func() (err error) {
+		out, err = c.s3Client.GetObjectAttributes(ctx, input, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *credentialRefreshClient) PutObject(ctx context.Context, input *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	var out *s3.PutObjectOutput
+	err := c.do(ctx, rewinder(input.Body), func() (err error) {
+		out, err = c.s3Client.PutObject(ctx, input, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *credentialRefreshClient) CopyObject(ctx context.Context, input *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	var out *s3.CopyObjectOutput
+	err := c.do(ctx, nil, func() (err error) {
+		out, err = c.s3Client.CopyObject(ctx, input, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *credentialRefreshClient) GetObjectTagging(ctx context.Context, input *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+	var out *s3.GetObjectTaggingOutput
+	err := c.do(ctx, nil, func() (err error) {
+		out, err = c.s3Client.GetObjectTagging(ctx, input, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *credentialRefreshClient) DeleteObject(ctx context.Context, input *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	var out *s3.DeleteObjectOutput
+	err := c.do(ctx, nil, func() (err error) {
+		out, err = c.s3Client.DeleteObject(ctx, input, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *credentialRefreshClient) DeleteObjects(ctx context.Context, input *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	var out *s3.DeleteObjectsOutput
+	err := c.do(ctx, nil, func() (err error) {
+		out, err = c.s3Client.DeleteObjects(ctx, input, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *credentialRefreshClient) ListObjects(ctx context.Context, input *s3.ListObjectsInput, optFns ...func(*s3.Options)) (*s3.ListObjectsOutput, error) {
+	var out *s3.ListObjectsOutput
+	err := c.do(ctx, nil, func() (err error) {
+		out, err = c.s3Client.ListObjects(ctx, input, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *credentialRefreshClient) GetObjectLegalHold(ctx context.Context, input *s3.GetObjectLegalHoldInput, optFns ...func(*s3.Options)) (*s3.GetObjectLegalHoldOutput, error) {
+	var out *s3.GetObjectLegalHoldOutput
+	err := c.do(ctx, nil, func() (err error) {
+		out, err = c.s3Client.GetObjectLegalHold(ctx, input, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *credentialRefreshClient) GetObjectRetention(ctx context.Context, input *s3.GetObjectRetentionInput, optFns ...func(*s3.Options)) (*s3.GetObjectRetentionOutput, error) {
+	var out *s3.GetObjectRetentionOutput
+	err := c.do(ctx, nil, func() (err error) {
+		out, err = c.s3Client.GetObjectRetention(ctx, input, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *credentialRefreshClient) GetObjectLockConfiguration(ctx context.Context, input *s3.GetObjectLockConfigurationInput, optFns ...func(*s3.Options)) (*s3.GetObjectLockConfigurationOutput, error) {
+	var out *s3.GetObjectLockConfigurationOutput
+	err := c.do(ctx, nil, func() (err error) {
+		out, err = c.s3Client.GetObjectLockConfiguration(ctx, input, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *credentialRefreshClient) GetBucketLifecycleConfiguration(ctx context.Context, input *s3.GetBucketLifecycleConfigurationInput, optFns ...func(*s3.Options)) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+	var out *s3.GetBucketLifecycleConfigurationOutput
+	err := c.do(ctx, nil, func() (err error) {
+		out, err = c.s3Client.GetBucketLifecycleConfiguration(ctx, input, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *credentialRefreshClient) PutBucketLifecycleConfiguration(ctx context.Context, input *s3.PutBucketLifecycleConfigurationInput, optFns ...func(*s3.Options)) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+	var out *s3.PutBucketLifecycleConfigurationOutput
+	err := c.do(ctx, nil, func() (err error) {
+		out, err = c.s3Client.PutBucketLifecycleConfiguration(ctx, input, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *credentialRefreshClient) ListObjectVersions(ctx context.Context, input *s3.ListObjectVersionsInput, optFns ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error) {
+	var out *s3.ListObjectVersionsOutput
+	err := c.do(ctx, nil, func() (err error) {
+		out, err = c.s3Client.ListObjectVersions(ctx, input, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *credentialRefreshClient) ListMultipartUploads(ctx context.Context, input *s3.ListMultipartUploadsInput, optFns ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error) {
+	var out *s3.ListMultipartUploadsOutput
+	err := c.do(ctx, nil, func() (err error) {
+		out, err = c.s3Client.ListMultipartUploads(ctx, input, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *credentialRefreshClient) CreateMultipartUpload(ctx context.Context, input *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	var out *s3.CreateMultipartUploadOutput
+	err := c.do(ctx, nil, func() (err error) {
+		out, err = c.s3Client.CreateMultipartUpload(ctx, input, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *credentialRefreshClient) UploadPart(ctx context.Context, input *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	var out *s3.UploadPartOutput
+	err := c.do(ctx, rewinder(input.Body), func() (err error) {
+		out, err = c.s3Client.UploadPart(ctx, input, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *credentialRefreshClient) UploadPartCopy(ctx context.Context, input *s3.UploadPartCopyInput, optFns ...func(*s3.Options)) (*s3.UploadPartCopyOutput, error) {
+	var out *s3.UploadPartCopyOutput
+	err := c.do(ctx, nil, func() (err error) {
+		out, err = c.s3Client.UploadPartCopy(ctx, input, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *credentialRefreshClient) CompleteMultipartUpload(ctx context.Context, input *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	var out *s3.CompleteMultipartUploadOutput
+	err := c.do(ctx, nil, func() (err error) {
+		out, err = c.s3Client.CompleteMultipartUpload(ctx, input, optFns...)
+		return err
+	})
+	return out, err
+}
+
+func (c *credentialRefreshClient) AbortMultipartUpload(ctx context.Context, input *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	var out *s3.AbortMultipartUploadOutput
+	err := c.do(ctx, nil, func() (err error) {
+		out, err = c.s3Client.AbortMultipartUpload(ctx, input, optFns...)
+		return err
+	})
+	return out, err
+}
@@ -0,0 +1,94 @@
+package pail
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/pkg/errors"
+)
+
+// CredentialSource is the source of the AWS credentials used by an S3
+// bucket.
+type CredentialSource string
+
+const (
+	// CredentialSourceChain uses the first credentials available from,
+	// in order:
+	//   1. S3Options.Credentials.
+	//   2. The role given by S3Options.AssumeRoleARN, assumed with the
+	//      credentials from the rest of the chain.
+	//   3. The profile given by S3Options.SharedCredentialsProfile in the
+	//      shared credentials file.
+	//   4. The AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY, and
+	//      AWS_SESSION_TOKEN environment variables.
+	//   5. The default profile in the shared credentials file, which is
+	//      S3Options.SharedCredentialsFilepath if set.
+	//   6. The remaining sources of the AWS SDK's default credentials
+	//      chain, such as the EC2 instance role.
+	// This is the default.
+	CredentialSourceChain CredentialSource = "chain"
+	// CredentialSourceExplicit only uses S3Options.Credentials.
+	CredentialSourceExplicit CredentialSource = "explicit"
+	// CredentialSourceEnv only uses the AWS_ACCESS_KEY_ID,
+	// AWS_SECRET_ACCESS_KEY, and AWS_SESSION_TOKEN environment variables.
+	CredentialSourceEnv CredentialSource = "env"
+	// CredentialSourceSharedFile only uses the shared credentials file,
+	// which is S3Options.SharedCredentialsFilepath if set, with the
+	// profile S3Options.SharedCredentialsProfile, or the default profile
+	// if not set.
+	CredentialSourceSharedFile CredentialSource = "shared-file"
+)
+
+// Validate checks that the credential source is supported.
+func (s CredentialSource) Validate() error {
+	switch s {
+	case "", CredentialSourceChain, CredentialSourceExplicit, CredentialSourceEnv, CredentialSourceSharedFile:
+		return nil
+	default:
+		return errors.Errorf("unsupported credential source '%s'", s)
+	}
+}
+
+// pinnedCredentials returns the credentials from the source given by the
+// options, or nil if the options use the credentials chain.
+func pinnedCredentials(ctx context.Context, options S3Options) (aws.CredentialsProvider, error) {
+	switch options.CredentialSource {
+	case "", CredentialSourceChain:
+		return nil, nil
+	case CredentialSourceExplicit:
+		if options.Credentials == nil {
+			return nil, errors.New("explicit credential source requires credentials")
+		}
+		return options.Credentials, nil
+	case CredentialSourceEnv:
+		env, err := config.NewEnvConfig()
+		if err != nil {
+			return nil, errors.Wrap(err, "reading credentials from environment")
+		}
+		if !env.Credentials.HasKeys() {
+			return nil, errors.New("environment does not have credentials")
+		}
+		return credentials.StaticCredentialsProvider{Value: env.Credentials}, nil
+	case CredentialSourceSharedFile:
+		profile := options.SharedCredentialsProfile
+		if profile == "" {
+			profile = config.DefaultSharedConfigProfile
+		}
+		shared, err := config.LoadSharedConfigProfile(ctx, profile, func(opts *config.LoadSharedConfigOptions) {
+			if options.SharedCredentialsFilepath != "" {
+				opts.CredentialsFiles = []string{options.SharedCredentialsFilepath}
+			}
+		})
+		if err != nil {
+			return nil, errors.Wrapf(err, "reading profile '%s' from shared credentials file", profile)
+		}
+		if !shared.Credentials.HasKeys() {
+			return nil, errors.Errorf("profile '%s' in shared credentials file does not have credentials", profile)
+		}
+		return credentials.StaticCredentialsProvider{Value: shared.Credentials}, nil
+	default:
+		return nil, errors.Errorf("unsupported credential source '%s'", options.CredentialSource)
+	}
+}
@@ -0,0 +1,241 @@
+package pail
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
+	"github.com/pkg/errors"
+)
+
+// ReEncryptBucket is implemented by buckets whose objects can be re-encrypted
+// under a different KMS key.
+type ReEncryptBucket interface {
+	// ReEncrypt re-encrypts every object with the given prefix that is not
+	// already encrypted with the given KMS key under it, using the given
+	// number of concurrent workers. The key may be given by its ID, its
+	// ARN, or an alias; an alias is resolved to the key it names once the
+	// first object is re-encrypted. Objects that fail to re-encrypt do not
+	// stop the others, and all of the failures are returned together.
+	ReEncrypt(ctx context.Context, prefix, newKMSKeyID string, workers int) error
+}
+
+// serverSideEncryption is the server-side encryption that S3 applies to the
+// objects written to a bucket. The zero value leaves objects to the bucket's
+// default encryption.
+type serverSideEncryption struct {
+	algorithm s3Types.ServerSideEncryption
+	kmsKeyID  string
+}
+
+// newServerSideEncryption validates the server-side encryption options.
+func newServerSideEncryption(algorithm, kmsKeyID string) (serverSideEncryption, error) {
+	sse := serverSideEncryption{algorithm: s3Types.ServerSideEncryption(algorithm), kmsKeyID: kmsKeyID}
+	if algorithm == "" {
+		if kmsKeyID != "" {
+			return serverSideEncryption{}, errors.New("KMS key ID requires KMS server-side encryption")
+		}
+		return sse, nil
+	}
+
+	var valid bool
+	for _, value := range sse.algorithm.Values() {
+		if sse.algorithm == value {
+			valid = true
+			break
+		}
+	}
+	if !valid {
+		return serverSideEncryption{}, errors.Errorf("unsupported server-side encryption '%s'", algorithm)
+	}
+	if kmsKeyID != "" && !sse.usesKMS() {
+		return serverSideEncryption{}, errors.Errorf("KMS key ID cannot be used with server-side encryption '%s'", algorithm)
+	}
+	return sse, nil
+}
+
+// usesKMS returns whether objects are encrypted with KMS keys, in which case
+// their ETags are not MD5 checksums of their data.
+func (sse serverSideEncryption) usesKMS() bool {
+	return sse.algorithm == s3Types.ServerSideEncryptionAwsKms || sse.algorithm == s3Types.ServerSideEncryptionAwsKmsDsse
+}
+
+// orObject returns the encryption, or, if it is not set, the encryption of
+// the object with the given metadata, so that copying the object onto itself
+// keeps its encryption rather than applying the bucket's default.
+func (sse serverSideEncryption) orObject(head *s3.HeadObjectOutput) serverSideEncryption {
+	if sse.algorithm != "" || head == nil {
+		return sse
+	}
+	return serverSideEncryption{algorithm: head.ServerSideEncryption, kmsKeyID: aws.ToString(head.SSEKMSKeyId)}
+}
+
+func (sse serverSideEncryption) keyID() *string {
+	if sse.kmsKeyID == "" {
+		return nil
+	}
+	return aws.String(sse.kmsKeyID)
+}
+
+func (sse serverSideEncryption) applyToPut(input *s3.PutObjectInput) {
+	input.ServerSideEncryption = sse.algorithm
+	input.SSEKMSKeyId = sse.keyID()
+}
+
+// applyToCreateMultipartUpload sets the encryption of a multipart upload.
+// Unlike customer-provided keys, KMS keys are not sent with each part.
+func (sse serverSideEncryption) applyToCreateMultipartUpload(input *s3.CreateMultipartUploadInput) {
+	input.ServerSideEncryption = sse.algorithm
+	input.SSEKMSKeyId = sse.keyID()
+}
+
+// applyToCopy sets the encryption of the destination of a copy, which S3
+// otherwise encrypts with the bucket's default encryption regardless of the
+// encryption of the source.
+func (sse serverSideEncryption) applyToCopy(input *s3.CopyObjectInput) {
+	input.ServerSideEncryption = sse.algorithm
+	input.SSEKMSKeyId = sse.keyID()
+}
+
+func (s *s3Bucket) ReEncrypt(ctx context.Context, prefix, newKMSKeyID string, workers int) error {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"dry_run":       s.dryRun,
+		"operation":     "re-encrypt",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+		"prefix":        prefix,
+		"kms_key_id":    newKMSKeyID,
+		"workers":       workers,
+	})
+
+	if newKMSKeyID == "" {
+		return errors.New("must specify a KMS key ID")
+	}
+	target := &kmsKeyTarget{id: newKMSKeyID}
+	return s.forEachObject(ctx, prefix, workers, func(ctx context.Context, obj s3Types.Object) error {
+		key := aws.ToString(obj.Key)
+		return errors.Wrapf(s.reEncryptObject(ctx, key, target), "re-encrypting key '%s'", s.denormalizeKey(key))
+	})
+}
+
+// reEncryptObject copies the object with the given normalized key onto itself
+// encrypted with the target KMS key, unless it is already encrypted with it.
+func (s *s3Bucket) reEncryptObject(ctx context.Context, key string, target *kmsKeyTarget) error {
+	head, err := s.headObjectForCopy(ctx, key)
+	if err != nil {
+		return err
+	}
+	if target.matches(aws.ToString(head.SSEKMSKeyId)) {
+		return nil
+	}
+	if s.dryRun {
+		return nil
+	}
+
+	sse := serverSideEncryption{algorithm: head.ServerSideEncryption, kmsKeyID: target.id}
+	if !sse.usesKMS() {
+		sse.algorithm = s3Types.ServerSideEncryptionAwsKms
+	}
+	// Copying an object onto itself is allowed when its encryption
+	// changes.
+	input := s.copyInPlaceInput(key, head)
+	sse.applyToCopy(input)
+	if err = s.copyInPlace(ctx, input, aws.ToInt64(head.ContentLength)); err != nil {
+		return errors.WithStack(err)
+	}
+	if !target.needsResolving() {
+		return nil
+	}
+
+	// S3 reports the key that an alias named when the object was encrypted,
+	// so the alias is resolved from the object that was just copied.
+	head, err = s.headObjectForCopy(ctx, key)
+	if err != nil {
+		return errors.Wrap(err, "resolving KMS key alias")
+	}
+	target.resolve(aws.ToString(head.SSEKMSKeyId))
+	return nil
+}
+
+// headObjectForCopy gets the metadata of the object with the given normalized
+// key.
+func (s *s3Bucket) headObjectForCopy(ctx context.Context, key string) (*s3.HeadObjectOutput, error) {
+	release, err := s.acquireMetadataSlot(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer release()
+
+	head, err := s.svc.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.name),
+		Key:    aws.String(key),
+	})
+	return head, errors.Wrap(err, "getting S3 head object")
+}
+
+// kmsKeyTarget is the KMS key that objects are re-encrypted with. S3 reports
+// the ARN of the key that encrypts each object, so keys are compared by their
+// IDs, and an alias is compared by the key that it was found to name.
+type kmsKeyTarget struct {
+	id       string
+	mu       sync.Mutex
+	resolved string
+}
+
+// kmsKeyName returns the ID of the KMS key, or the name of the alias, that
+// the given key ID, ARN or alias refers to.
+func kmsKeyName(id string) string {
+	if strings.HasPrefix(id, "arn:") {
+		id = id[strings.LastIndex(id, ":")+1:]
+	}
+	return strings.TrimPrefix(id, "key/")
+}
+
+func isKMSAlias(id string) bool {
+	return strings.HasPrefix(kmsKeyName(id), "alias/")
+}
+
+// matches returns whether an object encrypted with the given KMS key is
+// already encrypted with the target key.
+func (k *kmsKeyTarget) matches(objectKeyID string) bool {
+	if objectKeyID == "" {
+		return false
+	}
+	id := k.id
+	if isKMSAlias(id) {
+		k.mu.Lock()
+		id = k.resolved
+		k.mu.Unlock()
+		if id == "" {
+			return false
+		}
+	}
+	return kmsKeyName(id) == kmsKeyName(objectKeyID)
+}
+
+// needsResolving returns whether the target key is an alias whose key is not
+// yet known.
+func (k *kmsKeyTarget) needsResolving() bool {
+	if !isKMSAlias(k.id) {
+		return false
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	return k.resolved == ""
+}
+
+// resolve records the key that the target alias names.
+func (k *kmsKeyTarget) resolve(objectKeyID string) {
+	if objectKeyID == "" || isKMSAlias(objectKeyID) {
+		return
+	}
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	k.resolved = objectKeyID
+}
@@ -0,0 +1,90 @@
+package pail
+
+import (
+	"context"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/smithy-go"
+	"github.com/pkg/errors"
+)
+
+// ComputeMultipartETag returns the ETag that S3 assigns to an object uploaded
+// in a multipart upload with the given parts, in order: the hex-encoded MD5
+// digest of the concatenated MD5 digests of the parts, followed by a hyphen
+// and the number of parts. The result is not quoted. Note that S3 does not
+// use this algorithm for objects encrypted with SSE-KMS or SSE-C.
+func ComputeMultipartETag(parts [][]byte) string {
+	digests := make([][md5.Size]byte, 0, len(parts))
+	for _, part := range parts {
+		digests = append(digests, md5.Sum(part))
+	}
+
+	return multipartETag(digests)
+}
+
+// multipartETag returns the multipart ETag of an object whose parts have the
+// given MD5 digests.
+func multipartETag(digests [][md5.Size]byte) string {
+	if len(digests) == 0 {
+		return ""
+	}
+
+	hash := md5.New()
+	for _, digest := range digests {
+		_, _ = hash.Write(digest[:])
+	}
+
+	return fmt.Sprintf("%s-%d", hex.EncodeToString(hash.Sum(nil)), len(digests))
+}
+
+// contentMD5 returns the base64-encoded MD5 digest of the data, as used in the
+// Content-MD5 header.
+func contentMD5(data []byte) string {
+	sum := md5.Sum(data)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// checkUploadETag returns ErrChecksumMismatch if the ETag that S3 returned for
+// an uploaded object is not the ETag computed from the uploaded data.
+func checkUploadETag(etag, expected string) error {
+	etag = strings.Trim(etag, `"`)
+	if etag != expected {
+		return errors.Wrapf(ErrChecksumMismatch, "uploaded object has ETag '%s' but expected '%s'", etag, expected)
+	}
+	return nil
+}
+
+// etagIsMD5 returns whether the ETag of the object with the given key is the
+// MD5 checksum of its data, as downloaded, which it is not for objects
+// encrypted with KMS keys or customer-provided keys, nor for compressed
+// objects, whose ETag is the checksum of the compressed data.
+func (s *s3Bucket) etagIsMD5(ctx context.Context, key string) (bool, error) {
+	head, err := s.headObjectForCopy(ctx, s.normalizeKey(key))
+	if err != nil {
+		return false, err
+	}
+	if head.SSECustomerAlgorithm != nil || isCompressedEncoding(aws.ToString(head.ContentEncoding)) {
+		return false, nil
+	}
+	sse := serverSideEncryption{algorithm: head.ServerSideEncryption}
+	return !sse.usesKMS() && !isMultipartETag(strings.Trim(aws.ToString(head.ETag), `"`)), nil
+}
+
+// objectHash returns the ETag of the object with the given key, as reported by
+// List, from its metadata, or an empty string if it does not exist.
+func (s *s3Bucket) objectHash(ctx context.Context, key string) (string, error) {
+	head, err := s.headObjectForCopy(ctx, s.normalizeKey(key))
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotFound" {
+			return "", nil
+		}
+		return "", err
+	}
+	return strings.Trim(aws.ToString(head.ETag), `"`), nil
+}
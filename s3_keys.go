@@ -0,0 +1,143 @@
+package pail
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+// s3MaxKeyLength is the maximum length, in bytes, of an S3 object key.
+const s3MaxKeyLength = 1024
+
+// prefix returns the bucket prefix composed from Prefix and PrefixSegments.
+func (o *S3Options) prefix() string {
+	if len(o.PrefixSegments) == 0 {
+		return o.Prefix
+	}
+
+	return composePrefix(append([]string{o.Prefix}, o.PrefixSegments...))
+}
+
+// composePrefix joins the given segments with slashes, removing empty
+// segments and any leading, trailing, or repeated slashes.
+func composePrefix(segments []string) string {
+	var parts []string
+	for _, segment := range segments {
+		for _, part := range strings.Split(segment, "/") {
+			if part != "" {
+				parts = append(parts, part)
+			}
+		}
+	}
+
+	return strings.Join(parts, "/")
+}
+
+// KeyTransform transforms the keys of objects as they are stored in a bucket,
+// for example to avoid characters that the bucket's service handles poorly.
+// Callers use the untransformed keys: keys are encoded before they are sent
+// to the service, and keys returned by the service, such as those of listed
+// objects, are decoded.
+//
+// Encode must map the empty string to itself, and must preserve prefixes, so
+// that the encoding of a key starts with the encoding of each of its
+// prefixes; otherwise, listing by prefix does not find the encoded keys.
+// Decode should return keys that it cannot decode, such as keys written
+// without the transform, unchanged.
+type KeyTransform interface {
+	Encode(key string) string
+	Decode(key string) string
+}
+
+// URLKeyTransform is a KeyTransform that percent-encodes every byte of a key
+// other than slashes and the characters that S3 considers safe in object
+// keys: ASCII letters and digits and the characters "!-_.*'()". Keys encoded
+// with it can be used in URLs and HTTP headers without further escaping.
+type URLKeyTransform struct{}
+
+func (URLKeyTransform) Encode(key string) string {
+	var encoded strings.Builder
+	for i := 0; i < len(key); i++ {
+		c := key[i]
+		if c == '/' || isS3SafeKeyByte(c) {
+			encoded.WriteByte(c)
+			continue
+		}
+		fmt.Fprintf(&encoded, "%%%02X", c)
+	}
+
+	return encoded.String()
+}
+
+func (URLKeyTransform) Decode(key string) string {
+	decoded, err := url.PathUnescape(key)
+	if err != nil {
+		return key
+	}
+	return decoded
+}
+
+// isS3SafeKeyByte returns whether the byte is one of the characters that S3
+// considers safe to use in object keys.
+func isS3SafeKeyByte(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	default:
+		return strings.IndexByte("!-_.*'()", c) >= 0
+	}
+}
+
+// escapeCopySource URL-encodes the "bucket/key" source of a copy, which S3
+// decodes before looking up the source object.
+func escapeCopySource(source string) string {
+	segments := strings.Split(source, "/")
+	for i, segment := range segments {
+		segments[i] = url.PathEscape(segment)
+	}
+	return strings.Join(segments, "/")
+}
+
+// keyValidator is implemented by buckets that restrict the keys of the objects
+// written to them, so that invalid keys can be rejected before any data is
+// transferred.
+type keyValidator interface {
+	validateKey(key string) error
+}
+
+// validateKey checks that the given key, once the bucket's prefix is added to
+// it, is a valid key for objects written to the bucket.
+func (s *s3Bucket) validateKey(key string) error {
+	fullKey := s.normalizeKey(key)
+	maxLength := s.maxKeyLength
+	if maxLength == 0 {
+		maxLength = s3MaxKeyLength
+	}
+	if len(fullKey) > maxLength {
+		return errors.Wrapf(ErrInvalidKey, "key '%s' is %d bytes long, which exceeds the maximum of %d bytes", fullKey, len(fullKey), maxLength)
+	}
+	if s.validateKeyUTF8 && !utf8.ValidString(fullKey) {
+		return errors.Wrapf(ErrInvalidKey, "key '%s' is not valid UTF-8", fullKey)
+	}
+
+	return nil
+}
+
+// validateKeys checks that all of the given keys are valid for the bucket, if
+// it restricts its keys.
+func validateKeys(b Bucket, keys []string) error {
+	validator, ok := b.(keyValidator)
+	if !ok {
+		return nil
+	}
+	for _, key := range keys {
+		if err := validator.validateKey(key); err != nil {
+			return errors.WithStack(err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,421 @@
+package pail
+
+import (
+	"context"
+	"net/http"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
+	"github.com/pkg/errors"
+)
+
+// LifecycleBucket is implemented by buckets that have a lifecycle
+// configuration that expires objects.
+type LifecycleBucket interface {
+	// GetLifecycleRules returns the bucket's lifecycle rules, or nil if
+	// the bucket does not have a lifecycle configuration.
+	GetLifecycleRules(context.Context) ([]LifecycleRule, error)
+
+	// PredictExpiry returns the time at which the object with the given
+	// key will be expired by the bucket's lifecycle configuration, or nil
+	// if no lifecycle rule expires it. If more than one rule expires the
+	// object, the earliest expiry is returned. Rules that are filtered by
+	// object tags or sizes are not considered.
+	PredictExpiry(context.Context, string) (*time.Time, error)
+
+	// PredictTransitions returns the storage class transitions that the
+	// bucket's lifecycle configuration will apply to the object with the
+	// given key, in the order in which they occur, combining the
+	// transitions of every rule that applies to it. Rules that are
+	// filtered by object tags or sizes are not considered.
+	PredictTransitions(context.Context, string) ([]PredictedTransition, error)
+}
+
+// LifecycleRule describes a rule in a bucket's lifecycle configuration.
+type LifecycleRule struct {
+	ID string
+	// Prefix is the key prefix of the objects to which the rule applies.
+	// An empty prefix applies the rule to every object in the bucket.
+	Prefix string
+	// FilteredByTagsOrSize is true if the rule only applies to objects
+	// with certain tags or sizes, in addition to its prefix. Rules that
+	// are filtered this way cannot be matched by key alone, so they are
+	// never matched by FindMatchingRules.
+	FilteredByTagsOrSize bool
+	Enabled              bool
+	// ExpirationDays is the number of days after an object is created
+	// that it is expired, or 0 if the rule does not expire objects after a
+	// number of days.
+	ExpirationDays int32
+	// ExpirationDate is the date on which objects are expired, or nil if
+	// the rule does not expire objects on a date.
+	ExpirationDate *time.Time
+	// Transitions are the storage class transitions of the rule.
+	Transitions []LifecycleTransition
+}
+
+// LifecycleTransition describes the transition of objects to a different
+// storage class by a lifecycle rule.
+type LifecycleTransition struct {
+	// Days is the number of days after an object is created that it is
+	// transitioned, or 0 if the transition happens on a date.
+	Days int32
+	// Date is the date on which objects are transitioned, or nil if they
+	// are transitioned after a number of days.
+	Date         *time.Time
+	StorageClass string
+}
+
+// PredictedTransition describes when an object will be transitioned to a
+// different storage class by a lifecycle rule.
+type PredictedTransition struct {
+	StorageClass string
+	Date         time.Time
+}
+
+// convertLifecycleRules converts S3 lifecycle rules to lifecycle rules. Only
+// the prefix of each rule's filter is preserved, along with whether the
+// filter also depends on object tags or sizes.
+func convertLifecycleRules(rules []s3Types.LifecycleRule) []LifecycleRule {
+	var out []LifecycleRule
+	for _, rule := range rules {
+		prefix, filtered := lifecycleRuleFilter(rule)
+		converted := LifecycleRule{
+			ID:                   aws.ToString(rule.ID),
+			Prefix:               prefix,
+			FilteredByTagsOrSize: filtered,
+			Enabled:              rule.Status == s3Types.ExpirationStatusEnabled,
+		}
+		if rule.Expiration != nil {
+			converted.ExpirationDays = aws.ToInt32(rule.Expiration.Days)
+			converted.ExpirationDate = rule.Expiration.Date
+		}
+		for _, transition := range rule.Transitions {
+			converted.Transitions = append(converted.Transitions, LifecycleTransition{
+				Days:         aws.ToInt32(transition.Days),
+				Date:         transition.Date,
+				StorageClass: string(transition.StorageClass),
+			})
+		}
+		out = append(out, converted)
+	}
+
+	return out
+}
+
+// lifecycleRuleFilter returns the key prefix of the rule's filter and whether
+// the filter also depends on object tags or sizes.
+func lifecycleRuleFilter(rule s3Types.LifecycleRule) (string, bool) {
+	switch filter := rule.Filter.(type) {
+	case *s3Types.LifecycleRuleFilterMemberPrefix:
+		return filter.Value, false
+	case *s3Types.LifecycleRuleFilterMemberAnd:
+		filtered := len(filter.Value.Tags) > 0 || filter.Value.ObjectSizeGreaterThan != nil || filter.Value.ObjectSizeLessThan != nil
+		return aws.ToString(filter.Value.Prefix), filtered
+	case *s3Types.LifecycleRuleFilterMemberTag,
+		*s3Types.LifecycleRuleFilterMemberObjectSizeGreaterThan,
+		*s3Types.LifecycleRuleFilterMemberObjectSizeLessThan:
+		return "", true
+	default:
+		return aws.ToString(rule.Prefix), false
+	}
+}
+
+// FindMatchingRules returns the enabled rules that apply to the given key, in
+// the order in which they are configured. Rules that are filtered by object
+// tags or sizes are not returned, since whether they apply cannot be
+// determined from the key.
+//
+// S3 applies every matching rule to an object, so when rules overlap, the
+// earliest action of any of them takes effect.
+func FindMatchingRules(rules []LifecycleRule, key string) []LifecycleRule {
+	var matches []LifecycleRule
+	for _, rule := range rules {
+		if !rule.Enabled || rule.FilteredByTagsOrSize || !strings.HasPrefix(key, rule.Prefix) {
+			continue
+		}
+		matches = append(matches, rule)
+	}
+
+	return matches
+}
+
+// predictExpiry returns the earliest time at which any of the rules expires an
+// object created at the given time, or nil if none of them expires it.
+func predictExpiry(rules []LifecycleRule, created time.Time) *time.Time {
+	var expiry *time.Time
+	for _, rule := range rules {
+		var candidates []time.Time
+		if rule.ExpirationDays > 0 {
+			candidates = append(candidates, lifecycleActionDate(created, rule.ExpirationDays))
+		}
+		if rule.ExpirationDate != nil {
+			candidates = append(candidates, rule.ExpirationDate.UTC())
+		}
+		for i := range candidates {
+			if expiry == nil || candidates[i].Before(*expiry) {
+				expiry = &candidates[i]
+			}
+		}
+	}
+
+	return expiry
+}
+
+// transitionStorageClassTier orders storage classes from the warmest to the
+// coldest. S3 only transitions objects to colder storage classes, and when an
+// object is due to transition to more than one class at once, it chooses the
+// coldest one.
+var transitionStorageClassTier = map[string]int{
+	string(s3Types.TransitionStorageClassStandardIa):         1,
+	string(s3Types.TransitionStorageClassOnezoneIa):          1,
+	string(s3Types.TransitionStorageClassIntelligentTiering): 1,
+	string(s3Types.TransitionStorageClassGlacierIr):          2,
+	string(s3Types.TransitionStorageClassGlacier):            3,
+	string(s3Types.TransitionStorageClassDeepArchive):        4,
+}
+
+// predictTransitions returns the storage class transitions that the rules
+// apply to an object created at the given time, in the order in which they
+// occur. Transitions that would not take effect are omitted: those to a
+// storage class that is no colder than one the object has already
+// transitioned to, and those on or after the object's expiry.
+func predictTransitions(rules []LifecycleRule, created time.Time) []PredictedTransition {
+	var candidates []PredictedTransition
+	for _, rule := range rules {
+		for _, transition := range rule.Transitions {
+			date := lifecycleActionDate(created, transition.Days)
+			if transition.Date != nil {
+				date = transition.Date.UTC()
+			}
+			candidates = append(candidates, PredictedTransition{StorageClass: transition.StorageClass, Date: date})
+		}
+	}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if !candidates[i].Date.Equal(candidates[j].Date) {
+			return candidates[i].Date.Before(candidates[j].Date)
+		}
+		return transitionStorageClassTier[candidates[i].StorageClass] > transitionStorageClassTier[candidates[j].StorageClass]
+	})
+
+	expiry := predictExpiry(rules, created)
+	var transitions []PredictedTransition
+	tier := 0
+	for _, candidate := range candidates {
+		if expiry != nil && !candidate.Date.Before(*expiry) {
+			break
+		}
+		if len(transitions) > 0 && candidate.Date.Equal(transitions[len(transitions)-1].Date) {
+			continue
+		}
+		if candidateTier := transitionStorageClassTier[candidate.StorageClass]; len(transitions) == 0 || candidateTier > tier {
+			transitions = append(transitions, candidate)
+			tier = candidateTier
+		}
+	}
+
+	return transitions
+}
+
+// lifecycleActionDate returns the time at which a lifecycle action that
+// applies the given number of days after an object is created takes effect.
+// S3 rounds this up to the next midnight UTC.
+func lifecycleActionDate(created time.Time, days int32) time.Time {
+	date := created.UTC().AddDate(0, 0, int(days))
+	midnight := date.Truncate(24 * time.Hour)
+	if midnight.Before(date) {
+		midnight = midnight.Add(24 * time.Hour)
+	}
+
+	return midnight
+}
+
+func (s *s3Bucket) GetLifecycleRules(ctx context.Context) ([]LifecycleRule, error) {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"operation":     "get lifecycle rules",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+	})
+
+	result, err := s.svc.GetBucketLifecycleConfiguration(ctx, &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(s.name),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchLifecycleConfiguration" {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "getting bucket lifecycle configuration")
+	}
+
+	return convertLifecycleRules(result.Rules), nil
+}
+
+func (s *s3Bucket) PredictExpiry(ctx context.Context, key string) (*time.Time, error) {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"operation":     "predict expiry",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+		"key":           key,
+	})
+
+	rules, err := s.GetLifecycleRules(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	rules = FindMatchingRules(rules, s.normalizeKey(key))
+	expires := false
+	for _, rule := range rules {
+		expires = expires || rule.ExpirationDays > 0 || rule.ExpirationDate != nil
+	}
+	if !expires {
+		return nil, nil
+	}
+
+	created, err := s.objectCreated(ctx, key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return predictExpiry(rules, created), nil
+}
+
+// parseExpirationHeader returns the expiry date in the value of the
+// x-amz-expiration header that S3 returns for objects that a lifecycle rule
+// expires, such as:
+//
+//	expiry-date="Fri, 23 Dec 2012 00:00:00 GMT", rule-id="rule"
+//
+// It returns nil if the header is empty or does not have a valid expiry date.
+func parseExpirationHeader(header string) *time.Time {
+	const field = `expiry-date="`
+	start := strings.Index(header, field)
+	if start < 0 {
+		return nil
+	}
+	value := header[start+len(field):]
+	end := strings.IndexByte(value, '"')
+	if end < 0 {
+		return nil
+	}
+
+	expiry, err := time.Parse(http.TimeFormat, value[:end])
+	if err != nil {
+		return nil
+	}
+	return &expiry
+}
+
+func (s *s3Bucket) PredictTransitions(ctx context.Context, key string) ([]PredictedTransition, error) {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"operation":     "predict transitions",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+		"key":           key,
+	})
+
+	rules, err := s.GetLifecycleRules(ctx)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	rules = FindMatchingRules(rules, s.normalizeKey(key))
+	hasTransitions := false
+	for _, rule := range rules {
+		hasTransitions = hasTransitions || len(rule.Transitions) > 0
+	}
+	if !hasTransitions {
+		return nil, nil
+	}
+
+	created, err := s.objectCreated(ctx, key)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return predictTransitions(rules, created), nil
+}
+
+// objectCreated returns the time at which the object with the given key was
+// created, from which lifecycle actions are scheduled.
+func (s *s3Bucket) objectCreated(ctx context.Context, key string) (time.Time, error) {
+	head, err := s.svc.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.name),
+		Key:    aws.String(s.normalizeKey(key)),
+	})
+	if err != nil {
+		return time.Time{}, errors.Wrap(err, "getting S3 head object")
+	}
+
+	return aws.ToTime(head.LastModified), nil
+}
+
+// CopyLifecycleConfiguration applies the lifecycle configuration of the
+// source bucket to the destination bucket, replacing any lifecycle
+// configuration that the destination already has. Both buckets must be S3
+// buckets. If the source does not have a lifecycle configuration, the
+// destination is left unchanged.
+//
+// The S3 rules are copied as-is, so settings that are not described by
+// LifecycleRule, such as noncurrent version expiration, are preserved.
+func CopyLifecycleConfiguration(ctx context.Context, src, dest Bucket) error {
+	srcBucket, ok := asS3Bucket(src)
+	if !ok {
+		return errors.New("source bucket is not an S3 bucket")
+	}
+	destBucket, ok := asS3Bucket(dest)
+	if !ok {
+		return errors.New("destination bucket is not an S3 bucket")
+	}
+
+	grip.DebugWhen(srcBucket.verbose || destBucket.verbose, message.Fields{
+		"type":               "s3",
+		"operation":          "copy lifecycle configuration",
+		"source_bucket":      srcBucket.name,
+		"destination_bucket": destBucket.name,
+		"dry_run":            destBucket.dryRun,
+	})
+
+	result, err := srcBucket.svc.GetBucketLifecycleConfiguration(ctx, &s3.GetBucketLifecycleConfigurationInput{
+		Bucket: aws.String(srcBucket.name),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchLifecycleConfiguration" {
+			return nil
+		}
+		return errors.Wrap(err, "getting source bucket lifecycle configuration")
+	}
+	if len(result.Rules) == 0 || destBucket.dryRun {
+		return nil
+	}
+
+	_, err = destBucket.svc.PutBucketLifecycleConfiguration(ctx, &s3.PutBucketLifecycleConfigurationInput{
+		Bucket:                 aws.String(destBucket.name),
+		LifecycleConfiguration: &s3Types.BucketLifecycleConfiguration{Rules: result.Rules},
+	})
+	return errors.Wrap(err, "putting destination bucket lifecycle configuration")
+}
+
+// asS3Bucket returns the S3 bucket underlying the given bucket, if any.
+func asS3Bucket(b Bucket) (*s3Bucket, bool) {
+	switch bucket := b.(type) {
+	case *s3BucketSmall:
+		return &bucket.s3Bucket, true
+	case *s3BucketLarge:
+		return &bucket.s3Bucket, true
+	case *s3ArchiveBucket:
+		return &bucket.s3Bucket, true
+	default:
+		return nil, false
+	}
+}
@@ -0,0 +1,171 @@
+package pail
+
+import (
+	"context"
+	"sort"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
+	"github.com/pkg/errors"
+)
+
+// listParallelDelimiter is the delimiter that separates the sub-prefixes that
+// ListParallel lists concurrently.
+const listParallelDelimiter = "/"
+
+func (s *s3BucketSmall) ListParallel(ctx context.Context, prefix string, workers int) (BucketIterator, error) {
+	return s.listParallelHelper(ctx, s, prefix, workers)
+}
+
+func (s *s3BucketLarge) ListParallel(ctx context.Context, prefix string, workers int) (BucketIterator, error) {
+	return s.listParallelHelper(ctx, s, prefix, workers)
+}
+
+// listParallelHelper lists the objects directly under the prefix and the
+// sub-prefixes that contain the rest, then lists the sub-prefixes
+// concurrently.
+func (s *s3Bucket) listParallelHelper(ctx context.Context, b Bucket, prefix string, workers int) (BucketIterator, error) {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"operation":     "list parallel",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+		"prefix":        prefix,
+		"workers":       workers,
+	})
+
+	if workers < 1 {
+		workers = 1
+	}
+
+	objects, subPrefixes, err := s.listDelimited(ctx, s.normalizeListPrefix(prefix))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+	// A prefix that is not followed by the delimiter, or that contains a
+	// single directory, has few sub-prefixes, so expand them until there
+	// are enough to keep the workers busy.
+	for len(subPrefixes) > 0 && len(subPrefixes) < workers {
+		var expanded []string
+		for _, subPrefix := range subPrefixes {
+			subObjects, subSubPrefixes, err := s.listDelimited(ctx, subPrefix)
+			if err != nil {
+				return nil, errors.WithStack(err)
+			}
+			objects = append(objects, subObjects...)
+			expanded = append(expanded, subSubPrefixes...)
+		}
+		subPrefixes = expanded
+	}
+
+	prefixes := make(chan string, len(subPrefixes))
+	for _, subPrefix := range subPrefixes {
+		prefixes <- subPrefix
+	}
+	close(prefixes)
+
+	listings := []func(context.Context, func(BucketItem) bool) error{
+		func(_ context.Context, send func(BucketItem) bool) error {
+			for _, obj := range objects {
+				if !send(s.newBucketItem(b, obj)) {
+					return nil
+				}
+			}
+			return nil
+		},
+	}
+	for i := 0; i < workers && i < len(subPrefixes); i++ {
+		listings = append(listings, func(ctx context.Context, send func(BucketItem) bool) error {
+			for subPrefix := range prefixes {
+				iter, err := s.listHelper(ctx, b, subPrefix)
+				if err != nil {
+					return errors.Wrapf(err, "listing prefix '%s'", subPrefix)
+				}
+				for iter.Next(ctx) {
+					if !send(iter.Item()) {
+						return nil
+					}
+				}
+				if err := iter.Err(); err != nil {
+					return errors.Wrapf(err, "iterating prefix '%s'", subPrefix)
+				}
+			}
+			return nil
+		})
+	}
+
+	return newParallelListIterator(ctx, listings...), nil
+}
+
+func (s *s3BucketSmall) ListDir(ctx context.Context, dir string) (BucketIterator, error) {
+	return s.listDirHelper(ctx, s, dir)
+}
+
+func (s *s3BucketLarge) ListDir(ctx context.Context, dir string) (BucketIterator, error) {
+	return s.listDirHelper(ctx, s, dir)
+}
+
+// listDirHelper lists the objects directly in the directory and its
+// subdirectories with a single delimited listing.
+func (s *s3Bucket) listDirHelper(ctx context.Context, b Bucket, dir string) (BucketIterator, error) {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"operation":     "list dir",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+		"dir":           dir,
+	})
+
+	objects, subPrefixes, err := s.listDelimited(ctx, s.normalizeListPrefix(dirPrefix(dir)))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	items := make([]BucketItem, 0, len(objects)+len(subPrefixes))
+	for _, obj := range objects {
+		items = append(items, s.newBucketItem(b, obj))
+	}
+	for _, subPrefix := range subPrefixes {
+		items = append(items, &bucketItemImpl{bucket: s.name, key: s.denormalizeKey(subPrefix), b: b})
+	}
+	sort.Slice(items, func(i, j int) bool { return items[i].Name() < items[j].Name() })
+
+	return &bucketItemsIterator{items: items, idx: -1}, nil
+}
+
+// listDelimited lists the objects directly under the normalized prefix and
+// the sub-prefixes, up to the next delimiter, of the objects under it.
+func (s *s3Bucket) listDelimited(ctx context.Context, prefix string) ([]s3Types.Object, []string, error) {
+	var objects []s3Types.Object
+	var subPrefixes []string
+	var marker string
+	for {
+		result, err := s.svc.ListObjects(ctx, &s3.ListObjectsInput{
+			Bucket:    aws.String(s.name),
+			Prefix:    aws.String(prefix),
+			Delimiter: aws.String(listParallelDelimiter),
+			Marker:    aws.String(marker),
+		})
+		if err != nil {
+			return nil, nil, errors.Wrap(err, "listing sub-prefixes")
+		}
+
+		objects = append(objects, result.Contents...)
+		for _, commonPrefix := range result.CommonPrefixes {
+			subPrefixes = append(subPrefixes, aws.ToString(commonPrefix.Prefix))
+		}
+		if !aws.ToBool(result.IsTruncated) {
+			return objects, subPrefixes, nil
+		}
+
+		// S3 only returns the next marker when listing with a
+		// delimiter, since the last entry may be a sub-prefix.
+		marker = aws.ToString(result.NextMarker)
+		if marker == "" {
+			return nil, nil, errors.New("truncated listing did not return a next marker")
+		}
+	}
+}
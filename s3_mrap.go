@@ -0,0 +1,59 @@
+package pail
+
+import (
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws/arn"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/pkg/errors"
+)
+
+// sigV4AAuthSchemeID is the ID of the SigV4A signing scheme, which requests to
+// multi-region access points must be signed with.
+const sigV4AAuthSchemeID = "aws.auth#sigv4a"
+
+// isAccessPointARN returns whether the bucket name is the ARN of an S3 access
+// point rather than the name of a bucket.
+func isAccessPointARN(name string) bool {
+	parsed, err := arn.Parse(name)
+	if err != nil {
+		return false
+	}
+	return parsed.Service == "s3" && strings.HasPrefix(parsed.Resource, "accesspoint/")
+}
+
+// isMultiRegionAccessPointARN returns whether the bucket name is the ARN of an
+// S3 multi-region access point, which, unlike the ARNs of other access
+// points, does not have a region.
+func isMultiRegionAccessPointARN(name string) bool {
+	if !isAccessPointARN(name) {
+		return false
+	}
+	parsed, _ := arn.Parse(name)
+	return parsed.Region == ""
+}
+
+// copySource returns the source of a copy of the object with the given key in
+// the given bucket, before it is escaped. Objects accessed through access
+// points, including multi-region access points, are copied from the object
+// path of the access point's ARN.
+func copySource(bucket, key string) string {
+	if isAccessPointARN(bucket) {
+		return consistentJoin([]string{bucket, "object", key})
+	}
+	return consistentJoin([]string{bucket, key})
+}
+
+// validateSigV4ASupport checks that the client can sign requests with SigV4A,
+// which requests to multi-region access points require.
+func validateSigV4ASupport(opts s3.Options) error {
+	if opts.DisableMultiRegionAccessPoints {
+		return errors.New("multi-region access points are disabled")
+	}
+	for _, scheme := range opts.AuthSchemes {
+		if scheme.SchemeID() == sigV4AAuthSchemeID {
+			return nil
+		}
+	}
+	return errors.New("SigV4A signing is not available")
+}
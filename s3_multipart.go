@@ -0,0 +1,146 @@
+package pail
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
+	"github.com/pkg/errors"
+)
+
+const (
+	// maxMultipartParts is the maximum number of parts in an S3 multipart
+	// upload.
+	maxMultipartParts = 10000
+	// maxMultipartPartSize is the maximum size of a part in an S3
+	// multipart upload.
+	maxMultipartPartSize = 5 * 1024 * 1024 * 1024
+	// abortMultipartUploadTimeout is how long aborting a multipart upload
+	// may take after the writer's context is done.
+	abortMultipartUploadTimeout = time.Minute
+)
+
+// multipartPartSize returns the part size to use to upload an object of the
+// given size in at most maxMultipartParts parts, which is the given minimum
+// part size unless the object is too large to upload in parts of that size.
+// It returns an error if the object is too large to upload in parts of the
+// maximum part size.
+func multipartPartSize(size int64, minPartSize int) (int, error) {
+	if size <= int64(minPartSize)*maxMultipartParts {
+		return minPartSize, nil
+	}
+
+	partSize := (size + maxMultipartParts - 1) / maxMultipartParts
+	if partSize > maxMultipartPartSize {
+		return 0, errors.Errorf("object of %d bytes is too large to upload in at most %d parts of at most %d bytes", size, maxMultipartParts, maxMultipartPartSize)
+	}
+
+	return int(partSize), nil
+}
+
+// MultipartUploadBucket is implemented by buckets that can list the multipart
+// uploads that were started but neither completed nor aborted. Incomplete
+// uploads are not visible as objects, but their parts are stored, and billed,
+// until they are aborted.
+type MultipartUploadBucket interface {
+	// ListIncompleteUploads returns an iterator over the incomplete
+	// multipart uploads of objects with the given prefix, ordered by key
+	// and then by the time they were initiated.
+	ListIncompleteUploads(ctx context.Context, prefix string) (MultipartUploadIterator, error)
+}
+
+// MultipartUpload describes an incomplete multipart upload.
+type MultipartUpload struct {
+	// Key is the key of the object being uploaded.
+	Key string
+	// UploadID identifies the upload, for example to abort it.
+	UploadID  string
+	Initiated time.Time
+}
+
+// MultipartUploadIterator iterates over incomplete multipart uploads, in the
+// same way as a BucketIterator iterates over objects.
+type MultipartUploadIterator interface {
+	Next(context.Context) bool
+	Err() error
+	Item() *MultipartUpload
+}
+
+func (s *s3Bucket) ListIncompleteUploads(ctx context.Context, prefix string) (MultipartUploadIterator, error) {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"operation":     "list incomplete uploads",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+		"prefix":        prefix,
+	})
+
+	iter := &s3MultipartUploadIterator{
+		s: s,
+		input: &s3.ListMultipartUploadsInput{
+			Bucket: aws.String(s.name),
+			Prefix: aws.String(s.normalizeListPrefix(prefix)),
+		},
+		idx: -1,
+	}
+	if err := iter.fetch(ctx); err != nil {
+		return nil, err
+	}
+
+	return iter, nil
+}
+
+type s3MultipartUploadIterator struct {
+	s           *s3Bucket
+	input       *s3.ListMultipartUploadsInput
+	uploads     []s3Types.MultipartUpload
+	idx         int
+	isTruncated bool
+	item        *MultipartUpload
+	err         error
+}
+
+// fetch lists the next page of uploads.
+func (iter *s3MultipartUploadIterator) fetch(ctx context.Context) error {
+	result, err := iter.s.svc.ListMultipartUploads(ctx, iter.input)
+	if err != nil {
+		return errors.Wrap(err, "listing multipart uploads")
+	}
+
+	iter.uploads = result.Uploads
+	iter.idx = -1
+	iter.isTruncated = aws.ToBool(result.IsTruncated)
+	iter.input.KeyMarker = result.NextKeyMarker
+	iter.input.UploadIdMarker = result.NextUploadIdMarker
+	return nil
+}
+
+func (iter *s3MultipartUploadIterator) Err() error { return iter.err }
+
+func (iter *s3MultipartUploadIterator) Item() *MultipartUpload { return iter.item }
+
+func (iter *s3MultipartUploadIterator) Next(ctx context.Context) bool {
+	iter.idx++
+	for iter.idx > len(iter.uploads)-1 {
+		if !iter.isTruncated {
+			return false
+		}
+		if err := iter.fetch(ctx); err != nil {
+			iter.err = err
+			return false
+		}
+		iter.idx++
+	}
+
+	upload := iter.uploads[iter.idx]
+	iter.item = &MultipartUpload{
+		Key:       iter.s.denormalizeKey(aws.ToString(upload.Key)),
+		UploadID:  aws.ToString(upload.UploadId),
+		Initiated: aws.ToTime(upload.Initiated),
+	}
+	return true
+}
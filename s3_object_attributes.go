@@ -0,0 +1,243 @@
+package pail
+
+import (
+	"context"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
+	"github.com/pkg/errors"
+)
+
+const (
+	// listAttributesBatchSize is the number of listed objects whose
+	// attributes are fetched together before any of them are returned.
+	listAttributesBatchSize = 100
+	// listAttributesWorkers is the maximum number of concurrent requests
+	// for the attributes of listed objects.
+	listAttributesWorkers = 8
+)
+
+// ObjectAttributesBucket is implemented by buckets that can list objects
+// along with attributes that listing does not return.
+type ObjectAttributesBucket interface {
+	// ListWithAttributes lists the objects with the given prefix, like
+	// List, and fetches the given attributes of each object. The
+	// attributes are the names of the attributes of S3's
+	// GetObjectAttributes API: "ETag", "Checksum", "ObjectParts",
+	// "StorageClass" and "ObjectSize". The iterator's items implement
+	// AttributesBucketItem.
+	ListWithAttributes(ctx context.Context, prefix string, attrs []string) (BucketIterator, error)
+}
+
+// ObjectAttributes are the attributes of an object. Attributes that were not
+// requested, or that the object does not have, are empty.
+type ObjectAttributes struct {
+	ETag           string
+	Size           int64
+	StorageClass   string
+	ChecksumCRC32  string
+	ChecksumCRC32C string
+	ChecksumSHA1   string
+	ChecksumSHA256 string
+	// PartsCount is the number of parts the object was uploaded in, or
+	// zero if it was not uploaded in parts.
+	PartsCount int
+}
+
+// AttributesBucketItem is implemented by bucket items that were listed along
+// with their attributes.
+type AttributesBucketItem interface {
+	SizedBucketItem
+	Attributes() ObjectAttributes
+}
+
+type attributesBucketItem struct {
+	*bucketItemImpl
+	attrs ObjectAttributes
+}
+
+func (item *attributesBucketItem) Attributes() ObjectAttributes { return item.attrs }
+
+// validateObjectAttributes converts the names of object attributes to the
+// attributes that can be requested from S3, checking that each is valid.
+func validateObjectAttributes(attrs []string) ([]s3Types.ObjectAttributes, error) {
+	if len(attrs) == 0 {
+		return nil, errors.New("must request at least one object attribute")
+	}
+
+	var converted []s3Types.ObjectAttributes
+	for _, attr := range attrs {
+		var valid bool
+		for _, value := range s3Types.ObjectAttributes("").Values() {
+			if attr == string(value) {
+				valid = true
+				break
+			}
+		}
+		if !valid {
+			return nil, errors.Errorf("unsupported object attribute '%s'", attr)
+		}
+		converted = append(converted, s3Types.ObjectAttributes(attr))
+	}
+	return converted, nil
+}
+
+func (s *s3BucketSmall) ListWithAttributes(ctx context.Context, prefix string, attrs []string) (BucketIterator, error) {
+	return s.listWithAttributesHelper(ctx, s, prefix, attrs)
+}
+
+func (s *s3BucketLarge) ListWithAttributes(ctx context.Context, prefix string, attrs []string) (BucketIterator, error) {
+	return s.listWithAttributesHelper(ctx, s, prefix, attrs)
+}
+
+func (s *s3Bucket) listWithAttributesHelper(ctx context.Context, b Bucket, prefix string, attrs []string) (BucketIterator, error) {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"operation":     "list with attributes",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+		"prefix":        prefix,
+		"attributes":    attrs,
+	})
+
+	converted, err := validateObjectAttributes(attrs)
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	iter, err := s.listHelper(ctx, b, s.normalizeListPrefix(prefix))
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return &s3AttributesIterator{iter: iter, s: s, attrs: converted, idx: -1}, nil
+}
+
+// getObjectAttributes fetches the given attributes of the object with the
+// given key.
+func (s *s3Bucket) getObjectAttributes(ctx context.Context, key string, attrs []s3Types.ObjectAttributes) (ObjectAttributes, error) {
+	release, err := s.acquireMetadataSlot(ctx)
+	if err != nil {
+		return ObjectAttributes{}, err
+	}
+	defer release()
+
+	out, err := s.svc.GetObjectAttributes(ctx, &s3.GetObjectAttributesInput{
+		Bucket:           aws.String(s.name),
+		Key:              aws.String(s.normalizeKey(key)),
+		ObjectAttributes: attrs,
+	})
+	if err != nil {
+		return ObjectAttributes{}, errors.Wrapf(err, "getting attributes of key '%s'", key)
+	}
+
+	result := ObjectAttributes{
+		ETag:         aws.ToString(out.ETag),
+		Size:         aws.ToInt64(out.ObjectSize),
+		StorageClass: string(out.StorageClass),
+	}
+	if out.Checksum != nil {
+		result.ChecksumCRC32 = aws.ToString(out.Checksum.ChecksumCRC32)
+		result.ChecksumCRC32C = aws.ToString(out.Checksum.ChecksumCRC32C)
+		result.ChecksumSHA1 = aws.ToString(out.Checksum.ChecksumSHA1)
+		result.ChecksumSHA256 = aws.ToString(out.Checksum.ChecksumSHA256)
+	}
+	if out.ObjectParts != nil {
+		result.PartsCount = int(aws.ToInt32(out.ObjectParts.TotalPartsCount))
+	}
+	return result, nil
+}
+
+// s3AttributesIterator iterates over listed objects, fetching the attributes
+// of each batch of objects concurrently before returning them.
+type s3AttributesIterator struct {
+	iter  BucketIterator
+	s     *s3Bucket
+	attrs []s3Types.ObjectAttributes
+	batch []*attributesBucketItem
+	idx   int
+	err   error
+}
+
+func (iter *s3AttributesIterator) Next(ctx context.Context) bool {
+	if iter.err != nil {
+		return false
+	}
+	if iter.idx+1 < len(iter.batch) {
+		iter.idx++
+		return true
+	}
+
+	iter.batch = iter.batch[:0]
+	iter.idx = -1
+	for len(iter.batch) < listAttributesBatchSize && iter.iter.Next(ctx) {
+		item, ok := iter.iter.Item().(*bucketItemImpl)
+		if !ok {
+			iter.err = errors.Errorf("unexpected bucket item type %T", iter.iter.Item())
+			return false
+		}
+		iter.batch = append(iter.batch, &attributesBucketItem{bucketItemImpl: item})
+	}
+	if err := iter.iter.Err(); err != nil {
+		iter.err = errors.WithStack(err)
+		return false
+	}
+	if len(iter.batch) == 0 {
+		return false
+	}
+
+	if err := iter.fetchAttributes(ctx); err != nil {
+		iter.err = err
+		return false
+	}
+
+	iter.idx = 0
+	return true
+}
+
+// fetchAttributes fetches the attributes of every item in the current batch,
+// using at most listAttributesWorkers concurrent requests.
+func (iter *s3AttributesIterator) fetchAttributes(ctx context.Context) error {
+	items := make(chan *attributesBucketItem, len(iter.batch))
+	for _, item := range iter.batch {
+		items <- item
+	}
+	close(items)
+
+	catcher := grip.NewBasicCatcher()
+	wg := &sync.WaitGroup{}
+	for i := 0; i < listAttributesWorkers && i < len(iter.batch); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for item := range items {
+				if ctx.Err() != nil {
+					catcher.Add(ctx.Err())
+					return
+				}
+				attrs, err := iter.s.getObjectAttributes(ctx, item.Name(), iter.attrs)
+				if err != nil {
+					catcher.Add(err)
+					continue
+				}
+				item.attrs = attrs
+			}
+		}()
+	}
+	wg.Wait()
+
+	return catcher.Resolve()
+}
+
+func (iter *s3AttributesIterator) Err() error { return iter.err }
+
+func (iter *s3AttributesIterator) Item() BucketItem {
+	if iter.idx < 0 || iter.idx >= len(iter.batch) {
+		return nil
+	}
+	return iter.batch[iter.idx]
+}
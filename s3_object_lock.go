@@ -0,0 +1,286 @@
+package pail
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
+	"github.com/pkg/errors"
+)
+
+// ObjectLockBucket is implemented by buckets that support Object Lock, which
+// prevents objects from being overwritten or removed while they are under a
+// retention period or legal hold.
+type ObjectLockBucket interface {
+	// GetObjectLegalHold returns whether the object with the given key is
+	// under a legal hold.
+	GetObjectLegalHold(context.Context, string) (bool, error)
+
+	// GetObjectRetention returns the retention settings of the object with
+	// the given key, or nil if the object has no retention settings.
+	GetObjectRetention(context.Context, string) (*ObjectRetention, error)
+
+	// GetBucketObjectLockConfiguration returns the bucket's Object Lock
+	// configuration, or nil if the bucket does not have Object Lock
+	// enabled.
+	GetBucketObjectLockConfiguration(context.Context) (*ObjectLockConfig, error)
+}
+
+// ObjectRetention describes the Object Lock retention settings of an object.
+type ObjectRetention struct {
+	// Mode is the retention mode, either "GOVERNANCE" or "COMPLIANCE".
+	Mode string
+	// RetainUntil is the time until which the object is retained.
+	RetainUntil time.Time
+	// RetainFor is the length of time for which the object is retained
+	// after it is written. It is only used to set the retention of new
+	// objects, for which it may be set instead of RetainUntil, so that
+	// each object is retained for the same length of time regardless of
+	// when it is written.
+	RetainFor time.Duration
+}
+
+// at returns the retention of an object written at the given time.
+func (r *ObjectRetention) at(written time.Time) *ObjectRetention {
+	if r == nil || r.RetainFor == 0 {
+		return r
+	}
+	return &ObjectRetention{Mode: r.Mode, RetainUntil: written.Add(r.RetainFor)}
+}
+
+// IsActive returns whether the retention period has not yet expired.
+func (r *ObjectRetention) IsActive() bool {
+	return r != nil && time.Now().Before(r.RetainUntil)
+}
+
+// ObjectLockConfig describes the Object Lock configuration of a bucket.
+type ObjectLockConfig struct {
+	// Enabled is whether Object Lock is enabled for the bucket.
+	Enabled bool
+	// Mode is the default retention mode applied to new objects, either
+	// "GOVERNANCE" or "COMPLIANCE", or empty if the bucket has no default
+	// retention.
+	Mode string
+	// Days and Years are the default retention period applied to new
+	// objects. At most one of them is set.
+	Days  int32
+	Years int32
+}
+
+// HasDefaultRetention returns whether new objects are retained by default.
+func (c *ObjectLockConfig) HasDefaultRetention() bool {
+	return c != nil && c.Mode != "" && (c.Days > 0 || c.Years > 0)
+}
+
+// DefaultRetainUntil returns the time until which an object written at the
+// given time is retained by default.
+func (c *ObjectLockConfig) DefaultRetainUntil(written time.Time) time.Time {
+	return written.AddDate(int(c.Years), 0, int(c.Days))
+}
+
+// validateRetention checks that the retention settings of an object written
+// at the given time are at least as strict as the bucket's default
+// retention.
+func (c *ObjectLockConfig) validateRetention(r *ObjectRetention, written time.Time) error {
+	if r == nil || !c.HasDefaultRetention() {
+		return nil
+	}
+
+	catcher := grip.NewBasicCatcher()
+	catcher.ErrorfWhen(retentionModeStrictness(r.Mode) < retentionModeStrictness(c.Mode), "retention mode '%s' is less strict than the bucket's default mode '%s'", r.Mode, c.Mode)
+	minRetainUntil := c.DefaultRetainUntil(written)
+	catcher.ErrorfWhen(r.RetainUntil.Before(minRetainUntil), "retention until %s is shorter than the bucket's default retention until %s", r.RetainUntil.Format(time.RFC3339), minRetainUntil.Format(time.RFC3339))
+
+	return catcher.Resolve()
+}
+
+// retentionModeStrictness orders retention modes from least to most strict.
+func retentionModeStrictness(mode string) int {
+	switch s3Types.ObjectLockRetentionMode(mode) {
+	case s3Types.ObjectLockRetentionModeCompliance:
+		return 2
+	case s3Types.ObjectLockRetentionModeGovernance:
+		return 1
+	default:
+		return 0
+	}
+}
+
+// validate checks that the retention settings can be applied to new objects.
+func (r *ObjectRetention) validate() error {
+	catcher := grip.NewBasicCatcher()
+	catcher.ErrorfWhen(retentionModeStrictness(r.Mode) == 0, "invalid retention mode '%s'", r.Mode)
+	catcher.NewWhen(r.RetainUntil.IsZero() && r.RetainFor == 0, "must specify a retention time or duration")
+	catcher.NewWhen(!r.RetainUntil.IsZero() && r.RetainFor != 0, "cannot specify both a retention time and duration")
+	catcher.NewWhen(r.RetainFor < 0, "retention duration cannot be negative")
+
+	return catcher.Resolve()
+}
+
+func (s *s3Bucket) GetObjectLegalHold(ctx context.Context, key string) (bool, error) {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"operation":     "get object legal hold",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+		"key":           key,
+	})
+
+	result, err := s.svc.GetObjectLegalHold(ctx, &s3.GetObjectLegalHoldInput{
+		Bucket: aws.String(s.name),
+		Key:    aws.String(s.normalizeKey(key)),
+	})
+	if err != nil {
+		if isNoObjectLockConfigurationError(err) {
+			return false, nil
+		}
+		return false, errors.Wrapf(s.wrapObjectLockNotFound(err), "getting legal hold for key '%s'", key)
+	}
+	if result.LegalHold == nil {
+		return false, nil
+	}
+
+	return result.LegalHold.Status == s3Types.ObjectLockLegalHoldStatusOn, nil
+}
+
+func (s *s3Bucket) GetObjectRetention(ctx context.Context, key string) (*ObjectRetention, error) {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"operation":     "get object retention",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+		"key":           key,
+	})
+
+	result, err := s.svc.GetObjectRetention(ctx, &s3.GetObjectRetentionInput{
+		Bucket: aws.String(s.name),
+		Key:    aws.String(s.normalizeKey(key)),
+	})
+	if err != nil {
+		if isNoObjectLockConfigurationError(err) {
+			return nil, nil
+		}
+		return nil, errors.Wrapf(s.wrapObjectLockNotFound(err), "getting retention for key '%s'", key)
+	}
+	if result.Retention == nil {
+		return nil, nil
+	}
+
+	return &ObjectRetention{
+		Mode:        string(result.Retention.Mode),
+		RetainUntil: aws.ToTime(result.Retention.RetainUntilDate),
+	}, nil
+}
+
+func (s *s3Bucket) GetBucketObjectLockConfiguration(ctx context.Context) (*ObjectLockConfig, error) {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"operation":     "get bucket object lock configuration",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+	})
+
+	result, err := s.svc.GetObjectLockConfiguration(ctx, &s3.GetObjectLockConfigurationInput{
+		Bucket: aws.String(s.name),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "ObjectLockConfigurationNotFoundError" {
+			return nil, nil
+		}
+		return nil, errors.Wrap(err, "getting bucket object lock configuration")
+	}
+	if result.ObjectLockConfiguration == nil {
+		return nil, nil
+	}
+
+	config := &ObjectLockConfig{
+		Enabled: result.ObjectLockConfiguration.ObjectLockEnabled == s3Types.ObjectLockEnabledEnabled,
+	}
+	if rule := result.ObjectLockConfiguration.Rule; rule != nil && rule.DefaultRetention != nil {
+		config.Mode = string(rule.DefaultRetention.Mode)
+		config.Days = aws.ToInt32(rule.DefaultRetention.Days)
+		config.Years = aws.ToInt32(rule.DefaultRetention.Years)
+	}
+
+	return config, nil
+}
+
+// checkObjectRetention checks that the given retention of a new object, if
+// any, is at least as strict as the bucket's default retention.
+func (s *s3Bucket) checkObjectRetention(ctx context.Context, retention *ObjectRetention) error {
+	if retention == nil {
+		return nil
+	}
+
+	config, err := s.objectLockConfig.get(ctx, s.GetBucketObjectLockConfiguration)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	return errors.Wrap(config.validateRetention(retention, time.Now()), "validating object retention")
+}
+
+// objectLockConfigCache holds a bucket's Object Lock configuration once it
+// has been fetched, so that writes that set object retention do not each
+// fetch it. The configuration is cached for the lifetime of the bucket.
+type objectLockConfigCache struct {
+	mu      sync.Mutex
+	fetched bool
+	config  *ObjectLockConfig
+}
+
+// get returns the cached configuration, fetching it if it has not been
+// fetched successfully yet. A nil cache always fetches the configuration.
+func (c *objectLockConfigCache) get(ctx context.Context, fetch func(context.Context) (*ObjectLockConfig, error)) (*ObjectLockConfig, error) {
+	if c == nil {
+		return fetch(ctx)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.fetched {
+		return c.config, nil
+	}
+
+	config, err := fetch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	c.config = config
+	c.fetched = true
+
+	return config, nil
+}
+
+func (s *s3Bucket) wrapObjectLockNotFound(err error) error {
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchKey" {
+		return MakeKeyNotFoundError(err)
+	}
+	return err
+}
+
+// isNoObjectLockConfigurationError returns whether the error indicates that
+// the object has no Object Lock settings of the requested kind.
+func isNoObjectLockConfigurationError(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "NoSuchObjectLockConfiguration"
+}
+
+// isObjectLockError returns whether S3 rejected a request because the object
+// is protected by Object Lock.
+func isObjectLockError(err error) bool {
+	var apiErr smithy.APIError
+	if !errors.As(err, &apiErr) || apiErr.ErrorCode() != "AccessDenied" {
+		return false
+	}
+	return strings.Contains(strings.ToLower(apiErr.ErrorMessage()), "object lock")
+}
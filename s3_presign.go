@@ -0,0 +1,142 @@
+package pail
+
+import (
+	"context"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
+	"github.com/pkg/errors"
+)
+
+// presignMaxExpiry is the longest that a URL presigned with SigV4 can be
+// valid for.
+const presignMaxExpiry = 7 * 24 * time.Hour
+
+// PresignBucket is implemented by buckets that can presign URLs, which let
+// clients without credentials access objects directly for a limited time.
+type PresignBucket interface {
+	// PresignGet returns a URL that downloads the object with the given
+	// key until it expires.
+	PresignGet(ctx context.Context, key string, expires time.Duration) (string, error)
+	// PresignGetWithOptions returns a URL that downloads the object with
+	// the given key, as configured by the options.
+	PresignGetWithOptions(ctx context.Context, key string, opts PresignGetOptions) (string, error)
+	// PresignPut returns a URL that uploads an object to the given key
+	// until it expires. The object is uploaded as it is sent, without the
+	// bucket's permissions, metadata, tags, or encryption.
+	PresignPut(ctx context.Context, key string, expires time.Duration) (string, error)
+}
+
+// PresignGetOptions configure a presigned download URL.
+type PresignGetOptions struct {
+	// Expires is how long the URL is valid for, which can be at most 7
+	// days.
+	Expires time.Duration
+	// ResponseContentDisposition, if set, overrides the Content-Disposition
+	// header of the response, for example so that browsers save the
+	// object as a file with a given name. (Optional)
+	ResponseContentDisposition string
+}
+
+func validatePresignExpiry(expires time.Duration) error {
+	if expires <= 0 || expires > presignMaxExpiry {
+		return errors.Errorf("expiration must be positive and at most %s", presignMaxExpiry)
+	}
+	return nil
+}
+
+func (s *s3Bucket) PresignGet(ctx context.Context, key string, expires time.Duration) (string, error) {
+	return s.PresignGetWithOptions(ctx, key, PresignGetOptions{Expires: expires})
+}
+
+func (s *s3Bucket) PresignGetWithOptions(ctx context.Context, key string, opts PresignGetOptions) (string, error) {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":                "s3",
+		"operation":           "presign get",
+		"bucket":              s.name,
+		"bucket_prefix":       s.prefix,
+		"key":                 key,
+		"expires":             opts.Expires,
+		"content_disposition": opts.ResponseContentDisposition,
+	})
+
+	if err := validatePresignExpiry(opts.Expires); err != nil {
+		return "", errors.WithStack(err)
+	}
+	client, err := s.presignClient(ctx)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.name),
+		Key:    aws.String(s.normalizeKey(key)),
+	}
+	if opts.ResponseContentDisposition != "" {
+		input.ResponseContentDisposition = aws.String(opts.ResponseContentDisposition)
+	}
+	req, err := client.PresignGetObject(ctx, input, s3.WithPresignExpires(opts.Expires))
+	if err != nil {
+		return "", errors.Wrapf(err, "presigning get of key '%s'", key)
+	}
+
+	return req.URL, nil
+}
+
+func (s *s3Bucket) PresignPut(ctx context.Context, key string, expires time.Duration) (string, error) {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"operation":     "presign put",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+		"key":           key,
+		"expires":       expires,
+	})
+
+	if err := s.validateKey(key); err != nil {
+		return "", errors.WithStack(err)
+	}
+	if err := validatePresignExpiry(expires); err != nil {
+		return "", errors.WithStack(err)
+	}
+	client, err := s.presignClient(ctx)
+	if err != nil {
+		return "", errors.WithStack(err)
+	}
+
+	req, err := client.PresignPutObject(ctx, &s3.PutObjectInput{
+		Bucket: aws.String(s.name),
+		Key:    aws.String(s.normalizeKey(key)),
+	}, s3.WithPresignExpires(expires))
+	if err != nil {
+		return "", errors.Wrapf(err, "presigning put of key '%s'", key)
+	}
+
+	return req.URL, nil
+}
+
+// presignClient returns a client that presigns URLs with the bucket's
+// credentials, checking that there are credentials to sign with, since URLs
+// presigned without them silently fail once used.
+func (s *s3Bucket) presignClient(ctx context.Context) (*s3.PresignClient, error) {
+	if s.presignSvc == nil {
+		return nil, errors.New("bucket's client cannot presign URLs")
+	}
+
+	provider := s.presignSvc.Options().Credentials
+	if provider == nil {
+		return nil, errors.New("cannot presign URLs without credentials")
+	}
+	creds, err := provider.Retrieve(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "retrieving credentials to presign with")
+	}
+	if creds.AccessKeyID == "" || creds.SecretAccessKey == "" {
+		return nil, errors.New("cannot presign URLs with anonymous credentials")
+	}
+
+	return s3.NewPresignClient(s.presignSvc), nil
+}
@@ -0,0 +1,51 @@
+package pail
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
+	"github.com/pkg/errors"
+)
+
+// RegionBucket is implemented by buckets that can detect the region in which
+// they are located.
+type RegionBucket interface {
+	// DetectBucketRegion returns the region in which the bucket is
+	// located.
+	DetectBucketRegion(context.Context) (string, error)
+}
+
+// normalizeRegion returns the region name for an S3 location constraint. S3
+// reports buckets in us-east-1 with an empty location constraint, and some
+// older buckets in eu-west-1 with the legacy "EU" constraint.
+func normalizeRegion(location string) string {
+	switch location {
+	case "":
+		return "us-east-1"
+	case "EU":
+		return "eu-west-1"
+	default:
+		return location
+	}
+}
+
+func (s *s3Bucket) DetectBucketRegion(ctx context.Context) (string, error) {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"operation":     "detect bucket region",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+	})
+
+	result, err := s.svc.GetBucketLocation(ctx, &s3.GetBucketLocationInput{
+		Bucket: aws.String(s.name),
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "getting bucket location")
+	}
+
+	return normalizeRegion(string(result.LocationConstraint)), nil
+}
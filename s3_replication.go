@@ -0,0 +1,75 @@
+package pail
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
+	"github.com/pkg/errors"
+)
+
+// Replication statuses of objects in buckets with replication configured, as
+// returned by GetReplicationStatus. S3 reports COMPLETED as "COMPLETE" for
+// some requests, so both are returned as ReplicationStatusCompleted.
+const (
+	// ReplicationStatusPending means that the object has not been
+	// replicated to every destination yet.
+	ReplicationStatusPending = string(s3Types.ReplicationStatusPending)
+	// ReplicationStatusCompleted means that the object has been replicated
+	// to every destination.
+	ReplicationStatusCompleted = string(s3Types.ReplicationStatusCompleted)
+	// ReplicationStatusFailed means that replicating the object to at
+	// least one destination failed.
+	ReplicationStatusFailed = string(s3Types.ReplicationStatusFailed)
+	// ReplicationStatusReplica means that the object is itself a replica
+	// of an object in another bucket.
+	ReplicationStatusReplica = string(s3Types.ReplicationStatusReplica)
+)
+
+// ReplicationStatusBucket is implemented by buckets that can report whether
+// objects have been replicated to other buckets.
+type ReplicationStatusBucket interface {
+	// GetReplicationStatus returns the replication status of the object
+	// with the given key: one of ReplicationStatusPending,
+	// ReplicationStatusCompleted, ReplicationStatusFailed or
+	// ReplicationStatusReplica, or the empty string if the object is not
+	// subject to replication.
+	GetReplicationStatus(ctx context.Context, key string) (string, error)
+}
+
+func (s *s3Bucket) GetReplicationStatus(ctx context.Context, key string) (string, error) {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"operation":     "get replication status",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+		"key":           key,
+	})
+
+	release, err := s.acquireMetadataSlot(ctx)
+	if err != nil {
+		return "", err
+	}
+	defer release()
+
+	head, err := s.svc.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.name),
+		Key:    aws.String(s.normalizeKey(key)),
+	})
+	if err != nil {
+		var apiErr smithy.APIError
+		if errors.As(err, &apiErr) && apiErr.ErrorCode() == "NotFound" {
+			return "", MakeKeyNotFoundError(err)
+		}
+		return "", errors.Wrap(err, "getting S3 head object")
+	}
+
+	if head.ReplicationStatus == s3Types.ReplicationStatusComplete {
+		return ReplicationStatusCompleted, nil
+	}
+	return string(head.ReplicationStatus), nil
+}
@@ -0,0 +1,133 @@
+package pail
+
+import (
+	"context"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/aws/retry"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+)
+
+// retryPolicyClient wraps an S3 client so that read and write operations
+// use separate maximum numbers of attempts. Reads are idempotent and can be
+// retried aggressively, whereas retrying some writes, such as completing a
+// multipart upload, can fail or waste work.
+type retryPolicyClient struct {
+	s3Client
+	// readAttempts and writeAttempts are the maximum number of attempts
+	// for read and write operations, or 0 to use the client's default.
+	readAttempts  int
+	writeAttempts int
+}
+
+// withMaxAttempts returns the given per-operation options with an option that
+// sets the maximum number of attempts, if it is not 0.
+func withMaxAttempts(maxAttempts int, optFns []func(*s3.Options)) []func(*s3.Options) {
+	if maxAttempts == 0 {
+		return optFns
+	}
+
+	return append(optFns, func(o *s3.Options) {
+		var retryer aws.Retryer = retry.NewStandard()
+		if o.Retryer != nil {
+			retryer = o.Retryer
+		}
+		o.Retryer = retry.AddWithMaxAttempts(retryer, maxAttempts)
+	})
+}
+
+func (c *retryPolicyClient) HeadBucket(ctx context.Context, input *s3.HeadBucketInput, optFns ...func(*s3.Options)) (*s3.HeadBucketOutput, error) {
+	return c.s3Client.HeadBucket(ctx, input, withMaxAttempts(c.readAttempts, optFns)...)
+}
+
+func (c *retryPolicyClient) GetBucketLocation(ctx context.Context, input *s3.GetBucketLocationInput, optFns ...func(*s3.Options)) (*s3.GetBucketLocationOutput, error) {
+	return c.s3Client.GetBucketLocation(ctx, input, withMaxAttempts(c.readAttempts, optFns)...)
+}
+
+func (c *retryPolicyClient) HeadObject(ctx context.Context, input *s3.HeadObjectInput, optFns ...func(*s3.Options)) (*s3.HeadObjectOutput, error) {
+	return c.s3Client.HeadObject(ctx, input, withMaxAttempts(c.readAttempts, optFns)...)
+}
+
+func (c *retryPolicyClient) GetObject(ctx context.Context, input *s3.GetObjectInput, optFns ...func(*s3.Options)) (*s3.GetObjectOutput, error) {
+	return c.s3Client.GetObject(ctx, input, withMaxAttempts(c.readAttempts, optFns)...)
+}
+
+func (c *retryPolicyClient) GetObjectAcl(ctx context.Context, input *s3.GetObjectAclInput, optFns ...func(*s3.Options)) (*s3.GetObjectAclOutput, error) {
+	return c.s3Client.GetObjectAcl(ctx, input, withMaxAttempts(c.readAttempts, optFns)...)
+}
+
+func (c *retryPolicyClient) GetObjectAttributes(ctx context.Context, input *s3.GetObjectAttributesInput, optFns ...func(*s3.Options)) (*s3.GetObjectAttributesOutput, error) {
+	return c.s3Client.GetObjectAttributes(ctx, input, withMaxAttempts(c.readAttempts, optFns)...)
+}
+
+func (c *retryPolicyClient) ListObjects(ctx context.Context, input *s3.ListObjectsInput, optFns ...func(*s3.Options)) (*s3.ListObjectsOutput, error) {
+	return c.s3Client.ListObjects(ctx, input, withMaxAttempts(c.readAttempts, optFns)...)
+}
+
+func (c *retryPolicyClient) GetObjectLegalHold(ctx context.Context, input *s3.GetObjectLegalHoldInput, optFns ...func(*s3.Options)) (*s3.GetObjectLegalHoldOutput, error) {
+	return c.s3Client.GetObjectLegalHold(ctx, input, withMaxAttempts(c.readAttempts, optFns)...)
+}
+
+func (c *retryPolicyClient) GetObjectRetention(ctx context.Context, input *s3.GetObjectRetentionInput, optFns ...func(*s3.Options)) (*s3.GetObjectRetentionOutput, error) {
+	return c.s3Client.GetObjectRetention(ctx, input, withMaxAttempts(c.readAttempts, optFns)...)
+}
+
+func (c *retryPolicyClient) GetObjectLockConfiguration(ctx context.Context, input *s3.GetObjectLockConfigurationInput, optFns ...func(*s3.Options)) (*s3.GetObjectLockConfigurationOutput, error) {
+	return c.s3Client.GetObjectLockConfiguration(ctx, input, withMaxAttempts(c.readAttempts, optFns)...)
+}
+
+func (c *retryPolicyClient) GetBucketLifecycleConfiguration(ctx context.Context, input *s3.GetBucketLifecycleConfigurationInput, optFns ...func(*s3.Options)) (*s3.GetBucketLifecycleConfigurationOutput, error) {
+	return c.s3Client.GetBucketLifecycleConfiguration(ctx, input, withMaxAttempts(c.readAttempts, optFns)...)
+}
+
+func (c *retryPolicyClient) ListObjectVersions(ctx context.Context, input *s3.ListObjectVersionsInput, optFns ...func(*s3.Options)) (*s3.ListObjectVersionsOutput, error) {
+	return c.s3Client.ListObjectVersions(ctx, input, withMaxAttempts(c.readAttempts, optFns)...)
+}
+
+func (c *retryPolicyClient) ListMultipartUploads(ctx context.Context, input *s3.ListMultipartUploadsInput, optFns ...func(*s3.Options)) (*s3.ListMultipartUploadsOutput, error) {
+	return c.s3Client.ListMultipartUploads(ctx, input, withMaxAttempts(c.readAttempts, optFns)...)
+}
+
+func (c *retryPolicyClient) PutObject(ctx context.Context, input *s3.PutObjectInput, optFns ...func(*s3.Options)) (*s3.PutObjectOutput, error) {
+	return c.s3Client.PutObject(ctx, input, withMaxAttempts(c.writeAttempts, optFns)...)
+}
+
+func (c *retryPolicyClient) PutBucketLifecycleConfiguration(ctx context.Context, input *s3.PutBucketLifecycleConfigurationInput, optFns ...func(*s3.Options)) (*s3.PutBucketLifecycleConfigurationOutput, error) {
+	return c.s3Client.PutBucketLifecycleConfiguration(ctx, input, withMaxAttempts(c.writeAttempts, optFns)...)
+}
+
+func (c *retryPolicyClient) CopyObject(ctx context.Context, input *s3.CopyObjectInput, optFns ...func(*s3.Options)) (*s3.CopyObjectOutput, error) {
+	return c.s3Client.CopyObject(ctx, input, withMaxAttempts(c.writeAttempts, optFns)...)
+}
+
+func (c *retryPolicyClient) GetObjectTagging(ctx context.Context, input *s3.GetObjectTaggingInput, optFns ...func(*s3.Options)) (*s3.GetObjectTaggingOutput, error) {
+	return c.s3Client.GetObjectTagging(ctx, input, withMaxAttempts(c.readAttempts, optFns)...)
+}
+
+func (c *retryPolicyClient) DeleteObject(ctx context.Context, input *s3.DeleteObjectInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectOutput, error) {
+	return c.s3Client.DeleteObject(ctx, input, withMaxAttempts(c.writeAttempts, optFns)...)
+}
+
+func (c *retryPolicyClient) DeleteObjects(ctx context.Context, input *s3.DeleteObjectsInput, optFns ...func(*s3.Options)) (*s3.DeleteObjectsOutput, error) {
+	return c.s3Client.DeleteObjects(ctx, input, withMaxAttempts(c.writeAttempts, optFns)...)
+}
+
+func (c *retryPolicyClient) CreateMultipartUpload(ctx context.Context, input *s3.CreateMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CreateMultipartUploadOutput, error) {
+	return c.s3Client.CreateMultipartUpload(ctx, input, withMaxAttempts(c.writeAttempts, optFns)...)
+}
+
+func (c *retryPolicyClient) UploadPart(ctx context.Context, input *s3.UploadPartInput, optFns ...func(*s3.Options)) (*s3.UploadPartOutput, error) {
+	return c.s3Client.UploadPart(ctx, input, withMaxAttempts(c.writeAttempts, optFns)...)
+}
+
+func (c *retryPolicyClient) UploadPartCopy(ctx context.Context, input *s3.UploadPartCopyInput, optFns ...func(*s3.Options)) (*s3.UploadPartCopyOutput, error) {
+	return c.s3Client.UploadPartCopy(ctx, input, withMaxAttempts(c.writeAttempts, optFns)...)
+}
+
+func (c *retryPolicyClient) CompleteMultipartUpload(ctx context.Context, input *s3.CompleteMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.CompleteMultipartUploadOutput, error) {
+	return c.s3Client.CompleteMultipartUpload(ctx, input, withMaxAttempts(c.writeAttempts, optFns)...)
+}
+
+func (c *retryPolicyClient) AbortMultipartUpload(ctx context.Context, input *s3.AbortMultipartUploadInput, optFns ...func(*s3.Options)) (*s3.AbortMultipartUploadOutput, error) {
+	return c.s3Client.AbortMultipartUpload(ctx, input, withMaxAttempts(c.writeAttempts, optFns)...)
+}
@@ -0,0 +1,140 @@
+package pail
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
+	"github.com/pkg/errors"
+)
+
+// StorageClassBucket is implemented by buckets whose objects can be moved
+// between storage classes.
+type StorageClassBucket interface {
+	// ChangeStorageClass moves every object with the given prefix that is
+	// not already in the target storage class to it, using the given
+	// number of concurrent workers. Objects that fail to move do not stop
+	// the others, and all of the failures are returned together. Archived
+	// objects that must be restored before they can be copied are skipped
+	// and reported with an *ArchivedObjectsError.
+	ChangeStorageClass(ctx context.Context, prefix, target string, workers int) error
+}
+
+// validateStorageClass checks that the storage class is one that objects can
+// be stored in.
+func validateStorageClass(storageClass string) error {
+	for _, valid := range s3Types.StorageClass("").Values() {
+		if storageClass == string(valid) {
+			return nil
+		}
+	}
+	return errors.Errorf("unsupported storage class '%s'", storageClass)
+}
+
+func (s *s3Bucket) ChangeStorageClass(ctx context.Context, prefix, target string, workers int) error {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"dry_run":       s.dryRun,
+		"operation":     "change storage class",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+		"prefix":        prefix,
+		"storage_class": target,
+		"workers":       workers,
+	})
+
+	if err := validateStorageClass(target); err != nil {
+		return errors.WithStack(err)
+	}
+	mu := &sync.Mutex{}
+	archived := &ArchivedObjectsError{}
+	err := s.forEachObject(ctx, prefix, workers, func(ctx context.Context, obj s3Types.Object) error {
+		// Some S3-compatible services omit the storage class of objects
+		// in the standard storage class.
+		storageClass := string(obj.StorageClass)
+		if storageClass == "" {
+			storageClass = string(s3Types.StorageClassStandard)
+		}
+		if storageClass == target || s.dryRun {
+			return nil
+		}
+
+		key := aws.ToString(obj.Key)
+		release, err := s.acquireMetadataSlot(ctx)
+		if err != nil {
+			return err
+		}
+		head, err := s.svc.HeadObject(ctx, &s3.HeadObjectInput{
+			Bucket: aws.String(s.name),
+			Key:    aws.String(key),
+		})
+		release()
+		if err != nil {
+			return errors.Wrapf(err, "getting metadata of key '%s'", s.denormalizeKey(key))
+		}
+		if needsRestore(head) {
+			mu.Lock()
+			archived.Keys = append(archived.Keys, s.denormalizeKey(key))
+			mu.Unlock()
+			return nil
+		}
+
+		// Copying an object onto itself is allowed when its storage
+		// class changes. The copy keeps the object's own encryption
+		// rather than applying the bucket's.
+		input := s.copyInPlaceInput(key, head)
+		input.StorageClass = s3Types.StorageClass(target)
+		return errors.Wrapf(s.copyInPlace(ctx, input, aws.ToInt64(head.ContentLength)), "changing storage class of key '%s'", s.denormalizeKey(key))
+	})
+	if len(archived.Keys) == 0 {
+		return err
+	}
+
+	grip.Warning(message.Fields{
+		"message":          "could not change storage class of archived objects that are not restored",
+		"bucket":           s.name,
+		"prefix":           prefix,
+		"archived_objects": len(archived.Keys),
+	})
+	if err != nil {
+		catcher := grip.NewBasicCatcher()
+		catcher.Add(err)
+		catcher.Add(archived)
+		return catcher.Resolve()
+	}
+	return archived
+}
+
+// ArchivedObjectsError is returned when objects could not be copied because
+// they are archived and have not been restored. It matches ErrObjectArchived.
+type ArchivedObjectsError struct {
+	Keys []string
+}
+
+func (e *ArchivedObjectsError) Error() string {
+	descriptions := make([]string, 0, len(e.Keys))
+	for _, key := range e.Keys {
+		descriptions = append(descriptions, fmt.Sprintf("'%s'", key))
+	}
+
+	return fmt.Sprintf("%s: %s", ErrObjectArchived, strings.Join(descriptions, ", "))
+}
+
+func (e *ArchivedObjectsError) Is(target error) bool { return target == ErrObjectArchived }
+
+// needsRestore returns whether the object is archived in a storage class that
+// cannot be read, and so cannot be copied, until it is restored.
+func needsRestore(head *s3.HeadObjectOutput) bool {
+	switch head.StorageClass {
+	case s3Types.StorageClassGlacier, s3Types.StorageClassDeepArchive:
+		return !strings.Contains(aws.ToString(head.Restore), `ongoing-request="false"`)
+	default:
+		return false
+	}
+}
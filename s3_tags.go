@@ -0,0 +1,39 @@
+package pail
+
+import (
+	"net/url"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	// s3MaxObjectTags is the maximum number of tags on an S3 object.
+	s3MaxObjectTags = 10
+	// s3MaxTagKeyLength and s3MaxTagValueLength are the maximum lengths,
+	// in characters, of the keys and values of S3 object tags.
+	s3MaxTagKeyLength   = 128
+	s3MaxTagValueLength = 256
+)
+
+// encodeObjectTags validates the tags against S3's limits and returns them
+// encoded as URL query parameters, as S3 expects them in write requests. It
+// returns an empty string if there are no tags.
+func encodeObjectTags(tags map[string]string) (string, error) {
+	if len(tags) > s3MaxObjectTags {
+		return "", errors.Errorf("cannot set more than %d tags", s3MaxObjectTags)
+	}
+
+	values := url.Values{}
+	for k, v := range tags {
+		if k == "" || utf8.RuneCountInString(k) > s3MaxTagKeyLength {
+			return "", errors.Errorf("tag key '%s' must be between 1 and %d characters", k, s3MaxTagKeyLength)
+		}
+		if utf8.RuneCountInString(v) > s3MaxTagValueLength {
+			return "", errors.Errorf("value of tag '%s' cannot exceed %d characters", k, s3MaxTagValueLength)
+		}
+		values.Set(k, v)
+	}
+
+	return values.Encode(), nil
+}
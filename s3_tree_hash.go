@@ -0,0 +1,107 @@
+package pail
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+)
+
+const (
+	// treeHashMetadataKey is the object metadata key that stores the
+	// SHA-256 tree hash of objects uploaded by buckets that compute it.
+	treeHashMetadataKey = "sha256-tree-hash"
+	// treeHashChunkSize is the size of the chunks whose SHA-256 digests are
+	// the leaves of a tree hash.
+	treeHashChunkSize = 1024 * 1024
+	// maxCopyObjectSize is the size of the largest object that S3 can copy
+	// in a single CopyObject request.
+	maxCopyObjectSize = 5 * 1024 * 1024 * 1024
+)
+
+// treeHasher computes the SHA-256 tree hash that Amazon S3 Glacier uses to
+// verify archives of the data written to it. The data is hashed in 1 MiB
+// chunks, and adjacent pairs of digests are hashed together, level by level,
+// until a single digest remains; a digest without a pair is carried up to the
+// next level unchanged.
+//
+// Rather than keeping every chunk's digest, the hasher combines digests as
+// soon as their pair is complete, so it only keeps one digest per level of the
+// tree.
+type treeHasher struct {
+	chunk []byte
+	// levels are the digests of the completed subtrees, from the largest
+	// to the smallest. Each subtree is at least twice as large as the
+	// next, so combining them from the smallest up yields the same tree as
+	// pairing the chunks level by level.
+	levels []treeHashLevel
+	// written is whether any data has been written.
+	written bool
+}
+
+type treeHashLevel struct {
+	height int
+	digest [sha256.Size]byte
+}
+
+func newTreeHasher() *treeHasher {
+	return &treeHasher{chunk: make([]byte, 0, treeHashChunkSize)}
+}
+
+func (h *treeHasher) Write(p []byte) (int, error) {
+	n := len(p)
+	h.written = h.written || n > 0
+	for len(p) > 0 {
+		take := treeHashChunkSize - len(h.chunk)
+		if take > len(p) {
+			take = len(p)
+		}
+		h.chunk = append(h.chunk, p[:take]...)
+		p = p[take:]
+		if len(h.chunk) == treeHashChunkSize {
+			h.addChunk()
+		}
+	}
+
+	return n, nil
+}
+
+// addChunk adds the digest of the current chunk to the tree, combining it
+// with the completed subtrees of the same height.
+func (h *treeHasher) addChunk() {
+	level := treeHashLevel{digest: sha256.Sum256(h.chunk)}
+	h.chunk = h.chunk[:0]
+	for len(h.levels) > 0 && h.levels[len(h.levels)-1].height == level.height {
+		last := h.levels[len(h.levels)-1]
+		h.levels = h.levels[:len(h.levels)-1]
+		level = treeHashLevel{height: level.height + 1, digest: combineTreeHashes(last.digest, level.digest)}
+	}
+	h.levels = append(h.levels, level)
+}
+
+// Sum returns the hex-encoded tree hash of the data written so far. The tree
+// hash of no data is the SHA-256 digest of no data.
+func (h *treeHasher) Sum() string {
+	levels := h.levels
+	if len(h.chunk) > 0 || !h.written {
+		levels = append(levels[:len(levels):len(levels)], treeHashLevel{digest: sha256.Sum256(h.chunk)})
+	}
+
+	digest := levels[len(levels)-1].digest
+	for i := len(levels) - 2; i >= 0; i-- {
+		digest = combineTreeHashes(levels[i].digest, digest)
+	}
+	return hex.EncodeToString(digest[:])
+}
+
+func combineTreeHashes(left, right [sha256.Size]byte) [sha256.Size]byte {
+	return sha256.Sum256(append(left[:], right[:]...))
+}
+
+// withTreeHash returns a copy of the metadata with the given tree hash.
+func withTreeHash(metadata map[string]string, treeHash string) map[string]string {
+	out := make(map[string]string, len(metadata)+1)
+	for k, v := range metadata {
+		out[k] = v
+	}
+	out[treeHashMetadataKey] = treeHash
+	return out
+}
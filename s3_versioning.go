@@ -0,0 +1,272 @@
+package pail
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/aws/smithy-go"
+	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
+	"github.com/pkg/errors"
+)
+
+// VersionedBucket is implemented by buckets that support object versioning.
+type VersionedBucket interface {
+	// ListDeleteMarkers returns all delete markers with the given prefix.
+	ListDeleteMarkers(context.Context, string) ([]DeleteMarker, error)
+
+	// PurgeDeleteMarkers permanently removes all delete markers with the
+	// given prefix, continuing on error and returning any accumulated
+	// errors. Note that removing the latest delete marker of a key
+	// restores the key's most recent version, if there is one.
+	PurgeDeleteMarkers(context.Context, string) error
+
+	// EmptyPrefix removes the objects with the given prefix, and their
+	// versions and delete markers as configured by the options. It
+	// continues past objects that are protected by Object Lock, returning
+	// a *LockedObjectsError that lists them once it has removed the
+	// others.
+	EmptyPrefix(context.Context, string, EmptyOptions) error
+}
+
+// EmptyOptions configures what EmptyPrefix removes in addition to the
+// current versions of objects.
+type EmptyOptions struct {
+	// NoncurrentVersions permanently removes every version of the
+	// objects. Otherwise, removing an object from a versioned bucket only
+	// hides its versions behind a delete marker.
+	NoncurrentVersions bool
+	// DeleteMarkers removes the delete markers with the prefix. It
+	// requires NoncurrentVersions, since removing the latest delete marker
+	// of a key would otherwise restore its most recent version.
+	DeleteMarkers bool
+}
+
+// ObjectVersion identifies a version of an object. The version ID is empty
+// for the current version of an object.
+type ObjectVersion struct {
+	Key       string
+	VersionID string
+}
+
+// LockedObjectsError is returned when objects could not be removed because
+// they are protected by Object Lock. It matches ErrObjectLocked.
+type LockedObjectsError struct {
+	Objects []ObjectVersion
+}
+
+func (e *LockedObjectsError) Error() string {
+	descriptions := make([]string, 0, len(e.Objects))
+	for _, obj := range e.Objects {
+		if obj.VersionID == "" {
+			descriptions = append(descriptions, fmt.Sprintf("'%s'", obj.Key))
+		} else {
+			descriptions = append(descriptions, fmt.Sprintf("'%s' (version '%s')", obj.Key, obj.VersionID))
+		}
+	}
+
+	return fmt.Sprintf("%s: %s", ErrObjectLocked, strings.Join(descriptions, ", "))
+}
+
+func (e *LockedObjectsError) Is(target error) bool { return target == ErrObjectLocked }
+
+// DeleteMarker describes a delete marker in a versioned bucket.
+type DeleteMarker struct {
+	Key          string
+	VersionID    string
+	IsLatest     bool
+	LastModified time.Time
+}
+
+func (s *s3Bucket) ListDeleteMarkers(ctx context.Context, prefix string) ([]DeleteMarker, error) {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"operation":     "list delete markers",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+		"prefix":        prefix,
+	})
+
+	return s.listDeleteMarkers(ctx, prefix)
+}
+
+func (s *s3Bucket) PurgeDeleteMarkers(ctx context.Context, prefix string) error {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":          "s3",
+		"dry_run":       s.dryRun,
+		"operation":     "purge delete markers",
+		"bucket":        s.name,
+		"bucket_prefix": s.prefix,
+		"prefix":        prefix,
+	})
+
+	markers, err := s.listDeleteMarkers(ctx, prefix)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+	if s.dryRun {
+		return nil
+	}
+
+	catcher := grip.NewBasicCatcher()
+	for _, marker := range markers {
+		_, err := s.svc.DeleteObject(ctx, &s3.DeleteObjectInput{
+			Bucket:    aws.String(s.name),
+			Key:       aws.String(s.normalizeKey(marker.Key)),
+			VersionId: aws.String(marker.VersionID),
+		})
+		catcher.Wrapf(err, "removing delete marker '%s' for key '%s'", marker.VersionID, marker.Key)
+	}
+
+	return catcher.Resolve()
+}
+
+func (s *s3Bucket) EmptyPrefix(ctx context.Context, prefix string, opts EmptyOptions) error {
+	grip.DebugWhen(s.verbose, message.Fields{
+		"type":                "s3",
+		"dry_run":             s.dryRun,
+		"operation":           "empty prefix",
+		"bucket":              s.name,
+		"bucket_prefix":       s.prefix,
+		"prefix":              prefix,
+		"noncurrent_versions": opts.NoncurrentVersions,
+		"delete_markers":      opts.DeleteMarkers,
+	})
+
+	if opts.DeleteMarkers && !opts.NoncurrentVersions {
+		return errors.New("removing delete markers requires removing noncurrent versions")
+	}
+
+	// Each page of the listing is removed before the next is listed, so
+	// the listing is never held in memory.
+	catcher := grip.NewBasicCatcher()
+	locked := &LockedObjectsError{}
+	var err error
+	if opts.NoncurrentVersions {
+		err = s.forEachVersionPage(ctx, prefix, func(result *s3.ListObjectVersionsOutput) {
+			var toRemove []s3Types.ObjectIdentifier
+			for _, version := range result.Versions {
+				toRemove = append(toRemove, s3Types.ObjectIdentifier{Key: version.Key, VersionId: version.VersionId})
+			}
+			if opts.DeleteMarkers {
+				for _, marker := range result.DeleteMarkers {
+					toRemove = append(toRemove, s3Types.ObjectIdentifier{Key: marker.Key, VersionId: marker.VersionId})
+				}
+			}
+			s.deleteVersions(ctx, toRemove, locked, catcher)
+		})
+	} else {
+		err = s.forEachObjectPage(ctx, prefix, func(contents []s3Types.Object) error {
+			toRemove := make([]s3Types.ObjectIdentifier, 0, len(contents))
+			for _, obj := range contents {
+				toRemove = append(toRemove, s3Types.ObjectIdentifier{Key: obj.Key})
+			}
+			s.deleteVersions(ctx, toRemove, locked, catcher)
+			return nil
+		})
+	}
+	catcher.Add(err)
+	if len(locked.Objects) == 0 {
+		return catcher.Resolve()
+	}
+
+	grip.Warning(message.Fields{
+		"message":        "could not remove objects protected by object lock",
+		"bucket":         s.name,
+		"prefix":         prefix,
+		"locked_objects": len(locked.Objects),
+	})
+	if catcher.HasErrors() {
+		catcher.Add(locked)
+		return catcher.Resolve()
+	}
+	return locked
+}
+
+// deleteVersions removes the objects, which are identified by their
+// normalized keys, in batches, adding the objects that are protected by Object
+// Lock to the error and any other failures to the catcher. It does nothing in
+// a dry run.
+func (s *s3Bucket) deleteVersions(ctx context.Context, objects []s3Types.ObjectIdentifier, locked *LockedObjectsError, catcher grip.Catcher) {
+	if s.dryRun {
+		return
+	}
+
+	for len(objects) > 0 {
+		batch := objects
+		if len(batch) > s.batchSize {
+			batch = batch[:s.batchSize]
+		}
+		objects = objects[len(batch):]
+
+		result, err := s.svc.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(s.name),
+			Delete: &s3Types.Delete{Objects: batch, Quiet: aws.Bool(true)},
+		})
+		if err != nil {
+			catcher.Wrap(err, "removing objects")
+			continue
+		}
+		for _, failed := range result.Errors {
+			obj := ObjectVersion{Key: s.denormalizeKey(aws.ToString(failed.Key)), VersionID: aws.ToString(failed.VersionId)}
+			if err = deleteObjectsError(failed); isObjectLockError(err) {
+				locked.Objects = append(locked.Objects, obj)
+				continue
+			}
+			catcher.Wrapf(err, "removing version '%s' of key '%s'", obj.VersionID, obj.Key)
+		}
+	}
+}
+
+// deleteObjectsError returns the error that S3 reported for an object that a
+// DeleteObjects request failed to remove.
+func deleteObjectsError(failed s3Types.Error) error {
+	return &smithy.GenericAPIError{Code: aws.ToString(failed.Code), Message: aws.ToString(failed.Message)}
+}
+
+func (s *s3Bucket) listDeleteMarkers(ctx context.Context, prefix string) ([]DeleteMarker, error) {
+	var markers []DeleteMarker
+	err := s.forEachVersionPage(ctx, prefix, func(result *s3.ListObjectVersionsOutput) {
+		for _, marker := range result.DeleteMarkers {
+			markers = append(markers, DeleteMarker{
+				Key:          s.denormalizeKey(aws.ToString(marker.Key)),
+				VersionID:    aws.ToString(marker.VersionId),
+				IsLatest:     aws.ToBool(marker.IsLatest),
+				LastModified: aws.ToTime(marker.LastModified),
+			})
+		}
+	})
+	if err != nil {
+		return nil, errors.WithStack(err)
+	}
+
+	return markers, nil
+}
+
+// forEachVersionPage calls the function with each page of the versions and
+// delete markers with the given prefix.
+func (s *s3Bucket) forEachVersionPage(ctx context.Context, prefix string, fn func(*s3.ListObjectVersionsOutput)) error {
+	input := &s3.ListObjectVersionsInput{
+		Bucket: aws.String(s.name),
+		Prefix: aws.String(s.normalizeListPrefix(prefix)),
+	}
+
+	for {
+		result, err := s.svc.ListObjectVersions(ctx, input)
+		if err != nil {
+			return errors.Wrap(err, "listing object versions")
+		}
+		fn(result)
+		if !aws.ToBool(result.IsTruncated) {
+			return nil
+		}
+
+		input.KeyMarker = result.NextKeyMarker
+		input.VersionIdMarker = result.NextVersionIdMarker
+	}
+}
@@ -0,0 +1,105 @@
+package pail
+
+import (
+	"io"
+
+	"github.com/pkg/errors"
+)
+
+// rangeReadSeeker implements seeking over an object whose contents can only
+// be read sequentially, by reopening the object at the new offset the next
+// time it is read after a seek.
+type rangeReadSeeker struct {
+	size   int64
+	offset int64
+	// open returns a reader for the object starting at the given offset,
+	// which is always less than size.
+	open func(offset int64) (io.ReadCloser, error)
+	r    io.ReadCloser
+}
+
+// newRangeReadSeeker returns a seeker over an object of the given size. If
+// initial is not nil, it is used to read the object from its start until the
+// first seek.
+func newRangeReadSeeker(size int64, initial io.ReadCloser, open func(offset int64) (io.ReadCloser, error)) *rangeReadSeeker {
+	return &rangeReadSeeker{size: size, r: initial, open: open}
+}
+
+func (s *rangeReadSeeker) Read(p []byte) (int, error) {
+	if s.offset >= s.size {
+		return 0, io.EOF
+	}
+	if s.r == nil {
+		r, err := s.open(s.offset)
+		if err != nil {
+			return 0, errors.Wrapf(err, "opening object at offset %d", s.offset)
+		}
+		s.r = r
+	}
+
+	n, err := s.r.Read(p)
+	s.offset += int64(n)
+	return n, err
+}
+
+func (s *rangeReadSeeker) Seek(offset int64, whence int) (int64, error) {
+	var abs int64
+	switch whence {
+	case io.SeekStart:
+		abs = offset
+	case io.SeekCurrent:
+		abs = s.offset + offset
+	case io.SeekEnd:
+		abs = s.size + offset
+	default:
+		return 0, errors.Errorf("invalid whence %d", whence)
+	}
+	if abs < 0 {
+		return 0, errors.Errorf("cannot seek to negative offset %d", abs)
+	}
+
+	if abs != s.offset && s.r != nil {
+		err := s.r.Close()
+		s.r = nil
+		if err != nil {
+			return 0, errors.Wrap(err, "closing reader")
+		}
+	}
+	s.offset = abs
+
+	return abs, nil
+}
+
+func (s *rangeReadSeeker) Close() error {
+	if s.r == nil {
+		return nil
+	}
+	err := s.r.Close()
+	s.r = nil
+	return errors.WithStack(err)
+}
+
+// validateRange checks the offset and length of a range read.
+func validateRange(offset, length int64) error {
+	if offset < 0 {
+		return errors.Errorf("cannot read from negative offset %d", offset)
+	}
+	if length <= 0 {
+		return errors.Errorf("range length must be positive, but got %d", length)
+	}
+	return nil
+}
+
+// limitedRangeReadCloser reads at most a fixed number of bytes from a reader,
+// such as a file after seeking to the start of a range, and closes the
+// underlying reader.
+type limitedRangeReadCloser struct {
+	io.Reader
+	closer io.Closer
+}
+
+func newLimitedRangeReadCloser(r io.ReadCloser, length int64) *limitedRangeReadCloser {
+	return &limitedRangeReadCloser{Reader: io.LimitReader(r, length), closer: r}
+}
+
+func (r *limitedRangeReadCloser) Close() error { return r.closer.Close() }
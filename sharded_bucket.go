@@ -0,0 +1,324 @@
+package pail
+
+import (
+	"context"
+	"hash/fnv"
+	"io"
+	"path/filepath"
+	"regexp"
+	"sync"
+	"time"
+
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+)
+
+// NewShardedBucket returns a bucket that spreads objects across the given
+// buckets, for example to exceed the request rate limit of a single S3 bucket.
+// Each object is stored in the shard at the index returned by hashFn for its
+// key, modulo the number of shards; if hashFn is nil, the FNV-1a hash of the
+// key is used. The shards must not be empty, and their order and number must
+// not change while objects are stored in them.
+//
+// Operations on a single key are routed to its shard. List, RemovePrefix and
+// RemoveMatching apply to every shard, and List merges the shards' listings
+// into a single listing in order of the items' keys, provided that each shard
+// lists its own items in order of their keys.
+// Push and Pull transfer each object to or from its own shard, so the shards'
+// own sync behavior, such as deleting objects on sync, does not apply.
+func NewShardedBucket(shards []Bucket, hashFn func(key string) int) Bucket {
+	if hashFn == nil {
+		hashFn = fnvKeyHash
+	}
+
+	return &shardedBucket{shards: shards, hash: hashFn}
+}
+
+type shardedBucket struct {
+	shards []Bucket
+	hash   func(string) int
+}
+
+func fnvKeyHash(key string) int {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return int(h.Sum32())
+}
+
+// shardIndex returns the index of the shard that stores the given key.
+func (s *shardedBucket) shardIndex(key string) int {
+	n := len(s.shards)
+	return ((s.hash(key) % n) + n) % n
+}
+
+func (s *shardedBucket) shard(key string) Bucket { return s.shards[s.shardIndex(key)] }
+
+// groupByShard returns the given keys grouped by the index of their shard.
+func (s *shardedBucket) groupByShard(keys []string) map[int][]string {
+	groups := map[int][]string{}
+	for _, key := range keys {
+		idx := s.shardIndex(key)
+		groups[idx] = append(groups[idx], key)
+	}
+	return groups
+}
+
+// forEachShard runs the operation on every shard concurrently, returning the
+// accumulated errors.
+func (s *shardedBucket) forEachShard(op func(idx int, shard Bucket) error) error {
+	catcher := grip.NewBasicCatcher()
+	wg := &sync.WaitGroup{}
+	for i, shard := range s.shards {
+		wg.Add(1)
+		go func(i int, shard Bucket) {
+			defer wg.Done()
+			catcher.Wrapf(op(i, shard), "shard %d", i)
+		}(i, shard)
+	}
+	wg.Wait()
+
+	return catcher.Resolve()
+}
+
+func (s *shardedBucket) Check(ctx context.Context) error {
+	if len(s.shards) == 0 {
+		return errors.New("sharded bucket has no shards")
+	}
+
+	return s.forEachShard(func(_ int, shard Bucket) error { return shard.Check(ctx) })
+}
+
+// Ping pings every shard, returning the latency of the slowest.
+func (s *shardedBucket) Ping(ctx context.Context) (time.Duration, error) {
+	start := time.Now()
+	if err := s.forEachShard(func(_ int, shard Bucket) error {
+		_, err := Ping(ctx, shard)
+		return err
+	}); err != nil {
+		return 0, err
+	}
+
+	return time.Since(start), nil
+}
+
+func (s *shardedBucket) Exists(ctx context.Context, key string) (bool, error) {
+	return s.shard(key).Exists(ctx, key)
+}
+func (s *shardedBucket) Join(elems ...string) string { return s.shards[0].Join(elems...) }
+
+// URI returns the URI of the object in the shard that the key belongs to.
+func (s *shardedBucket) URI(key string) string { return s.shard(key).URI(key) }
+
+func (s *shardedBucket) Writer(ctx context.Context, key string) (io.WriteCloser, error) {
+	return s.shard(key).Writer(ctx, key)
+}
+
+func (s *shardedBucket) Reader(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.shard(key).Reader(ctx, key)
+}
+
+func (s *shardedBucket) Put(ctx context.Context, key string, r io.Reader) error {
+	return s.shard(key).Put(ctx, key, r)
+}
+
+func (s *shardedBucket) Get(ctx context.Context, key string) (io.ReadCloser, error) {
+	return s.shard(key).Get(ctx, key)
+}
+
+func (s *shardedBucket) Upload(ctx context.Context, key, path string) error {
+	return s.shard(key).Upload(ctx, key, path)
+}
+
+func (s *shardedBucket) Download(ctx context.Context, key, path string) error {
+	return s.shard(key).Download(ctx, key, path)
+}
+
+func (s *shardedBucket) Push(ctx context.Context, opts SyncOptions) error {
+	var re *regexp.Regexp
+	var err error
+	if opts.Exclude != "" {
+		re, err = regexp.Compile(opts.Exclude)
+		if err != nil {
+			return errors.Wrap(err, "compiling exclude regex")
+		}
+	}
+
+	files, err := walkLocalTree(ctx, opts.Local)
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var completed []string
+	for _, fn := range files {
+		if err = ctx.Err(); err != nil {
+			return partialSyncError(ctx, errors.WithStack(err), completed)
+		}
+		if re != nil && re.MatchString(fn) {
+			continue
+		}
+
+		key := s.Join(opts.Remote, fn)
+		path := filepath.Join(opts.Local, fn)
+		if err = s.Upload(ctx, key, path); err != nil {
+			if skipVanishedFile(opts, path, err) {
+				continue
+			}
+			return partialSyncError(ctx, errors.WithStack(err), completed)
+		}
+		completed = append(completed, key)
+	}
+
+	// The shards' delete on sync options only apply to their own pushes,
+	// so only a mirror deletes objects, which may be in any shard.
+	if opts.Mirror {
+		return errors.Wrap(deleteOnPush(ctx, files, opts.Remote, s), "deleting on sync after push")
+	}
+
+	return nil
+}
+
+func (s *shardedBucket) Pull(ctx context.Context, opts SyncOptions) error {
+	var re *regexp.Regexp
+	var err error
+	if opts.Exclude != "" {
+		re, err = regexp.Compile(opts.Exclude)
+		if err != nil {
+			return errors.Wrap(err, "compiling exclude regex")
+		}
+	}
+
+	iter, err := s.List(ctx, dirPrefix(opts.Remote))
+	if err != nil {
+		return errors.WithStack(err)
+	}
+
+	var completed []string
+	for iter.Next(ctx) {
+		if err = ctx.Err(); err != nil {
+			return partialSyncError(ctx, errors.WithStack(err), completed)
+		}
+		if re != nil && re.MatchString(iter.Item().Name()) {
+			continue
+		}
+
+		name, err := filepath.Rel(opts.Remote, iter.Item().Name())
+		if err != nil {
+			return errors.Wrap(err, "getting relative filepath")
+		}
+		if err = s.Download(ctx, iter.Item().Name(), filepath.Join(opts.Local, name)); err != nil {
+			return partialSyncError(ctx, errors.WithStack(err), completed)
+		}
+		completed = append(completed, iter.Item().Name())
+	}
+
+	return partialSyncError(ctx, errors.Wrap(iter.Err(), "iterating bucket"), completed)
+}
+
+// Copy copies the object within the shards. If the destination bucket is also
+// a sharded bucket, the object is copied to the destination key's shard.
+func (s *shardedBucket) Copy(ctx context.Context, opts CopyOptions) error {
+	if dest, ok := opts.DestinationBucket.(*shardedBucket); ok {
+		opts.DestinationBucket = dest.shard(opts.DestinationKey)
+	}
+
+	return s.shard(opts.SourceKey).Copy(ctx, opts)
+}
+
+func (s *shardedBucket) Remove(ctx context.Context, key string) error {
+	return s.shard(key).Remove(ctx, key)
+}
+
+func (s *shardedBucket) RemoveMany(ctx context.Context, keys ...string) error {
+	groups := s.groupByShard(keys)
+	return s.forEachShard(func(idx int, shard Bucket) error {
+		if len(groups[idx]) == 0 {
+			return nil
+		}
+		return shard.RemoveMany(ctx, groups[idx]...)
+	})
+}
+
+func (s *shardedBucket) RemovePrefix(ctx context.Context, prefix string) error {
+	return s.forEachShard(func(_ int, shard Bucket) error { return shard.RemovePrefix(ctx, prefix) })
+}
+
+func (s *shardedBucket) RemoveMatching(ctx context.Context, expression string) error {
+	return s.forEachShard(func(_ int, shard Bucket) error { return shard.RemoveMatching(ctx, expression) })
+}
+
+// List lists every shard concurrently and returns an iterator that merges the
+// shards' items in order of their keys.
+func (s *shardedBucket) List(ctx context.Context, prefix string) (BucketIterator, error) {
+	iters := make([]BucketIterator, len(s.shards))
+	if err := s.forEachShard(func(idx int, shard Bucket) error {
+		iter, err := shard.List(ctx, prefix)
+		iters[idx] = iter
+		return err
+	}); err != nil {
+		return nil, errors.Wrap(err, "listing shards")
+	}
+
+	return &mergedIterator{iters: iters, heads: make([]BucketItem, len(iters)), current: -1}, nil
+}
+
+// mergedIterator merges iterators that each yield items in order of their keys
+// into a single iterator over all of their items in order of their keys.
+type mergedIterator struct {
+	iters []BucketIterator
+	// heads are the next item of each iterator, or nil if the iterator is
+	// exhausted.
+	heads []BucketItem
+	// current is the index of the iterator whose item was last returned,
+	// which must be advanced before the next item is chosen.
+	current int
+	started bool
+	item    BucketItem
+	err     error
+}
+
+func (iter *mergedIterator) Next(ctx context.Context) bool {
+	if iter.err != nil {
+		return false
+	}
+
+	if !iter.started {
+		iter.started = true
+		for idx := range iter.iters {
+			if !iter.advance(ctx, idx) {
+				return false
+			}
+		}
+	} else if iter.current >= 0 && !iter.advance(ctx, iter.current) {
+		return false
+	}
+
+	iter.current = -1
+	iter.item = nil
+	for idx, head := range iter.heads {
+		if head != nil && (iter.item == nil || head.Name() < iter.item.Name()) {
+			iter.current = idx
+			iter.item = head
+		}
+	}
+	return iter.item != nil
+}
+
+// advance moves the iterator at the given index to its next item, returning
+// false if it failed.
+func (iter *mergedIterator) advance(ctx context.Context, idx int) bool {
+	iter.heads[idx] = nil
+	if iter.iters[idx].Next(ctx) {
+		iter.heads[idx] = iter.iters[idx].Item()
+		return true
+	}
+	if err := iter.iters[idx].Err(); err != nil {
+		iter.err = errors.Wrapf(err, "iterating shard %d", idx)
+		iter.item = nil
+		return false
+	}
+	return true
+}
+
+func (iter *mergedIterator) Err() error { return iter.err }
+
+func (iter *mergedIterator) Item() BucketItem { return iter.item }
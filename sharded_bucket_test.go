@@ -0,0 +1,103 @@
+package pail
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestShardedBucket(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// Route keys by the parity of their length so that the test knows
+	// which shard each key belongs to.
+	byLength := func(key string) int { return len(key) }
+	setup := func(t *testing.T) ([]string, Bucket) {
+		var dirs []string
+		var shards []Bucket
+		for i := 0; i < 2; i++ {
+			dir := t.TempDir()
+			shard, err := NewLocalBucket(LocalOptions{Path: dir})
+			require.NoError(t, err)
+			dirs = append(dirs, dir)
+			shards = append(shards, shard)
+		}
+		return dirs, NewShardedBucket(shards, byLength)
+	}
+	keys := map[string]int{"logs/a": 0, "logs/bb": 1, "logs/cccc": 1, "logs/ddddd": 0}
+
+	t.Run("RoutesKeysDeterministically", func(t *testing.T) {
+		dirs, b := setup(t)
+		for key := range keys {
+			require.NoError(t, b.Put(ctx, key, strings.NewReader(key)))
+		}
+
+		for key, shard := range keys {
+			_, err := os.Stat(filepath.Join(dirs[shard], key))
+			assert.NoError(t, err, key)
+			_, err = os.Stat(filepath.Join(dirs[1-shard], key))
+			assert.True(t, os.IsNotExist(err), key)
+
+			data, err := readDataFromFile(ctx, b, key)
+			require.NoError(t, err)
+			assert.Equal(t, key, data)
+		}
+	})
+	t.Run("ListMergesShards", func(t *testing.T) {
+		_, b := setup(t)
+		for key := range keys {
+			require.NoError(t, b.Put(ctx, key, strings.NewReader(key)))
+		}
+		require.NoError(t, b.Put(ctx, "other/e", strings.NewReader("other")))
+
+		iter, err := b.List(ctx, "logs")
+		require.NoError(t, err)
+		var listed []string
+		for iter.Next(ctx) {
+			listed = append(listed, iter.Item().Name())
+		}
+		require.NoError(t, iter.Err())
+		assert.ElementsMatch(t, []string{"logs/a", "logs/bb", "logs/cccc", "logs/ddddd"}, listed)
+	})
+	t.Run("RemovePrefixRemovesFromAllShards", func(t *testing.T) {
+		_, b := setup(t)
+		for key := range keys {
+			require.NoError(t, b.Put(ctx, key, strings.NewReader(key)))
+		}
+		require.NoError(t, b.Put(ctx, "other/e", strings.NewReader("other")))
+
+		require.NoError(t, b.RemovePrefix(ctx, "logs"))
+		count, err := Count(ctx, b, "")
+		require.NoError(t, err)
+		assert.Equal(t, 1, count)
+	})
+	t.Run("PushAndPullRouteEachFile", func(t *testing.T) {
+		dirs, b := setup(t)
+		local := t.TempDir()
+		for key := range keys {
+			require.NoError(t, os.MkdirAll(filepath.Dir(filepath.Join(local, key)), 0700))
+			require.NoError(t, os.WriteFile(filepath.Join(local, key), []byte(key), 0600))
+		}
+
+		require.NoError(t, b.Push(ctx, SyncOptions{Local: local, Remote: "remote"}))
+		for key := range keys {
+			remoteKey := filepath.Join("remote", key)
+			_, err := os.Stat(filepath.Join(dirs[len(remoteKey)%2], remoteKey))
+			assert.NoError(t, err, remoteKey)
+		}
+
+		pulled := t.TempDir()
+		require.NoError(t, b.Pull(ctx, SyncOptions{Local: pulled, Remote: "remote"}))
+		for key := range keys {
+			data, err := os.ReadFile(filepath.Join(pulled, key))
+			require.NoError(t, err)
+			assert.Equal(t, key, string(data))
+		}
+	})
+}
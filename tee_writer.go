@@ -0,0 +1,109 @@
+package pail
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+
+	"github.com/mongodb/grip"
+	"github.com/pkg/errors"
+)
+
+// TeeWriter returns a writer that writes the data written to it both to the
+// given key in the bucket and to the file at localPath, so that a single pass
+// over the data uploads it and keeps a local copy, for example for a cache.
+//
+// The local file is written to a temporary file in the same directory, which
+// replaces localPath only once the upload succeeds. If writing either copy
+// fails, or the context is canceled, Close discards the upload, as described
+// for Bucket's Writer, and removes the partial local file.
+func TeeWriter(ctx context.Context, b Bucket, key, localPath string) (io.WriteCloser, error) {
+	dir := filepath.Dir(localPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, errors.Wrapf(err, "creating directory '%s'", dir)
+	}
+	local, err := os.CreateTemp(dir, filepath.Base(localPath)+".tmp")
+	if err != nil {
+		return nil, errors.Wrapf(err, "creating temporary file for '%s'", localPath)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	remote, err := b.Writer(ctx, key)
+	if err != nil {
+		cancel()
+		catcher := grip.NewBasicCatcher()
+		catcher.Wrapf(err, "getting writer for key '%s'", key)
+		catcher.Add(local.Close())
+		catcher.Add(os.Remove(local.Name()))
+		return nil, catcher.Resolve()
+	}
+
+	return &teeWriteCloser{remote: remote, local: local, path: localPath, cancel: cancel}, nil
+}
+
+type teeWriteCloser struct {
+	remote io.WriteCloser
+	local  *os.File
+	path   string
+	cancel context.CancelFunc
+	// err is the first error that occurred while writing, after which the
+	// writer discards both copies.
+	err    error
+	closed bool
+}
+
+func (w *teeWriteCloser) Write(p []byte) (int, error) {
+	if w.closed {
+		return 0, errors.New("writer already closed")
+	}
+	if w.err != nil {
+		return 0, w.err
+	}
+
+	if _, err := w.local.Write(p); err != nil {
+		w.err = errors.Wrapf(err, "writing local file '%s'", w.path)
+		return 0, w.err
+	}
+	n, err := w.remote.Write(p)
+	if err != nil {
+		w.err = errors.Wrap(err, "writing to bucket")
+	}
+	return n, w.err
+}
+
+// Close completes the upload and moves the local file into place, or discards
+// both if writing either failed.
+func (w *teeWriteCloser) Close() error {
+	if w.closed {
+		return errors.New("writer already closed")
+	}
+	w.closed = true
+	defer w.cancel()
+
+	if w.err == nil {
+		if err := w.local.Close(); err != nil {
+			w.err = errors.Wrapf(err, "closing local file '%s'", w.path)
+		}
+	}
+	if w.err != nil {
+		// Cancel the upload before closing it, so that the partial
+		// object is discarded rather than stored.
+		w.cancel()
+		_ = w.remote.Close()
+		_ = w.local.Close()
+		_ = os.Remove(w.local.Name())
+		return w.err
+	}
+
+	if err := w.remote.Close(); err != nil {
+		_ = os.Remove(w.local.Name())
+		return errors.Wrap(err, "closing bucket writer")
+	}
+	if err := os.Rename(w.local.Name(), w.path); err != nil {
+		_ = os.Remove(w.local.Name())
+		return errors.Wrapf(err, "moving local file into place at '%s'", w.path)
+	}
+
+	return nil
+}
@@ -0,0 +1,126 @@
+package pail
+
+import (
+	"context"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// failingWriterBucket wraps a bucket so that its writers fail once more than
+// limit bytes are written to them.
+type failingWriterBucket struct {
+	Bucket
+	limit int
+}
+
+func (b *failingWriterBucket) Writer(ctx context.Context, key string) (io.WriteCloser, error) {
+	w, err := b.Bucket.Writer(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	return &failingWriteCloser{WriteCloser: w, remaining: b.limit}, nil
+}
+
+type failingWriteCloser struct {
+	io.WriteCloser
+	remaining int
+}
+
+func (w *failingWriteCloser) Write(p []byte) (int, error) {
+	if len(p) > w.remaining {
+		return 0, errors.New("connection reset")
+	}
+	w.remaining -= len(p)
+	return w.WriteCloser.Write(p)
+}
+
+func TestTeeWriter(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	data := strings.Repeat("tee data ", 1000)
+	for name, constructor := range map[string]func(t *testing.T) Bucket{
+		"Local": func(t *testing.T) Bucket {
+			b, err := NewLocalBucket(LocalOptions{Path: t.TempDir()})
+			require.NoError(t, err)
+			return b
+		},
+		"S3Small": func(t *testing.T) Bucket {
+			return &s3BucketSmall{s3Bucket: *newMockS3Bucket(newMockS3Client(), "prefix")}
+		},
+		"S3Large": func(t *testing.T) Bucket {
+			return &s3BucketLarge{s3Bucket: *newMockS3Bucket(newMockS3Client(), "prefix"), minPartSize: 1024}
+		},
+	} {
+		t.Run(name, func(t *testing.T) {
+			t.Run("WritesObjectAndLocalFile", func(t *testing.T) {
+				b := constructor(t)
+				localPath := filepath.Join(t.TempDir(), "cache", "key")
+
+				w, err := TeeWriter(ctx, b, "key", localPath)
+				require.NoError(t, err)
+				for _, chunk := range []string{data[:100], data[100:5000], data[5000:]} {
+					_, err = w.Write([]byte(chunk))
+					require.NoError(t, err)
+				}
+				require.NoError(t, w.Close())
+
+				remote, err := readDataFromFile(ctx, b, "key")
+				require.NoError(t, err)
+				assert.Equal(t, data, remote)
+				local, err := os.ReadFile(localPath)
+				require.NoError(t, err)
+				assert.Equal(t, data, string(local))
+
+				entries, err := os.ReadDir(filepath.Dir(localPath))
+				require.NoError(t, err)
+				assert.Len(t, entries, 1, "temporary file should be renamed into place")
+			})
+			t.Run("FailedWriteDiscardsBothCopies", func(t *testing.T) {
+				b := &failingWriterBucket{Bucket: constructor(t), limit: len(data) / 2}
+				localPath := filepath.Join(t.TempDir(), "key")
+
+				w, err := TeeWriter(ctx, b, "key", localPath)
+				require.NoError(t, err)
+				_, err = w.Write([]byte(data[:len(data)/2]))
+				require.NoError(t, err)
+				_, err = w.Write([]byte(data[len(data)/2:]))
+				require.Error(t, err)
+				assert.Error(t, w.Close())
+
+				entries, err := os.ReadDir(filepath.Dir(localPath))
+				require.NoError(t, err)
+				assert.Empty(t, entries)
+				exists, err := b.Exists(ctx, "key")
+				require.NoError(t, err)
+				assert.False(t, exists)
+			})
+			t.Run("CanceledContextDiscardsBothCopies", func(t *testing.T) {
+				b := constructor(t)
+				localPath := filepath.Join(t.TempDir(), "key")
+
+				tctx, tcancel := context.WithCancel(ctx)
+				w, err := TeeWriter(tctx, b, "key", localPath)
+				require.NoError(t, err)
+				_, err = w.Write([]byte(data))
+				require.NoError(t, err)
+				tcancel()
+				assert.Error(t, w.Close())
+
+				entries, err := os.ReadDir(filepath.Dir(localPath))
+				require.NoError(t, err)
+				assert.Empty(t, entries)
+				exists, err := b.Exists(ctx, "key")
+				require.NoError(t, err)
+				assert.False(t, exists)
+			})
+		})
+	}
+}
@@ -9,49 +9,63 @@ import (
 	"github.com/pkg/errors"
 )
 
+// maxDeleteObjects is the maximum number of objects that can be deleted in a
+// single DeleteObjects request.
+const maxDeleteObjects = 1000
+
 func CleanupS3Bucket(ctx context.Context, creds aws.CredentialsProvider, name, prefix, region string) error {
 	svc, err := CreateS3Client(creds, region)
 	if err != nil {
 		return errors.Wrap(err, "creating S3 client")
 	}
-	deleteObjectsInput := &s3.DeleteObjectsInput{
-		Bucket: aws.String(name),
-		Delete: &s3Types.Delete{},
-	}
-	listInput := &s3.ListObjectsInput{
-		Bucket: aws.String(name),
-		Prefix: aws.String(prefix),
+
+	objects, err := ListAllObjects(ctx, svc, name, prefix)
+	if err != nil {
+		return errors.WithStack(err)
 	}
-	var result *s3.ListObjectsOutput
 
-	for {
-		result, err = svc.ListObjects(ctx, listInput)
-		if err != nil {
-			return errors.Wrap(err, "listing objects")
+	for start := 0; start < len(objects); start += maxDeleteObjects {
+		end := start + maxDeleteObjects
+		if end > len(objects) {
+			end = len(objects)
 		}
 
-		for _, object := range result.Contents {
-			deleteObjectsInput.Delete.Objects = append(deleteObjectsInput.Delete.Objects, s3Types.ObjectIdentifier{
+		toDelete := &s3Types.Delete{}
+		for _, object := range objects[start:end] {
+			toDelete.Objects = append(toDelete.Objects, s3Types.ObjectIdentifier{
 				Key: object.Key,
 			})
 		}
-
-		if deleteObjectsInput.Delete.Objects != nil {
-			_, err = svc.DeleteObjects(ctx, deleteObjectsInput)
-			if err != nil {
-				return errors.Wrap(err, "deleting S3 bucket objects")
-			}
-			deleteObjectsInput.Delete = &s3Types.Delete{}
+		_, err = svc.DeleteObjects(ctx, &s3.DeleteObjectsInput{
+			Bucket: aws.String(name),
+			Delete: toDelete,
+		})
+		if err != nil {
+			return errors.Wrap(err, "deleting S3 bucket objects")
 		}
+	}
 
-		if *result.IsTruncated {
-			listInput.Marker = result.Contents[len(result.Contents)-1].Key
-		} else {
-			break
+	return nil
+}
+
+// ListAllObjects returns every object in the bucket with the given prefix,
+// following the pagination of ListObjectsV2 until the listing is complete.
+func ListAllObjects(ctx context.Context, client s3.ListObjectsV2APIClient, bucket, prefix string) ([]s3Types.Object, error) {
+	paginator := s3.NewListObjectsV2Paginator(client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(bucket),
+		Prefix: aws.String(prefix),
+	})
+
+	var objects []s3Types.Object
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, errors.Wrap(err, "listing objects")
 		}
+		objects = append(objects, page.Contents...)
 	}
 
-	return nil
+	return objects, nil
 }
 
 func CreateS3Client(creds aws.CredentialsProvider, region string) (*s3.Client, error) {
@@ -0,0 +1,67 @@
+package testutil
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	s3Types "github.com/aws/aws-sdk-go-v2/service/s3/types"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// pagingListClient lists its keys in pages of at most pageSize objects.
+type pagingListClient struct {
+	keys     []string
+	pageSize int
+	calls    int
+}
+
+func (c *pagingListClient) ListObjectsV2(_ context.Context, input *s3.ListObjectsV2Input, _ ...func(*s3.Options)) (*s3.ListObjectsV2Output, error) {
+	c.calls++
+
+	var matching []string
+	for _, key := range c.keys {
+		if strings.HasPrefix(key, aws.ToString(input.Prefix)) && key > aws.ToString(input.ContinuationToken) {
+			matching = append(matching, key)
+		}
+	}
+	sort.Strings(matching)
+
+	out := &s3.ListObjectsV2Output{IsTruncated: aws.Bool(false)}
+	for i, key := range matching {
+		if i == c.pageSize {
+			out.IsTruncated = aws.Bool(true)
+			out.NextContinuationToken = aws.String(matching[i-1])
+			break
+		}
+		out.Contents = append(out.Contents, s3Types.Object{Key: aws.String(key)})
+	}
+	return out, nil
+}
+
+func TestListAllObjects(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	client := &pagingListClient{pageSize: 2, keys: []string{"other/a"}}
+	var expected []string
+	for i := 0; i < 5; i++ {
+		key := fmt.Sprintf("logs/%d", i)
+		client.keys = append(client.keys, key)
+		expected = append(expected, key)
+	}
+
+	objects, err := ListAllObjects(ctx, client, "bucket", "logs/")
+	require.NoError(t, err)
+	var keys []string
+	for _, object := range objects {
+		keys = append(keys, aws.ToString(object.Key))
+	}
+	assert.Equal(t, expected, keys)
+	assert.Equal(t, 3, client.calls)
+}
@@ -0,0 +1,236 @@
+package pail
+
+import (
+	"context"
+	"sync"
+
+	"github.com/pkg/errors"
+)
+
+// defaultTransferBufferSize is the size of the buffer that io.Copy uses,
+// which is reserved for downloads that do not set a buffer size.
+const defaultTransferBufferSize = 32 * 1024
+
+// globalTransferBudget limits the bytes that transfers in the process buffer
+// at once.
+var globalTransferBudget = &transferBudget{changed: make(chan struct{})}
+
+// SetGlobalTransferByteBudget limits the total size, in bytes, of the buffers
+// that uploads and downloads across every bucket in the process hold at once.
+// Transfers that would exceed the budget block until other transfers release
+// their buffers or their context is done. A budget of zero or less, the
+// default, removes the limit.
+//
+// The budget covers the buffers of S3 writers, which includes Put, Upload and
+// Push, and the copy buffers of S3 downloads. Writers that upload objects in
+// multiple parts reserve one part at a time, and release it once the part is
+// uploaded; writers that upload objects in a single request reserve their
+// buffer as it grows, and hold it until the object is uploaded. A single
+// buffer that is larger than the budget is reserved as the entire budget, and
+// when every transfer holding part of the budget is waiting to grow its
+// buffer, one of them may exceed the budget so that they do not wait on each
+// other forever.
+// UploadReaderAt reads each part from its source as the part is sent, without
+// buffering it, so it does not reserve any of the budget.
+//
+// Since a writer holds its reservation until its buffer is uploaded, a
+// goroutine that writes to more than one writer at a time can block forever
+// once the budget is smaller than their buffers combined, waiting for a
+// reservation that only it can release. Each writer should be written to by
+// its own goroutine, or the budget should allow for every writer that a
+// goroutine uses at once.
+func SetGlobalTransferByteBudget(n int64) {
+	globalTransferBudget.setLimit(n)
+}
+
+// GlobalTransferBytesInUse returns the number of bytes currently reserved
+// against the global transfer byte budget.
+func GlobalTransferBytesInUse() int64 {
+	return globalTransferBudget.inUse()
+}
+
+// transferBudget is a semaphore weighted by bytes.
+type transferBudget struct {
+	mu       sync.Mutex
+	limit    int64
+	reserved int64
+	// waiting is the bytes held by reservations that are waiting to grow.
+	waiting int64
+	// overcommitted is the reservation allowed to grow past the limit, if
+	// any, until it is released.
+	overcommitted *transferReservation
+	// changed is closed, and replaced, whenever bytes are released or the
+	// limit changes, to wake the transfers waiting to reserve bytes.
+	changed chan struct{}
+}
+
+func (b *transferBudget) setLimit(n int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.limit = n
+	b.notify()
+}
+
+func (b *transferBudget) inUse() int64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	return b.reserved
+}
+
+// notify wakes the waiting transfers. The caller must hold the lock.
+func (b *transferBudget) notify() {
+	close(b.changed)
+	b.changed = make(chan struct{})
+}
+
+// acquire reserves n bytes, blocking until they are available or the context
+// is done, and returns a function that releases them. The release function
+// may be called more than once.
+func (b *transferBudget) acquire(ctx context.Context, n int64) (func(), error) {
+	r := &transferReservation{budget: b}
+	if err := r.grow(ctx, n); err != nil {
+		return nil, err
+	}
+	return r.release, nil
+}
+
+// transferReservation is the bytes of a transfer budget reserved for a single
+// buffer, which may grow as the buffer does.
+type transferReservation struct {
+	budget *transferBudget
+	n      int64
+}
+
+// grow increases the bytes reserved to n, blocking until they are available
+// or the context is done. Reservations are limited to the budget's limit, so
+// that a buffer larger than the budget reserves the entire budget rather than
+// waiting forever. Since growing reservations hold their bytes while they
+// wait, when every reserved byte is held by a reservation that is waiting to
+// grow, one of them may grow past the limit until it is released, so that
+// they cannot wait on each other forever.
+func (r *transferReservation) grow(ctx context.Context, n int64) error {
+	b := r.budget
+	waiting := false
+	defer func() {
+		if waiting {
+			b.mu.Lock()
+			b.waiting -= r.n
+			b.mu.Unlock()
+		}
+	}()
+
+	for {
+		b.mu.Lock()
+		if b.limit <= 0 {
+			b.mu.Unlock()
+			return nil
+		}
+		if n > b.limit && b.overcommitted != r {
+			n = b.limit
+		}
+		if n <= r.n {
+			b.mu.Unlock()
+			return nil
+		}
+		if b.overcommitted == nil && waiting && b.reserved == b.waiting {
+			b.overcommitted = r
+		}
+		if b.reserved+n-r.n <= b.limit || b.overcommitted == r {
+			if waiting {
+				b.waiting -= r.n
+				waiting = false
+			}
+			b.reserved += n - r.n
+			r.n = n
+			b.mu.Unlock()
+			return nil
+		}
+		if !waiting {
+			waiting = true
+			b.waiting += r.n
+			b.notify()
+		}
+		changed := b.changed
+		b.mu.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), "waiting for transfer byte budget")
+		}
+	}
+}
+
+// release releases the bytes reserved. It may be called more than once.
+func (r *transferReservation) release() {
+	b := r.budget
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.overcommitted == r {
+		b.overcommitted = nil
+	}
+	if r.n == 0 {
+		return
+	}
+	b.reserved -= r.n
+	r.n = 0
+	b.notify()
+}
+
+// budgetedBuffer is a buffer whose capacity is reserved against the global
+// transfer byte budget as it grows.
+type budgetedBuffer struct {
+	buf         []byte
+	reservation transferReservation
+}
+
+func newBudgetedBuffer() *budgetedBuffer {
+	return &budgetedBuffer{reservation: transferReservation{budget: globalTransferBudget}}
+}
+
+// bytes returns the buffered data.
+func (b *budgetedBuffer) bytes() []byte { return b.buf }
+
+// len returns the number of bytes buffered.
+func (b *budgetedBuffer) len() int { return len(b.buf) }
+
+// reserve makes the buffer's capacity at least size bytes, reserving the new
+// capacity first.
+func (b *budgetedBuffer) reserve(ctx context.Context, size int) error {
+	if size <= cap(b.buf) {
+		return nil
+	}
+	if err := b.reservation.grow(ctx, int64(size)); err != nil {
+		return err
+	}
+
+	grown := make([]byte, len(b.buf), size)
+	copy(grown, b.buf)
+	b.buf = grown
+	return nil
+}
+
+// write appends p to the buffer, reserving more of the budget if the buffer
+// has to grow. Like append, the buffer at least doubles in size when it grows.
+func (b *budgetedBuffer) write(ctx context.Context, p []byte) error {
+	if need := len(b.buf) + len(p); need > cap(b.buf) {
+		size := 2 * cap(b.buf)
+		if size < need {
+			size = need
+		}
+		if err := b.reserve(ctx, size); err != nil {
+			return err
+		}
+	}
+	b.buf = append(b.buf, p...)
+	return nil
+}
+
+// reset discards the buffer and releases its reservation.
+func (b *budgetedBuffer) reset() {
+	b.buf = nil
+	b.reservation.release()
+}
@@ -0,0 +1,219 @@
+package pail
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGlobalTransferByteBudget(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer SetGlobalTransferByteBudget(0)
+
+	t.Run("BoundsConcurrentLargeUploads", func(t *testing.T) {
+		const partSize = 1024
+		SetGlobalTransferByteBudget(partSize)
+		defer SetGlobalTransferByteBudget(0)
+
+		client := newMockS3Client()
+		var active, maxActive int32
+		var mu sync.Mutex
+		client.uploadPartHook = func() {
+			n := atomic.AddInt32(&active, 1)
+			defer atomic.AddInt32(&active, -1)
+			mu.Lock()
+			if n > maxActive {
+				maxActive = n
+			}
+			mu.Unlock()
+			time.Sleep(5 * time.Millisecond)
+		}
+		b := &s3BucketLarge{s3Bucket: *newMockS3Bucket(client, "prefix"), minPartSize: partSize}
+
+		data := bytes.Repeat([]byte("0123456789abcdef"), 512)
+		keys := []string{"a", "b", "c", "d"}
+		wg := &sync.WaitGroup{}
+		errs := make([]error, len(keys))
+		for i, key := range keys {
+			wg.Add(1)
+			go func(i int, key string) {
+				defer wg.Done()
+				w, err := b.Writer(ctx, key)
+				if err != nil {
+					errs[i] = err
+					return
+				}
+				for offset := 0; offset < len(data); offset += 256 {
+					if _, err = w.Write(data[offset : offset+256]); err != nil {
+						errs[i] = err
+						return
+					}
+				}
+				errs[i] = w.Close()
+			}(i, key)
+		}
+		wg.Wait()
+
+		for _, err := range errs {
+			require.NoError(t, err)
+		}
+		for _, key := range keys {
+			stored, err := readDataFromFile(ctx, b, key)
+			require.NoError(t, err)
+			assert.Equal(t, string(data), stored)
+		}
+		assert.EqualValues(t, 1, maxActive, "only one part should be buffered at a time")
+		assert.Zero(t, GlobalTransferBytesInUse())
+	})
+	t.Run("BoundsSmallWriterAllocations", func(t *testing.T) {
+		const (
+			objSize = 4 << 20
+			writers = 8
+		)
+		peakHeapGrowth := func(t *testing.T) uint64 {
+			client := newMockS3Client()
+			client.corrupt = func(string, []byte) []byte { return nil }
+			var mu sync.Mutex
+			var baseline, peak uint64
+			stats := &runtime.MemStats{}
+			client.putObjectHook = func() {
+				// The recorded requests refer to the buffers of the
+				// writers that have already finished.
+				client.mu.Lock()
+				client.putObjectCalls = nil
+				client.mu.Unlock()
+
+				mu.Lock()
+				defer mu.Unlock()
+				runtime.GC()
+				runtime.ReadMemStats(stats)
+				if stats.HeapAlloc > peak {
+					peak = stats.HeapAlloc
+				}
+			}
+			b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(client, "prefix")}
+
+			chunk := bytes.Repeat([]byte("0123456789abcdef"), 4096)
+			runtime.GC()
+			runtime.ReadMemStats(stats)
+			baseline = stats.HeapAlloc
+
+			wg := &sync.WaitGroup{}
+			errs := make([]error, writers)
+			start := make(chan struct{})
+			for i := 0; i < writers; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					w, err := b.Writer(ctx, strconv.Itoa(i))
+					if err != nil {
+						errs[i] = err
+						return
+					}
+					<-start
+					for written := 0; written < objSize; written += len(chunk) {
+						if _, err = w.Write(chunk); err != nil {
+							errs[i] = err
+							return
+						}
+					}
+					errs[i] = w.Close()
+				}(i)
+			}
+			close(start)
+			wg.Wait()
+
+			for _, err := range errs {
+				require.NoError(t, err)
+			}
+			require.Greater(t, peak, baseline)
+			return peak - baseline
+		}
+
+		SetGlobalTransferByteBudget(objSize)
+		defer SetGlobalTransferByteBudget(0)
+		assert.Less(t, peakHeapGrowth(t), uint64(2*objSize))
+		assert.Zero(t, GlobalTransferBytesInUse())
+	})
+	t.Run("GrowingReservationsDoNotWaitOnEachOther", func(t *testing.T) {
+		SetGlobalTransferByteBudget(10)
+		defer SetGlobalTransferByteBudget(0)
+
+		first := &transferReservation{budget: globalTransferBudget}
+		second := &transferReservation{budget: globalTransferBudget}
+		require.NoError(t, first.grow(ctx, 5))
+		require.NoError(t, second.grow(ctx, 5))
+
+		tctx, tcancel := context.WithTimeout(ctx, time.Second)
+		defer tcancel()
+		errs := make(chan error, 2)
+		go func() { errs <- first.grow(tctx, 10) }()
+		go func() { errs <- second.grow(tctx, 10) }()
+		require.NoError(t, <-errs)
+		first.release()
+		second.release()
+		require.NoError(t, <-errs)
+		first.release()
+		second.release()
+		assert.Zero(t, GlobalTransferBytesInUse())
+	})
+	t.Run("DownloadsReserveCopyBuffer", func(t *testing.T) {
+		SetGlobalTransferByteBudget(defaultTransferBufferSize)
+		defer SetGlobalTransferByteBudget(0)
+
+		b := &s3BucketSmall{s3Bucket: *newMockS3Bucket(newMockS3Client(), "prefix")}
+		require.NoError(t, b.Put(ctx, "key", strings.NewReader("some data")))
+
+		release, err := globalTransferBudget.acquire(ctx, defaultTransferBufferSize)
+		require.NoError(t, err)
+		tctx, tcancel := context.WithTimeout(ctx, 50*time.Millisecond)
+		defer tcancel()
+		assert.Error(t, b.Download(tctx, "key", filepath.Join(t.TempDir(), "file")))
+
+		release()
+		require.NoError(t, b.Download(ctx, "key", filepath.Join(t.TempDir(), "file")))
+		assert.Zero(t, GlobalTransferBytesInUse())
+	})
+	t.Run("WaitingStopsWhenContextIsDone", func(t *testing.T) {
+		SetGlobalTransferByteBudget(10)
+		defer SetGlobalTransferByteBudget(0)
+
+		release, err := globalTransferBudget.acquire(ctx, 10)
+		require.NoError(t, err)
+		defer release()
+
+		tctx, tcancel := context.WithCancel(ctx)
+		tcancel()
+		_, err = globalTransferBudget.acquire(tctx, 1)
+		assert.Error(t, err)
+	})
+	t.Run("ReservationsLargerThanBudgetUseWholeBudget", func(t *testing.T) {
+		SetGlobalTransferByteBudget(10)
+		defer SetGlobalTransferByteBudget(0)
+
+		release, err := globalTransferBudget.acquire(ctx, 100)
+		require.NoError(t, err)
+		assert.EqualValues(t, 10, GlobalTransferBytesInUse())
+		release()
+		release()
+		assert.Zero(t, GlobalTransferBytesInUse())
+	})
+	t.Run("UnlimitedByDefault", func(t *testing.T) {
+		for i := 0; i < 3; i++ {
+			_, err := globalTransferBudget.acquire(ctx, 1<<40)
+			require.NoError(t, err)
+		}
+		assert.Zero(t, GlobalTransferBytesInUse())
+	})
+}
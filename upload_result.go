@@ -0,0 +1,43 @@
+package pail
+
+import (
+	"context"
+	"os"
+
+	"github.com/pkg/errors"
+)
+
+// UploadResult describes what an upload did.
+type UploadResult struct {
+	// Uploaded is set if the file was uploaded.
+	Uploaded bool
+	// Skipped is set if the file was not uploaded because the object was
+	// already up to date. Neither Uploaded nor Skipped is set if the
+	// bucket is in dry run mode.
+	Skipped bool
+	// BytesTransferred is the size of the file if it was uploaded, before
+	// any compression, and zero otherwise.
+	BytesTransferred int64
+}
+
+// UploadWithResult uploads the file at path to the given key, like Upload, and
+// reports whether the file was uploaded or skipped.
+//
+// Buckets that implement UploadResultBucket report the result themselves;
+// S3 buckets configured with UseSingleFileChecksums skip files whose contents
+// match the existing object. Other buckets always upload the file, so their
+// uploads are reported as uploaded.
+func UploadWithResult(ctx context.Context, b Bucket, key, path string) (UploadResult, error) {
+	if rb, ok := b.(UploadResultBucket); ok {
+		return rb.UploadWithResult(ctx, key, path)
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return UploadResult{}, errors.Wrapf(err, "getting file stats for '%s'", path)
+	}
+	if err = b.Upload(ctx, key, path); err != nil {
+		return UploadResult{}, errors.WithStack(err)
+	}
+	return UploadResult{Uploaded: true, BytesTransferred: info.Size()}, nil
+}
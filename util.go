@@ -2,6 +2,7 @@ package pail
 
 import (
 	"archive/tar"
+	"bytes"
 	"context"
 	"io"
 	"os"
@@ -9,21 +10,78 @@ import (
 	"regexp"
 	"runtime"
 	"strings"
+	"sync"
 
 	"github.com/mongodb/grip"
+	"github.com/mongodb/grip/message"
 	"github.com/pkg/errors"
 )
 
+// copyWithBuffer copies src to dst using a buffer of the given size, or as by
+// io.Copy, which uses a 32KB buffer unless either side can copy more
+// efficiently, if the size is zero.
+func copyWithBuffer(dst io.Writer, src io.Reader, size int) (int64, error) {
+	if size == 0 {
+		return io.Copy(dst, src)
+	}
+	// Hide any io.WriterTo or io.ReaderFrom implementations, which would
+	// otherwise be used instead of the buffer.
+	return io.CopyBuffer(struct{ io.Writer }{dst}, struct{ io.Reader }{src}, make([]byte, size))
+}
+
 func consistentJoin(elems []string) string {
 	return filepath.ToSlash(filepath.Join(elems...))
 }
 
+// dirPrefix returns the prefix that lists the objects in the directory, such
+// as the remote directory of a sync, so that a directory "foo" does not also
+// match "foobar".
+func dirPrefix(dir string) string {
+	if dir == "" || strings.HasSuffix(dir, "/") {
+		return dir
+	}
+	return dir + "/"
+}
+
+// underPrefix returns whether the key is the object at the prefix or is in
+// the directory that the prefix names, so that, unlike List, a prefix "foo"
+// does not also match "foobar".
+func underPrefix(key, prefix string) bool {
+	key, prefix = filepath.ToSlash(key), filepath.ToSlash(prefix)
+	return key == prefix || strings.HasPrefix(key, dirPrefix(prefix))
+}
+
 func consistentTrimPrefix(key, prefix string) string {
 	return strings.TrimPrefix(key, prefix+"/")
 }
 
 func walkLocalTree(ctx context.Context, prefix string) ([]string, error) {
+	files, err := walkLocalTreeInfo(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+
 	var out []string
+	for _, file := range files {
+		out = append(out, file.path)
+	}
+	return out, nil
+}
+
+// localFile is a file found by walking a local tree.
+type localFile struct {
+	// path is the path of the file relative to the root of the tree.
+	path string
+	// info describes the file, or the file that it links to.
+	info os.FileInfo
+}
+
+// walkLocalTreeInfo behaves like walkLocalTree, but also returns the info of
+// each file found while walking the tree, so that callers do not need to stat
+// the files again. The temporary files that local bucket writers stage data in
+// are skipped.
+func walkLocalTreeInfo(ctx context.Context, prefix string) ([]localFile, error) {
+	var out []localFile
 	err := filepath.Walk(prefix, func(path string, info os.FileInfo, err error) error {
 		if err != nil {
 			return nil
@@ -43,23 +101,23 @@ func walkLocalTree(ctx context.Context, prefix string) ([]string, error) {
 			if err != nil {
 				return errors.Wrap(err, "getting symlink path")
 			}
-			symTree, err := walkLocalTree(ctx, symPath)
+			symTree, err := walkLocalTreeInfo(ctx, symPath)
 			if err != nil {
 				return errors.Wrap(err, "getting symlink tree")
 			}
 			for i := range symTree {
-				symTree[i] = filepath.Join(rel, symTree[i])
+				symTree[i].path = filepath.Join(rel, symTree[i].path)
 			}
 			out = append(out, symTree...)
 
 			return nil
 		}
 
-		if info.IsDir() {
+		if info.IsDir() || isLocalTempFile(info.Name()) {
 			return nil
 		}
 
-		out = append(out, rel)
+		out = append(out, localFile{path: rel, info: info})
 		return nil
 	})
 
@@ -83,9 +141,15 @@ func removePrefix(ctx context.Context, prefix string, b Bucket) error {
 	for iter.Next(ctx) {
 		keys = append(keys, iter.Item().Name())
 	}
+	if err = iter.Err(); err != nil {
+		return errors.Wrapf(err, "listing objects with prefix '%s'", prefix)
+	}
 	return errors.Wrapf(b.RemoveMany(ctx, keys...), "deleting objects with prefix '%s'", prefix)
 }
 
+// removeMatching removes the objects in the bucket whose keys, relative to the
+// bucket's prefix, match the regular expression, using RemoveMany so that
+// buckets can remove them in batches.
 func removeMatching(ctx context.Context, expression string, b Bucket) error {
 	regex, err := regexp.Compile(expression)
 	if err != nil {
@@ -103,9 +167,144 @@ func removeMatching(ctx context.Context, expression string, b Bucket) error {
 			keys = append(keys, key)
 		}
 	}
+	// Only remove objects once every object is listed, so that a failed
+	// listing does not remove an arbitrary subset of the matches.
+	if err = iter.Err(); err != nil {
+		return errors.Wrapf(err, "listing objects matching '%s'", expression)
+	}
+	if len(keys) == 0 {
+		return nil
+	}
 	return errors.Wrapf(b.RemoveMany(ctx, keys...), "deleting objects matching '%s'", expression)
 }
 
+// skipVanishedFile returns whether the error from pushing the given local file
+// should be ignored because the file was removed after the local tree was
+// walked and the sync options allow skipping such files.
+func skipVanishedFile(opts SyncOptions, path string, err error) bool {
+	if err == nil || !opts.SkipVanishedFiles {
+		return false
+	}
+	if _, statErr := os.Stat(path); !os.IsNotExist(statErr) {
+		return false
+	}
+
+	grip.Info(message.WrapError(err, message.Fields{
+		"message": "skipping file that was removed during push",
+		"path":    path,
+	}))
+	return true
+}
+
+// existsManyHelper checks whether each of the given keys exists using the
+// given existence check and number of concurrent workers.
+func existsManyHelper(ctx context.Context, existsFn func(context.Context, string) (bool, error), keys []string, workers int) (map[string]bool, error) {
+	if workers < 1 {
+		workers = 1
+	}
+
+	in := make(chan string, len(keys))
+	for _, key := range keys {
+		in <- key
+	}
+	close(in)
+
+	var mu sync.Mutex
+	exists := make(map[string]bool, len(keys))
+	catcher := grip.NewBasicCatcher()
+	wg := &sync.WaitGroup{}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for key := range in {
+				if err := ctx.Err(); err != nil {
+					catcher.Wrapf(err, "checking if key '%s' exists", key)
+					continue
+				}
+
+				ok, err := existsFn(ctx, key)
+				if err != nil {
+					catcher.Wrapf(err, "checking if key '%s' exists", key)
+					continue
+				}
+
+				mu.Lock()
+				exists[key] = ok
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	return exists, catcher.Resolve()
+}
+
+// checkCopyDestination returns an error wrapping ErrAlreadyExists if the copy
+// must not overwrite its destination and the destination key already exists.
+func checkCopyDestination(ctx context.Context, opts CopyOptions) error {
+	if !opts.IfNotExists {
+		return nil
+	}
+
+	exists, err := opts.DestinationBucket.Exists(ctx, opts.DestinationKey)
+	if err != nil {
+		return errors.Wrap(err, "checking if destination exists")
+	}
+	if exists {
+		return errors.Wrapf(ErrAlreadyExists, "copying to key '%s'", opts.DestinationKey)
+	}
+
+	return nil
+}
+
+// streamCopy copies an object from the given bucket to the destination bucket
+// by streaming its contents. If the source bucket is an InfoBucket and the
+// destination is an InfoWriterBucket, the object's content type and custom
+// metadata are copied too.
+func streamCopy(ctx context.Context, b Bucket, opts CopyOptions) error {
+	if err := checkCopyDestination(ctx, opts); err != nil {
+		return err
+	}
+
+	var (
+		from io.ReadCloser
+		info *BucketItemInfo
+		err  error
+	)
+	if ib, ok := b.(InfoBucket); ok {
+		from, info, err = ib.GetWithInfo(ctx, opts.SourceKey)
+	} else {
+		from, err = b.Reader(ctx, opts.SourceKey)
+	}
+	if err != nil {
+		return errors.Wrap(err, "getting reader for source")
+	}
+	defer from.Close()
+
+	// Canceling the writer's context before closing it discards the data
+	// written, so a failed copy does not create a truncated object.
+	writeCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	var to io.WriteCloser
+	if iwb, ok := opts.DestinationBucket.(InfoWriterBucket); ok && info != nil {
+		to, err = iwb.WriterWithInfo(writeCtx, opts.DestinationKey, info)
+	} else {
+		to, err = opts.DestinationBucket.Writer(writeCtx, opts.DestinationKey)
+	}
+	if err != nil {
+		return errors.Wrap(err, "getting writer for destination")
+	}
+
+	if _, err = io.Copy(to, from); err != nil {
+		cancel()
+		_ = to.Close()
+		return errors.Wrap(err, "copying data")
+	}
+
+	return errors.WithStack(to.Close())
+}
+
 func deleteOnPush(ctx context.Context, sourceFiles []string, remote string, bucket Bucket) error {
 	sourceFilesMap := map[string]bool{}
 	for _, fn := range sourceFiles {
@@ -115,7 +314,7 @@ func deleteOnPush(ctx context.Context, sourceFiles []string, remote string, buck
 		sourceFilesMap[bucket.Join(remote, fn)] = true
 	}
 
-	iter, err := bucket.List(ctx, remote)
+	iter, err := bucket.List(ctx, dirPrefix(remote))
 	if err != nil {
 		return err
 	}
@@ -127,6 +326,9 @@ func deleteOnPush(ctx context.Context, sourceFiles []string, remote string, buck
 			toDelete = append(toDelete, name)
 		}
 	}
+	if err = iter.Err(); err != nil {
+		return errors.Wrap(err, "listing remote objects")
+	}
 
 	return bucket.RemoveMany(ctx, toDelete...)
 }
@@ -155,36 +357,196 @@ func deleteOnPull(ctx context.Context, sourceFiles []string, local string) error
 // The archive/unarchive functions below are modified versions of the same
 // functions from github.com/mholt/archiver.
 
+// resolveTarPath returns the absolute path of the file at the given path,
+// which is either relative to dir or an absolute path within it, and its path
+// relative to dir.
+func resolveTarPath(dir, path string) (absPath, relPath string, err error) {
+	if !filepath.IsAbs(path) {
+		return filepath.Join(dir, path), path, nil
+	}
+	if !strings.HasPrefix(path, dir) {
+		return "", "", errors.Errorf("cannot specify absolute path to file that is not within directory '%s'", dir)
+	}
+	relPath, err = filepath.Rel(dir, path)
+	if err != nil {
+		return "", "", errors.Wrap(err, "getting relative path")
+	}
+	return path, relPath, nil
+}
+
 func tarFile(tarWriter *tar.Writer, dir, relPath string) error {
-	var absPath string
-	if filepath.IsAbs(relPath) {
-		if !strings.HasPrefix(relPath, dir) {
-			return errors.Errorf("cannot specify absolute path to file that is not within directory '%s'", dir)
+	entry := statTarEntry(dir, relPath)
+	if entry.err != nil {
+		return entry.err
+	}
+	return streamTarEntry(tarWriter, entry)
+}
+
+// maxTarReadAheadBytes is the most file contents that tarFiles reads ahead of
+// the file being written.
+const maxTarReadAheadBytes = 64 * 1024 * 1024
+
+// tarFiles adds the files at the given paths relative to dir to the archive,
+// in order. If workers is greater than one, up to that many files, and up to
+// maxTarReadAheadBytes of their contents, are read into memory concurrently
+// ahead of the file being written. Files larger than that are read from disk
+// as they are written.
+func tarFiles(ctx context.Context, tarWriter *tar.Writer, dir string, relPaths []string, workers int) error {
+	return tarFilesReadAhead(ctx, tarWriter, dir, relPaths, workers, maxTarReadAheadBytes)
+}
+
+// tarFilesReadAhead behaves like tarFiles, but reads ahead at most the given
+// number of bytes of file contents.
+func tarFilesReadAhead(ctx context.Context, tarWriter *tar.Writer, dir string, relPaths []string, workers int, readAheadBytes int64) error {
+	if workers <= 1 {
+		for _, relPath := range relPaths {
+			if err := tarFile(tarWriter, dir, relPath); err != nil {
+				return errors.Wrap(err, filepath.Join(dir, relPath))
+			}
 		}
-		absPath = relPath
-		var err error
-		relPath, err = filepath.Rel(dir, relPath)
-		if err != nil {
-			return errors.Wrap(err, "getting relative path")
+		return nil
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	results := make([]chan tarEntry, len(relPaths))
+	for i := range results {
+		results[i] = make(chan tarEntry, 1)
+	}
+	// slots limits the number of files that are read but not yet written
+	// to the archive, and budget limits the size of their contents. The
+	// contents are reserved in the order the files are written, so the
+	// file being written never waits for a file after it.
+	slots := make(chan struct{}, workers)
+	budget := &transferBudget{limit: readAheadBytes, changed: make(chan struct{})}
+	go func() {
+		for i, relPath := range relPaths {
+			select {
+			case slots <- struct{}{}:
+			case <-ctx.Done():
+				return
+			}
+
+			entry := statTarEntry(dir, relPath)
+			if entry.err != nil || !entry.info.Mode().IsRegular() || entry.info.Size() > readAheadBytes {
+				results[i] <- entry
+				continue
+			}
+			release, err := budget.acquire(ctx, entry.info.Size())
+			if err != nil {
+				results[i] <- tarEntry{err: err}
+				continue
+			}
+			go func(i int, entry tarEntry) {
+				results[i] <- readTarEntry(entry, release)
+			}(i, entry)
+		}
+	}()
+
+	for i, relPath := range relPaths {
+		var entry tarEntry
+		select {
+		case entry = <-results[i]:
+		case <-ctx.Done():
+			return errors.WithStack(ctx.Err())
+		}
+		<-slots
+
+		if entry.err != nil {
+			return errors.Wrap(entry.err, filepath.Join(dir, relPath))
+		}
+		if entry.readAhead {
+			err := addToTar(tarWriter, entry.info, bytes.NewReader(entry.content), entry.absPath, entry.relPath)
+			entry.release()
+			if err != nil {
+				return errors.Wrap(errors.Wrap(err, "adding file to archive"), filepath.Join(dir, relPath))
+			}
+			continue
+		}
+		if err := streamTarEntry(tarWriter, entry); err != nil {
+			return errors.Wrap(err, filepath.Join(dir, relPath))
 		}
-	} else {
-		absPath = filepath.Join(dir, relPath)
+	}
+
+	return nil
+}
+
+// tarEntry is a file to be added to an archive.
+type tarEntry struct {
+	info    os.FileInfo
+	absPath string
+	relPath string
+	// readAhead is whether the file's contents have been read into
+	// content, in which case release releases the bytes reserved for them.
+	readAhead bool
+	content   []byte
+	release   func()
+	err       error
+}
+
+// statTarEntry returns the entry for the file at the given path relative to
+// dir, without reading its contents.
+func statTarEntry(dir, relPath string) tarEntry {
+	absPath, relPath, err := resolveTarPath(dir, relPath)
+	if err != nil {
+		return tarEntry{err: err}
 	}
 
 	info, err := os.Stat(absPath)
 	if err != nil {
-		return errors.Wrapf(err, "getting stat info for path '%s'", absPath)
+		return tarEntry{err: errors.Wrapf(err, "getting stat info for path '%s'", absPath)}
 	}
 
+	return tarEntry{info: info, absPath: absPath, relPath: relPath}
+}
+
+// readTarEntry reads the contents of the regular file of the entry, up to its
+// size when it was stat'ed. The entry's size is that of the contents actually
+// read, so that its header matches them if the file has since changed.
+func readTarEntry(entry tarEntry, release func()) tarEntry {
+	f, err := os.Open(entry.absPath)
+	if err != nil {
+		release()
+		return tarEntry{err: errors.Wrap(err, "opening file")}
+	}
+	defer f.Close()
+
+	content := make([]byte, entry.info.Size())
+	n, err := io.ReadFull(f, content)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		release()
+		return tarEntry{err: errors.Wrap(err, "reading file")}
+	}
+
+	entry.readAhead = true
+	entry.content = content[:n]
+	entry.info = sizedFileInfo{FileInfo: entry.info, size: int64(n)}
+	entry.release = release
+	return entry
+}
+
+// sizedFileInfo overrides the size of a file's info.
+type sizedFileInfo struct {
+	os.FileInfo
+	size int64
+}
+
+func (i sizedFileInfo) Size() int64 { return i.size }
+
+// streamTarEntry adds the entry to the archive, reading the contents of a
+// regular file from disk as they are written.
+func streamTarEntry(tarWriter *tar.Writer, entry tarEntry) error {
 	var file *os.File
-	if info.Mode().IsRegular() {
-		file, err = os.Open(absPath)
+	if entry.info.Mode().IsRegular() {
+		var err error
+		file, err = os.Open(entry.absPath)
 		if err != nil {
 			return errors.Wrap(err, "opening file")
 		}
 		defer file.Close()
 	}
-	if err := addToTar(tarWriter, info, file, absPath, relPath); err != nil {
+	if err := addToTar(tarWriter, entry.info, file, entry.absPath, entry.relPath); err != nil {
 		return errors.Wrap(err, "adding file to archive")
 	}
 
@@ -322,3 +684,47 @@ func writeHardLink(path string, target string) error {
 
 	return nil
 }
+
+// limitedReadCloser wraps a ReadCloser and returns an error once more than
+// the given number of bytes have been read from it.
+type limitedReadCloser struct {
+	io.ReadCloser
+	key       string
+	maxBytes  int64
+	remaining int64
+}
+
+func newLimitedReadCloser(rc io.ReadCloser, key string, maxBytes int64) *limitedReadCloser {
+	return &limitedReadCloser{
+		ReadCloser: rc,
+		key:        key,
+		maxBytes:   maxBytes,
+		remaining:  maxBytes,
+	}
+}
+
+func (r *limitedReadCloser) Read(p []byte) (int, error) {
+	if r.remaining < 0 {
+		return 0, r.limitError()
+	}
+
+	// Read at most one byte past the limit so that exceeding it can be
+	// detected without consuming the rest of the stream.
+	if int64(len(p)) > r.remaining+1 {
+		p = p[:r.remaining+1]
+	}
+
+	n, err := r.ReadCloser.Read(p)
+	if int64(n) > r.remaining {
+		n = int(r.remaining)
+		r.remaining = -1
+		return n, r.limitError()
+	}
+	r.remaining -= int64(n)
+
+	return n, err
+}
+
+func (r *limitedReadCloser) limitError() error {
+	return errors.Wrapf(ErrObjectTooLarge, "key '%s' is larger than %d bytes", r.key, r.maxBytes)
+}
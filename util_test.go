@@ -4,13 +4,17 @@ import (
 	"archive/tar"
 	"bytes"
 	"context"
+	"fmt"
 	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"runtime"
+	"strings"
 	"testing"
+	"testing/iotest"
 
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -142,3 +146,181 @@ func TestTarFile(t *testing.T) {
 		})
 	}
 }
+
+func TestTarFiles(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	dir := t.TempDir()
+	var relPaths []string
+	for i := 0; i < 50; i++ {
+		relPath := filepath.Join(fmt.Sprintf("dir%d", i%5), fmt.Sprintf("file%d.txt", i))
+		require.NoError(t, os.MkdirAll(filepath.Join(dir, filepath.Dir(relPath)), 0700))
+		require.NoError(t, os.WriteFile(filepath.Join(dir, relPath), bytes.Repeat([]byte(relPath), i*100), 0600))
+		relPaths = append(relPaths, relPath)
+	}
+	relPaths = append(relPaths, "dir0")
+
+	archive := func(t *testing.T, workers int) []byte {
+		b := &bytes.Buffer{}
+		tw := tar.NewWriter(b)
+		require.NoError(t, tarFiles(ctx, tw, dir, relPaths, workers))
+		require.NoError(t, tw.Close())
+		return b.Bytes()
+	}
+
+	serial := archive(t, 1)
+	tr := tar.NewReader(bytes.NewReader(serial))
+	for i, relPath := range relPaths {
+		header, err := tr.Next()
+		require.NoError(t, err)
+		if i == len(relPaths)-1 {
+			assert.Equal(t, "dir0/", header.Name)
+			continue
+		}
+		assert.Equal(t, filepath.ToSlash(relPath), header.Name)
+		content, err := io.ReadAll(tr)
+		require.NoError(t, err)
+		assert.Equal(t, bytes.Repeat([]byte(relPath), i*100), content)
+	}
+	_, err := tr.Next()
+	assert.Equal(t, io.EOF, err)
+
+	for _, workers := range []int{2, 8, 100} {
+		t.Run(fmt.Sprintf("%dWorkersMatchSerial", workers), func(t *testing.T) {
+			for i := 0; i < 5; i++ {
+				assert.Equal(t, serial, archive(t, workers))
+			}
+		})
+	}
+	t.Run("ReadAheadLimitedByBytesMatchesSerial", func(t *testing.T) {
+		for _, limit := range []int64{1, 1000, 10000} {
+			b := &bytes.Buffer{}
+			tw := tar.NewWriter(b)
+			require.NoError(t, tarFilesReadAhead(ctx, tw, dir, relPaths, 8, limit))
+			require.NoError(t, tw.Close())
+			assert.Equal(t, serial, b.Bytes(), "limit %d", limit)
+		}
+	})
+	t.Run("ReadAheadHeaderMatchesContentsRead", func(t *testing.T) {
+		path := filepath.Join(t.TempDir(), "file")
+		for name, change := range map[string]func() error{
+			"Grown":  func() error { return os.WriteFile(path, []byte("0123456789abcdef"), 0600) },
+			"Shrunk": func() error { return os.WriteFile(path, []byte("01234"), 0600) },
+		} {
+			t.Run(name, func(t *testing.T) {
+				require.NoError(t, os.WriteFile(path, []byte("0123456789"), 0600))
+				entry := statTarEntry(filepath.Dir(path), "file")
+				require.NoError(t, entry.err)
+				require.NoError(t, change())
+
+				entry = readTarEntry(entry, func() {})
+				require.NoError(t, entry.err)
+				assert.EqualValues(t, len(entry.content), entry.info.Size())
+
+				b := &bytes.Buffer{}
+				tw := tar.NewWriter(b)
+				require.NoError(t, addToTar(tw, entry.info, bytes.NewReader(entry.content), entry.absPath, entry.relPath))
+				require.NoError(t, tw.Close())
+				tr := tar.NewReader(b)
+				_, err := tr.Next()
+				require.NoError(t, err)
+				content, err := io.ReadAll(tr)
+				require.NoError(t, err)
+				assert.Equal(t, entry.content, content)
+			})
+		}
+	})
+	t.Run("FailsForNonexistentFile", func(t *testing.T) {
+		tw := tar.NewWriter(&bytes.Buffer{})
+		err := tarFiles(ctx, tw, dir, append([]string{relPaths[0], "nonexistent_file"}, relPaths[1:]...), 4)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "nonexistent_file")
+	})
+	t.Run("FailsForCanceledContext", func(t *testing.T) {
+		tctx, tcancel := context.WithCancel(ctx)
+		tcancel()
+		tw := tar.NewWriter(&bytes.Buffer{})
+		assert.Error(t, tarFiles(tctx, tw, dir, relPaths, 4))
+	})
+}
+
+func TestCopyWithBuffer(t *testing.T) {
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 64*1024)
+	for _, size := range []int{0, 1, 4096, 4 * 1024 * 1024} {
+		t.Run(fmt.Sprint(size), func(t *testing.T) {
+			dst := &bytes.Buffer{}
+			n, err := copyWithBuffer(dst, bytes.NewReader(payload), size)
+			require.NoError(t, err)
+			assert.EqualValues(t, len(payload), n)
+			assert.Equal(t, payload, dst.Bytes())
+		})
+	}
+}
+
+func TestLocalCopyBufferSize(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 256*1024)
+	src := filepath.Join(t.TempDir(), "src")
+	require.NoError(t, os.WriteFile(src, payload, 0600))
+
+	b, err := NewLocalBucket(LocalOptions{Path: t.TempDir(), CopyBufferSize: 1024 * 1024})
+	require.NoError(t, err)
+	require.NoError(t, b.Upload(ctx, "key", src))
+	dest := filepath.Join(t.TempDir(), "dest")
+	require.NoError(t, b.Download(ctx, "key", dest))
+	downloaded, err := os.ReadFile(dest)
+	require.NoError(t, err)
+	assert.Equal(t, payload, downloaded)
+
+	_, err = NewLocalBucket(LocalOptions{Path: t.TempDir(), CopyBufferSize: -1})
+	assert.Error(t, err)
+}
+
+func BenchmarkLocalCopyBufferSize(b *testing.B) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	payload := bytes.Repeat([]byte("0123456789abcdef"), 4*1024*1024)
+	for _, size := range []int{4 * 1024, 32 * 1024, 1024 * 1024} {
+		b.Run(fmt.Sprint(size), func(b *testing.B) {
+			bucket, err := NewLocalBucket(LocalOptions{Path: b.TempDir(), CopyBufferSize: size})
+			require.NoError(b, err)
+			b.SetBytes(int64(len(payload)))
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				require.NoError(b, bucket.Put(ctx, "key", bytes.NewReader(payload)))
+			}
+		})
+	}
+}
+
+// failingReaderBucket wraps a bucket so that its readers fail after returning
+// the beginning of the object.
+type failingReaderBucket struct {
+	Bucket
+}
+
+func (b *failingReaderBucket) Reader(context.Context, string) (io.ReadCloser, error) {
+	return io.NopCloser(io.MultiReader(strings.NewReader("partial"), iotest.ErrReader(errors.New("connection reset")))), nil
+}
+
+func TestStreamCopyDiscardsFailedCopy(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	src, err := NewLocalBucket(LocalOptions{Path: t.TempDir()})
+	require.NoError(t, err)
+	dst, err := NewLocalBucket(LocalOptions{Path: t.TempDir()})
+	require.NoError(t, err)
+
+	err = streamCopy(ctx, &failingReaderBucket{Bucket: src}, CopyOptions{SourceKey: "key", DestinationKey: "key", DestinationBucket: dst})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "connection reset")
+
+	exists, err := dst.Exists(ctx, "key")
+	require.NoError(t, err)
+	assert.False(t, exists)
+}
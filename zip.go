@@ -0,0 +1,93 @@
+package pail
+
+import (
+	"archive/zip"
+	"context"
+	"io"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// GetZipReader returns a reader of a zip archive of the objects in the bucket
+// under the given prefix, which is treated as a directory, so "logs" archives
+// "logs/a.txt" but not "logs.txt". Each object is an entry in the archive at
+// its path relative to the prefix. The archive is produced as it is read, one object at
+// a time, so the entries are written in streaming mode, with their sizes and
+// checksums in data descriptors following their contents. Errors listing or
+// reading the objects are returned by Read. Closing the reader before reading
+// the whole archive stops producing it.
+func GetZipReader(ctx context.Context, b Bucket, prefix string) (io.ReadCloser, error) {
+	iter, err := b.List(ctx, prefix)
+	if err != nil {
+		return nil, errors.Wrap(err, "listing bucket")
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	pr, pw := io.Pipe()
+	go func() {
+		defer cancel()
+		_ = pw.CloseWithError(writeZip(ctx, b, iter, prefix, pw))
+	}()
+
+	return &zipReadCloser{PipeReader: pr, cancel: cancel}, nil
+}
+
+// writeZip writes a zip archive of the iterator's objects to the writer.
+func writeZip(ctx context.Context, b Bucket, iter BucketIterator, prefix string, w io.Writer) error {
+	zw := zip.NewWriter(w)
+	for iter.Next(ctx) {
+		item := iter.Item()
+		if !underPrefix(item.Name(), prefix) {
+			continue
+		}
+		header := &zip.FileHeader{
+			Name:     relativeKey(item.Name(), prefix),
+			Method:   zip.Deflate,
+			Modified: itemLastModified(item),
+		}
+		entry, err := zw.CreateHeader(header)
+		if err != nil {
+			return errors.Wrapf(err, "adding archive entry for key '%s'", item.Name())
+		}
+
+		r, err := b.Get(ctx, item.Name())
+		if err != nil {
+			return errors.Wrapf(err, "getting key '%s'", item.Name())
+		}
+		_, err = io.Copy(entry, r)
+		_ = r.Close()
+		if err != nil {
+			return errors.Wrapf(err, "archiving key '%s'", item.Name())
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return errors.Wrap(err, "iterating bucket")
+	}
+
+	return errors.Wrap(zw.Close(), "finishing archive")
+}
+
+// relativeKey returns the slash-separated path of the object with the given
+// key relative to the given prefix, such as its path in an archive of the
+// prefix. If the key is the prefix itself, its base name is used.
+func relativeKey(key, prefix string) string {
+	name := strings.TrimLeft(strings.TrimPrefix(filepath.ToSlash(key), filepath.ToSlash(prefix)), "/")
+	if name == "" {
+		return path.Base(filepath.ToSlash(key))
+	}
+	return name
+}
+
+// zipReadCloser stops producing the archive when it is closed.
+type zipReadCloser struct {
+	*io.PipeReader
+	cancel context.CancelFunc
+}
+
+func (r *zipReadCloser) Close() error {
+	r.cancel()
+	return r.PipeReader.Close()
+}